@@ -0,0 +1,234 @@
+package axon
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// AckConfig configures acknowledged writes on a Client. Messages written
+// with WriteAck are stamped with a sequence number and kept until the peer
+// acknowledges them; unacknowledged messages are written again after every
+// reconnect, giving at-least-once delivery.
+type AckConfig[T any] struct {
+	// Stamp returns msg carrying sequence number seq
+	Stamp func(msg T, seq uint64) T
+
+	// Ack extracts the acknowledged sequence number from a received
+	// message; ok is false for messages that are not acknowledgements.
+	// Acknowledgements are not delivered to OnMessage.
+	Ack func(msg T) (seq uint64, ok bool)
+
+	// Cumulative makes an acknowledgement cover every sequence number up
+	// to and including its own
+	Cumulative bool
+
+	// MaxPending limits unacknowledged messages; WriteAck returns
+	// ErrQueueFull beyond it. 0 means no limit.
+	MaxPending int
+}
+
+// Delivery tracks an acknowledged write
+type Delivery struct {
+	seq  uint64
+	done chan struct{}
+	once sync.Once
+	err  error
+}
+
+// Seq returns the message's sequence number
+func (d *Delivery) Seq() uint64 {
+	return d.seq
+}
+
+// Done returns a channel that is closed once the message is acknowledged
+// or has failed
+func (d *Delivery) Done() <-chan struct{} {
+	return d.done
+}
+
+// Err returns nil once the message is acknowledged, or the reason it will
+// not be. It blocks until Done is closed.
+func (d *Delivery) Err() error {
+	<-d.done
+	return d.err
+}
+
+// Wait blocks until the message is acknowledged, fails or ctx is done
+func (d *Delivery) Wait(ctx context.Context) error {
+	select {
+	case <-d.done:
+		return d.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// complete resolves the delivery with err
+func (d *Delivery) complete(err error) {
+	d.once.Do(func() {
+		d.err = err
+		close(d.done)
+	})
+}
+
+// pendingAck is a message awaiting acknowledgement
+type pendingAck[T any] struct {
+	msg      T
+	delivery *Delivery
+}
+
+// SetAcks configures acknowledged writes
+func (c *Client[T]) SetAcks(cfg AckConfig[T]) {
+	c.acksMu.Lock()
+	c.acks = cfg
+	c.acksMu.Unlock()
+}
+
+// WriteAck stamps msg with the next sequence number and writes it, or
+// leaves it to be written on reconnect while the client is reconnecting.
+// The returned Delivery completes when the peer acknowledges the message.
+// Acknowledgements are read by the read loop, so the client must be
+// connected with ConnectWithReadLoop.
+func (c *Client[T]) WriteAck(ctx context.Context, msg T) (*Delivery, error) {
+	c.acksMu.Lock()
+	if c.acks.Stamp == nil || c.acks.Ack == nil {
+		c.acksMu.Unlock()
+		return nil, ErrNoAckConfig
+	}
+	if c.acks.MaxPending > 0 && len(c.pendingAcks) >= c.acks.MaxPending {
+		c.acksMu.Unlock()
+		return nil, ErrQueueFull
+	}
+	state := c.state.State()
+	if state != StateConnected && state != StateConnecting && state != StateReconnecting {
+		c.acksMu.Unlock()
+		return nil, ErrConnectionClosed
+	}
+
+	c.ackSeq++
+	d := &Delivery{seq: c.ackSeq, done: make(chan struct{})}
+	msg = c.acks.Stamp(msg, d.seq)
+	if c.pendingAcks == nil {
+		c.pendingAcks = make(map[uint64]*pendingAck[T])
+	}
+	c.pendingAcks[d.seq] = &pendingAck[T]{msg: msg, delivery: d}
+	c.acksMu.Unlock()
+
+	if state != StateConnected {
+		return d, nil
+	}
+	if err := c.write(ctx, msg); err != nil && !c.reconnector.config.Enabled {
+		c.cancelAck(d.seq, err)
+		return nil, err
+	}
+	return d, nil
+}
+
+// WriteSync writes msg with WriteAck and waits for its acknowledgement. If
+// ctx is done first the message is no longer retried, though the peer may
+// still receive it.
+func (c *Client[T]) WriteSync(ctx context.Context, msg T) error {
+	d, err := c.WriteAck(ctx, msg)
+	if err != nil {
+		return err
+	}
+	if err := d.Wait(ctx); err != nil {
+		c.cancelAck(d.seq, err)
+		return err
+	}
+	return nil
+}
+
+// PendingAcks returns the number of messages awaiting acknowledgement
+func (c *Client[T]) PendingAcks() int {
+	c.acksMu.Lock()
+	defer c.acksMu.Unlock()
+	return len(c.pendingAcks)
+}
+
+// cancelAck stops retrying seq and fails its delivery with err
+func (c *Client[T]) cancelAck(seq uint64, err error) {
+	c.acksMu.Lock()
+	p, ok := c.pendingAcks[seq]
+	delete(c.pendingAcks, seq)
+	c.acksMu.Unlock()
+
+	if ok {
+		p.delivery.complete(err)
+	}
+}
+
+// resolveAck completes the deliveries acknowledged by msg, reporting
+// whether msg is an acknowledgement
+func (c *Client[T]) resolveAck(msg T) bool {
+	c.acksMu.Lock()
+	ack := c.acks.Ack
+	cumulative := c.acks.Cumulative
+	c.acksMu.Unlock()
+
+	if ack == nil {
+		return false
+	}
+	seq, ok := ack(msg)
+	if !ok {
+		return false
+	}
+
+	var acked []*Delivery
+	c.acksMu.Lock()
+	if cumulative {
+		for s, p := range c.pendingAcks {
+			if s <= seq {
+				acked = append(acked, p.delivery)
+				delete(c.pendingAcks, s)
+			}
+		}
+	} else if p, ok := c.pendingAcks[seq]; ok {
+		acked = append(acked, p.delivery)
+		delete(c.pendingAcks, seq)
+	}
+	c.acksMu.Unlock()
+
+	for _, d := range acked {
+		d.complete(nil)
+	}
+	return true
+}
+
+// resendAcks writes unacknowledged messages in sequence order on a new
+// connection
+func (c *Client[T]) resendAcks(ctx context.Context) {
+	c.acksMu.Lock()
+	seqs := make([]uint64, 0, len(c.pendingAcks))
+	for seq := range c.pendingAcks {
+		seqs = append(seqs, seq)
+	}
+	slices.Sort(seqs)
+	msgs := make([]T, 0, len(seqs))
+	for _, seq := range seqs {
+		msgs = append(msgs, c.pendingAcks[seq].msg)
+	}
+	c.acksMu.Unlock()
+
+	for _, msg := range msgs {
+		if err := c.write(ctx, msg); err != nil {
+			if c.onError != nil {
+				c.onError(err)
+			}
+			return
+		}
+	}
+}
+
+// failAcks fails every pending delivery with err
+func (c *Client[T]) failAcks(err error) {
+	c.acksMu.Lock()
+	pending := c.pendingAcks
+	c.pendingAcks = nil
+	c.acksMu.Unlock()
+
+	for _, p := range pending {
+		p.delivery.complete(err)
+	}
+}
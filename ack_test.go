@@ -0,0 +1,148 @@
+package axon_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+type ackMessage struct {
+	Seq  uint64 `json:"seq,omitempty"`
+	Ack  uint64 `json:"ack,omitempty"`
+	Data string `json:"data,omitempty"`
+}
+
+var ackConfig = axon.AckConfig[ackMessage]{
+	Stamp: func(m ackMessage, seq uint64) ackMessage {
+		m.Seq = seq
+		return m
+	},
+	Ack: func(m ackMessage) (uint64, bool) {
+		return m.Ack, m.Ack != 0
+	},
+}
+
+// startAckServer acknowledges every message it receives, except that the
+// first connection is dropped on its first message when dropFirst is set
+func startAckServer(t *testing.T, dropFirst bool) (string, <-chan ackMessage) {
+	t.Helper()
+	received := make(chan ackMessage, 16)
+	var conns atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[ackMessage](w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close(1000, "done")
+		n := conns.Add(1)
+
+		for {
+			msg, err := conn.Read(context.Background())
+			if err != nil {
+				return
+			}
+			received <- msg
+			if dropFirst && n == 1 {
+				conn.Close(int(axon.CloseGoingAway), "restarting")
+				return
+			}
+			conn.Write(context.Background(), ackMessage{Ack: msg.Seq})
+		}
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http"), received
+}
+
+func TestClient_WriteSync(t *testing.T) {
+	url, received := startAckServer(t, false)
+
+	opts := axon.DefaultClientOptions()
+	opts.Reconnect.Enabled = false
+	client := axon.NewClient[ackMessage](url, opts)
+	defer client.Close()
+	client.SetAcks(ackConfig)
+
+	messages := make(chan ackMessage, 4)
+	client.OnMessage(func(m ackMessage) {
+		messages <- m
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.ConnectWithReadLoop(ctx); err != nil {
+		t.Fatalf("ConnectWithReadLoop() error = %v", err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		if err := client.WriteSync(ctx, ackMessage{Data: "hello"}); err != nil {
+			t.Fatalf("WriteSync() error = %v", err)
+		}
+		if m := receive(t, received); m.Seq != uint64(i) {
+			t.Errorf("server got seq %d, want %d", m.Seq, i)
+		}
+	}
+	if n := client.PendingAcks(); n != 0 {
+		t.Errorf("PendingAcks() = %d, want 0", n)
+	}
+
+	// Acknowledgements are not delivered as messages
+	select {
+	case m := <-messages:
+		t.Errorf("OnMessage got %+v", m)
+	default:
+	}
+}
+
+func TestClient_WriteAckResendsAfterReconnect(t *testing.T) {
+	url, received := startAckServer(t, true)
+
+	opts := axon.DefaultClientOptions()
+	opts.Reconnect.InitialDelay = 10 * time.Millisecond
+	opts.Reconnect.Jitter = false
+	client := axon.NewClient[ackMessage](url, opts)
+	defer client.Close()
+	client.SetAcks(ackConfig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.ConnectWithReadLoop(ctx); err != nil {
+		t.Fatalf("ConnectWithReadLoop() error = %v", err)
+	}
+
+	d, err := client.WriteAck(ctx, ackMessage{Data: "important"})
+	if err != nil {
+		t.Fatalf("WriteAck() error = %v", err)
+	}
+	if err := d.Wait(ctx); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+
+	// Delivered once before the drop and again after reconnecting
+	for i := 0; i < 2; i++ {
+		if m := receive(t, received); m.Seq != d.Seq() || m.Data != "important" {
+			t.Errorf("server got %+v, want seq %d", m, d.Seq())
+		}
+	}
+}
+
+func TestClient_WriteAckErrors(t *testing.T) {
+	client := axon.NewClient[ackMessage]("ws://localhost:8080", nil)
+	defer client.Close()
+	ctx := context.Background()
+
+	if _, err := client.WriteAck(ctx, ackMessage{}); !errors.Is(err, axon.ErrNoAckConfig) {
+		t.Errorf("WriteAck() without AckConfig error = %v, want ErrNoAckConfig", err)
+	}
+
+	client.SetAcks(ackConfig)
+	if _, err := client.WriteAck(ctx, ackMessage{}); !errors.Is(err, axon.ErrConnectionClosed) {
+		t.Errorf("WriteAck() while disconnected error = %v, want ErrConnectionClosed", err)
+	}
+}
@@ -0,0 +1,141 @@
+package axon
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CloseAuthExpired closes connections whose credentials expired. A Client
+// treats it as recoverable, so it reconnects with a fresh token from its
+// Authenticator.
+const CloseAuthExpired CloseCode = 4001
+
+// MetaPrincipal is the Conn metadata key holding the principal returned by
+// the upgrader's Authenticator
+const MetaPrincipal = "axon.principal"
+
+// Authenticator authenticates connections with bearer tokens. Dialers call
+// Token before every dial attempt, and upgraders call Authenticate with the
+// token of every upgrade request. A process that only dials or only serves
+// implements just the method it uses; see AuthFuncs.
+type Authenticator interface {
+	// Token returns the token to present on the next handshake, refreshing
+	// it first if it is about to expire
+	Token(ctx context.Context) (string, error)
+
+	// Authenticate validates a token and returns the principal it
+	// identifies and when it expires. The zero time means it never does.
+	Authenticate(r *http.Request, token string) (principal any, expires time.Time, err error)
+}
+
+// AuthFuncs adapts functions to an Authenticator. Methods whose function
+// is nil fail with ErrUnauthorized.
+type AuthFuncs struct {
+	TokenFunc        func(ctx context.Context) (string, error)
+	AuthenticateFunc func(r *http.Request, token string) (any, time.Time, error)
+}
+
+// Token calls TokenFunc
+func (f AuthFuncs) Token(ctx context.Context) (string, error) {
+	if f.TokenFunc == nil {
+		return "", ErrUnauthorized
+	}
+	return f.TokenFunc(ctx)
+}
+
+// Authenticate calls AuthenticateFunc
+func (f AuthFuncs) Authenticate(r *http.Request, token string) (any, time.Time, error) {
+	if f.AuthenticateFunc == nil {
+		return nil, time.Time{}, ErrUnauthorized
+	}
+	return f.AuthenticateFunc(r, token)
+}
+
+// principalKey is the context key of an authenticated request's auth
+type principalKey struct{}
+
+// auth is the outcome of authenticating an upgrade request
+type auth struct {
+	principal any
+	expires   time.Time
+}
+
+// PrincipalFromContext returns the principal of an authenticated
+// connection's context, as seen by upgrade interceptors and Conn.Context
+func PrincipalFromContext(ctx context.Context) (any, bool) {
+	a, ok := ctx.Value(principalKey{}).(*auth)
+	if !ok {
+		return nil, false
+	}
+	return a.principal, true
+}
+
+// bearerToken returns the token of the Authorization header or, since
+// browsers cannot set headers on WebSocket requests, the access_token query
+// parameter (RFC 6750 Section 2)
+func bearerToken(r *http.Request) string {
+	if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return r.URL.Query().Get("access_token")
+}
+
+// authenticate validates the request's token, returning a context carrying
+// its principal. On failure it sets WWW-Authenticate on header.
+func (u *Upgrader) authenticate(header http.Header, r *http.Request) (context.Context, error) {
+	token := bearerToken(r)
+	if token == "" {
+		header.Set("WWW-Authenticate", "Bearer")
+		return nil, ErrUnauthorized
+	}
+	principal, expires, err := u.authenticator.Authenticate(r, token)
+	if err != nil {
+		header.Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		return nil, fmt.Errorf("%w: %w", ErrUnauthorized, err)
+	}
+	return context.WithValue(r.Context(), principalKey{}, &auth{principal: principal, expires: expires}), nil
+}
+
+// startAuthExpiry records the principal of an authenticated connection and
+// closes it with CloseAuthExpired once its credentials expire
+func (c *Conn[T]) startAuthExpiry() {
+	a, ok := c.Context().Value(principalKey{}).(*auth)
+	if !ok {
+		return
+	}
+	c.Set(MetaPrincipal, a.principal)
+	if a.expires.IsZero() {
+		return
+	}
+	expire := func() {
+		c.logger().Info("closing connection with expired credentials")
+		c.Close(int(CloseAuthExpired), "authentication expired")
+	}
+	if d := a.expires.Sub(c.clock().Now()); d > 0 {
+		c.authTimer = c.clock().AfterFunc(d, expire)
+	} else {
+		expire()
+	}
+}
+
+// authorize returns a dialer whose handshake carries a fresh token from
+// the Authenticator, or d itself if it has none
+func (d *Dialer) authorize(ctx context.Context) (*Dialer, error) {
+	if d.opts.Authenticator == nil {
+		return d, nil
+	}
+	token, err := d.opts.Authenticator.Token(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnauthorized, err)
+	}
+	opts := *d.opts
+	opts.Headers = opts.Headers.Clone()
+	if opts.Headers == nil {
+		opts.Headers = make(http.Header)
+	}
+	opts.Headers.Set("Authorization", "Bearer "+token)
+	return &Dialer{opts: &opts}, nil
+}
@@ -0,0 +1,130 @@
+package axon_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+// newAuthServer serves connections authenticated by tokens of the form
+// "user:ttl", reporting each connection's principal on the returned channel
+func newAuthServer(t *testing.T) (string, chan any) {
+	t.Helper()
+
+	principals := make(chan any, 4)
+	opts := &axon.UpgradeOptions{
+		Authenticator: axon.AuthFuncs{
+			AuthenticateFunc: func(r *http.Request, token string) (any, time.Time, error) {
+				user, ttl, ok := strings.Cut(token, ":")
+				if !ok {
+					return nil, time.Time{}, errors.New("malformed token")
+				}
+				d, err := time.ParseDuration(ttl)
+				if err != nil {
+					return nil, time.Time{}, err
+				}
+				return user, time.Now().Add(d), nil
+			},
+		},
+	}
+	server := httptest.NewServer(axon.Handler(opts, func(ctx context.Context, conn *axon.Conn[string]) {
+		principal, _ := conn.Get(axon.MetaPrincipal)
+		if p, ok := axon.PrincipalFromContext(conn.Context()); !ok || p != principal {
+			principal = fmt.Sprintf("context principal %v", p)
+		}
+		principals <- principal
+		for {
+			if _, err := conn.Read(ctx); err != nil && conn.IsClosed() {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http"), principals
+}
+
+func TestAuthenticator_Rejects(t *testing.T) {
+	url, _ := newAuthServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	for _, token := range []string{"", "malformed"} {
+		var opts axon.DialOptions
+		if token != "" {
+			opts.Authenticator = axon.AuthFuncs{TokenFunc: func(context.Context) (string, error) { return token, nil }}
+		}
+		_, err := axon.Dial[string](ctx, url, &opts)
+		var hsErr *axon.HandshakeError
+		if !errors.As(err, &hsErr) || hsErr.StatusCode() != http.StatusUnauthorized {
+			t.Fatalf("Dial() with token %q error = %v, want 401", token, err)
+		}
+		if !strings.HasPrefix(hsErr.Response.Header.Get("WWW-Authenticate"), "Bearer") {
+			t.Errorf("WWW-Authenticate = %q", hsErr.Response.Header.Get("WWW-Authenticate"))
+		}
+	}
+
+	// Token failures stop the dial
+	failing := axon.AuthFuncs{TokenFunc: func(context.Context) (string, error) { return "", errors.New("no refresh token") }}
+	if _, err := axon.Dial[string](ctx, url, &axon.DialOptions{Authenticator: failing}); !errors.Is(err, axon.ErrUnauthorized) {
+		t.Errorf("Dial() error = %v, want ErrUnauthorized", err)
+	}
+}
+
+func TestAuthenticator_Expiry(t *testing.T) {
+	url, principals := newAuthServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// The token travels in the query string too, for browsers
+	conn, err := axon.Dial[string](ctx, url+"?access_token=alice:100ms", nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close(1000, "")
+	if p := <-principals; p != "alice" {
+		t.Errorf("principal = %v, want alice", p)
+	}
+
+	_, err = conn.Read(ctx)
+	closeErr := axon.AsCloseError(err)
+	if closeErr == nil || closeErr.Code != axon.CloseAuthExpired {
+		t.Fatalf("Read() error = %v, want CloseAuthExpired", err)
+	}
+}
+
+func TestClient_Reauthenticates(t *testing.T) {
+	url, principals := newAuthServer(t)
+
+	// Each token outlives the connection it opens only briefly
+	var tokens atomic.Int32
+	opts := axon.DefaultClientOptions()
+	opts.Authenticator = axon.AuthFuncs{TokenFunc: func(context.Context) (string, error) {
+		return fmt.Sprintf("user%d:100ms", tokens.Add(1)), nil
+	}}
+	opts.Reconnect.InitialDelay = 10 * time.Millisecond
+	opts.Reconnect.Jitter = false
+	client := axon.NewClient[string](url, opts)
+	defer client.Close()
+
+	if err := client.ConnectWithReadLoop(context.Background()); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	for _, want := range []string{"user1", "user2"} {
+		select {
+		case p := <-principals:
+			if p != want {
+				t.Errorf("principal = %v, want %s", p, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no connection as %s", want)
+		}
+	}
+}
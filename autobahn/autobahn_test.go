@@ -0,0 +1,145 @@
+//go:build autobahn
+
+// Package autobahn runs axon against the Autobahn|Testsuite fuzzing client.
+//
+// The suite runs in Docker and connects back to an echo server started by
+// the test, so it must be run on a host where the container can reach
+// 127.0.0.1 through host networking:
+//
+//	go test -tags autobahn -v ./autobahn
+//
+// Each Autobahn case is reported as a subtest, and the full HTML/JSON report
+// is written to autobahn/reports.
+package autobahn_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+const (
+	serverAddr = "127.0.0.1:9001"
+	agentName  = "axon"
+	image      = "crossbario/autobahn-testsuite"
+)
+
+// echo reflects every message back to the peer, preserving its type
+func echo(w http.ResponseWriter, r *http.Request) {
+	conn, err := axon.Upgrade[[]byte](w, r, &axon.UpgradeOptions{
+		MaxFrameSize:   16 << 20,
+		MaxMessageSize: 16 << 20,
+		ReadDeadline:   time.Minute,
+		WriteDeadline:  time.Minute,
+	})
+	if err != nil {
+		return
+	}
+	defer conn.Close(1000, "")
+
+	ctx := context.Background()
+	for {
+		mt, data, err := conn.ReadMessage(ctx)
+		if err != nil {
+			return
+		}
+		if err := conn.WriteMessage(ctx, mt, data); err != nil {
+			return
+		}
+	}
+}
+
+// caseResult is a single entry of the Autobahn index.json report
+type caseResult struct {
+	Behavior      string `json:"behavior"`
+	BehaviorClose string `json:"behaviorClose"`
+	Duration      int    `json:"duration"`
+	ReportFile    string `json:"reportfile"`
+}
+
+// passed reports whether Autobahn considers the outcome acceptable
+func passed(behavior string) bool {
+	switch behavior {
+	case "OK", "NON-STRICT", "INFORMATIONAL", "UNIMPLEMENTED":
+		return true
+	}
+	return false
+}
+
+func TestAutobahn(t *testing.T) {
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("docker is required to run the Autobahn suite")
+	}
+
+	ln, err := net.Listen("tcp", serverAddr)
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(echo)}
+	go server.Serve(ln)
+	defer server.Close()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reports := filepath.Join(wd, "reports")
+	if err := os.MkdirAll(reports, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command("docker", "run", "--rm", "--network", "host",
+		"-v", filepath.Join(wd, "config")+":/config",
+		"-v", reports+":/reports",
+		image, "wstest", "-m", "fuzzingclient", "-s", "/config/fuzzingclient.json")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			t.Fatalf("failed to run autobahn: %v", err)
+		}
+		t.Logf("wstest exited with %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(reports, "servers", "index.json"))
+	if err != nil {
+		t.Fatalf("failed to read report: %v", err)
+	}
+
+	var index map[string]map[string]caseResult
+	if err := json.Unmarshal(data, &index); err != nil {
+		t.Fatalf("failed to parse report: %v", err)
+	}
+
+	results, ok := index[agentName]
+	if !ok {
+		t.Fatalf("no results for agent %q", agentName)
+	}
+
+	ids := make([]string, 0, len(results))
+	for id := range results {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		result := results[id]
+		t.Run(id, func(t *testing.T) {
+			if !passed(result.Behavior) || !passed(result.BehaviorClose) {
+				t.Errorf("behavior=%s close=%s (see reports/servers/%s)",
+					result.Behavior, result.BehaviorClose, result.ReportFile)
+			}
+		})
+	}
+}
@@ -1,10 +1,19 @@
 package axon
 
 import (
+	"bufio"
+	"context"
 	"crypto/sha1"
+	"crypto/x509"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -15,26 +24,63 @@ const (
 
 // Upgrader handles WebSocket connection upgrades
 type Upgrader struct {
-	readBufferSize    int
-	writeBufferSize   int
-	maxFrameSize      int
-	maxMessageSize    int
-	readDeadline      time.Duration
-	writeDeadline     time.Duration
-	pingInterval      time.Duration
-	pongTimeout       time.Duration
-	checkOrigin       func(r *http.Request) bool
-	subprotocols      []string
-	enableCompression bool
+	readBufferSize        int
+	writeBufferSize       int
+	noBufferPool          bool
+	maxFrameSize          int
+	maxMessageSize        int
+	lenientProtocol       bool
+	readDeadline          time.Duration
+	writeDeadline         time.Duration
+	handshakeTimeout      time.Duration
+	maxHeaderBytes        int
+	pingInterval          time.Duration
+	pongTimeout           time.Duration
+	extendDeadline        DeadlineExtension
+	clock                 Clock
+	checkOrigin           func(r *http.Request) bool
+	upgradeError          func(w http.ResponseWriter, r *http.Request, status int, err error)
+	noErrorResponse       bool
+	subprotocols          []string
+	preferServerProtocols bool
+	selectProtocol        func(requested []string) string
+	enableCompression     bool
+	compression           compressionConfig
+	deflatePrefs          deflateParams
+	extensions            []ExtensionPlugin
+	interceptors          []UpgradeInterceptor
+	baseContext           func(r *http.Request) context.Context
+	limits                connLimits
+	rateLimiter           RateLimiter
+	metrics               *Metrics
+	sendQueueSize         int
+	overflowPolicy        OverflowPolicy
+	enableHTTP2           bool
+	idleTimeout           time.Duration
+	writeStall            time.Duration
+	trustedProxies        []netip.Prefix
+	authenticator         Authenticator
+	bans                  BanStore
+	payloadCipher         PayloadCipher
+	faults                *Faults
+	verifyPeerCertificate func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error
+	fallback              *fallbackSessions
+	logger                *slog.Logger
+	trace                 TraceFunc
 }
 
 // NewUpgrader creates a new Upgrader with default settings
 func NewUpgrader(opts *UpgradeOptions) *Upgrader {
 	u := &Upgrader{
-		readBufferSize:  4096,
-		writeBufferSize: 4096,
-		maxFrameSize:    4096,
-		maxMessageSize:  1048576, // 1MB
+		readBufferSize:   4096,
+		writeBufferSize:  4096,
+		maxFrameSize:     4096,
+		maxMessageSize:   1048576, // 1MB
+		handshakeTimeout: defaultHandshakeTimeout,
+		maxHeaderBytes:   http.DefaultMaxHeaderBytes,
+		compression:      newCompressionConfig(0, 0, CompressionPerConnection),
+		limits:           connLimits{retryAfter: time.Second},
+		logger:           discardLogger,
 	}
 
 	if opts != nil {
@@ -44,48 +90,347 @@ func NewUpgrader(opts *UpgradeOptions) *Upgrader {
 		if opts.WriteBufferSize > 0 {
 			u.writeBufferSize = opts.WriteBufferSize
 		}
+		u.noBufferPool = opts.DisableBufferPool
 		if opts.MaxFrameSize > 0 {
 			u.maxFrameSize = opts.MaxFrameSize
 		}
 		if opts.MaxMessageSize > 0 {
 			u.maxMessageSize = opts.MaxMessageSize
 		}
+		u.lenientProtocol = opts.LenientProtocol
 		u.readDeadline = opts.ReadDeadline
 		u.writeDeadline = opts.WriteDeadline
+		if opts.HandshakeTimeout > 0 {
+			u.handshakeTimeout = opts.HandshakeTimeout
+		}
+		if opts.MaxHeaderBytes > 0 {
+			u.maxHeaderBytes = opts.MaxHeaderBytes
+		}
 		u.pingInterval = opts.PingInterval
 		u.pongTimeout = opts.PongTimeout
+		u.extendDeadline = opts.ExtendReadDeadline
+		u.clock = opts.Clock
 		u.checkOrigin = opts.CheckOrigin
+		u.upgradeError = opts.UpgradeError
+		u.noErrorResponse = opts.NoErrorResponse
 		u.subprotocols = opts.Subprotocols
+		u.preferServerProtocols = opts.PreferServerSubprotocols
+		u.selectProtocol = opts.SelectSubprotocol
 		u.enableCompression = opts.Compression
+		u.compression = newCompressionConfig(opts.CompressionThreshold, opts.CompressionLevel, opts.CompressionStrategy)
+		u.deflatePrefs = deflateParams{
+			serverNoContextTakeover: opts.ServerNoContextTakeover || opts.CompressionStrategy == CompressionPooled,
+			clientNoContextTakeover: opts.ClientNoContextTakeover,
+		}
+		if _, ok := parseWindowBits(strconv.Itoa(opts.ClientMaxWindowBits)); ok {
+			u.deflatePrefs.clientMaxWindowBits = opts.ClientMaxWindowBits
+		}
+		u.extensions = opts.Extensions
+		u.interceptors = opts.Interceptors
+		u.baseContext = opts.BaseContext
+		u.limits.maxConnections = opts.MaxConnections
+		u.limits.maxPerIP = opts.MaxConnectionsPerIP
+		if opts.RetryAfter > 0 {
+			u.limits.retryAfter = opts.RetryAfter
+		}
+		u.rateLimiter = opts.RateLimiter
+		u.metrics = opts.Metrics
+		u.sendQueueSize = opts.SendQueueSize
+		u.overflowPolicy = opts.OverflowPolicy
+		u.enableHTTP2 = opts.EnableHTTP2
+		u.idleTimeout = opts.IdleTimeout
+		u.trustedProxies = opts.TrustedProxies
+		u.authenticator = opts.Authenticator
+		u.bans = opts.Bans
+		u.payloadCipher = opts.PayloadCipher
+		u.faults = opts.Faults
+		u.verifyPeerCertificate = opts.VerifyPeerCertificate
+		if opts.SlowClientPolicy != nil {
+			u.writeStall = opts.SlowClientPolicy.WriteStall
+		}
+		if opts.HTTPFallback {
+			u.fallback = newFallbackSessions()
+		}
+		u.logger = loggerOr(opts.Logger)
+		u.trace = opts.Trace
 	}
 
 	return u
 }
 
-// Upgrade upgrades an HTTP connection to a WebSocket connection
+// Upgrade upgrades an HTTP connection to a WebSocket connection. Headers
+// set on w beforehand, such as cookies, are sent with the 101 response.
+// Rejected requests are answered with the status UpgradeErrorStatus maps
+// the error to, unless NoErrorResponse is set.
 func Upgrade[T any](w http.ResponseWriter, r *http.Request, opts *UpgradeOptions) (*Conn[T], error) {
 	u := NewUpgrader(opts)
 	return upgrade[T](u, w, r)
 }
 
+// UpgradeWith upgrades an HTTP connection using a shared Upgrader, so that
+// state such as connection limits is tracked across upgrades
+func UpgradeWith[T any](u *Upgrader, w http.ResponseWriter, r *http.Request) (*Conn[T], error) {
+	return upgrade[T](u, w, r)
+}
+
 // upgrade performs the actual upgrade logic
-func upgrade[T any](u *Upgrader, w http.ResponseWriter, r *http.Request) (*Conn[T], error) {
-	if r.Method != http.MethodGet {
-		return nil, ErrUpgradeRequired
+func upgrade[T any](u *Upgrader, w http.ResponseWriter, r *http.Request) (upgraded *Conn[T], err error) {
+	// Once the response is under way, errors can no longer be answered
+	responding := false
+	defer func() {
+		if err != nil {
+			u.logger.Info("upgrade rejected", "remote_addr", r.RemoteAddr, "error", err)
+			u.recordHandshakeError(err)
+			if !responding {
+				u.writeError(w, r, err)
+			}
+			return
+		}
+		upgraded.logger().Debug("connection upgraded", "remote_addr", r.RemoteAddr)
+	}()
+
+	hs, err := u.negotiate(w.Header(), r)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := u.admit(w.Header(), r)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, err := u.before(w.Header(), r)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	if hs.http2 {
+		responding = true
+		stream, err := acceptHTTP2(w, r, hs)
+		if err != nil {
+			release()
+			return nil, fmt.Errorf("axon: failed to accept stream: %w", err)
+		}
+		hs.release = release
+		// The stream ends with the handler, so it keeps r's cancellation
+		wsConn := newServerConn[T](u.connContext(r, ctx), u, stream, u.newReader(stream), hs)
+		if err := u.after(wsConn); err != nil {
+			return nil, err
+		}
+		return wsConn, nil
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		release()
+		return nil, ErrInvalidHandshake
+	}
+
+	responding = true
+	conn, bufrw, err := hj.Hijack()
+	if err != nil {
+		release()
+		return nil, fmt.Errorf("axon: failed to hijack connection: %w", err)
+	}
+
+	// The server's own deadlines no longer apply to a hijacked connection
+	if err := conn.SetDeadline(time.Now().Add(u.handshakeTimeout)); err != nil {
+		release()
+		conn.Close()
+		return nil, fmt.Errorf("axon: failed to set handshake deadline: %w", err)
+	}
+
+	if _, err := bufrw.WriteString(hs.response(w.Header())); err != nil {
+		release()
+		conn.Close()
+		return nil, fmt.Errorf("axon: failed to write response: %w", err)
+	}
+
+	if err := bufrw.Flush(); err != nil {
+		release()
+		conn.Close()
+		return nil, fmt.Errorf("axon: failed to flush response: %w", err)
+	}
+	conn.SetDeadline(time.Time{})
+
+	hs.release = release
+	wsConn := newServerConn[T](u.connContext(r, context.WithoutCancel(ctx)), u, conn, u.hijackedReader(conn, bufrw.Reader), hs)
+	if err := u.after(wsConn); err != nil {
+		return nil, err
+	}
+
+	return wsConn, nil
+}
+
+// writeError answers a rejected upgrade request with the status of err, if
+// it has one
+func (u *Upgrader) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	status := UpgradeErrorStatus(err)
+	if status == 0 || u.noErrorResponse {
+		return
+	}
+	if u.upgradeError != nil {
+		u.upgradeError(w, r, status, err)
+		return
+	}
+	http.Error(w, http.StatusText(status), status)
+}
+
+// admit applies the rate limiter, ban list and connection limits to the
+// request, returning a function that frees its connection slot
+func (u *Upgrader) admit(header http.Header, r *http.Request) (func(), error) {
+	ip := u.clientKey(r)
+	if u.rateLimiter != nil {
+		// KeyByIP finds the client IP in the context
+		ctx := context.WithValue(r.Context(), clientIPKey{}, ip)
+		if ok, wait := u.rateLimiter.Allow(r.WithContext(ctx)); !ok {
+			setRetryAfter(header, wait)
+			return nil, ErrRateLimited
+		}
+	}
+
+	if u.bans != nil {
+		if err := u.checkBans(r, ip); err != nil {
+			return nil, err
+		}
+	}
+
+	release, err := u.limits.acquire(header, ip)
+	if err != nil {
+		return nil, err
+	}
+	return release, nil
+}
+
+// recordHandshakeError counts a failed upgrade, distinguishing those
+// rejected by a limit
+func (u *Upgrader) recordHandshakeError(err error) {
+	switch {
+	case u.metrics == nil:
+	case errors.Is(err, ErrRateLimited) || errors.Is(err, ErrTooManyConnections) || errors.Is(err, ErrBanned):
+		u.metrics.RecordRejectedHandshake()
+	default:
+		u.metrics.RecordHandshakeError()
+	}
+}
+
+// before checks the client certificate and token of the request and
+// whether its principal is banned, then runs the Before interceptors and
+// returns the connection's context
+func (u *Upgrader) before(header http.Header, r *http.Request) (context.Context, error) {
+	ctx := r.Context()
+	if u.verifyPeerCertificate != nil {
+		if err := u.verifyPeer(r); err != nil {
+			return nil, err
+		}
+	}
+	if u.authenticator != nil {
+		var err error
+		if ctx, err = u.authenticate(header, r); err != nil {
+			return nil, err
+		}
+		r = r.WithContext(ctx)
+		if u.bans != nil {
+			principal, _ := PrincipalFromContext(ctx)
+			if err := u.checkBans(r, banKeys(netip.Addr{}, principal)...); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, ic := range u.interceptors {
+		if ic.Before == nil {
+			continue
+		}
+		var err error
+		if ctx, err = ic.Before(r); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrUpgradeRejected, err)
+		}
+		r = r.WithContext(ctx)
+	}
+	return ctx, nil
+}
+
+// connContext returns the parent of the context of a connection upgraded
+// from r, given the context carrying the values added during the upgrade
+func (u *Upgrader) connContext(r *http.Request, ctx context.Context) context.Context {
+	if u.baseContext == nil {
+		return ctx
+	}
+	return valuesContext{Context: u.baseContext(r), values: context.WithoutCancel(ctx)}
+}
+
+// valuesContext is canceled with its Context, but looks values up in
+// values first. values must not be cancelable, so that context.WithCancel
+// finds the Context's cancellation rather than starting a goroutine.
+type valuesContext struct {
+	context.Context
+	values context.Context
+}
+
+func (c valuesContext) Value(key any) any {
+	if v := c.values.Value(key); v != nil {
+		return v
 	}
+	return c.Context.Value(key)
+}
 
-	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
-		return nil, ErrUpgradeRequired
+// after runs the After interceptors, closing the connection if one fails
+func (u *Upgrader) after(conn RegisteredConn) error {
+	for _, ic := range u.interceptors {
+		if ic.After == nil {
+			continue
+		}
+		if err := ic.After(conn.Context(), conn); err != nil {
+			// The handshake already succeeded, so this is not reported as
+			// ErrUpgradeRejected, which is answered with an HTTP status
+			conn.Close(int(ClosePolicyViolation), "")
+			return fmt.Errorf("axon: connection rejected after upgrade: %w", err)
+		}
 	}
+	return nil
+}
+
+// handshake holds the negotiated parameters of an accepted upgrade request
+type handshake struct {
+	acceptKey   string
+	subprotocol string
+	compression bool
+	deflate     deflateParams
+	extensions  []activeExtension
+	http2       bool // Bootstrapped with an HTTP/2 extended CONNECT
+	clientIP    netip.Addr
+	clientTLS   bool
+	release     func() // Frees the limit slot taken by admit
+}
 
-	connection := r.Header.Get("Connection")
-	if !strings.Contains(strings.ToLower(connection), "upgrade") {
-		return nil, ErrUpgradeRequired
+// negotiate validates an upgrade request and selects the subprotocol and
+// extensions to accept. Headers for an error response are added to header.
+func (u *Upgrader) negotiate(header http.Header, r *http.Request) (*handshake, error) {
+	if headerBytes(r.Header) > u.maxHeaderBytes {
+		return nil, ErrHeaderTooLarge
 	}
 
+	h2 := isExtendedConnect(r)
+	if h2 {
+		if !u.enableHTTP2 || r.Header.Get(":protocol") != "websocket" {
+			return nil, ErrUpgradeRequired
+		}
+	} else {
+		if r.Method != http.MethodGet {
+			return nil, ErrUpgradeRequired
+		}
+		if err := checkUpgradeTokens(r.Header); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(r.Header.Values("Sec-WebSocket-Version")) > 1 {
+		return nil, fmt.Errorf("%w: repeated Sec-WebSocket-Version", ErrInvalidHandshake)
+	}
 	version := r.Header.Get("Sec-WebSocket-Version")
 	if version != "13" {
-		w.Header().Set("Sec-WebSocket-Version", "13")
+		header.Set("Sec-WebSocket-Version", "13")
 		return nil, ErrInvalidHandshake
 	}
 
@@ -93,88 +438,260 @@ func upgrade[T any](u *Upgrader, w http.ResponseWriter, r *http.Request) (*Conn[
 		return nil, ErrInvalidOrigin
 	}
 
+	// RFC 8441 drops the key exchange, since the stream can't be confused
+	// with a plain HTTP response
 	key := r.Header.Get("Sec-WebSocket-Key")
-	if key == "" {
+	if key == "" && !h2 {
 		return nil, ErrInvalidHandshake
 	}
+	if !h2 && (len(r.Header.Values("Sec-WebSocket-Key")) > 1 || !validKey(key)) {
+		return nil, fmt.Errorf("%w: malformed Sec-WebSocket-Key", ErrInvalidHandshake)
+	}
 
-	requestedSubprotocol := r.Header.Get("Sec-WebSocket-Protocol")
-	selectedSubprotocol := ""
-	if requestedSubprotocol != "" && len(u.subprotocols) > 0 {
-		requested := strings.Split(requestedSubprotocol, ",")
-		for _, req := range requested {
-			req = strings.TrimSpace(req)
-			for _, supported := range u.subprotocols {
-				if req == supported {
-					selectedSubprotocol = supported
-					break
-				}
-			}
-			if selectedSubprotocol != "" {
-				break
+	selectedSubprotocol, err := u.selectSubprotocol(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var extensions []Extension
+	if u.enableCompression || len(u.extensions) > 0 {
+		if extensions, err = ParseExtensions(r.Header.Values("Sec-WebSocket-Extensions")...); err != nil {
+			return nil, err
+		}
+	}
+
+	// Accept the first permessage-deflate offer we can honor
+	var deflate deflateParams
+	var reserved byte
+	compressionEnabled := false
+	if u.enableCompression {
+		if offers := parseDeflateOffers(extensions); len(offers) > 0 {
+			deflate = offers[0].withServerPrefs(u.deflatePrefs)
+			compressionEnabled = true
+			reserved = rsv1Mask
+		}
+	}
+
+	hs := &handshake{
+		subprotocol: selectedSubprotocol,
+		compression: compressionEnabled,
+		deflate:     deflate,
+		extensions:  acceptExtensions(u.extensions, extensions, reserved),
+		http2:       h2,
+		clientIP:    ClientIP(r, u.trustedProxies),
+		clientTLS:   ClientTLS(r, u.trustedProxies),
+	}
+	if !h2 {
+		hs.acceptKey = computeAcceptKey(key)
+	}
+	return hs, nil
+}
+
+// checkUpgradeTokens checks that the Upgrade header asks for websocket alone
+// and that the Connection header asks to upgrade without also asking to
+// close. Either header may be split across several lines.
+func checkUpgradeTokens(h http.Header) error {
+	upgrade := headerTokens(h, "Upgrade")
+	if !slices.Contains(upgrade, "websocket") {
+		return ErrUpgradeRequired
+	}
+	for _, token := range upgrade {
+		if token != "websocket" {
+			return fmt.Errorf("%w: conflicting Upgrade protocol %q", ErrInvalidHandshake, token)
+		}
+	}
+
+	connection := headerTokens(h, "Connection")
+	if !slices.Contains(connection, "upgrade") {
+		return ErrUpgradeRequired
+	}
+	if slices.Contains(connection, "close") {
+		return fmt.Errorf("%w: Connection asks to both upgrade and close", ErrInvalidHandshake)
+	}
+	return nil
+}
+
+// headerTokens returns the lowercased comma-separated tokens of every
+// value of the header key
+func headerTokens(h http.Header, key string) []string {
+	var tokens []string
+	for _, value := range h.Values(key) {
+		for _, token := range strings.Split(value, ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				tokens = append(tokens, strings.ToLower(token))
 			}
 		}
-		if selectedSubprotocol == "" {
-			return nil, ErrInvalidSubprotocol
+	}
+	return tokens
+}
+
+// validKey reports whether key is the base64 encoding of 16 bytes, as RFC
+// 6455 Section 4.1 requires of Sec-WebSocket-Key
+func validKey(key string) bool {
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	return err == nil && len(decoded) == 16
+}
+
+// headerBytes returns the size of h as sent on the wire
+func headerBytes(h http.Header) int {
+	n := 0
+	for name, values := range h {
+		for _, value := range values {
+			n += len(name) + len(": ") + len(value) + len("\r\n")
 		}
 	}
+	return n
+}
 
-	acceptKey := computeAcceptKey(key)
+// selectSubprotocol returns the subprotocol requested by r to accept: the
+// choice of the SelectSubprotocol callback if there is one, and otherwise
+// the first supported in the client's or, if preferred, the server's order
+func (u *Upgrader) selectSubprotocol(r *http.Request) (string, error) {
+	var requested []string
+	for _, value := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, req := range strings.Split(value, ",") {
+			if req = strings.TrimSpace(req); req != "" {
+				requested = append(requested, req)
+			}
+		}
+	}
+	if len(requested) == 0 {
+		return "", nil
+	}
 
-	hj, ok := w.(http.Hijacker)
-	if !ok {
-		return nil, ErrInvalidHandshake
+	if u.selectProtocol != nil {
+		selected := u.selectProtocol(requested)
+		if selected != "" && !slices.Contains(requested, selected) {
+			return "", ErrInvalidSubprotocol
+		}
+		return selected, nil
 	}
 
-	conn, bufw, err := hj.Hijack()
-	if err != nil {
-		return nil, fmt.Errorf("axon: failed to hijack connection: %w", err)
+	if len(u.subprotocols) == 0 {
+		return "", nil
+	}
+	if u.preferServerProtocols {
+		for _, supported := range u.subprotocols {
+			if supported == "*" {
+				return requested[0], nil
+			}
+			if slices.Contains(requested, supported) {
+				return supported, nil
+			}
+		}
+		return "", ErrInvalidSubprotocol
 	}
+	for _, req := range requested {
+		if slices.Contains(u.subprotocols, req) || slices.Contains(u.subprotocols, "*") {
+			return req, nil
+		}
+	}
+	return "", ErrInvalidSubprotocol
+}
 
+// handshakeHeaders are the response headers owned by the handshake
+var handshakeHeaders = map[string]bool{
+	"Upgrade":                  true,
+	"Connection":               true,
+	"Sec-Websocket-Accept":     true,
+	"Sec-Websocket-Protocol":   true,
+	"Sec-Websocket-Extensions": true,
+}
+
+// response returns the 101 Switching Protocols response for the handshake,
+// including the headers in extra other than those of the handshake itself
+func (hs *handshake) response(extra http.Header) string {
 	response := fmt.Sprintf("HTTP/1.1 101 Switching Protocols\r\n"+
 		"Upgrade: websocket\r\n"+
 		"Connection: Upgrade\r\n"+
-		"Sec-WebSocket-Accept: %s\r\n", acceptKey)
+		"Sec-WebSocket-Accept: %s\r\n", hs.acceptKey)
 
-	if selectedSubprotocol != "" {
-		response += fmt.Sprintf("Sec-WebSocket-Protocol: %s\r\n", selectedSubprotocol)
+	if hs.subprotocol != "" {
+		response += fmt.Sprintf("Sec-WebSocket-Protocol: %s\r\n", hs.subprotocol)
 	}
 
-	response += "\r\n"
-
-	if _, err := bufw.WriteString(response); err != nil {
-		conn.Close()
-		return nil, fmt.Errorf("axon: failed to write response: %w", err)
+	if extensions := hs.extensionsHeader(); extensions != "" {
+		response += fmt.Sprintf("Sec-WebSocket-Extensions: %s\r\n", extensions)
 	}
 
-	if err := bufw.Flush(); err != nil {
-		conn.Close()
-		return nil, fmt.Errorf("axon: failed to flush response: %w", err)
+	if len(extra) > 0 {
+		var b strings.Builder
+		extra.WriteSubset(&b, handshakeHeaders)
+		response += b.String()
 	}
 
-	readBuf := getBuffer()
-	writeBuf := getBuffer()
-	reader := getReader(conn)
-	writer := getWriter(conn)
+	return response + "\r\n"
+}
+
+// extensionsHeader returns the Sec-WebSocket-Extensions value accepting
+// the negotiated extensions, or "" if there are none
+func (hs *handshake) extensionsHeader() string {
+	var extensions []Extension
+	if hs.compression {
+		extensions = append(extensions, hs.deflate.extension())
+	}
+	for _, ext := range hs.extensions {
+		extensions = append(extensions, ext.response)
+	}
+	return FormatExtensions(extensions...)
+}
 
+// newServerConn wraps an upgraded connection. reader must read from conn
+// and may already hold data sent after the handshake.
+func newServerConn[T any](ctx context.Context, u *Upgrader, conn net.Conn, reader *bufio.Reader, hs *handshake) *Conn[T] {
+	id := newConnID()
 	wsConn := &Conn[T]{
-		conn:          conn,
-		reader:        reader,
-		writer:        writer,
-		readBuf:       readBuf,
-		writeBuf:      writeBuf,
-		upgrader:      u,
-		readDeadline:  u.readDeadline,
-		writeDeadline: u.writeDeadline,
-		pingInterval:  u.pingInterval,
-		pongTimeout:   u.pongTimeout,
+		id:             id,
+		conn:           conn,
+		reader:         reader,
+		writer:         u.newWriter(conn),
+		readBuf:        u.newBuffer(u.readBufferSize),
+		writeBuf:       u.newBuffer(u.writeBufferSize),
+		upgrader:       u,
+		readDeadline:   u.readDeadline,
+		writeDeadline:  u.writeDeadline,
+		pingInterval:   u.pingInterval,
+		pongTimeout:    u.pongTimeout,
+		extendDeadline: u.extendDeadline,
+		clk:            u.clock,
+		extensions:     hs.extensions,
+		subprotocol:    hs.subprotocol,
+		release:        hs.release,
+		log:            connLogger(u.logger, id),
+		metrics:        u.metrics,
+		trace:          u.trace,
+		idleTimeout:    u.idleTimeout,
+		writeStall:     u.writeStall,
+		meta: map[string]any{
+			MetaClientIP:  hs.clientIP,
+			MetaClientTLS: hs.clientTLS,
+		},
+	}
+
+	wsConn.ctx, wsConn.cancel = context.WithCancel(ctx)
+
+	if hs.compression {
+		wsConn.compression = newCompressionManager(u.compression, hs.deflate, false)
+	}
+
+	registry.add(wsConn)
+	if u.metrics != nil {
+		u.metrics.RecordConnection()
 	}
 
 	if u.pingInterval > 0 {
 		wsConn.startPingLoop()
 	}
+	wsConn.startIdleTimer()
+	wsConn.startAuthExpiry()
+	if u.payloadCipher != nil {
+		wsConn.UseCipher(u.payloadCipher)
+	}
+	if u.faults != nil {
+		wsConn.InjectFaults(*u.faults)
+	}
 
-	return wsConn, nil
+	return wsConn
 }
 
 // computeAcceptKey computes the WebSocket accept key (RFC 6455 Section 4.2.2)
@@ -1,12 +1,19 @@
 package axon_test
 
 import (
+	"bufio"
+	"context"
 	"crypto/sha1"
 	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/kolosys/axon"
 )
@@ -128,6 +135,141 @@ func TestUpgradeMissingKey(t *testing.T) {
 	handler(w, req)
 }
 
+func TestUpgradeErrorResponse(t *testing.T) {
+	badVersion := func() *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		req.Header.Set("Sec-WebSocket-Version", "8")
+		return req
+	}
+
+	t.Run("Default", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		axon.Upgrade[string](w, badVersion(), nil)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status %d, got %d", http.StatusBadRequest, w.Code)
+		}
+		if got := w.Header().Get("Sec-WebSocket-Version"); got != "13" {
+			t.Errorf("expected Sec-WebSocket-Version 13 in the response, got %q", got)
+		}
+	})
+
+	t.Run("Custom", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		var gotErr error
+		axon.Upgrade[string](w, badVersion(), &axon.UpgradeOptions{
+			UpgradeError: func(w http.ResponseWriter, r *http.Request, status int, err error) {
+				gotErr = err
+				w.WriteHeader(status)
+				io.WriteString(w, "use version 13")
+			},
+		})
+		if w.Code != http.StatusBadRequest || w.Body.String() != "use version 13" {
+			t.Errorf("expected the custom response, got %d %q", w.Code, w.Body.String())
+		}
+		if gotErr != axon.ErrInvalidHandshake {
+			t.Errorf("expected ErrInvalidHandshake, got %v", gotErr)
+		}
+	})
+
+	t.Run("Suppressed", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		axon.Upgrade[string](w, badVersion(), &axon.UpgradeOptions{NoErrorResponse: true})
+		if w.Code != http.StatusOK || w.Body.Len() != 0 {
+			t.Errorf("expected nothing written, got %d %q", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("Origin", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := badVersion()
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		axon.Upgrade[string](w, req, &axon.UpgradeOptions{
+			CheckOrigin: func(r *http.Request) bool { return false },
+		})
+		if w.Code != http.StatusForbidden {
+			t.Errorf("expected status %d, got %d", http.StatusForbidden, w.Code)
+		}
+	})
+}
+
+func TestUpgradeRequestValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		opts   *axon.UpgradeOptions
+		err    error
+		status int
+	}{
+		{
+			name:   "ShortKey",
+			header: http.Header{"Sec-Websocket-Key": {"c2hvcnQ="}},
+			err:    axon.ErrInvalidHandshake,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "KeyNotBase64",
+			header: http.Header{"Sec-Websocket-Key": {"not a base64 key!!!!!!!!"}},
+			err:    axon.ErrInvalidHandshake,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "RepeatedKey",
+			header: http.Header{"Sec-Websocket-Key": {"dGhlIHNhbXBsZSBub25jZQ==", "AAAAAAAAAAAAAAAAAAAAAA=="}},
+			err:    axon.ErrInvalidHandshake,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "ConflictingUpgrade",
+			header: http.Header{"Upgrade": {"websocket", "h2c"}},
+			err:    axon.ErrInvalidHandshake,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "UpgradeAndClose",
+			header: http.Header{"Connection": {"Upgrade, close"}},
+			err:    axon.ErrInvalidHandshake,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "ConnectionWithoutUpgradeToken",
+			header: http.Header{"Connection": {"keep-alive, no-upgrade"}},
+			err:    axon.ErrUpgradeRequired,
+			status: http.StatusUpgradeRequired,
+		},
+		{
+			name:   "HeaderTooLarge",
+			header: http.Header{"Cookie": {strings.Repeat("a", 2048)}},
+			opts:   &axon.UpgradeOptions{MaxHeaderBytes: 1024},
+			err:    axon.ErrHeaderTooLarge,
+			status: http.StatusRequestHeaderFieldsTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Upgrade", "websocket")
+			req.Header.Set("Connection", "keep-alive, Upgrade")
+			req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+			req.Header.Set("Sec-WebSocket-Version", "13")
+			for name, values := range tt.header {
+				req.Header[name] = values
+			}
+
+			_, err := axon.Upgrade[string](httptest.NewRecorder(), req, tt.opts)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+			if status := axon.UpgradeErrorStatus(err); status != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, status)
+			}
+		})
+	}
+}
+
 func TestUpgradeWithOriginCheck(t *testing.T) {
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		_, err := axon.Upgrade[string](w, r, &axon.UpgradeOptions{
@@ -221,3 +363,327 @@ func TestUpgradeWithMultipleSubprotocols(t *testing.T) {
 	w := httptest.NewRecorder()
 	handler(w, req)
 }
+
+func TestUpgradeSubprotocolSelection(t *testing.T) {
+	tests := []struct {
+		name string
+		opts axon.UpgradeOptions
+		want string
+	}{
+		{
+			name: "ClientPreference",
+			opts: axon.UpgradeOptions{Subprotocols: []string{"v1", "v2"}},
+			want: "v2",
+		},
+		{
+			name: "ServerPreference",
+			opts: axon.UpgradeOptions{Subprotocols: []string{"v1", "v2"}, PreferServerSubprotocols: true},
+			want: "v1",
+		},
+		{
+			name: "Wildcard",
+			opts: axon.UpgradeOptions{Subprotocols: []string{"*"}},
+			want: "v2",
+		},
+		{
+			name: "WildcardAfterPreferred",
+			opts: axon.UpgradeOptions{Subprotocols: []string{"v1", "*"}, PreferServerSubprotocols: true},
+			want: "v1",
+		},
+		{
+			name: "Callback",
+			opts: axon.UpgradeOptions{
+				Subprotocols: []string{"v1"},
+				SelectSubprotocol: func(requested []string) string {
+					return requested[len(requested)-1]
+				},
+			},
+			want: "v3",
+		},
+		{
+			name: "CallbackAcceptsNone",
+			opts: axon.UpgradeOptions{SelectSubprotocol: func([]string) string { return "" }},
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server, err := axon.Pipe[string](
+				&axon.DialOptions{Subprotocols: []string{"v2", "v1", "v3"}}, &tt.opts)
+			if err != nil {
+				t.Fatalf("Pipe() error = %v", err)
+			}
+			defer client.Close(1000, "")
+			defer server.Close(1000, "")
+
+			if server.Subprotocol() != tt.want || client.Subprotocol() != tt.want {
+				t.Errorf("subprotocols = %q, %q, want %q", server.Subprotocol(), client.Subprotocol(), tt.want)
+			}
+		})
+	}
+
+	t.Run("CallbackUnrequested", func(t *testing.T) {
+		_, _, err := axon.Pipe[string](&axon.DialOptions{Subprotocols: []string{"v1"}}, &axon.UpgradeOptions{
+			SelectSubprotocol: func([]string) string { return "v9" },
+		})
+		if err == nil {
+			t.Fatal("expected the upgrade to fail")
+		}
+	})
+}
+
+type tenantKey struct{}
+
+func TestUpgradeInterceptors(t *testing.T) {
+	opts := &axon.UpgradeOptions{
+		Interceptors: []axon.UpgradeInterceptor{
+			{
+				Before: func(r *http.Request) (context.Context, error) {
+					if r.Header.Get("Authorization") != "Bearer secret" {
+						return nil, errors.New("unauthorized")
+					}
+					return r.Context(), nil
+				},
+			},
+			{
+				Before: func(r *http.Request) (context.Context, error) {
+					return context.WithValue(r.Context(), tenantKey{}, r.Header.Get("X-Tenant")), nil
+				},
+				After: func(ctx context.Context, conn axon.RegisteredConn) error {
+					conn.Set("tenant", ctx.Value(tenantKey{}))
+					return nil
+				},
+			},
+		},
+	}
+
+	server := httptest.NewServer(axon.Handler[string](opts, func(ctx context.Context, conn *axon.Conn[string]) {
+		tenant, _ := conn.Get("tenant")
+		conn.Write(ctx, fmt.Sprintf("%v/%v", ctx.Value(tenantKey{}), tenant))
+		conn.Read(ctx)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	if _, err := axon.Dial[string](ctx, url, &axon.DialOptions{}); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("expected unauthenticated dial to fail with 403, got %v", err)
+	}
+
+	conn, err := axon.Dial[string](ctx, url, &axon.DialOptions{
+		Headers: http.Header{
+			"Authorization": {"Bearer secret"},
+			"X-Tenant":      {"acme"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close(1000, "")
+
+	got, err := conn.Read(ctx)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if got != "acme/acme" {
+		t.Errorf("expected tenant in context and metadata, got %q", got)
+	}
+}
+
+func TestUpgradeConnContextOutlivesHandler(t *testing.T) {
+	type upgraded struct {
+		conn   *axon.Conn[string]
+		reqCtx context.Context
+	}
+	conns := make(chan upgraded, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[string](w, r, nil)
+		if err != nil {
+			return
+		}
+		conns <- upgraded{conn, r.Context()}
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	client, err := axon.Dial[string](ctx, "ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer client.Close(1000, "")
+
+	u := <-conns
+	select {
+	case <-u.reqCtx.Done():
+	case <-ctx.Done():
+		t.Fatal("request context was not canceled after the handler returned")
+	}
+	if err := u.conn.Context().Err(); err != nil {
+		t.Errorf("connection context ended with the handler: %v", err)
+	}
+	if u.conn.Context().Value(http.ServerContextKey) == nil {
+		t.Error("connection context lost the request context's values")
+	}
+
+	u.conn.Close(1000, "")
+	select {
+	case <-u.conn.Context().Done():
+	default:
+		t.Error("connection context was not canceled by Close")
+	}
+}
+
+func TestUpgradeBaseContext(t *testing.T) {
+	type baseKey struct{}
+	base, cancelBase := context.WithCancel(context.WithValue(context.Background(), baseKey{}, "base"))
+	defer cancelBase()
+
+	conns := make(chan *axon.Conn[string], 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[string](w, r, &axon.UpgradeOptions{
+			BaseContext: func(r *http.Request) context.Context { return base },
+			Interceptors: []axon.UpgradeInterceptor{{
+				Before: func(r *http.Request) (context.Context, error) {
+					return context.WithValue(r.Context(), tenantKey{}, "acme"), nil
+				},
+			}},
+		})
+		if err != nil {
+			return
+		}
+		conns <- conn
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	client, err := axon.Dial[string](ctx, "ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer client.Close(1000, "")
+
+	conn := <-conns
+	defer conn.Close(1000, "")
+	connCtx := conn.Context()
+	if got := connCtx.Value(baseKey{}); got != "base" {
+		t.Errorf("base context value = %v, want %q", got, "base")
+	}
+	if got := connCtx.Value(tenantKey{}); got != "acme" {
+		t.Errorf("interceptor value = %v, want %q", got, "acme")
+	}
+	if err := connCtx.Err(); err != nil {
+		t.Fatalf("connection context canceled early: %v", err)
+	}
+
+	cancelBase()
+	select {
+	case <-connCtx.Done():
+	case <-ctx.Done():
+		t.Error("connection context was not canceled with its base context")
+	}
+}
+
+func TestUpgradeInterceptorAfterRejects(t *testing.T) {
+	server := httptest.NewServer(axon.Handler[string](&axon.UpgradeOptions{
+		Interceptors: []axon.UpgradeInterceptor{{
+			After: func(ctx context.Context, conn axon.RegisteredConn) error {
+				return errors.New("tenant suspended")
+			},
+		}},
+	}, func(ctx context.Context, conn *axon.Conn[string]) {
+		t.Error("handler should not run when After rejects")
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	conn, err := axon.Dial[string](ctx, "ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close(1000, "")
+
+	var closeErr *axon.CloseError
+	if _, err := conn.Read(ctx); !errors.As(err, &closeErr) || closeErr.Code != axon.ClosePolicyViolation {
+		t.Errorf("expected policy violation closure, got %v", err)
+	}
+}
+
+// stalledHijacker hijacks to one end of a pipe nobody reads from
+type stalledHijacker struct {
+	http.ResponseWriter
+	conn net.Conn
+}
+
+func (h stalledHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return h.conn, bufio.NewReadWriter(bufio.NewReader(h.conn), bufio.NewWriter(h.conn)), nil
+}
+
+func TestUpgradeHandshakeTimeout(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	w := stalledHijacker{ResponseWriter: httptest.NewRecorder(), conn: serverConn}
+
+	start := time.Now()
+	_, err := axon.Upgrade[string](w, req, &axon.UpgradeOptions{HandshakeTimeout: 50 * time.Millisecond})
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("Upgrade() error = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Upgrade() took %v", elapsed)
+	}
+}
+
+func TestUpgradePipelinedFrame(t *testing.T) {
+	received := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[string](w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close(1000, "")
+		msg, err := conn.Read(r.Context())
+		if err != nil {
+			msg = err.Error()
+		}
+		received <- msg
+	}))
+	defer server.Close()
+
+	nc, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+
+	// Send a frame in the same write as the request, so net/http buffers it
+	var buf strings.Builder
+	buf.WriteString("GET / HTTP/1.1\r\nHost: example.com\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+	writeClientFrame(&buf, 0x1, []byte(`"early"`))
+	if _, err := io.WriteString(nc, buf.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-received:
+		if msg != "early" {
+			t.Errorf("Read() = %q, want the pipelined message", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pipelined frame was not read")
+	}
+}
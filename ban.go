@@ -0,0 +1,95 @@
+package axon
+
+import (
+	"net/http"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// BanStore records banned clients, keyed by client IP or by principal.
+// Implement it to share bans between servers, such as in Redis; BanList
+// keeps them in memory.
+type BanStore interface {
+	// Ban bans key until the given time. The zero time bans it for good.
+	Ban(key string, until time.Time) error
+
+	// Unban lifts the ban on key
+	Unban(key string) error
+
+	// Banned reports whether key is currently banned
+	Banned(key string) (bool, error)
+}
+
+// BanList is an in-memory BanStore. Expired bans are discarded as they
+// are looked up.
+type BanList struct {
+	mu   sync.Mutex
+	bans map[string]time.Time
+}
+
+// NewBanList creates an empty BanList
+func NewBanList() *BanList {
+	return &BanList{bans: make(map[string]time.Time)}
+}
+
+// Ban bans key until the given time
+func (l *BanList) Ban(key string, until time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.bans[key] = until
+	return nil
+}
+
+// Unban lifts the ban on key
+func (l *BanList) Unban(key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.bans, key)
+	return nil
+}
+
+// Banned reports whether key is currently banned
+func (l *BanList) Banned(key string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	until, ok := l.bans[key]
+	if !ok {
+		return false, nil
+	}
+	if !until.IsZero() && !time.Now().Before(until) {
+		delete(l.bans, key)
+		return false, nil
+	}
+	return true, nil
+}
+
+// banKeys returns the keys a connection can be banned by: its client IP
+// and, when the principal is a string, its principal
+func banKeys(ip netip.Addr, principal any) []string {
+	var keys []string
+	if ip.IsValid() {
+		keys = append(keys, ip.String())
+	}
+	if s, ok := principal.(string); ok && s != "" {
+		keys = append(keys, s)
+	}
+	return keys
+}
+
+// checkBans rejects the request with ErrBanned if any of keys is banned.
+// A failing store is logged and does not block upgrades.
+func (u *Upgrader) checkBans(r *http.Request, keys ...string) error {
+	for _, key := range keys {
+		banned, err := u.bans.Banned(key)
+		if err != nil {
+			u.logger.Warn("ban lookup failed", "remote_addr", r.RemoteAddr, "error", err)
+			continue
+		}
+		if banned {
+			return ErrBanned
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package axon_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+func TestBanList(t *testing.T) {
+	l := axon.NewBanList()
+	l.Ban("10.0.0.1", time.Now().Add(50*time.Millisecond))
+	l.Ban("mallory", time.Time{})
+
+	for _, key := range []string{"10.0.0.1", "mallory"} {
+		if banned, _ := l.Banned(key); !banned {
+			t.Errorf("expected %s to be banned", key)
+		}
+	}
+	if banned, _ := l.Banned("10.0.0.2"); banned {
+		t.Error("expected 10.0.0.2 not to be banned")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if banned, _ := l.Banned("10.0.0.1"); banned {
+		t.Error("expected ban on 10.0.0.1 to expire")
+	}
+
+	l.Unban("mallory")
+	if banned, _ := l.Banned("mallory"); banned {
+		t.Error("expected mallory to be unbanned")
+	}
+}
+
+func TestUpgrader_Banned(t *testing.T) {
+	metrics := &axon.Metrics{}
+	bans := axon.NewBanList()
+	bans.Ban("10.0.0.1", time.Time{})
+	u := axon.NewUpgrader(&axon.UpgradeOptions{Bans: bans, Metrics: metrics})
+
+	_, err := axon.UpgradeWith[string](u, httptest.NewRecorder(), upgradeRequest("10.0.0.1:1234"))
+	if err != axon.ErrBanned {
+		t.Fatalf("expected ErrBanned, got %v", err)
+	}
+	if status := axon.UpgradeErrorStatus(err); status != http.StatusForbidden {
+		t.Errorf("expected status 403, got %d", status)
+	}
+	if got := metrics.GetSnapshot().RejectedHandshakes; got != 1 {
+		t.Errorf("expected 1 rejected handshake, got %d", got)
+	}
+
+	// Other clients are still admitted and fail later on the recorder,
+	// which cannot be hijacked
+	_, err = axon.UpgradeWith[string](u, httptest.NewRecorder(), upgradeRequest("10.0.0.2:1234"))
+	if err == axon.ErrBanned {
+		t.Error("expected 10.0.0.2 not to be banned")
+	}
+}
+
+func TestUpgrader_BannedPrincipal(t *testing.T) {
+	hub := axon.NewHub[string](nil)
+	defer hub.Close()
+	hub.Ban("mallory", time.Minute)
+
+	opts := &axon.UpgradeOptions{
+		Bans: hub.Bans(),
+		Authenticator: axon.AuthFuncs{
+			AuthenticateFunc: func(r *http.Request, token string) (any, time.Time, error) {
+				return token, time.Time{}, nil
+			},
+		},
+	}
+	server := httptest.NewServer(axon.Handler(opts, func(ctx context.Context, conn *axon.Conn[string]) {
+		conn.Close(1000, "")
+	}))
+	defer server.Close()
+	url := "ws" + server.URL[len("http"):]
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	_, err := axon.Dial[string](ctx, url+"?access_token=mallory", nil)
+	var hsErr *axon.HandshakeError
+	if !errors.As(err, &hsErr) || hsErr.StatusCode() != http.StatusForbidden {
+		t.Fatalf("Dial() as banned principal error = %v, want 403", err)
+	}
+
+	conn, err := axon.Dial[string](ctx, url+"?access_token=alice", nil)
+	if err != nil {
+		t.Fatalf("Dial() as alice error = %v", err)
+	}
+	conn.Close(1000, "")
+}
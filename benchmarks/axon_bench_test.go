@@ -1,9 +1,18 @@
 package benchmarks_test
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -37,16 +46,235 @@ func BenchmarkUpgrade(b *testing.B) {
 	}
 }
 
+// benchTransports are the connections the end-to-end benchmarks run over
+var benchTransports = []string{"Pipe", "TCP"}
+
+// benchSizes are the message sizes of the end-to-end benchmarks, one that
+// fits the default buffers and one that doesn't
+var benchSizes = []int{128, 65536}
+
+// benchPayload returns size bytes of compressible text
+func benchPayload(size int) []byte {
+	return []byte(strings.Repeat("axon websocket ", size/15+1)[:size])
+}
+
+// benchPair connects a client and a server over net.Pipe or loopback TCP,
+// negotiating compression if compress is set. Both are closed when the
+// benchmark ends. The server runs in-process, so its allocations are
+// counted along with the client's.
+func benchPair(b *testing.B, transport string, compress bool) (client, server *axon.Conn[axon.RawBinary]) {
+	b.Helper()
+
+	dialOpts := &axon.DialOptions{
+		MaxFrameSize:   1 << 20,
+		MaxMessageSize: 1 << 20,
+		Compression:    compress,
+	}
+	upgradeOpts := &axon.UpgradeOptions{
+		MaxFrameSize:   1 << 20,
+		MaxMessageSize: 1 << 20,
+		Compression:    compress,
+	}
+
+	switch transport {
+	case "Pipe":
+		var err error
+		client, server, err = axon.Pipe[axon.RawBinary](dialOpts, upgradeOpts)
+		if err != nil {
+			b.Fatalf("pipe failed: %v", err)
+		}
+	case "TCP":
+		conns := make(chan *axon.Conn[axon.RawBinary], 1)
+		done := make(chan struct{})
+		hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, err := axon.Upgrade[axon.RawBinary](w, r, upgradeOpts)
+			if err != nil {
+				return
+			}
+			conns <- conn
+			<-done
+		}))
+		b.Cleanup(hs.Close)
+		b.Cleanup(func() { close(done) })
+
+		var err error
+		client, err = axon.Dial[axon.RawBinary](context.Background(), "ws"+strings.TrimPrefix(hs.URL, "http"), dialOpts)
+		if err != nil {
+			b.Fatalf("dial failed: %v", err)
+		}
+		server = <-conns
+	default:
+		b.Fatalf("unknown transport %q", transport)
+	}
+
+	b.Cleanup(func() {
+		server.Close(1000, "done")
+		client.Close(1000, "done")
+	})
+	return client, server
+}
+
+// benchCases runs bench for every transport, message size and compression
+// setting
+func benchCases(b *testing.B, bench func(b *testing.B, transport string, size int, compress bool)) {
+	for _, transport := range benchTransports {
+		for _, size := range benchSizes {
+			for _, compress := range []bool{false, true} {
+				name := fmt.Sprintf("%s/%d/Plain", transport, size)
+				if compress {
+					name = fmt.Sprintf("%s/%d/Compressed", transport, size)
+				}
+				b.Run(name, func(b *testing.B) {
+					bench(b, transport, size, compress)
+				})
+			}
+		}
+	}
+}
+
+// BenchmarkRead measures client reads of messages the server writes as fast
+// as they are read
 func BenchmarkRead(b *testing.B) {
-	// This benchmark would require a real WebSocket connection
-	// For now, we'll skip it as it requires more complex setup
-	b.Skip("requires real WebSocket connection")
+	benchCases(b, func(b *testing.B, transport string, size int, compress bool) {
+		client, server := benchPair(b, transport, compress)
+		payload := benchPayload(size)
+		ctx := context.Background()
+
+		go func() {
+			for server.WriteMessage(ctx, axon.BinaryMessage, payload) == nil {
+			}
+		}()
+
+		b.SetBytes(int64(size))
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, _, err := client.ReadMessage(ctx); err != nil {
+				b.Fatalf("read failed: %v", err)
+			}
+		}
+	})
 }
 
+// BenchmarkWrite measures client writes of messages the server reads and
+// discards
 func BenchmarkWrite(b *testing.B) {
-	// This benchmark would require a real WebSocket connection
-	// For now, we'll skip it as it requires more complex setup
-	b.Skip("requires real WebSocket connection")
+	benchCases(b, func(b *testing.B, transport string, size int, compress bool) {
+		client, server := benchPair(b, transport, compress)
+		payload := benchPayload(size)
+		ctx := context.Background()
+
+		go func() {
+			for {
+				if _, _, err := server.ReadMessage(ctx); err != nil {
+					return
+				}
+			}
+		}()
+
+		b.SetBytes(int64(size))
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := client.WriteMessage(ctx, axon.BinaryMessage, payload); err != nil {
+				b.Fatalf("write failed: %v", err)
+			}
+		}
+	})
+}
+
+// BenchmarkRoundTrip measures the latency of a message the server echoes
+// back to the client
+func BenchmarkRoundTrip(b *testing.B) {
+	benchCases(b, func(b *testing.B, transport string, size int, compress bool) {
+		client, server := benchPair(b, transport, compress)
+		payload := benchPayload(size)
+		ctx := context.Background()
+
+		go func() {
+			for {
+				typ, data, err := server.ReadMessage(ctx)
+				if err != nil {
+					return
+				}
+				if err := server.WriteMessage(ctx, typ, data); err != nil {
+					return
+				}
+			}
+		}()
+
+		b.SetBytes(int64(size))
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := client.WriteMessage(ctx, axon.BinaryMessage, payload); err != nil {
+				b.Fatalf("write failed: %v", err)
+			}
+			if _, _, err := client.ReadMessage(ctx); err != nil {
+				b.Fatalf("read failed: %v", err)
+			}
+		}
+	})
+}
+
+// BenchmarkReadFragmented measures reads of a 64 KiB message split into
+// more and more frames, which are accumulated into one payload
+func BenchmarkReadFragmented(b *testing.B) {
+	const size = 65536
+	for _, fragments := range []int{1, 4, 64} {
+		b.Run(fmt.Sprintf("%d", fragments), func(b *testing.B) {
+			msg := fragmentedMessage(benchPayload(size), fragments)
+			conn := dialSource(b, &axon.DialOptions{
+				MaxFrameSize:   1 << 20,
+				MaxMessageSize: 1 << 20,
+			}, msg)
+			ctx := context.Background()
+
+			b.SetBytes(size)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := conn.ReadMessage(ctx); err != nil {
+					b.Fatalf("read failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// fragmentedMessage encodes payload as a binary message of unmasked frames,
+// split into the given number of fragments
+func fragmentedMessage(payload []byte, fragments int) []byte {
+	var buf bytes.Buffer
+	step := len(payload) / fragments
+	for i := 0; i < fragments; i++ {
+		chunk := payload[i*step : (i+1)*step]
+		if i == fragments-1 {
+			chunk = payload[i*step:]
+		}
+
+		var b0 byte // Continuation
+		if i == 0 {
+			b0 = 0x2
+		}
+		if i == fragments-1 {
+			b0 |= 0x80
+		}
+		buf.WriteByte(b0)
+		switch n := len(chunk); {
+		case n < 126:
+			buf.WriteByte(byte(n))
+		case n < 65536:
+			buf.Write([]byte{126, byte(n >> 8), byte(n)})
+		default:
+			buf.WriteByte(127)
+			for shift := 56; shift >= 0; shift -= 8 {
+				buf.WriteByte(byte(n >> shift))
+			}
+		}
+		buf.Write(chunk)
+	}
+	return buf.Bytes()
 }
 
 func BenchmarkFrameParsing(b *testing.B) {
@@ -86,3 +314,217 @@ func BenchmarkSerialization(b *testing.B) {
 		}
 	})
 }
+
+// dialDiscard dials a loopback server that reads and discards every message.
+// The server accepts compression, which is used only if opts offers it.
+func dialDiscard[T any](b *testing.B, opts *axon.DialOptions) (*axon.Conn[T], func()) {
+	b.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[T](w, r, &axon.UpgradeOptions{
+			MaxFrameSize:   1 << 20,
+			MaxMessageSize: 1 << 20,
+			Compression:    true,
+		})
+		if err != nil {
+			return
+		}
+		defer conn.Close(1000, "done")
+		for {
+			if _, err := conn.Read(context.Background()); err != nil {
+				return
+			}
+		}
+	}))
+
+	conn, err := axon.Dial[T](context.Background(), "ws"+strings.TrimPrefix(server.URL, "http"), opts)
+	if err != nil {
+		server.Close()
+		b.Fatalf("dial failed: %v", err)
+	}
+
+	return conn, func() {
+		conn.Close(1000, "done")
+		server.Close()
+	}
+}
+
+// BenchmarkClientWriteMasked measures client writes, where masking the
+// payload dominates for large messages
+func BenchmarkClientWriteMasked(b *testing.B) {
+	for _, size := range []int{128, 4096, 65536} {
+		b.Run(fmt.Sprintf("%d", size), func(b *testing.B) {
+			conn, cleanup := dialDiscard[string](b, &axon.DialOptions{
+				MaxFrameSize:   1 << 20,
+				MaxMessageSize: 1 << 20,
+			})
+			defer cleanup()
+
+			msg := strings.Repeat("a", size)
+			ctx := context.Background()
+
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := conn.Write(ctx, msg); err != nil {
+					b.Fatalf("write failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// dialSink dials a client whose writes are discarded by a peer that only
+// answers the handshake, so that the benchmark sees the client's costs alone
+func dialSink(b *testing.B, opts *axon.DialOptions) *axon.Conn[axon.RawBinary] {
+	return dialRaw(b, opts, func(nc net.Conn) {
+		io.Copy(io.Discard, nc)
+	})
+}
+
+// dialSource dials a client whose peer writes frame over and over, and
+// otherwise only answers the handshake
+func dialSource(b *testing.B, opts *axon.DialOptions, frame []byte) *axon.Conn[axon.RawBinary] {
+	return dialRaw(b, opts, func(nc net.Conn) {
+		for {
+			if _, err := nc.Write(frame); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// dialRaw dials a client whose peer answers the handshake by hand and then
+// runs peer on the connection
+func dialRaw(b *testing.B, opts *axon.DialOptions, peer func(nc net.Conn)) *axon.Conn[axon.RawBinary] {
+	b.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatalf("listen failed: %v", err)
+	}
+	go func() {
+		nc, err := ln.Accept()
+		ln.Close()
+		if err != nil {
+			return
+		}
+		defer nc.Close()
+		req, err := http.ReadRequest(bufio.NewReader(nc))
+		if err != nil {
+			return
+		}
+		h := sha1.Sum([]byte(req.Header.Get("Sec-WebSocket-Key") + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+		fmt.Fprintf(nc, "HTTP/1.1 101 Switching Protocols\r\n"+
+			"Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+			base64.StdEncoding.EncodeToString(h[:]))
+		peer(nc)
+	}()
+
+	conn, err := axon.Dial[axon.RawBinary](context.Background(), "ws://"+ln.Addr().String()+"/", opts)
+	if err != nil {
+		b.Fatalf("dial failed: %v", err)
+	}
+	b.Cleanup(func() {
+		conn.Close(1000, "done")
+	})
+	return conn
+}
+
+// BenchmarkClientReadAllocs measures the allocations of reading a
+// single-frame message. Frames are parsed into a reused Frame, so only the
+// returned copy of the payload is allocated.
+func BenchmarkClientReadAllocs(b *testing.B) {
+	for _, size := range []int{125, 1024} {
+		b.Run(fmt.Sprintf("%d", size), func(b *testing.B) {
+			frame := []byte{0x82, byte(size)}
+			if size > 125 {
+				frame = []byte{0x82, 126, byte(size >> 8), byte(size)}
+			}
+			frame = append(frame, strings.Repeat("a", size)...)
+			conn := dialSource(b, nil, bytes.Repeat(frame, 64))
+			ctx := context.Background()
+
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := conn.ReadMessage(ctx); err != nil {
+					b.Fatalf("read failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkClientWriteAllocs measures the allocations of a client write,
+// which masks the payload without copying it
+func BenchmarkClientWriteAllocs(b *testing.B) {
+	for _, size := range []int{128, 4096, 65536} {
+		b.Run(fmt.Sprintf("%d", size), func(b *testing.B) {
+			conn := dialSink(b, &axon.DialOptions{
+				MaxFrameSize:   1 << 20,
+				MaxMessageSize: 1 << 20,
+			})
+			payload := []byte(strings.Repeat("a", size))
+			ctx := context.Background()
+
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := conn.WriteMessage(ctx, axon.BinaryMessage, payload); err != nil {
+					b.Fatalf("write failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkConcurrentWrite measures write throughput with many goroutines
+// sharing one connection, where work done outside the write lock overlaps
+func BenchmarkConcurrentWrite(b *testing.B) {
+	type payload struct {
+		Text string `json:"text"`
+		Seq  int    `json:"seq"`
+	}
+
+	cases := []struct {
+		name string
+		opts *axon.DialOptions
+	}{
+		{"Plain", &axon.DialOptions{}},
+		{"Pooled", &axon.DialOptions{
+			Compression:         true,
+			CompressionStrategy: axon.CompressionPooled,
+		}},
+		{"Takeover", &axon.DialOptions{
+			Compression: true,
+		}},
+	}
+
+	for _, tc := range cases {
+		b.Run(tc.name, func(b *testing.B) {
+			tc.opts.MaxFrameSize = 1 << 20
+			tc.opts.MaxMessageSize = 1 << 20
+			conn, cleanup := dialDiscard[payload](b, tc.opts)
+			defer cleanup()
+
+			msg := payload{Text: strings.Repeat("axon websocket ", 256)}
+			ctx := context.Background()
+
+			b.SetParallelism(4)
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if err := conn.Write(ctx, msg); err != nil {
+						b.Errorf("write failed: %v", err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package axon
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// PayloadCipher encrypts message payloads end to end, independently of
+// TLS, so that messages relayed through untrusted brokers stay private.
+// Both peers must use ciphers sharing the same keys.
+type PayloadCipher interface {
+	// Encrypt returns the ciphertext of plaintext
+	Encrypt(plaintext []byte) ([]byte, error)
+
+	// Decrypt returns the plaintext of ciphertext, or an error if it was
+	// not produced by a matching cipher or was tampered with
+	Decrypt(ciphertext []byte) ([]byte, error)
+}
+
+// UseCipher encrypts every message written to the connection and decrypts
+// every message read from it. Payloads are encrypted after serialization
+// and travel as binary messages carrying the original message type, so
+// middleware added later with WrapRead and WrapWrite sees plaintext. Messages
+// that fail to decrypt are returned as errors wrapping ErrDecryptionFailed.
+// Call it before the connection is used, or set PayloadCipher in the
+// options.
+func (c *Conn[T]) UseCipher(pc PayloadCipher) {
+	c.WrapRead(func(next ReadFunc) ReadFunc {
+		return func(ctx context.Context) (MessageType, []byte, error) {
+			_, data, err := next(ctx)
+			if err != nil {
+				return 0, nil, err
+			}
+			plaintext, err := pc.Decrypt(data)
+			if err != nil {
+				if !errors.Is(err, ErrDecryptionFailed) {
+					err = fmt.Errorf("%w: %w", ErrDecryptionFailed, err)
+				}
+				return 0, nil, err
+			}
+			if len(plaintext) == 0 {
+				return 0, nil, ErrDecryptionFailed
+			}
+			return MessageType(plaintext[0]), plaintext[1:], nil
+		}
+	})
+	c.WrapWrite(func(next WriteFunc) WriteFunc {
+		return func(ctx context.Context, messageType MessageType, data []byte) error {
+			// The message type is encrypted along with the payload
+			plaintext := make([]byte, 1+len(data))
+			plaintext[0] = byte(messageType)
+			copy(plaintext[1:], data)
+			ciphertext, err := pc.Encrypt(plaintext)
+			if err != nil {
+				return err
+			}
+			return next(ctx, BinaryMessage, ciphertext)
+		}
+	})
+}
+
+// AESGCMCipher is a PayloadCipher using AES-GCM with random nonces. Each
+// ciphertext names the key that sealed it, so keys can be rotated without
+// coordinating both peers: add the new key to every peer with AddKey, make
+// it current with Rotate, and remove the old one once no messages sealed
+// with it remain in flight. Keys should be rotated well before 2^32
+// messages are sealed with one.
+type AESGCMCipher struct {
+	mu      sync.RWMutex
+	current uint32
+	keys    map[uint32]cipher.AEAD
+}
+
+// aesGCMHeaderSize is the key ID and nonce that start each ciphertext
+const aesGCMHeaderSize = 4 + 12
+
+// NewAESGCMCipher creates a cipher sealing with key, identified by keyID.
+// The key must be 16, 24 or 32 bytes long.
+func NewAESGCMCipher(keyID uint32, key []byte) (*AESGCMCipher, error) {
+	c := &AESGCMCipher{keys: make(map[uint32]cipher.AEAD)}
+	if err := c.Rotate(keyID, key); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// AddKey makes key, identified by keyID, available for opening messages
+// without sealing with it yet
+func (c *AESGCMCipher) AddKey(keyID uint32, key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf("axon: invalid AES key: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return fmt.Errorf("axon: invalid AES key: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.keys[keyID] = aead
+	return nil
+}
+
+// Rotate adds key, identified by keyID, and seals all later messages with
+// it. Previous keys still open messages until removed.
+func (c *AESGCMCipher) Rotate(keyID uint32, key []byte) error {
+	if err := c.AddKey(keyID, key); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.current = keyID
+	return nil
+}
+
+// RemoveKey retires a key. The current key cannot be removed.
+func (c *AESGCMCipher) RemoveKey(keyID uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if keyID != c.current {
+		delete(c.keys, keyID)
+	}
+}
+
+// Encrypt seals plaintext with the current key
+func (c *AESGCMCipher) Encrypt(plaintext []byte) ([]byte, error) {
+	c.mu.RLock()
+	keyID, aead := c.current, c.keys[c.current]
+	c.mu.RUnlock()
+
+	out := make([]byte, aesGCMHeaderSize, aesGCMHeaderSize+len(plaintext)+aead.Overhead())
+	binary.BigEndian.PutUint32(out, keyID)
+	if _, err := rand.Read(out[4:aesGCMHeaderSize]); err != nil {
+		return nil, err
+	}
+	// The key ID is authenticated so it cannot be swapped
+	return aead.Seal(out, out[4:aesGCMHeaderSize], plaintext, out[:4]), nil
+}
+
+// Decrypt opens a ciphertext sealed with any key the cipher holds
+func (c *AESGCMCipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < aesGCMHeaderSize {
+		return nil, ErrDecryptionFailed
+	}
+	keyID := binary.BigEndian.Uint32(ciphertext)
+
+	c.mu.RLock()
+	aead, ok := c.keys[keyID]
+	c.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key %d", ErrDecryptionFailed, keyID)
+	}
+
+	plaintext, err := aead.Open(nil, ciphertext[4:aesGCMHeaderSize], ciphertext[aesGCMHeaderSize:], ciphertext[:4])
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,112 @@
+package axon_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+func newTestCipher(t *testing.T, keyID uint32, fill byte) *axon.AESGCMCipher {
+	t.Helper()
+	c, err := axon.NewAESGCMCipher(keyID, bytes.Repeat([]byte{fill}, 32))
+	if err != nil {
+		t.Fatalf("NewAESGCMCipher() error = %v", err)
+	}
+	return c
+}
+
+func TestAESGCMCipher(t *testing.T) {
+	sender := newTestCipher(t, 1, 'a')
+	receiver := newTestCipher(t, 1, 'a')
+
+	ciphertext, err := sender.Encrypt([]byte("secret"))
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if bytes.Contains(ciphertext, []byte("secret")) {
+		t.Error("ciphertext contains the plaintext")
+	}
+	if plaintext, err := receiver.Decrypt(ciphertext); err != nil || string(plaintext) != "secret" {
+		t.Errorf("Decrypt() = %q, %v", plaintext, err)
+	}
+
+	tampered := bytes.Clone(ciphertext)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := receiver.Decrypt(tampered); !errors.Is(err, axon.ErrDecryptionFailed) {
+		t.Errorf("Decrypt() of tampered ciphertext error = %v", err)
+	}
+
+	// The receiver learns the new key before the sender seals with it
+	newKey := bytes.Repeat([]byte{'b'}, 32)
+	if err := receiver.AddKey(2, newKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := sender.Rotate(2, newKey); err != nil {
+		t.Fatal(err)
+	}
+	rotated, _ := sender.Encrypt([]byte("after"))
+	if plaintext, err := receiver.Decrypt(rotated); err != nil || string(plaintext) != "after" {
+		t.Errorf("Decrypt() after rotation = %q, %v", plaintext, err)
+	}
+	if _, err := receiver.Decrypt(ciphertext); err != nil {
+		t.Errorf("Decrypt() with the previous key error = %v", err)
+	}
+
+	receiver.Rotate(2, newKey)
+	receiver.RemoveKey(1)
+	if _, err := receiver.Decrypt(ciphertext); !errors.Is(err, axon.ErrDecryptionFailed) {
+		t.Errorf("Decrypt() with a removed key error = %v", err)
+	}
+
+	if _, err := axon.NewAESGCMCipher(1, []byte("short")); err == nil {
+		t.Error("NewAESGCMCipher() accepted a short key")
+	}
+}
+
+func TestConn_PayloadCipher(t *testing.T) {
+	readErrs := make(chan error, 1)
+	server := httptest.NewServer(axon.Handler(&axon.UpgradeOptions{PayloadCipher: newTestCipher(t, 1, 'k')},
+		func(ctx context.Context, conn *axon.Conn[string]) {
+			msg, err := conn.Read(ctx)
+			if err != nil {
+				readErrs <- err
+				return
+			}
+			conn.Write(ctx, "echo "+msg)
+			conn.Read(ctx)
+		}))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	conn, err := axon.Dial[string](ctx, url, &axon.DialOptions{PayloadCipher: newTestCipher(t, 1, 'k')})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close(1000, "")
+	if err := conn.Write(ctx, "hi"); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if msg, err := conn.Read(ctx); err != nil || msg != "echo hi" {
+		t.Errorf("Read() = %q, %v", msg, err)
+	}
+
+	// Messages sealed with another key are rejected
+	other, err := axon.Dial[string](ctx, url, &axon.DialOptions{PayloadCipher: newTestCipher(t, 1, 'e')})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer other.Close(1000, "")
+	other.Write(ctx, "hi")
+	if err := <-readErrs; !errors.Is(err, axon.ErrDecryptionFailed) {
+		t.Errorf("server Read() error = %v, want ErrDecryptionFailed", err)
+	}
+}
@@ -2,6 +2,9 @@ package axon
 
 import (
 	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -14,6 +17,7 @@ type Client[T any] struct {
 	conn   *Conn[T]
 	connMu sync.RWMutex
 	dialer *Dialer
+	log    *slog.Logger
 
 	// State management
 	state *stateManager
@@ -25,10 +29,47 @@ type Client[T any] struct {
 	queue *MessageQueue[T]
 
 	// Callbacks
-	onConnect    func(*Client[T])
-	onDisconnect func(*Client[T], error)
-	onMessage    func(T)
-	onError      func(error)
+	onConnect     func(*Client[T])
+	onDisconnect  func(*Client[T], error)
+	onMessage     func(T)
+	onRawMessage  func([]byte, MessageType)
+	onDecodeError func([]byte, error)
+	onError       func(error)
+
+	// Calls awaiting a response
+	callsMu sync.Mutex
+	callID  func(T) string
+	calls   map[string]*pendingCall[T]
+
+	// Topic subscriptions
+	topicsMu sync.Mutex
+	topics   TopicConfig[T]
+	subs     map[string][]*Subscription[T]
+
+	// Acknowledged writes
+	acksMu      sync.Mutex
+	acks        AckConfig[T]
+	ackSeq      uint64
+	pendingAcks map[uint64]*pendingAck[T]
+
+	// Application-level heartbeats
+	heartbeatMu    sync.Mutex
+	heartbeat      HeartbeatConfig[T]
+	heartbeatReply chan struct{}
+
+	// Session resumption
+	sessionMu  sync.Mutex
+	sessionSeq func(T) (uint64, bool)
+	lastSeq    uint64
+
+	// Read ownership
+	modeMu        sync.Mutex
+	readMode      ReadMode
+	readLoopOn    bool
+	loopConn      *Conn[T]      // Connection of the latest read loop
+	loopDone      chan struct{} // Closed when the latest read loop stops
+	receive       chan T
+	receiveClosed bool
 
 	// Lifecycle
 	ctx       context.Context
@@ -37,6 +78,24 @@ type Client[T any] struct {
 	closeOnce sync.Once
 }
 
+// ReadMode selects how a Client's received messages are consumed. Only one
+// reader may own the connection, so the modes are exclusive.
+type ReadMode int
+
+const (
+	// ReadModeAuto selects the mode of whichever of Read or
+	// ConnectWithReadLoop is used first
+	ReadModeAuto ReadMode = iota
+	// ReadModeCallback reads with the read loop started by
+	// ConnectWithReadLoop, delivering messages to OnMessage and Receive
+	ReadModeCallback
+	// ReadModePull reads only when Read is called
+	ReadModePull
+)
+
+// receiveBufferSize is the capacity of the channel returned by Receive
+const receiveBufferSize = 64
+
 // ClientOptions configures the WebSocket client
 type ClientOptions struct {
 	// DialOptions for the underlying connection
@@ -45,6 +104,23 @@ type ClientOptions struct {
 	// Reconnection configuration
 	Reconnect *ReconnectConfig
 
+	// Endpoints chooses the URL of each connection attempt, replacing the
+	// URL passed to NewClient. See NewEndpoints.
+	// Default is nil (always dial the client's URL).
+	Endpoints EndpointProvider
+
+	// HTTPFallback degrades to a server-sent event stream for reads and
+	// HTTP POST requests for writes when the server answers the upgrade
+	// with 400 or 426, as it does when a proxy strips the Upgrade header.
+	// The server must enable UpgradeOptions.HTTPFallback. Messages keep
+	// their WebSocket framing, so the Conn behaves the same either way.
+	// Default is false.
+	HTTPFallback bool
+
+	// Health replaces degraded connections before they fail
+	// Default is nil (connections are only replaced once lost)
+	Health *HealthConfig
+
 	// QueueSize is the maximum number of messages to queue during disconnection
 	// 0 disables queuing
 	QueueSize int
@@ -53,11 +129,34 @@ type ClientOptions struct {
 	// Default: 30 seconds
 	QueueTimeout time.Duration
 
+	// CallTimeout bounds Call when its context has no deadline
+	// 0 means no timeout
+	CallTimeout time.Duration
+
+	// FlushConcurrency is how many keys of queued messages are flushed at
+	// once after reconnecting; see SetQueueKey. Messages without a key
+	// function are flushed one at a time.
+	// Default is 1.
+	FlushConcurrency int
+
+	// ReadMode selects between the read loop and Read.
+	// Default is ReadModeAuto.
+	ReadMode ReadMode
+
+	// QueueStore persists queued messages so they survive restarts.
+	// Messages it holds are queued again when the client is created.
+	// Default is nil (in-memory only).
+	QueueStore QueueStore
+
 	// OnError is called when an error occurs
 	OnError func(error)
 
 	// OnStateChange is called when the connection state changes
 	OnStateChange StateHandler
+
+	// AsyncStateHandlers calls state change handlers in order on a separate
+	// goroutine, so slow handlers do not block state transitions
+	AsyncStateHandlers bool
 }
 
 // DefaultClientOptions returns default client options
@@ -75,6 +174,7 @@ func DefaultClientOptions() *ClientOptions {
 		Reconnect:    DefaultReconnectConfig(),
 		QueueSize:    100,
 		QueueTimeout: 30 * time.Second,
+		CallTimeout:  30 * time.Second,
 	}
 }
 
@@ -85,13 +185,16 @@ func NewClient[T any](url string, opts *ClientOptions) *Client[T] {
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
+	log := loggerOr(opts.Logger)
 
 	c := &Client[T]{
 		url:         url,
 		opts:        opts,
 		dialer:      NewDialer(&opts.DialOptions),
-		state:       newStateManager(),
-		reconnector: newReconnector(opts.Reconnect),
+		log:         log,
+		state:       newStateManager(opts.AsyncStateHandlers),
+		reconnector: newReconnector(opts.Reconnect, log, opts.Metrics, opts.Clock),
+		readMode:    opts.ReadMode,
 		ctx:         ctx,
 		cancel:      cancel,
 	}
@@ -99,6 +202,8 @@ func NewClient[T any](url string, opts *ClientOptions) *Client[T] {
 	// Initialize queue if enabled
 	if opts.QueueSize > 0 {
 		c.queue = newMessageQueue[T](opts.QueueSize, opts.QueueTimeout)
+		c.queue.metrics = opts.Metrics
+		c.queue.clock = clockOr(opts.Clock)
 	}
 
 	// Register callbacks
@@ -109,6 +214,18 @@ func NewClient[T any](url string, opts *ClientOptions) *Client[T] {
 		c.state.OnStateChange(opts.OnStateChange)
 	}
 
+	if c.queue != nil {
+		c.queue.setFlush(opts.FlushConcurrency, nil)
+	}
+	if c.queue != nil && opts.QueueStore != nil {
+		if err := c.queue.restore(opts.QueueStore); err != nil {
+			c.log.Error("failed to restore queued messages", "error", err)
+			if c.onError != nil {
+				c.onError(err)
+			}
+		}
+	}
+
 	return c
 }
 
@@ -127,6 +244,19 @@ func (c *Client[T]) OnMessage(fn func(T)) {
 	c.onMessage = fn
 }
 
+// OnRawMessage sets the callback for the raw payload of received messages.
+// It runs before the payload is decoded, so the slice may be retained but
+// must not be modified.
+func (c *Client[T]) OnRawMessage(fn func([]byte, MessageType)) {
+	c.onRawMessage = fn
+}
+
+// OnDecodeError sets the callback for messages that cannot be decoded into
+// T. When unset, decode errors are reported to OnError without the payload.
+func (c *Client[T]) OnDecodeError(fn func(payload []byte, err error)) {
+	c.onDecodeError = fn
+}
+
 // Connect establishes the WebSocket connection
 func (c *Client[T]) Connect(ctx context.Context) error {
 	// Transition to connecting state
@@ -139,18 +269,22 @@ func (c *Client[T]) Connect(ctx context.Context) error {
 	}
 
 	// Attempt to connect
-	conn, err := DialWithDialer[T](ctx, c.dialer, c.url)
+	conn, err := c.dial(ctx, nil, "")
 	if err != nil {
-		c.state.forceTransition(StateDisconnected, err, 0)
+		c.state.advance(StateDisconnected, err, 0)
 		return err
 	}
 
-	c.connMu.Lock()
-	c.conn = conn
-	c.connMu.Unlock()
-
 	// Transition to connected state
-	c.state.forceTransition(StateConnected, nil, 0)
+	if err := c.attach(conn, 0); err != nil {
+		return err
+	}
+	c.startReadLoop(conn)
+	c.startHealthMonitor(conn)
+	c.startHeartbeat(conn)
+
+	c.resubscribe(ctx)
+	c.resendAcks(ctx)
 
 	// Flush any queued messages
 	if c.queue != nil {
@@ -170,110 +304,256 @@ func (c *Client[T]) Connect(ctx context.Context) error {
 	return nil
 }
 
+// dial connects to url, or the next endpoint if empty, adding headers to
+// the handshake and presenting the session for resumption
+func (c *Client[T]) dial(ctx context.Context, headers http.Header, url string) (*Conn[T], error) {
+	if url == "" && c.opts.Endpoints != nil {
+		url = c.opts.Endpoints.Next()
+		conn, err := c.dialURL(ctx, headers, url)
+		c.opts.Endpoints.Report(url, err)
+		return conn, err
+	}
+	if url == "" {
+		url = c.url
+	}
+	return c.dialURL(ctx, headers, url)
+}
+
+// dialURL connects to url
+func (c *Client[T]) dialURL(ctx context.Context, headers http.Header, url string) (*Conn[T], error) {
+	d := c.dialer
+	id := c.state.SessionID()
+	if id != "" || len(headers) > 0 {
+		opts := c.opts.DialOptions
+		opts.Headers = opts.Headers.Clone()
+		if opts.Headers == nil {
+			opts.Headers = make(http.Header)
+		}
+		for key, values := range headers {
+			opts.Headers[http.CanonicalHeaderKey(key)] = values
+		}
+		if id != "" {
+			opts.Headers.Set(SessionHeader, id)
+			opts.Headers.Set(LastSeqHeader, strconv.FormatUint(c.LastSeq(), 10))
+		}
+		d = NewDialer(&opts)
+	}
+	conn, err := DialWithDialer[T](ctx, d, url)
+	if err != nil && c.opts.HTTPFallback && upgradeBlocked(err) {
+		c.log.Debug("upgrade blocked, falling back to HTTP", "error", err)
+		return dialFallback[T](ctx, d, url)
+	}
+	return conn, err
+}
+
 // ConnectWithReadLoop connects and starts a read loop
-// Messages are delivered via OnMessage callback
+// Messages are delivered via OnMessage callback and Receive.
+// A new read loop is started for each connection established by reconnecting.
+// It returns ErrPullMode if the client is in ReadModePull.
 func (c *Client[T]) ConnectWithReadLoop(ctx context.Context) error {
-	if err := c.Connect(ctx); err != nil {
+	if err := c.claimReadMode(ReadModeCallback); err != nil {
 		return err
 	}
 
-	c.wg.Add(1)
-	go c.readLoop()
+	c.modeMu.Lock()
+	c.readLoopOn = true
+	c.modeMu.Unlock()
+
+	if err := c.Connect(ctx); err != nil {
+		return err
+	}
 
+	// Connect does not start a loop if the client was already connected
+	if conn := c.Conn(); conn != nil {
+		c.startReadLoop(conn)
+	}
 	return nil
 }
 
-// readLoop continuously reads messages from the connection
-func (c *Client[T]) readLoop() {
-	defer c.wg.Done()
+// startReadLoop starts a read loop for conn if the client uses one and conn
+// has none yet. The loop starts once the previous connection's loop has
+// stopped, so messages are delivered in order and never concurrently.
+func (c *Client[T]) startReadLoop(conn *Conn[T]) {
+	c.modeMu.Lock()
+	if !c.readLoopOn || c.loopConn == conn {
+		c.modeMu.Unlock()
+		return
+	}
+	prev := c.loopDone
+	c.loopConn = conn
+	done := make(chan struct{})
+	c.loopDone = done
+	c.modeMu.Unlock()
 
-	for {
-		// Check if client is closed
-		select {
-		case <-c.ctx.Done():
-			return
-		default:
-		}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer close(done)
 
-		// Check connection state
-		state := c.state.State()
-		if state == StateClosed || state == StateClosing {
-			return
+		if prev != nil {
+			<-prev
 		}
+		c.readLoop(conn)
+	}()
+}
 
-		// Skip if not connected
-		if state != StateConnected {
-			time.Sleep(100 * time.Millisecond)
-			continue
+// readLoop reads messages from conn until it fails or is replaced
+func (c *Client[T]) readLoop(conn *Conn[T]) {
+	for {
+		messageType, payload, err := conn.ReadMessage(c.ctx)
+
+		// Stop if the client is closed or conn is no longer current
+		if c.ctx.Err() != nil || c.Conn() != conn {
+			return
 		}
 
-		// Read message
-		msg, err := c.Read(c.ctx)
 		if err != nil {
 			// Handle disconnection - check for CloseError, ErrConnectionClosed, or context canceled
 			if IsCloseError(err) || err == ErrConnectionClosed || err == ErrContextCanceled {
 				c.handleDisconnect(err)
-				continue
+				return
 			}
 
 			// Report error
+			conn.logger().Warn("read failed", "error", err)
 			if c.onError != nil {
 				c.onError(err)
 			}
 			continue
 		}
 
-		// Deliver message
-		if c.onMessage != nil {
-			c.onMessage(msg)
+		c.deliver(messageType, payload)
+	}
+}
+
+// deliver passes a received message to the message callbacks
+func (c *Client[T]) deliver(messageType MessageType, payload []byte) {
+	if c.onRawMessage != nil {
+		c.onRawMessage(payload, messageType)
+	}
+
+	var msg T
+	if len(payload) > 0 {
+		var err error
+		msg, err = decode[T](payload, false)
+		if err != nil {
+			c.log.Warn("failed to decode message", "error", err)
+			if c.onDecodeError != nil {
+				c.onDecodeError(payload, err)
+			} else if c.onError != nil {
+				c.onError(err)
+			}
+			return
+		}
+	}
+
+	c.trackSeq(msg)
+
+	if c.resolveHeartbeat(msg) || c.resolveCall(msg) || c.resolveAck(msg) || c.dispatchTopic(msg) {
+		return
+	}
+
+	if c.onMessage != nil {
+		c.onMessage(msg)
+	}
+
+	c.modeMu.Lock()
+	receive := c.receive
+	c.modeMu.Unlock()
+	if receive != nil {
+		select {
+		case receive <- msg:
+		case <-c.ctx.Done():
 		}
 	}
 }
 
+// attach makes conn the client's connection and transitions to connected.
+// If the client was closed meanwhile, conn is closed and ErrInvalidState
+// returned.
+func (c *Client[T]) attach(conn *Conn[T], attempt int) error {
+	c.connMu.Lock()
+	c.conn = conn
+	c.connMu.Unlock()
+
+	if err := c.state.advance(StateConnected, nil, attempt); err != nil {
+		c.detach(conn)
+		conn.Close(int(CloseNormalClosure), "client closed")
+		return err
+	}
+	return nil
+}
+
 // handleDisconnect handles connection loss
 func (c *Client[T]) handleDisconnect(err error) {
-	// Transition to disconnected or reconnecting
-	fromState := c.state.State()
-	if fromState == StateClosed || fromState == StateClosing {
+	// Check if we should reconnect
+	c.reconnector.maybeReset()
+	reconnect := c.reconnector.shouldReconnect(err)
+
+	// Transition to disconnected or reconnecting; only one caller handles
+	// the loss of a connection, and none once the client is closing
+	to := StateDisconnected
+	if reconnect {
+		to = StateReconnecting
+	}
+	if !c.state.transition(StateConnected, to, err, c.reconnector.attemptCount()) {
 		return
 	}
 
+	c.log.Warn("connection lost", "error", err, "reconnect", reconnect)
+	c.failCalls(ErrConnectionClosed)
+
 	// Call disconnect callback
 	if c.onDisconnect != nil {
 		c.onDisconnect(c, err)
 	}
 
-	// Check if we should reconnect
-	if c.reconnector.shouldReconnect(err) {
-		c.state.forceTransition(StateReconnecting, err, c.reconnector.attempts)
-		c.startReconnect()
+	if reconnect {
+		c.startReconnect(err)
 	} else {
-		c.state.forceTransition(StateDisconnected, err, 0)
+		c.failAcks(err)
 	}
 }
 
-// startReconnect initiates the reconnection process
-func (c *Client[T]) startReconnect() {
+// startReconnect initiates the reconnection process after err
+func (c *Client[T]) startReconnect(err error) {
 	c.wg.Add(1)
 	go func() {
 		defer c.wg.Done()
 
-		err := c.reconnector.reconnectLoop(c.ctx, func(ctx context.Context) error {
+		err := c.reconnector.reconnectLoop(c.ctx, err, func(ctx context.Context) error {
 			// Transition to connecting
-			c.state.forceTransition(StateConnecting, nil, c.reconnector.attempts)
+			if err := c.state.advance(StateConnecting, nil, c.reconnector.attemptCount()); err != nil {
+				return err
+			}
+
+			// Let the hook refresh credentials or pick another endpoint
+			var headers http.Header
+			var url string
+			if hook := c.reconnector.config.BeforeAttempt; hook != nil {
+				var err error
+				headers, url, err = hook(ctx, c.reconnector.attemptCount())
+				if err != nil {
+					return err
+				}
+			}
 
 			// Attempt connection
-			conn, err := DialWithDialer[T](ctx, c.dialer, c.url)
+			conn, err := c.dial(ctx, headers, url)
 			if err != nil {
 				return err
 			}
 
-			c.connMu.Lock()
-			c.conn = conn
-			c.connMu.Unlock()
-
 			// Transition to connected
-			c.state.forceTransition(StateConnected, nil, c.reconnector.attempts)
+			if err := c.attach(conn, c.reconnector.attemptCount()); err != nil {
+				return err
+			}
+			c.startReadLoop(conn)
+			c.startHealthMonitor(conn)
+			c.startHeartbeat(conn)
+
+			c.resubscribe(ctx)
+			c.resendAcks(ctx)
 
 			// Flush queued messages
 			if c.queue != nil {
@@ -291,7 +571,11 @@ func (c *Client[T]) startReconnect() {
 		})
 
 		if err != nil {
-			c.state.forceTransition(StateDisconnected, err, c.reconnector.attempts)
+			if c.ctx.Err() == nil {
+				c.log.Error("reconnection failed", "error", err)
+			}
+			c.failAcks(err)
+			c.state.advance(StateDisconnected, err, c.reconnector.attemptCount())
 			if c.onError != nil {
 				c.onError(err)
 			}
@@ -300,9 +584,14 @@ func (c *Client[T]) startReconnect() {
 }
 
 // Read reads a message from the connection
+// It returns ErrReadLoopActive if the client is in ReadModeCallback.
 func (c *Client[T]) Read(ctx context.Context) (T, error) {
 	var zero T
 
+	if err := c.claimReadMode(ReadModePull); err != nil {
+		return zero, err
+	}
+
 	c.connMu.RLock()
 	conn := c.conn
 	c.connMu.RUnlock()
@@ -314,6 +603,54 @@ func (c *Client[T]) Read(ctx context.Context) (T, error) {
 	return conn.Read(ctx)
 }
 
+// Receive returns a channel of the messages the read loop delivers to
+// OnMessage, for pull-style consumption in ReadModeCallback. The read loop
+// blocks until each message is received from the channel, which is closed
+// when the client is closed. It returns ErrPullMode if the client is in
+// ReadModePull.
+func (c *Client[T]) Receive() (<-chan T, error) {
+	if err := c.claimReadMode(ReadModeCallback); err != nil {
+		return nil, err
+	}
+
+	c.modeMu.Lock()
+	defer c.modeMu.Unlock()
+	if c.receive == nil {
+		c.receive = make(chan T, receiveBufferSize)
+		if c.receiveClosed {
+			close(c.receive)
+		}
+	}
+	return c.receive, nil
+}
+
+// ReadMode returns the client's read mode
+func (c *Client[T]) ReadMode() ReadMode {
+	c.modeMu.Lock()
+	defer c.modeMu.Unlock()
+	return c.readMode
+}
+
+// claimReadMode selects mode, failing if the client is in the other mode
+func (c *Client[T]) claimReadMode(mode ReadMode) error {
+	c.modeMu.Lock()
+	defer c.modeMu.Unlock()
+
+	switch c.readMode {
+	case ReadModeAuto:
+		c.readMode = mode
+	case ReadModeCallback:
+		if mode != ReadModeCallback {
+			return ErrReadLoopActive
+		}
+	case ReadModePull:
+		if mode != ReadModePull {
+			return ErrPullMode
+		}
+	}
+	return nil
+}
+
 // Write writes a message to the connection
 // If disconnected and queue is enabled, the message is queued
 func (c *Client[T]) Write(ctx context.Context, msg T) error {
@@ -343,6 +680,31 @@ func (c *Client[T]) Write(ctx context.Context, msg T) error {
 	return ErrConnectionClosed
 }
 
+// WriteNoWait is like Write but does not wait for a queued message to be
+// sent after reconnecting. It returns an error if msg could not be written
+// or queued; otherwise done, if not nil, is called exactly once with the
+// delivery result, from the reconnecting goroutine for queued messages.
+// A queued message is dropped if ctx is done before it is sent.
+func (c *Client[T]) WriteNoWait(ctx context.Context, msg T, done func(error)) error {
+	state := c.state.State()
+
+	if state == StateConnected {
+		if err := c.write(ctx, msg); err != nil {
+			return err
+		}
+		if done != nil {
+			done(nil)
+		}
+		return nil
+	}
+
+	if c.queue != nil && (state == StateReconnecting || state == StateConnecting) {
+		return c.queue.EnqueueFunc(ctx, msg, done)
+	}
+
+	return ErrConnectionClosed
+}
+
 // write sends a message directly to the connection
 func (c *Client[T]) write(ctx context.Context, msg T) error {
 	c.connMu.RLock()
@@ -366,14 +728,58 @@ func (c *Client[T]) IsConnected() bool {
 	return c.state.State() == StateConnected
 }
 
-// OnStateChange registers a callback for state change events
-func (c *Client[T]) OnStateChange(handler StateHandler) {
-	c.state.OnStateChange(handler)
+// ReconnectStats returns a snapshot of the client's reconnection state
+func (c *Client[T]) ReconnectStats() ReconnectStats {
+	return c.reconnector.stats()
+}
+
+// BreakerState returns the state of the reconnection circuit breaker
+func (c *Client[T]) BreakerState() BreakerState {
+	return c.reconnector.breakerState()
+}
+
+// WaitForState blocks until the client is in state or ctx is done. It
+// returns ErrClientClosed if the client is closed first.
+func (c *Client[T]) WaitForState(ctx context.Context, state ConnectionState) error {
+	for {
+		current, changed := c.state.watch()
+		if current == state {
+			return nil
+		}
+		if current == StateClosed {
+			return ErrClientClosed
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Ready returns a channel that is closed while the client is connected.
+// Once the connection is lost, Ready returns a new channel for the next
+// connection.
+func (c *Client[T]) Ready() <-chan struct{} {
+	return c.state.ready()
 }
 
-// SetSessionID sets the session identifier for reconnection
+// OnStateChange registers a callback for state change events and returns a
+// function that removes it
+func (c *Client[T]) OnStateChange(handler StateHandler) (remove func()) {
+	return c.state.OnStateChange(handler)
+}
+
+// SetSessionID sets the session identifier for reconnection. Changing it
+// resets LastSeq.
 func (c *Client[T]) SetSessionID(id string) {
+	c.sessionMu.Lock()
+	if id != c.state.SessionID() {
+		c.lastSeq = 0
+	}
 	c.state.SetSessionID(id)
+	c.sessionMu.Unlock()
 }
 
 // SessionID returns the current session identifier
@@ -381,6 +787,15 @@ func (c *Client[T]) SessionID() string {
 	return c.state.SessionID()
 }
 
+// SetQueueKey sets the function grouping queued messages for flushing.
+// Messages with the same key are sent in the order they were queued, while
+// different keys are flushed concurrently up to FlushConcurrency.
+func (c *Client[T]) SetQueueKey(fn func(T) string) {
+	if c.queue != nil {
+		c.queue.setFlush(c.opts.FlushConcurrency, fn)
+	}
+}
+
 // QueueStats returns message queue statistics
 func (c *Client[T]) QueueStats() MessageQueueStats {
 	if c.queue == nil {
@@ -394,7 +809,7 @@ func (c *Client[T]) Close() error {
 	var closeErr error
 
 	c.closeOnce.Do(func() {
-		// Transition to closing state
+		// Transition to closing state from any state
 		c.state.forceTransition(StateClosing, nil, 0)
 
 		// Cancel context to stop all goroutines
@@ -405,6 +820,9 @@ func (c *Client[T]) Close() error {
 			c.queue.Close()
 		}
 
+		c.failCalls(ErrClientClosed)
+		c.failAcks(ErrClientClosed)
+
 		// Close the connection
 		c.connMu.Lock()
 		if c.conn != nil {
@@ -416,6 +834,15 @@ func (c *Client[T]) Close() error {
 		// Wait for goroutines to finish
 		c.wg.Wait()
 
+		c.closeSubscriptions()
+
+		c.modeMu.Lock()
+		c.receiveClosed = true
+		if c.receive != nil {
+			close(c.receive)
+		}
+		c.modeMu.Unlock()
+
 		// Transition to closed state
 		c.state.forceTransition(StateClosed, nil, 0)
 	})
@@ -423,6 +850,12 @@ func (c *Client[T]) Close() error {
 	return closeErr
 }
 
+// StateHistory returns the client's most recent state transitions, oldest
+// first, for debugging
+func (c *Client[T]) StateHistory() []StateChange {
+	return c.state.History()
+}
+
 // Conn returns the underlying connection (may be nil if disconnected)
 func (c *Client[T]) Conn() *Conn[T] {
 	c.connMu.RLock()
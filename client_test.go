@@ -2,10 +2,13 @@ package axon_test
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -335,3 +338,495 @@ func TestClient_WithRealServer(t *testing.T) {
 		t.Errorf("State() = %v, want %v", client.State(), axon.StateClosed)
 	}
 }
+
+func TestClient_RawAndDecodeErrorCallbacks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[string](w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close(1000, "done")
+
+		conn.WriteMessage(r.Context(), axon.TextMessage, []byte(`{"n":1}`))
+		conn.WriteMessage(r.Context(), axon.TextMessage, []byte(`not json`))
+	}))
+	defer server.Close()
+
+	type event struct {
+		N int `json:"n"`
+	}
+
+	opts := axon.DefaultClientOptions()
+	opts.Reconnect.Enabled = false
+	client := axon.NewClient[event]("ws"+strings.TrimPrefix(server.URL, "http"), opts)
+	defer client.Close()
+
+	var mu sync.Mutex
+	var raw []string
+	var events []event
+	decodeErrs := make(chan string, 1)
+	disconnected := make(chan struct{})
+
+	client.OnRawMessage(func(payload []byte, mt axon.MessageType) {
+		mu.Lock()
+		raw = append(raw, mt.String()+":"+string(payload))
+		mu.Unlock()
+	})
+	client.OnMessage(func(e event) {
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+	})
+	client.OnDecodeError(func(payload []byte, err error) {
+		if !errors.Is(err, axon.ErrDeserializationFailed) {
+			t.Errorf("decode error = %v, want ErrDeserializationFailed", err)
+		}
+		decodeErrs <- string(payload)
+	})
+	client.OnDisconnect(func(*axon.Client[event], error) {
+		close(disconnected)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.ConnectWithReadLoop(ctx); err != nil {
+		t.Fatalf("ConnectWithReadLoop() error = %v", err)
+	}
+
+	select {
+	case payload := <-decodeErrs:
+		if payload != "not json" {
+			t.Errorf("decode error payload = %q, want %q", payload, "not json")
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for decode error")
+	}
+	select {
+	case <-disconnected:
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for disconnect")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 1 || events[0].N != 1 {
+		t.Errorf("events = %v, want [{1}]", events)
+	}
+	want := []string{`text:{"n":1}`, "text:not json"}
+	if strings.Join(raw, ",") != strings.Join(want, ",") {
+		t.Errorf("raw = %q, want %q", raw, want)
+	}
+}
+
+func TestClient_WriteNoWait(t *testing.T) {
+	received := make(chan string, 4)
+	first := true
+	var firstMu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[string](w, r, nil)
+		if err != nil {
+			return
+		}
+		firstMu.Lock()
+		drop := first
+		first = false
+		firstMu.Unlock()
+
+		if drop {
+			conn.Close(int(axon.CloseGoingAway), "restarting")
+			return
+		}
+		defer conn.Close(1000, "done")
+		for {
+			msg, err := conn.Read(context.Background())
+			if err != nil {
+				return
+			}
+			received <- msg
+		}
+	}))
+	defer server.Close()
+
+	opts := axon.DefaultClientOptions()
+	opts.Reconnect.InitialDelay = 200 * time.Millisecond
+	opts.Reconnect.Jitter = false
+	client := axon.NewClient[string]("ws"+strings.TrimPrefix(server.URL, "http"), opts)
+	defer client.Close()
+
+	reconnecting := make(chan struct{})
+	var once sync.Once
+	client.OnStateChange(func(sc axon.StateChange) {
+		if sc.To == axon.StateReconnecting {
+			once.Do(func() { close(reconnecting) })
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.ConnectWithReadLoop(ctx); err != nil {
+		t.Fatalf("ConnectWithReadLoop() error = %v", err)
+	}
+	select {
+	case <-reconnecting:
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for reconnect")
+	}
+
+	// Queued while reconnecting; returns before the message is sent
+	results := make(chan error, 1)
+	start := time.Now()
+	if err := client.WriteNoWait(ctx, "queued", func(err error) { results <- err }); err != nil {
+		t.Fatalf("WriteNoWait() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("WriteNoWait() blocked for %v", elapsed)
+	}
+
+	if err := receive(t, results); err != nil {
+		t.Errorf("delivery result = %v, want nil", err)
+	}
+	if msg := receive(t, received); msg != "queued" {
+		t.Errorf("server got %q, want %q", msg, "queued")
+	}
+
+	// While connected the message is written before returning
+	var direct error = context.Canceled
+	if err := client.WriteNoWait(ctx, "direct", func(err error) { direct = err }); err != nil {
+		t.Fatalf("WriteNoWait() error = %v", err)
+	}
+	if direct != nil {
+		t.Errorf("delivery result = %v, want nil", direct)
+	}
+	if msg := receive(t, received); msg != "direct" {
+		t.Errorf("server got %q, want %q", msg, "direct")
+	}
+}
+
+func TestClient_ReadModes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[string](w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close(1000, "done")
+
+		conn.Write(r.Context(), "hello")
+		conn.Write(r.Context(), "world")
+		for {
+			if _, err := conn.Read(r.Context()); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t.Run("pull", func(t *testing.T) {
+		opts := axon.DefaultClientOptions()
+		opts.ReadMode = axon.ReadModePull
+		client := axon.NewClient[string](wsURL, opts)
+		defer client.Close()
+
+		if err := client.ConnectWithReadLoop(ctx); !errors.Is(err, axon.ErrPullMode) {
+			t.Errorf("ConnectWithReadLoop() error = %v, want ErrPullMode", err)
+		}
+		if _, err := client.Receive(); !errors.Is(err, axon.ErrPullMode) {
+			t.Errorf("Receive() error = %v, want ErrPullMode", err)
+		}
+	})
+
+	t.Run("callback", func(t *testing.T) {
+		client := axon.NewClient[string](wsURL, nil)
+
+		msgs, err := client.Receive()
+		if err != nil {
+			t.Fatalf("Receive() error = %v", err)
+		}
+		if mode := client.ReadMode(); mode != axon.ReadModeCallback {
+			t.Errorf("ReadMode() = %v, want ReadModeCallback", mode)
+		}
+		if err := client.ConnectWithReadLoop(ctx); err != nil {
+			t.Fatalf("ConnectWithReadLoop() error = %v", err)
+		}
+
+		for _, want := range []string{"hello", "world"} {
+			if got := receive(t, msgs); got != want {
+				t.Errorf("received %q, want %q", got, want)
+			}
+		}
+		if _, err := client.Read(ctx); !errors.Is(err, axon.ErrReadLoopActive) {
+			t.Errorf("Read() error = %v, want ErrReadLoopActive", err)
+		}
+
+		client.Close()
+		if _, ok := <-msgs; ok {
+			t.Error("Receive channel should be closed after Close")
+		}
+	})
+}
+
+func TestClient_ReadLoopAfterReconnect(t *testing.T) {
+	var mu sync.Mutex
+	conns := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[string](w, r, nil)
+		if err != nil {
+			return
+		}
+		mu.Lock()
+		conns++
+		n := conns
+		mu.Unlock()
+
+		conn.Write(r.Context(), fmt.Sprintf("conn-%d", n))
+		if n < 3 {
+			conn.Close(int(axon.CloseGoingAway), "restarting")
+			return
+		}
+		defer conn.Close(1000, "done")
+		for {
+			if _, err := conn.Read(r.Context()); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	opts := axon.DefaultClientOptions()
+	opts.Reconnect.InitialDelay = 50 * time.Millisecond
+	opts.Reconnect.Jitter = false
+	client := axon.NewClient[string]("ws"+strings.TrimPrefix(server.URL, "http"), opts)
+	defer client.Close()
+
+	msgs, err := client.Receive()
+	if err != nil {
+		t.Fatalf("Receive() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.ConnectWithReadLoop(ctx); err != nil {
+		t.Fatalf("ConnectWithReadLoop() error = %v", err)
+	}
+
+	for _, want := range []string{"conn-1", "conn-2", "conn-3"} {
+		if got := receive(t, msgs); got != want {
+			t.Fatalf("received %q, want %q", got, want)
+		}
+	}
+	if state := client.State(); state != axon.StateConnected {
+		t.Errorf("State() = %v, want %v", state, axon.StateConnected)
+	}
+}
+
+func TestClient_WaitForState(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[string](w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close(1000, "done")
+		for {
+			if _, err := conn.Read(r.Context()); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	opts := axon.DefaultClientOptions()
+	opts.Reconnect.Enabled = false
+	client := axon.NewClient[string]("ws"+strings.TrimPrefix(server.URL, "http"), opts)
+	defer client.Close()
+
+	ready := client.Ready()
+	select {
+	case <-ready:
+		t.Fatal("Ready() closed before connecting")
+	default:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	connected := make(chan error, 1)
+	go func() {
+		connected <- client.WaitForState(ctx, axon.StateConnected)
+	}()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	if err := receive(t, connected); err != nil {
+		t.Errorf("WaitForState(connected) error = %v", err)
+	}
+	receive(t, ready)
+
+	short, cancelShort := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancelShort()
+	if err := client.WaitForState(short, axon.StateReconnecting); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitForState(reconnecting) error = %v, want context.DeadlineExceeded", err)
+	}
+
+	client.Close()
+	select {
+	case <-client.Ready():
+		t.Error("Ready() closed after Close")
+	default:
+	}
+	if err := client.WaitForState(ctx, axon.StateConnected); !errors.Is(err, axon.ErrClientClosed) {
+		t.Errorf("WaitForState() after Close error = %v, want ErrClientClosed", err)
+	}
+	if err := client.WaitForState(ctx, axon.StateClosed); err != nil {
+		t.Errorf("WaitForState(closed) error = %v", err)
+	}
+}
+
+func TestClient_BeforeAttempt(t *testing.T) {
+	type handshake struct {
+		path, auth string
+	}
+	handshakes := make(chan handshake, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[string](w, r, nil)
+		if err != nil {
+			return
+		}
+		handshakes <- handshake{r.URL.Path, r.Header.Get("Authorization")}
+
+		if r.URL.Path == "/v1" {
+			conn.Close(int(axon.CloseGoingAway), "restarting")
+			return
+		}
+		defer conn.Close(1000, "done")
+		for {
+			if _, err := conn.Read(r.Context()); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	refreshErr := errors.New("token refresh failed")
+	failed := make(chan error, 1)
+
+	opts := axon.DefaultClientOptions()
+	opts.Headers = http.Header{"Authorization": {"Bearer old"}}
+	opts.Reconnect.InitialDelay = 10 * time.Millisecond
+	opts.Reconnect.Jitter = false
+	opts.Reconnect.OnReconnectFailed = func(attempt int, err error) {
+		failed <- err
+	}
+	opts.Reconnect.BeforeAttempt = func(ctx context.Context, attempt int) (http.Header, string, error) {
+		if attempt == 1 {
+			return nil, "", refreshErr
+		}
+		return http.Header{"Authorization": {fmt.Sprintf("Bearer new-%d", attempt)}}, wsURL + "/v2", nil
+	}
+	client := axon.NewClient[string](wsURL+"/v1", opts)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.ConnectWithReadLoop(ctx); err != nil {
+		t.Fatalf("ConnectWithReadLoop() error = %v", err)
+	}
+
+	if hs := receive(t, handshakes); hs != (handshake{"/v1", "Bearer old"}) {
+		t.Errorf("first handshake = %+v, want /v1 with the original header", hs)
+	}
+	if err := receive(t, failed); !errors.Is(err, refreshErr) {
+		t.Errorf("OnReconnectFailed error = %v, want the BeforeAttempt error", err)
+	}
+	if hs := receive(t, handshakes); hs != (handshake{"/v2", "Bearer new-2"}) {
+		t.Errorf("reconnect handshake = %+v, want /v2 with Bearer new-2", hs)
+	}
+	if opts.Headers.Get("Authorization") != "Bearer old" {
+		t.Error("BeforeAttempt headers modified DialOptions.Headers")
+	}
+}
+
+func TestClient_ReconnectBreaker(t *testing.T) {
+	var accept atomic.Bool
+	var conns atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if conns.Load() > 0 && !accept.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		conn, err := axon.Upgrade[string](w, r, nil)
+		if err != nil {
+			return
+		}
+		if conns.Add(1) == 1 {
+			conn.Close(int(axon.CloseGoingAway), "restarting")
+			return
+		}
+		defer conn.Close(1000, "done")
+		for {
+			if _, err := conn.Read(r.Context()); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	type change struct {
+		state    axon.BreakerState
+		failures int
+		at       time.Time
+	}
+	changes := make(chan change, 16)
+
+	opts := axon.DefaultClientOptions()
+	opts.Reconnect.InitialDelay = 5 * time.Millisecond
+	opts.Reconnect.MaxDelay = 5 * time.Millisecond
+	opts.Reconnect.Jitter = false
+	opts.Reconnect.BreakerThreshold = 2
+	opts.Reconnect.BreakerCooldown = 100 * time.Millisecond
+	opts.Reconnect.OnBreakerChange = func(state axon.BreakerState, failures int) {
+		changes <- change{state, failures, time.Now()}
+	}
+	client := axon.NewClient[string]("ws"+strings.TrimPrefix(server.URL, "http"), opts)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.ConnectWithReadLoop(ctx); err != nil {
+		t.Fatalf("ConnectWithReadLoop() error = %v", err)
+	}
+
+	open := receive(t, changes)
+	if open.state != axon.BreakerOpen || open.failures != 2 {
+		t.Errorf("first change = %v after %d failures, want open after 2", open.state, open.failures)
+	}
+	halfOpen := receive(t, changes)
+	if halfOpen.state != axon.BreakerHalfOpen {
+		t.Errorf("second change = %v, want half-open", halfOpen.state)
+	}
+	if elapsed := halfOpen.at.Sub(open.at); elapsed < 100*time.Millisecond {
+		t.Errorf("breaker half-opened after %v, want at least the 100ms cooldown", elapsed)
+	}
+
+	// The failed probe opens the breaker again
+	if reopened := receive(t, changes); reopened.state != axon.BreakerOpen || reopened.failures != 3 {
+		t.Errorf("third change = %v after %d failures, want open after 3", reopened.state, reopened.failures)
+	}
+	accept.Store(true)
+
+	if c := receive(t, changes); c.state != axon.BreakerHalfOpen {
+		t.Errorf("fourth change = %v, want half-open", c.state)
+	}
+	if c := receive(t, changes); c.state != axon.BreakerClosed {
+		t.Errorf("fifth change = %v, want closed", c.state)
+	}
+	if err := client.WaitForState(ctx, axon.StateConnected); err != nil {
+		t.Fatalf("WaitForState() error = %v", err)
+	}
+	if state := client.BreakerState(); state != axon.BreakerClosed {
+		t.Errorf("BreakerState() = %v, want closed", state)
+	}
+}
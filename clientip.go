@@ -0,0 +1,154 @@
+package axon
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Conn metadata keys set on every server connection
+const (
+	// MetaClientIP holds the client's netip.Addr as returned by ClientIP.
+	// It is the zero Addr when the peer has no IP address.
+	MetaClientIP = "axon.client_ip"
+
+	// MetaClientTLS holds whether the client connected over TLS, as
+	// returned by ClientTLS
+	MetaClientTLS = "axon.client_tls"
+)
+
+// ClientIP returns the IP address of the client that sent r. When the
+// peer is one of trustedProxies, the Forwarded (RFC 7239) or else the
+// X-Forwarded-For header is walked from the right, skipping trusted hops,
+// and the first untrusted address is the client. Headers from untrusted
+// peers are ignored, since anyone can set them.
+func ClientIP(r *http.Request, trustedProxies []netip.Prefix) netip.Addr {
+	ip, _ := clientHop(r, trustedProxies)
+	return ip
+}
+
+// ClientTLS reports whether the client that sent r connected over TLS,
+// either to this server or, as told by the proto parameter of Forwarded
+// or X-Forwarded-Proto, to a proxy in trustedProxies
+func ClientTLS(r *http.Request, trustedProxies []netip.Prefix) bool {
+	ip, hop := clientHop(r, trustedProxies)
+	if hop < 0 || !ip.IsValid() {
+		return r.TLS != nil
+	}
+
+	var proto string
+	if elems := forwardedElements(r.Header); len(elems) > 0 {
+		proto = forwardedParam(elems[hop], "proto")
+	} else {
+		protos := headerList(r.Header, "X-Forwarded-Proto")
+		if len(protos) == 0 {
+			return r.TLS != nil
+		}
+		// Proxies rarely append to X-Forwarded-Proto, so the first value
+		// is taken as the client's
+		proto = protos[0]
+	}
+	return strings.EqualFold(proto, "https") || strings.EqualFold(proto, "wss")
+}
+
+// clientKey returns the client IP of r as counted by connection limits,
+// falling back to the remote address for peers without one
+func (u *Upgrader) clientKey(r *http.Request) string {
+	if ip := ClientIP(r, u.trustedProxies); ip.IsValid() {
+		return ip.String()
+	}
+	return remoteIP(r)
+}
+
+// clientHop returns the client's IP and the index of the forwarding
+// header element it was taken from, or -1 when it is the peer itself
+func clientHop(r *http.Request, trusted []netip.Prefix) (netip.Addr, int) {
+	peer := parseNode(r.RemoteAddr)
+	if !peer.IsValid() || !isTrusted(peer, trusted) {
+		return peer, -1
+	}
+
+	var hops []netip.Addr
+	if elems := forwardedElements(r.Header); len(elems) > 0 {
+		for _, elem := range elems {
+			hops = append(hops, parseNode(forwardedParam(elem, "for")))
+		}
+	} else {
+		for _, node := range headerList(r.Header, "X-Forwarded-For") {
+			hops = append(hops, parseNode(node))
+		}
+	}
+
+	client, index := peer, -1
+	for i := len(hops) - 1; i >= 0; i-- {
+		// Obfuscated and unknown nodes hide the rest of the chain
+		if !hops[i].IsValid() {
+			break
+		}
+		client, index = hops[i], i
+		if !isTrusted(client, trusted) {
+			break
+		}
+	}
+	return client, index
+}
+
+// isTrusted reports whether ip is in one of the prefixes
+func isTrusted(ip netip.Addr, prefixes []netip.Prefix) bool {
+	ip = ip.Unmap()
+	for _, p := range prefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseNode parses an address with an optional port, such as a
+// RemoteAddr or a Forwarded node, returning the zero Addr when it holds
+// no IP
+func parseNode(node string) netip.Addr {
+	node = strings.Trim(strings.TrimSpace(node), `"`)
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		node = host
+	}
+	node = strings.TrimSuffix(strings.TrimPrefix(node, "["), "]")
+	ip, err := netip.ParseAddr(node)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return ip.Unmap()
+}
+
+// forwardedElements returns the elements of the Forwarded headers in
+// order, one per proxy hop
+func forwardedElements(header http.Header) []string {
+	return headerList(header, "Forwarded")
+}
+
+// forwardedParam returns the value of the named parameter of a Forwarded
+// element
+func forwardedParam(elem, name string) string {
+	for _, pair := range strings.Split(elem, ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok && strings.EqualFold(key, name) {
+			return strings.Trim(value, `"`)
+		}
+	}
+	return ""
+}
+
+// headerList returns the comma separated values of every header line
+// with the given name
+func headerList(header http.Header, name string) []string {
+	var list []string
+	for _, line := range header.Values(name) {
+		for _, v := range strings.Split(line, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				list = append(list, v)
+			}
+		}
+	}
+	return list
+}
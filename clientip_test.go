@@ -0,0 +1,104 @@
+package axon_test
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/kolosys/axon"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     http.Header
+		want       string
+	}{
+		{"direct", "203.0.113.7:4000", nil, "203.0.113.7"},
+		{"untrusted peer", "203.0.113.7:4000", http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "203.0.113.7"},
+		{"x-forwarded-for", "10.0.0.1:4000", http.Header{"X-Forwarded-For": {"198.51.100.1, 10.0.0.2"}}, "198.51.100.1"},
+		{"spoofed prefix", "10.0.0.1:4000", http.Header{"X-Forwarded-For": {"1.2.3.4, 198.51.100.1"}}, "198.51.100.1"},
+		{"forwarded", "10.0.0.1:4000", http.Header{
+			"Forwarded":       {`for="[2001:db8::1]:4711";proto=https, for=10.0.0.2`},
+			"X-Forwarded-For": {"192.0.2.9"},
+		}, "2001:db8::1"},
+		{"obfuscated", "10.0.0.1:4000", http.Header{"Forwarded": {"for=_hidden, for=10.0.0.2"}}, "10.0.0.2"},
+		{"no header", "10.0.0.1:4000", nil, "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+			if got := axon.ClientIP(r, trusted); got.String() != tt.want {
+				t.Errorf("ClientIP() = %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientTLS(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:4000"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	r.Header.Set("X-Forwarded-Proto", "https")
+	if !axon.ClientTLS(r, trusted) {
+		t.Error("ClientTLS() = false behind a TLS terminating proxy")
+	}
+	if axon.ClientTLS(r, nil) {
+		t.Error("ClientTLS() = true from an untrusted header")
+	}
+
+	r.Header.Set("Forwarded", "for=198.51.100.1;proto=http")
+	if axon.ClientTLS(r, trusted) {
+		t.Error("ClientTLS() = true despite Forwarded proto=http")
+	}
+
+	r = httptest.NewRequest("GET", "/", nil)
+	r.TLS = &tls.ConnectionState{}
+	if !axon.ClientTLS(r, nil) {
+		t.Error("ClientTLS() = false for a TLS request")
+	}
+}
+
+func TestUpgrade_ClientMetadata(t *testing.T) {
+	got := make(chan [2]any, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[string](w, r, &axon.UpgradeOptions{
+			TrustedProxies: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")},
+		})
+		if err != nil {
+			return
+		}
+		defer conn.Close(1000, "")
+		ip, _ := conn.Get(axon.MetaClientIP)
+		secure, _ := conn.Get(axon.MetaClientTLS)
+		got <- [2]any{ip, secure}
+	}))
+	defer server.Close()
+
+	opts := &axon.DialOptions{Headers: http.Header{
+		"X-Forwarded-For":   {"198.51.100.1"},
+		"X-Forwarded-Proto": {"https"},
+	}}
+	conn, err := axon.Dial[string](t.Context(), "ws"+server.URL[4:], opts)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close(1000, "")
+
+	meta := <-got
+	if meta[0] != netip.MustParseAddr("198.51.100.1") || meta[1] != true {
+		t.Errorf("metadata = %v", meta)
+	}
+}
@@ -0,0 +1,264 @@
+package axon
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time for a connection's ping, idle and expiry
+// timers, a Client's backoff, heartbeats and health checks, and message
+// queue expiry. Network deadlines always follow the system clock.
+// Tests may pass a FakeClock to control time.
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+
+	// NewTimer creates a timer that fires once after d
+	NewTimer(d time.Duration) Timer
+
+	// NewTicker creates a ticker that fires every d
+	NewTicker(d time.Duration) Ticker
+
+	// AfterFunc calls f in its own goroutine after d
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a single event created by a Clock
+type Timer interface {
+	// C returns the channel the time is sent on when the timer fires. It
+	// is nil for timers created by AfterFunc.
+	C() <-chan time.Time
+
+	// Stop prevents the timer from firing, reporting whether it was
+	// active
+	Stop() bool
+
+	// Reset makes the timer fire after d, reporting whether it was active
+	Reset(d time.Duration) bool
+}
+
+// Ticker is a repeating event created by a Clock
+type Ticker interface {
+	// C returns the channel ticks are sent on
+	C() <-chan time.Time
+
+	// Stop turns the ticker off
+	Stop()
+
+	// Reset changes the ticker's period to d
+	Reset(d time.Duration)
+}
+
+// SystemClock is the Clock of the time package
+var SystemClock Clock = systemClock{}
+
+// clockOr returns clk, or SystemClock if clk is nil
+func clockOr(clk Clock) Clock {
+	if clk == nil {
+		return SystemClock
+	}
+	return clk
+}
+
+// clock returns the connection's clock
+func (c *Conn[T]) clock() Clock {
+	return clockOr(c.clk)
+}
+
+// clock returns the client's clock
+func (c *Client[T]) clock() Clock {
+	return clockOr(c.opts.Clock)
+}
+
+// systemClock implements Clock with the time package
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return systemTimer{time.AfterFunc(d, f)}
+}
+
+// systemTimer adapts a *time.Timer to Timer
+type systemTimer struct{ t *time.Timer }
+
+func (t systemTimer) C() <-chan time.Time        { return t.t.C }
+func (t systemTimer) Stop() bool                 { return t.t.Stop() }
+func (t systemTimer) Reset(d time.Duration) bool { return t.t.Reset(d) }
+
+// systemTicker adapts a *time.Ticker to Ticker
+type systemTicker struct{ t *time.Ticker }
+
+func (t systemTicker) C() <-chan time.Time   { return t.t.C }
+func (t systemTicker) Stop()                 { t.t.Stop() }
+func (t systemTicker) Reset(d time.Duration) { t.t.Reset(d) }
+
+// FakeClock is a Clock that only moves when told to, for deterministic
+// tests. Timers and tickers fire, in order, as Advance passes them.
+type FakeClock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	pending []*fakeTimer
+}
+
+// NewFakeClock creates a FakeClock reading now
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{now: now}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now returns the clock's current time
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d, firing every timer and ticker due
+// on the way
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	target := c.now.Add(d)
+	for len(c.pending) > 0 && !c.pending[0].when.After(target) {
+		t := c.pending[0]
+		c.now = t.when
+		if t.period > 0 {
+			t.when = t.when.Add(t.period)
+			c.sort()
+		} else {
+			c.remove(t)
+		}
+		t.fire(c.now)
+	}
+	c.now = target
+}
+
+// BlockUntil waits until at least n timers and tickers are pending, so a
+// test can advance the clock once the code under test is waiting on it
+func (c *FakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.pending) < n {
+		c.cond.Wait()
+	}
+}
+
+// NewTimer creates a timer that fires once the clock passes d from now
+func (c *FakeClock) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{clock: c, ch: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+// NewTicker creates a ticker that fires every d of clock time
+func (c *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("axon: non-positive interval for NewTicker")
+	}
+	t := &fakeTimer{clock: c, period: d, ch: make(chan time.Time, 1)}
+	t.Reset(d)
+	return fakeTicker{t}
+}
+
+// AfterFunc calls f in its own goroutine once the clock passes d from now
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	t := &fakeTimer{clock: c, fn: f}
+	t.Reset(d)
+	return t
+}
+
+// add schedules t; c.mu must be held
+func (c *FakeClock) add(t *fakeTimer) {
+	c.pending = append(c.pending, t)
+	c.sort()
+	c.cond.Broadcast()
+}
+
+// remove unschedules t, reporting whether it was pending; c.mu must be
+// held
+func (c *FakeClock) remove(t *fakeTimer) bool {
+	for i, p := range c.pending {
+		if p == t {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			c.cond.Broadcast()
+			return true
+		}
+	}
+	return false
+}
+
+// sort orders the pending timers by when they fire; c.mu must be held
+func (c *FakeClock) sort() {
+	sort.SliceStable(c.pending, func(i, j int) bool {
+		return c.pending[i].when.Before(c.pending[j].when)
+	})
+}
+
+// fakeTimer is a timer or ticker of a FakeClock
+type fakeTimer struct {
+	clock  *FakeClock
+	when   time.Time
+	period time.Duration // Non-zero for tickers
+	ch     chan time.Time
+	fn     func()
+}
+
+// fire delivers a tick, dropping it if the last one was not received
+func (t *fakeTimer) fire(now time.Time) {
+	if t.fn != nil {
+		go t.fn()
+		return
+	}
+	select {
+	case t.ch <- now:
+	default:
+	}
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.ch }
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.clock.remove(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	active := t.clock.remove(t)
+	t.when = t.clock.now.Add(d)
+	if d <= 0 && t.period == 0 {
+		// Like time.Timer, a timer that is already due fires at once
+		t.fire(t.when)
+		return active
+	}
+	t.clock.add(t)
+	return active
+}
+
+// fakeTicker is a repeating fakeTimer
+type fakeTicker struct{ t *fakeTimer }
+
+func (t fakeTicker) C() <-chan time.Time { return t.t.ch }
+func (t fakeTicker) Stop()               { t.t.Stop() }
+
+func (t fakeTicker) Reset(d time.Duration) {
+	t.t.clock.mu.Lock()
+	t.t.period = d
+	t.t.clock.mu.Unlock()
+	t.t.Reset(d)
+}
@@ -0,0 +1,106 @@
+package axon_test
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Unix(0, 0)
+	clock := axon.NewFakeClock(start)
+
+	timer := clock.NewTimer(2 * time.Second)
+	ticker := clock.NewTicker(time.Second)
+	fired := make(chan time.Time, 1)
+	clock.AfterFunc(3*time.Second, func() { fired <- clock.Now() })
+
+	clock.Advance(time.Second)
+	if got := <-ticker.C(); !got.Equal(start.Add(time.Second)) {
+		t.Errorf("tick at %v, want 1s", got.Sub(start))
+	}
+	select {
+	case <-timer.C():
+		t.Fatal("timer fired early")
+	default:
+	}
+
+	clock.Advance(time.Second)
+	if got := <-timer.C(); !got.Equal(start.Add(2 * time.Second)) {
+		t.Errorf("timer fired at %v, want 2s", got.Sub(start))
+	}
+	if timer.Stop() {
+		t.Error("Stop() of a fired timer reported it active")
+	}
+
+	clock.Advance(5 * time.Second)
+	if got := <-fired; !got.Equal(start.Add(7 * time.Second)) {
+		t.Errorf("AfterFunc ran at %v, want 7s", got.Sub(start))
+	}
+	if got := clock.Now(); !got.Equal(start.Add(7 * time.Second)) {
+		t.Errorf("Now() = %v, want 7s", got.Sub(start))
+	}
+
+	// Ticks not received are dropped rather than queued
+	<-ticker.C()
+	ticker.Stop()
+	clock.Advance(time.Hour)
+	select {
+	case <-ticker.C():
+		t.Error("stopped ticker fired")
+	default:
+	}
+}
+
+func TestConn_IdleTimeoutFakeClock(t *testing.T) {
+	clock := axon.NewFakeClock(time.Unix(0, 0))
+	conn, clientConn, err := axon.NewTestConn[string](&axon.UpgradeOptions{
+		IdleTimeout: time.Minute,
+		Clock:       clock,
+	})
+	if err != nil {
+		t.Fatalf("failed to create test connection: %v", err)
+	}
+	defer clientConn.Close()
+
+	clock.BlockUntil(1)
+	clock.Advance(59 * time.Second)
+	if conn.IsClosed() {
+		t.Fatal("connection closed before the idle timeout")
+	}
+	clock.Advance(time.Second)
+
+	opcode, payload := readHubFrame(t, clientConn)
+	if opcode != 0x8 || binary.BigEndian.Uint16(payload) != uint16(axon.CloseGoingAway) {
+		t.Fatalf("expected a going away close frame, got opcode %d payload %q", opcode, payload)
+	}
+}
+
+func TestMessageQueue_ExpiryFakeClock(t *testing.T) {
+	clock := axon.NewFakeClock(time.Unix(0, 0))
+	mq := axon.NewMessageQueue[string](10, time.Minute, 1, nil)
+	mq.SetClock(clock)
+
+	expired, _ := mq.Enqueue(context.Background(), "old")
+	clock.Advance(45 * time.Second)
+	fresh, _ := mq.Enqueue(context.Background(), "new")
+	clock.Advance(30 * time.Second)
+
+	var sent []string
+	mq.Flush(func(ctx context.Context, msg string) error {
+		sent = append(sent, msg)
+		return nil
+	})
+	if len(sent) != 1 || sent[0] != "new" {
+		t.Errorf("sent %v, want [new]", sent)
+	}
+	if err := <-expired; err != axon.ErrQueueTimeout {
+		t.Errorf("expired message result = %v, want ErrQueueTimeout", err)
+	}
+	if err := <-fresh; err != nil {
+		t.Errorf("fresh message result = %v, want nil", err)
+	}
+}
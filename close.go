@@ -71,7 +71,7 @@ func (c CloseCode) String() string {
 // IsReserved returns true if this is a reserved close code that must not be sent in close frames.
 func (c CloseCode) IsReserved() bool {
 	switch c {
-	case CloseNoStatusReceived, CloseAbnormalClosure, CloseTLSHandshake:
+	case 1004, CloseNoStatusReceived, CloseAbnormalClosure, CloseTLSHandshake: // 1004 is reserved for future use
 		return true
 	default:
 		return false
@@ -72,6 +72,7 @@ func TestCloseCodeIsValid(t *testing.T) {
 		axon.CloseNoStatusReceived, // Reserved
 		axon.CloseAbnormalClosure,  // Reserved
 		axon.CloseTLSHandshake,     // Reserved
+		axon.CloseCode(1004),       // Reserved
 		axon.CloseCode(999),        // Out of range
 		axon.CloseCode(2999),       // Out of range
 		axon.CloseCode(5000),       // Out of range
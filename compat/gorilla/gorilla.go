@@ -0,0 +1,547 @@
+// Package gorilla adapts axon connections to the API of
+// github.com/gorilla/websocket, so that code written against it can move to
+// axon one call site at a time. Upgrader, Dialer and Conn mirror their
+// gorilla counterparts; features without an axon equivalent are noted on
+// the fields and methods concerned.
+package gorilla
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+// Message types, with the values of RFC 6455 opcodes
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+)
+
+// Close codes defined in RFC 6455 Section 7.4.1
+const (
+	CloseNormalClosure           = 1000
+	CloseGoingAway               = 1001
+	CloseProtocolError           = 1002
+	CloseUnsupportedData         = 1003
+	CloseNoStatusReceived        = 1005
+	CloseAbnormalClosure         = 1006
+	CloseInvalidFramePayloadData = 1007
+	ClosePolicyViolation         = 1008
+	CloseMessageTooBig           = 1009
+	CloseMandatoryExtension      = 1010
+	CloseInternalServerErr       = 1011
+	CloseServiceRestart          = 1012
+	CloseTryAgainLater           = 1013
+	CloseTLSHandshake            = 1015
+)
+
+var (
+	// ErrCloseSent is returned by writes after a close message was sent
+	ErrCloseSent = errors.New("axon: close sent")
+
+	// ErrReadLimit is returned by reads of a message over the read limit
+	ErrReadLimit = errors.New("axon: read limit exceeded")
+
+	// ErrBadHandshake is returned by Dial when the server does not switch
+	// protocols
+	ErrBadHandshake = errors.New("axon: bad handshake")
+)
+
+// noDeadline bounds operations without a deadline, as axon defaults to a
+// 30 second timeout for each read and write
+const noDeadline = 100 * 365 * 24 * time.Hour
+
+// maxSize lifts axon's frame and message size limits; SetReadLimit applies
+// the limit instead
+const maxSize = 1<<31 - 1
+
+// CloseError is returned by reads once the peer closed the connection
+type CloseError struct {
+	Code int
+	Text string
+}
+
+// Error returns the error message
+func (e *CloseError) Error() string {
+	if e.Text == "" {
+		return fmt.Sprintf("axon: close %d", e.Code)
+	}
+	return fmt.Sprintf("axon: close %d: %s", e.Code, e.Text)
+}
+
+// IsCloseError reports whether err is a *CloseError with one of codes
+func IsCloseError(err error, codes ...int) bool {
+	var e *CloseError
+	if !errors.As(err, &e) {
+		return false
+	}
+	for _, code := range codes {
+		if e.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// IsUnexpectedCloseError reports whether err is a *CloseError with none of
+// expectedCodes
+func IsUnexpectedCloseError(err error, expectedCodes ...int) bool {
+	var e *CloseError
+	if !errors.As(err, &e) {
+		return false
+	}
+	for _, code := range expectedCodes {
+		if e.Code == code {
+			return false
+		}
+	}
+	return true
+}
+
+// FormatCloseMessage formats code and text as the payload of a close
+// message
+func FormatCloseMessage(code int, text string) []byte {
+	if code == CloseNoStatusReceived {
+		return []byte{}
+	}
+	buf := binary.BigEndian.AppendUint16(nil, uint16(code))
+	return append(buf, text...)
+}
+
+// Upgrader upgrades HTTP requests like gorilla's Upgrader
+type Upgrader struct {
+	// HandshakeTimeout is accepted for compatibility and unused; the
+	// handshake completes within the HTTP request
+	HandshakeTimeout time.Duration
+
+	// ReadBufferSize and WriteBufferSize size the connection's buffers
+	ReadBufferSize  int
+	WriteBufferSize int
+
+	// Subprotocols lists the supported subprotocols in order of preference
+	Subprotocols []string
+
+	// Error writes the HTTP error response of a failed upgrade. If nil,
+	// http.Error is used.
+	Error func(w http.ResponseWriter, r *http.Request, status int, reason error)
+
+	// CheckOrigin reports whether the request's Origin is acceptable. If
+	// nil, only requests without an Origin or from the same host are.
+	CheckOrigin func(r *http.Request) bool
+
+	// EnableCompression negotiates permessage-deflate with the client
+	EnableCompression bool
+}
+
+// Upgrade upgrades the request to a WebSocket connection, sending
+// responseHeader with the 101 response. Failed upgrades are answered with
+// an HTTP error before the error is returned.
+func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request, responseHeader http.Header) (*Conn, error) {
+	checkOrigin := u.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = checkSameOrigin
+	}
+	for k, v := range responseHeader {
+		w.Header()[k] = v
+	}
+
+	conn, err := axon.Upgrade[[]byte](w, r, &axon.UpgradeOptions{
+		ReadBufferSize:  u.ReadBufferSize,
+		WriteBufferSize: u.WriteBufferSize,
+		MaxFrameSize:    maxSize,
+		MaxMessageSize:  maxSize,
+		Subprotocols:    u.Subprotocols,
+		CheckOrigin:     checkOrigin,
+		Compression:     u.EnableCompression,
+		UpgradeError:    u.Error,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newConn(conn), nil
+}
+
+// checkSameOrigin accepts requests without an Origin or whose Origin has
+// the request's host
+func checkSameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// Dialer dials WebSocket servers like gorilla's Dialer
+type Dialer struct {
+	// NetDialContext dials network connections. NetDial is used if it is
+	// nil, and net.Dialer if both are.
+	NetDialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+	NetDial        func(network, addr string) (net.Conn, error)
+
+	// Proxy returns the proxy for a request, or nil for none
+	Proxy func(*http.Request) (*url.URL, error)
+
+	// TLSClientConfig configures wss:// connections
+	TLSClientConfig *tls.Config
+
+	// HandshakeTimeout bounds the handshake
+	HandshakeTimeout time.Duration
+
+	// ReadBufferSize and WriteBufferSize size the connection's buffers
+	ReadBufferSize  int
+	WriteBufferSize int
+
+	// Subprotocols lists the subprotocols offered to the server
+	Subprotocols []string
+
+	// EnableCompression offers permessage-deflate to the server
+	EnableCompression bool
+}
+
+// DefaultDialer is a Dialer using the environment's proxy settings
+var DefaultDialer = &Dialer{
+	Proxy:            http.ProxyFromEnvironment,
+	HandshakeTimeout: 45 * time.Second,
+}
+
+// Dial connects to urlStr, sending requestHeader with the handshake. See
+// DialContext.
+func (d *Dialer) Dial(urlStr string, requestHeader http.Header) (*Conn, *http.Response, error) {
+	return d.DialContext(context.Background(), urlStr, requestHeader)
+}
+
+// DialContext connects to urlStr, sending requestHeader with the handshake;
+// a Host entry overrides the Host header. When the server refuses the
+// upgrade, its response is returned with ErrBadHandshake. On success the
+// response is a 101 carrying only the negotiated subprotocol, as axon does
+// not keep the handshake response.
+func (d *Dialer) DialContext(ctx context.Context, urlStr string, requestHeader http.Header) (*Conn, *http.Response, error) {
+	if d == nil {
+		d = &Dialer{}
+	}
+
+	headers := requestHeader.Clone()
+	host := headers.Get("Host")
+	headers.Del("Host")
+
+	opts := &axon.DialOptions{
+		HandshakeTimeout: d.HandshakeTimeout,
+		ReadBufferSize:   d.ReadBufferSize,
+		WriteBufferSize:  d.WriteBufferSize,
+		MaxFrameSize:     maxSize,
+		MaxMessageSize:   maxSize,
+		Subprotocols:     d.Subprotocols,
+		Compression:      d.EnableCompression,
+		Headers:          headers,
+		Host:             host,
+		TLSConfig:        d.TLSClientConfig,
+		Proxy:            d.Proxy,
+		DialContext:      d.NetDialContext,
+	}
+	if opts.Proxy == nil {
+		opts.Proxy = func(*http.Request) (*url.URL, error) { return nil, nil }
+	}
+	if opts.DialContext == nil && d.NetDial != nil {
+		netDial := d.NetDial
+		opts.DialContext = func(_ context.Context, network, addr string) (net.Conn, error) {
+			return netDial(network, addr)
+		}
+	}
+
+	conn, err := axon.Dial[[]byte](ctx, urlStr, opts)
+	if err != nil {
+		var hsErr *axon.HandshakeError
+		if errors.As(err, &hsErr) {
+			return nil, hsErr.Response, ErrBadHandshake
+		}
+		return nil, nil, err
+	}
+
+	resp := &http.Response{
+		Status:     "101 Switching Protocols",
+		StatusCode: http.StatusSwitchingProtocols,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+		Body:       http.NoBody,
+	}
+	if sub := conn.Subprotocol(); sub != "" {
+		resp.Header.Set("Sec-WebSocket-Protocol", sub)
+	}
+	return newConn(conn), resp, nil
+}
+
+// Conn is a WebSocket connection with gorilla's API. Like gorilla's, it
+// supports one concurrent reader and one concurrent writer.
+type Conn struct {
+	conn *axon.Conn[[]byte]
+
+	mu            sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+	readLimit     int64
+	closeHandler  func(code int, text string) error
+	closeSent     bool
+}
+
+// newConn wraps an axon connection
+func newConn(conn *axon.Conn[[]byte]) *Conn {
+	return &Conn{conn: conn}
+}
+
+// Axon returns the underlying axon connection
+func (c *Conn) Axon() *axon.Conn[[]byte] {
+	return c.conn
+}
+
+// Subprotocol returns the negotiated subprotocol
+func (c *Conn) Subprotocol() string {
+	return c.conn.Subprotocol()
+}
+
+// RemoteAddr returns the remote network address
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// LocalAddr returns the local network address
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// SetReadDeadline sets the deadline for reads; the zero value means none
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.mu.Unlock()
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for writes; the zero value means none
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.writeDeadline = t
+	c.mu.Unlock()
+	return nil
+}
+
+// SetReadLimit sets the largest message size read. A larger message closes
+// the connection with CloseMessageTooBig and fails the read with
+// ErrReadLimit. The limit is checked once the message has been read.
+func (c *Conn) SetReadLimit(limit int64) {
+	c.mu.Lock()
+	c.readLimit = limit
+	c.mu.Unlock()
+}
+
+// SetPingHandler sets the handler for pings, which replaces the automatic
+// pong. A nil h restores it.
+func (c *Conn) SetPingHandler(h func(appData string) error) {
+	if h == nil {
+		c.conn.SetPingHandler(nil)
+		return
+	}
+	c.conn.SetPingHandler(func(data []byte) error { return h(string(data)) })
+}
+
+// SetPongHandler sets the handler for pongs
+func (c *Conn) SetPongHandler(h func(appData string) error) {
+	if h == nil {
+		c.conn.SetPongHandler(nil)
+		return
+	}
+	c.conn.SetPongHandler(func(data []byte) error { return h(string(data)) })
+}
+
+// SetCloseHandler sets the handler called when a read finds the peer
+// closed the connection, before the read returns its *CloseError. axon
+// ends the connection itself, so h need not answer the close.
+func (c *Conn) SetCloseHandler(h func(code int, text string) error) {
+	c.mu.Lock()
+	c.closeHandler = h
+	c.mu.Unlock()
+}
+
+// ReadMessage reads the next data message
+func (c *Conn) ReadMessage() (messageType int, p []byte, err error) {
+	c.mu.Lock()
+	ctx, cancel := withDeadline(c.readDeadline)
+	limit := c.readLimit
+	c.mu.Unlock()
+	defer cancel()
+
+	mt, data, err := c.conn.ReadMessage(ctx)
+	if err != nil {
+		return 0, nil, c.readError(err)
+	}
+	if limit > 0 && int64(len(data)) > limit {
+		c.conn.Close(CloseMessageTooBig, "")
+		return 0, nil, ErrReadLimit
+	}
+	return int(mt), data, nil
+}
+
+// NextReader reads the next data message and returns a reader over it
+func (c *Conn) NextReader() (messageType int, r io.Reader, err error) {
+	mt, data, err := c.ReadMessage()
+	if err != nil {
+		return 0, nil, err
+	}
+	return mt, bytes.NewReader(data), nil
+}
+
+// ReadJSON reads the next message and decodes it as JSON into v
+func (c *Conn) ReadJSON(v any) error {
+	_, data, err := c.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// readError converts a read error into the form gorilla returns, calling
+// the close handler when the peer closed the connection
+func (c *Conn) readError(err error) error {
+	var closeErr *axon.CloseError
+	switch {
+	case errors.As(err, &closeErr):
+		e := &CloseError{Code: int(closeErr.Code), Text: closeErr.Reason}
+		c.mu.Lock()
+		h := c.closeHandler
+		c.mu.Unlock()
+		if h != nil {
+			if err := h(e.Code, e.Text); err != nil {
+				return err
+			}
+		}
+		return e
+	case errors.Is(err, axon.ErrConnectionClosed):
+		return &CloseError{Code: CloseAbnormalClosure, Text: io.ErrUnexpectedEOF.Error()}
+	}
+	return err
+}
+
+// WriteMessage writes a message of any type. Close messages close the
+// connection after they are sent.
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	c.mu.Lock()
+	deadline := c.writeDeadline
+	closeSent := c.closeSent
+	c.mu.Unlock()
+
+	switch messageType {
+	case CloseMessage, PingMessage, PongMessage:
+		return c.WriteControl(messageType, data, deadline)
+	}
+	if closeSent {
+		return ErrCloseSent
+	}
+
+	ctx, cancel := withDeadline(deadline)
+	defer cancel()
+	return c.conn.WriteMessage(ctx, axon.MessageType(messageType), data)
+}
+
+// NextWriter returns a writer for the next message, sent when the writer
+// is closed
+func (c *Conn) NextWriter(messageType int) (io.WriteCloser, error) {
+	return &messageWriter{conn: c, messageType: messageType}, nil
+}
+
+// WriteJSON writes v encoded as JSON in a text message
+func (c *Conn) WriteJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(TextMessage, data)
+}
+
+// WriteControl writes a close, ping or pong message with the given
+// deadline; the zero value means none
+func (c *Conn) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	ctx, cancel := withDeadline(deadline)
+	defer cancel()
+
+	c.mu.Lock()
+	closeSent := c.closeSent
+	if messageType == CloseMessage {
+		c.closeSent = true
+	}
+	c.mu.Unlock()
+	if closeSent {
+		return ErrCloseSent
+	}
+
+	switch messageType {
+	case PingMessage:
+		return c.conn.Ping(ctx, data)
+	case PongMessage:
+		return c.conn.Pong(ctx, data)
+	case CloseMessage:
+		code, reason := CloseNoStatusReceived, ""
+		if len(data) >= 2 {
+			code = int(binary.BigEndian.Uint16(data))
+			reason = string(data[2:])
+		}
+		return c.conn.Close(code, reason)
+	}
+	return fmt.Errorf("axon: invalid control message type %d", messageType)
+}
+
+// Close closes the connection
+func (c *Conn) Close() error {
+	return c.conn.Close(CloseNormalClosure, "")
+}
+
+// messageWriter buffers a message for NextWriter
+type messageWriter struct {
+	conn        *Conn
+	messageType int
+	buf         bytes.Buffer
+	closed      bool
+}
+
+// Write appends p to the message
+func (w *messageWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("axon: write to closed writer")
+	}
+	return w.buf.Write(p)
+}
+
+// Close sends the message
+func (w *messageWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.conn.WriteMessage(w.messageType, w.buf.Bytes())
+}
+
+// withDeadline returns a context ending at deadline, or after noDeadline if
+// it is zero
+func withDeadline(deadline time.Time) (context.Context, context.CancelFunc) {
+	if deadline.IsZero() {
+		deadline = time.Now().Add(noDeadline)
+	}
+	return context.WithDeadline(context.Background(), deadline)
+}
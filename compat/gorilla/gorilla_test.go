@@ -0,0 +1,225 @@
+package gorilla_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon/compat/gorilla"
+)
+
+// echoServer upgrades with the gorilla API and echoes messages until the
+// client closes
+func echoServer(t *testing.T, upgrader *gorilla.Upgrader) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := http.Header{"Set-Cookie": {"session=abc"}}
+		conn, err := upgrader.Upgrade(w, r, header)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			mt, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(mt, data); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func dial(t *testing.T, url string, d *gorilla.Dialer) (*gorilla.Conn, *http.Response) {
+	t.Helper()
+	conn, resp, err := d.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return conn, resp
+}
+
+func TestEcho(t *testing.T) {
+	url := echoServer(t, &gorilla.Upgrader{Subprotocols: []string{"chat"}})
+	conn, resp := dial(t, url, &gorilla.Dialer{Subprotocols: []string{"chat"}})
+
+	if resp.StatusCode != http.StatusSwitchingProtocols || conn.Subprotocol() != "chat" {
+		t.Errorf("response status = %d, subprotocol = %q", resp.StatusCode, conn.Subprotocol())
+	}
+
+	if err := conn.WriteMessage(gorilla.TextMessage, []byte("hello")); err != nil {
+		t.Fatalf("WriteMessage() error = %v", err)
+	}
+	mt, data, err := conn.ReadMessage()
+	if err != nil || mt != gorilla.TextMessage || string(data) != "hello" {
+		t.Fatalf("ReadMessage() = %d, %q, %v", mt, data, err)
+	}
+
+	type greeting struct {
+		Name string `json:"name"`
+	}
+	if err := conn.WriteJSON(greeting{Name: "axon"}); err != nil {
+		t.Fatalf("WriteJSON() error = %v", err)
+	}
+	var got greeting
+	if err := conn.ReadJSON(&got); err != nil || got.Name != "axon" {
+		t.Fatalf("ReadJSON() = %+v, %v", got, err)
+	}
+
+	w, err := conn.NextWriter(gorilla.BinaryMessage)
+	if err != nil {
+		t.Fatalf("NextWriter() error = %v", err)
+	}
+	w.Write([]byte("split "))
+	w.Write([]byte("message"))
+	if err := w.Close(); err != nil {
+		t.Fatalf("writer Close() error = %v", err)
+	}
+	if mt, data, err := conn.ReadMessage(); err != nil || mt != gorilla.BinaryMessage || string(data) != "split message" {
+		t.Fatalf("ReadMessage() = %d, %q, %v", mt, data, err)
+	}
+}
+
+func TestUpgrade_ResponseHeader(t *testing.T) {
+	url := echoServer(t, &gorilla.Upgrader{})
+
+	// The synthesized dial response lacks the server's headers, so shake
+	// hands by hand
+	req, _ := http.NewRequest(http.MethodGet, "http"+strings.TrimPrefix(url, "ws"), nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	raw, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("handshake error = %v", err)
+	}
+	defer raw.Body.Close()
+	if raw.StatusCode != http.StatusSwitchingProtocols || raw.Header.Get("Set-Cookie") != "session=abc" {
+		t.Errorf("handshake = %d with Set-Cookie %q", raw.StatusCode, raw.Header.Get("Set-Cookie"))
+	}
+}
+
+func TestUpgrade_CheckOrigin(t *testing.T) {
+	url := echoServer(t, &gorilla.Upgrader{})
+
+	_, resp, err := (&gorilla.Dialer{}).Dial(url, http.Header{"Origin": {"https://elsewhere.example"}})
+	if err != gorilla.ErrBadHandshake {
+		t.Fatalf("Dial() from another origin error = %v, want ErrBadHandshake", err)
+	}
+	if resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("response = %+v, want 403", resp)
+	}
+
+	host := strings.TrimPrefix(url, "ws://")
+	conn, _, err := (&gorilla.Dialer{}).Dial(url, http.Header{"Origin": {"http://" + host}})
+	if err != nil {
+		t.Fatalf("Dial() from the same origin error = %v", err)
+	}
+	conn.Close()
+}
+
+func TestConn_PingPong(t *testing.T) {
+	url := echoServer(t, &gorilla.Upgrader{})
+	conn, _ := dial(t, url, &gorilla.Dialer{})
+
+	pongs := make(chan string, 1)
+	conn.SetPongHandler(func(appData string) error {
+		pongs <- appData
+		return nil
+	})
+	if err := conn.WriteControl(gorilla.PingMessage, []byte("are you there"), time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("WriteControl() error = %v", err)
+	}
+	// Pongs are handled while reading
+	if err := conn.WriteMessage(gorilla.TextMessage, []byte("x")); err != nil {
+		t.Fatalf("WriteMessage() error = %v", err)
+	}
+	if _, _, err := conn.ReadMessage(); err != nil {
+		t.Fatalf("ReadMessage() error = %v", err)
+	}
+	select {
+	case data := <-pongs:
+		if data != "are you there" {
+			t.Errorf("pong data = %q", data)
+		}
+	default:
+		t.Fatal("pong handler not called")
+	}
+}
+
+func TestConn_CloseError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&gorilla.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		msg := gorilla.FormatCloseMessage(gorilla.CloseGoingAway, "restarting")
+		conn.WriteMessage(gorilla.CloseMessage, msg)
+		if err := conn.WriteMessage(gorilla.TextMessage, []byte("late")); err != gorilla.ErrCloseSent {
+			t.Errorf("WriteMessage() after close error = %v, want ErrCloseSent", err)
+		}
+	}))
+	defer server.Close()
+
+	conn, _ := dial(t, "ws"+strings.TrimPrefix(server.URL, "http"), &gorilla.Dialer{})
+	var handled int
+	conn.SetCloseHandler(func(code int, text string) error {
+		handled = code
+		return nil
+	})
+
+	_, _, err := conn.ReadMessage()
+	if !gorilla.IsCloseError(err, gorilla.CloseGoingAway) {
+		t.Fatalf("ReadMessage() error = %v, want close 1001", err)
+	}
+	if gorilla.IsUnexpectedCloseError(err, gorilla.CloseGoingAway, gorilla.CloseNormalClosure) {
+		t.Error("IsUnexpectedCloseError() = true for an expected code")
+	}
+	if e := err.(*gorilla.CloseError); e.Text != "restarting" || handled != gorilla.CloseGoingAway {
+		t.Errorf("close error = %+v, handler saw %d", e, handled)
+	}
+}
+
+func TestConn_ReadLimit(t *testing.T) {
+	url := echoServer(t, &gorilla.Upgrader{})
+	conn, _ := dial(t, url, &gorilla.Dialer{})
+	conn.SetReadLimit(4)
+
+	if err := conn.WriteMessage(gorilla.TextMessage, []byte("too long")); err != nil {
+		t.Fatalf("WriteMessage() error = %v", err)
+	}
+	if _, _, err := conn.ReadMessage(); err != gorilla.ErrReadLimit {
+		t.Fatalf("ReadMessage() error = %v, want ErrReadLimit", err)
+	}
+}
+
+func TestConn_LargeMessage(t *testing.T) {
+	url := echoServer(t, &gorilla.Upgrader{})
+	conn, _ := dial(t, url, &gorilla.Dialer{})
+
+	// Larger than axon's default frame and message limits
+	big := strings.Repeat("x", 2<<20)
+	if err := conn.WriteMessage(gorilla.TextMessage, []byte(big)); err != nil {
+		t.Fatalf("WriteMessage() error = %v", err)
+	}
+	if _, data, err := conn.ReadMessage(); err != nil || len(data) != len(big) {
+		t.Fatalf("ReadMessage() = %d bytes, %v", len(data), err)
+	}
+}
+
+func TestFormatCloseMessage(t *testing.T) {
+	if got := gorilla.FormatCloseMessage(gorilla.CloseNormalClosure, "bye"); string(got) != "\x03\xe8bye" {
+		t.Errorf("FormatCloseMessage() = %q", got)
+	}
+	if got := gorilla.FormatCloseMessage(gorilla.CloseNoStatusReceived, ""); len(got) != 0 {
+		t.Errorf("FormatCloseMessage(1005) = %q, want empty", got)
+	}
+}
@@ -4,34 +4,371 @@ import (
 	"bytes"
 	"compress/flate"
 	"io"
+	"strconv"
 	"sync"
 )
 
+const (
+	// permessageDeflate is the extension token for RFC 7692 compression
+	permessageDeflate = "permessage-deflate"
+
+	// Window size limits for the LZ77 sliding window (RFC 7692 Section 7.1.2)
+	minWindowBits = 8
+	maxWindowBits = 15
+)
+
+// deflateTail is appended to compressed messages before inflating: the
+// sync-flush marker stripped by the sender (RFC 7692 Section 7.2.2), followed
+// by an empty final stored block so the reader terminates with io.EOF.
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+// CompressionStrategy selects how flate compressors are allocated
+type CompressionStrategy int
+
+const (
+	// CompressionPerConnection gives each connection its own compressor,
+	// allowing context takeover for the best compression ratio. If context
+	// takeover is not negotiated, compressors are pooled regardless.
+	CompressionPerConnection CompressionStrategy = iota
+
+	// CompressionPooled borrows a compressor from a package-level pool for
+	// each message. Context takeover is disabled for the local compressor,
+	// trading ratio for a much smaller per-connection memory footprint.
+	CompressionPooled
+)
+
+// compressionConfig holds the local compression settings that are not
+// negotiated with the peer
+type compressionConfig struct {
+	threshold int
+	level     int
+	strategy  CompressionStrategy
+}
+
+// newCompressionConfig applies defaults to the user-supplied settings
+func newCompressionConfig(threshold, level int, strategy CompressionStrategy) compressionConfig {
+	if threshold <= 0 {
+		threshold = 256
+	}
+	// flate.NoCompression is pointless for permessage-deflate, so the zero
+	// value selects the default and out-of-range levels fall back to it
+	if level == flate.NoCompression || level < flate.HuffmanOnly || level > flate.BestCompression {
+		level = flate.BestSpeed
+	}
+	return compressionConfig{
+		threshold: threshold,
+		level:     level,
+		strategy:  strategy,
+	}
+}
+
+// deflateParams holds the negotiated permessage-deflate parameters
+type deflateParams struct {
+	serverNoContextTakeover bool
+	clientNoContextTakeover bool
+	serverMaxWindowBits     int // 0 means the default of 15
+	clientMaxWindowBits     int // 0 means the default of 15
+
+	// clientMaxWindowBitsSupported records that the client offered
+	// client_max_window_bits, so the server may limit the client's window
+	clientMaxWindowBitsSupported bool
+}
+
+// clientOffers returns the permessage-deflate offers of a client, in order
+// of preference. A client asking the server to limit its window also offers
+// plain permessage-deflate as a fallback for servers that cannot.
+// client_max_window_bits is deliberately not offered because compress/flate
+// cannot limit its compression window below 15 bits.
+func (p deflateParams) clientOffers() []Extension {
+	offers := []Extension{p.extension()}
+	if p.serverMaxWindowBits != 0 {
+		fallback := p
+		fallback.serverMaxWindowBits = 0
+		offers = append(offers, fallback.extension())
+	}
+	return offers
+}
+
+// parseDeflateResponse parses the extensions of the server's response
+// against the client's offers. It reports whether compression was accepted
+// and returns ErrInvalidHandshake if the response is malformed or asks for
+// parameters the client did not offer. Extensions other than
+// permessage-deflate are ignored.
+func parseDeflateResponse(extensions []Extension, offer deflateParams) (deflateParams, bool, error) {
+	var params deflateParams
+	accepted := false
+
+	for _, ext := range extensions {
+		if ext.Name != permessageDeflate {
+			continue
+		}
+		if accepted {
+			// The server must not accept the same extension twice
+			return deflateParams{}, false, ErrInvalidHandshake
+		}
+		accepted = true
+
+		for _, param := range ext.Params {
+			switch param.Name {
+			case "server_no_context_takeover":
+				if param.Value != "" {
+					return deflateParams{}, false, ErrInvalidHandshake
+				}
+				params.serverNoContextTakeover = true
+			case "server_max_window_bits":
+				// Any window is acceptable, since the fallback offer sets
+				// no limit and the decompressor handles 15 bits
+				bits, ok := parseWindowBits(param.Value)
+				if !ok {
+					return deflateParams{}, false, ErrInvalidHandshake
+				}
+				params.serverMaxWindowBits = bits
+			case "client_no_context_takeover":
+				if param.Value != "" {
+					return deflateParams{}, false, ErrInvalidHandshake
+				}
+				params.clientNoContextTakeover = true
+			case "client_max_window_bits":
+				// Not offered, so the server must not send it
+				return deflateParams{}, false, ErrInvalidHandshake
+			default:
+				return deflateParams{}, false, ErrInvalidHandshake
+			}
+		}
+	}
+
+	// The client always honors a request to drop its own context
+	params.clientNoContextTakeover = params.clientNoContextTakeover || offer.clientNoContextTakeover
+
+	return params, accepted, nil
+}
+
+// parseDeflateOffers returns the permessage-deflate offers among a client's
+// extensions that the server can accept, in the client's order of
+// preference. Offers with unknown or malformed parameters, or that require
+// a compression window below 15 bits, are skipped.
+func parseDeflateOffers(extensions []Extension) []deflateParams {
+	var offers []deflateParams
+
+offers:
+	for _, ext := range extensions {
+		if ext.Name != permessageDeflate {
+			continue
+		}
+
+		var params deflateParams
+		seen := make(map[string]bool, len(ext.Params))
+		for _, param := range ext.Params {
+			// Each parameter may appear at most once (RFC 7692 Section 7)
+			if seen[param.Name] {
+				continue offers
+			}
+			seen[param.Name] = true
+
+			switch param.Name {
+			case "server_no_context_takeover":
+				if param.Value != "" {
+					continue offers
+				}
+				params.serverNoContextTakeover = true
+			case "client_no_context_takeover":
+				if param.Value != "" {
+					continue offers
+				}
+				params.clientNoContextTakeover = true
+			case "server_max_window_bits":
+				bits, ok := parseWindowBits(param.Value)
+				// compress/flate always uses a 32KB window
+				if !ok || bits != maxWindowBits {
+					continue offers
+				}
+				params.serverMaxWindowBits = bits
+			case "client_max_window_bits":
+				// The client supports limiting its window; a value, if any,
+				// is only a hint and the server's decompressor handles 15 bits
+				if param.Value != "" {
+					if _, ok := parseWindowBits(param.Value); !ok {
+						continue offers
+					}
+				}
+				params.clientMaxWindowBitsSupported = true
+			default:
+				continue offers
+			}
+		}
+
+		offers = append(offers, params)
+	}
+
+	return offers
+}
+
+// serverResponse returns the Sec-WebSocket-Extensions value sent by a server
+// accepting an offer with these parameters
+func (p deflateParams) serverResponse() string {
+	return p.extension().String()
+}
+
+// extension returns the permessage-deflate extension with these parameters
+func (p deflateParams) extension() Extension {
+	ext := Extension{Name: permessageDeflate}
+	if p.serverNoContextTakeover {
+		ext.Params = append(ext.Params, ExtensionParam{Name: "server_no_context_takeover"})
+	}
+	if p.clientNoContextTakeover {
+		ext.Params = append(ext.Params, ExtensionParam{Name: "client_no_context_takeover"})
+	}
+	if p.serverMaxWindowBits != 0 {
+		ext.Params = append(ext.Params, ExtensionParam{Name: "server_max_window_bits", Value: strconv.Itoa(p.serverMaxWindowBits)})
+	}
+	if p.clientMaxWindowBits != 0 {
+		ext.Params = append(ext.Params, ExtensionParam{Name: "client_max_window_bits", Value: strconv.Itoa(p.clientMaxWindowBits)})
+	}
+	return ext
+}
+
+// withServerPrefs applies the server's own preferences to an accepted offer.
+// The server may always disable context takeover, but can only limit the
+// client's window if the client offered client_max_window_bits.
+func (p deflateParams) withServerPrefs(prefs deflateParams) deflateParams {
+	p.serverNoContextTakeover = p.serverNoContextTakeover || prefs.serverNoContextTakeover
+	p.clientNoContextTakeover = p.clientNoContextTakeover || prefs.clientNoContextTakeover
+	if p.clientMaxWindowBitsSupported && prefs.clientMaxWindowBits != 0 {
+		p.clientMaxWindowBits = prefs.clientMaxWindowBits
+	}
+	return p
+}
+
+// parseWindowBits parses a max_window_bits value in the range 8-15
+func parseWindowBits(value string) (int, bool) {
+	bits, err := strconv.Atoi(value)
+	if err != nil || bits < minWindowBits || bits > maxWindowBits {
+		return 0, false
+	}
+	return bits, true
+}
+
+// maxPooledBufferSize caps the buffers returned to deflateBufferPool so a
+// single large message doesn't pin memory for every later borrower
+const maxPooledBufferSize = 64 * 1024
+
+// flateWriterPools holds reusable compressors for connections without
+// compressor context takeover, indexed by level offset by flate.HuffmanOnly
+var flateWriterPools [flate.BestCompression - flate.HuffmanOnly + 1]sync.Pool
+
+// flateReaderPool holds reusable decompressors for connections without
+// decompressor context takeover
+var flateReaderPool sync.Pool
+
+// deflateBufferPool holds scratch buffers for pooled compression
+var deflateBufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// getFlateWriter retrieves a compressor for the level from the pool
+func getFlateWriter(w io.Writer, level int) (*flate.Writer, error) {
+	if fw, ok := flateWriterPools[level-flate.HuffmanOnly].Get().(*flate.Writer); ok {
+		fw.Reset(w)
+		return fw, nil
+	}
+	return flate.NewWriter(w, level)
+}
+
+// putFlateWriter returns a compressor to the pool for its level
+func putFlateWriter(fw *flate.Writer, level int) {
+	flateWriterPools[level-flate.HuffmanOnly].Put(fw)
+}
+
+// getFlateReader retrieves a decompressor reading from r from the pool
+func getFlateReader(r io.Reader) (io.ReadCloser, error) {
+	if fr, ok := flateReaderPool.Get().(io.ReadCloser); ok {
+		if err := fr.(flate.Resetter).Reset(r, nil); err != nil {
+			return nil, err
+		}
+		return fr, nil
+	}
+	return flate.NewReader(r), nil
+}
+
+// putFlateReader returns a decompressor to the pool
+func putFlateReader(fr io.ReadCloser) {
+	flateReaderPool.Put(fr)
+}
+
+// getDeflateBuffer retrieves an empty scratch buffer from the pool
+func getDeflateBuffer() *bytes.Buffer {
+	buf := deflateBufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+// putDeflateBuffer returns a scratch buffer to the pool
+func putDeflateBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	deflateBufferPool.Put(buf)
+}
+
 // CompressionManager handles per-message compression (RFC 7692)
 type CompressionManager struct {
 	enabled   bool
 	threshold int // Minimum size to compress
+	level     int // flate compression level
 
-	// Compressor resources
+	// Context takeover keeps the LZ77 window across messages
+	compressTakeover   bool
+	decompressTakeover bool
+	decompressWindow   int // Bytes of history kept for decompression
+
+	// Compressor resources, unused when the compressor is borrowed from
+	// flateWriterPools for each message
 	compressorMu sync.Mutex
 	compressor   *flate.Writer
 	compressBuf  bytes.Buffer
 
-	// Decompressor resources
+	// Decompressor resources, unused when the decompressor is borrowed from
+	// flateReaderPool for each message
 	decompressorMu sync.Mutex
 	decompressor   io.ReadCloser
 	decompressBuf  bytes.Buffer
+	dict           []byte // Sliding window of previous decompressed output
 }
 
-// newCompressionManager creates a new CompressionManager
-func newCompressionManager(threshold int) *CompressionManager {
-	if threshold <= 0 {
-		threshold = 256
-	}
-	return &CompressionManager{
+// newCompressionManager creates a new CompressionManager for the negotiated
+// parameters, from the point of view of a client or server endpoint.
+// Directions without context takeover carry no state between messages, so
+// they borrow pooled flate state instead of holding their own.
+func newCompressionManager(cfg compressionConfig, params deflateParams, isClient bool) *CompressionManager {
+	cm := &CompressionManager{
 		enabled:   true,
-		threshold: threshold,
+		threshold: cfg.threshold,
+		level:     cfg.level,
+	}
+
+	peerWindowBits := params.clientMaxWindowBits
+	if isClient {
+		cm.compressTakeover = !params.clientNoContextTakeover
+		cm.decompressTakeover = !params.serverNoContextTakeover
+		peerWindowBits = params.serverMaxWindowBits
+	} else {
+		cm.compressTakeover = !params.serverNoContextTakeover
+		cm.decompressTakeover = !params.clientNoContextTakeover
+	}
+	if peerWindowBits == 0 {
+		peerWindowBits = maxWindowBits
 	}
+	cm.decompressWindow = 1 << peerWindowBits
+
+	// A pooled compressor is shared between connections, so it can't
+	// carry context from one message to the next
+	if cfg.strategy == CompressionPooled {
+		cm.compressTakeover = false
+	}
+
+	return cm
 }
 
 // ShouldCompress returns true if the payload should be compressed
@@ -39,8 +376,23 @@ func (cm *CompressionManager) ShouldCompress(payloadSize int) bool {
 	return cm.enabled && payloadSize >= cm.threshold
 }
 
-// Compress compresses the payload using DEFLATE
+// Compress compresses the payload using DEFLATE.
+// With context takeover the compressor keeps its window between messages, so
+// every compressed result must be sent to the peer.
 func (cm *CompressionManager) Compress(data []byte) ([]byte, error) {
+	if !cm.compressTakeover {
+		buf := getDeflateBuffer()
+		defer putDeflateBuffer(buf)
+
+		fw, err := getFlateWriter(buf, cm.level)
+		if err != nil {
+			return nil, err
+		}
+		defer putFlateWriter(fw, cm.level)
+
+		return compressMessage(fw, buf, data)
+	}
+
 	cm.compressorMu.Lock()
 	defer cm.compressorMu.Unlock()
 
@@ -49,26 +401,30 @@ func (cm *CompressionManager) Compress(data []byte) ([]byte, error) {
 	// Create compressor if not exists
 	if cm.compressor == nil {
 		var err error
-		cm.compressor, err = flate.NewWriter(&cm.compressBuf, flate.BestSpeed)
+		cm.compressor, err = flate.NewWriter(&cm.compressBuf, cm.level)
 		if err != nil {
 			return nil, err
 		}
-	} else {
-		cm.compressor.Reset(&cm.compressBuf)
 	}
 
+	return compressMessage(cm.compressor, &cm.compressBuf, data)
+}
+
+// compressMessage writes data through fw into buf and returns a copy of the
+// compressed message without the trailing empty block
+func compressMessage(fw *flate.Writer, buf *bytes.Buffer, data []byte) ([]byte, error) {
 	// Write data to compressor
-	if _, err := cm.compressor.Write(data); err != nil {
+	if _, err := fw.Write(data); err != nil {
 		return nil, err
 	}
 
 	// Flush the compressor
-	if err := cm.compressor.Flush(); err != nil {
+	if err := fw.Flush(); err != nil {
 		return nil, err
 	}
 
 	// Get compressed data
-	compressed := cm.compressBuf.Bytes()
+	compressed := buf.Bytes()
 
 	// Per RFC 7692, remove the trailing 0x00 0x00 0xff 0xff
 	if len(compressed) >= 4 {
@@ -85,38 +441,91 @@ func (cm *CompressionManager) Compress(data []byte) ([]byte, error) {
 	return result, nil
 }
 
-// Decompress decompresses the payload using DEFLATE
+// Decompress decompresses the payload using DEFLATE.
+// With context takeover the previous output is used as a preset dictionary so
+// back-references into earlier messages resolve.
 func (cm *CompressionManager) Decompress(data []byte) ([]byte, error) {
-	cm.decompressorMu.Lock()
-	defer cm.decompressorMu.Unlock()
+	return cm.decompress(data, -1)
+}
 
+// decompress is like Decompress, but fails with ErrMessageTooLarge as soon
+// as the output exceeds limit bytes, unless limit is negative, so that a
+// small message cannot inflate without bound
+func (cm *CompressionManager) decompress(data []byte, limit int) ([]byte, error) {
 	// Per RFC 7692, append the trailing 0x00 0x00 0xff 0xff
-	dataWithTail := make([]byte, len(data)+4)
+	dataWithTail := make([]byte, len(data)+len(deflateTail))
 	copy(dataWithTail, data)
-	dataWithTail[len(data)] = 0x00
-	dataWithTail[len(data)+1] = 0x00
-	dataWithTail[len(data)+2] = 0xff
-	dataWithTail[len(data)+3] = 0xff
+	copy(dataWithTail[len(data):], deflateTail)
+	reader := bytes.NewReader(dataWithTail)
+
+	if !cm.decompressTakeover {
+		buf := getDeflateBuffer()
+		defer putDeflateBuffer(buf)
+
+		fr, err := getFlateReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		defer putFlateReader(fr)
+
+		return decompressMessage(fr, buf, limit)
+	}
+
+	cm.decompressorMu.Lock()
+	defer cm.decompressorMu.Unlock()
 
 	cm.decompressBuf.Reset()
-	reader := bytes.NewReader(dataWithTail)
 
-	// Create decompressor
-	cm.decompressor = flate.NewReader(reader)
-	defer cm.decompressor.Close()
+	if cm.decompressor == nil {
+		cm.decompressor = flate.NewReaderDict(reader, cm.dict)
+	} else if err := cm.decompressor.(flate.Resetter).Reset(reader, cm.dict); err != nil {
+		return nil, err
+	}
 
-	// Read decompressed data
-	if _, err := io.Copy(&cm.decompressBuf, cm.decompressor); err != nil {
+	result, err := decompressMessage(cm.decompressor, &cm.decompressBuf, limit)
+	if err != nil {
 		return nil, err
 	}
 
+	cm.dict = appendWindow(cm.dict, result, cm.decompressWindow)
+
+	return result, nil
+}
+
+// decompressMessage reads the whole message from fr into buf and returns a
+// copy, reading no more than one byte past limit unless it is negative
+func decompressMessage(fr io.Reader, buf *bytes.Buffer, limit int) ([]byte, error) {
+	if limit >= 0 {
+		fr = io.LimitReader(fr, int64(limit)+1)
+	}
+
+	// Read decompressed data
+	if _, err := io.Copy(buf, fr); err != nil {
+		return nil, ErrCompressionFailed
+	}
+	if limit >= 0 && buf.Len() > limit {
+		return nil, ErrMessageTooLarge
+	}
+
 	// Make a copy to avoid buffer reuse issues
-	result := make([]byte, cm.decompressBuf.Len())
-	copy(result, cm.decompressBuf.Bytes())
+	result := make([]byte, buf.Len())
+	copy(result, buf.Bytes())
 
 	return result, nil
 }
 
+// appendWindow appends data to the sliding window, keeping at most size bytes
+func appendWindow(window, data []byte, size int) []byte {
+	if len(data) >= size {
+		return append(window[:0], data[len(data)-size:]...)
+	}
+	if keep := size - len(data); len(window) > keep {
+		copy(window, window[len(window)-keep:])
+		window = window[:keep]
+	}
+	return append(window, data...)
+}
+
 // Close releases compression resources
 func (cm *CompressionManager) Close() error {
 	cm.compressorMu.Lock()
@@ -131,6 +540,7 @@ func (cm *CompressionManager) Close() error {
 		cm.decompressor.Close()
 		cm.decompressor = nil
 	}
+	cm.dict = nil
 	cm.decompressorMu.Unlock()
 
 	return nil
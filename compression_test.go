@@ -1,8 +1,20 @@
 package axon_test
 
 import (
+	"bufio"
 	"bytes"
+	"compress/flate"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/kolosys/axon"
 )
@@ -69,3 +81,482 @@ func TestUpgradeOptions_Compression(t *testing.T) {
 		t.Error("expected Compression to be true")
 	}
 }
+
+func TestCompressionManager_RoundTrip(t *testing.T) {
+	for _, noTakeover := range []bool{false, true} {
+		client, server := axon.NewCompressionPair(noTakeover, noTakeover)
+
+		messages := [][]byte{
+			bytes.Repeat([]byte("Hello World! "), 100),
+			[]byte("short"),
+			bytes.Repeat([]byte("Hello World! "), 100),
+		}
+
+		for i, msg := range messages {
+			compressed, err := client.Compress(msg)
+			if err != nil {
+				t.Fatalf("noTakeover=%v message %d: compress failed: %v", noTakeover, i, err)
+			}
+			got, err := server.Decompress(compressed)
+			if err != nil {
+				t.Fatalf("noTakeover=%v message %d: decompress failed: %v", noTakeover, i, err)
+			}
+			if !bytes.Equal(got, msg) {
+				t.Fatalf("noTakeover=%v message %d: round trip mismatch", noTakeover, i)
+			}
+		}
+	}
+}
+
+func TestCompressionManager_ContextTakeover(t *testing.T) {
+	var b strings.Builder
+	for i := 0; i < 40; i++ {
+		b.WriteString(strings.Repeat(string(rune('a'+i%26)), i%7+1))
+		b.WriteString(" ")
+	}
+	msg := []byte(b.String())
+
+	client, server := axon.NewCompressionPair(false, false)
+	first, _ := client.Compress(msg)
+	second, _ := client.Compress(msg)
+
+	// The repeated message is encoded as a back-reference into the window
+	if len(second) >= len(first) {
+		t.Errorf("expected context takeover to shrink repeated message: first=%d second=%d", len(first), len(second))
+	}
+
+	if _, err := server.Decompress(first); err != nil {
+		t.Fatalf("decompress first failed: %v", err)
+	}
+	got, err := server.Decompress(second)
+	if err != nil {
+		t.Fatalf("decompress second failed: %v", err)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Error("second message mismatch with context takeover")
+	}
+
+	client, _ = axon.NewCompressionPair(true, true)
+	first, _ = client.Compress(msg)
+	second, _ = client.Compress(msg)
+	if !bytes.Equal(first, second) {
+		t.Error("expected identical output without context takeover")
+	}
+}
+
+func TestCompressionManager_Levels(t *testing.T) {
+	msg := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 50)
+
+	for _, level := range []int{-2, -1, 0, 1, 5, 9, 42} {
+		for _, strategy := range []axon.CompressionStrategy{axon.CompressionPerConnection, axon.CompressionPooled} {
+			client, server := axon.NewConfiguredCompressionPair(level, strategy)
+			for i := 0; i < 3; i++ {
+				compressed, err := client.Compress(msg)
+				if err != nil {
+					t.Fatalf("level=%d strategy=%d: compress failed: %v", level, strategy, err)
+				}
+				got, err := server.Decompress(compressed)
+				if err != nil {
+					t.Fatalf("level=%d strategy=%d: decompress failed: %v", level, strategy, err)
+				}
+				if !bytes.Equal(got, msg) {
+					t.Fatalf("level=%d strategy=%d: round trip mismatch", level, strategy)
+				}
+			}
+		}
+	}
+}
+
+func TestCompressionManager_PooledNoTakeover(t *testing.T) {
+	msg := bytes.Repeat([]byte("pooled compressor "), 20)
+
+	client, _ := axon.NewConfiguredCompressionPair(0, axon.CompressionPooled)
+	first, _ := client.Compress(msg)
+	second, _ := client.Compress(msg)
+	if !bytes.Equal(first, second) {
+		t.Error("expected pooled compressor to reset between messages")
+	}
+}
+
+func TestCompressionManager_PooledConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			client, server := axon.NewCompressionPair(true, true)
+			msg := bytes.Repeat([]byte(fmt.Sprintf("connection %d ", g)), 64)
+			for i := 0; i < 50; i++ {
+				compressed, err := client.Compress(msg)
+				if err != nil {
+					errs <- err
+					return
+				}
+				got, err := server.Decompress(compressed)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if !bytes.Equal(got, msg) {
+					errs <- fmt.Errorf("connection %d: round trip mismatch", g)
+					return
+				}
+			}
+		}(g)
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestUpgrade_DeflatePreferences(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		opts   axon.UpgradeOptions
+		want   string
+	}{
+		{
+			name:   "defaults",
+			header: "permessage-deflate; client_max_window_bits",
+			want:   "permessage-deflate",
+		},
+		{
+			name:   "server no context takeover",
+			header: "permessage-deflate",
+			opts:   axon.UpgradeOptions{ServerNoContextTakeover: true},
+			want:   "permessage-deflate; server_no_context_takeover",
+		},
+		{
+			name:   "pooled implies server no context takeover",
+			header: "permessage-deflate",
+			opts:   axon.UpgradeOptions{CompressionStrategy: axon.CompressionPooled},
+			want:   "permessage-deflate; server_no_context_takeover",
+		},
+		{
+			name:   "client no context takeover",
+			header: "permessage-deflate",
+			opts:   axon.UpgradeOptions{ClientNoContextTakeover: true},
+			want:   "permessage-deflate; client_no_context_takeover",
+		},
+		{
+			name:   "client window limited when offered",
+			header: "permessage-deflate; client_max_window_bits",
+			opts:   axon.UpgradeOptions{ClientMaxWindowBits: 10},
+			want:   "permessage-deflate; client_max_window_bits=10",
+		},
+		{
+			name:   "client window not limited when not offered",
+			header: "permessage-deflate",
+			opts:   axon.UpgradeOptions{ClientMaxWindowBits: 10},
+			want:   "permessage-deflate",
+		},
+		{
+			name:   "invalid client window ignored",
+			header: "permessage-deflate; client_max_window_bits",
+			opts:   axon.UpgradeOptions{ClientMaxWindowBits: 20},
+			want:   "permessage-deflate",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Compression = true
+			if got := axon.AcceptDeflateOffer(tt.header, &tt.opts); got != tt.want {
+				t.Errorf("AcceptDeflateOffer() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDeflateResponse(t *testing.T) {
+	tests := []struct {
+		header     string
+		accepted   bool
+		noTakeover bool
+		windowBits int
+		expectErr  bool
+	}{
+		{"permessage-deflate", true, false, 0, false},
+		{"permessage-deflate; server_no_context_takeover", true, true, 0, false},
+		{"x-webkit-deflate-frame, permessage-deflate; server_max_window_bits=10", true, false, 10, false},
+		{"permessage-deflate; server_max_window_bits=\"12\"", true, false, 12, false},
+		{"x-custom", false, false, 0, false},
+		{"permessage-deflate; server_max_window_bits=7", false, false, 0, true},
+		{"permessage-deflate; client_max_window_bits=10", false, false, 0, true},
+		{"permessage-deflate; unknown_param", false, false, 0, true},
+		{"permessage-deflate, permessage-deflate", false, false, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			noTakeover, bits, accepted, err := axon.ParseDeflateResponse(tt.header)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("error = %v, expectErr %v", err, tt.expectErr)
+			}
+			if err != nil {
+				return
+			}
+			if accepted != tt.accepted || noTakeover != tt.noTakeover || bits != tt.windowBits {
+				t.Errorf("got accepted=%v noTakeover=%v bits=%d", accepted, noTakeover, bits)
+			}
+		})
+	}
+}
+
+// extensionServer answers every upgrade with the given extensions header
+func extensionServer(t *testing.T, extensions string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hj := w.(http.Hijacker)
+		conn, bufrw, err := hj.Hijack()
+		if err != nil {
+			t.Errorf("hijack failed: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		accept := axon.ComputeAcceptKey(r.Header.Get("Sec-WebSocket-Key"))
+		bufrw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+			"Upgrade: websocket\r\nConnection: Upgrade\r\n" +
+			"Sec-WebSocket-Accept: " + accept + "\r\n" +
+			"Sec-WebSocket-Extensions: " + extensions + "\r\n\r\n")
+		bufrw.Flush()
+		bufio.NewReader(conn).ReadByte()
+	}))
+}
+
+func TestDial_CompressionNegotiation(t *testing.T) {
+	tests := []struct {
+		name       string
+		extensions string
+		expectErr  bool
+	}{
+		{"accepted", "permessage-deflate; server_no_context_takeover", false},
+		{"unsolicited client window bits", "permessage-deflate; client_max_window_bits=9", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := extensionServer(t, tt.extensions)
+			defer server.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			conn, err := axon.Dial[string](ctx, "ws"+strings.TrimPrefix(server.URL, "http"), &axon.DialOptions{
+				Compression:             true,
+				ServerNoContextTakeover: true,
+			})
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("Dial() error = %v, expectErr %v", err, tt.expectErr)
+			}
+			if conn != nil {
+				conn.Close(1000, "")
+			}
+		})
+	}
+}
+
+func TestDial_CompressionFallbackOffer(t *testing.T) {
+	offered := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		offered <- r.Header.Get("Sec-WebSocket-Extensions")
+		conn, err := axon.Upgrade[string](w, r, &axon.UpgradeOptions{Compression: true})
+		if err == nil {
+			conn.Close(1000, "")
+		}
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := axon.Dial[string](ctx, "ws"+strings.TrimPrefix(server.URL, "http"), &axon.DialOptions{
+		Compression:         true,
+		ServerMaxWindowBits: 10,
+	})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close(1000, "")
+
+	want := "permessage-deflate; server_max_window_bits=10, permessage-deflate"
+	if got := <-offered; got != want {
+		t.Errorf("offered %q, want %q", got, want)
+	}
+	// The server cannot limit its window, so it accepts the fallback
+	if !conn.Compressed() {
+		t.Error("expected compression to be negotiated with the fallback offer")
+	}
+}
+
+func TestParseDeflateOffers(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []string
+	}{
+		{"permessage-deflate", []string{"permessage-deflate"}},
+		{"permessage-deflate; client_max_window_bits", []string{"permessage-deflate"}},
+		{
+			"permessage-deflate; server_max_window_bits=10, permessage-deflate; server_no_context_takeover",
+			[]string{"permessage-deflate; server_no_context_takeover"},
+		},
+		{"permessage-deflate; server_max_window_bits=15", []string{"permessage-deflate; server_max_window_bits=15"}},
+		{"permessage-deflate; client_no_context_takeover; client_no_context_takeover", nil},
+		{"permessage-deflate; bogus", nil},
+		{`x-custom; list="a, b", permessage-deflate`, []string{"permessage-deflate"}},
+		{"x-webkit-deflate-frame", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			got := axon.ParseDeflateOffers(tt.header)
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("ParseDeflateOffers() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompression_EndToEnd(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[string](w, r, &axon.UpgradeOptions{
+			Compression:    true,
+			MaxFrameSize:   1 << 20,
+			MaxMessageSize: 1 << 20,
+		})
+		if err != nil {
+			return
+		}
+		defer conn.Close(1000, "done")
+
+		for {
+			msg, err := conn.Read(context.Background())
+			if err != nil {
+				return
+			}
+			if err := conn.Write(context.Background(), msg); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := axon.Dial[string](ctx, "ws"+strings.TrimPrefix(server.URL, "http"), &axon.DialOptions{
+		Compression:    true,
+		MaxFrameSize:   1 << 20,
+		MaxMessageSize: 1 << 20,
+	})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close(1000, "done")
+
+	// Mix messages above and below the compression threshold
+	messages := []string{
+		strings.Repeat("compressible payload ", 500),
+		"small",
+		strings.Repeat("compressible payload ", 500),
+	}
+
+	for i, msg := range messages {
+		if err := conn.Write(ctx, msg); err != nil {
+			t.Fatalf("message %d: write failed: %v", i, err)
+		}
+		got, err := conn.Read(ctx)
+		if err != nil {
+			t.Fatalf("message %d: read failed: %v", i, err)
+		}
+		if got != msg {
+			t.Fatalf("message %d: echo mismatch (got %d bytes, want %d)", i, len(got), len(msg))
+		}
+	}
+}
+
+func TestUpgrade_CompressionResponseHeader(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[string](w, r, &axon.UpgradeOptions{Compression: true})
+		if err != nil {
+			return
+		}
+		conn.Close(1000, "done")
+	}))
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n" +
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n" +
+		"Sec-WebSocket-Extensions: permessage-deflate; client_max_window_bits\r\n\r\n"))
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Extensions"); got != "permessage-deflate" {
+		t.Errorf("Sec-WebSocket-Extensions = %q, want %q", got, "permessage-deflate")
+	}
+}
+
+func TestReadCompressionBomb(t *testing.T) {
+	// 16 MB of zeros deflate to a few KB, well within MaxFrameSize
+	var compressed bytes.Buffer
+	fw, _ := flate.NewWriter(&compressed, flate.BestCompression)
+	fw.Write(make([]byte, 16<<20))
+	fw.Flush()
+	payload := bytes.TrimSuffix(compressed.Bytes(), []byte{0x00, 0x00, 0xff, 0xff})
+
+	for _, tc := range []struct {
+		name     string
+		takeover bool
+	}{
+		{"Takeover", true},
+		{"NoContextTakeover", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, clientConn, err := axon.NewTestConn[axon.RawBinary](&axon.UpgradeOptions{
+				Compression:             true,
+				ClientNoContextTakeover: !tc.takeover,
+				MaxFrameSize:            64 * 1024,
+				MaxMessageSize:          64 * 1024,
+			})
+			if err != nil {
+				t.Fatalf("failed to create test connection: %v", err)
+			}
+			defer conn.Close(1000, "")
+			defer clientConn.Close()
+
+			go func() {
+				var frame bytes.Buffer
+				writeClientFrame(&frame, 0x2, payload)
+				b := frame.Bytes()
+				b[0] |= 0x40 // RSV1: compressed
+				clientConn.Write(b)
+				io.Copy(io.Discard, clientConn)
+			}()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+
+			if _, _, err := conn.ReadMessage(ctx); !errors.Is(err, axon.ErrMessageTooLarge) {
+				t.Errorf("expected ErrMessageTooLarge, got %v", err)
+			}
+			if conn.CloseCode() != int(axon.CloseMessageTooBig) {
+				t.Errorf("expected close code 1009, got %d", conn.CloseCode())
+			}
+		})
+	}
+}
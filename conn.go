@@ -3,47 +3,148 @@ package axon
 import (
 	"bufio"
 	"context"
-	"crypto/rand"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"sync"
 	"sync/atomic"
 	"time"
+	"unicode/utf8"
 )
 
 // Conn represents a WebSocket connection with type-safe message handling
 type Conn[T any] struct {
-	conn          net.Conn
-	reader        *bufio.Reader
-	writer        *bufio.Writer
-	readBuf       []byte
-	writeBuf      []byte
-	upgrader      *Upgrader
-	closed        int32
-	closeOnce     sync.Once
-	closeCode     int
-	closeReason   string
-	readDeadline  time.Duration
-	writeDeadline time.Duration
-	pingInterval  time.Duration
-	pongTimeout   time.Duration
-	pingTicker    *time.Ticker
-	pingStop      chan struct{}
-	pingWg        sync.WaitGroup
-	isClient      bool
-	compression   *CompressionManager
-	writeMu       sync.Mutex
-}
-
-// Read reads a complete message from the connection
+	id             string
+	conn           net.Conn
+	reader         *bufio.Reader
+	writer         *bufio.Writer
+	readBuf        []byte
+	writeBuf       []byte
+	upgrader       *Upgrader
+	closed         int32
+	closeOnce      sync.Once
+	closeCode      int
+	closeReason    string
+	readDeadline   time.Duration
+	writeDeadline  time.Duration
+	pingInterval   time.Duration
+	pongTimeout    time.Duration
+	pingTicker     Ticker
+	pingStop       chan struct{}
+	pingWg         sync.WaitGroup
+	isClient       bool
+	compression    *CompressionManager
+	extensions     []activeExtension // Negotiated plugins, in order
+	writeMu        sync.Mutex
+	maskKeys       *maskKeys    // Client mask keys, guarded by writeMu
+	ioUsers        atomic.Int64 // Reads and writes using pooled buffers
+	freeOnce       sync.Once
+	metaMu         sync.RWMutex
+	meta           map[string]any
+	ctx            context.Context
+	cancel         context.CancelFunc // Cancels ctx once the connection is closed
+	release        func()             // Frees the connection's upgrader limit slot
+	onClosed       func()             // Called once the connection is marked closed
+	lazyBuffers    bool               // Buffers are attached only while in use
+	sendOnce       sync.Once
+	sendQ          atomic.Pointer[sendQueue[T]]
+	dialTimings    DialTimings
+	health         connHealth
+	log            *slog.Logger
+	metrics        *Metrics
+	trace          TraceFunc
+	wrapMu         sync.RWMutex
+	readChain      ReadFunc
+	writeChain     WriteFunc
+	validator      atomic.Pointer[Validator[T]]
+	subprotocol    string
+	pingHandler    atomic.Pointer[func([]byte) error]
+	pongHandler    atomic.Pointer[func([]byte) error]
+	idleTimeout    time.Duration
+	idleTimer      Timer
+	lastData       atomic.Int64 // Unix nanoseconds of the last data message
+	writeStall     time.Duration
+	authTimer      Timer
+	faultTimer     Timer
+	extendDeadline DeadlineExtension
+	clk            Clock
+}
+
+// Read reads a complete message from the connection, skipping messages
+// dropped by the Validator
 func (c *Conn[T]) Read(ctx context.Context) (T, error) {
+	for {
+		msg, err := c.readDecoded(ctx)
+		if err != nil {
+			return msg, err
+		}
+		drop, err := c.validateRead(msg)
+		if drop {
+			continue
+		}
+		if err != nil {
+			var zero T
+			return zero, err
+		}
+		return msg, nil
+	}
+}
+
+// readDecoded reads a complete message and decodes it into T
+func (c *Conn[T]) readDecoded(ctx context.Context) (T, error) {
 	var zero T
+	if !c.acquireIO() {
+		return zero, ErrConnectionClosed
+	}
+	defer c.releaseIO()
+
+	var payload []byte
+	var borrowed bool
+	var err error
+	if read := c.readWrapped(); read != nil {
+		_, payload, err = read(ctx)
+	} else {
+		_, payload, borrowed, err = c.readMessage(ctx)
+	}
+	if err != nil {
+		return zero, err
+	}
+
+	if len(payload) == 0 {
+		return zero, nil
+	}
+
+	return decode[T](payload, borrowed)
+}
+
+// ReadMessage reads a complete message and returns its type and raw payload
+// without decoding it into T. The returned slice is owned by the caller.
+func (c *Conn[T]) ReadMessage(ctx context.Context) (MessageType, []byte, error) {
+	if read := c.readWrapped(); read != nil {
+		return read(ctx)
+	}
+	return c.readRaw(ctx)
+}
+
+// readMessage reads frames until a complete data message has been assembled,
+// handling interleaved control frames. When borrowed is true the payload
+// aliases the connection's read buffer and is only valid until the next read.
+func (c *Conn[T]) readMessage(ctx context.Context) (opcode byte, payload []byte, borrowed bool, err error) {
+	start := time.Now()
+	defer func() {
+		if err == errNoMessage {
+			return
+		}
+		c.health.record(err)
+		c.recordRead(len(payload), time.Since(start), err)
+	}()
 
 	if atomic.LoadInt32(&c.closed) != 0 {
-		return zero, ErrConnectionClosed
+		return 0, nil, false, ErrConnectionClosed
 	}
 
 	deadline := c.readDeadline
@@ -51,81 +152,178 @@ func (c *Conn[T]) Read(ctx context.Context) (T, error) {
 		deadline = 30 * time.Second // Default timeout
 	}
 
+	// While the heartbeat extends the deadline, a Read waits for as long
+	// as the peer keeps answering it
+	window := c.heartbeatWindow()
+	if window > 0 {
+		deadline = window
+	}
+
+	var limit time.Time
 	if ctx != nil {
 		if ctxDeadline, ok := ctx.Deadline(); ok {
-			deadline = time.Until(ctxDeadline)
+			limit = ctxDeadline
+			if window == 0 || time.Until(ctxDeadline) < deadline {
+				deadline = time.Until(ctxDeadline)
+			}
 		}
 		if ctx.Err() != nil {
-			return zero, ErrContextCanceled
+			return 0, nil, false, ErrContextCanceled
 		}
 	}
 
 	if err := c.conn.SetReadDeadline(time.Now().Add(deadline)); err != nil {
-		return zero, err
+		return 0, nil, false, err
 	}
 
 	var messagePayload []byte
 	firstFrame := true
+	isText := false
+	validated := 0
+	compressed := false
+	var messageRSV byte
 
-	for {
-		frame, err := readFrame(c.reader, c.readBuf, c.upgrader.maxFrameSize)
-		if err != nil {
+	strict := !c.upgrader.lenientProtocol
+	rsv := c.extensionRSV()
+	if c.compression != nil && c.compression.enabled {
+		rsv |= rsv1Mask
+	}
+
+	c.attachReader()
+
+	// Every frame is read into the same Frame, which stays on the stack
+	var frame Frame
+	for frames := 0; ; frames++ {
+		// A connection driven by readiness events must not wait for a
+		// message once the control frames that arrived are handled
+		if frames > 0 && firstFrame && c.lazyBuffers && c.reader.Buffered() == 0 {
+			return 0, nil, false, errNoMessage
+		}
+
+		if err := readFrameInto(c.reader, c.readBuf, c.upgrader.maxFrameSize, rsv, strict, &frame); err != nil {
 			if err == io.EOF {
-				return zero, ErrConnectionClosed
+				return 0, nil, false, ErrConnectionClosed
 			}
-			return zero, err
+			if c.IsClosed() {
+				// Interrupted by Close
+				return 0, nil, false, ErrConnectionClosed
+			}
+			if isProtocolError(err) {
+				return 0, nil, false, c.fail(CloseProtocolError, err)
+			}
+			return 0, nil, false, deadlineErr(ErrReadDeadlineExceeded, err)
+		}
+		c.traceFrame(FrameRead, &frame)
+		if window > 0 {
+			if err := c.extendReadDeadline(frame.Opcode, window, limit); err != nil {
+				return 0, nil, false, err
+			}
+		}
+
+		// Clients mask every frame and servers none (RFC 6455 Section 5.1)
+		if strict && frame.Masked == c.isClient {
+			return 0, nil, false, c.fail(CloseProtocolError, readError(ErrInvalidMask, frame.Opcode, uint64(len(frame.Payload)), 0))
+		}
+
+		// RSV1 marks a compressed message, so it is only valid on the
+		// first frame of a data message (RFC 7692 Section 6.1), and so are
+		// the bits of plugins, which transform whole messages
+		if frame.rsv() != 0 && (frame.Opcode == opContinuation || isControl(frame.Opcode)) {
+			return 0, nil, false, c.fail(CloseProtocolError, readError(ErrInvalidFrame, frame.Opcode, uint64(len(frame.Payload)), 0))
 		}
 
 		switch frame.Opcode {
 		case opContinuation:
 			if firstFrame {
-				return zero, ErrInvalidFrame
+				return 0, nil, false, readError(ErrInvalidFrame, frame.Opcode, uint64(len(frame.Payload)), 0)
 			}
 		case opClose:
-			code := 1000 // Normal closure
+			code := int(CloseNoStatusReceived)
 			reason := ""
-			if len(frame.Payload) >= 2 {
+			switch {
+			case len(frame.Payload) == 1:
+				return 0, nil, false, c.fail(CloseProtocolError, ErrInvalidFrame)
+			case len(frame.Payload) >= 2:
 				code = int(binary.BigEndian.Uint16(frame.Payload[:2]))
+				if cc := CloseCode(code); !cc.IsValid() || cc.IsReserved() {
+					return 0, nil, false, c.fail(CloseProtocolError, ErrInvalidCloseCode)
+				}
 				if len(frame.Payload) > 2 {
+					if strict && !utf8.Valid(frame.Payload[2:]) {
+						return 0, nil, false, c.fail(CloseInvalidPayloadData, ErrInvalidUTF8)
+					}
 					reason = string(frame.Payload[2:])
 				}
 			}
-			c.closeOnce.Do(func() {
-				atomic.StoreInt32(&c.closed, 1)
-				c.closeCode = code
-				c.closeReason = reason
-			})
-			return zero, NewCloseError(code, reason)
+			// Echo the close frame and release the socket (RFC 6455
+			// Section 5.5.1)
+			c.close(code, reason, true)
+			return 0, nil, false, NewCloseError(code, reason)
 
 		case opPing:
-			pongFrame := &Frame{
-				Fin:     true,
-				Opcode:  opPong,
-				Payload: frame.Payload,
+			if h := c.pingHandler.Load(); h != nil {
+				if err := (*h)(frame.Payload); err != nil {
+					return 0, nil, false, err
+				}
+				continue
 			}
-			if err := writeFrame(c.writer, c.writeBuf, pongFrame); err != nil {
-				return zero, err
+			timeout := c.writeDeadline
+			if timeout == 0 {
+				timeout = 30 * time.Second
 			}
-			if err := c.writer.Flush(); err != nil {
-				return zero, err
+			// An oversized ping of a lenient connection is answered with
+			// as much of its payload as a pong can hold
+			payload := frame.Payload
+			if len(payload) > maxControlPayloadSize {
+				payload = payload[:maxControlPayloadSize]
+			}
+			if err := c.sendControl(opPong, payload, timeout); err != nil {
+				return 0, nil, false, deadlineErr(ErrWriteDeadlineExceeded, c.closedErr(err))
 			}
 			continue
 
 		case opPong:
+			c.health.pong(frame.Payload, c.clock().Now())
+			if h := c.pongHandler.Load(); h != nil {
+				if err := (*h)(frame.Payload); err != nil {
+					return 0, nil, false, err
+				}
+			}
 			continue
 		case opText, opBinary:
 			if !firstFrame {
-				return zero, ErrInvalidFrame
+				return 0, nil, false, readError(ErrInvalidFrame, frame.Opcode, uint64(len(frame.Payload)), 0)
 			}
 			firstFrame = false
+			messageRSV = frame.rsv()
+			compressed = frame.Rsv1 && c.compression != nil
+			opcode = frame.Opcode
+			isText = opcode == opText
 		default:
-			return zero, ErrUnsupportedFrameType
+			return 0, nil, false, readError(ErrUnsupportedFrameType, frame.Opcode, uint64(len(frame.Payload)), 0)
 		}
 
-		messagePayload = append(messagePayload, frame.Payload...)
+		// Single-frame messages are consumed straight from the read buffer;
+		// only fragmented messages are copied into an accumulated payload
+		if frame.Fin && messagePayload == nil {
+			messagePayload = frame.Payload
+			borrowed = true
+		} else {
+			messagePayload = append(messagePayload, frame.Payload...)
+		}
 
 		if len(messagePayload) > c.upgrader.maxMessageSize {
-			return zero, ErrMessageTooLarge
+			return 0, nil, false, readError(ErrMessageTooLarge, opcode, uint64(len(messagePayload)), c.upgrader.maxMessageSize)
+		}
+
+		// Validate text incrementally so invalid data fails fast, even
+		// when a multi-byte sequence spans a fragment boundary
+		if strict && isText && !compressed && c.extensions == nil {
+			n, ok := validUTF8Prefix(messagePayload[validated:])
+			validated += n
+			if !ok || (frame.Fin && validated != len(messagePayload)) {
+				return 0, nil, false, c.fail(CloseInvalidPayloadData, ErrInvalidUTF8)
+			}
 		}
 
 		if frame.Fin {
@@ -133,35 +331,123 @@ func (c *Conn[T]) Read(ctx context.Context) (T, error) {
 		}
 	}
 
-	var msg T
-	if len(messagePayload) == 0 {
-		return zero, nil
+	if c.extensions != nil {
+		transformed, err := c.transformRead(opcode, messageRSV, messagePayload)
+		if err != nil {
+			return 0, nil, false, c.fail(CloseProtocolError, err)
+		}
+		messagePayload = transformed
 	}
 
 	// Decompress if compression is enabled and message was compressed
-	if c.compression != nil && c.compression.enabled {
-		decompressed, err := c.compression.Decompress(messagePayload)
+	if compressed && len(messagePayload) > 0 {
+		decompressed, err := c.compression.decompress(messagePayload, c.upgrader.maxMessageSize)
+		if errors.Is(err, ErrMessageTooLarge) {
+			// The limit applies to the inflated message too
+			return 0, nil, false, c.fail(CloseMessageTooBig, readError(err, opcode, uint64(c.upgrader.maxMessageSize)+1, c.upgrader.maxMessageSize))
+		}
 		if err != nil {
-			return zero, err
+			return 0, nil, false, err
+		}
+		if c.metrics != nil {
+			c.metrics.RecordDecompression()
 		}
 		messagePayload = decompressed
+		borrowed = false
+	}
+
+	if strict && isText && (compressed || c.extensions != nil) && !utf8.Valid(messagePayload) {
+		return 0, nil, false, c.fail(CloseInvalidPayloadData, ErrInvalidUTF8)
 	}
 
-	if err := json.Unmarshal(messagePayload, &msg); err != nil {
-		switch v := any(&msg).(type) {
-		case *[]byte:
-			*v = messagePayload
-			return msg, nil
-		case *string:
-			*v = string(messagePayload)
-			return msg, nil
+	c.touch()
+	return opcode, messagePayload, borrowed, nil
+}
+
+// decode deserializes a message payload into T. When borrowed is true the
+// payload is copied before being retained.
+func decode[T any](payload []byte, borrowed bool) (T, error) {
+	var zero T
+	var msg T
+
+	switch v := any(&msg).(type) {
+	case *RawText:
+		*v = RawText(payload)
+		return msg, nil
+	case *RawBinary:
+		if borrowed {
+			// The read buffer is reused by the next Read
+			payload = append([]byte(nil), payload...)
 		}
+		*v = payload
+		return msg, nil
+	}
+
+	if err := json.Unmarshal(payload, &msg); err != nil {
 		return zero, ErrDeserializationFailed
 	}
 
 	return msg, nil
 }
 
+// ID returns the connection's process-unique ID
+func (c *Conn[T]) ID() string {
+	return c.id
+}
+
+// RemoteAddr returns the peer's network address
+func (c *Conn[T]) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// LocalAddr returns the local network address
+func (c *Conn[T]) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// DialTimings returns how long each phase of dialing took. It is zero for
+// server connections.
+func (c *Conn[T]) DialTimings() DialTimings {
+	return c.dialTimings
+}
+
+// Context returns the connection's context, which carries any values added
+// by upgrade interceptors and is canceled once the connection is closed. It
+// derives from the BaseContext option, or else from the context the
+// connection was upgraded with, which for hijacked connections is not
+// canceled when the handler returns.
+func (c *Conn[T]) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// Set stores a metadata value on the connection
+func (c *Conn[T]) Set(key string, value any) {
+	c.metaMu.Lock()
+	defer c.metaMu.Unlock()
+	if c.meta == nil {
+		c.meta = make(map[string]any)
+	}
+	c.meta[key] = value
+}
+
+// Get returns a metadata value stored on the connection
+func (c *Conn[T]) Get(key string) (any, bool) {
+	c.metaMu.RLock()
+	defer c.metaMu.RUnlock()
+	value, ok := c.meta[key]
+	return value, ok
+}
+
+// Delete removes a metadata value from the connection
+func (c *Conn[T]) Delete(key string) {
+	c.metaMu.Lock()
+	defer c.metaMu.Unlock()
+	delete(c.meta, key)
+}
+
 // IsClosed returns true if the connection has been closed
 func (c *Conn[T]) IsClosed() bool {
 	return atomic.LoadInt32(&c.closed) != 0
@@ -183,6 +469,62 @@ func (c *Conn[T]) Write(ctx context.Context, msg T) error {
 		return ErrConnectionClosed
 	}
 
+	if err := c.validateWrite(msg); err != nil {
+		return err
+	}
+
+	opcode, payload, err := encode(msg)
+	if err != nil {
+		return err
+	}
+
+	return c.write(ctx, opcode, payload)
+}
+
+// WriteMessage writes a raw payload as a single message of the given type,
+// bypassing serialization of T
+func (c *Conn[T]) WriteMessage(ctx context.Context, messageType MessageType, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return writeError(ErrUnsupportedFrameType, byte(messageType), len(data), 0)
+	}
+	return c.write(ctx, byte(messageType), data)
+}
+
+// encode serializes msg and selects the frame opcode for it. Raw messages
+// are sent verbatim; everything else, strings and byte slices included, is
+// JSON in a text frame.
+func encode[T any](msg T) (byte, []byte, error) {
+	switch v := any(msg).(type) {
+	case RawText:
+		return opText, []byte(v), nil
+	case RawBinary:
+		return opBinary, v, nil
+	}
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return 0, nil, ErrSerializationFailed
+	}
+	return opText, payload, nil
+}
+
+// RawText is a message sent as a text frame holding the string verbatim,
+// with no JSON encoding. A Conn[RawText] reads every message's payload
+// as is, whatever its frame type.
+type RawText string
+
+// RawBinary is a message sent as a binary frame holding the bytes
+// verbatim, with no JSON encoding. A Conn[RawBinary] reads every
+// message's payload as is, whatever its frame type.
+type RawBinary []byte
+
+// writeTimeout checks that a write of size bytes may proceed and returns
+// how long it may take
+func (c *Conn[T]) writeTimeout(ctx context.Context, opcode byte, size int) (time.Duration, error) {
+	if atomic.LoadInt32(&c.closed) != 0 {
+		return 0, ErrConnectionClosed
+	}
+
 	deadline := c.writeDeadline
 	if deadline == 0 {
 		deadline = 30 * time.Second // Default timeout
@@ -193,92 +535,237 @@ func (c *Conn[T]) Write(ctx context.Context, msg T) error {
 			deadline = time.Until(ctxDeadline)
 		}
 		if ctx.Err() != nil {
-			return ErrContextCanceled
+			return 0, ErrContextCanceled
 		}
 	}
 
-	c.writeMu.Lock()
-	defer c.writeMu.Unlock()
+	if size > c.upgrader.maxMessageSize {
+		return 0, writeError(ErrMessageTooLarge, opcode, size, c.upgrader.maxMessageSize)
+	}
+
+	return deadline, nil
+}
 
-	if err := c.conn.SetWriteDeadline(time.Now().Add(deadline)); err != nil {
+// writeMessage compresses, frames, and writes an encoded message.
+// Only frame emission is serialized by writeMu; compression without context
+// takeover runs before the lock is taken so concurrent writers overlap that
+// work. Client frames are masked as they are written, so writes don't
+// allocate a masked copy of the payload.
+func (c *Conn[T]) writeMessage(ctx context.Context, opcode byte, payload []byte) (err error) {
+	start := time.Now()
+	defer func() {
+		c.health.record(err)
+		c.recordWrite(len(payload), time.Since(start), err)
+	}()
+
+	deadline, err := c.writeTimeout(ctx, opcode, len(payload))
+	if err != nil {
 		return err
 	}
 
-	var payload []byte
-	var err error
+	// With context takeover the compressor window must see messages in the
+	// order they go out, as must plugins, so the frame is built under the
+	// lock
+	ordered := (c.compression != nil && c.compression.compressTakeover) || c.extensions != nil
 
-	if payload, err = json.Marshal(msg); err != nil {
-		switch v := any(msg).(type) {
-		case []byte:
-			payload = v
-		case string:
-			payload = []byte(v)
-		default:
-			return ErrSerializationFailed
+	var frame Frame
+	if !ordered {
+		if frame, err = c.buildFrame(opcode, payload); err != nil {
+			return err
 		}
 	}
 
-	if len(payload) > c.upgrader.maxMessageSize {
-		return ErrMessageTooLarge
-	}
+	deadline, stalled := c.stallTimeout(deadline)
+	err = func() error {
+		c.writeMu.Lock()
+		defer c.writeMu.Unlock()
 
-	var opcode byte
-	switch any(msg).(type) {
-	case string:
-		opcode = opText
-	case []byte:
-		opcode = opBinary
-	default:
-		opcode = opText // JSON is text frame
+		if c.IsClosed() || !c.acquireIO() {
+			return ErrConnectionClosed
+		}
+		defer c.releaseIO()
+		if err := c.conn.SetWriteDeadline(time.Now().Add(deadline)); err != nil {
+			return err
+		}
+
+		if ordered {
+			var err error
+			if frame, err = c.buildFrame(opcode, payload); err != nil {
+				return err
+			}
+		}
+
+		if err := c.writeFrame(&frame); err != nil {
+			return err
+		}
+
+		return c.flush()
+	}()
+	if err != nil {
+		return c.evictStalled(deadlineErr(ErrWriteDeadlineExceeded, c.closedErr(err)), stalled)
 	}
+	c.touch()
+	return nil
+}
 
+// buildFrame compresses and masks payload into a single data frame
+func (c *Conn[T]) buildFrame(opcode byte, payload []byte) (Frame, error) {
 	// Compress if compression is enabled and payload is large enough
 	compressed := false
 	if c.compression != nil && c.compression.ShouldCompress(len(payload)) {
 		compressedPayload, err := c.compression.Compress(payload)
-		if err == nil && len(compressedPayload) < len(payload) {
+		if err != nil && c.compression.compressTakeover {
+			// The compressor window may now be out of sync with the peer
+			return Frame{}, ErrCompressionFailed
+		}
+		// With context takeover the peer must see every compressed message
+		// to keep its window in sync, even if it didn't shrink
+		if err == nil && (c.compression.compressTakeover || len(compressedPayload) < len(payload)) {
+			if c.metrics != nil {
+				c.metrics.RecordCompression(len(payload), len(compressedPayload))
+			}
 			payload = compressedPayload
 			compressed = true
 		}
 	}
 
-	frame := &Frame{
+	var rsv byte
+	if c.extensions != nil {
+		var err error
+		if payload, rsv, err = c.transformWrite(opcode, payload); err != nil {
+			return Frame{}, err
+		}
+	}
+
+	frame := Frame{
 		Fin:     true,
-		Rsv1:    compressed, // RSV1 indicates compression
+		Rsv1:    compressed || rsv&rsv1Mask != 0, // RSV1 indicates compression
+		Rsv2:    rsv&rsv2Mask != 0,
+		Rsv3:    rsv&rsv3Mask != 0,
 		Opcode:  opcode,
-		Masked:  c.isClient, // Clients must mask frames
 		Payload: payload,
 	}
 
-	// Generate mask key for client connections
 	if c.isClient {
-		frame.MaskKey = make([]byte, 4)
-		if _, err := rand.Read(frame.MaskKey); err != nil {
-			return fmt.Errorf("axon: failed to generate mask key: %w", err)
-		}
-		// Mask the payload
-		maskedPayload := make([]byte, len(payload))
-		copy(maskedPayload, payload)
-		maskBytes(maskedPayload, frame.MaskKey)
-		frame.Payload = maskedPayload
+		maskFrame(&frame) // Clients must mask frames
 	}
 
-	if err := writeFrame(c.writer, c.writeBuf, frame); err != nil {
+	return frame, nil
+}
+
+// maskFrame marks frame to be masked as it is written, with a fresh mask
+// key chosen then, so its payload is never copied
+func maskFrame(frame *Frame) {
+	frame.Masked = true
+	frame.maskOnWrite = true
+}
+
+// Subprotocol returns the subprotocol negotiated in the handshake, or ""
+func (c *Conn[T]) Subprotocol() string {
+	return c.subprotocol
+}
+
+// Ping writes a ping carrying data, which is at most 125 bytes
+func (c *Conn[T]) Ping(ctx context.Context, data []byte) error {
+	return c.writeControl(ctx, opPing, data)
+}
+
+// Pong writes a pong carrying data, which is at most 125 bytes. Pings are
+// answered automatically unless a ping handler is set.
+func (c *Conn[T]) Pong(ctx context.Context, data []byte) error {
+	return c.writeControl(ctx, opPong, data)
+}
+
+// SetPingHandler sets the function called with the data of each ping read.
+// It replaces the automatic pong, so fn should answer with Pong. An error
+// from fn is returned by the read. data is only valid during the call; nil
+// restores the automatic pong.
+func (c *Conn[T]) SetPingHandler(fn func(data []byte) error) {
+	if fn == nil {
+		c.pingHandler.Store(nil)
+		return
+	}
+	c.pingHandler.Store(&fn)
+}
+
+// SetPongHandler sets the function called with the data of each pong read.
+// An error from fn is returned by the read. data is only valid during the
+// call.
+func (c *Conn[T]) SetPongHandler(fn func(data []byte) error) {
+	if fn == nil {
+		c.pongHandler.Store(nil)
+		return
+	}
+	c.pongHandler.Store(&fn)
+}
+
+// writeControl writes a control frame, masked on client connections
+func (c *Conn[T]) writeControl(ctx context.Context, opcode byte, payload []byte) error {
+	if len(payload) > maxControlPayloadSize {
+		return writeError(ErrControlFrameTooLarge, opcode, len(payload), maxControlPayloadSize)
+	}
+	deadline, err := c.writeTimeout(ctx, opcode, len(payload))
+	if err != nil {
 		return err
 	}
 
-	return c.writer.Flush()
+	return deadlineErr(ErrWriteDeadlineExceeded, c.closedErr(c.sendControl(opcode, payload, deadline)))
+}
+
+// sendControl writes a control frame within timeout, masked on client
+// connections. Pings, pongs and close frames all go through it, so they
+// are serialized with data frames by writeMu.
+func (c *Conn[T]) sendControl(opcode byte, payload []byte, timeout time.Duration) error {
+	frame := &Frame{Fin: true, Opcode: opcode, Payload: payload}
+	if c.isClient {
+		maskFrame(frame)
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if !c.acquireIO() {
+		return ErrConnectionClosed
+	}
+	defer c.releaseIO()
+	if err := c.conn.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	if err := c.writeFrame(frame); err != nil {
+		return err
+	}
+	return c.flush()
 }
 
 // Close closes the connection with the given code and reason
 func (c *Conn[T]) Close(code int, reason string) error {
+	return c.close(code, reason, false)
+}
+
+// close closes the connection, recording code and reason. When echo is set
+// it answers the peer's close frame, which carried them, with the code
+// alone.
+func (c *Conn[T]) close(code int, reason string, echo bool) error {
 	var closeErr error
 
 	c.closeOnce.Do(func() {
-		atomic.StoreInt32(&c.closed, 1)
-		c.closeCode = code
-		c.closeReason = reason
+		c.markClosed(code, reason)
+		c.logger().Debug("connection closed", "code", code, "reason", reason)
+
+		// Wake a Read blocked on the network at once, and give a Write in
+		// progress a short while to finish before the close frame
+		c.conn.SetReadDeadline(time.Now())
+		c.conn.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
 
+		if c.idleTimer != nil {
+			c.idleTimer.Stop()
+		}
+		if c.authTimer != nil {
+			c.authTimer.Stop()
+		}
+		if c.faultTimer != nil {
+			c.faultTimer.Stop()
+		}
 		if c.pingStop != nil {
 			close(c.pingStop)
 			c.pingWg.Wait()
@@ -287,42 +774,88 @@ func (c *Conn[T]) Close(code int, reason string) error {
 			}
 		}
 
+		if echo {
+			reason = ""
+		}
+		// Keep the close frame within the control frame limit
+		if len(reason) > maxControlPayloadSize-2 {
+			reason = truncateUTF8(reason, maxControlPayloadSize-2)
+		}
+
 		closePayload := make([]byte, 2+len(reason))
 		binary.BigEndian.PutUint16(closePayload[:2], uint16(code))
 		copy(closePayload[2:], reason)
-
-		closeFrame := &Frame{
-			Fin:     true,
-			Opcode:  opClose,
-			Payload: closePayload,
+		// A close frame that carried no code is echoed without one
+		if echo && code == int(CloseNoStatusReceived) {
+			closePayload = nil
 		}
 
-		// Set a short deadline to avoid blocking on close frame write
-		c.conn.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
-
-		if err := writeFrame(c.writer, c.writeBuf, closeFrame); err != nil {
+		// Use a short deadline to avoid blocking on the close frame
+		if err := c.sendControl(opClose, closePayload, 100*time.Millisecond); err != nil {
 			// Ignore write errors on close - connection may already be dead
 			c.conn.Close()
-			closeErr = nil
-			return
+		} else {
+			closeErr = c.conn.Close()
 		}
+		c.retireIO()
+	})
 
-		if err := c.writer.Flush(); err != nil {
-			// Ignore flush errors on close - connection may already be dead
-			c.conn.Close()
-			closeErr = nil
-			return
-		}
+	return closeErr
+}
 
-		closeErr = c.conn.Close()
+// markClosed records that the connection is closed and releases what it
+// holds outside of itself; it must run inside closeOnce
+func (c *Conn[T]) markClosed(code int, reason string) {
+	atomic.StoreInt32(&c.closed, 1)
+	c.closeCode = code
+	c.closeReason = reason
+	registry.remove(c.id)
+	if c.metrics != nil {
+		c.metrics.RecordDisconnection()
+	}
+	if q := c.sendQ.Load(); q != nil {
+		q.close()
+	}
+	if c.release != nil {
+		c.release()
+	}
+	if c.cancel != nil {
+		c.cancel()
+	}
+	if c.onClosed != nil {
+		c.onClosed()
+	}
+}
 
-		putBuffer(c.readBuf)
-		putBuffer(c.writeBuf)
-		putReader(c.reader)
-		putWriter(c.writer)
-	})
+// closedErr returns ErrConnectionClosed in place of err once the
+// connection is closed, so that I/O interrupted by Close reports it
+func (c *Conn[T]) closedErr(err error) error {
+	if err != nil && c.IsClosed() {
+		return ErrConnectionClosed
+	}
+	return err
+}
 
-	return closeErr
+// deadlineErr wraps err in sentinel if it is a network timeout, so callers
+// can match it with errors.Is while errors.As still finds the net.Error
+func deadlineErr(sentinel, err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("%w: %w", sentinel, err)
+	}
+	return err
+}
+
+// fail closes the connection with the given close code and returns err.
+// It is used when the peer violates the protocol and the connection must
+// be failed as described in RFC 6455 Section 7.1.7.
+func (c *Conn[T]) fail(code CloseCode, err error) error {
+	c.logger().Warn("failing connection", "code", int(code), "error", err)
+	if c.metrics != nil {
+		c.metrics.RecordFrameError()
+	}
+	c.Close(int(code), "")
+	return err
 }
 
 // startPingLoop starts the ping/pong keepalive loop
@@ -332,7 +865,7 @@ func (c *Conn[T]) startPingLoop() {
 	}
 
 	c.pingStop = make(chan struct{})
-	c.pingTicker = time.NewTicker(c.pingInterval)
+	c.pingTicker = c.clock().NewTicker(c.pingInterval)
 
 	c.pingWg.Add(1)
 	go func() {
@@ -340,16 +873,10 @@ func (c *Conn[T]) startPingLoop() {
 
 		for {
 			select {
-			case <-c.pingTicker.C:
-				pingFrame := &Frame{
-					Fin:     true,
-					Opcode:  opPing,
-					Payload: []byte("ping"),
-				}
-
-				if err := c.conn.SetWriteDeadline(time.Now().Add(c.pongTimeout)); err == nil {
-					writeFrame(c.writer, c.writeBuf, pingFrame)
-					c.writer.Flush()
+			case <-c.pingTicker.C():
+				payload := c.health.pingPayload(c.clock().Now())
+				if err := c.sendControl(opPing, payload, c.pongTimeout); err != nil {
+					c.logger().Warn("ping failed", "error", err)
 				}
 
 			case <-c.pingStop:
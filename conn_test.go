@@ -1,13 +1,23 @@
 package axon_test
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/sha1"
+	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	"github.com/kolosys/axon"
 )
@@ -250,9 +260,13 @@ func TestConnReadTimeout(t *testing.T) {
 		if err == nil {
 			t.Error("expected timeout error, got nil")
 		}
-		// Should be a timeout error (net.Error with Timeout() == true)
-		if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
-			t.Logf("got error (may be connection closed): %T: %v", err, err)
+		if !errors.Is(err, axon.ErrReadDeadlineExceeded) {
+			t.Errorf("expected ErrReadDeadlineExceeded, got %v", err)
+		}
+		// The network timeout is still wrapped
+		var netErr net.Error
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			t.Errorf("expected a net.Error timeout, got %T: %v", err, err)
 		}
 	case <-time.After(200 * time.Millisecond):
 		// Timeout test itself timed out - this is acceptable
@@ -261,6 +275,23 @@ func TestConnReadTimeout(t *testing.T) {
 	}
 }
 
+func TestConnWriteTimeout(t *testing.T) {
+	conn, clientConn, err := axon.NewTestConn[[]byte](&axon.UpgradeOptions{
+		WriteDeadline: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to create test connection: %v", err)
+	}
+	defer conn.Close(1000, "")
+	defer clientConn.Close()
+
+	// The peer never reads, so the write times out
+	err = conn.WriteMessage(context.Background(), axon.BinaryMessage, make([]byte, 64*1024))
+	if !errors.Is(err, axon.ErrWriteDeadlineExceeded) {
+		t.Errorf("expected ErrWriteDeadlineExceeded, got %v", err)
+	}
+}
+
 // readServerFrame reads an unmasked WebSocket frame sent by server
 func readServerFrame(r io.Reader) (opcode byte, payload []byte, err error) {
 	buf := make([]byte, 14)
@@ -378,7 +409,7 @@ func TestConnWriteJSONMessage(t *testing.T) {
 }
 
 func TestConnWriteBinaryMessage(t *testing.T) {
-	conn, clientConn, err := axon.NewTestConn[[]byte](nil)
+	conn, clientConn, err := axon.NewTestConn[axon.RawBinary](nil)
 	if err != nil {
 		t.Fatalf("failed to create test connection: %v", err)
 	}
@@ -406,19 +437,74 @@ func TestConnWriteBinaryMessage(t *testing.T) {
 
 	select {
 	case got := <-received:
-		// Binary data is JSON encoded, so unmarshal it
-		var decoded []byte
-		if err := json.Unmarshal(got, &decoded); err != nil {
-			t.Fatalf("failed to decode: %v", err)
+		// Raw binary messages are sent verbatim
+		if string(got) != string(data) {
+			t.Errorf("expected %v, got %v", data, got)
+		}
+	case <-time.After(time.Second):
+		t.Error("timeout waiting for message")
+	}
+}
+
+func TestConnWriteByteSliceJSON(t *testing.T) {
+	conn, clientConn, err := axon.NewTestConn[[]byte](nil)
+	if err != nil {
+		t.Fatalf("failed to create test connection: %v", err)
+	}
+	defer conn.Close(1000, "")
+	defer clientConn.Close()
+
+	type frame struct {
+		opcode  byte
+		payload []byte
+	}
+	received := make(chan frame, 1)
+	go func() {
+		opcode, payload, err := readServerFrame(clientConn)
+		if err == nil {
+			received <- frame{opcode, payload}
 		}
-		if len(decoded) != len(data) {
-			t.Errorf("expected %d bytes, got %d", len(data), len(decoded))
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// []byte is serialized as JSON like any other T
+	data := []byte{0x01, 0x02, 0x03}
+	if err := conn.Write(ctx, data); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	select {
+	case got := <-received:
+		want, _ := json.Marshal(data)
+		if got.opcode != 0x1 || string(got.payload) != string(want) {
+			t.Errorf("expected text frame %s, got opcode %d %q", want, got.opcode, got.payload)
 		}
 	case <-time.After(time.Second):
 		t.Error("timeout waiting for message")
 	}
 }
 
+func TestConnReadInvalidJSONString(t *testing.T) {
+	conn, clientConn, err := axon.NewTestConn[string](nil)
+	if err != nil {
+		t.Fatalf("failed to create test connection: %v", err)
+	}
+	defer conn.Close(1000, "")
+	defer clientConn.Close()
+
+	go writeClientFrame(clientConn, 0x1, []byte("not json"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// Text that is not JSON is not taken as the string itself
+	if _, err := conn.Read(ctx); err != axon.ErrDeserializationFailed {
+		t.Errorf("expected ErrDeserializationFailed, got %v", err)
+	}
+}
+
 func TestConnWriteConnectionClosed(t *testing.T) {
 	conn, clientConn, err := axon.NewTestConn[string](nil)
 	if err != nil {
@@ -509,6 +595,58 @@ func TestConnCloseIdempotent(t *testing.T) {
 	conn.Close(1002, "third")
 }
 
+func TestConnCloseInterruptsRead(t *testing.T) {
+	conn, clientConn, err := axon.NewTestConn[string](nil)
+	if err != nil {
+		t.Fatalf("failed to create test connection: %v", err)
+	}
+	defer clientConn.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(context.Background())
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// The peer never reads the close frame, so only the interrupt can wake
+	// the Read before the socket is closed
+	go conn.Close(1000, "")
+	select {
+	case err := <-done:
+		if err != axon.ErrConnectionClosed {
+			t.Errorf("expected ErrConnectionClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Read still blocked after Close")
+	}
+}
+
+func TestConnCloseInterruptsWrite(t *testing.T) {
+	conn, clientConn, err := axon.NewTestConn[[]byte](nil)
+	if err != nil {
+		t.Fatalf("failed to create test connection: %v", err)
+	}
+	defer clientConn.Close()
+
+	// The peer never reads, so the write blocks on the network
+	done := make(chan error, 1)
+	go func() {
+		done <- conn.WriteMessage(context.Background(), axon.BinaryMessage, make([]byte, 64*1024))
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	go conn.Close(1000, "")
+	select {
+	case err := <-done:
+		if err != axon.ErrConnectionClosed {
+			t.Errorf("expected ErrConnectionClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Write still blocked after Close")
+	}
+}
+
 func TestConnWriteContextCanceled(t *testing.T) {
 	conn, clientConn, err := axon.NewTestConn[string](nil)
 	if err != nil {
@@ -540,3 +678,961 @@ func TestConnStartPingLoop(t *testing.T) {
 	// Just verify the connection was created
 	_ = conn
 }
+
+// writeClientFragment writes a masked frame with an explicit FIN bit
+func writeClientFragment(w io.Writer, fin bool, opcode byte, payload []byte) error {
+	return writeClientFrameRSV1(w, fin, false, opcode, payload)
+}
+
+// writeClientFrameRSV1 writes a masked frame with control over RSV1, which
+// permessage-deflate uses to mark compressed messages
+func writeClientFrameRSV1(w io.Writer, fin, rsv1 bool, opcode byte, payload []byte) error {
+	header := []byte{opcode & 0x0F, 0x80 | byte(len(payload))}
+	if fin {
+		header[0] |= 0x80
+	}
+	if rsv1 {
+		header[0] |= 0x40
+	}
+
+	maskKey := []byte{0x12, 0x34, 0x56, 0x78}
+	masked := make([]byte, len(payload))
+	for i, b := range payload {
+		masked[i] = b ^ maskKey[i%4]
+	}
+
+	frame := append(append(header, maskKey...), masked...)
+	_, err := w.Write(frame)
+	return err
+}
+
+func TestConnReadInvalidUTF8(t *testing.T) {
+	conn, clientConn, err := axon.NewTestConn[string](nil)
+	if err != nil {
+		t.Fatalf("failed to create test connection: %v", err)
+	}
+	defer conn.Close(1000, "")
+	defer clientConn.Close()
+
+	closeCode := make(chan uint16, 1)
+	go func() {
+		writeClientFrame(clientConn, 0x1, []byte{'h', 0xff, 'i'})
+		opcode, payload, err := readServerFrame(clientConn)
+		if err == nil && opcode == 0x8 && len(payload) >= 2 {
+			closeCode <- binary.BigEndian.Uint16(payload)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, err = conn.Read(ctx)
+	if err != axon.ErrInvalidUTF8 {
+		t.Fatalf("expected ErrInvalidUTF8, got %v", err)
+	}
+
+	select {
+	case code := <-closeCode:
+		if code != uint16(axon.CloseInvalidPayloadData) {
+			t.Errorf("expected close code 1007, got %d", code)
+		}
+	case <-time.After(time.Second):
+		t.Error("timeout waiting for close frame")
+	}
+}
+
+func TestConnReadFragmentedUTF8(t *testing.T) {
+	conn, clientConn, err := axon.NewTestConn[axon.RawText](nil)
+	if err != nil {
+		t.Fatalf("failed to create test connection: %v", err)
+	}
+	defer conn.Close(1000, "")
+	defer clientConn.Close()
+
+	// "é" (0xC3 0xA9) split across two fragments
+	go func() {
+		writeClientFragment(clientConn, false, 0x1, []byte{'c', 'a', 'f', 0xC3})
+		writeClientFragment(clientConn, true, 0x0, []byte{0xA9})
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	got, err := conn.Read(ctx)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if got != "café" {
+		t.Errorf("expected 'café', got %q", got)
+	}
+}
+
+func TestConnReadTruncatedUTF8(t *testing.T) {
+	conn, clientConn, err := axon.NewTestConn[string](nil)
+	if err != nil {
+		t.Fatalf("failed to create test connection: %v", err)
+	}
+	defer conn.Close(1000, "")
+	defer clientConn.Close()
+
+	go func() {
+		writeClientFragment(clientConn, false, 0x1, []byte{'c', 'a', 'f', 0xC3})
+		writeClientFragment(clientConn, true, 0x0, []byte{'!'})
+		io.Copy(io.Discard, clientConn)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if _, err := conn.Read(ctx); err != axon.ErrInvalidUTF8 {
+		t.Errorf("expected ErrInvalidUTF8, got %v", err)
+	}
+}
+
+func TestConnReadCloseEchoes(t *testing.T) {
+	conn, clientConn, err := axon.NewTestConn[string](nil)
+	if err != nil {
+		t.Fatalf("failed to create test connection: %v", err)
+	}
+	defer clientConn.Close()
+
+	type echoed struct {
+		opcode  byte
+		payload []byte
+		after   error
+	}
+	result := make(chan echoed, 1)
+	go func() {
+		writeClientFrame(clientConn, 0x8, []byte{0x03, 0xE9, 'b', 'y', 'e'})
+		opcode, payload, err := readServerFrame(clientConn)
+		if err != nil {
+			result <- echoed{after: err}
+			return
+		}
+		// The socket is closed once the close frame is echoed
+		_, err = clientConn.Read(make([]byte, 1))
+		result <- echoed{opcode, payload, err}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var closeErr *axon.CloseError
+	if _, err := conn.Read(ctx); !errors.As(err, &closeErr) || closeErr.Code != axon.CloseGoingAway {
+		t.Errorf("expected a CloseError with CloseGoingAway, got %v", err)
+	}
+	if conn.CloseCode() != int(axon.CloseGoingAway) || conn.CloseReason() != "bye" {
+		t.Errorf("close = %d %q, want 1001 %q", conn.CloseCode(), conn.CloseReason(), "bye")
+	}
+
+	select {
+	case got := <-result:
+		if got.opcode != 0x8 || !bytes.Equal(got.payload, []byte{0x03, 0xE9}) {
+			t.Errorf("echoed frame = %d %v, want a close frame with code 1001", got.opcode, got.payload)
+		}
+		if got.after != io.EOF {
+			t.Errorf("read after the echo = %v, want io.EOF", got.after)
+		}
+	case <-time.After(time.Second):
+		t.Error("timeout waiting for the echoed close frame")
+	}
+}
+
+func TestConnReadCloseFrames(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		err     error // nil when the frame is echoed
+		code    axon.CloseCode
+		echoed  []byte
+	}{
+		{"Empty", nil, nil, axon.CloseNoStatusReceived, []byte{}},
+		{"OneByte", []byte{0x03}, axon.ErrInvalidFrame, axon.CloseProtocolError, []byte{0x03, 0xEA}},
+		{"Code1000", []byte{0x03, 0xE8}, nil, axon.CloseNormalClosure, []byte{0x03, 0xE8}},
+		{"Code3000", []byte{0x0B, 0xB8}, nil, 3000, []byte{0x0B, 0xB8}},
+		{"Code4999", []byte{0x13, 0x87}, nil, 4999, []byte{0x13, 0x87}},
+		{"Code0", []byte{0x00, 0x00}, axon.ErrInvalidCloseCode, axon.CloseProtocolError, []byte{0x03, 0xEA}},
+		{"Code999", []byte{0x03, 0xE7}, axon.ErrInvalidCloseCode, axon.CloseProtocolError, []byte{0x03, 0xEA}},
+		{"Code1004", []byte{0x03, 0xEC}, axon.ErrInvalidCloseCode, axon.CloseProtocolError, []byte{0x03, 0xEA}},
+		{"Code1005", []byte{0x03, 0xED}, axon.ErrInvalidCloseCode, axon.CloseProtocolError, []byte{0x03, 0xEA}},
+		{"Code1006", []byte{0x03, 0xEE}, axon.ErrInvalidCloseCode, axon.CloseProtocolError, []byte{0x03, 0xEA}},
+		{"Code1015", []byte{0x03, 0xF7}, axon.ErrInvalidCloseCode, axon.CloseProtocolError, []byte{0x03, 0xEA}},
+		{"Code1016", []byte{0x03, 0xF8}, axon.ErrInvalidCloseCode, axon.CloseProtocolError, []byte{0x03, 0xEA}},
+		{"Code2999", []byte{0x0B, 0xB7}, axon.ErrInvalidCloseCode, axon.CloseProtocolError, []byte{0x03, 0xEA}},
+		{"Code5000", []byte{0x13, 0x88}, axon.ErrInvalidCloseCode, axon.CloseProtocolError, []byte{0x03, 0xEA}},
+		{"Code65535", []byte{0xFF, 0xFF}, axon.ErrInvalidCloseCode, axon.CloseProtocolError, []byte{0x03, 0xEA}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, clientConn, err := axon.NewTestConn[string](nil)
+			if err != nil {
+				t.Fatalf("failed to create test connection: %v", err)
+			}
+			defer clientConn.Close()
+
+			sent := make(chan []byte, 1)
+			go func() {
+				writeClientFrame(clientConn, 0x8, tt.payload)
+				_, payload, err := readServerFrame(clientConn)
+				if err != nil {
+					payload = nil
+				}
+				sent <- payload
+			}()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			_, err = conn.Read(ctx)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("expected %v, got %v", tt.err, err)
+				}
+			} else {
+				var closeErr *axon.CloseError
+				if !errors.As(err, &closeErr) || closeErr.Code != tt.code {
+					t.Errorf("expected a CloseError with code %d, got %v", tt.code, err)
+				}
+			}
+			if conn.CloseCode() != int(tt.code) {
+				t.Errorf("close code = %d, want %d", conn.CloseCode(), tt.code)
+			}
+
+			select {
+			case payload := <-sent:
+				if !bytes.Equal(payload, tt.echoed) {
+					t.Errorf("sent close payload %v, want %v", payload, tt.echoed)
+				}
+			case <-time.After(time.Second):
+				t.Error("timeout waiting for the close frame")
+			}
+		})
+	}
+}
+
+func TestConnReadCloseInvalidUTF8Reason(t *testing.T) {
+	conn, clientConn, err := axon.NewTestConn[string](nil)
+	if err != nil {
+		t.Fatalf("failed to create test connection: %v", err)
+	}
+	defer conn.Close(1000, "")
+	defer clientConn.Close()
+
+	go func() {
+		writeClientFrame(clientConn, 0x8, []byte{0x03, 0xE8, 0xff, 0xfe})
+		io.Copy(io.Discard, clientConn)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if _, err := conn.Read(ctx); err != axon.ErrInvalidUTF8 {
+		t.Errorf("expected ErrInvalidUTF8, got %v", err)
+	}
+	if conn.CloseCode() != int(axon.CloseInvalidPayloadData) {
+		t.Errorf("expected close code 1007, got %d", conn.CloseCode())
+	}
+}
+
+func TestConnReadControlFrameTooLarge(t *testing.T) {
+	conn, clientConn, err := axon.NewTestConn[string](nil)
+	if err != nil {
+		t.Fatalf("failed to create test connection: %v", err)
+	}
+	defer conn.Close(1000, "")
+	defer clientConn.Close()
+
+	go func() {
+		writeClientFrame(clientConn, 0x9, make([]byte, 200))
+		io.Copy(io.Discard, clientConn)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if _, err := conn.Read(ctx); !errors.Is(err, axon.ErrControlFrameTooLarge) {
+		t.Errorf("expected ErrControlFrameTooLarge, got %v", err)
+	}
+	if conn.CloseCode() != int(axon.CloseProtocolError) {
+		t.Errorf("expected close code 1002, got %d", conn.CloseCode())
+	}
+}
+
+func TestConnReadUnmaskedClientFrame(t *testing.T) {
+	conn, clientConn, err := axon.NewTestConn[string](nil)
+	if err != nil {
+		t.Fatalf("failed to create test connection: %v", err)
+	}
+	defer conn.Close(1000, "")
+	defer clientConn.Close()
+
+	go func() {
+		clientConn.Write([]byte{0x81, 0x04, '"', 'h', 'i', '"'})
+		io.Copy(io.Discard, clientConn)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if _, err := conn.Read(ctx); !errors.Is(err, axon.ErrInvalidMask) {
+		t.Errorf("expected ErrInvalidMask, got %v", err)
+	}
+	if conn.CloseCode() != int(axon.CloseProtocolError) {
+		t.Errorf("expected close code 1002, got %d", conn.CloseCode())
+	}
+}
+
+func TestConnReadLenientProtocol(t *testing.T) {
+	conn, clientConn, err := axon.NewTestConn[axon.RawText](&axon.UpgradeOptions{LenientProtocol: true})
+	if err != nil {
+		t.Fatalf("failed to create test connection: %v", err)
+	}
+	defer conn.Close(1000, "")
+	defer clientConn.Close()
+
+	pong := make(chan []byte, 1)
+	go func() {
+		// Unmasked, and not valid UTF-8
+		clientConn.Write([]byte{0x81, 0x03, 'h', 0xff, 'i'})
+		writeClientFrame(clientConn, 0x9, []byte(strings.Repeat("p", 200)))
+		if opcode, payload, err := readServerFrame(clientConn); err == nil && opcode == 0xA {
+			pong <- payload
+		}
+		writeClientFrame(clientConn, 0x8, []byte{0x03, 0xE8, 0xff, 0xfe})
+		io.Copy(io.Discard, clientConn)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	msg, err := conn.Read(ctx)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if msg != "h\xffi" {
+		t.Errorf("expected the invalid text as sent, got %q", msg)
+	}
+
+	var closeErr *axon.CloseError
+	if _, err := conn.Read(ctx); !errors.As(err, &closeErr) || closeErr.Code != axon.CloseNormalClosure {
+		t.Errorf("expected a normal CloseError despite the invalid reason, got %v", err)
+	}
+	select {
+	case payload := <-pong:
+		if len(payload) != 125 {
+			t.Errorf("expected the oversized ping to be answered with 125 bytes, got %d", len(payload))
+		}
+	default:
+		t.Error("oversized ping was not answered")
+	}
+}
+
+func TestConnCloseLongReason(t *testing.T) {
+	conn, clientConn, err := axon.NewTestConn[string](nil)
+	if err != nil {
+		t.Fatalf("failed to create test connection: %v", err)
+	}
+	defer clientConn.Close()
+
+	received := make(chan []byte, 1)
+	go func() {
+		opcode, payload, err := readServerFrame(clientConn)
+		if err == nil && opcode == 0x8 {
+			received <- payload
+		}
+	}()
+
+	conn.Close(1000, strings.Repeat("é", 100))
+
+	select {
+	case payload := <-received:
+		if len(payload) > 125 {
+			t.Errorf("close payload is %d bytes, want <= 125", len(payload))
+		}
+		if !utf8.Valid(payload[2:]) {
+			t.Error("truncated close reason is not valid UTF-8")
+		}
+	case <-time.After(time.Second):
+		t.Error("timeout waiting for close frame")
+	}
+}
+
+func TestConnReadBinaryNotOverwritten(t *testing.T) {
+	conn, clientConn, err := axon.NewTestConn[axon.RawBinary](nil)
+	if err != nil {
+		t.Fatalf("failed to create test connection: %v", err)
+	}
+	defer conn.Close(1000, "")
+	defer clientConn.Close()
+
+	go func() {
+		writeClientFrame(clientConn, 0x2, []byte("first"))
+		writeClientFrame(clientConn, 0x2, []byte("other"))
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	first, err := conn.Read(ctx)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if _, err := conn.Read(ctx); err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+
+	// The first message must not alias the reused read buffer
+	if string(first) != "first" {
+		t.Errorf("expected 'first', got %q", first)
+	}
+}
+
+func TestConnReadMessage(t *testing.T) {
+	conn, clientConn, err := axon.NewTestConn[string](nil)
+	if err != nil {
+		t.Fatalf("failed to create test connection: %v", err)
+	}
+	defer conn.Close(1000, "")
+	defer clientConn.Close()
+
+	go func() {
+		writeClientFrame(clientConn, 0x2, []byte{0x00, 0x01, 0x02})
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	mt, data, err := conn.ReadMessage(ctx)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if mt != axon.BinaryMessage {
+		t.Errorf("expected BinaryMessage, got %v", mt)
+	}
+	if string(data) != "\x00\x01\x02" {
+		t.Errorf("unexpected payload %v", data)
+	}
+}
+
+func TestConnWriteMessage(t *testing.T) {
+	conn, clientConn, err := axon.NewTestConn[string](nil)
+	if err != nil {
+		t.Fatalf("failed to create test connection: %v", err)
+	}
+	defer conn.Close(1000, "")
+	defer clientConn.Close()
+
+	type result struct {
+		opcode  byte
+		payload []byte
+	}
+	received := make(chan result, 1)
+	go func() {
+		opcode, payload, err := readServerFrame(clientConn)
+		if err == nil {
+			received <- result{opcode, payload}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := conn.WriteMessage(ctx, axon.TextMessage, []byte("raw text")); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	select {
+	case r := <-received:
+		if r.opcode != 0x1 {
+			t.Errorf("expected text opcode, got 0x%x", r.opcode)
+		}
+		if string(r.payload) != "raw text" {
+			t.Errorf("expected unencoded payload, got %q", r.payload)
+		}
+	case <-time.After(time.Second):
+		t.Error("timeout waiting for message")
+	}
+
+	if err := conn.WriteMessage(ctx, axon.MessageType(0x9), nil); !errors.Is(err, axon.ErrUnsupportedFrameType) {
+		t.Errorf("expected ErrUnsupportedFrameType, got %v", err)
+	}
+}
+
+func TestConnReadRSV1PerMessage(t *testing.T) {
+	conn, clientConn, err := axon.NewTestConn[axon.RawText](&axon.UpgradeOptions{Compression: true})
+	if err != nil {
+		t.Fatalf("failed to create test connection: %v", err)
+	}
+	defer conn.Close(1000, "")
+	defer clientConn.Close()
+
+	compressor, _ := axon.NewCompressionPair(false, false)
+	compressed, err := compressor.Compress([]byte(strings.Repeat("compressed ", 10)))
+	if err != nil {
+		t.Fatalf("compress failed: %v", err)
+	}
+
+	go func() {
+		writeClientFrameRSV1(clientConn, true, false, 0x1, []byte("plain"))
+		writeClientFrameRSV1(clientConn, true, true, 0x1, compressed)
+		writeClientFrameRSV1(clientConn, true, false, 0x1, []byte("plain again"))
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	for _, want := range []string{"plain", strings.Repeat("compressed ", 10), "plain again"} {
+		got, err := conn.Read(ctx)
+		if err != nil {
+			t.Fatalf("read failed: %v", err)
+		}
+		if string(got) != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	}
+}
+
+func TestConnReadRSV1InvalidFrames(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames func(w io.Writer)
+	}{
+		{
+			name: "continuation",
+			frames: func(w io.Writer) {
+				writeClientFrameRSV1(w, false, false, 0x1, []byte("he"))
+				writeClientFrameRSV1(w, true, true, 0x0, []byte("llo"))
+			},
+		},
+		{
+			name: "ping",
+			frames: func(w io.Writer) {
+				writeClientFrameRSV1(w, true, true, 0x9, []byte("ping"))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, clientConn, err := axon.NewTestConn[string](&axon.UpgradeOptions{Compression: true})
+			if err != nil {
+				t.Fatalf("failed to create test connection: %v", err)
+			}
+			defer conn.Close(1000, "")
+			defer clientConn.Close()
+
+			closeCode := make(chan uint16, 1)
+			go func() {
+				tt.frames(clientConn)
+				opcode, payload, err := readServerFrame(clientConn)
+				if err == nil && opcode == 0x8 && len(payload) >= 2 {
+					closeCode <- binary.BigEndian.Uint16(payload)
+				}
+			}()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			if _, err := conn.Read(ctx); !errors.Is(err, axon.ErrInvalidFrame) {
+				t.Fatalf("expected ErrInvalidFrame, got %v", err)
+			}
+
+			select {
+			case code := <-closeCode:
+				if code != uint16(axon.CloseProtocolError) {
+					t.Errorf("expected close code 1002, got %d", code)
+				}
+			case <-time.After(time.Second):
+				t.Error("timeout waiting for close frame")
+			}
+		})
+	}
+}
+
+func TestConnMetadata(t *testing.T) {
+	conn, clientConn, err := axon.NewTestConn[string](nil)
+	if err != nil {
+		t.Fatalf("failed to create test connection: %v", err)
+	}
+	defer conn.Close(1000, "")
+	defer clientConn.Close()
+
+	if _, ok := conn.Get("user"); ok {
+		t.Error("expected no metadata on a new connection")
+	}
+
+	conn.Set("user", "alice")
+	if v, ok := conn.Get("user"); !ok || v != "alice" {
+		t.Errorf("expected user=alice, got %v (ok=%v)", v, ok)
+	}
+
+	conn.Delete("user")
+	if _, ok := conn.Get("user"); ok {
+		t.Error("expected metadata to be deleted")
+	}
+
+	if conn.RemoteAddr() == nil || conn.LocalAddr() == nil {
+		t.Error("expected non-nil addresses")
+	}
+}
+
+func TestConnConcurrentWrites(t *testing.T) {
+	conn, clientConn, err := axon.NewTestConn[string](nil)
+	if err != nil {
+		t.Fatalf("failed to create test connection: %v", err)
+	}
+	defer conn.Close(1000, "")
+	defer clientConn.Close()
+
+	const writers, perWriter = 8, 20
+	msg := strings.Repeat("x", 300)
+
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		go func() {
+			for j := 0; j < perWriter; j++ {
+				if err := conn.Write(context.Background(), msg); err != nil {
+					errs <- err
+					return
+				}
+			}
+			errs <- nil
+		}()
+	}
+
+	// Frames from concurrent writers must never interleave
+	want := `"` + msg + `"`
+	clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for i := 0; i < writers*perWriter; i++ {
+		opcode, payload, err := readServerFrame(clientConn)
+		if err != nil {
+			t.Fatalf("failed to read frame %d: %v", i, err)
+		}
+		if opcode != 0x1 || string(payload) != want {
+			t.Fatalf("frame %d corrupted: opcode %d, %d bytes", i, opcode, len(payload))
+		}
+	}
+
+	for i := 0; i < writers; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("write failed: %v", err)
+		}
+	}
+}
+
+func TestConnPingLoopConcurrentWrites(t *testing.T) {
+	conn, clientConn, err := axon.NewTestConn[string](&axon.UpgradeOptions{
+		PingInterval: time.Millisecond,
+		PongTimeout:  time.Second,
+	})
+	if err != nil {
+		t.Fatalf("failed to create test connection: %v", err)
+	}
+	defer clientConn.Close()
+
+	const writers, perWriter = 4, 50
+	msg := strings.Repeat("x", 300)
+
+	done := make(chan struct{})
+	for i := 0; i < writers; i++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			for j := 0; j < perWriter; j++ {
+				if conn.Write(context.Background(), msg) != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	// Pings and the close frame must never interleave with data frames
+	want := `"` + msg + `"`
+	clientConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for data := 0; ; {
+		opcode, payload, err := readServerFrame(clientConn)
+		if err != nil {
+			t.Fatalf("failed to read frame: %v", err)
+		}
+		switch opcode {
+		case 0x1:
+			if string(payload) != want {
+				t.Fatalf("data frame corrupted: %d bytes", len(payload))
+			}
+			data++
+			if data == writers*perWriter/2 {
+				go conn.Close(1000, "done")
+			}
+		case 0x9:
+			if len(payload) > 125 {
+				t.Fatalf("ping frame corrupted: %d bytes", len(payload))
+			}
+		case 0x8:
+			if code := binary.BigEndian.Uint16(payload); code != 1000 {
+				t.Fatalf("close frame corrupted: code %d", code)
+			}
+			for i := 0; i < writers; i++ {
+				<-done
+			}
+			return
+		default:
+			t.Fatalf("unexpected opcode %d", opcode)
+		}
+	}
+}
+
+func TestConnPingHandler(t *testing.T) {
+	conn, clientConn, err := axon.NewTestConn[string](nil)
+	if err != nil {
+		t.Fatalf("failed to create test connection: %v", err)
+	}
+	defer conn.Close(1000, "")
+	defer clientConn.Close()
+
+	pings := make(chan string, 1)
+	conn.SetPingHandler(func(data []byte) error {
+		pings <- string(data)
+		return nil
+	})
+
+	go func() {
+		writeClientFrame(clientConn, 0x9, []byte("ping"))
+		writeClientFrame(clientConn, 0x1, []byte(`"done"`))
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if msg, err := conn.Read(ctx); err != nil || msg != "done" {
+		t.Fatalf("Read() = %q, %v", msg, err)
+	}
+	select {
+	case data := <-pings:
+		if data != "ping" {
+			t.Errorf("ping handler got %q, want %q", data, "ping")
+		}
+	default:
+		t.Fatal("ping handler not called")
+	}
+
+	// The handler replaces the automatic pong
+	clientConn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if n, _ := clientConn.Read(make([]byte, 16)); n != 0 {
+		t.Errorf("client received %d bytes, want no pong", n)
+	}
+}
+
+func TestConnPingPong(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Server", "axon")
+		conn, err := axon.Upgrade[string](w, r, &axon.UpgradeOptions{Subprotocols: []string{"v2", "v1"}})
+		if err != nil {
+			return
+		}
+		defer conn.Close(1000, "")
+		for {
+			msg, err := conn.Read(r.Context())
+			if err != nil {
+				return
+			}
+			conn.Write(r.Context(), msg)
+		}
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := axon.Dial[string](ctx, "ws"+strings.TrimPrefix(server.URL, "http"), &axon.DialOptions{Subprotocols: []string{"v1"}})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close(1000, "")
+	if got := conn.Subprotocol(); got != "v1" {
+		t.Errorf("Subprotocol() = %q, want %q", got, "v1")
+	}
+
+	pongs := make(chan string, 1)
+	conn.SetPongHandler(func(data []byte) error {
+		pongs <- string(data)
+		return nil
+	})
+	if err := conn.Ping(ctx, []byte("hello")); err != nil {
+		t.Fatalf("Ping() error = %v", err)
+	}
+	if err := conn.Ping(ctx, make([]byte, 126)); err == nil {
+		t.Error("Ping() with a 126 byte payload succeeded")
+	}
+
+	// Pongs are handled while reading
+	if err := conn.Write(ctx, "x"); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if _, err := conn.Read(ctx); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	select {
+	case data := <-pongs:
+		if data != "hello" {
+			t.Errorf("pong handler got %q, want %q", data, "hello")
+		}
+	default:
+		t.Fatal("pong handler not called")
+	}
+}
+
+func TestUpgradeResponseHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Set-Cookie", "session=abc")
+		w.Header().Set("Upgrade", "not-websocket")
+		conn, err := axon.Upgrade[string](w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Close(1000, "")
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("handshake error = %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want 101", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Set-Cookie"); got != "session=abc" {
+		t.Errorf("Set-Cookie = %q, want %q", got, "session=abc")
+	}
+	if got := resp.Header.Values("Upgrade"); len(got) != 1 || got[0] != "websocket" {
+		t.Errorf("Upgrade = %q, want only the handshake's", got)
+	}
+}
+
+func TestClientWrite_MasksLargeMessages(t *testing.T) {
+	client, server, err := axon.Pipe[[]byte](nil, &axon.UpgradeOptions{MaxFrameSize: 64 * 1024, MaxMessageSize: 64 * 1024})
+	if err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	defer client.Close(1000, "")
+	defer server.Close(1000, "")
+
+	// Larger than the write buffer, so the payload is masked in chunks
+	msg := make([]byte, 3*4096+5)
+	for i := range msg {
+		msg[i] = byte(i * 7)
+	}
+	orig := append([]byte(nil), msg...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- client.WriteMessage(ctx, axon.BinaryMessage, msg)
+	}()
+	_, got, err := server.ReadMessage(ctx)
+	if err != nil {
+		t.Fatalf("ReadMessage() error = %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("WriteMessage() error = %v", err)
+	}
+	if string(got) != string(orig) {
+		t.Error("server read a different payload than the client wrote")
+	}
+	if string(msg) != string(orig) {
+		t.Error("WriteMessage() modified the caller's payload")
+	}
+}
+
+func TestServerWrite_LargeMessagesVectored(t *testing.T) {
+	client, server, err := axon.Pipe[[]byte](
+		&axon.DialOptions{MaxFrameSize: 64 * 1024, MaxMessageSize: 64 * 1024},
+		&axon.UpgradeOptions{WriteBufferSize: 1024},
+	)
+	if err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	defer client.Close(1000, "")
+	defer server.Close(1000, "")
+
+	// Larger than the write buffer, so the header and payload are sent
+	// together, between messages that go through the buffer
+	large := make([]byte, 40*1024+3)
+	for i := range large {
+		large[i] = byte(i * 13)
+	}
+	msgs := [][]byte{[]byte("before"), large, []byte("after")}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() {
+		for _, msg := range msgs {
+			if err := server.WriteMessage(ctx, axon.BinaryMessage, msg); err != nil {
+				errc <- err
+				return
+			}
+		}
+		errc <- nil
+	}()
+	for i, want := range msgs {
+		_, got, err := client.ReadMessage(ctx)
+		if err != nil {
+			t.Fatalf("ReadMessage() error = %v", err)
+		}
+		if string(got) != string(want) {
+			t.Errorf("message %d: read %d bytes that differ from the %d written", i, len(got), len(want))
+		}
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("WriteMessage() error = %v", err)
+	}
+}
+
+func TestClientWrite_NoAllocs(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	// A raw server that answers the handshake and discards every frame, so
+	// only the client's allocations are counted
+	go func() {
+		nc, err := ln.Accept()
+		ln.Close()
+		if err != nil {
+			return
+		}
+		defer nc.Close()
+		req, err := http.ReadRequest(bufio.NewReader(nc))
+		if err != nil {
+			return
+		}
+		h := sha1.Sum([]byte(req.Header.Get("Sec-WebSocket-Key") + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+		fmt.Fprintf(nc, "HTTP/1.1 101 Switching Protocols\r\n"+
+			"Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+			base64.StdEncoding.EncodeToString(h[:]))
+		io.Copy(io.Discard, nc)
+	}()
+
+	ctx := context.Background()
+	conn, err := axon.Dial[[]byte](ctx, "ws://"+ln.Addr().String()+"/", nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close(1000, "")
+
+	msg := make([]byte, 16*1024)
+	allocs := testing.AllocsPerRun(100, func() {
+		if err := conn.WriteMessage(ctx, axon.BinaryMessage, msg); err != nil {
+			t.Fatalf("WriteMessage() error = %v", err)
+		}
+	})
+	if allocs > 0 {
+		t.Errorf("WriteMessage() allocated %.1f times per message, want 0", allocs)
+	}
+}
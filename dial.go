@@ -2,16 +2,22 @@ package axon
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"crypto/rand"
 	"crypto/sha1"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -30,6 +36,12 @@ type DialOptions struct {
 	// Default is 4096 bytes.
 	WriteBufferSize int
 
+	// DisableBufferPool allocates the read and write buffers of each
+	// connection rather than taking them from shared pools, so no idle
+	// buffers are kept in memory. Pooled buffers are rounded up to a power
+	// of two, and buffers over 1MB are never pooled.
+	DisableBufferPool bool
+
 	// MaxFrameSize sets the maximum frame size in bytes.
 	// Default is 4096 bytes.
 	MaxFrameSize int
@@ -38,6 +50,16 @@ type DialOptions struct {
 	// Default is 1048576 bytes (1MB).
 	MaxMessageSize int
 
+	// LenientProtocol tolerates protocol violations of sloppy peers rather
+	// than closing the connection: frames masked contrary to RFC 6455
+	// Section 5.1, text messages and close reasons that are not valid
+	// UTF-8, and control frames over 125 bytes, whose pings are answered
+	// with the first 125 bytes. Unsolicited pongs, which the RFC allows,
+	// are always accepted.
+	// Default is false (violations close the connection with
+	// CloseProtocolError or CloseInvalidPayloadData).
+	LenientProtocol bool
+
 	// ReadDeadline sets the read deadline for connections.
 	// Default is no deadline.
 	ReadDeadline time.Duration
@@ -54,6 +76,17 @@ type DialOptions struct {
 	// If zero, pong timeout is disabled.
 	PongTimeout time.Duration
 
+	// ExtendReadDeadline makes received pongs, or any frames, push the
+	// read deadline back by PingInterval plus PongTimeout; see
+	// UpgradeOptions.ExtendReadDeadline.
+	// Default is ExtendNever.
+	ExtendReadDeadline DeadlineExtension
+
+	// Clock drives the connection's ping timer and, for a Client, its
+	// reconnection backoff, heartbeats, health checks and queue expiry.
+	// Default is SystemClock.
+	Clock Clock
+
 	// Subprotocols sets the list of supported subprotocols.
 	// Default is nil (no subprotocols).
 	Subprotocols []string
@@ -67,16 +100,159 @@ type DialOptions struct {
 	// Default is 256 bytes.
 	CompressionThreshold int
 
+	// ClientNoContextTakeover resets the client's compressor after every
+	// message instead of keeping the sliding window across messages.
+	// This lowers the compression ratio but frees per-connection state.
+	// Default is false (context takeover).
+	ClientNoContextTakeover bool
+
+	// ServerNoContextTakeover asks the server to reset its compressor after
+	// every message, so the client need not retain a decompression window.
+	// Default is false (context takeover).
+	ServerNoContextTakeover bool
+
+	// ServerMaxWindowBits asks the server to limit its LZ77 window to
+	// 2^ServerMaxWindowBits bytes. Valid values are 8-15.
+	// Default is 0 (no limit requested).
+	ServerMaxWindowBits int
+
+	// CompressionLevel sets the flate compression level, from
+	// flate.HuffmanOnly (-2) to flate.BestCompression (9).
+	// Default is 0, which selects flate.BestSpeed.
+	CompressionLevel int
+
+	// CompressionStrategy selects a per-connection or pooled compressor.
+	// CompressionPooled implies ClientNoContextTakeover.
+	// Default is CompressionPerConnection.
+	CompressionStrategy CompressionStrategy
+
+	// Extensions are extension plugins offered to the server, in order
+	// after permessage-deflate.
+	// Default is nil (none).
+	Extensions []ExtensionPlugin
+
+	// SendQueueSize sets the capacity of the queue used by SendAsync.
+	// Default is 256 messages.
+	SendQueueSize int
+
+	// OverflowPolicy decides what SendAsync does when the queue is full.
+	// Default is OverflowDropNewest.
+	OverflowPolicy OverflowPolicy
+
 	// Headers sets additional HTTP headers for the handshake request.
 	Headers http.Header
 
+	// PayloadCipher encrypts and decrypts every message payload, end to
+	// end and independently of TLS; see Conn.UseCipher.
+	// Default is nil.
+	PayloadCipher PayloadCipher
+
+	// Faults injects dropped messages, delays and forced closes into
+	// every connection, for resilience tests; see Conn.InjectFaults.
+	// Default is nil.
+	Faults *Faults
+
+	// Authenticator supplies the bearer token sent in the Authorization
+	// header. Its Token method is called before every dial, including a
+	// Client's reconnection attempts, so expired tokens are refreshed.
+	// Default is nil.
+	Authenticator Authenticator
+
+	// BaseContext returns the context that the context of a dialed
+	// connection derives from, rather than the context passed to Dial,
+	// which often only bounds the handshake.
+	// Default is nil (context.Background()).
+	BaseContext func() context.Context
+
+	// Host overrides the Host header of the handshake request, which
+	// otherwise comes from the URL. The dialed address and TLS server name
+	// are unaffected.
+	Host string
+
+	// EnableHTTP2 bootstraps the connection over HTTP/2 with an extended
+	// CONNECT request (RFC 8441) instead of an HTTP/1.1 upgrade. The
+	// server must speak HTTP/2, over TLS for wss:// URLs and with prior
+	// knowledge for ws:// URLs, and advertise
+	// SETTINGS_ENABLE_CONNECT_PROTOCOL. Each connection has its own HTTP/2
+	// connection.
+	// Default is false.
+	EnableHTTP2 bool
+
 	// TLSConfig specifies the TLS configuration to use for wss:// connections.
 	// If nil, the default configuration is used.
 	TLSConfig *tls.Config
 
+	// GetClientCertificate supplies the client certificate for mutual TLS,
+	// replacing TLSConfig's. It is called on every handshake, so rotated
+	// certificates are picked up; see CertReloader.
+	// Default is nil.
+	GetClientCertificate func(*tls.CertificateRequestInfo) (*tls.Certificate, error)
+
+	// VerifyPeerCertificate checks the server's certificate after normal
+	// verification, replacing TLSConfig's, such as to pin the identity of
+	// the peers of a service mesh.
+	// Default is nil.
+	VerifyPeerCertificate func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error
+
 	// NetDialer specifies the dialer to use for creating the network connection.
 	// If nil, a default dialer is used.
 	NetDialer *net.Dialer
+
+	// Proxy returns the proxy to connect through for a request, or nil to
+	// connect directly. http and https proxies are tunneled with CONNECT;
+	// socks5 and socks5h proxies are also supported.
+	// If nil, http.ProxyFromEnvironment is used.
+	Proxy func(*http.Request) (*url.URL, error)
+
+	// MaxRedirects is the number of 301, 302, 307 and 308 responses to the
+	// handshake that are followed to their Location. Headers are sent
+	// again to each new location, except that Authorization, Cookie and
+	// URL credentials are dropped once the scheme or host changes.
+	// Default is 0 (redirects fail the dial).
+	MaxRedirects int
+
+	// CheckRedirect decides whether to follow a redirect to next, given the
+	// URLs dialed so far, oldest first. Returning an error stops the dial.
+	// Default allows only redirects with the same scheme and host.
+	CheckRedirect func(next *url.URL, via []*url.URL) error
+
+	// Resolver is used to look up host names when NetDialer dials.
+	// If nil, NetDialer's resolver is used.
+	Resolver *net.Resolver
+
+	// HostOverrides maps a "host:port" from the URL to the address to
+	// connect to instead, such as "127.0.0.1:8080" or "unix:/run/ws.sock"
+	// for a unix domain socket. The Host header and TLS server name still
+	// use the URL. Overridden hosts are dialed directly, without Proxy.
+	HostOverrides map[string]string
+
+	// FallbackDelay is the head start each connection attempt gets before
+	// the next address is tried. Host names that resolve to several
+	// addresses are raced, alternating IPv6 and IPv4 (RFC 8305).
+	// Default is 250ms; a negative value leaves dialing to NetDialer.
+	FallbackDelay time.Duration
+
+	// DialContext creates network connections, including connections to
+	// proxies. It takes precedence over NetDialer, Resolver and
+	// FallbackDelay.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// Metrics records the dialed connections' traffic and failed dials,
+	// and a Client's reconnections and queued messages, if set.
+	// Default is nil.
+	Metrics *Metrics
+
+	// Logger receives records about dials and the dialed connections, such
+	// as failed pings and protocol errors. Connection records carry a
+	// conn_id attribute. A Client also logs its reconnection attempts.
+	// Default is nil (no logging).
+	Logger *slog.Logger
+
+	// Trace is called with every frame read or written on dialed
+	// connections, for wire-level debugging. Wrap it with RedactPayloads
+	// to keep message contents out of traces.
+	// Default is nil.
+	Trace TraceFunc
 }
 
 // Dialer is a WebSocket client dialer
@@ -105,7 +281,156 @@ func DialWithDialer[T any](ctx context.Context, d *Dialer, rawURL string) (*Conn
 	if err != nil {
 		return nil, fmt.Errorf("axon: invalid URL: %w", err)
 	}
+	if d, err = d.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	var via []*url.URL
+	for {
+		conn, err := dialURL[T](ctx, d, u)
+		location, ok := d.redirectLocation(err)
+		if !ok {
+			if err != nil {
+				loggerOr(d.opts.Logger).Debug("dial failed", "url", u.Redacted(), "error", err)
+				if d.opts.Metrics != nil {
+					d.opts.Metrics.RecordHandshakeError()
+				}
+			} else {
+				conn.logger().Debug("connection dialed", "url", u.Redacted())
+			}
+			return conn, err
+		}
+		loggerOr(d.opts.Logger).Debug("following redirect", "url", u.Redacted(), "location", location)
 
+		via = append(via, u)
+		next, err := d.nextRedirect(u, location, via)
+		if err != nil {
+			return nil, err
+		}
+		// Like net/http, credentials never follow a redirect to another origin
+		if next.Scheme != u.Scheme || next.Host != u.Host {
+			d = d.withoutCredentials()
+			next.User = nil
+		}
+		u = next
+	}
+}
+
+// maxHandshakeErrorBody bounds the response body kept in a HandshakeError
+const maxHandshakeErrorBody = 16 * 1024
+
+// HandshakeError is returned by Dial when the server answers the handshake
+// with a status other than 101 Switching Protocols, such as 401 or 429
+type HandshakeError struct {
+	// Response is the server's response. Its body holds the first 16KB
+	// the server sent and needs no closing.
+	Response *http.Response
+}
+
+// Error returns the error message
+func (e *HandshakeError) Error() string {
+	return fmt.Sprintf("axon: unexpected status code: %d", e.Response.StatusCode)
+}
+
+// StatusCode returns the response status code
+func (e *HandshakeError) StatusCode() int {
+	return e.Response.StatusCode
+}
+
+// Header returns the response headers, such as WWW-Authenticate or
+// Retry-After
+func (e *HandshakeError) Header() http.Header {
+	return e.Response.Header
+}
+
+// Unwrap returns ErrInvalidHandshake, so errors.Is matches every rejected
+// handshake
+func (e *HandshakeError) Unwrap() error {
+	return ErrInvalidHandshake
+}
+
+// newHandshakeError reads a bounded copy of resp's body into a
+// HandshakeError
+func newHandshakeError(resp *http.Response) *HandshakeError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxHandshakeErrorBody))
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return &HandshakeError{Response: resp}
+}
+
+// redirectLocation returns where a handshake failed with err was
+// redirected to, if the redirect should be followed
+func (d *Dialer) redirectLocation(err error) (string, bool) {
+	var hsErr *HandshakeError
+	if d.opts == nil || d.opts.MaxRedirects <= 0 || !errors.As(err, &hsErr) || !isRedirect(hsErr.StatusCode()) {
+		return "", false
+	}
+	location := hsErr.Response.Header.Get("Location")
+	return location, location != ""
+}
+
+// nextRedirect resolves a redirect from u to location and checks that it
+// may be followed
+func (d *Dialer) nextRedirect(u *url.URL, location string, via []*url.URL) (*url.URL, error) {
+	if len(via) > d.opts.MaxRedirects {
+		return nil, ErrTooManyRedirects
+	}
+
+	loc, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("axon: invalid redirect location: %w", err)
+	}
+	next := u.ResolveReference(loc)
+	switch next.Scheme {
+	case "http":
+		next.Scheme = "ws"
+	case "https":
+		next.Scheme = "wss"
+	}
+
+	check := d.opts.CheckRedirect
+	if check == nil {
+		check = sameOriginRedirect
+	}
+	if err := check(next, via); err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+// withoutCredentials returns a copy of d that sends no Authorization or
+// Cookie header
+func (d *Dialer) withoutCredentials() *Dialer {
+	opts := *d.opts
+	opts.Headers = opts.Headers.Clone()
+	opts.Headers.Del("Authorization")
+	opts.Headers.Del("Cookie")
+	return &Dialer{opts: &opts}
+}
+
+// sameOriginRedirect is the default CheckRedirect policy
+func sameOriginRedirect(next *url.URL, via []*url.URL) error {
+	prev := via[len(via)-1]
+	if next.Scheme != prev.Scheme || next.Host != prev.Host {
+		return ErrCrossOriginRedirect
+	}
+	return nil
+}
+
+// isRedirect reports whether status is a redirect that can be followed
+func isRedirect(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// dialURL performs a single dial and handshake to u
+func dialURL[T any](ctx context.Context, d *Dialer, u *url.URL) (*Conn[T], error) {
+	// Work on a copy, since the scheme is rewritten for the HTTP request
+	uc := *u
+	u = &uc
 	switch u.Scheme {
 	case "ws":
 		u.Scheme = "http"
@@ -126,40 +451,37 @@ func DialWithDialer[T any](ctx context.Context, d *Dialer, rawURL string) (*Conn
 		handshakeTimeout = 30 * time.Second
 	}
 
-	readBufferSize := opts.ReadBufferSize
-	if readBufferSize <= 0 {
-		readBufferSize = 4096
-	}
-
-	writeBufferSize := opts.WriteBufferSize
-	if writeBufferSize <= 0 {
-		writeBufferSize = 4096
-	}
-
-	maxFrameSize := opts.MaxFrameSize
-	if maxFrameSize <= 0 {
-		maxFrameSize = 4096
-	}
+	compression := newCompressionConfig(opts.CompressionThreshold, opts.CompressionLevel, opts.CompressionStrategy)
 
-	maxMessageSize := opts.MaxMessageSize
-	if maxMessageSize <= 0 {
-		maxMessageSize = 1048576
+	offer := deflateParams{
+		clientNoContextTakeover: opts.ClientNoContextTakeover || opts.CompressionStrategy == CompressionPooled,
+		serverNoContextTakeover: opts.ServerNoContextTakeover,
 	}
-
-	compressionThreshold := opts.CompressionThreshold
-	if compressionThreshold <= 0 {
-		compressionThreshold = 256
+	if opts.ServerMaxWindowBits != 0 {
+		if _, ok := parseWindowBits(strconv.Itoa(opts.ServerMaxWindowBits)); !ok {
+			return nil, fmt.Errorf("axon: invalid ServerMaxWindowBits: %d", opts.ServerMaxWindowBits)
+		}
+		offer.serverMaxWindowBits = opts.ServerMaxWindowBits
 	}
 
 	// Create context with handshake timeout
 	dialCtx, dialCancel := context.WithTimeout(ctx, handshakeTimeout)
 	defer dialCancel()
 
+	start := time.Now()
+	timings := &DialTimings{}
+	dialCtx = context.WithValue(dialCtx, dialTimingsKey{}, timings)
+
+	if opts.EnableHTTP2 {
+		return dialHTTP2[T](dialCtx, d, u, offer, compression, start, timings)
+	}
+
 	// Establish TCP connection
 	conn, err := d.dial(dialCtx, u)
 	if err != nil {
 		return nil, fmt.Errorf("axon: dial failed: %w", err)
 	}
+	handshakeStart := time.Now()
 
 	// Generate WebSocket key
 	key, err := generateWebSocketKey()
@@ -178,7 +500,7 @@ func DialWithDialer[T any](ctx context.Context, d *Dialer, rawURL string) (*Conn
 		}
 	}
 
-	path := u.Path
+	path := u.EscapedPath()
 	if path == "" {
 		path = "/"
 	}
@@ -186,13 +508,18 @@ func DialWithDialer[T any](ctx context.Context, d *Dialer, rawURL string) (*Conn
 		path += "?" + u.RawQuery
 	}
 
+	hostHeader := host
+	if opts.Host != "" {
+		hostHeader = opts.Host
+	}
+
 	// Write handshake request
 	var buf strings.Builder
 	buf.WriteString("GET ")
 	buf.WriteString(path)
 	buf.WriteString(" HTTP/1.1\r\n")
 	buf.WriteString("Host: ")
-	buf.WriteString(host)
+	buf.WriteString(hostHeader)
 	buf.WriteString("\r\n")
 	buf.WriteString("Upgrade: websocket\r\n")
 	buf.WriteString("Connection: Upgrade\r\n")
@@ -208,13 +535,32 @@ func DialWithDialer[T any](ctx context.Context, d *Dialer, rawURL string) (*Conn
 		buf.WriteString("\r\n")
 	}
 
-	// Add compression extension if requested
+	// Offer compression if requested, then the plugins
+	var offers []Extension
 	if opts.Compression {
-		buf.WriteString("Sec-WebSocket-Extensions: permessage-deflate; client_max_window_bits\r\n")
+		offers = offer.clientOffers()
+	}
+	offers = append(offers, offerExtensions(opts.Extensions)...)
+	if len(offers) > 0 {
+		buf.WriteString("Sec-WebSocket-Extensions: ")
+		buf.WriteString(FormatExtensions(offers...))
+		buf.WriteString("\r\n")
+	}
+
+	// Credentials in the URL are sent with basic authentication unless an
+	// Authorization header was given
+	if u.User != nil && opts.Headers.Get("Authorization") == "" {
+		password, _ := u.User.Password()
+		buf.WriteString("Authorization: Basic ")
+		buf.WriteString(base64.StdEncoding.EncodeToString([]byte(u.User.Username() + ":" + password)))
+		buf.WriteString("\r\n")
 	}
 
 	// Add custom headers
 	for key, values := range opts.Headers {
+		if http.CanonicalHeaderKey(key) == "Host" {
+			continue // Set with the Host option
+		}
 		for _, value := range values {
 			buf.WriteString(key)
 			buf.WriteString(": ")
@@ -242,8 +588,10 @@ func DialWithDialer[T any](ctx context.Context, d *Dialer, rawURL string) (*Conn
 		return nil, fmt.Errorf("axon: failed to set read deadline: %w", err)
 	}
 
-	// Read handshake response
-	reader := bufio.NewReader(conn)
+	// Read handshake response. The connection's reader starts with what
+	// this one buffered, since the server may send frames right behind the
+	// response.
+	reader := getReader(conn, 4096)
 	resp, err := http.ReadResponse(reader, nil)
 	if err != nil {
 		conn.Close()
@@ -253,8 +601,9 @@ func DialWithDialer[T any](ctx context.Context, d *Dialer, rawURL string) (*Conn
 
 	// Validate response
 	if resp.StatusCode != http.StatusSwitchingProtocols {
+		hsErr := newHandshakeError(resp)
 		conn.Close()
-		return nil, fmt.Errorf("axon: unexpected status code: %d", resp.StatusCode)
+		return nil, hsErr
 	}
 
 	if !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") {
@@ -274,13 +623,17 @@ func DialWithDialer[T any](ctx context.Context, d *Dialer, rawURL string) (*Conn
 		return nil, ErrInvalidHandshake
 	}
 
-	// Check if compression was accepted
-	compressionEnabled := false
-	if opts.Compression {
-		extensions := resp.Header.Get("Sec-WebSocket-Extensions")
-		if strings.Contains(extensions, "permessage-deflate") {
-			compressionEnabled = true
-		}
+	// The server may only select a subprotocol the client offered
+	subprotocol := resp.Header.Get("Sec-WebSocket-Protocol")
+	if subprotocol != "" && !slices.Contains(opts.Subprotocols, subprotocol) {
+		conn.Close()
+		return nil, ErrInvalidSubprotocol
+	}
+
+	deflate, compressionEnabled, extensions, err := acceptedExtensions(opts, offer, resp.Header)
+	if err != nil {
+		conn.Close()
+		return nil, err
 	}
 
 	// Clear deadlines for normal operation
@@ -293,53 +646,166 @@ func DialWithDialer[T any](ctx context.Context, d *Dialer, rawURL string) (*Conn
 		return nil, fmt.Errorf("axon: failed to clear write deadline: %w", err)
 	}
 
+	var cm *CompressionManager
+	if compressionEnabled {
+		cm = newCompressionManager(compression, deflate, true)
+	}
+	wsConn := newClientConn[T](opts, conn, reader, subprotocol, cm, extensions)
+	putReader(reader)
+
+	timings.Handshake = time.Since(handshakeStart)
+	timings.Total = time.Since(start)
+	wsConn.dialTimings = *timings
+
+	return wsConn, nil
+}
+
+// acceptedExtensions checks which of the offered extensions the server
+// accepted in its handshake response, and with which parameters
+func acceptedExtensions(opts *DialOptions, offer deflateParams, header http.Header) (deflateParams, bool, []activeExtension, error) {
+	var deflate deflateParams
+	values := header.Values("Sec-WebSocket-Extensions")
+	if len(values) == 0 {
+		return deflate, false, nil, nil
+	}
+
+	parsed, err := ParseExtensions(values...)
+	if err != nil {
+		return deflate, false, nil, err
+	}
+	compressionEnabled := false
+	if opts.Compression {
+		if deflate, compressionEnabled, err = parseDeflateResponse(parsed, offer); err != nil {
+			return deflate, false, nil, err
+		}
+	}
+	var reserved byte
+	if compressionEnabled {
+		reserved = rsv1Mask
+	}
+	extensions, err := confirmExtensions(opts.Extensions, parsed, opts.Compression, reserved)
+	if err != nil {
+		return deflate, false, nil, err
+	}
+	return deflate, compressionEnabled, extensions, nil
+}
+
+// newClientConn wraps a dialed connection. br, if not nil, holds data read
+// from conn after the handshake.
+func newClientConn[T any](opts *DialOptions, conn net.Conn, br *bufio.Reader, subprotocol string, compression *CompressionManager, extensions []activeExtension) *Conn[T] {
+	// Apply defaults
+	readBufferSize := opts.ReadBufferSize
+	if readBufferSize <= 0 {
+		readBufferSize = 4096
+	}
+
+	writeBufferSize := opts.WriteBufferSize
+	if writeBufferSize <= 0 {
+		writeBufferSize = 4096
+	}
+
+	maxFrameSize := opts.MaxFrameSize
+	if maxFrameSize <= 0 {
+		maxFrameSize = 4096
+	}
+
+	maxMessageSize := opts.MaxMessageSize
+	if maxMessageSize <= 0 {
+		maxMessageSize = 1048576
+	}
+
 	// Create upgrader for connection configuration
 	upgrader := &Upgrader{
 		readBufferSize:    readBufferSize,
 		writeBufferSize:   writeBufferSize,
 		maxFrameSize:      maxFrameSize,
 		maxMessageSize:    maxMessageSize,
+		lenientProtocol:   opts.LenientProtocol,
 		readDeadline:      opts.ReadDeadline,
 		writeDeadline:     opts.WriteDeadline,
 		pingInterval:      opts.PingInterval,
 		pongTimeout:       opts.PongTimeout,
-		enableCompression: compressionEnabled,
+		enableCompression: compression != nil,
+		sendQueueSize:     opts.SendQueueSize,
+		overflowPolicy:    opts.OverflowPolicy,
+		logger:            loggerOr(opts.Logger),
+		metrics:           opts.Metrics,
+		noBufferPool:      opts.DisableBufferPool,
 	}
 
-	// Get pooled buffers and readers/writers
-	readBuf := getBuffer()
-	writeBuf := getBuffer()
-	wsReader := getReader(conn)
-	wsWriter := getWriter(conn)
-
 	// Create WebSocket connection
+	id := newConnID()
 	wsConn := &Conn[T]{
-		conn:          conn,
-		reader:        wsReader,
-		writer:        wsWriter,
-		readBuf:       readBuf,
-		writeBuf:      writeBuf,
-		upgrader:      upgrader,
-		readDeadline:  opts.ReadDeadline,
-		writeDeadline: opts.WriteDeadline,
-		pingInterval:  opts.PingInterval,
-		pongTimeout:   opts.PongTimeout,
-		isClient:      true,
-	}
-
-	// Initialize compression if enabled
-	if compressionEnabled {
-		wsConn.compression = newCompressionManager(compressionThreshold)
+		id:             id,
+		conn:           conn,
+		reader:         upgrader.hijackedReader(conn, br),
+		writer:         upgrader.newWriter(conn),
+		readBuf:        upgrader.newBuffer(readBufferSize),
+		writeBuf:       upgrader.newBuffer(writeBufferSize),
+		upgrader:       upgrader,
+		readDeadline:   opts.ReadDeadline,
+		writeDeadline:  opts.WriteDeadline,
+		pingInterval:   opts.PingInterval,
+		pongTimeout:    opts.PongTimeout,
+		extendDeadline: opts.ExtendReadDeadline,
+		clk:            opts.Clock,
+		isClient:       true,
+		subprotocol:    subprotocol,
+		compression:    compression,
+		extensions:     extensions,
+		log:            connLogger(opts.Logger, id),
+		metrics:        opts.Metrics,
+		trace:          opts.Trace,
+	}
+
+	base := context.Background()
+	if opts.BaseContext != nil {
+		base = opts.BaseContext()
+	}
+	wsConn.ctx, wsConn.cancel = context.WithCancel(base)
+
+	registry.add(wsConn)
+	if opts.Metrics != nil {
+		opts.Metrics.RecordConnection()
 	}
 
 	// Start ping loop if configured
 	if opts.PingInterval > 0 {
 		wsConn.startPingLoop()
 	}
+	if opts.PayloadCipher != nil {
+		wsConn.UseCipher(opts.PayloadCipher)
+	}
+	if opts.Faults != nil {
+		wsConn.InjectFaults(*opts.Faults)
+	}
 
-	return wsConn, nil
+	return wsConn
+}
+
+// DialTimings records how long each phase of dialing a connection took
+type DialTimings struct {
+	// DNS is the time spent resolving host names. It is zero when the
+	// address is an IP or NetDialer resolves it itself.
+	DNS time.Duration
+
+	// Connect is the time spent establishing the network connection,
+	// including any proxy tunnel, excluding DNS
+	Connect time.Duration
+
+	// TLS is the time spent in the TLS handshake for wss:// URLs
+	TLS time.Duration
+
+	// Handshake is the time spent in the WebSocket upgrade exchange
+	Handshake time.Duration
+
+	// Total is the time the whole dial took
+	Total time.Duration
 }
 
+// dialTimingsKey is the context key for the *DialTimings being recorded
+type dialTimingsKey struct{}
+
 // dial establishes a TCP connection to the server
 func (d *Dialer) dial(ctx context.Context, u *url.URL) (net.Conn, error) {
 	opts := d.opts
@@ -347,9 +813,27 @@ func (d *Dialer) dial(ctx context.Context, u *url.URL) (net.Conn, error) {
 		opts = &DialOptions{}
 	}
 
-	netDialer := opts.NetDialer
-	if netDialer == nil {
-		netDialer = &net.Dialer{}
+	dialContext := opts.DialContext
+	if dialContext == nil {
+		netDialer := opts.NetDialer
+		if netDialer == nil {
+			netDialer = &net.Dialer{}
+		}
+		if opts.Resolver != nil {
+			nd := *netDialer
+			nd.Resolver = opts.Resolver
+			netDialer = &nd
+		}
+		dialContext = netDialer.DialContext
+
+		if opts.FallbackDelay >= 0 {
+			delay := opts.FallbackDelay
+			if delay == 0 {
+				delay = defaultFallbackDelay
+			}
+			he := &happyEyeballs{dialer: netDialer, delay: delay}
+			dialContext = he.DialContext
+		}
 	}
 
 	host := u.Host
@@ -361,26 +845,57 @@ func (d *Dialer) dial(ctx context.Context, u *url.URL) (net.Conn, error) {
 		}
 	}
 
-	if u.Scheme == "https" {
-		// TLS connection
-		tlsConfig := opts.TLSConfig
-		if tlsConfig == nil {
-			tlsConfig = &tls.Config{}
+	timings, _ := ctx.Value(dialTimingsKey{}).(*DialTimings)
+	if timings == nil {
+		timings = &DialTimings{}
+	}
+	connectStart := time.Now()
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	if addr, ok := opts.HostOverrides[host]; ok {
+		network := "tcp"
+		if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+			network, addr = "unix", path
+		}
+		conn, err = dialContext(ctx, network, addr)
+	} else {
+		proxy := opts.Proxy
+		if proxy == nil {
+			proxy = http.ProxyFromEnvironment
 		}
-		if tlsConfig.ServerName == "" {
-			tlsConfig = tlsConfig.Clone()
-			tlsConfig.ServerName = u.Hostname()
+		proxyURL, perr := proxy(&http.Request{URL: u, Header: make(http.Header)})
+		if perr != nil {
+			return nil, fmt.Errorf("axon: proxy lookup failed: %w", perr)
 		}
 
-		conn, err := tls.DialWithDialer(netDialer, "tcp", host, tlsConfig)
-		if err != nil {
+		if proxyURL != nil {
+			conn, err = dialProxy(ctx, dialContext, proxyURL, host)
+		} else {
+			conn, err = dialContext(ctx, "tcp", host)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	timings.Connect = time.Since(connectStart) - timings.DNS
+
+	if u.Scheme == "https" {
+		// TLS connection
+		tlsStart := time.Now()
+		defer func() { timings.TLS = time.Since(tlsStart) }()
+
+		tlsConn := tls.Client(conn, opts.clientTLSConfig(u.Hostname()))
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
 			return nil, err
 		}
-		return conn, nil
+		return tlsConn, nil
 	}
 
-	// Plain TCP connection
-	return netDialer.DialContext(ctx, "tcp", host)
+	return conn, nil
 }
 
 // generateWebSocketKey generates a random 16-byte base64-encoded key
@@ -2,8 +2,13 @@ package axon_test
 
 import (
 	"context"
+	"errors"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	neturl "net/url"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -257,3 +262,411 @@ func TestDialWithDialer(t *testing.T) {
 	}
 	defer conn.Close(1000, "done")
 }
+
+// redirectServer answers every request with a redirect to location
+func redirectServer(t *testing.T, status int, location func(r *http.Request) string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, location(r), status)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestDial_FollowsRedirect(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/new?x=1", http.StatusPermanentRedirect)
+	})
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[string](w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close(1000, "")
+		conn.Write(r.Context(), r.URL.RawQuery)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/old"
+
+	if _, err := axon.Dial[string](ctx, url, nil); err == nil || !strings.Contains(err.Error(), "308") {
+		t.Fatalf("expected redirect to fail without MaxRedirects, got %v", err)
+	}
+
+	conn, err := axon.Dial[string](ctx, url, &axon.DialOptions{MaxRedirects: 1})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close(1000, "")
+
+	if got, err := conn.Read(ctx); err != nil || got != "x=1" {
+		t.Errorf("expected query x=1 at the new location, got %q (err=%v)", got, err)
+	}
+}
+
+func TestDial_RedirectLimit(t *testing.T) {
+	server := redirectServer(t, http.StatusFound, func(r *http.Request) string { return r.URL.Path + "x" })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	_, err := axon.Dial[string](ctx, "ws"+strings.TrimPrefix(server.URL, "http")+"/", &axon.DialOptions{MaxRedirects: 3})
+	if err != axon.ErrTooManyRedirects {
+		t.Errorf("expected ErrTooManyRedirects, got %v", err)
+	}
+}
+
+func TestDial_CrossOriginRedirect(t *testing.T) {
+	target := redirectServer(t, http.StatusFound, func(r *http.Request) string { return "/" })
+	server := redirectServer(t, http.StatusTemporaryRedirect, func(r *http.Request) string { return target.URL + "/" })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/"
+
+	if _, err := axon.Dial[string](ctx, url, &axon.DialOptions{MaxRedirects: 5}); err != axon.ErrCrossOriginRedirect {
+		t.Errorf("expected ErrCrossOriginRedirect, got %v", err)
+	}
+
+	var seen []string
+	_, err := axon.Dial[string](ctx, url, &axon.DialOptions{
+		MaxRedirects: 1,
+		CheckRedirect: func(next *neturl.URL, via []*neturl.URL) error {
+			seen = append(seen, next.Scheme+"://"+next.Host)
+			return nil
+		},
+	})
+	if err != axon.ErrTooManyRedirects {
+		t.Errorf("expected ErrTooManyRedirects after following the cross-origin redirect, got %v", err)
+	}
+	if want := "ws://" + strings.TrimPrefix(target.URL, "http://"); len(seen) != 1 || seen[0] != want {
+		t.Errorf("expected CheckRedirect to see %s, got %v", want, seen)
+	}
+}
+
+func TestDial_CrossOriginRedirectDropsCredentials(t *testing.T) {
+	got := make(chan http.Header, 1)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header.Clone()
+		conn, err := axon.Upgrade[string](w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Close(1000, "")
+	}))
+	defer target.Close()
+	server := redirectServer(t, http.StatusFound, func(r *http.Request) string { return target.URL + "/" })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	url := "ws://user:pass@" + strings.TrimPrefix(server.URL, "http://") + "/"
+
+	conn, err := axon.Dial[string](ctx, url, &axon.DialOptions{
+		Headers: http.Header{
+			"Authorization": {"Bearer secret"},
+			"Cookie":        {"session=secret"},
+			"X-Custom":      {"kept"},
+		},
+		MaxRedirects:  1,
+		CheckRedirect: func(*neturl.URL, []*neturl.URL) error { return nil },
+	})
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close(1000, "")
+
+	header := <-got
+	if v := header.Get("Authorization"); v != "" {
+		t.Errorf("expected no Authorization header on the new origin, got %q", v)
+	}
+	if v := header.Get("Cookie"); v != "" {
+		t.Errorf("expected no Cookie header on the new origin, got %q", v)
+	}
+	if v := header.Get("X-Custom"); v != "kept" {
+		t.Errorf("expected other headers to be kept, got %q", v)
+	}
+}
+
+func TestDial_HandshakeError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="axon"`)
+		http.Error(w, "token expired", http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	_, err := axon.Dial[string](ctx, "ws"+strings.TrimPrefix(server.URL, "http"), nil)
+
+	var hsErr *axon.HandshakeError
+	if !errors.As(err, &hsErr) {
+		t.Fatalf("expected HandshakeError, got %v", err)
+	}
+	if hsErr.StatusCode() != http.StatusUnauthorized {
+		t.Errorf("expected status 401, got %d", hsErr.StatusCode())
+	}
+	if got := hsErr.Header().Get("WWW-Authenticate"); got != `Bearer realm="axon"` {
+		t.Errorf("expected auth challenge, got %q", got)
+	}
+	if !errors.Is(err, axon.ErrInvalidHandshake) {
+		t.Errorf("expected HandshakeError to wrap ErrInvalidHandshake")
+	}
+	body, _ := io.ReadAll(hsErr.Response.Body)
+	if strings.TrimSpace(string(body)) != "token expired" {
+		t.Errorf("expected error body, got %q", body)
+	}
+}
+
+func TestDial_HandshakeErrorRetryAfter(t *testing.T) {
+	limiter := axon.NewTokenBucketLimiter(0.1, 1, nil)
+	server := httptest.NewServer(axon.Handler[string](&axon.UpgradeOptions{RateLimiter: limiter}, func(ctx context.Context, conn *axon.Conn[string]) {}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	conn, err := axon.Dial[string](ctx, url, nil)
+	if err != nil {
+		t.Fatalf("first Dial() error = %v", err)
+	}
+	conn.Close(1000, "")
+
+	_, err = axon.Dial[string](ctx, url, nil)
+	var hsErr *axon.HandshakeError
+	if !errors.As(err, &hsErr) || hsErr.StatusCode() != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 HandshakeError, got %v", err)
+	}
+	if hsErr.Response.Header.Get("Retry-After") == "" {
+		t.Error("expected Retry-After header")
+	}
+}
+
+func TestDial_HostOverrides(t *testing.T) {
+	hosts := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts <- r.Host
+		conn, err := axon.Upgrade[string](w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Close(1000, "")
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	conn, err := axon.Dial[string](ctx, "ws://ws.example.test/", &axon.DialOptions{
+		HostOverrides: map[string]string{"ws.example.test:80": server.Listener.Addr().String()},
+	})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	conn.Close(1000, "")
+
+	if got := <-hosts; got != "ws.example.test:80" {
+		t.Errorf("expected Host ws.example.test:80, got %q", got)
+	}
+}
+
+func TestDial_UnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ws.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go axon.Serve(ctx, ln, nil, func(ctx context.Context, conn *axon.Conn[string]) {
+		conn.Write(ctx, "over unix")
+	})
+
+	dialCtx, dialCancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer dialCancel()
+
+	conn, err := axon.Dial[string](dialCtx, "ws://sidecar/", &axon.DialOptions{
+		HostOverrides: map[string]string{"sidecar:80": "unix:" + path},
+	})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close(1000, "")
+
+	if got, err := conn.Read(dialCtx); err != nil || got != "over unix" {
+		t.Errorf("expected message over unix socket, got %q (err=%v)", got, err)
+	}
+}
+
+func TestDial_DialContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[string](w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Close(1000, "")
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	var dialed []string
+	conn, err := axon.Dial[string](ctx, "ws://fixture.test/", &axon.DialOptions{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed = append(dialed, network+" "+addr)
+			return net.Dial("tcp", server.Listener.Addr().String())
+		},
+	})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	conn.Close(1000, "")
+
+	if len(dialed) != 1 || dialed[0] != "tcp fixture.test:80" {
+		t.Errorf("expected one dial to fixture.test:80, got %v", dialed)
+	}
+}
+
+func TestDial_BaseContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[string](w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Read(r.Context())
+	}))
+	defer server.Close()
+
+	type baseKey struct{}
+	dialCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	conn, err := axon.Dial[string](dialCtx, "ws"+strings.TrimPrefix(server.URL, "http"), &axon.DialOptions{
+		BaseContext: func() context.Context {
+			return context.WithValue(context.Background(), baseKey{}, "base")
+		},
+	})
+	// The connection's context does not end with the dial's
+	cancel()
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+
+	ctx := conn.Context()
+	if got := ctx.Value(baseKey{}); got != "base" {
+		t.Errorf("base context value = %v, want %q", got, "base")
+	}
+	if err := ctx.Err(); err != nil {
+		t.Errorf("connection context canceled before Close: %v", err)
+	}
+	conn.Close(1000, "")
+	if ctx.Err() == nil {
+		t.Error("connection context was not canceled by Close")
+	}
+}
+
+func TestDial_Timings(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[string](w, r, nil)
+		if err != nil {
+			return
+		}
+		if timings := conn.DialTimings(); timings != (axon.DialTimings{}) {
+			t.Errorf("expected zero timings on a server connection, got %+v", timings)
+		}
+		conn.Close(1000, "")
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// The test certificate is for example.com, and dialing localhost
+	// exercises name resolution
+	tlsConfig := server.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	tlsConfig.ServerName = "example.com"
+
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	conn, err := axon.Dial[string](ctx, "wss://localhost:"+port+"/", &axon.DialOptions{
+		TLSConfig: tlsConfig,
+	})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close(1000, "")
+
+	timings := conn.DialTimings()
+	if timings.DNS <= 0 || timings.Connect <= 0 || timings.TLS <= 0 || timings.Handshake <= 0 {
+		t.Errorf("expected every phase to be timed, got %+v", timings)
+	}
+	if sum := timings.DNS + timings.Connect + timings.TLS + timings.Handshake; timings.Total < sum {
+		t.Errorf("expected total %v to cover the phases (%v)", timings.Total, sum)
+	}
+}
+
+// captureRequest starts a server that records each upgrade request
+func captureRequest(t *testing.T) (*httptest.Server, <-chan *http.Request) {
+	t.Helper()
+
+	requests := make(chan *http.Request, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r
+		conn, err := axon.Upgrade[string](w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Close(1000, "")
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func TestDial_URLUserinfo(t *testing.T) {
+	server, requests := captureRequest(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	conn, err := axon.Dial[string](ctx, "ws://alice:s3cret@"+strings.TrimPrefix(server.URL, "http://")+"/a%2Fb", nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	conn.Close(1000, "")
+
+	r := <-requests
+	if user, pass, ok := r.BasicAuth(); !ok || user != "alice" || pass != "s3cret" {
+		t.Errorf("expected basic auth from the URL, got %q", r.Header.Get("Authorization"))
+	}
+	if got := r.URL.EscapedPath(); got != "/a%2Fb" {
+		t.Errorf("expected escaped path /a%%2Fb, got %q", got)
+	}
+}
+
+func TestDial_HostOverride(t *testing.T) {
+	server, requests := captureRequest(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	conn, err := axon.Dial[string](ctx, "ws"+strings.TrimPrefix(server.URL, "http"), &axon.DialOptions{
+		Host:    "api.example.com",
+		Headers: http.Header{"Authorization": {"Bearer token"}},
+	})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	conn.Close(1000, "")
+
+	r := <-requests
+	if r.Host != "api.example.com" {
+		t.Errorf("expected Host api.example.com, got %q", r.Host)
+	}
+	if got := r.Header.Get("Authorization"); got != "Bearer token" {
+		t.Errorf("expected explicit Authorization header, got %q", got)
+	}
+}
@@ -0,0 +1,129 @@
+package axon
+
+import (
+	"sync"
+	"time"
+)
+
+// EndpointProvider chooses the URL dialed by each connection attempt of a
+// Client, allowing failover between several endpoints
+type EndpointProvider interface {
+	// Next returns the URL to dial
+	Next() string
+
+	// Report records the outcome of dialing url
+	Report(url string, err error)
+}
+
+// EndpointHealth is the health of an endpoint as tracked by Endpoints
+type EndpointHealth struct {
+	URL       string
+	Failures  int       // Consecutive failed dials
+	LastErr   error     // Error of the last failed dial
+	DownUntil time.Time // Zero while the endpoint is healthy
+}
+
+// Endpoint backoff bounds used by NewEndpoints
+const (
+	defaultEndpointBackoff    = time.Second
+	defaultEndpointMaxBackoff = 5 * time.Minute
+)
+
+// Endpoints is an EndpointProvider for a list of URLs in priority order.
+// The first healthy endpoint is dialed; an endpoint that fails is avoided
+// for a backoff that doubles with each consecutive failure. When every
+// endpoint is down, the one due back soonest is dialed.
+type Endpoints struct {
+	backoff    time.Duration
+	maxBackoff time.Duration
+	now        func() time.Time
+
+	mu        sync.Mutex
+	endpoints []EndpointHealth
+}
+
+// NewEndpoints creates a provider for urls, highest priority first
+func NewEndpoints(urls ...string) *Endpoints {
+	e := &Endpoints{
+		backoff:    defaultEndpointBackoff,
+		maxBackoff: defaultEndpointMaxBackoff,
+		now:        time.Now,
+		endpoints:  make([]EndpointHealth, len(urls)),
+	}
+	for i, url := range urls {
+		e.endpoints[i].URL = url
+	}
+	return e
+}
+
+// SetBackoff sets how long a failed endpoint is avoided after its first
+// failure, and the limit the doubling backoff is capped at
+func (e *Endpoints) SetBackoff(backoff, max time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if backoff > 0 {
+		e.backoff = backoff
+	}
+	if max > 0 {
+		e.maxBackoff = max
+	}
+}
+
+// Next returns the highest priority endpoint that is not down
+func (e *Endpoints) Next() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(e.endpoints) == 0 {
+		return ""
+	}
+
+	now := e.now()
+	next := 0
+	for i, ep := range e.endpoints {
+		if !ep.DownUntil.After(now) {
+			return ep.URL
+		}
+		if ep.DownUntil.Before(e.endpoints[next].DownUntil) {
+			next = i
+		}
+	}
+	return e.endpoints[next].URL
+}
+
+// Report marks url healthy after a successful dial, or down for its
+// backoff after a failure
+func (e *Endpoints) Report(url string, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i := range e.endpoints {
+		ep := &e.endpoints[i]
+		if ep.URL != url {
+			continue
+		}
+
+		if err == nil {
+			*ep = EndpointHealth{URL: url}
+			return
+		}
+
+		ep.Failures++
+		ep.LastErr = err
+		backoff := e.backoff << min(ep.Failures-1, 30)
+		if backoff <= 0 || backoff > e.maxBackoff {
+			backoff = e.maxBackoff
+		}
+		ep.DownUntil = e.now().Add(backoff)
+		return
+	}
+}
+
+// Health returns the health of each endpoint in priority order
+func (e *Endpoints) Health() []EndpointHealth {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	health := make([]EndpointHealth, len(e.endpoints))
+	copy(health, e.endpoints)
+	return health
+}
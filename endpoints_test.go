@@ -0,0 +1,125 @@
+package axon_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+func TestEndpoints_Failover(t *testing.T) {
+	now := time.Unix(1000, 0)
+	e := axon.NewEndpoints("wss://a", "wss://b", "wss://c")
+	e.SetClock(func() time.Time { return now })
+	e.SetBackoff(time.Second, 4*time.Second)
+	errDown := errors.New("down")
+
+	if url := e.Next(); url != "wss://a" {
+		t.Fatalf("Next() = %q, want wss://a", url)
+	}
+
+	e.Report("wss://a", errDown)
+	if url := e.Next(); url != "wss://b" {
+		t.Errorf("Next() after a failed = %q, want wss://b", url)
+	}
+
+	// The highest priority endpoint is preferred once its backoff ends
+	now = now.Add(time.Second)
+	if url := e.Next(); url != "wss://a" {
+		t.Errorf("Next() after a's backoff = %q, want wss://a", url)
+	}
+
+	// Consecutive failures double the backoff
+	e.Report("wss://a", errDown)
+	now = now.Add(time.Second)
+	if url := e.Next(); url != "wss://b" {
+		t.Errorf("Next() during a's second backoff = %q, want wss://b", url)
+	}
+
+	// With every endpoint down, the one due back soonest is dialed
+	e.Report("wss://b", errDown)
+	e.Report("wss://c", errDown)
+	if url := e.Next(); url != "wss://a" {
+		t.Errorf("Next() with all down = %q, want wss://a", url)
+	}
+
+	health := e.Health()
+	if len(health) != 3 {
+		t.Fatalf("Health() returned %d endpoints, want 3", len(health))
+	}
+	if h := health[0]; h.URL != "wss://a" || h.Failures != 2 || !errors.Is(h.LastErr, errDown) || !h.DownUntil.Equal(now.Add(time.Second)) {
+		t.Errorf("Health()[0] = %+v", h)
+	}
+
+	e.Report("wss://b", nil)
+	if h := e.Health()[1]; h.Failures != 0 || h.LastErr != nil || !h.DownUntil.IsZero() {
+		t.Errorf("Health()[1] after success = %+v, want healthy", h)
+	}
+	if url := e.Next(); url != "wss://b" {
+		t.Errorf("Next() after b recovered = %q, want wss://b", url)
+	}
+}
+
+func TestEndpoints_MaxBackoff(t *testing.T) {
+	now := time.Unix(1000, 0)
+	e := axon.NewEndpoints("wss://a")
+	e.SetClock(func() time.Time { return now })
+	e.SetBackoff(time.Second, 4*time.Second)
+
+	for range 10 {
+		e.Report("wss://a", errors.New("down"))
+	}
+	if until := e.Health()[0].DownUntil; !until.Equal(now.Add(4 * time.Second)) {
+		t.Errorf("DownUntil = %v, want capped at 4s", until.Sub(now))
+	}
+}
+
+func TestClient_Endpoints(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	deadURL := "ws" + strings.TrimPrefix(dead.URL, "http")
+	dead.Close()
+
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[string](w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close(1000, "done")
+		for {
+			if _, err := conn.Read(r.Context()); err != nil {
+				return
+			}
+		}
+	}))
+	defer live.Close()
+	liveURL := "ws" + strings.TrimPrefix(live.URL, "http")
+
+	endpoints := axon.NewEndpoints(deadURL, liveURL)
+	opts := axon.DefaultClientOptions()
+	opts.Reconnect.Enabled = false
+	opts.Endpoints = endpoints
+	client := axon.NewClient[string]("", opts)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := client.Connect(ctx); err == nil {
+		t.Fatal("Connect() to the dead endpoint should fail")
+	}
+	if h := endpoints.Health()[0]; h.Failures != 1 {
+		t.Errorf("dead endpoint failures = %d, want 1", h.Failures)
+	}
+
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Connect() error = %v, want failover to the live endpoint", err)
+	}
+	if h := endpoints.Health()[1]; h.Failures != 0 || !h.DownUntil.IsZero() {
+		t.Errorf("live endpoint health = %+v", h)
+	}
+}
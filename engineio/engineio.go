@@ -0,0 +1,318 @@
+// Package engineio implements the client side of the Engine.IO v4
+// protocol over the WebSocket transport, which Socket.IO servers speak
+// beneath their own packets. A Conn performs the open handshake, answers
+// the server's pings and carries text and binary messages; Socket.IO
+// packets travel as text messages over it.
+package engineio
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+// Protocol is the Engine.IO protocol version sent in the EIO query
+// parameter
+const Protocol = "4"
+
+// DefaultPath is the path Engine.IO servers listen on by default
+const DefaultPath = "/engine.io/"
+
+// Packet types, sent as the first character of text packets
+const (
+	PacketOpen    = '0'
+	PacketClose   = '1'
+	PacketPing    = '2'
+	PacketPong    = '3'
+	PacketMessage = '4'
+	PacketUpgrade = '5'
+	PacketNoop    = '6'
+)
+
+var (
+	// ErrInvalidPacket is returned when the server sends a malformed packet
+	ErrInvalidPacket = errors.New("engineio: invalid packet")
+
+	// ErrPingTimeout is returned when the server stops pinging
+	ErrPingTimeout = errors.New("engineio: ping timeout")
+
+	// ErrServerClosed is returned once the server sent a close packet
+	ErrServerClosed = errors.New("engineio: closed by server")
+)
+
+// Handshake is the payload of the server's open packet
+type Handshake struct {
+	SID          string   `json:"sid"`
+	Upgrades     []string `json:"upgrades"`
+	PingInterval int      `json:"pingInterval"` // Milliseconds
+	PingTimeout  int      `json:"pingTimeout"`  // Milliseconds
+	MaxPayload   int      `json:"maxPayload"`
+}
+
+// Options configures Dial
+type Options struct {
+	// Path is the server's Engine.IO path.
+	// Default is "/engine.io/", or the path of the URL if it has one.
+	Path string
+
+	// Query holds extra query parameters of the handshake, such as
+	// authentication tokens. EIO and transport are set by Dial.
+	Query url.Values
+
+	// DialOptions configures the WebSocket connection
+	DialOptions *axon.DialOptions
+}
+
+// Message is a message received from the server
+type Message struct {
+	Data   []byte
+	Binary bool
+}
+
+// Conn is an Engine.IO connection. It is safe for concurrent use.
+type Conn struct {
+	conn      *axon.Conn[[]byte]
+	handshake Handshake
+	messages  chan Message
+	pinged    chan struct{}
+
+	mu   sync.Mutex
+	err  error
+	done chan struct{}
+}
+
+// Dial connects to the Engine.IO server at rawURL, which may use the
+// http, https, ws or wss scheme, and waits for its open packet
+func Dial(ctx context.Context, rawURL string, opts *Options) (*Conn, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	u, err := handshakeURL(rawURL, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := axon.Dial[[]byte](ctx, u, opts.DialOptions)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewConn(ctx, conn)
+	if err != nil {
+		conn.Close(int(axon.CloseNormalClosure), "")
+		return nil, err
+	}
+	return c, nil
+}
+
+// handshakeURL returns the WebSocket URL of the handshake
+func handshakeURL(rawURL string, opts *Options) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	}
+
+	switch {
+	case opts.Path != "":
+		u.Path = opts.Path
+	case u.Path == "" || u.Path == "/":
+		u.Path = DefaultPath
+	}
+
+	query := u.Query()
+	for k, v := range opts.Query {
+		query[k] = v
+	}
+	query.Set("EIO", Protocol)
+	query.Set("transport", "websocket")
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// NewConn waits for the open packet on conn, which must be a WebSocket
+// connection to an Engine.IO endpoint. The Conn then owns reading from it.
+func NewConn(ctx context.Context, conn *axon.Conn[[]byte]) (*Conn, error) {
+	mt, data, err := conn.ReadMessage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if mt != axon.TextMessage || len(data) == 0 || data[0] != PacketOpen {
+		return nil, ErrInvalidPacket
+	}
+
+	c := &Conn{
+		conn:     conn,
+		messages: make(chan Message, 64),
+		pinged:   make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	if err := json.Unmarshal(data[1:], &c.handshake); err != nil || c.handshake.SID == "" {
+		return nil, ErrInvalidPacket
+	}
+
+	go c.readLoop()
+	if c.handshake.PingInterval > 0 {
+		go c.watchPings()
+	}
+	return c, nil
+}
+
+// Handshake returns the server's open packet
+func (c *Conn) Handshake() Handshake {
+	return c.handshake
+}
+
+// SID returns the session ID assigned by the server
+func (c *Conn) SID() string {
+	return c.handshake.SID
+}
+
+// Done returns a channel that is closed when the connection ends
+func (c *Conn) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the error that ended the connection, or nil while it is open
+func (c *Conn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Read returns the next message. Once the connection ends, buffered
+// messages are returned before its error. Messages must be read promptly:
+// while the buffer is full, pings go unanswered.
+func (c *Conn) Read(ctx context.Context) (Message, error) {
+	select {
+	case msg, ok := <-c.messages:
+		if !ok {
+			return Message{}, c.Err()
+		}
+		return msg, nil
+	case <-ctx.Done():
+		return Message{}, ctx.Err()
+	}
+}
+
+// WriteText sends a text message
+func (c *Conn) WriteText(ctx context.Context, text string) error {
+	return c.conn.WriteMessage(ctx, axon.TextMessage, append([]byte{PacketMessage}, text...))
+}
+
+// WriteBinary sends a binary message, which travels without a packet type
+func (c *Conn) WriteBinary(ctx context.Context, data []byte) error {
+	return c.conn.WriteMessage(ctx, axon.BinaryMessage, data)
+}
+
+// Close sends a close packet and closes the connection
+func (c *Conn) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c.conn.WriteMessage(ctx, axon.TextMessage, []byte{PacketClose})
+	return c.conn.Close(int(axon.CloseNormalClosure), "")
+}
+
+// readLoop handles packets until the connection ends
+func (c *Conn) readLoop() {
+	defer close(c.messages)
+	for {
+		mt, data, err := c.conn.ReadMessage(context.Background())
+		if err != nil {
+			// Idle connections hit the default read deadline
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			c.stop(err)
+			return
+		}
+		if err := c.handle(mt, data); err != nil {
+			c.stop(err)
+			c.conn.Close(int(axon.CloseNormalClosure), "")
+			return
+		}
+	}
+}
+
+// handle handles a packet from the server
+func (c *Conn) handle(mt axon.MessageType, data []byte) error {
+	if mt == axon.BinaryMessage {
+		return c.deliver(Message{Data: data, Binary: true})
+	}
+	if len(data) == 0 {
+		return ErrInvalidPacket
+	}
+
+	switch data[0] {
+	case PacketPing:
+		select {
+		case c.pinged <- struct{}{}:
+		default:
+		}
+		pong := append([]byte{PacketPong}, data[1:]...)
+		return c.conn.WriteMessage(context.Background(), axon.TextMessage, pong)
+	case PacketMessage:
+		return c.deliver(Message{Data: data[1:]})
+	case PacketClose:
+		return ErrServerClosed
+	case PacketPong, PacketNoop:
+		return nil
+	}
+	return ErrInvalidPacket
+}
+
+// deliver queues a message for Read
+func (c *Conn) deliver(msg Message) error {
+	select {
+	case c.messages <- msg:
+		return nil
+	case <-c.done:
+		return c.Err()
+	}
+}
+
+// watchPings fails the connection when the server does not ping within
+// its ping interval and timeout
+func (c *Conn) watchPings() {
+	wait := time.Duration(c.handshake.PingInterval+c.handshake.PingTimeout) * time.Millisecond
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-c.pinged:
+			timer.Reset(wait)
+		case <-timer.C:
+			c.stop(ErrPingTimeout)
+			c.conn.Close(int(axon.CloseNormalClosure), "")
+			return
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// stop ends the connection with err. Only the first call has an effect.
+func (c *Conn) stop(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	if errors.Is(err, net.ErrClosed) {
+		err = axon.ErrConnectionClosed
+	}
+	c.err = err
+	close(c.done)
+}
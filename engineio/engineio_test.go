@@ -0,0 +1,141 @@
+package engineio_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+	"github.com/kolosys/axon/engineio"
+)
+
+// server is a minimal Engine.IO server. It opens with the given ping
+// settings, pings once, echoes text messages with an "echo " prefix and
+// binary messages as they are, and closes on "bye".
+func server(t *testing.T, pingInterval, pingTimeout int, queries chan<- string) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if queries != nil {
+			queries <- r.URL.Path + "?" + r.URL.RawQuery
+		}
+		conn, err := axon.Upgrade[[]byte](w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close(1000, "")
+		ctx := r.Context()
+
+		open := `0{"sid":"abc","upgrades":[],"pingInterval":` + strconv.Itoa(pingInterval) + `,"pingTimeout":` + strconv.Itoa(pingTimeout) + `,"maxPayload":1000000}`
+		conn.WriteMessage(ctx, axon.TextMessage, []byte(open))
+		conn.WriteMessage(ctx, axon.TextMessage, []byte("2"))
+
+		for {
+			mt, data, err := conn.ReadMessage(ctx)
+			if err != nil {
+				return
+			}
+			switch {
+			case mt == axon.BinaryMessage:
+				conn.WriteMessage(ctx, axon.BinaryMessage, data)
+			case string(data) == "3":
+				conn.WriteMessage(ctx, axon.TextMessage, []byte("4pong received"))
+			case string(data) == "4bye":
+				conn.WriteMessage(ctx, axon.TextMessage, []byte("1"))
+			case strings.HasPrefix(string(data), "4"):
+				conn.WriteMessage(ctx, axon.TextMessage, append([]byte("4echo "), data[1:]...))
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestConn(t *testing.T) {
+	queries := make(chan string, 1)
+	url := server(t, 25000, 20000, queries)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := engineio.Dial(ctx, url, &engineio.Options{Query: map[string][]string{"token": {"t1"}}})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+
+	if got := <-queries; got != "/engine.io/?EIO=4&token=t1&transport=websocket" {
+		t.Errorf("handshake request = %q", got)
+	}
+	if hs := conn.Handshake(); conn.SID() != "abc" || hs.PingInterval != 25000 || hs.MaxPayload != 1000000 {
+		t.Errorf("Handshake() = %+v", hs)
+	}
+
+	// The server's ping is answered automatically
+	if msg, err := conn.Read(ctx); err != nil || string(msg.Data) != "pong received" {
+		t.Fatalf("Read() = %q, %v; want the server to see the pong", msg.Data, err)
+	}
+
+	if err := conn.WriteText(ctx, "hello"); err != nil {
+		t.Fatalf("WriteText() error = %v", err)
+	}
+	if msg, err := conn.Read(ctx); err != nil || msg.Binary || string(msg.Data) != "echo hello" {
+		t.Fatalf("Read() = %+v, %v", msg, err)
+	}
+
+	if err := conn.WriteBinary(ctx, []byte{1, 2, 3}); err != nil {
+		t.Fatalf("WriteBinary() error = %v", err)
+	}
+	if msg, err := conn.Read(ctx); err != nil || !msg.Binary || string(msg.Data) != "\x01\x02\x03" {
+		t.Fatalf("Read() = %+v, %v", msg, err)
+	}
+
+	conn.WriteText(ctx, "bye")
+	if _, err := conn.Read(ctx); !errors.Is(err, engineio.ErrServerClosed) {
+		t.Errorf("Read() after the close packet error = %v, want ErrServerClosed", err)
+	}
+}
+
+func TestConn_PingTimeout(t *testing.T) {
+	// The server pings once, then falls silent
+	url := server(t, 20, 20, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := engineio.Dial(ctx, url, nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+
+	select {
+	case <-conn.Done():
+	case <-ctx.Done():
+		t.Fatal("connection did not time out")
+	}
+	if !errors.Is(conn.Err(), engineio.ErrPingTimeout) {
+		t.Errorf("Err() = %v, want ErrPingTimeout", conn.Err())
+	}
+}
+
+func TestDial_InvalidOpen(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[[]byte](w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close(1000, "")
+		conn.WriteMessage(r.Context(), axon.TextMessage, []byte("4not an open packet"))
+		conn.ReadMessage(r.Context())
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := engineio.Dial(ctx, srv.URL, nil); !errors.Is(err, engineio.ErrInvalidPacket) {
+		t.Errorf("Dial() error = %v, want ErrInvalidPacket", err)
+	}
+}
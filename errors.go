@@ -37,9 +37,15 @@ var (
 	// ErrFragmentedControlFrame indicates a control frame is fragmented (not allowed)
 	ErrFragmentedControlFrame = errors.New("axon: fragmented control frame")
 
+	// ErrControlFrameTooLarge indicates a control frame payload exceeds 125 bytes
+	ErrControlFrameTooLarge = errors.New("axon: control frame too large")
+
 	// ErrInvalidCloseCode indicates an invalid close code was used
 	ErrInvalidCloseCode = errors.New("axon: invalid close code")
 
+	// ErrInvalidUTF8 indicates a text message or close reason is not valid UTF-8
+	ErrInvalidUTF8 = errors.New("axon: invalid UTF-8")
+
 	// ErrReadDeadlineExceeded indicates a read operation exceeded its deadline
 	ErrReadDeadlineExceeded = errors.New("axon: read deadline exceeded")
 
@@ -78,4 +84,99 @@ var (
 
 	// ErrClientClosed indicates the client has been closed
 	ErrClientClosed = errors.New("axon: client closed")
+
+	// ErrHubClosed indicates the hub has been closed
+	ErrHubClosed = errors.New("axon: hub closed")
+
+	// ErrConnectionNotFound indicates no connection is registered with the given ID
+	ErrConnectionNotFound = errors.New("axon: connection not found")
+
+	// ErrSlowConsumer indicates a connection was evicted for not keeping up with sends
+	ErrSlowConsumer = errors.New("axon: slow consumer")
+
+	// ErrUpgradeRejected indicates an upgrade interceptor rejected the request
+	ErrUpgradeRejected = errors.New("axon: upgrade rejected")
+
+	// ErrTooManyConnections indicates an upgrade would exceed a connection limit
+	ErrTooManyConnections = errors.New("axon: too many connections")
+
+	// ErrRateLimited indicates an upgrade was throttled by the rate limiter
+	ErrRateLimited = errors.New("axon: upgrade rate limited")
+
+	// ErrTooManyRedirects indicates the handshake was redirected more than
+	// DialOptions.MaxRedirects times
+	ErrTooManyRedirects = errors.New("axon: too many redirects")
+
+	// ErrCrossOriginRedirect indicates the handshake was redirected to a
+	// different scheme or host
+	ErrCrossOriginRedirect = errors.New("axon: cross-origin redirect")
+
+	// ErrNoCallID indicates a Call request has no correlation ID
+	ErrNoCallID = errors.New("axon: call has no correlation ID")
+
+	// ErrDuplicateCallID indicates a Call is already pending with the same ID
+	ErrDuplicateCallID = errors.New("axon: duplicate call ID")
+
+	// ErrNoTopic indicates a subscription has no topic or the client has no
+	// TopicConfig
+	ErrNoTopic = errors.New("axon: no topic")
+
+	// ErrChannelClosed indicates a Mux channel has been closed
+	ErrChannelClosed = errors.New("axon: channel closed")
+
+	// ErrMuxProtocol indicates the peer sent a malformed Mux frame or
+	// exceeded a channel's window
+	ErrMuxProtocol = errors.New("axon: mux protocol violation")
+
+	// ErrNoAckConfig indicates an acknowledged write was attempted without
+	// an AckConfig
+	ErrNoAckConfig = errors.New("axon: acknowledgements not configured")
+
+	// ErrSessionNotFound indicates a session cannot be resumed
+	ErrSessionNotFound = errors.New("axon: session not found")
+
+	// ErrReadLoopActive indicates Read was called while the client's read
+	// loop owns the connection; use Receive instead
+	ErrReadLoopActive = errors.New("axon: read loop owns the connection")
+
+	// ErrPullMode indicates the read loop was requested while the client
+	// is in ReadModePull; use Read instead
+	ErrPullMode = errors.New("axon: client is in pull mode")
+
+	// ErrConnectionDegraded indicates a Client closed a connection that
+	// failed its HealthConfig checks
+	ErrConnectionDegraded = errors.New("axon: connection degraded")
+
+	// ErrValidationFailed indicates a message failed a connection's
+	// Validator
+	ErrValidationFailed = errors.New("axon: message failed validation")
+
+	// ErrInvalidProxyHeader indicates a connection accepted by a
+	// ProxyProtocolListener did not start with a valid PROXY header
+	ErrInvalidProxyHeader = errors.New("axon: invalid PROXY protocol header")
+
+	// ErrUnauthorized indicates an upgrade request had no valid token, or
+	// an Authenticator could not supply one
+	ErrUnauthorized = errors.New("axon: unauthorized")
+
+	// ErrDecryptionFailed indicates a message could not be decrypted by
+	// the connection's PayloadCipher
+	ErrDecryptionFailed = errors.New("axon: payload decryption failed")
+
+	// ErrBanned indicates an upgrade request came from a banned client IP
+	// or principal
+	ErrBanned = errors.New("axon: client banned")
+
+	// ErrHeartbeatTimeout indicates a Client closed a connection whose
+	// heartbeat went unanswered
+	ErrHeartbeatTimeout = errors.New("axon: heartbeat timeout")
+
+	// ErrHeaderTooLarge indicates the headers of an upgrade request
+	// exceed UpgradeOptions.MaxHeaderBytes
+	ErrHeaderTooLarge = errors.New("axon: request headers too large")
+
+	// ErrExtendedConnectUnsupported indicates a server dialed with
+	// DialOptions.EnableHTTP2 does not speak HTTP/2 or does not accept
+	// extended CONNECT requests
+	ErrExtendedConnectUnsupported = errors.New("axon: extended CONNECT not supported by server")
 )
@@ -23,12 +23,40 @@ func TestErrors(t *testing.T) {
 		{"InvalidMask", axon.ErrInvalidMask},
 		{"UnsupportedFrameType", axon.ErrUnsupportedFrameType},
 		{"FragmentedControlFrame", axon.ErrFragmentedControlFrame},
+		{"ControlFrameTooLarge", axon.ErrControlFrameTooLarge},
 		{"InvalidCloseCode", axon.ErrInvalidCloseCode},
+		{"InvalidUTF8", axon.ErrInvalidUTF8},
 		{"ReadDeadlineExceeded", axon.ErrReadDeadlineExceeded},
 		{"WriteDeadlineExceeded", axon.ErrWriteDeadlineExceeded},
 		{"ContextCanceled", axon.ErrContextCanceled},
 		{"SerializationFailed", axon.ErrSerializationFailed},
 		{"DeserializationFailed", axon.ErrDeserializationFailed},
+		{"HubClosed", axon.ErrHubClosed},
+		{"ConnectionNotFound", axon.ErrConnectionNotFound},
+		{"SlowConsumer", axon.ErrSlowConsumer},
+		{"UpgradeRejected", axon.ErrUpgradeRejected},
+		{"TooManyConnections", axon.ErrTooManyConnections},
+		{"RateLimited", axon.ErrRateLimited},
+		{"TooManyRedirects", axon.ErrTooManyRedirects},
+		{"CrossOriginRedirect", axon.ErrCrossOriginRedirect},
+		{"NoCallID", axon.ErrNoCallID},
+		{"DuplicateCallID", axon.ErrDuplicateCallID},
+		{"NoTopic", axon.ErrNoTopic},
+		{"ChannelClosed", axon.ErrChannelClosed},
+		{"MuxProtocol", axon.ErrMuxProtocol},
+		{"NoAckConfig", axon.ErrNoAckConfig},
+		{"SessionNotFound", axon.ErrSessionNotFound},
+		{"ReadLoopActive", axon.ErrReadLoopActive},
+		{"PullMode", axon.ErrPullMode},
+		{"ConnectionDegraded", axon.ErrConnectionDegraded},
+		{"ValidationFailed", axon.ErrValidationFailed},
+		{"InvalidProxyHeader", axon.ErrInvalidProxyHeader},
+		{"Unauthorized", axon.ErrUnauthorized},
+		{"DecryptionFailed", axon.ErrDecryptionFailed},
+		{"Banned", axon.ErrBanned},
+		{"HeartbeatTimeout", axon.ErrHeartbeatTimeout},
+		{"HeaderTooLarge", axon.ErrHeaderTooLarge},
+		{"ExtendedConnectUnsupported", axon.ErrExtendedConnectUnsupported},
 	}
 
 	for _, tt := range tests {
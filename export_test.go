@@ -1,17 +1,25 @@
 package axon
 
-import "net"
+import (
+	"net"
+	"time"
+)
 
 // Export internal functions for testing
 var (
-	ReadFrame  = readFrame
-	WriteFrame = writeFrame
-	GetBuffer  = getBuffer
-	PutBuffer  = putBuffer
-	GetReader  = getReader
-	PutReader  = putReader
-	GetWriter  = getWriter
-	PutWriter  = putWriter
+	ReadFrame     = readFrame
+	ReadFrameRSV  = readFrameRSV
+	ReadFrameInto = readFrameInto
+	WriteFrame    = writeFrame
+	GetBuffer     = getBuffer
+	PutBuffer     = putBuffer
+	GetReader     = getReader
+	PutReader     = putReader
+	GetWriter     = getWriter
+	PutWriter     = putWriter
+
+	MaskBytes    = maskBytes
+	MaskBytesPos = maskBytesPos
 )
 
 // NewTestConn creates a Conn for testing using net.Pipe
@@ -23,27 +31,155 @@ func NewTestConn[T any](opts *UpgradeOptions) (*Conn[T], net.Conn, error) {
 	u := NewUpgrader(opts)
 	clientConn, serverConn := net.Pipe()
 
-	readBuf := getBuffer()
-	writeBuf := getBuffer()
-	reader := getReader(serverConn)
-	writer := getWriter(serverConn)
+	readBuf := u.newBuffer(u.readBufferSize)
+	writeBuf := u.newBuffer(u.writeBufferSize)
+	reader := u.newReader(serverConn)
+	writer := u.newWriter(serverConn)
 
 	wsConn := &Conn[T]{
-		conn:          serverConn,
-		reader:        reader,
-		writer:        writer,
-		readBuf:       readBuf,
-		writeBuf:      writeBuf,
-		upgrader:      u,
-		readDeadline:  u.readDeadline,
-		writeDeadline: u.writeDeadline,
-		pingInterval:  u.pingInterval,
-		pongTimeout:   u.pongTimeout,
+		id:             newConnID(),
+		conn:           serverConn,
+		reader:         reader,
+		writer:         writer,
+		readBuf:        readBuf,
+		writeBuf:       writeBuf,
+		upgrader:       u,
+		readDeadline:   u.readDeadline,
+		writeDeadline:  u.writeDeadline,
+		pingInterval:   u.pingInterval,
+		pongTimeout:    u.pongTimeout,
+		extendDeadline: u.extendDeadline,
+		clk:            u.clock,
+		idleTimeout:    u.idleTimeout,
+		writeStall:     u.writeStall,
+	}
+
+	if u.enableCompression {
+		wsConn.compression = newCompressionManager(u.compression, u.deflatePrefs, false)
 	}
 
+	registry.add(wsConn)
+
 	if u.pingInterval > 0 {
 		wsConn.startPingLoop()
 	}
+	wsConn.startIdleTimer()
 
 	return wsConn, clientConn, nil
 }
+
+// NewCompressionPair creates client and server compression managers as if
+// permessage-deflate had been negotiated with the given takeover settings
+func NewCompressionPair(clientNoContextTakeover, serverNoContextTakeover bool) (client, server *CompressionManager) {
+	params := deflateParams{
+		clientNoContextTakeover: clientNoContextTakeover,
+		serverNoContextTakeover: serverNoContextTakeover,
+	}
+	cfg := newCompressionConfig(1, 0, CompressionPerConnection)
+	return newCompressionManager(cfg, params, true), newCompressionManager(cfg, params, false)
+}
+
+// NewConfiguredCompressionPair creates client and server compression managers
+// with context takeover negotiated and the given local settings
+func NewConfiguredCompressionPair(level int, strategy CompressionStrategy) (client, server *CompressionManager) {
+	cfg := newCompressionConfig(1, level, strategy)
+	return newCompressionManager(cfg, deflateParams{}, true), newCompressionManager(cfg, deflateParams{}, false)
+}
+
+// ParseDeflateResponse exposes permessage-deflate response parsing for a
+// client that offered no optional parameters
+func ParseDeflateResponse(header string) (serverNoContextTakeover bool, serverMaxWindowBits int, accepted bool, err error) {
+	extensions, err := ParseExtensions(header)
+	if err != nil {
+		return false, 0, false, err
+	}
+	params, accepted, err := parseDeflateResponse(extensions, deflateParams{})
+	return params.serverNoContextTakeover, params.serverMaxWindowBits, accepted, err
+}
+
+// ComputeAcceptKey exposes the Sec-WebSocket-Accept computation
+var ComputeAcceptKey = computeAcceptKey
+
+// ParseDeflateOffers exposes server-side permessage-deflate offer parsing,
+// returning the response the server would send for each acceptable offer
+func ParseDeflateOffers(header string) []string {
+	extensions, _ := ParseExtensions(header)
+	var responses []string
+	for _, offer := range parseDeflateOffers(extensions) {
+		responses = append(responses, offer.serverResponse())
+	}
+	return responses
+}
+
+// AcceptDeflateOffer returns the response an upgrader configured with opts
+// would send for the first acceptable offer in header
+func AcceptDeflateOffer(header string, opts *UpgradeOptions) string {
+	u := NewUpgrader(opts)
+	extensions, _ := ParseExtensions(header)
+	offers := parseDeflateOffers(extensions)
+	if len(offers) == 0 {
+		return ""
+	}
+	return offers[0].withServerPrefs(u.deflatePrefs).serverResponse()
+}
+
+// BufferSizes returns the sizes of the connection's read and write buffers
+func (c *Conn[T]) BufferSizes() (read, write int) {
+	return c.reader.Size(), c.writer.Size()
+}
+
+// Compressed reports whether the connection negotiated permessage-deflate
+func (c *Conn[T]) Compressed() bool {
+	return c.compression != nil
+}
+
+// WriteRawFrame writes frame as is, bypassing compression and extensions,
+// masked if c is a client
+func WriteRawFrame[T any](c *Conn[T], frame *Frame) error {
+	if c.isClient {
+		maskFrame(frame)
+	}
+	return c.writeRecorded(frame)
+}
+
+// SetClock replaces the limiter's time source
+func (l *TokenBucketLimiter) SetClock(now func() time.Time) {
+	l.now = now
+}
+
+// Happy Eyeballs internals
+var (
+	DialParallel    = dialParallel
+	InterleaveAddrs = interleaveAddrs
+)
+
+// SetClock replaces the store's time source
+func (m *MemorySessionStore) SetClock(now func() time.Time) {
+	m.now = now
+}
+
+// NewMessageQueue creates a message queue flushing up to concurrency keys
+// at once
+func NewMessageQueue[T any](maxSize int, timeout time.Duration, concurrency int, key func(T) string) *MessageQueue[T] {
+	mq := newMessageQueue[T](maxSize, timeout)
+	mq.setFlush(concurrency, key)
+	return mq
+}
+
+// ReconnectDelay returns the delay a reconnector configured with cfg waits
+// before its first attempt after err
+func ReconnectDelay(cfg *ReconnectConfig, err error) time.Duration {
+	r := newReconnector(cfg, nil, nil, nil)
+	r.attempts = 1
+	return r.delayAfter(err)
+}
+
+// SetClock replaces the provider's time source
+func (e *Endpoints) SetClock(now func() time.Time) {
+	e.now = now
+}
+
+// SetClock replaces the queue's clock
+func (mq *MessageQueue[T]) SetClock(clk Clock) {
+	mq.clock = clk
+}
@@ -0,0 +1,234 @@
+package axon
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Extension is an extension in a Sec-WebSocket-Extensions header (RFC 6455
+// Section 9.1), such as permessage-deflate with its parameters
+type Extension struct {
+	Name   string
+	Params []ExtensionParam
+}
+
+// ExtensionParam is a parameter of an Extension. Value is empty for a
+// parameter without one.
+type ExtensionParam struct {
+	Name  string
+	Value string
+}
+
+// Param returns the value of the parameter name and whether e has it
+func (e Extension) Param(name string) (string, bool) {
+	for _, p := range e.Params {
+		if p.Name == name {
+			return p.Value, true
+		}
+	}
+	return "", false
+}
+
+// String formats e as in a Sec-WebSocket-Extensions header, quoting
+// parameter values that are not tokens
+func (e Extension) String() string {
+	var b strings.Builder
+	b.WriteString(e.Name)
+	for _, p := range e.Params {
+		b.WriteString("; ")
+		b.WriteString(p.Name)
+		if p.Value == "" {
+			continue
+		}
+		b.WriteByte('=')
+		if isToken(p.Value) {
+			b.WriteString(p.Value)
+		} else {
+			b.WriteString(quote(p.Value))
+		}
+	}
+	return b.String()
+}
+
+// FormatExtensions joins extensions into a Sec-WebSocket-Extensions header
+// value
+func FormatExtensions(extensions ...Extension) string {
+	parts := make([]string, len(extensions))
+	for i, ext := range extensions {
+		parts[i] = ext.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ParseExtensions parses the values of Sec-WebSocket-Extensions headers into
+// their extensions, in order. An extension listed several times, as when a
+// client offers fallbacks, is returned each time. Malformed values return
+// ErrInvalidHandshake.
+func ParseExtensions(values ...string) ([]Extension, error) {
+	var extensions []Extension
+	for _, value := range values {
+		p := extensionParser{s: value}
+		parsed, err := p.parse()
+		if err != nil {
+			return nil, fmt.Errorf("%w: malformed Sec-WebSocket-Extensions %q", ErrInvalidHandshake, value)
+		}
+		extensions = append(extensions, parsed...)
+	}
+	return extensions, nil
+}
+
+// extensionParser parses a Sec-WebSocket-Extensions header value
+type extensionParser struct {
+	s   string
+	pos int
+}
+
+// parse parses the list of extensions, skipping empty list elements as
+// RFC 9110 Section 5.6.1 allows
+func (p *extensionParser) parse() ([]Extension, error) {
+	var extensions []Extension
+	for {
+		p.skipSpace()
+		if p.done() {
+			return extensions, nil
+		}
+		if p.next(',') {
+			continue
+		}
+
+		name, err := p.token()
+		if err != nil {
+			return nil, err
+		}
+		ext := Extension{Name: name}
+		for p.skipSpace(); p.next(';'); p.skipSpace() {
+			param, err := p.param()
+			if err != nil {
+				return nil, err
+			}
+			ext.Params = append(ext.Params, param)
+		}
+		extensions = append(extensions, ext)
+
+		if !p.done() && !p.next(',') {
+			return nil, fmt.Errorf("unexpected %q at %d", p.s[p.pos], p.pos)
+		}
+	}
+}
+
+// param parses a parameter, whose value may be a token or a quoted string.
+// RFC 6455 Section 9.1 requires quoted values to hold tokens, but that is
+// left to the extension, so that unknown extensions never fail the parse.
+func (p *extensionParser) param() (ExtensionParam, error) {
+	p.skipSpace()
+	name, err := p.token()
+	if err != nil {
+		return ExtensionParam{}, err
+	}
+	p.skipSpace()
+	if !p.next('=') {
+		return ExtensionParam{Name: name}, nil
+	}
+	p.skipSpace()
+
+	var value string
+	if p.next('"') {
+		value, err = p.quoted()
+	} else {
+		value, err = p.token()
+	}
+	if err != nil {
+		return ExtensionParam{}, err
+	}
+	return ExtensionParam{Name: name, Value: value}, nil
+}
+
+// token parses a token
+func (p *extensionParser) token() (string, error) {
+	start := p.pos
+	for !p.done() && isTokenChar(p.s[p.pos]) {
+		p.pos++
+	}
+	if p.pos == start {
+		return "", fmt.Errorf("expected a token at %d", start)
+	}
+	return p.s[start:p.pos], nil
+}
+
+// quoted parses the rest of a quoted string, unescaping quoted pairs
+func (p *extensionParser) quoted() (string, error) {
+	var b strings.Builder
+	for !p.done() {
+		c := p.s[p.pos]
+		p.pos++
+		switch c {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if p.done() {
+				return "", fmt.Errorf("unterminated escape")
+			}
+			c = p.s[p.pos]
+			p.pos++
+		}
+		b.WriteByte(c)
+	}
+	return "", fmt.Errorf("unterminated quoted string")
+}
+
+// skipSpace skips optional whitespace
+func (p *extensionParser) skipSpace() {
+	for !p.done() && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// next consumes c if it is the next byte
+func (p *extensionParser) next(c byte) bool {
+	if !p.done() && p.s[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// done reports whether the whole value was parsed
+func (p *extensionParser) done() bool {
+	return p.pos >= len(p.s)
+}
+
+// isToken reports whether s is a non-empty token (RFC 9110 Section 5.6.2)
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isTokenChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// isTokenChar reports whether c may appear in a token
+func isTokenChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
+
+// quote returns s as a quoted string
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,117 @@
+package axon_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/kolosys/axon"
+)
+
+func TestParseExtensions(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		want   []axon.Extension
+	}{
+		{
+			name:   "Single",
+			values: []string{"permessage-deflate"},
+			want:   []axon.Extension{{Name: "permessage-deflate"}},
+		},
+		{
+			name:   "Params",
+			values: []string{"permessage-deflate; client_max_window_bits; server_max_window_bits=10"},
+			want: []axon.Extension{{Name: "permessage-deflate", Params: []axon.ExtensionParam{
+				{Name: "client_max_window_bits"},
+				{Name: "server_max_window_bits", Value: "10"},
+			}}},
+		},
+		{
+			name:   "QuotedParams",
+			values: []string{`x-custom; list="a, b; c"; esc="say \"hi\""`},
+			want: []axon.Extension{{Name: "x-custom", Params: []axon.ExtensionParam{
+				{Name: "list", Value: "a, b; c"},
+				{Name: "esc", Value: `say "hi"`},
+			}}},
+		},
+		{
+			name:   "FallbackOffers",
+			values: []string{"permessage-deflate; server_max_window_bits=10, permessage-deflate"},
+			want: []axon.Extension{
+				{Name: "permessage-deflate", Params: []axon.ExtensionParam{{Name: "server_max_window_bits", Value: "10"}}},
+				{Name: "permessage-deflate"},
+			},
+		},
+		{
+			name:   "SeveralHeaders",
+			values: []string{"x-webkit-deflate-frame", " permessage-deflate ;server_no_context_takeover ,, "},
+			want: []axon.Extension{
+				{Name: "x-webkit-deflate-frame"},
+				{Name: "permessage-deflate", Params: []axon.ExtensionParam{{Name: "server_no_context_takeover"}}},
+			},
+		},
+		{
+			name:   "Empty",
+			values: []string{""},
+			want:   nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := axon.ParseExtensions(tt.values...)
+			if err != nil {
+				t.Fatalf("ParseExtensions() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseExtensions() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseExtensionsMalformed(t *testing.T) {
+	for _, value := range []string{
+		"permessage-deflate; ",
+		"permessage-deflate; a=",
+		`permessage-deflate; a="unterminated`,
+		"permessage-deflate x",
+		"; a=1",
+		"per message",
+	} {
+		t.Run(value, func(t *testing.T) {
+			if _, err := axon.ParseExtensions(value); !errors.Is(err, axon.ErrInvalidHandshake) {
+				t.Errorf("expected ErrInvalidHandshake, got %v", err)
+			}
+		})
+	}
+}
+
+func TestFormatExtensions(t *testing.T) {
+	exts := []axon.Extension{
+		{Name: "permessage-deflate", Params: []axon.ExtensionParam{
+			{Name: "server_no_context_takeover"},
+			{Name: "server_max_window_bits", Value: "10"},
+		}},
+		{Name: "x-custom", Params: []axon.ExtensionParam{{Name: "list", Value: `a, "b"`}}},
+	}
+
+	header := axon.FormatExtensions(exts...)
+	want := `permessage-deflate; server_no_context_takeover; server_max_window_bits=10, x-custom; list="a, \"b\""`
+	if header != want {
+		t.Errorf("FormatExtensions() = %q, want %q", header, want)
+	}
+
+	parsed, err := axon.ParseExtensions(header)
+	if err != nil || !reflect.DeepEqual(parsed, exts) {
+		t.Errorf("round trip = %+v (err=%v), want %+v", parsed, err, exts)
+	}
+
+	if v, ok := parsed[0].Param("server_max_window_bits"); !ok || v != "10" {
+		t.Errorf("Param() = %q, %v, want 10, true", v, ok)
+	}
+	if _, ok := parsed[0].Param("client_max_window_bits"); ok {
+		t.Error("Param() should not find a missing parameter")
+	}
+}
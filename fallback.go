@@ -0,0 +1,407 @@
+package axon
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FallbackHeader identifies requests of the HTTP fallback transport. It is
+// "open" on the request for the event stream, which starts with an "open"
+// event carrying the session ID, and holds the session ID on the POST
+// requests that carry the client's frames.
+//
+// Both directions carry the same frames as a WebSocket connection, so
+// pings, close handshakes and message limits work unchanged. The server's
+// frames arrive base64 encoded in the data of unnamed events.
+const FallbackHeader = "X-Axon-Fallback"
+
+// fallbackOpen is the FallbackHeader value of the event stream request
+const fallbackOpen = "open"
+
+// upgradeBlocked reports whether a failed dial was refused in a way that
+// the HTTP fallback may get around
+func upgradeBlocked(err error) bool {
+	var hsErr *HandshakeError
+	if !errors.As(err, &hsErr) {
+		return false
+	}
+	switch hsErr.StatusCode() {
+	case http.StatusBadRequest, http.StatusUpgradeRequired:
+		return true
+	}
+	return false
+}
+
+// fallbackSessions tracks the open fallback sessions of an Upgrader by
+// their session ID
+type fallbackSessions struct {
+	mu       sync.Mutex
+	sessions map[string]net.Conn // The transport's end of each pipe
+}
+
+func newFallbackSessions() *fallbackSessions {
+	return &fallbackSessions{sessions: make(map[string]net.Conn)}
+}
+
+func (s *fallbackSessions) add(id string, pipe net.Conn) {
+	s.mu.Lock()
+	s.sessions[id] = pipe
+	s.mu.Unlock()
+}
+
+func (s *fallbackSessions) get(id string) net.Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sessions[id]
+}
+
+func (s *fallbackSessions) remove(id string) {
+	s.mu.Lock()
+	delete(s.sessions, id)
+	s.mu.Unlock()
+}
+
+// post passes the body of a POST request to its session
+func (s *fallbackSessions) post(w http.ResponseWriter, r *http.Request) {
+	pipe := s.get(r.Header.Get(FallbackHeader))
+	if pipe == nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if _, err := io.Copy(pipe, r.Body); err != nil {
+		http.Error(w, http.StatusText(http.StatusGone), http.StatusGone)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveFallback serves a request of the HTTP fallback transport, running
+// the handler for the duration of an event stream
+func (h *handler[T]) serveFallback(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		h.upgrader.fallback.post(w, r)
+		return
+	}
+
+	conn, id, pipe, err := upgradeFallback[T](h.upgrader, w, r)
+	if err != nil {
+		if h.onError != nil {
+			h.onError(nil, err)
+		}
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runConn(conn.Context(), conn, h.serve, h.onError)
+	}()
+
+	defer h.upgrader.fallback.remove(id)
+
+	// The client leaving ends the connection
+	stop := context.AfterFunc(r.Context(), func() { pipe.Close() })
+	defer stop()
+
+	streamFrames(w, pipe)
+	pipe.Close()
+	<-done
+}
+
+// upgradeFallback opens an event stream session for r. It returns the
+// connection, the session ID and the transport's end of its pipe.
+func upgradeFallback[T any](u *Upgrader, w http.ResponseWriter, r *http.Request) (upgraded *Conn[T], id string, pipe net.Conn, err error) {
+	responding := false
+	defer func() {
+		if err != nil {
+			u.logger.Info("upgrade rejected", "remote_addr", r.RemoteAddr, "error", err)
+			u.recordHandshakeError(err)
+			if !responding {
+				u.writeError(w, r, err)
+			}
+			return
+		}
+		upgraded.logger().Debug("connection opened over HTTP fallback", "remote_addr", r.RemoteAddr)
+	}()
+
+	if r.Method != http.MethodGet || r.Header.Get(FallbackHeader) != fallbackOpen {
+		return nil, "", nil, ErrUpgradeRequired
+	}
+	if u.checkOrigin != nil && !u.checkOrigin(r) {
+		return nil, "", nil, ErrInvalidOrigin
+	}
+	subprotocol, err := u.selectSubprotocol(r)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	rc := http.NewResponseController(w)
+
+	release, err := u.admit(w.Header(), r)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	ctx, err := u.before(w.Header(), r)
+	if err != nil {
+		release()
+		return nil, "", nil, err
+	}
+
+	id = rand.Text()
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-Accel-Buffering", "no") // Keep proxies from buffering events
+	if subprotocol != "" {
+		header.Set("Sec-WebSocket-Protocol", subprotocol)
+	}
+	responding = true
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", fallbackOpen, id)
+	if err := rc.Flush(); err != nil {
+		release()
+		return nil, "", nil, fmt.Errorf("axon: failed to flush response: %w", err)
+	}
+
+	local, pipe := net.Pipe()
+	wsConn := newServerConn[T](ctx, u, local, u.newReader(local), &handshake{
+		subprotocol: subprotocol,
+		clientIP:    ClientIP(r, u.trustedProxies),
+		clientTLS:   ClientTLS(r, u.trustedProxies),
+		release:     release,
+	})
+	u.fallback.add(id, pipe)
+
+	if err := u.after(wsConn); err != nil {
+		u.fallback.remove(id)
+		pipe.Close()
+		return nil, "", nil, err
+	}
+	return wsConn, id, pipe, nil
+}
+
+// streamFrames writes the bytes read from pipe to w as events until either
+// fails
+func streamFrames(w http.ResponseWriter, pipe net.Conn) {
+	rc := http.NewResponseController(w)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := pipe.Read(buf)
+		if err != nil {
+			return
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", base64.StdEncoding.EncodeToString(buf[:n])); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// dialFallback connects to rawURL over the HTTP fallback transport
+func dialFallback[T any](ctx context.Context, d *Dialer, rawURL string) (*Conn[T], error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("axon: invalid URL: %w", err)
+	}
+	if d, err = d.authorize(ctx); err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	default:
+		return nil, fmt.Errorf("axon: unsupported scheme: %s", u.Scheme)
+	}
+
+	opts := d.opts
+	if opts == nil {
+		opts = &DialOptions{}
+	}
+	handshakeTimeout := opts.HandshakeTimeout
+	if handshakeTimeout == 0 {
+		handshakeTimeout = 30 * time.Second
+	}
+
+	// The event stream outlives ctx, which only bounds opening it
+	streamCtx, cancel := context.WithCancel(context.Background())
+	openCtx, openCancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer openCancel()
+	stopOpen := context.AfterFunc(openCtx, cancel)
+
+	client := &http.Client{Transport: d.fallbackTransport()}
+	req, err := http.NewRequestWithContext(streamCtx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("axon: invalid URL: %w", err)
+	}
+	if opts.Headers != nil {
+		req.Header = opts.Headers.Clone()
+	}
+	req.Host = opts.Host
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set(FallbackHeader, fallbackOpen)
+	if len(opts.Subprotocols) > 0 {
+		req.Header.Set("Sec-WebSocket-Protocol", strings.Join(opts.Subprotocols, ", "))
+	}
+
+	fail := func(err error) (*Conn[T], error) {
+		cancel()
+		if openCtx.Err() != nil {
+			return nil, fmt.Errorf("axon: dial failed: %w", openCtx.Err())
+		}
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fail(fmt.Errorf("axon: dial failed: %w", err))
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if resp.StatusCode != http.StatusOK || mediaType != "text/event-stream" {
+		err := newHandshakeError(resp)
+		resp.Body.Close()
+		return fail(err)
+	}
+
+	events := bufio.NewReader(resp.Body)
+	name, id, err := readEvent(events)
+	if err != nil || name != fallbackOpen || id == "" {
+		resp.Body.Close()
+		return fail(ErrInvalidHandshake)
+	}
+	subprotocol := resp.Header.Get("Sec-WebSocket-Protocol")
+	if subprotocol != "" && !slices.Contains(opts.Subprotocols, subprotocol) {
+		resp.Body.Close()
+		return fail(ErrInvalidSubprotocol)
+	}
+	if !stopOpen() {
+		resp.Body.Close()
+		return fail(ErrInvalidHandshake)
+	}
+
+	local, pipe := net.Pipe()
+	conn := newClientConn[T](opts, local, nil, subprotocol, nil, nil)
+
+	// Server frames are written to the pipe as their events arrive
+	go func() {
+		defer pipe.Close()
+		defer resp.Body.Close()
+		for {
+			_, data, err := readEvent(events)
+			if err != nil {
+				return
+			}
+			frames, err := base64.StdEncoding.DecodeString(data)
+			if err != nil {
+				return
+			}
+			if _, err := pipe.Write(frames); err != nil {
+				return
+			}
+		}
+	}()
+
+	// Client frames are posted in order as the connection writes them
+	go func() {
+		defer cancel()
+		defer pipe.Close()
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := pipe.Read(buf)
+			if err != nil {
+				return
+			}
+			if err := postFrames(streamCtx, client, u, opts, id, buf[:n]); err != nil {
+				conn.logger().Debug("fallback write failed", "error", err)
+				return
+			}
+		}
+	}()
+
+	return conn, nil
+}
+
+// postFrames sends frames of the session id to the server
+func postFrames(ctx context.Context, client *http.Client, u *url.URL, opts *DialOptions, id string, frames []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(frames))
+	if err != nil {
+		return err
+	}
+	if opts.Headers != nil {
+		req.Header = opts.Headers.Clone()
+	}
+	req.Host = opts.Host
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set(FallbackHeader, id)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return newHandshakeError(resp)
+	}
+	return nil
+}
+
+// readEvent reads the next event from an event stream, skipping comments
+// and events without data
+func readEvent(r *bufio.Reader) (name, data string, err error) {
+	var lines []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if lines != nil {
+				return name, strings.Join(lines, "\n"), nil
+			}
+			name = ""
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			name = value
+		case "data":
+			lines = append(lines, value)
+		}
+	}
+}
+
+// fallbackTransport returns an HTTP transport that connects the way the
+// dialer does, including its proxy, TLS and host overrides
+func (d *Dialer) fallbackTransport() *http.Transport {
+	dial := func(scheme string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return d.dial(ctx, &url.URL{Scheme: scheme, Host: addr})
+		}
+	}
+	return &http.Transport{
+		DialContext:    dial("http"),
+		DialTLSContext: dial("https"),
+	}
+}
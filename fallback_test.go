@@ -0,0 +1,141 @@
+package axon_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+// strippingProxy starts a reverse proxy to target that drops the Upgrade
+// header, as some corporate proxies do
+func strippingProxy(t *testing.T, target string) string {
+	t.Helper()
+	u, err := url.Parse(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httputil.NewSingleHostReverseProxy(u)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del("Upgrade")
+		r.Header.Del("Connection")
+		proxy.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// fallbackEchoServer starts a server that echoes messages with an "echo " prefix
+func fallbackEchoServer(t *testing.T, opts *axon.UpgradeOptions) string {
+	t.Helper()
+	server := httptest.NewServer(axon.Handler(opts, func(ctx context.Context, conn *axon.Conn[string]) {
+		for {
+			msg, err := conn.Read(ctx)
+			if err != nil {
+				return
+			}
+			if err := conn.Write(ctx, "echo "+msg); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestClient_HTTPFallback(t *testing.T) {
+	url := strippingProxy(t, fallbackEchoServer(t, &axon.UpgradeOptions{
+		HTTPFallback:   true,
+		Subprotocols:   []string{"v1"},
+		MaxFrameSize:   1 << 20,
+		MaxMessageSize: 1 << 20,
+	}))
+
+	opts := axon.DefaultClientOptions()
+	opts.HTTPFallback = true
+	opts.Reconnect = nil
+	opts.Subprotocols = []string{"v1"}
+	opts.MaxFrameSize = 1 << 20
+	client := axon.NewClient[string](url, opts)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	if got := client.Conn().Subprotocol(); got != "v1" {
+		t.Errorf("Subprotocol() = %q, want v1", got)
+	}
+
+	for _, msg := range []string{"hello", strings.Repeat("x", 100000)} {
+		if err := client.Write(ctx, msg); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		got, err := client.Read(ctx)
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+		if got != "echo "+msg {
+			t.Errorf("Read() = %.20q, want the echo of %.20q", got, msg)
+		}
+	}
+}
+
+func TestClient_HTTPFallbackDisabled(t *testing.T) {
+	url := strippingProxy(t, fallbackEchoServer(t, &axon.UpgradeOptions{HTTPFallback: true}))
+
+	opts := axon.DefaultClientOptions()
+	opts.Reconnect = nil
+	client := axon.NewClient[string](url, opts)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var hsErr *axon.HandshakeError
+	if err := client.Connect(ctx); !errors.As(err, &hsErr) || hsErr.StatusCode() != http.StatusUpgradeRequired {
+		t.Errorf("Connect() error = %v, want 426 Upgrade Required", err)
+	}
+}
+
+func TestHandler_HTTPFallback(t *testing.T) {
+	url := fallbackEchoServer(t, &axon.UpgradeOptions{HTTPFallback: true})
+
+	// Frames for an unknown session are rejected
+	req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader("frame"))
+	req.Header.Set(axon.FallbackHeader, "unknown")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("POST to an unknown session status = %d, want 404", resp.StatusCode)
+	}
+
+	// Opening a stream starts with the session ID
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ = http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	req.Header.Set(axon.FallbackHeader, "open")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); resp.StatusCode != http.StatusOK || ct != "text/event-stream" {
+		t.Fatalf("stream response = %d %s", resp.StatusCode, ct)
+	}
+	buf := make([]byte, 64)
+	n, _ := resp.Body.Read(buf)
+	if !strings.HasPrefix(string(buf[:n]), "event: open\ndata: ") {
+		t.Errorf("stream starts with %q, want the open event", buf[:n])
+	}
+}
@@ -0,0 +1,94 @@
+package axon
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// Faults injects network failures into a connection, for testing how
+// reconnection, message queues and timeouts behave when the network
+// misbehaves. Never enable it in production.
+type Faults struct {
+	// DropRate is the fraction of messages, from 0 to 1, silently lost in
+	// each direction. Dropped writes report success.
+	// Default is 0 (none).
+	DropRate float64
+
+	// WriteDelay delays every message written, as a slow link would
+	// Default is 0.
+	WriteDelay time.Duration
+
+	// CloseAfter closes the connection this long after faults are
+	// injected, with CloseCode and CloseReason.
+	// Default is 0 (never).
+	CloseAfter time.Duration
+
+	// CloseCode is the code of the forced close. Zero or
+	// CloseAbnormalClosure drops the network connection without a close
+	// frame, as when a link fails.
+	CloseCode CloseCode
+
+	// CloseReason is the reason of the forced close
+	CloseReason string
+
+	// Rand returns the numbers in [0, 1) that decide which messages are
+	// dropped, so tests can make drops reproducible. It must be safe for
+	// concurrent use.
+	// Default is math/rand.Float64.
+	Rand func() float64
+}
+
+// InjectFaults makes the connection lose, delay and close messages as
+// configured. Reads and writes are wrapped with WrapRead and WrapWrite,
+// so call it before the connection is used, or set Faults in the options.
+// Delays and forced closes follow the connection's Clock.
+func (c *Conn[T]) InjectFaults(f Faults) {
+	random := f.Rand
+	if random == nil {
+		random = rand.Float64
+	}
+	drop := func() bool {
+		return f.DropRate > 0 && random() < f.DropRate
+	}
+
+	c.WrapRead(func(next ReadFunc) ReadFunc {
+		return func(ctx context.Context) (MessageType, []byte, error) {
+			for {
+				mt, data, err := next(ctx)
+				if err != nil || !drop() {
+					return mt, data, err
+				}
+			}
+		}
+	})
+	c.WrapWrite(func(next WriteFunc) WriteFunc {
+		return func(ctx context.Context, messageType MessageType, data []byte) error {
+			if f.WriteDelay > 0 {
+				timer := c.clock().NewTimer(f.WriteDelay)
+				select {
+				case <-timer.C():
+				case <-ctx.Done():
+					timer.Stop()
+					return ErrContextCanceled
+				}
+			}
+			if drop() {
+				return nil
+			}
+			return next(ctx, messageType, data)
+		}
+	})
+
+	if f.CloseAfter > 0 {
+		c.faultTimer = c.clock().AfterFunc(f.CloseAfter, func() {
+			if f.CloseCode == 0 || f.CloseCode == CloseAbnormalClosure {
+				c.logger().Debug("injected connection drop")
+				c.conn.Close()
+				return
+			}
+			c.logger().Debug("injected close", "code", int(f.CloseCode))
+			c.Close(int(f.CloseCode), f.CloseReason)
+		})
+	}
+}
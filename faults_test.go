@@ -0,0 +1,179 @@
+package axon_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+// sequence returns a Rand yielding values in turn, cycling
+func sequence(values ...float64) func() float64 {
+	var mu sync.Mutex
+	i := 0
+	return func() float64 {
+		mu.Lock()
+		defer mu.Unlock()
+		v := values[i%len(values)]
+		i++
+		return v
+	}
+}
+
+func TestInjectFaults_DropWrites(t *testing.T) {
+	faults := &axon.Faults{DropRate: 0.5, Rand: sequence(0.1, 0.9)}
+	client, server, err := axon.Pipe[string](&axon.DialOptions{Faults: faults}, nil)
+	if err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	defer client.Close(1000, "")
+	defer server.Close(1000, "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	go func() {
+		for _, msg := range []string{"one", "two", "three", "four"} {
+			if err := client.Write(ctx, msg); err != nil {
+				t.Errorf("Write(%s) error = %v", msg, err)
+			}
+		}
+	}()
+
+	// Every other message is lost
+	for _, want := range []string{"two", "four"} {
+		if msg, err := server.Read(ctx); err != nil || msg != want {
+			t.Fatalf("Read() = %q, %v, want %q", msg, err, want)
+		}
+	}
+}
+
+func TestInjectFaults_DropReads(t *testing.T) {
+	faults := &axon.Faults{DropRate: 0.5, Rand: sequence(0.1, 0.9)}
+	client, server, err := axon.Pipe[string](nil, &axon.UpgradeOptions{Faults: faults})
+	if err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	defer client.Close(1000, "")
+	defer server.Close(1000, "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	go func() {
+		client.Write(ctx, "one")
+		client.Write(ctx, "two")
+	}()
+	if msg, err := server.Read(ctx); err != nil || msg != "two" {
+		t.Fatalf("Read() = %q, %v, want two", msg, err)
+	}
+}
+
+func TestInjectFaults_WriteDelay(t *testing.T) {
+	clock := axon.NewFakeClock(time.Now())
+	client, server, err := axon.Pipe[string](&axon.DialOptions{
+		Clock:  clock,
+		Faults: &axon.Faults{WriteDelay: time.Minute},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	defer client.Close(1000, "")
+	defer server.Close(1000, "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	written := make(chan error, 1)
+	go func() { written <- client.Write(ctx, "late") }()
+
+	clock.BlockUntil(1)
+	select {
+	case err := <-written:
+		t.Fatalf("Write() returned %v before the delay", err)
+	default:
+	}
+	clock.Advance(time.Minute)
+	if msg, err := server.Read(ctx); err != nil || msg != "late" {
+		t.Fatalf("Read() = %q, %v, want late", msg, err)
+	}
+	if err := <-written; err != nil {
+		t.Errorf("Write() error = %v", err)
+	}
+}
+
+func TestInjectFaults_CloseAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		code   axon.CloseCode
+		wantCE bool
+	}{
+		{"close frame", axon.CloseServiceRestart, true},
+		{"drop", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := axon.NewFakeClock(time.Now())
+			client, server, err := axon.Pipe[string](nil, &axon.UpgradeOptions{
+				Clock:  clock,
+				Faults: &axon.Faults{CloseAfter: time.Second, CloseCode: tt.code, CloseReason: "chaos"},
+			})
+			if err != nil {
+				t.Fatalf("Pipe() error = %v", err)
+			}
+			defer client.Close(1000, "")
+			defer server.Close(1000, "")
+
+			clock.BlockUntil(1)
+			clock.Advance(time.Second)
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			_, err = client.Read(ctx)
+			closeErr := axon.AsCloseError(err)
+			if tt.wantCE {
+				if closeErr == nil || closeErr.Code != tt.code || closeErr.Reason != "chaos" {
+					t.Errorf("Read() error = %v, want close %d", err, tt.code)
+				}
+				return
+			}
+			if err == nil || (closeErr != nil && closeErr.Code != axon.CloseAbnormalClosure) {
+				t.Errorf("Read() error = %v, want a dropped connection", err)
+			}
+		})
+	}
+}
+
+func TestInjectFaults_ClientReconnects(t *testing.T) {
+	// Every served connection is dropped shortly after it opens
+	var conns atomic.Int32
+	serve := func(ctx context.Context, conn *axon.Conn[string]) {
+		conns.Add(1)
+		for {
+			if _, err := conn.Read(ctx); err != nil {
+				return
+			}
+		}
+	}
+
+	opts := axon.DefaultClientOptions()
+	opts.Reconnect.InitialDelay = 10 * time.Millisecond
+	opts.Reconnect.Jitter = false
+	client := axon.NewTestClient[string](opts, &axon.UpgradeOptions{
+		Faults: &axon.Faults{CloseAfter: 20 * time.Millisecond, CloseCode: axon.CloseGoingAway},
+	}, serve)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.ConnectWithReadLoop(ctx); err != nil && !errors.Is(err, axon.ErrConnectionClosed) {
+		t.Fatalf("ConnectWithReadLoop() error = %v", err)
+	}
+	for conns.Load() < 3 {
+		if ctx.Err() != nil {
+			t.Fatalf("served %d connections, want the client to keep reconnecting", conns.Load())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -2,7 +2,10 @@ package axon
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
+	"net"
 )
 
 const (
@@ -15,14 +18,42 @@ const (
 	opPong         = 0xA
 
 	// Frame flags
-	finMask = 0x80
-	rsvMask = 0x70
-	opMask  = 0x0F
+	finMask  = 0x80
+	rsvMask  = 0x70
+	rsv1Mask = 0x40
+	rsv2Mask = 0x20
+	rsv3Mask = 0x10
+	opMask   = 0x0F
 
 	// Maximum frame header size (2 bytes base + 8 bytes extended length + 4 bytes mask)
 	maxFrameHeaderSize = 14
+
+	// Maximum control frame payload size (RFC 6455 Section 5.5)
+	maxControlPayloadSize = 125
+)
+
+// MessageType identifies the type of a WebSocket data message
+type MessageType int
+
+const (
+	// TextMessage denotes a UTF-8 encoded text message
+	TextMessage MessageType = opText
+	// BinaryMessage denotes a binary data message
+	BinaryMessage MessageType = opBinary
 )
 
+// String returns the string representation of the message type
+func (t MessageType) String() string {
+	switch t {
+	case TextMessage:
+		return "text"
+	case BinaryMessage:
+		return "binary"
+	default:
+		return "unknown"
+	}
+}
+
 // Frame represents a WebSocket frame
 type Frame struct {
 	Fin     bool
@@ -33,15 +64,39 @@ type Frame struct {
 	Masked  bool
 	MaskKey []byte
 	Payload []byte
+
+	// maskOnWrite marks a frame whose Payload is still unmasked, to be
+	// masked with maskKey as it is written
+	maskOnWrite bool
+	maskKey     [4]byte
+}
+
+// rsv returns the frame's RSV bits as a mask
+func (f *Frame) rsv() byte {
+	var rsv byte
+	if f.Rsv1 {
+		rsv |= rsv1Mask
+	}
+	if f.Rsv2 {
+		rsv |= rsv2Mask
+	}
+	if f.Rsv3 {
+		rsv |= rsv3Mask
+	}
+	return rsv
 }
 
-// readFrameHeader reads and parses a WebSocket frame header without allocations
-func readFrameHeader(r io.Reader, buf []byte) (*Frame, error) {
+// readFrameHeader reads and parses a WebSocket frame header into frame
+// without allocations. It returns the declared payload length; the payload
+// itself is left unread so the caller can validate the length before
+// allocating. rsv holds the RSV bits reserved by negotiated extensions.
+// Unless strict is set, control frames may exceed 125 bytes.
+func readFrameHeader(r io.Reader, buf []byte, rsv byte, strict bool, frame *Frame) (uint64, error) {
 	if _, err := io.ReadFull(r, buf[:2]); err != nil {
-		return nil, err
+		return 0, err
 	}
 
-	frame := &Frame{
+	*frame = Frame{
 		Fin:    (buf[0] & finMask) != 0,
 		Rsv1:   (buf[0] & 0x40) != 0,
 		Rsv2:   (buf[0] & 0x20) != 0,
@@ -51,67 +106,101 @@ func readFrameHeader(r io.Reader, buf []byte) (*Frame, error) {
 	}
 
 	// RSV bits must be 0 unless extension negotiated
-	if (buf[0] & rsvMask) != 0 {
-		return nil, ErrInvalidFrame
+	if (buf[0] & rsvMask &^ rsv) != 0 {
+		return 0, readError(ErrInvalidFrame, frame.Opcode, 0, 0)
 	}
 
 	if frame.Opcode > 0x7 && frame.Opcode < 0x8 {
-		return nil, ErrUnsupportedFrameType
+		return 0, readError(ErrUnsupportedFrameType, frame.Opcode, 0, 0)
 	}
 	if frame.Opcode > 0xA {
-		return nil, ErrUnsupportedFrameType
+		return 0, readError(ErrUnsupportedFrameType, frame.Opcode, 0, 0)
 	}
 
 	if frame.Opcode >= 0x8 && !frame.Fin {
-		return nil, ErrFragmentedControlFrame
+		return 0, readError(ErrFragmentedControlFrame, frame.Opcode, 0, 0)
 	}
 
-	payloadLen := int(buf[1] & 0x7F)
+	payloadLen := uint64(buf[1] & 0x7F)
 	headerSize := 2
 
+	if strict && isControl(frame.Opcode) && payloadLen > maxControlPayloadSize {
+		return 0, readError(ErrControlFrameTooLarge, frame.Opcode, payloadLen, maxControlPayloadSize)
+	}
+
 	switch payloadLen {
 	case 126:
 		if _, err := io.ReadFull(r, buf[2:4]); err != nil {
-			return nil, err
+			return 0, err
 		}
-		payloadLen = int(binary.BigEndian.Uint16(buf[2:4]))
+		payloadLen = uint64(binary.BigEndian.Uint16(buf[2:4]))
 		headerSize = 4
 	case 127:
 		if _, err := io.ReadFull(r, buf[2:10]); err != nil {
-			return nil, err
+			return 0, err
 		}
-		if buf[2] != 0 || buf[3] != 0 || buf[4] != 0 || buf[5] != 0 {
-			return nil, ErrFrameTooLarge
+		payloadLen = binary.BigEndian.Uint64(buf[2:10])
+		// The most significant bit must be 0 (RFC 6455 Section 5.2)
+		if payloadLen>>63 != 0 {
+			return 0, readError(ErrInvalidFrame, frame.Opcode, payloadLen, 0)
 		}
-		payloadLen = int(binary.BigEndian.Uint32(buf[6:10]))
 		headerSize = 10
 	}
 
 	if frame.Masked {
 		if _, err := io.ReadFull(r, buf[headerSize:headerSize+4]); err != nil {
-			return nil, err
+			return 0, err
 		}
 		frame.MaskKey = buf[headerSize : headerSize+4]
 	}
 
-	frame.Payload = make([]byte, payloadLen)
-	return frame, nil
+	return payloadLen, nil
 }
 
-// readFrame reads a complete frame including payload
+// readFrame reads a complete frame including payload.
+// When the payload fits in buf after the header scratch space it is read into
+// buf without allocating; in that case frame.Payload aliases buf and is only
+// valid until the next call that reuses buf.
 func readFrame(r io.Reader, buf []byte, maxSize int) (*Frame, error) {
-	frame, err := readFrameHeader(r, buf)
-	if err != nil {
+	return readFrameRSV(r, buf, maxSize, 0)
+}
+
+// readFrameRSV is like readFrame but permits the RSV bits in rsv, which are
+// reserved by extensions negotiated for the connection
+func readFrameRSV(r io.Reader, buf []byte, maxSize int, rsv byte) (*Frame, error) {
+	frame := new(Frame)
+	if err := readFrameInto(r, buf, maxSize, rsv, true, frame); err != nil {
 		return nil, err
 	}
+	return frame, nil
+}
+
+// readFrameInto is like readFrameRSV but fills a frame owned by the caller,
+// so that a connection reading many frames reuses one. Unless strict is
+// set, control frames may exceed 125 bytes.
+func readFrameInto(r io.Reader, buf []byte, maxSize int, rsv byte, strict bool, frame *Frame) error {
+	payloadLen, err := readFrameHeader(r, buf, rsv, strict, frame)
+	if err != nil {
+		return err
+	}
 
-	if len(frame.Payload) > maxSize {
-		return nil, ErrFrameTooLarge
+	// Compare as uint64 so lengths beyond the platform int range are rejected
+	// rather than truncated
+	if maxSize < 0 || payloadLen > uint64(maxSize) {
+		return readError(ErrFrameTooLarge, frame.Opcode, payloadLen, maxSize)
+	}
+
+	// Compare in uint64 as well, since the header size plus a length near
+	// maxSize could overflow int
+	if payloadLen <= uint64(len(buf)-maxFrameHeaderSize) {
+		frame.Payload = buf[maxFrameHeaderSize : maxFrameHeaderSize+int(payloadLen)]
+	} else {
+		frame.Payload = make([]byte, payloadLen)
 	}
 
 	if len(frame.Payload) > 0 {
 		if _, err := io.ReadFull(r, frame.Payload); err != nil {
-			return nil, err
+			return err
 		}
 
 		if frame.Masked {
@@ -119,11 +208,38 @@ func readFrame(r io.Reader, buf []byte, maxSize int) (*Frame, error) {
 		}
 	}
 
-	return frame, nil
+	return nil
 }
 
 // writeFrame writes a frame header and payload
 func writeFrame(w io.Writer, buf []byte, frame *Frame) error {
+	headerSize, err := putFrameHeader(buf, frame)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(buf[:headerSize]); err != nil {
+		return err
+	}
+
+	if frame.maskOnWrite {
+		return writeMasked(w, buf, frame.Payload, frame.maskKey[:])
+	}
+	if len(frame.Payload) > 0 {
+		if _, err := w.Write(frame.Payload); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// putFrameHeader encodes the header of frame into buf and returns its size
+func putFrameHeader(buf []byte, frame *Frame) (int, error) {
+	if isControl(frame.Opcode) && len(frame.Payload) > maxControlPayloadSize {
+		return 0, writeError(ErrControlFrameTooLarge, frame.Opcode, len(frame.Payload), maxControlPayloadSize)
+	}
+
 	headerSize := 2
 	buf[0] = 0
 
@@ -131,15 +247,7 @@ func writeFrame(w io.Writer, buf []byte, frame *Frame) error {
 		buf[0] |= finMask
 	}
 
-	if frame.Rsv1 {
-		buf[0] |= 0x40
-	}
-	if frame.Rsv2 {
-		buf[0] |= 0x20
-	}
-	if frame.Rsv3 {
-		buf[0] |= 0x10
-	}
+	buf[0] |= frame.rsv()
 
 	buf[0] |= frame.Opcode & opMask
 
@@ -163,27 +271,115 @@ func writeFrame(w io.Writer, buf []byte, frame *Frame) error {
 		headerSize = 10
 	}
 
+	key := frame.MaskKey
+	if frame.maskOnWrite {
+		key = frame.maskKey[:]
+	}
 	if frame.Masked {
-		copy(buf[headerSize:headerSize+4], frame.MaskKey)
+		copy(buf[headerSize:headerSize+4], key)
 		headerSize += 4
 	}
 
-	if _, err := w.Write(buf[:headerSize]); err != nil {
+	return headerSize, nil
+}
+
+// writeFrameVectored writes a frame whose payload is larger than the
+// writer's buffer straight to the connection, sending the header and
+// payload with a single writev instead of copying the payload through the
+// buffer. Anything already buffered is flushed first.
+func (c *Conn[T]) writeFrameVectored(frame *Frame) error {
+	headerSize, err := putFrameHeader(c.writeBuf, frame)
+	if err != nil {
+		return err
+	}
+	if err := c.writer.Flush(); err != nil {
 		return err
 	}
+	bufs := net.Buffers{c.writeBuf[:headerSize], frame.Payload}
+	_, err = bufs.WriteTo(c.conn)
+	return err
+}
 
-	if len(frame.Payload) > 0 {
-		if _, err := w.Write(frame.Payload); err != nil {
+// writeMasked writes payload masked with key. The payload belongs to the
+// caller, so it is masked a chunk at a time in buf rather than in place.
+func writeMasked(w io.Writer, buf, payload, key []byte) error {
+	for pos := 0; pos < len(payload); {
+		n := copy(buf, payload[pos:])
+		maskBytesPos(buf[:n], key, pos)
+		if _, err := w.Write(buf[:n]); err != nil {
 			return err
 		}
+		pos += n
 	}
-
 	return nil
 }
 
-// maskBytes applies XOR masking to payload (RFC 6455 Section 5.3)
-func maskBytes(payload []byte, mask []byte) {
-	for i := range payload {
-		payload[i] ^= mask[i%4]
+// isControl reports whether the opcode denotes a control frame
+func isControl(opcode byte) bool {
+	return opcode >= opClose
+}
+
+// isProtocolError reports whether err is a frame-level protocol violation
+// that requires failing the connection with CloseProtocolError
+func isProtocolError(err error) bool {
+	return errors.Is(err, ErrInvalidFrame) || errors.Is(err, ErrUnsupportedFrameType) ||
+		errors.Is(err, ErrFragmentedControlFrame) || errors.Is(err, ErrControlFrameTooLarge)
+}
+
+// FrameError describes a frame or message that broke the protocol or a
+// size limit. It wraps the sentinel error naming the failure, such as
+// ErrFrameTooLarge, so errors.Is matches it.
+type FrameError struct {
+	Op     string // "read" or "write"
+	Opcode byte
+	Size   uint64 // Payload length seen, if known
+	Limit  int    // Size limit broken, if any
+	Err    error
+}
+
+// Error returns the error message
+func (e *FrameError) Error() string {
+	msg := fmt.Sprintf("%v: %s %s frame", e.Err, e.Op, opcodeName(e.Opcode))
+	if e.Size > 0 {
+		msg += fmt.Sprintf(" of %d bytes", e.Size)
+	}
+	if e.Limit > 0 {
+		msg += fmt.Sprintf(", limit %d", e.Limit)
+	}
+	return msg
+}
+
+// Unwrap returns the sentinel error
+func (e *FrameError) Unwrap() error {
+	return e.Err
+}
+
+// readError returns a FrameError for a frame that failed to be read
+func readError(err error, opcode byte, size uint64, limit int) *FrameError {
+	return &FrameError{Op: "read", Opcode: opcode, Size: size, Limit: limit, Err: err}
+}
+
+// writeError returns a FrameError for a frame that could not be written
+func writeError(err error, opcode byte, size int, limit int) *FrameError {
+	return &FrameError{Op: "write", Opcode: opcode, Size: uint64(size), Limit: limit, Err: err}
+}
+
+// opcodeName returns the name of a frame opcode
+func opcodeName(opcode byte) string {
+	switch opcode {
+	case opContinuation:
+		return "continuation"
+	case opText:
+		return "text"
+	case opBinary:
+		return "binary"
+	case opClose:
+		return "close"
+	case opPing:
+		return "ping"
+	case opPong:
+		return "pong"
+	default:
+		return fmt.Sprintf("0x%X", opcode)
 	}
 }
@@ -3,7 +3,9 @@ package axon_test
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"io"
+	"math"
 	"testing"
 
 	"github.com/kolosys/axon"
@@ -93,8 +95,7 @@ func TestReadFrame64BitLength(t *testing.T) {
 	frameData := make([]byte, 2+8+len(payload))
 	frameData[0] = 0x81 // FIN=1, opcode=1
 	frameData[1] = 0x7F // masked=0, extended length (127)
-	// First 4 bytes are 0 (we only support 32-bit)
-	binary.BigEndian.PutUint32(frameData[6:10], uint32(len(payload)))
+	binary.BigEndian.PutUint64(frameData[2:10], uint64(len(payload)))
 	copy(frameData[10:], payload)
 
 	buf := make([]byte, 4096)
@@ -108,6 +109,33 @@ func TestReadFrame64BitLength(t *testing.T) {
 	}
 }
 
+func TestReadFrame64BitLengthUpperBytes(t *testing.T) {
+	// Length above 4GB must be rejected by the size limit, not the parser
+	frameData := make([]byte, 2+8)
+	frameData[0] = 0x82 // FIN=1, opcode=2
+	frameData[1] = 0x7F
+	binary.BigEndian.PutUint64(frameData[2:10], 1<<33)
+
+	buf := make([]byte, 4096)
+	_, err := axon.ReadFrame(bytes.NewReader(frameData), buf, 4096)
+	if !errors.Is(err, axon.ErrFrameTooLarge) {
+		t.Errorf("expected ErrFrameTooLarge, got %v", err)
+	}
+}
+
+func TestReadFrame64BitLengthMSBSet(t *testing.T) {
+	frameData := make([]byte, 2+8)
+	frameData[0] = 0x82
+	frameData[1] = 0x7F
+	binary.BigEndian.PutUint64(frameData[2:10], 1<<63|5)
+
+	buf := make([]byte, 4096)
+	_, err := axon.ReadFrame(bytes.NewReader(frameData), buf, 1<<20)
+	if !errors.Is(err, axon.ErrInvalidFrame) {
+		t.Errorf("expected ErrInvalidFrame, got %v", err)
+	}
+}
+
 func TestReadFrameTooLarge(t *testing.T) {
 	payload := make([]byte, 5000)
 	frameData := make([]byte, 2+2+len(payload))
@@ -118,7 +146,7 @@ func TestReadFrameTooLarge(t *testing.T) {
 
 	buf := make([]byte, 4096)
 	_, err := axon.ReadFrame(bytes.NewReader(frameData), buf, 4096)
-	if err != axon.ErrFrameTooLarge {
+	if !errors.Is(err, axon.ErrFrameTooLarge) {
 		t.Errorf("expected ErrFrameTooLarge, got %v", err)
 	}
 }
@@ -129,7 +157,7 @@ func TestReadFrameInvalidRSV(t *testing.T) {
 	buf := make([]byte, 4096)
 
 	_, err := axon.ReadFrame(bytes.NewReader(frameData), buf, 4096)
-	if err != axon.ErrInvalidFrame {
+	if !errors.Is(err, axon.ErrInvalidFrame) {
 		t.Errorf("expected ErrInvalidFrame, got %v", err)
 	}
 }
@@ -140,7 +168,7 @@ func TestReadFrameInvalidOpcode(t *testing.T) {
 	buf := make([]byte, 4096)
 
 	_, err := axon.ReadFrame(bytes.NewReader(frameData), buf, 4096)
-	if err != axon.ErrUnsupportedFrameType {
+	if !errors.Is(err, axon.ErrUnsupportedFrameType) {
 		t.Errorf("expected ErrUnsupportedFrameType, got %v", err)
 	}
 }
@@ -151,7 +179,7 @@ func TestReadFrameFragmentedControl(t *testing.T) {
 	buf := make([]byte, 4096)
 
 	_, err := axon.ReadFrame(bytes.NewReader(frameData), buf, 4096)
-	if err != axon.ErrFragmentedControlFrame {
+	if !errors.Is(err, axon.ErrFragmentedControlFrame) {
 		t.Errorf("expected ErrFragmentedControlFrame, got %v", err)
 	}
 }
@@ -493,7 +521,155 @@ func TestReadFrame64BitLengthTooLarge(t *testing.T) {
 	buf := make([]byte, 4096)
 
 	_, err := axon.ReadFrame(bytes.NewReader(frameData), buf, 4096)
-	if err != axon.ErrFrameTooLarge {
+	if !errors.Is(err, axon.ErrFrameTooLarge) {
 		t.Errorf("expected ErrFrameTooLarge, got %v", err)
 	}
 }
+
+func TestReadFrameControlTooLarge(t *testing.T) {
+	// Ping frame declaring a 126-byte payload via 16-bit length
+	frameData := make([]byte, 4+126)
+	frameData[0] = 0x89 // FIN=1, opcode=9 (ping)
+	frameData[1] = 0x7E
+	binary.BigEndian.PutUint16(frameData[2:4], 126)
+	buf := make([]byte, 4096)
+
+	_, err := axon.ReadFrame(bytes.NewReader(frameData), buf, 4096)
+	if !errors.Is(err, axon.ErrControlFrameTooLarge) {
+		t.Errorf("expected ErrControlFrameTooLarge, got %v", err)
+	}
+}
+
+func TestWriteFrameControlTooLarge(t *testing.T) {
+	frame := &axon.Frame{
+		Fin:     true,
+		Opcode:  0x9,
+		Payload: make([]byte, 126),
+	}
+
+	var buf bytes.Buffer
+	writeBuf := make([]byte, 14)
+	if err := axon.WriteFrame(&buf, writeBuf, frame); !errors.Is(err, axon.ErrControlFrameTooLarge) {
+		t.Errorf("expected ErrControlFrameTooLarge, got %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expected nothing written, got %d bytes", buf.Len())
+	}
+}
+
+func TestReadFramePayloadUsesBuffer(t *testing.T) {
+	frameData := []byte{0x81, 0x05, 0x48, 0x65, 0x6c, 0x6c, 0x6f}
+	buf := make([]byte, 4096)
+	r := bytes.NewReader(frameData)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		r.Reset(frameData)
+		if _, err := axon.ReadFrame(r, buf, 4096); err != nil {
+			t.Fatalf("failed to read frame: %v", err)
+		}
+	})
+
+	// Only the Frame struct itself should be allocated
+	if allocs > 1 {
+		t.Errorf("expected at most 1 allocation per frame, got %.1f", allocs)
+	}
+}
+
+func TestReadFrameIntoReusesFrame(t *testing.T) {
+	masked := []byte{0x81, 0x85, 0x37, 0xfa, 0x21, 0x3d, 0x7f, 0x9f, 0x4d, 0x51, 0x58} // "Hello"
+	unmasked := []byte{0x82, 0x02, 0x01, 0x02}
+	buf := make([]byte, 4096)
+
+	var frame axon.Frame
+	if err := axon.ReadFrameInto(bytes.NewReader(masked), buf, 4096, 0, true, &frame); err != nil {
+		t.Fatalf("failed to read frame: %v", err)
+	}
+	if !frame.Masked || string(frame.Payload) != "Hello" {
+		t.Errorf("got masked %v payload %q, want masked payload %q", frame.Masked, frame.Payload, "Hello")
+	}
+
+	// Nothing of the previous frame is left behind
+	if err := axon.ReadFrameInto(bytes.NewReader(unmasked), buf, 4096, 0, true, &frame); err != nil {
+		t.Fatalf("failed to read frame: %v", err)
+	}
+	if frame.Masked || frame.MaskKey != nil || frame.Opcode != 0x2 || !bytes.Equal(frame.Payload, []byte{1, 2}) {
+		t.Errorf("got %+v, want an unmasked binary frame of 01 02", frame)
+	}
+
+	r := bytes.NewReader(unmasked)
+	allocs := testing.AllocsPerRun(100, func() {
+		r.Reset(unmasked)
+		if err := axon.ReadFrameInto(r, buf, 4096, 0, true, &frame); err != nil {
+			t.Fatalf("failed to read frame: %v", err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("expected no allocations per frame, got %.1f", allocs)
+	}
+}
+
+func TestReadFramePayloadExceedsBuffer(t *testing.T) {
+	payload := bytes.Repeat([]byte("x"), 200)
+	frameData := make([]byte, 2+2+len(payload))
+	frameData[0] = 0x82
+	frameData[1] = 0x7E
+	binary.BigEndian.PutUint16(frameData[2:4], uint16(len(payload)))
+	copy(frameData[4:], payload)
+
+	// Buffer only large enough for the header
+	buf := make([]byte, 14)
+	frame, err := axon.ReadFrame(bytes.NewReader(frameData), buf, 4096)
+	if err != nil {
+		t.Fatalf("failed to read frame: %v", err)
+	}
+	if !bytes.Equal(frame.Payload, payload) {
+		t.Error("payload mismatch for frame larger than buffer")
+	}
+}
+
+func TestReadFramePayloadFillsBuffer(t *testing.T) {
+	buf := make([]byte, 14+16)
+	for _, tt := range []struct {
+		size    int
+		aliased bool
+	}{
+		{16, true},
+		{17, false},
+	} {
+		frameData := append([]byte{0x82, byte(tt.size)}, bytes.Repeat([]byte("x"), tt.size)...)
+		frame, err := axon.ReadFrame(bytes.NewReader(frameData), buf, math.MaxInt)
+		if err != nil {
+			t.Fatalf("failed to read %d byte frame: %v", tt.size, err)
+		}
+		if len(frame.Payload) != tt.size {
+			t.Fatalf("expected %d byte payload, got %d", tt.size, len(frame.Payload))
+		}
+		if aliased := &frame.Payload[0] == &buf[14]; aliased != tt.aliased {
+			t.Errorf("%d byte payload: aliased buffer = %v, want %v", tt.size, aliased, tt.aliased)
+		}
+	}
+}
+
+func TestFrameError(t *testing.T) {
+	payload := make([]byte, 5000)
+	frameData := make([]byte, 4+len(payload))
+	frameData[0] = 0x82
+	frameData[1] = 0x7E
+	binary.BigEndian.PutUint16(frameData[2:4], uint16(len(payload)))
+	copy(frameData[4:], payload)
+
+	buf := make([]byte, 4096)
+	_, err := axon.ReadFrame(bytes.NewReader(frameData), buf, 4096)
+
+	var frameErr *axon.FrameError
+	if !errors.As(err, &frameErr) {
+		t.Fatalf("expected FrameError, got %v", err)
+	}
+	if frameErr.Op != "read" || frameErr.Opcode != 0x2 || frameErr.Size != 5000 || frameErr.Limit != 4096 {
+		t.Errorf("unexpected FrameError %+v", frameErr)
+	}
+	want := "axon: frame too large: read binary frame of 5000 bytes, limit 4096"
+	if err.Error() != want {
+		t.Errorf("expected %q, got %q", want, err.Error())
+	}
+}
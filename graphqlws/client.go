@@ -0,0 +1,321 @@
+package graphqlws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/kolosys/axon"
+)
+
+// ErrNotAcknowledged is returned by NewClient when the server answers
+// connection_init with anything but connection_ack
+var ErrNotAcknowledged = errors.New("graphqlws: connection not acknowledged")
+
+// Client runs operations on a graphql-transport-ws server. It is safe for
+// concurrent use.
+type Client struct {
+	conn *axon.Conn[Message]
+	ack  json.RawMessage
+
+	mu     sync.Mutex
+	nextID uint64
+	subs   map[string]*Subscription
+	pongs  []chan struct{}
+	err    error
+	done   chan struct{}
+}
+
+// Dial connects to a graphql-transport-ws server at url and initialises
+// the connection with initPayload. opts may be nil; its subprotocols are
+// replaced by Subprotocol.
+func Dial(ctx context.Context, url string, opts *axon.DialOptions, initPayload any) (*Client, error) {
+	var o axon.DialOptions
+	if opts != nil {
+		o = *opts
+	}
+	o.Subprotocols = []string{Subprotocol}
+
+	conn, err := axon.Dial[Message](ctx, url, &o)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewClient(ctx, conn, initPayload)
+	if err != nil {
+		conn.Close(int(axon.CloseNormalClosure), "")
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewClient initialises the connection with initPayload, which may be
+// nil, and waits for the server's acknowledgement. The client then owns
+// reading from the connection.
+func NewClient(ctx context.Context, conn *axon.Conn[Message], initPayload any) (*Client, error) {
+	init := Message{Type: TypeConnectionInit}
+	if initPayload != nil {
+		payload, err := json.Marshal(initPayload)
+		if err != nil {
+			return nil, err
+		}
+		init.Payload = payload
+	}
+	if err := conn.Write(ctx, init); err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		conn: conn,
+		subs: make(map[string]*Subscription),
+		done: make(chan struct{}),
+	}
+	for {
+		msg, err := conn.Read(ctx)
+		if err != nil {
+			return nil, err
+		}
+		switch msg.Type {
+		case TypePing:
+			if err := conn.Write(ctx, Message{Type: TypePong}); err != nil {
+				return nil, err
+			}
+			continue
+		case TypeConnectionAck:
+			c.ack = msg.Payload
+			go c.readLoop()
+			return c, nil
+		}
+		return nil, ErrNotAcknowledged
+	}
+}
+
+// AckPayload returns the payload of the server's connection_ack
+func (c *Client) AckPayload() json.RawMessage {
+	return c.ack
+}
+
+// Done returns a channel that is closed when the connection ends
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the error that ended the connection, or nil while it is open
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Close closes the connection, ending all subscriptions
+func (c *Client) Close() error {
+	return c.conn.Close(int(axon.CloseNormalClosure), "")
+}
+
+// Ping sends a ping and waits for the server's pong
+func (c *Client) Ping(ctx context.Context) error {
+	pong := make(chan struct{})
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	c.pongs = append(c.pongs, pong)
+	c.mu.Unlock()
+
+	if err := c.conn.Write(ctx, Message{Type: TypePing}); err != nil {
+		return err
+	}
+	select {
+	case <-pong:
+		return nil
+	case <-c.done:
+		return c.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Subscription is a running operation
+type Subscription struct {
+	id      string
+	client  *Client
+	results chan *ExecutionResult
+
+	once sync.Once
+	mu   sync.Mutex // Held while delivering, so results closes after
+	err  error
+	done chan struct{}
+}
+
+// Subscribe starts an operation. Its results must be received from the
+// subscription promptly, as the client reads no further messages while a
+// result waits to be delivered.
+func (c *Client) Subscribe(ctx context.Context, op *SubscribePayload) (*Subscription, error) {
+	payload, err := json.Marshal(op)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	c.nextID++
+	sub := &Subscription{
+		id:      strconv.FormatUint(c.nextID, 10),
+		client:  c,
+		results: make(chan *ExecutionResult, 16),
+		done:    make(chan struct{}),
+	}
+	c.subs[sub.id] = sub
+	c.mu.Unlock()
+
+	if err := c.conn.Write(ctx, Message{ID: sub.id, Type: TypeSubscribe, Payload: payload}); err != nil {
+		c.remove(sub.id)
+		return nil, err
+	}
+	return sub, nil
+}
+
+// ID returns the operation ID
+func (s *Subscription) ID() string {
+	return s.id
+}
+
+// Results returns the channel of results, closed when the operation ends
+func (s *Subscription) Results() <-chan *ExecutionResult {
+	return s.results
+}
+
+// Err returns why the operation ended once Results is closed: nil when it
+// completed, Errors when the server failed it, or the connection's error
+func (s *Subscription) Err() error {
+	<-s.done
+	return s.err
+}
+
+// Close completes the operation, telling the server to stop it
+func (s *Subscription) Close(ctx context.Context) error {
+	if !s.client.remove(s.id) {
+		return nil
+	}
+	s.end(nil)
+	return s.client.conn.Write(ctx, Message{ID: s.id, Type: TypeComplete})
+}
+
+// deliver waits for result to be received, unless the subscription ends
+func (s *Subscription) deliver(result *ExecutionResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	select {
+	case s.results <- result:
+	case <-s.done:
+	}
+}
+
+// end ends the subscription with err
+func (s *Subscription) end(err error) {
+	s.once.Do(func() {
+		s.err = err
+		close(s.done)
+		s.mu.Lock()
+		close(s.results)
+		s.mu.Unlock()
+	})
+}
+
+// remove forgets a subscription, reporting whether it was running
+func (c *Client) remove(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.subs[id]; !ok {
+		return false
+	}
+	delete(c.subs, id)
+	return true
+}
+
+// readLoop delivers messages until the connection ends
+func (c *Client) readLoop() {
+	for {
+		msg, err := c.conn.Read(context.Background())
+		if err != nil {
+			// Idle connections hit the default read deadline
+			if isTimeout(err) {
+				continue
+			}
+			c.stop(err)
+			return
+		}
+		c.dispatch(msg)
+	}
+}
+
+// dispatch handles a message from the server
+func (c *Client) dispatch(msg Message) {
+	switch msg.Type {
+	case TypePing:
+		c.conn.Write(context.Background(), Message{Type: TypePong})
+		return
+	case TypePong:
+		c.mu.Lock()
+		pongs := c.pongs
+		c.pongs = nil
+		c.mu.Unlock()
+		for _, pong := range pongs {
+			close(pong)
+		}
+		return
+	}
+
+	c.mu.Lock()
+	sub := c.subs[msg.ID]
+	c.mu.Unlock()
+	if sub == nil {
+		return
+	}
+
+	switch msg.Type {
+	case TypeNext:
+		var result ExecutionResult
+		if err := json.Unmarshal(msg.Payload, &result); err != nil {
+			return
+		}
+		sub.deliver(&result)
+	case TypeError:
+		var errs Errors
+		if json.Unmarshal(msg.Payload, &errs) != nil || len(errs) == 0 {
+			errs = Errors{{Message: "operation failed"}}
+		}
+		if c.remove(msg.ID) {
+			sub.end(errs)
+		}
+	case TypeComplete:
+		if c.remove(msg.ID) {
+			sub.end(nil)
+		}
+	}
+}
+
+// stop ends the client and all subscriptions with err
+func (c *Client) stop(err error) {
+	c.mu.Lock()
+	c.err = err
+	subs := c.subs
+	c.subs = make(map[string]*Subscription)
+	c.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.end(err)
+	}
+	close(c.done)
+}
@@ -0,0 +1,134 @@
+package graphqlws_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon/graphqlws"
+)
+
+func dialClient(t *testing.T, url string) (*graphqlws.Client, context.Context) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	client, err := graphqlws.Dial(ctx, url, nil, map[string]string{"token": "secret"})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client, ctx
+}
+
+func TestClient_Subscribe(t *testing.T) {
+	url := newServer(t, &graphqlws.Server{
+		OnConnect: func(ctx context.Context, payload json.RawMessage) (any, error) {
+			return json.RawMessage(payload), nil
+		},
+	})
+	client, ctx := dialClient(t, url)
+
+	if got := string(client.AckPayload()); got != `{"token":"secret"}` {
+		t.Errorf("AckPayload() = %s", got)
+	}
+	if err := client.Ping(ctx); err != nil {
+		t.Fatalf("Ping() error = %v", err)
+	}
+
+	sub, err := client.Subscribe(ctx, &graphqlws.SubscribePayload{
+		Query:     "subscription { n }",
+		Variables: map[string]any{"count": 3},
+	})
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	var got []string
+	for result := range sub.Results() {
+		got = append(got, string(result.Data))
+	}
+	if err := sub.Err(); err != nil {
+		t.Fatalf("subscription Err() = %v", err)
+	}
+	if len(got) != 3 || got[0] != `{"n":1}` || got[2] != `{"n":3}` {
+		t.Errorf("results = %v", got)
+	}
+}
+
+func TestClient_SubscribeError(t *testing.T) {
+	client, ctx := dialClient(t, newServer(t, &graphqlws.Server{}))
+
+	sub, err := client.Subscribe(ctx, &graphqlws.SubscribePayload{Query: "{ fail }"})
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	for range sub.Results() {
+		t.Error("received a result from a failed operation")
+	}
+	var errs graphqlws.Errors
+	if !errors.As(sub.Err(), &errs) || len(errs) != 1 || errs[0].Message != "cannot query field fail" {
+		t.Errorf("subscription Err() = %v, want the server's errors", sub.Err())
+	}
+}
+
+func TestClient_CloseSubscription(t *testing.T) {
+	stopped := make(chan struct{})
+	client, ctx := dialClient(t, newServer(t, &graphqlws.Server{
+		Execute: func(ctx context.Context, op *graphqlws.SubscribePayload) (<-chan *graphqlws.ExecutionResult, error) {
+			results := make(chan *graphqlws.ExecutionResult)
+			go func() {
+				defer close(stopped)
+				for {
+					select {
+					case results <- &graphqlws.ExecutionResult{Data: json.RawMessage(`{}`)}:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}()
+			return results, nil
+		},
+	}))
+
+	sub, err := client.Subscribe(ctx, &graphqlws.SubscribePayload{Query: "subscription { tick }"})
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	<-sub.Results()
+	if err := sub.Close(ctx); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		t.Fatal("server did not stop the completed operation")
+	}
+	for range sub.Results() {
+	}
+	if err := sub.Err(); err != nil {
+		t.Errorf("subscription Err() = %v, want nil", err)
+	}
+}
+
+func TestClient_ConnectionLost(t *testing.T) {
+	client, ctx := dialClient(t, newServer(t, &graphqlws.Server{
+		Execute: func(ctx context.Context, op *graphqlws.SubscribePayload) (<-chan *graphqlws.ExecutionResult, error) {
+			return make(chan *graphqlws.ExecutionResult), nil
+		},
+	}))
+
+	sub, err := client.Subscribe(ctx, &graphqlws.SubscribePayload{Query: "subscription { n }"})
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	client.Close()
+
+	if err := sub.Err(); err == nil {
+		t.Error("subscription Err() = nil after the connection closed")
+	}
+	<-client.Done()
+	if _, err := client.Subscribe(ctx, &graphqlws.SubscribePayload{Query: "{ n }"}); err == nil {
+		t.Error("Subscribe() on a closed client succeeded")
+	}
+}
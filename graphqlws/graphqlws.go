@@ -0,0 +1,92 @@
+// Package graphqlws implements the graphql-transport-ws subprotocol, which
+// carries GraphQL operations, subscriptions in particular, over axon
+// connections. The Server runs operations with an ExecuteFunc provided by
+// a GraphQL engine; the Client subscribes to them.
+package graphqlws
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+)
+
+// Subprotocol is the WebSocket subprotocol negotiated by client and server
+const Subprotocol = "graphql-transport-ws"
+
+// Message types
+const (
+	TypeConnectionInit = "connection_init"
+	TypeConnectionAck  = "connection_ack"
+	TypePing           = "ping"
+	TypePong           = "pong"
+	TypeSubscribe      = "subscribe"
+	TypeNext           = "next"
+	TypeError          = "error"
+	TypeComplete       = "complete"
+)
+
+// Close codes defined by the protocol
+const (
+	CloseBadRequest          = 4400
+	CloseUnauthorized        = 4401
+	CloseForbidden           = 4403
+	CloseInitTimeout         = 4408
+	CloseSubscriberExists    = 4409
+	CloseTooManyInitRequests = 4429
+)
+
+// Message is a protocol message
+type Message struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// SubscribePayload is the GraphQL operation of a subscribe message
+type SubscribePayload struct {
+	OperationName string         `json:"operationName,omitempty"`
+	Query         string         `json:"query"`
+	Variables     map[string]any `json:"variables,omitempty"`
+	Extensions    map[string]any `json:"extensions,omitempty"`
+}
+
+// ExecutionResult is a result of an operation, sent in a next message
+type ExecutionResult struct {
+	Data       json.RawMessage `json:"data,omitempty"`
+	Errors     Errors          `json:"errors,omitempty"`
+	Extensions map[string]any  `json:"extensions,omitempty"`
+}
+
+// Location is a position in a GraphQL document
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// GraphQLError is an error of a GraphQL operation
+type GraphQLError struct {
+	Message    string         `json:"message"`
+	Locations  []Location     `json:"locations,omitempty"`
+	Path       []any          `json:"path,omitempty"`
+	Extensions map[string]any `json:"extensions,omitempty"`
+}
+
+// Errors are the errors of an operation that failed before producing any
+// result, as sent in an error message
+type Errors []GraphQLError
+
+// Error returns the messages of the errors
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Message
+	}
+	return "graphqlws: " + strings.Join(msgs, "; ")
+}
+
+// isTimeout reports whether err is a read that hit its deadline
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
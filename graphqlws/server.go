@@ -0,0 +1,248 @@
+package graphqlws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+// defaultInitTimeout is how long a client has to initialise the connection
+// when Server.InitTimeout is unset
+const defaultInitTimeout = 3 * time.Second
+
+// ExecuteFunc runs an operation, sending its results on the returned
+// channel and closing it when the operation completes. Queries and
+// mutations send a single result; subscriptions send one per event until
+// ctx is done. An error fails the operation before any result: Errors are
+// sent as they are, other errors as a single GraphQLError.
+type ExecuteFunc func(ctx context.Context, op *SubscribePayload) (<-chan *ExecutionResult, error)
+
+// Server serves the graphql-transport-ws protocol
+type Server struct {
+	// Execute runs the operations clients subscribe to
+	Execute ExecuteFunc
+
+	// OnConnect is called with the payload of connection_init and returns
+	// the payload of connection_ack. An error closes the connection with
+	// CloseForbidden. If nil, every client is accepted.
+	OnConnect func(ctx context.Context, payload json.RawMessage) (any, error)
+
+	// InitTimeout is how long a client has to send connection_init before
+	// the connection is closed with CloseInitTimeout.
+	// Default is 3s.
+	InitTimeout time.Duration
+
+	// UpgradeOptions configures connections upgraded by ServeHTTP. Its
+	// subprotocols are replaced by Subprotocol.
+	UpgradeOptions *axon.UpgradeOptions
+}
+
+// ServeHTTP upgrades the request and serves the connection until it closes
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var opts axon.UpgradeOptions
+	if s.UpgradeOptions != nil {
+		opts = *s.UpgradeOptions
+	}
+	opts.Subprotocols = []string{Subprotocol}
+
+	conn, err := axon.Upgrade[Message](w, r, &opts)
+	if err != nil {
+		return
+	}
+	defer conn.Close(int(axon.CloseNormalClosure), "")
+	s.ServeConn(r.Context(), conn)
+}
+
+// ServeConn serves the protocol over conn until the connection closes or
+// ctx is done, returning the error that stopped it. Protocol violations
+// close the connection with the code the protocol assigns them.
+func (s *Server) ServeConn(ctx context.Context, conn *axon.Conn[Message]) error {
+	ctx, cancel := context.WithCancel(ctx)
+	sess := &session{
+		server: s,
+		conn:   conn,
+		ctx:    ctx,
+		subs:   make(map[string]context.CancelFunc),
+	}
+	defer func() {
+		cancel()
+		sess.wg.Wait()
+	}()
+
+	if err := sess.init(); err != nil {
+		return err
+	}
+
+	for {
+		msg, err := conn.Read(ctx)
+		if err != nil {
+			// Idle connections hit the default read deadline
+			if isTimeout(err) && ctx.Err() == nil {
+				continue
+			}
+			return err
+		}
+		if err := sess.handle(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// session is the state of a connection served by a Server
+type session struct {
+	server *Server
+	conn   *axon.Conn[Message]
+	ctx    context.Context
+	wg     sync.WaitGroup
+
+	mu   sync.Mutex
+	subs map[string]context.CancelFunc
+}
+
+// init waits for connection_init and acknowledges it. Pings may arrive
+// before it.
+func (s *session) init() error {
+	timeout := s.server.InitTimeout
+	if timeout <= 0 {
+		timeout = defaultInitTimeout
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, timeout)
+	defer cancel()
+
+	for {
+		msg, err := s.conn.Read(ctx)
+		if err != nil {
+			if (ctx.Err() != nil || isTimeout(err)) && s.ctx.Err() == nil {
+				return s.close(CloseInitTimeout, "Connection initialisation timeout")
+			}
+			return err
+		}
+
+		switch msg.Type {
+		case TypePing:
+			if err := s.write(Message{Type: TypePong}); err != nil {
+				return err
+			}
+		case TypePong:
+		case TypeConnectionInit:
+			var ack any
+			if s.server.OnConnect != nil {
+				ack, err = s.server.OnConnect(s.ctx, msg.Payload)
+				if err != nil {
+					return s.close(CloseForbidden, "Forbidden")
+				}
+			}
+			reply := Message{Type: TypeConnectionAck}
+			if ack != nil {
+				if reply.Payload, err = json.Marshal(ack); err != nil {
+					return s.close(int(axon.CloseInternalError), "")
+				}
+			}
+			return s.write(reply)
+		case TypeSubscribe:
+			return s.close(CloseUnauthorized, "Unauthorized")
+		default:
+			return s.close(CloseBadRequest, "Invalid message received")
+		}
+	}
+}
+
+// handle handles a message received after the connection was initialised
+func (s *session) handle(msg Message) error {
+	switch msg.Type {
+	case TypePing:
+		return s.write(Message{Type: TypePong})
+	case TypePong:
+		return nil
+	case TypeConnectionInit:
+		return s.close(CloseTooManyInitRequests, "Too many initialisation requests")
+	case TypeSubscribe:
+		var op SubscribePayload
+		if msg.ID == "" || json.Unmarshal(msg.Payload, &op) != nil || op.Query == "" {
+			return s.close(CloseBadRequest, "Invalid message received")
+		}
+		return s.subscribe(msg.ID, &op)
+	case TypeComplete:
+		s.mu.Lock()
+		cancel := s.subs[msg.ID]
+		s.mu.Unlock()
+		if cancel != nil {
+			cancel()
+		}
+		return nil
+	}
+	return s.close(CloseBadRequest, "Invalid message received")
+}
+
+// subscribe starts an operation, streaming its results until it completes
+// or the client completes it
+func (s *session) subscribe(id string, op *SubscribePayload) error {
+	s.mu.Lock()
+	if _, ok := s.subs[id]; ok {
+		s.mu.Unlock()
+		return s.close(CloseSubscriberExists, "Subscriber for "+id+" already exists")
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.subs[id] = cancel
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer func() {
+			cancel()
+			s.mu.Lock()
+			delete(s.subs, id)
+			s.mu.Unlock()
+		}()
+
+		results, err := s.server.Execute(ctx, op)
+		if err != nil {
+			var errs Errors
+			if !errors.As(err, &errs) {
+				errs = Errors{{Message: err.Error()}}
+			}
+			payload, _ := json.Marshal(errs)
+			s.write(Message{ID: id, Type: TypeError, Payload: payload})
+			return
+		}
+
+		for {
+			select {
+			case result, ok := <-results:
+				if !ok {
+					s.write(Message{ID: id, Type: TypeComplete})
+					return
+				}
+				payload, err := json.Marshal(result)
+				if err != nil {
+					continue
+				}
+				if s.write(Message{ID: id, Type: TypeNext, Payload: payload}) != nil {
+					return
+				}
+			case <-ctx.Done():
+				// Completed by the client, which expects no complete
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// write sends a message to the client
+func (s *session) write(msg Message) error {
+	return s.conn.Write(s.ctx, msg)
+}
+
+// close closes the connection with a protocol close code, returning the
+// error ServeConn stops with
+func (s *session) close(code int, reason string) error {
+	s.conn.Close(code, reason)
+	return &axon.CloseError{Code: axon.CloseCode(code), Reason: reason}
+}
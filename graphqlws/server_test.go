@@ -0,0 +1,176 @@
+package graphqlws_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+	"github.com/kolosys/axon/graphqlws"
+)
+
+// execute counts to the "count" variable for subscriptions, and fails
+// queries for "fail"
+func execute(ctx context.Context, op *graphqlws.SubscribePayload) (<-chan *graphqlws.ExecutionResult, error) {
+	if strings.Contains(op.Query, "fail") {
+		return nil, graphqlws.Errors{{Message: "cannot query field fail"}}
+	}
+	count, _ := op.Variables["count"].(float64)
+	results := make(chan *graphqlws.ExecutionResult)
+	go func() {
+		defer close(results)
+		for i := 1; i <= int(count); i++ {
+			select {
+			case results <- &graphqlws.ExecutionResult{Data: json.RawMessage(`{"n":` + string(rune('0'+i)) + `}`)}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return results, nil
+}
+
+// newServer serves a graphqlws.Server, returning its URL
+func newServer(t *testing.T, s *graphqlws.Server) string {
+	t.Helper()
+	if s.Execute == nil {
+		s.Execute = execute
+	}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// dialRaw connects to url without initialising the protocol
+func dialRaw(t *testing.T, url string) (*axon.Conn[graphqlws.Message], context.Context) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	conn, err := axon.Dial[graphqlws.Message](ctx, url, &axon.DialOptions{Subprotocols: []string{graphqlws.Subprotocol}})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { conn.Close(1000, "") })
+	return conn, ctx
+}
+
+// expectClose reads from conn until it closes, checking the close code
+func expectClose(t *testing.T, ctx context.Context, conn *axon.Conn[graphqlws.Message], code int) {
+	t.Helper()
+	for {
+		_, err := conn.Read(ctx)
+		if err == nil {
+			continue
+		}
+		var closeErr *axon.CloseError
+		if !errors.As(err, &closeErr) || int(closeErr.Code) != code {
+			t.Fatalf("Read() error = %v, want close %d", err, code)
+		}
+		return
+	}
+}
+
+func TestServer_Protocol(t *testing.T) {
+	url := newServer(t, &graphqlws.Server{})
+	conn, ctx := dialRaw(t, url)
+
+	if got := conn.Subprotocol(); got != graphqlws.Subprotocol {
+		t.Errorf("Subprotocol() = %q, want %q", got, graphqlws.Subprotocol)
+	}
+
+	steps := []struct {
+		send graphqlws.Message
+		want []graphqlws.Message
+	}{
+		{graphqlws.Message{Type: graphqlws.TypePing}, []graphqlws.Message{{Type: graphqlws.TypePong}}},
+		{graphqlws.Message{Type: graphqlws.TypeConnectionInit}, []graphqlws.Message{{Type: graphqlws.TypeConnectionAck}}},
+		{graphqlws.Message{ID: "a", Type: graphqlws.TypeSubscribe, Payload: json.RawMessage(`{"query":"subscription { n }","variables":{"count":2}}`)},
+			[]graphqlws.Message{
+				{ID: "a", Type: graphqlws.TypeNext, Payload: json.RawMessage(`{"data":{"n":1}}`)},
+				{ID: "a", Type: graphqlws.TypeNext, Payload: json.RawMessage(`{"data":{"n":2}}`)},
+				{ID: "a", Type: graphqlws.TypeComplete},
+			}},
+		{graphqlws.Message{ID: "b", Type: graphqlws.TypeSubscribe, Payload: json.RawMessage(`{"query":"{ fail }"}`)},
+			[]graphqlws.Message{{ID: "b", Type: graphqlws.TypeError, Payload: json.RawMessage(`[{"message":"cannot query field fail"}]`)}}},
+	}
+	for _, step := range steps {
+		if err := conn.Write(ctx, step.send); err != nil {
+			t.Fatalf("Write(%s) error = %v", step.send.Type, err)
+		}
+		for _, want := range step.want {
+			got, err := conn.Read(ctx)
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			if got.ID != want.ID || got.Type != want.Type || string(got.Payload) != string(want.Payload) {
+				t.Fatalf("after %s got %+v, want %+v", step.send.Type, got, want)
+			}
+		}
+	}
+}
+
+func TestServer_Violations(t *testing.T) {
+	subscribe := graphqlws.Message{ID: "1", Type: graphqlws.TypeSubscribe, Payload: json.RawMessage(`{"query":"subscription { n }","variables":{"count":100}}`)}
+	init := graphqlws.Message{Type: graphqlws.TypeConnectionInit}
+
+	tests := []struct {
+		name string
+		send []graphqlws.Message
+		code int
+	}{
+		{"subscribe before init", []graphqlws.Message{subscribe}, graphqlws.CloseUnauthorized},
+		{"second init", []graphqlws.Message{init, init}, graphqlws.CloseTooManyInitRequests},
+		{"unknown type", []graphqlws.Message{init, {Type: "start"}}, graphqlws.CloseBadRequest},
+		{"subscribe without query", []graphqlws.Message{init, {ID: "1", Type: graphqlws.TypeSubscribe, Payload: json.RawMessage(`{}`)}}, graphqlws.CloseBadRequest},
+		{"duplicate id", []graphqlws.Message{init, subscribe, subscribe}, graphqlws.CloseSubscriberExists},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url := newServer(t, &graphqlws.Server{Execute: func(ctx context.Context, op *graphqlws.SubscribePayload) (<-chan *graphqlws.ExecutionResult, error) {
+				// Never completes, so the duplicate ID is still running
+				return make(chan *graphqlws.ExecutionResult), nil
+			}})
+			conn, ctx := dialRaw(t, url)
+			for _, msg := range tt.send {
+				if err := conn.Write(ctx, msg); err != nil {
+					t.Fatalf("Write() error = %v", err)
+				}
+			}
+			expectClose(t, ctx, conn, tt.code)
+		})
+	}
+}
+
+func TestServer_InitTimeout(t *testing.T) {
+	url := newServer(t, &graphqlws.Server{InitTimeout: 50 * time.Millisecond})
+	conn, ctx := dialRaw(t, url)
+	expectClose(t, ctx, conn, graphqlws.CloseInitTimeout)
+}
+
+func TestServer_OnConnect(t *testing.T) {
+	url := newServer(t, &graphqlws.Server{
+		OnConnect: func(ctx context.Context, payload json.RawMessage) (any, error) {
+			var auth struct{ Token string }
+			json.Unmarshal(payload, &auth)
+			if auth.Token != "secret" {
+				return nil, errors.New("bad token")
+			}
+			return map[string]string{"user": "ada"}, nil
+		},
+	})
+
+	conn, ctx := dialRaw(t, url)
+	conn.Write(ctx, graphqlws.Message{Type: graphqlws.TypeConnectionInit, Payload: json.RawMessage(`{"token":"guess"}`)})
+	expectClose(t, ctx, conn, graphqlws.CloseForbidden)
+
+	conn, ctx = dialRaw(t, url)
+	conn.Write(ctx, graphqlws.Message{Type: graphqlws.TypeConnectionInit, Payload: json.RawMessage(`{"token":"secret"}`)})
+	msg, err := conn.Read(ctx)
+	if err != nil || msg.Type != graphqlws.TypeConnectionAck || string(msg.Payload) != `{"user":"ada"}` {
+		t.Fatalf("Read() = %+v, %v; want an ack with the user", msg, err)
+	}
+}
@@ -0,0 +1,149 @@
+package axon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// HandlerFunc runs an upgraded connection. The connection is closed when it
+// returns, so it should not retain the connection.
+type HandlerFunc[T any] func(ctx context.Context, conn *Conn[T])
+
+// Callbacks drives an upgraded connection through per-event callbacks.
+// Nil callbacks are skipped.
+type Callbacks[T any] struct {
+	// OnConnect is called once after the upgrade. Returning an error closes
+	// the connection with CloseInternalError.
+	OnConnect func(ctx context.Context, conn *Conn[T]) error
+
+	// OnMessage is called for each message read. Returning an error closes
+	// the connection with CloseInternalError.
+	OnMessage func(ctx context.Context, conn *Conn[T], msg T) error
+
+	// OnClose is called once the connection is done with the error that
+	// ended it, which is a *CloseError if the peer closed the connection.
+	OnClose func(conn *Conn[T], err error)
+
+	// OnError is called when an upgrade fails or a callback panics.
+	// conn is nil for upgrade failures.
+	OnError func(conn *Conn[T], err error)
+}
+
+// Handler returns an http.Handler that upgrades each request and runs fn.
+// Failed upgrades are answered with an HTTP error status. A panic in fn is
+// recovered and the connection is closed with CloseInternalError.
+func Handler[T any](opts *UpgradeOptions, fn HandlerFunc[T]) http.Handler {
+	return &handler[T]{
+		upgrader: NewUpgrader(opts),
+		serve:    fn,
+	}
+}
+
+// CallbackHandler returns an http.Handler that upgrades each request and
+// runs a read loop that dispatches to cb
+func CallbackHandler[T any](opts *UpgradeOptions, cb Callbacks[T]) http.Handler {
+	return &handler[T]{
+		upgrader: NewUpgrader(opts),
+		serve:    cb.serve,
+		onError:  cb.OnError,
+	}
+}
+
+// handler implements http.Handler for Handler and CallbackHandler
+type handler[T any] struct {
+	upgrader *Upgrader
+	serve    HandlerFunc[T]
+	onError  func(conn *Conn[T], err error)
+}
+
+// ServeHTTP upgrades the request and runs the connection to completion
+func (h *handler[T]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.upgrader.fallback != nil && r.Header.Get(FallbackHeader) != "" {
+		h.serveFallback(w, r)
+		return
+	}
+
+	conn, err := upgrade[T](h.upgrader, w, r)
+	if err != nil {
+		if h.onError != nil {
+			h.onError(nil, err)
+		}
+		return
+	}
+
+	runConn(conn.Context(), conn, h.serve, h.onError)
+}
+
+// runConn runs serve on an upgraded connection and closes it afterwards,
+// recovering any panic and reporting it to onError
+func runConn[T any](ctx context.Context, conn *Conn[T], serve HandlerFunc[T], onError func(*Conn[T], error)) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	defer func() {
+		if p := recover(); p != nil {
+			conn.Close(int(CloseInternalError), "")
+			if onError != nil {
+				onError(conn, fmt.Errorf("axon: handler panic: %v", p))
+			}
+		}
+		conn.Close(int(CloseNormalClosure), "")
+	}()
+
+	serve(ctx, conn)
+}
+
+// serve runs the read loop for a CallbackHandler
+func (cb Callbacks[T]) serve(ctx context.Context, conn *Conn[T]) {
+	var err error
+	if cb.OnClose != nil {
+		defer func() { cb.OnClose(conn, err) }()
+	}
+
+	if cb.OnConnect != nil {
+		if err = cb.OnConnect(ctx, conn); err != nil {
+			conn.Close(int(CloseInternalError), "")
+			return
+		}
+	}
+
+	for {
+		var msg T
+		if msg, err = conn.Read(ctx); err != nil {
+			return
+		}
+		if cb.OnMessage != nil {
+			if err = cb.OnMessage(ctx, conn, msg); err != nil {
+				conn.Close(int(CloseInternalError), "")
+				return
+			}
+		}
+	}
+}
+
+// UpgradeErrorStatus maps an error returned by Upgrade to the HTTP status
+// the request is answered with, or 0 if the connection was already
+// hijacked and no response can be written
+func UpgradeErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrUpgradeRequired):
+		return http.StatusUpgradeRequired
+	case errors.Is(err, ErrInvalidOrigin), errors.Is(err, ErrBanned):
+		return http.StatusForbidden
+	case errors.Is(err, ErrInvalidHandshake), errors.Is(err, ErrInvalidSubprotocol):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrHeaderTooLarge):
+		return http.StatusRequestHeaderFieldsTooLarge
+	case errors.Is(err, ErrTooManyConnections):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, ErrRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrUpgradeRejected):
+		return http.StatusForbidden
+	}
+	return 0
+}
@@ -0,0 +1,168 @@
+package axon_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+// dialHandler dials a test server serving h
+func dialHandler(t *testing.T, h http.Handler) (*axon.Conn[string], func()) {
+	t.Helper()
+
+	server := httptest.NewServer(h)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := axon.Dial[string](ctx, "ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		server.Close()
+		t.Fatalf("Dial() error = %v", err)
+	}
+
+	return conn, func() {
+		conn.Close(1000, "")
+		server.Close()
+	}
+}
+
+func TestHandler_Echo(t *testing.T) {
+	conn, cleanup := dialHandler(t, axon.Handler[string](nil, func(ctx context.Context, conn *axon.Conn[string]) {
+		for {
+			msg, err := conn.Read(ctx)
+			if err != nil {
+				return
+			}
+			if err := conn.Write(ctx, msg); err != nil {
+				return
+			}
+		}
+	}))
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := conn.Write(ctx, "ping"); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	got, err := conn.Read(ctx)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if got != "ping" {
+		t.Errorf("expected %q, got %q", "ping", got)
+	}
+}
+
+func TestHandler_ClosesOnReturn(t *testing.T) {
+	conn, cleanup := dialHandler(t, axon.Handler[string](nil, func(ctx context.Context, conn *axon.Conn[string]) {}))
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var closeErr *axon.CloseError
+	if _, err := conn.Read(ctx); !errors.As(err, &closeErr) || closeErr.Code != axon.CloseNormalClosure {
+		t.Errorf("expected normal closure, got %v", err)
+	}
+}
+
+func TestHandler_RecoversPanic(t *testing.T) {
+	conn, cleanup := dialHandler(t, axon.Handler[string](nil, func(ctx context.Context, conn *axon.Conn[string]) {
+		panic("boom")
+	}))
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var closeErr *axon.CloseError
+	if _, err := conn.Read(ctx); !errors.As(err, &closeErr) || closeErr.Code != axon.CloseInternalError {
+		t.Errorf("expected internal error closure, got %v", err)
+	}
+}
+
+func TestHandler_UpgradeFailure(t *testing.T) {
+	var gotErr error
+	h := axon.CallbackHandler(nil, axon.Callbacks[string]{
+		OnError: func(conn *axon.Conn[string], err error) {
+			gotErr = err
+		},
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUpgradeRequired {
+		t.Errorf("expected status %d, got %d", http.StatusUpgradeRequired, w.Code)
+	}
+	if gotErr != axon.ErrUpgradeRequired {
+		t.Errorf("expected ErrUpgradeRequired, got %v", gotErr)
+	}
+}
+
+func TestCallbackHandler_Lifecycle(t *testing.T) {
+	events := make(chan string, 10)
+	closed := make(chan error, 1)
+
+	conn, cleanup := dialHandler(t, axon.CallbackHandler(nil, axon.Callbacks[string]{
+		OnConnect: func(ctx context.Context, conn *axon.Conn[string]) error {
+			events <- "connect"
+			return conn.Write(ctx, "welcome")
+		},
+		OnMessage: func(ctx context.Context, conn *axon.Conn[string], msg string) error {
+			events <- "message:" + msg
+			if msg == "fail" {
+				return errors.New("handler failed")
+			}
+			return conn.Write(ctx, strings.ToUpper(msg))
+		},
+		OnClose: func(conn *axon.Conn[string], err error) {
+			closed <- err
+		},
+	}))
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if got, err := conn.Read(ctx); err != nil || got != "welcome" {
+		t.Fatalf("expected welcome, got %q (err=%v)", got, err)
+	}
+
+	conn.Write(ctx, "hello")
+	if got, err := conn.Read(ctx); err != nil || got != "HELLO" {
+		t.Fatalf("expected HELLO, got %q (err=%v)", got, err)
+	}
+
+	conn.Write(ctx, "fail")
+	var closeErr *axon.CloseError
+	if _, err := conn.Read(ctx); !errors.As(err, &closeErr) || closeErr.Code != axon.CloseInternalError {
+		t.Errorf("expected internal error closure, got %v", err)
+	}
+
+	select {
+	case err := <-closed:
+		if err == nil || err.Error() != "handler failed" {
+			t.Errorf("expected OnClose with handler error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for OnClose")
+	}
+
+	want := []string{"connect", "message:hello", "message:fail"}
+	for _, w := range want {
+		if got := <-events; got != w {
+			t.Errorf("expected event %q, got %q", w, got)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package axon
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+)
+
+// defaultFallbackDelay is the head start each connection attempt gets
+// before the next one starts, as recommended by RFC 8305 Section 8
+const defaultFallbackDelay = 250 * time.Millisecond
+
+// happyEyeballs dials host names by racing connections to all of their
+// addresses, alternating between IPv6 and IPv4 (RFC 8305)
+type happyEyeballs struct {
+	dialer *net.Dialer
+	delay  time.Duration
+}
+
+// DialContext resolves addr and races connection attempts to its addresses
+func (h *happyEyeballs) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || !strings.HasPrefix(network, "tcp") || net.ParseIP(host) != nil {
+		return h.dialer.DialContext(ctx, network, addr)
+	}
+
+	resolver := h.dialer.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	start := time.Now()
+	ips, err := resolver.LookupIPAddr(ctx, host)
+	if timings, ok := ctx.Value(dialTimingsKey{}).(*DialTimings); ok {
+		timings.DNS += time.Since(start)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]string, 0, len(ips))
+	for _, ip := range interleaveAddrs(ips) {
+		addrs = append(addrs, net.JoinHostPort(ip.String(), port))
+	}
+	return dialParallel(ctx, network, addrs, h.delay, h.dialer.DialContext)
+}
+
+// interleaveAddrs orders addresses alternating between IPv6 and IPv4,
+// starting with IPv6, keeping the resolver's order within each family
+func interleaveAddrs(ips []net.IPAddr) []net.IPAddr {
+	var v6, v4 []net.IPAddr
+	for _, ip := range ips {
+		if ip.IP.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+
+	out := make([]net.IPAddr, 0, len(ips))
+	for i := 0; i < len(v6) || i < len(v4); i++ {
+		if i < len(v6) {
+			out = append(out, v6[i])
+		}
+		if i < len(v4) {
+			out = append(out, v4[i])
+		}
+	}
+	return out
+}
+
+// dialParallel starts a connection attempt to each address in turn, giving
+// each a head start of delay or until it fails, and returns the first
+// connection established. Attempts that lose the race are closed.
+func dialParallel(ctx context.Context, network string, addrs []string, delay time.Duration, dial func(ctx context.Context, network, addr string) (net.Conn, error)) (net.Conn, error) {
+	if len(addrs) == 0 {
+		return nil, &net.AddrError{Err: "no addresses", Addr: ""}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, len(addrs))
+
+	next, pending := 0, 0
+	start := func() {
+		addr := addrs[next]
+		next++
+		pending++
+		go func() {
+			conn, err := dial(ctx, network, addr)
+			results <- result{conn, err}
+		}()
+	}
+
+	var firstErr error
+	start()
+	for pending > 0 {
+		var headStart <-chan time.Time
+		if next < len(addrs) {
+			headStart = time.After(delay)
+		}
+
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				// Close connections that lose the race
+				go func(n int) {
+					for ; n > 0; n-- {
+						if r := <-results; r.conn != nil {
+							r.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if next < len(addrs) {
+				start()
+			}
+		case <-headStart:
+			start()
+		}
+	}
+
+	return nil, firstErr
+}
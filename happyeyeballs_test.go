@@ -0,0 +1,96 @@
+package axon_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+func TestInterleaveAddrs(t *testing.T) {
+	var ips []net.IPAddr
+	for _, s := range []string{"192.0.2.1", "192.0.2.2", "2001:db8::1", "192.0.2.3", "2001:db8::2"} {
+		ips = append(ips, net.IPAddr{IP: net.ParseIP(s)})
+	}
+
+	want := []string{"2001:db8::1", "192.0.2.1", "2001:db8::2", "192.0.2.2", "192.0.2.3"}
+	got := axon.InterleaveAddrs(ips)
+	if len(got) != len(want) {
+		t.Fatalf("expected %d addresses, got %d", len(want), len(got))
+	}
+	for i, w := range want {
+		if got[i].IP.String() != w {
+			t.Errorf("address %d: expected %s, got %s", i, w, got[i].IP)
+		}
+	}
+}
+
+func TestDialParallel_HeadStart(t *testing.T) {
+	canceled := make(chan struct{})
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if addr == "[2001:db8::1]:80" {
+			// A black-holed IPv6 path
+			<-ctx.Done()
+			close(canceled)
+			return nil, ctx.Err()
+		}
+		client, server := net.Pipe()
+		server.Close()
+		return client, nil
+	}
+
+	start := time.Now()
+	conn, err := axon.DialParallel(context.Background(), "tcp", []string{"[2001:db8::1]:80", "192.0.2.1:80"}, 50*time.Millisecond, dial)
+	if err != nil {
+		t.Fatalf("DialParallel() error = %v", err)
+	}
+	conn.Close()
+
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Errorf("expected IPv4 to win after the head start, took %v", elapsed)
+	}
+
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Error("expected the losing attempt to be canceled")
+	}
+}
+
+func TestDialParallel_FailureStartsNext(t *testing.T) {
+	refused := errors.New("connection refused")
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if addr == "[2001:db8::1]:80" {
+			return nil, refused
+		}
+		client, server := net.Pipe()
+		server.Close()
+		return client, nil
+	}
+
+	start := time.Now()
+	conn, err := axon.DialParallel(context.Background(), "tcp", []string{"[2001:db8::1]:80", "192.0.2.1:80"}, 10*time.Second, dial)
+	if err != nil {
+		t.Fatalf("DialParallel() error = %v", err)
+	}
+	conn.Close()
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected a failed attempt to start the next one at once, took %v", elapsed)
+	}
+}
+
+func TestDialParallel_AllFail(t *testing.T) {
+	refused := errors.New("connection refused")
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return nil, refused
+	}
+
+	_, err := axon.DialParallel(context.Background(), "tcp", []string{"[2001:db8::1]:80", "192.0.2.1:80"}, time.Millisecond, dial)
+	if err != refused {
+		t.Errorf("expected the first error, got %v", err)
+	}
+}
@@ -0,0 +1,220 @@
+package axon
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConnHealth is a snapshot of a connection's health. Pongs are only seen
+// while the connection is being read, so RTT and pong misses are tracked
+// for connections with an active reader.
+type ConnHealth struct {
+	RTT         time.Duration // Round trip of the last answered ping
+	PongMisses  int           // Consecutive pings not answered before the next
+	TotalMisses int64         // Pings never answered
+	Messages    int64         // Messages read and written
+	Errors      int64         // Failed reads and writes
+	Score       float64       // From 0 (unusable) to 1 (healthy)
+}
+
+// healthRefRTT is the round trip time that halves the latency factor of
+// the health score
+const healthRefRTT = time.Second
+
+// connHealth tracks the health of a single connection
+type connHealth struct {
+	messages atomic.Int64
+	errors   atomic.Int64
+
+	mu          sync.Mutex
+	rtt         time.Duration
+	outstanding bool // A ping awaits its pong
+	misses      int
+	totalMisses int64
+}
+
+// record counts the outcome of a read or write
+func (h *connHealth) record(err error) {
+	if err == nil {
+		h.messages.Add(1)
+		return
+	}
+	if isConnError(err) {
+		h.errors.Add(1)
+	}
+}
+
+// isConnError reports whether a failed read or write says something about
+// the connection. Timeouts and cancellation do not.
+func isConnError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return false
+	}
+	return !errors.Is(err, ErrContextCanceled) && !errors.Is(err, ErrReadDeadlineExceeded) && !errors.Is(err, ErrWriteDeadlineExceeded)
+}
+
+// pingPayload returns the payload of a ping sent now, counting a miss if
+// the previous ping was never answered
+func (h *connHealth) pingPayload(now time.Time) []byte {
+	h.mu.Lock()
+	if h.outstanding {
+		h.misses++
+		h.totalMisses++
+	}
+	h.outstanding = true
+	h.mu.Unlock()
+
+	return binary.BigEndian.AppendUint64(nil, uint64(now.UnixNano()))
+}
+
+// pong records a pong carrying the payload of one of our pings
+func (h *connHealth) pong(payload []byte, now time.Time) {
+	if len(payload) != 8 {
+		return
+	}
+	sent := time.Unix(0, int64(binary.BigEndian.Uint64(payload)))
+	if sent.After(now) {
+		return
+	}
+
+	h.mu.Lock()
+	h.rtt = now.Sub(sent)
+	h.outstanding = false
+	h.misses = 0
+	h.mu.Unlock()
+}
+
+// snapshot returns the health with its score. Each consecutive pong miss
+// costs a quarter of the score, latency scales it by
+// healthRefRTT/(healthRefRTT+RTT) and the error rate scales the rest.
+func (h *connHealth) snapshot() ConnHealth {
+	h.mu.Lock()
+	health := ConnHealth{
+		RTT:         h.rtt,
+		PongMisses:  h.misses,
+		TotalMisses: h.totalMisses,
+	}
+	h.mu.Unlock()
+	health.Messages = h.messages.Load()
+	health.Errors = h.errors.Load()
+
+	score := 1 - 0.25*float64(health.PongMisses)
+	score *= float64(healthRefRTT) / float64(healthRefRTT+health.RTT)
+	if total := health.Messages + health.Errors; total > 0 {
+		score *= 1 - float64(health.Errors)/float64(total)
+	}
+	health.Score = max(score, 0)
+	return health
+}
+
+// Health returns a snapshot of the connection's health
+func (c *Conn[T]) Health() ConnHealth {
+	return c.health.snapshot()
+}
+
+// HealthConfig makes a Client replace a degraded connection before it
+// fails outright. The connection is closed and, if reconnection is
+// enabled, replaced as if it had been lost with ErrConnectionDegraded.
+type HealthConfig struct {
+	// CheckInterval is how often the connection's health is checked
+	// Default: 5 seconds
+	CheckInterval time.Duration
+
+	// MaxRTT is the longest acceptable ping round trip
+	// 0 disables the check
+	MaxRTT time.Duration
+
+	// MaxPongMisses is the number of consecutive unanswered pings tolerated
+	// 0 disables the check
+	MaxPongMisses int
+
+	// MinScore is the lowest acceptable health score
+	// 0 disables the check
+	MinScore float64
+
+	// OnDegraded is called with the health of a connection about to be
+	// replaced
+	OnDegraded func(ConnHealth)
+}
+
+// degraded reports whether health fails any of the configured checks
+func (hc *HealthConfig) degraded(health ConnHealth) bool {
+	return (hc.MaxRTT > 0 && health.RTT > hc.MaxRTT) ||
+		(hc.MaxPongMisses > 0 && health.PongMisses >= hc.MaxPongMisses) ||
+		(hc.MinScore > 0 && health.Score < hc.MinScore)
+}
+
+// Health returns the health of the current connection, or the zero value
+// while disconnected
+func (c *Client[T]) Health() ConnHealth {
+	conn := c.Conn()
+	if conn == nil {
+		return ConnHealth{}
+	}
+	return conn.Health()
+}
+
+// startHealthMonitor checks conn's health until it is replaced, cycling it
+// once it is degraded
+func (c *Client[T]) startHealthMonitor(conn *Conn[T]) {
+	hc := c.opts.Health
+	if hc == nil {
+		return
+	}
+	interval := hc.CheckInterval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+
+		ticker := c.clock().NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-ticker.C():
+			}
+
+			if c.Conn() != conn {
+				return
+			}
+			health := conn.Health()
+			if !hc.degraded(health) {
+				continue
+			}
+
+			if !c.detach(conn) {
+				return
+			}
+			conn.logger().Warn("connection degraded", "rtt", health.RTT, "pong_misses", health.PongMisses, "score", health.Score)
+			if hc.OnDegraded != nil {
+				hc.OnDegraded(health)
+			}
+			conn.Close(int(CloseGoingAway), "degraded connection")
+			c.handleDisconnect(ErrConnectionDegraded)
+			return
+		}
+	}()
+}
+
+// detach stops using conn as the client's connection, reporting whether it
+// was still in use
+func (c *Client[T]) detach(conn *Conn[T]) bool {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	if c.conn != conn {
+		return false
+	}
+	c.conn = nil
+	return true
+}
@@ -0,0 +1,143 @@
+package axon_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+func TestClient_HealthRTT(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[string](w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close(1000, "done")
+		for {
+			msg, err := conn.Read(r.Context())
+			if err != nil {
+				return
+			}
+			conn.Write(r.Context(), msg)
+		}
+	}))
+	defer server.Close()
+
+	opts := axon.DefaultClientOptions()
+	opts.Reconnect.Enabled = false
+	opts.PingInterval = 20 * time.Millisecond
+	client := axon.NewClient[string]("ws"+strings.TrimPrefix(server.URL, "http"), opts)
+	defer client.Close()
+
+	if health := client.Health(); health != (axon.ConnHealth{}) {
+		t.Errorf("Health() before connecting = %+v, want zero", health)
+	}
+
+	msgs, _ := client.Receive()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.ConnectWithReadLoop(ctx); err != nil {
+		t.Fatalf("ConnectWithReadLoop() error = %v", err)
+	}
+	if err := client.Write(ctx, "hello"); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	receive(t, msgs)
+
+	for client.Health().RTT == 0 {
+		if ctx.Err() != nil {
+			t.Fatal("timed out waiting for a pong")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	health := client.Health()
+	if health.PongMisses != 0 || health.Errors != 0 {
+		t.Errorf("Health() = %+v, want no misses or errors", health)
+	}
+	if health.Messages < 2 {
+		t.Errorf("Messages = %d, want at least 2", health.Messages)
+	}
+	if health.Score < 0.9 || health.Score > 1 {
+		t.Errorf("Score = %v, want close to 1 on a local connection", health.Score)
+	}
+}
+
+func TestClient_HealthCyclesDegradedConnection(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+
+	var conns atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[string](w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close(1000, "done")
+
+		// The first connection never reads, so pings go unanswered
+		if conns.Add(1) == 1 {
+			<-stop
+			return
+		}
+		for {
+			if _, err := conn.Read(r.Context()); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	degraded := make(chan axon.ConnHealth, 1)
+	disconnects := make(chan error, 1)
+
+	opts := axon.DefaultClientOptions()
+	opts.PingInterval = 20 * time.Millisecond
+	opts.Reconnect.InitialDelay = 10 * time.Millisecond
+	opts.Reconnect.Jitter = false
+	opts.Health = &axon.HealthConfig{
+		CheckInterval: 10 * time.Millisecond,
+		MaxPongMisses: 2,
+		OnDegraded: func(health axon.ConnHealth) {
+			degraded <- health
+		},
+	}
+	client := axon.NewClient[string]("ws"+strings.TrimPrefix(server.URL, "http"), opts)
+	defer client.Close()
+	client.OnDisconnect(func(_ *axon.Client[string], err error) {
+		select {
+		case disconnects <- err:
+		default:
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.ConnectWithReadLoop(ctx); err != nil {
+		t.Fatalf("ConnectWithReadLoop() error = %v", err)
+	}
+
+	if health := receive(t, degraded); health.PongMisses < 2 {
+		t.Errorf("degraded health has %d pong misses, want at least 2", health.PongMisses)
+	}
+	if err := receive(t, disconnects); !errors.Is(err, axon.ErrConnectionDegraded) {
+		t.Errorf("disconnect error = %v, want ErrConnectionDegraded", err)
+	}
+
+	if err := client.WaitForState(ctx, axon.StateConnected); err != nil {
+		t.Fatalf("WaitForState() error = %v", err)
+	}
+	for conns.Load() < 2 {
+		if ctx.Err() != nil {
+			t.Fatal("timed out waiting for the replacement connection")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,143 @@
+package axon
+
+import (
+	"context"
+	"time"
+)
+
+// HeartbeatConfig makes a Client send application-level heartbeats, for
+// gateways that expect messages such as {"op":"ping"} rather than protocol
+// pings. Each connection gets its own heartbeat loop.
+type HeartbeatConfig[T any] struct {
+	// Interval is how often a heartbeat is sent
+	// Default: 30 seconds
+	Interval time.Duration
+
+	// Message returns the heartbeat to send
+	Message func() T
+
+	// IsReply reports whether a received message answers a heartbeat.
+	// Replies are not delivered to OnMessage. When nil, replies are not
+	// awaited.
+	IsReply func(msg T) bool
+
+	// Timeout is how long to wait for a reply. A connection whose reply
+	// does not arrive in time is closed and, if reconnection is enabled,
+	// replaced as if it had been lost with ErrHeartbeatTimeout.
+	// Default: Interval
+	Timeout time.Duration
+
+	// OnTimeout is called before a connection that missed a reply is
+	// closed
+	OnTimeout func()
+}
+
+// SetHeartbeat configures application-level heartbeats. It takes effect
+// from the next connection, so call it before connecting. Replies are read
+// by the read loop, so a client awaiting them must be connected with
+// ConnectWithReadLoop.
+func (c *Client[T]) SetHeartbeat(cfg HeartbeatConfig[T]) {
+	c.heartbeatMu.Lock()
+	c.heartbeat = cfg
+	if c.heartbeatReply == nil {
+		c.heartbeatReply = make(chan struct{}, 1)
+	}
+	c.heartbeatMu.Unlock()
+}
+
+// resolveHeartbeat records a heartbeat reply, reporting whether msg was one
+func (c *Client[T]) resolveHeartbeat(msg T) bool {
+	c.heartbeatMu.Lock()
+	isReply := c.heartbeat.IsReply
+	reply := c.heartbeatReply
+	c.heartbeatMu.Unlock()
+
+	if isReply == nil || !isReply(msg) {
+		return false
+	}
+	select {
+	case reply <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// startHeartbeat sends heartbeats on conn until it is replaced, cycling it
+// once a reply is missed
+func (c *Client[T]) startHeartbeat(conn *Conn[T]) {
+	c.heartbeatMu.Lock()
+	hb := c.heartbeat
+	reply := c.heartbeatReply
+	c.heartbeatMu.Unlock()
+	if hb.Message == nil {
+		return
+	}
+	interval := hb.Interval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	timeout := hb.Timeout
+	if timeout <= 0 {
+		timeout = interval
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+
+		ticker := c.clock().NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-ticker.C():
+			}
+
+			if c.Conn() != conn {
+				return
+			}
+
+			// A reply to an earlier heartbeat does not answer this one
+			select {
+			case <-reply:
+			default:
+			}
+
+			ctx, cancel := context.WithTimeout(c.ctx, timeout)
+			err := conn.Write(ctx, hb.Message())
+			cancel()
+			if err != nil {
+				// A broken connection is noticed by its reader
+				conn.logger().Debug("heartbeat failed", "error", err)
+				continue
+			}
+			if hb.IsReply == nil {
+				continue
+			}
+
+			timer := c.clock().NewTimer(timeout)
+			select {
+			case <-c.ctx.Done():
+				timer.Stop()
+				return
+			case <-reply:
+				timer.Stop()
+				continue
+			case <-timer.C():
+			}
+
+			if !c.detach(conn) {
+				return
+			}
+			conn.logger().Warn("heartbeat reply missed", "timeout", timeout)
+			if hb.OnTimeout != nil {
+				hb.OnTimeout()
+			}
+			conn.Close(int(CloseGoingAway), "heartbeat timeout")
+			c.handleDisconnect(ErrHeartbeatTimeout)
+			return
+		}
+	}()
+}
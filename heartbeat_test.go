@@ -0,0 +1,93 @@
+package axon_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+type gatewayMessage struct {
+	Op string `json:"op"`
+}
+
+func TestClient_Heartbeat(t *testing.T) {
+	// The first connection answers two heartbeats, then goes silent
+	var conns atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[gatewayMessage](w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close(1000, "done")
+		first := conns.Add(1) == 1
+
+		for answered := 0; ; {
+			msg, err := conn.Read(r.Context())
+			if err != nil {
+				return
+			}
+			if msg.Op == "ping" && (!first || answered < 2) {
+				answered++
+				conn.Write(r.Context(), gatewayMessage{Op: "pong"})
+			}
+		}
+	}))
+	defer server.Close()
+
+	timeouts := make(chan struct{}, 1)
+	disconnects := make(chan error, 1)
+
+	opts := axon.DefaultClientOptions()
+	opts.Reconnect.InitialDelay = 10 * time.Millisecond
+	opts.Reconnect.Jitter = false
+	client := axon.NewClient[gatewayMessage]("ws"+strings.TrimPrefix(server.URL, "http"), opts)
+	defer client.Close()
+	client.SetHeartbeat(axon.HeartbeatConfig[gatewayMessage]{
+		Interval: 20 * time.Millisecond,
+		Timeout:  50 * time.Millisecond,
+		Message:  func() gatewayMessage { return gatewayMessage{Op: "ping"} },
+		IsReply:  func(msg gatewayMessage) bool { return msg.Op == "pong" },
+		OnTimeout: func() {
+			timeouts <- struct{}{}
+		},
+	})
+	client.OnDisconnect(func(_ *axon.Client[gatewayMessage], err error) {
+		select {
+		case disconnects <- err:
+		default:
+		}
+	})
+	var delivered atomic.Int32
+	client.OnMessage(func(gatewayMessage) { delivered.Add(1) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.ConnectWithReadLoop(ctx); err != nil {
+		t.Fatalf("ConnectWithReadLoop() error = %v", err)
+	}
+
+	receive(t, timeouts)
+	if err := receive(t, disconnects); !errors.Is(err, axon.ErrHeartbeatTimeout) {
+		t.Errorf("disconnect error = %v, want ErrHeartbeatTimeout", err)
+	}
+	if n := delivered.Load(); n != 0 {
+		t.Errorf("%d heartbeat replies delivered to OnMessage", n)
+	}
+
+	if err := client.WaitForState(ctx, axon.StateConnected); err != nil {
+		t.Fatalf("WaitForState() error = %v", err)
+	}
+	for conns.Load() < 2 {
+		if ctx.Err() != nil {
+			t.Fatal("timed out waiting for the replacement connection")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,368 @@
+package axon
+
+import (
+	"errors"
+	"sync"
+)
+
+// errHPACK is returned for header blocks that cannot be decoded
+var errHPACK = errors.New("axon: invalid HPACK header block")
+
+// hpackField is a decoded header field
+type hpackField struct {
+	name, value string
+}
+
+// size returns the size of the field in a dynamic table (RFC 7541
+// Section 4.1)
+func (f hpackField) size() int { return len(f.name) + len(f.value) + 32 }
+
+// hpackMaxTableSize is the default SETTINGS_HEADER_TABLE_SIZE, which the
+// HTTP/2 client keeps
+const hpackMaxTableSize = 4096
+
+// hpackDecoder decodes the header blocks of one HTTP/2 connection
+// (RFC 7541). The encoder side is stateless: fields are sent as literals
+// without indexing.
+type hpackDecoder struct {
+	dynamic []hpackField // Newest first
+	size    int
+	maxSize int
+}
+
+// newHPACKDecoder creates a decoder with the default table size
+func newHPACKDecoder() *hpackDecoder {
+	return &hpackDecoder{maxSize: hpackMaxTableSize}
+}
+
+// decode decodes a complete header block
+func (d *hpackDecoder) decode(block []byte) ([]hpackField, error) {
+	var fields []hpackField
+	for len(block) > 0 {
+		b := block[0]
+		switch {
+		case b&0x80 != 0: // Indexed field
+			index, rest, err := hpackInteger(block, 7)
+			if err != nil {
+				return nil, err
+			}
+			f, ok := d.field(index)
+			if !ok {
+				return nil, errHPACK
+			}
+			fields = append(fields, f)
+			block = rest
+
+		case b&0xc0 == 0x40: // Literal with incremental indexing
+			f, rest, err := d.literal(block, 6)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, f)
+			d.add(f)
+			block = rest
+
+		case b&0xe0 == 0x20: // Dynamic table size update
+			size, rest, err := hpackInteger(block, 5)
+			if err != nil {
+				return nil, err
+			}
+			if size > hpackMaxTableSize {
+				return nil, errHPACK
+			}
+			d.maxSize = int(size)
+			d.evict()
+			block = rest
+
+		default: // Literal without indexing or never indexed
+			f, rest, err := d.literal(block, 4)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, f)
+			block = rest
+		}
+	}
+	return fields, nil
+}
+
+// literal decodes a literal field whose name index has the given prefix
+func (d *hpackDecoder) literal(block []byte, prefix uint8) (hpackField, []byte, error) {
+	index, rest, err := hpackInteger(block, prefix)
+	if err != nil {
+		return hpackField{}, nil, err
+	}
+	var f hpackField
+	if index == 0 {
+		if f.name, rest, err = hpackString(rest); err != nil {
+			return hpackField{}, nil, err
+		}
+	} else {
+		named, ok := d.field(index)
+		if !ok {
+			return hpackField{}, nil, errHPACK
+		}
+		f.name = named.name
+	}
+	if f.value, rest, err = hpackString(rest); err != nil {
+		return hpackField{}, nil, err
+	}
+	return f, rest, nil
+}
+
+// field returns the field at index of the static and dynamic tables
+func (d *hpackDecoder) field(index uint64) (hpackField, bool) {
+	switch {
+	case index == 0:
+		return hpackField{}, false
+	case index <= uint64(len(hpackStaticTable)):
+		return hpackStaticTable[index-1], true
+	case index-uint64(len(hpackStaticTable)) <= uint64(len(d.dynamic)):
+		return d.dynamic[index-uint64(len(hpackStaticTable))-1], true
+	default:
+		return hpackField{}, false
+	}
+}
+
+// add inserts f into the dynamic table
+func (d *hpackDecoder) add(f hpackField) {
+	d.dynamic = append([]hpackField{f}, d.dynamic...)
+	d.size += f.size()
+	d.evict()
+}
+
+// evict drops the oldest entries until the table fits its maximum size
+func (d *hpackDecoder) evict() {
+	for d.size > d.maxSize && len(d.dynamic) > 0 {
+		d.size -= d.dynamic[len(d.dynamic)-1].size()
+		d.dynamic = d.dynamic[:len(d.dynamic)-1]
+	}
+}
+
+// hpackInteger decodes an integer with an N-bit prefix (RFC 7541
+// Section 5.1)
+func hpackInteger(b []byte, prefix uint8) (uint64, []byte, error) {
+	if len(b) == 0 {
+		return 0, nil, errHPACK
+	}
+	mask := uint64(1)<<prefix - 1
+	v := uint64(b[0]) & mask
+	b = b[1:]
+	if v < mask {
+		return v, b, nil
+	}
+	for shift := uint(0); len(b) > 0 && shift < 63; shift += 7 {
+		c := b[0]
+		b = b[1:]
+		v += uint64(c&0x7f) << shift
+		if c&0x80 == 0 {
+			return v, b, nil
+		}
+	}
+	return 0, nil, errHPACK
+}
+
+// hpackString decodes a string literal (RFC 7541 Section 5.2)
+func hpackString(b []byte) (string, []byte, error) {
+	if len(b) == 0 {
+		return "", nil, errHPACK
+	}
+	huffman := b[0]&0x80 != 0
+	n, rest, err := hpackInteger(b, 7)
+	if err != nil || n > uint64(len(rest)) {
+		return "", nil, errHPACK
+	}
+	s, rest := rest[:n], rest[n:]
+	if !huffman {
+		return string(s), rest, nil
+	}
+	decoded, err := huffmanDecode(s)
+	return decoded, rest, err
+}
+
+// appendHPACKField encodes a field as a literal without indexing and with
+// a new name, leaving the encoder stateless
+func appendHPACKField(dst []byte, name, value string) []byte {
+	dst = append(dst, 0)
+	dst = appendHPACKString(dst, name)
+	return appendHPACKString(dst, value)
+}
+
+// appendHPACKString encodes a string literal without Huffman coding
+func appendHPACKString(dst []byte, s string) []byte {
+	dst = appendHPACKInteger(dst, 7, 0, uint64(len(s)))
+	return append(dst, s...)
+}
+
+// appendHPACKInteger encodes v with an N-bit prefix after the flags in
+// the first byte
+func appendHPACKInteger(dst []byte, prefix uint8, flags byte, v uint64) []byte {
+	mask := uint64(1)<<prefix - 1
+	if v < mask {
+		return append(dst, flags|byte(v))
+	}
+	dst = append(dst, flags|byte(mask))
+	for v -= mask; v >= 0x80; v >>= 7 {
+		dst = append(dst, byte(v)|0x80)
+	}
+	return append(dst, byte(v))
+}
+
+// huffmanSymbols maps each Huffman code, keyed with its length, to its
+// symbol
+var (
+	huffmanOnce    sync.Once
+	huffmanSymbols map[uint64]byte
+)
+
+// huffmanDecode decodes a Huffman-coded string (RFC 7541 Section 5.2)
+func huffmanDecode(b []byte) (string, error) {
+	huffmanOnce.Do(func() {
+		huffmanSymbols = make(map[uint64]byte, len(huffmanCodes))
+		for sym, code := range huffmanCodes {
+			huffmanSymbols[uint64(huffmanCodeLen[sym])<<32|uint64(code)] = byte(sym)
+		}
+	})
+
+	out := make([]byte, 0, len(b)*8/5)
+	var code uint64
+	var n uint8
+	for _, c := range b {
+		for i := 7; i >= 0; i-- {
+			code = code<<1 | uint64(c>>i&1)
+			n++
+			if sym, ok := huffmanSymbols[uint64(n)<<32|code]; ok {
+				out = append(out, sym)
+				code, n = 0, 0
+			} else if n >= 30 {
+				return "", errHPACK
+			}
+		}
+	}
+	// Padding is at most 7 bits of the EOS code, which is all ones
+	if n > 7 || code != uint64(1)<<n-1 {
+		return "", errHPACK
+	}
+	return string(out), nil
+}
+
+// hpackStaticTable is the static table of RFC 7541 Appendix A
+var hpackStaticTable = [...]hpackField{
+	{":authority", ""},
+	{":method", "GET"},
+	{":method", "POST"},
+	{":path", "/"},
+	{":path", "/index.html"},
+	{":scheme", "http"},
+	{":scheme", "https"},
+	{":status", "200"},
+	{":status", "204"},
+	{":status", "206"},
+	{":status", "304"},
+	{":status", "400"},
+	{":status", "404"},
+	{":status", "500"},
+	{"accept-charset", ""},
+	{"accept-encoding", "gzip, deflate"},
+	{"accept-language", ""},
+	{"accept-ranges", ""},
+	{"accept", ""},
+	{"access-control-allow-origin", ""},
+	{"age", ""},
+	{"allow", ""},
+	{"authorization", ""},
+	{"cache-control", ""},
+	{"content-disposition", ""},
+	{"content-encoding", ""},
+	{"content-language", ""},
+	{"content-length", ""},
+	{"content-location", ""},
+	{"content-range", ""},
+	{"content-type", ""},
+	{"cookie", ""},
+	{"date", ""},
+	{"etag", ""},
+	{"expect", ""},
+	{"expires", ""},
+	{"from", ""},
+	{"host", ""},
+	{"if-match", ""},
+	{"if-modified-since", ""},
+	{"if-none-match", ""},
+	{"if-range", ""},
+	{"if-unmodified-since", ""},
+	{"last-modified", ""},
+	{"link", ""},
+	{"location", ""},
+	{"max-forwards", ""},
+	{"proxy-authenticate", ""},
+	{"proxy-authorization", ""},
+	{"range", ""},
+	{"referer", ""},
+	{"refresh", ""},
+	{"retry-after", ""},
+	{"server", ""},
+	{"set-cookie", ""},
+	{"strict-transport-security", ""},
+	{"transfer-encoding", ""},
+	{"user-agent", ""},
+	{"vary", ""},
+	{"via", ""},
+	{"www-authenticate", ""},
+}
+
+// huffmanCodes are the codes of RFC 7541 Appendix B, by symbol
+var huffmanCodes = [256]uint32{
+	0x1ff8, 0x7fffd8, 0xfffffe2, 0xfffffe3, 0xfffffe4, 0xfffffe5, 0xfffffe6, 0xfffffe7,
+	0xfffffe8, 0xffffea, 0x3ffffffc, 0xfffffe9, 0xfffffea, 0x3ffffffd, 0xfffffeb, 0xfffffec,
+	0xfffffed, 0xfffffee, 0xfffffef, 0xffffff0, 0xffffff1, 0xffffff2, 0x3ffffffe, 0xffffff3,
+	0xffffff4, 0xffffff5, 0xffffff6, 0xffffff7, 0xffffff8, 0xffffff9, 0xffffffa, 0xffffffb,
+	0x14, 0x3f8, 0x3f9, 0xffa, 0x1ff9, 0x15, 0xf8, 0x7fa,
+	0x3fa, 0x3fb, 0xf9, 0x7fb, 0xfa, 0x16, 0x17, 0x18,
+	0x0, 0x1, 0x2, 0x19, 0x1a, 0x1b, 0x1c, 0x1d,
+	0x1e, 0x1f, 0x5c, 0xfb, 0x7ffc, 0x20, 0xffb, 0x3fc,
+	0x1ffa, 0x21, 0x5d, 0x5e, 0x5f, 0x60, 0x61, 0x62,
+	0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69, 0x6a,
+	0x6b, 0x6c, 0x6d, 0x6e, 0x6f, 0x70, 0x71, 0x72,
+	0xfc, 0x73, 0xfd, 0x1ffb, 0x7fff0, 0x1ffc, 0x3ffc, 0x22,
+	0x7ffd, 0x3, 0x23, 0x4, 0x24, 0x5, 0x25, 0x26,
+	0x27, 0x6, 0x74, 0x75, 0x28, 0x29, 0x2a, 0x7,
+	0x2b, 0x76, 0x2c, 0x8, 0x9, 0x2d, 0x77, 0x78,
+	0x79, 0x7a, 0x7b, 0x7ffe, 0x7fc, 0x3ffd, 0x1ffd, 0xffffffc,
+	0xfffe6, 0x3fffd2, 0xfffe7, 0xfffe8, 0x3fffd3, 0x3fffd4, 0x3fffd5, 0x7fffd9,
+	0x3fffd6, 0x7fffda, 0x7fffdb, 0x7fffdc, 0x7fffdd, 0x7fffde, 0xffffeb, 0x7fffdf,
+	0xffffec, 0xffffed, 0x3fffd7, 0x7fffe0, 0xffffee, 0x7fffe1, 0x7fffe2, 0x7fffe3,
+	0x7fffe4, 0x1fffdc, 0x3fffd8, 0x7fffe5, 0x3fffd9, 0x7fffe6, 0x7fffe7, 0xffffef,
+	0x3fffda, 0x1fffdd, 0xfffe9, 0x3fffdb, 0x3fffdc, 0x7fffe8, 0x7fffe9, 0x1fffde,
+	0x7fffea, 0x3fffdd, 0x3fffde, 0xfffff0, 0x1fffdf, 0x3fffdf, 0x7fffeb, 0x7fffec,
+	0x1fffe0, 0x1fffe1, 0x3fffe0, 0x1fffe2, 0x7fffed, 0x3fffe1, 0x7fffee, 0x7fffef,
+	0xfffea, 0x3fffe2, 0x3fffe3, 0x3fffe4, 0x7ffff0, 0x3fffe5, 0x3fffe6, 0x7ffff1,
+	0x3ffffe0, 0x3ffffe1, 0xfffeb, 0x7fff1, 0x3fffe7, 0x7ffff2, 0x3fffe8, 0x1ffffec,
+	0x3ffffe2, 0x3ffffe3, 0x3ffffe4, 0x7ffffde, 0x7ffffdf, 0x3ffffe5, 0xfffff1, 0x1ffffed,
+	0x7fff2, 0x1fffe3, 0x3ffffe6, 0x7ffffe0, 0x7ffffe1, 0x3ffffe7, 0x7ffffe2, 0xfffff2,
+	0x1fffe4, 0x1fffe5, 0x3ffffe8, 0x3ffffe9, 0xffffffd, 0x7ffffe3, 0x7ffffe4, 0x7ffffe5,
+	0xfffec, 0xfffff3, 0xfffed, 0x1fffe6, 0x3fffe9, 0x1fffe7, 0x1fffe8, 0x7ffff3,
+	0x3fffea, 0x3fffeb, 0x1ffffee, 0x1ffffef, 0xfffff4, 0xfffff5, 0x3ffffea, 0x7ffff4,
+	0x3ffffeb, 0x7ffffe6, 0x3ffffec, 0x3ffffed, 0x7ffffe7, 0x7ffffe8, 0x7ffffe9, 0x7ffffea,
+	0x7ffffeb, 0xffffffe, 0x7ffffec, 0x7ffffed, 0x7ffffee, 0x7ffffef, 0x7fffff0, 0x3ffffee,
+}
+
+// huffmanCodeLen are the lengths in bits of huffmanCodes
+var huffmanCodeLen = [256]uint8{
+	13, 23, 28, 28, 28, 28, 28, 28, 28, 24, 30, 28, 28, 30, 28, 28,
+	28, 28, 28, 28, 28, 28, 30, 28, 28, 28, 28, 28, 28, 28, 28, 28,
+	6, 10, 10, 12, 13, 6, 8, 11, 10, 10, 8, 11, 8, 6, 6, 6,
+	5, 5, 5, 6, 6, 6, 6, 6, 6, 6, 7, 8, 15, 6, 12, 10,
+	13, 6, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7,
+	7, 7, 7, 7, 7, 7, 7, 7, 8, 7, 8, 13, 19, 13, 14, 6,
+	15, 5, 6, 5, 6, 5, 6, 6, 6, 5, 7, 7, 6, 6, 6, 5,
+	6, 7, 6, 5, 5, 6, 7, 7, 7, 7, 7, 15, 11, 14, 13, 28,
+	20, 22, 20, 20, 22, 22, 22, 23, 22, 23, 23, 23, 23, 23, 24, 23,
+	24, 24, 22, 23, 24, 23, 23, 23, 23, 21, 22, 23, 22, 23, 23, 24,
+	22, 21, 20, 22, 22, 23, 23, 21, 23, 22, 22, 24, 21, 22, 23, 23,
+	21, 21, 22, 21, 23, 22, 23, 23, 20, 22, 22, 22, 23, 22, 22, 23,
+	26, 26, 20, 19, 22, 23, 22, 25, 26, 26, 26, 27, 27, 26, 24, 25,
+	19, 21, 26, 27, 27, 26, 27, 24, 21, 21, 26, 26, 28, 27, 27, 27,
+	20, 24, 20, 21, 22, 21, 21, 23, 22, 22, 25, 25, 24, 24, 26, 23,
+	26, 27, 26, 26, 27, 27, 27, 27, 27, 28, 27, 27, 27, 27, 27, 26,
+}
@@ -0,0 +1,213 @@
+package axon
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// isExtendedConnect reports whether r bootstraps a WebSocket over an
+// HTTP/2 stream as described in RFC 8441
+func isExtendedConnect(r *http.Request) bool {
+	return r.ProtoMajor == 2 && r.Method == http.MethodConnect
+}
+
+// h2Stream adapts the request and response bodies of an HTTP/2 extended
+// CONNECT stream to a net.Conn. HTTP/2 streams cannot be interrupted and
+// resumed, so an expired deadline aborts the stream.
+type h2Stream struct {
+	r     io.ReadCloser
+	w     io.Writer
+	flush func() error
+	abort func()
+
+	localAddr  net.Addr
+	remoteAddr net.Addr
+
+	closeOnce sync.Once
+	closed    chan struct{}
+
+	readDeadline  streamDeadline
+	writeDeadline streamDeadline
+}
+
+// newH2Stream creates a stream reading from r and writing to w. flush is
+// called after every write and abort tears the stream down.
+func newH2Stream(r io.ReadCloser, w io.Writer, flush func() error, abort func(), local, remote net.Addr) *h2Stream {
+	s := &h2Stream{
+		r:          r,
+		w:          w,
+		flush:      flush,
+		abort:      abort,
+		localAddr:  local,
+		remoteAddr: remote,
+		closed:     make(chan struct{}),
+	}
+	s.readDeadline.expire = s.Close
+	s.writeDeadline.expire = s.Close
+	return s
+}
+
+// Read reads from the peer's half of the stream
+func (s *h2Stream) Read(p []byte) (int, error) {
+	if s.readDeadline.expired() {
+		return 0, os.ErrDeadlineExceeded
+	}
+	n, err := s.r.Read(p)
+	if err != nil && s.readDeadline.expired() {
+		err = os.ErrDeadlineExceeded
+	}
+	return n, err
+}
+
+// Write writes to the local half of the stream and flushes it
+func (s *h2Stream) Write(p []byte) (int, error) {
+	if s.writeDeadline.expired() {
+		return 0, os.ErrDeadlineExceeded
+	}
+	select {
+	case <-s.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+
+	n, err := s.w.Write(p)
+	if err == nil {
+		err = s.flush()
+	}
+	if err != nil && s.writeDeadline.expired() {
+		err = os.ErrDeadlineExceeded
+	}
+	return n, err
+}
+
+// Close aborts the stream
+func (s *h2Stream) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		s.readDeadline.stop()
+		s.writeDeadline.stop()
+		s.abort()
+	})
+	return nil
+}
+
+// LocalAddr returns the local network address
+func (s *h2Stream) LocalAddr() net.Addr { return s.localAddr }
+
+// RemoteAddr returns the remote network address
+func (s *h2Stream) RemoteAddr() net.Addr { return s.remoteAddr }
+
+// SetDeadline sets the read and write deadlines
+func (s *h2Stream) SetDeadline(t time.Time) error {
+	s.readDeadline.set(t)
+	s.writeDeadline.set(t)
+	return nil
+}
+
+// SetReadDeadline sets the read deadline
+func (s *h2Stream) SetReadDeadline(t time.Time) error {
+	s.readDeadline.set(t)
+	return nil
+}
+
+// SetWriteDeadline sets the write deadline
+func (s *h2Stream) SetWriteDeadline(t time.Time) error {
+	s.writeDeadline.set(t)
+	return nil
+}
+
+// streamDeadline calls expire once its deadline passes
+type streamDeadline struct {
+	mu      sync.Mutex
+	timer   *time.Timer
+	passed  bool
+	stopped bool
+	expire  func() error
+}
+
+// set arms the deadline; the zero time disarms it
+func (d *streamDeadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	if d.stopped || d.passed || t.IsZero() {
+		return
+	}
+
+	wait := time.Until(t)
+	if wait <= 0 {
+		d.passed = true
+		go d.expire()
+		return
+	}
+	d.timer = time.AfterFunc(wait, func() {
+		d.mu.Lock()
+		d.passed = true
+		d.mu.Unlock()
+		d.expire()
+	})
+}
+
+// expired reports whether the deadline has passed
+func (d *streamDeadline) expired() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.passed
+}
+
+// stop disarms the deadline for good
+func (d *streamDeadline) stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stopped = true
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+}
+
+// streamAddr is the address of an HTTP/2 stream's connection
+type streamAddr string
+
+// Network returns the address's network name
+func (a streamAddr) Network() string { return "tcp" }
+
+// String returns the address
+func (a streamAddr) String() string { return string(a) }
+
+// acceptHTTP2 answers an extended CONNECT request and returns the stream.
+// The stream lives only as long as the handler serving r.
+func acceptHTTP2(w http.ResponseWriter, r *http.Request, hs *handshake) (net.Conn, error) {
+	if hs.subprotocol != "" {
+		w.Header().Set("Sec-WebSocket-Protocol", hs.subprotocol)
+	}
+	if extensions := hs.extensionsHeader(); extensions != "" {
+		w.Header().Set("Sec-WebSocket-Extensions", extensions)
+	}
+
+	rc := http.NewResponseController(w)
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return nil, err
+	}
+
+	var local net.Addr = streamAddr("")
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		local = addr
+	}
+
+	abort := func() {
+		r.Body.Close()
+		// Unblock a write stalled on flow control
+		rc.SetWriteDeadline(time.Now())
+	}
+	return newH2Stream(r.Body, w, rc.Flush, abort, local, streamAddr(r.RemoteAddr)), nil
+}
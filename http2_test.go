@@ -0,0 +1,213 @@
+package axon_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+// streamResponseWriter is an http.ResponseWriter whose body is a pipe, as
+// for an HTTP/2 stream
+type streamResponseWriter struct {
+	header http.Header
+	status int
+	w      *io.PipeWriter
+}
+
+func (s *streamResponseWriter) Header() http.Header         { return s.header }
+func (s *streamResponseWriter) WriteHeader(status int)      { s.status = status }
+func (s *streamResponseWriter) Write(p []byte) (int, error) { return s.w.Write(p) }
+func (s *streamResponseWriter) Flush()                      {}
+
+// extendedConnectRequest returns an RFC 8441 request reading from body
+func extendedConnectRequest(body io.Reader) *http.Request {
+	req := httptest.NewRequest(http.MethodConnect, "/", body)
+	req.Proto, req.ProtoMajor, req.ProtoMinor = "HTTP/2.0", 2, 0
+	req.Header.Set(":protocol", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	return req
+}
+
+func TestUpgrade_ExtendedConnect(t *testing.T) {
+	reqBody, clientWriter := io.Pipe()
+	clientReader, respBody := io.Pipe()
+	defer clientWriter.Close()
+	defer clientReader.Close()
+
+	w := &streamResponseWriter{header: make(http.Header), w: respBody}
+	req := extendedConnectRequest(reqBody)
+	req.Header.Set("Sec-WebSocket-Protocol", "chat")
+
+	conn, err := axon.Upgrade[string](w, req, &axon.UpgradeOptions{
+		EnableHTTP2:  true,
+		Subprotocols: []string{"chat"},
+	})
+	if err != nil {
+		t.Fatalf("Upgrade() error = %v", err)
+	}
+	defer conn.Close(1000, "")
+
+	if w.status != http.StatusOK {
+		t.Errorf("expected status 200, got %d", w.status)
+	}
+	if got := w.header.Get("Sec-WebSocket-Protocol"); got != "chat" {
+		t.Errorf("expected subprotocol chat, got %q", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	go writeClientFrame(clientWriter, 0x1, []byte(`"hello"`))
+	if got, err := conn.Read(ctx); err != nil || got != "hello" {
+		t.Fatalf("expected hello, got %q (err=%v)", got, err)
+	}
+
+	go conn.Write(ctx, "world")
+	opcode, payload, err := readServerFrame(clientReader)
+	if err != nil || opcode != 0x1 || string(payload) != `"world"` {
+		t.Fatalf("expected world frame, got opcode %d payload %q (err=%v)", opcode, payload, err)
+	}
+
+	// Let the close frame through
+	go io.Copy(io.Discard, clientReader)
+}
+
+func TestUpgrade_ExtendedConnectDisabled(t *testing.T) {
+	w := httptest.NewRecorder()
+	if _, err := axon.Upgrade[string](w, extendedConnectRequest(nil), nil); err != axon.ErrUpgradeRequired {
+		t.Errorf("expected ErrUpgradeRequired without EnableHTTP2, got %v", err)
+	}
+	if w.Code != http.StatusUpgradeRequired {
+		t.Errorf("expected status %d, got %d", http.StatusUpgradeRequired, w.Code)
+	}
+}
+
+// withExtendedConnect reruns the named test in a child process that
+// enables extended CONNECT in net/http's HTTP/2 server, which reads
+// GODEBUG only at startup. It reports whether the caller is that child.
+func withExtendedConnect(t *testing.T) bool {
+	t.Helper()
+	if strings.Contains(os.Getenv("GODEBUG"), "http2xconnect=1") {
+		return true
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^"+t.Name()+"$", "-test.count=1")
+	cmd.Env = append(os.Environ(), "GODEBUG="+os.Getenv("GODEBUG")+",http2xconnect=1")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	return false
+}
+
+func TestDial_HTTP2(t *testing.T) {
+	if !withExtendedConnect(t) {
+		return
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[string](w, r, &axon.UpgradeOptions{
+			EnableHTTP2:  true,
+			Subprotocols: []string{"chat"},
+		})
+		if err != nil {
+			return
+		}
+		defer conn.Close(1000, "")
+		for {
+			msg, err := conn.Read(r.Context())
+			if err != nil {
+				return
+			}
+			if err := conn.Write(r.Context(), r.Proto+" "+msg); err != nil {
+				return
+			}
+		}
+	})
+
+	tlsServer := httptest.NewUnstartedServer(handler)
+	tlsServer.EnableHTTP2 = true
+	tlsServer.StartTLS()
+	defer tlsServer.Close()
+
+	h2cServer := httptest.NewUnstartedServer(handler)
+	h2cServer.Config.Protocols = new(http.Protocols)
+	h2cServer.Config.Protocols.SetUnencryptedHTTP2(true)
+	h2cServer.Start()
+	defer h2cServer.Close()
+
+	tests := []struct {
+		name   string
+		server *httptest.Server
+		scheme string
+	}{
+		{"TLS", tlsServer, "wss"},
+		{"PriorKnowledge", h2cServer, "ws"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			url := tt.scheme + strings.TrimPrefix(strings.TrimPrefix(tt.server.URL, "https"), "http") + "/"
+			opts := &axon.DialOptions{
+				EnableHTTP2:  true,
+				Subprotocols: []string{"chat"},
+			}
+			if tt.server.TLS != nil {
+				opts.TLSConfig = &tls.Config{RootCAs: tt.server.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs}
+			}
+			conn, err := axon.Dial[string](ctx, url, opts)
+			if err != nil {
+				t.Fatalf("Dial() error = %v", err)
+			}
+			defer conn.Close(1000, "")
+
+			if got := conn.Subprotocol(); got != "chat" {
+				t.Errorf("expected subprotocol chat, got %q", got)
+			}
+			for _, msg := range []string{"hello", "world"} {
+				if err := conn.Write(ctx, msg); err != nil {
+					t.Fatalf("Write() error = %v", err)
+				}
+				got, err := conn.Read(ctx)
+				if err != nil {
+					t.Fatalf("Read() error = %v", err)
+				}
+				if want := "HTTP/2.0 " + msg; got != want {
+					t.Errorf("got %q, want %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDial_HTTP2Unsupported(t *testing.T) {
+	if strings.Contains(os.Getenv("GODEBUG"), "http2xconnect=1") {
+		t.Skip("net/http advertises extended CONNECT in this process")
+	}
+
+	server := httptest.NewUnstartedServer(http.NotFoundHandler())
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := axon.Dial[string](ctx, "wss"+strings.TrimPrefix(server.URL, "https")+"/", &axon.DialOptions{
+		EnableHTTP2: true,
+		TLSConfig:   &tls.Config{RootCAs: server.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs},
+	})
+	if !errors.Is(err, axon.ErrExtendedConnectUnsupported) {
+		t.Errorf("expected ErrExtendedConnectUnsupported, got %v", err)
+	}
+}
@@ -0,0 +1,674 @@
+package axon
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errHTTP2Protocol is returned when the server breaks the HTTP/2 protocol
+var errHTTP2Protocol = errors.New("axon: HTTP/2 protocol error")
+
+// HTTP/2 frame types, flags, settings and error codes used by the
+// extended CONNECT client (RFC 9113)
+const (
+	h2FrameData         = 0x0
+	h2FrameHeaders      = 0x1
+	h2FrameRSTStream    = 0x3
+	h2FrameSettings     = 0x4
+	h2FramePushPromise  = 0x5
+	h2FramePing         = 0x6
+	h2FrameGoAway       = 0x7
+	h2FrameWindowUpdate = 0x8
+	h2FrameContinuation = 0x9
+
+	h2FlagEndStream  = 0x1
+	h2FlagAck        = 0x1
+	h2FlagEndHeaders = 0x4
+	h2FlagPadded     = 0x8
+	h2FlagPriority   = 0x20
+
+	h2SettingEnablePush            = 0x2
+	h2SettingInitialWindowSize     = 0x4
+	h2SettingMaxFrameSize          = 0x5
+	h2SettingEnableConnectProtocol = 0x8
+)
+
+const (
+	// h2Preface opens every HTTP/2 connection
+	h2Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+	// h2StreamID is the only stream the client opens
+	h2StreamID = 1
+
+	// h2MaxFrameSize is the largest frame payload either side may send
+	// until the peer allows more
+	h2MaxFrameSize = 16384
+
+	// h2InitialWindow is the initial flow control window of streams and
+	// connections, which the client keeps for what it receives
+	h2InitialWindow = 65535
+)
+
+// dialHTTP2 opens a connection on the stream of an extended CONNECT
+// request (RFC 8441), over an HTTP/2 connection of its own. ctx bounds the
+// handshake; u has an http or https scheme.
+func dialHTTP2[T any](ctx context.Context, d *Dialer, u *url.URL, offer deflateParams, compression compressionConfig, start time.Time, timings *DialTimings) (*Conn[T], error) {
+	opts := d.opts
+
+	// Offer only h2 in the TLS handshake
+	h2Opts := *opts
+	if opts.TLSConfig != nil {
+		h2Opts.TLSConfig = opts.TLSConfig.Clone()
+	} else {
+		h2Opts.TLSConfig = &tls.Config{}
+	}
+	h2Opts.TLSConfig.NextProtos = []string{"h2"}
+
+	conn, err := (&Dialer{opts: &h2Opts}).dial(ctx, u)
+	if err != nil {
+		return nil, fmt.Errorf("axon: dial failed: %w", err)
+	}
+	if tc, ok := conn.(*tls.Conn); ok && tc.ConnectionState().NegotiatedProtocol != "h2" {
+		conn.Close()
+		return nil, ErrExtendedConnectUnsupported
+	}
+	handshakeStart := time.Now()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+
+	cc := newH2ClientConn(conn)
+	resp, err := cc.handshake(http2RequestHeaders(opts, u, offer))
+	if err != nil {
+		stop()
+		cc.close()
+		return nil, fmt.Errorf("axon: dial failed: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		hsErr := newHandshakeError(resp)
+		stop()
+		cc.close()
+		return nil, hsErr
+	}
+
+	// The server may only select a subprotocol the client offered
+	subprotocol := resp.Header.Get("Sec-WebSocket-Protocol")
+	if subprotocol != "" && !slices.Contains(opts.Subprotocols, subprotocol) {
+		stop()
+		cc.close()
+		return nil, ErrInvalidSubprotocol
+	}
+
+	deflate, compressionEnabled, extensions, err := acceptedExtensions(opts, offer, resp.Header)
+	if err != nil {
+		stop()
+		cc.close()
+		return nil, err
+	}
+
+	// Clear deadlines for normal operation, unless ctx ended meanwhile
+	if !stop() {
+		cc.close()
+		return nil, fmt.Errorf("axon: dial failed: %w", ctx.Err())
+	}
+	conn.SetDeadline(time.Time{})
+
+	// Writes are framed as they are made, so there is nothing to flush
+	stream := newH2Stream(resp.Body, cc, func() error { return nil }, cc.close, conn.LocalAddr(), conn.RemoteAddr())
+
+	var cm *CompressionManager
+	if compressionEnabled {
+		cm = newCompressionManager(compression, deflate, true)
+	}
+	wsConn := newClientConn[T](opts, stream, nil, subprotocol, cm, extensions)
+
+	timings.Handshake = time.Since(handshakeStart)
+	timings.Total = time.Since(start)
+	wsConn.dialTimings = *timings
+
+	return wsConn, nil
+}
+
+// http2RequestHeaders encodes the header block of an extended CONNECT
+// request for u
+func http2RequestHeaders(opts *DialOptions, u *url.URL, offer deflateParams) []byte {
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	authority := u.Host
+	if opts.Host != "" {
+		authority = opts.Host
+	}
+
+	block := appendHPACKField(nil, ":method", http.MethodConnect)
+	block = appendHPACKField(block, ":protocol", "websocket")
+	block = appendHPACKField(block, ":scheme", u.Scheme)
+	block = appendHPACKField(block, ":path", path)
+	block = appendHPACKField(block, ":authority", authority)
+	block = appendHPACKField(block, "sec-websocket-version", "13")
+
+	if len(opts.Subprotocols) > 0 {
+		block = appendHPACKField(block, "sec-websocket-protocol", strings.Join(opts.Subprotocols, ", "))
+	}
+
+	var offers []Extension
+	if opts.Compression {
+		offers = offer.clientOffers()
+	}
+	offers = append(offers, offerExtensions(opts.Extensions)...)
+	if len(offers) > 0 {
+		block = appendHPACKField(block, "sec-websocket-extensions", FormatExtensions(offers...))
+	}
+
+	// Credentials in the URL are sent with basic authentication unless an
+	// Authorization header was given
+	if u.User != nil && opts.Headers.Get("Authorization") == "" {
+		password, _ := u.User.Password()
+		block = appendHPACKField(block, "authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(u.User.Username()+":"+password)))
+	}
+
+	for key, values := range opts.Headers {
+		name := strings.ToLower(key)
+		switch name {
+		case "host", "connection", "upgrade", "keep-alive", "proxy-connection", "transfer-encoding":
+			continue // Set with the Host option, or not allowed in HTTP/2
+		}
+		for _, value := range values {
+			block = appendHPACKField(block, name, value)
+		}
+	}
+	return block
+}
+
+// h2ClientConn is an HTTP/2 connection carrying one extended CONNECT
+// stream. Flow control is honored both ways: the server's windows bound
+// what is written, and received data is acknowledged as it is read.
+type h2ClientConn struct {
+	conn  net.Conn
+	br    *bufio.Reader
+	hpack *hpackDecoder
+
+	wmu sync.Mutex // Serializes frame writes
+
+	mu            sync.Mutex
+	cond          *sync.Cond
+	connWindow    int64 // What may be sent on the connection
+	streamWindow  int64 // What may be sent on the stream
+	initialWindow int64 // The server's SETTINGS_INITIAL_WINDOW_SIZE
+	maxFrameSize  int
+	connectOK     bool
+	seenSettings  bool
+	recv          bytes.Buffer // Received data not yet read
+	recvEnded     bool
+	inflight      int // Received bytes not yet acknowledged
+	pendingAck    int // Read bytes not yet acknowledged
+	err           error
+
+	settings chan struct{}     // Closed on the server's first SETTINGS
+	response chan []hpackField // The response headers
+	done     chan struct{}     // Closed when the connection fails
+}
+
+// newH2ClientConn wraps conn, over which nothing was sent yet
+func newH2ClientConn(conn net.Conn) *h2ClientConn {
+	cc := &h2ClientConn{
+		conn:          conn,
+		br:            bufio.NewReaderSize(conn, 4096),
+		hpack:         newHPACKDecoder(),
+		connWindow:    h2InitialWindow,
+		streamWindow:  h2InitialWindow,
+		initialWindow: h2InitialWindow,
+		maxFrameSize:  h2MaxFrameSize,
+		settings:      make(chan struct{}),
+		response:      make(chan []hpackField, 1),
+		done:          make(chan struct{}),
+	}
+	cc.cond = sync.NewCond(&cc.mu)
+	return cc
+}
+
+// handshake opens the connection, sends the request header block on the
+// stream and returns the response, whose body is the stream's data
+func (cc *h2ClientConn) handshake(block []byte) (*http.Response, error) {
+	if _, err := io.WriteString(cc.conn, h2Preface); err != nil {
+		return nil, err
+	}
+	var settings [6]byte
+	binary.BigEndian.PutUint16(settings[:2], h2SettingEnablePush)
+	if err := cc.writeFrame(h2FrameSettings, 0, 0, settings[:]); err != nil {
+		return nil, err
+	}
+	go cc.readLoop()
+
+	select {
+	case <-cc.settings:
+	case <-cc.done:
+		return nil, cc.failure()
+	}
+	cc.mu.Lock()
+	connectOK := cc.connectOK
+	cc.mu.Unlock()
+	if !connectOK {
+		return nil, ErrExtendedConnectUnsupported
+	}
+
+	if err := cc.writeHeaders(block); err != nil {
+		return nil, err
+	}
+
+	var fields []hpackField
+	select {
+	case fields = <-cc.response:
+	case <-cc.done:
+		return nil, cc.failure()
+	}
+
+	resp := &http.Response{
+		Proto:      "HTTP/2.0",
+		ProtoMajor: 2,
+		Header:     make(http.Header),
+		Body:       h2Body{cc},
+	}
+	for _, f := range fields {
+		switch {
+		case f.name == ":status":
+			resp.StatusCode, _ = strconv.Atoi(f.value)
+		case !strings.HasPrefix(f.name, ":"):
+			resp.Header.Add(f.name, f.value)
+		}
+	}
+	if resp.StatusCode < 100 || resp.StatusCode > 999 {
+		return nil, errHTTP2Protocol
+	}
+	resp.Status = strconv.Itoa(resp.StatusCode) + " " + http.StatusText(resp.StatusCode)
+	return resp, nil
+}
+
+// readLoop reads frames until the connection fails
+func (cc *h2ClientConn) readLoop() {
+	var (
+		block       []byte // Header block being assembled
+		blockEnds   bool   // Whether the HEADERS frame ended the stream
+		gotResponse bool
+	)
+	for {
+		typ, flags, stream, payload, err := cc.readFrame()
+		if err != nil {
+			cc.fail(err)
+			return
+		}
+		// A header block is sent in frames with nothing in between
+		if block != nil && typ != h2FrameContinuation {
+			cc.fail(errHTTP2Protocol)
+			return
+		}
+
+		switch typ {
+		case h2FrameSettings:
+			if flags&h2FlagAck != 0 {
+				continue
+			}
+			if err := cc.applySettings(payload); err != nil {
+				cc.fail(err)
+				return
+			}
+			cc.writeFrame(h2FrameSettings, h2FlagAck, 0, nil)
+
+		case h2FramePing:
+			if flags&h2FlagAck == 0 {
+				cc.writeFrame(h2FramePing, h2FlagAck, 0, payload)
+			}
+
+		case h2FrameWindowUpdate:
+			if len(payload) != 4 {
+				cc.fail(errHTTP2Protocol)
+				return
+			}
+			increment := int64(binary.BigEndian.Uint32(payload) & 0x7fffffff)
+			cc.mu.Lock()
+			switch stream {
+			case 0:
+				cc.connWindow += increment
+			case h2StreamID:
+				cc.streamWindow += increment
+			}
+			cc.cond.Broadcast()
+			cc.mu.Unlock()
+
+		case h2FrameHeaders, h2FrameContinuation:
+			if stream != h2StreamID || (typ == h2FrameContinuation) != (block != nil) {
+				cc.fail(errHTTP2Protocol)
+				return
+			}
+			if typ == h2FrameHeaders {
+				if payload, err = h2Unpad(flags, payload); err != nil {
+					cc.fail(err)
+					return
+				}
+				if flags&h2FlagPriority != 0 {
+					if len(payload) < 5 {
+						cc.fail(errHTTP2Protocol)
+						return
+					}
+					payload = payload[5:]
+				}
+				block, blockEnds = []byte{}, flags&h2FlagEndStream != 0
+			}
+			block = append(block, payload...)
+			if flags&h2FlagEndHeaders == 0 {
+				continue
+			}
+
+			fields, err := cc.hpack.decode(block)
+			if err != nil {
+				cc.fail(err)
+				return
+			}
+			block = nil
+			// Informational responses precede the final one, and trailers
+			// follow the data
+			if !gotResponse && !isInformational(fields) {
+				gotResponse = true
+				cc.response <- fields
+			}
+			if blockEnds {
+				cc.endStream()
+			}
+
+		case h2FrameData:
+			if stream != h2StreamID {
+				cc.fail(errHTTP2Protocol)
+				return
+			}
+			data, err := h2Unpad(flags, payload)
+			if err != nil {
+				cc.fail(err)
+				return
+			}
+			cc.mu.Lock()
+			cc.inflight += len(payload)
+			if cc.inflight > h2InitialWindow || cc.recvEnded {
+				cc.mu.Unlock()
+				cc.fail(errHTTP2Protocol)
+				return
+			}
+			cc.recv.Write(data)
+			// Padding is acknowledged along with the data
+			cc.pendingAck += len(payload) - len(data)
+			cc.cond.Broadcast()
+			cc.mu.Unlock()
+			if flags&h2FlagEndStream != 0 {
+				cc.endStream()
+			}
+
+		case h2FrameRSTStream:
+			if stream == h2StreamID && len(payload) == 4 {
+				cc.fail(fmt.Errorf("axon: HTTP/2 stream reset by server with code %d", binary.BigEndian.Uint32(payload)))
+				return
+			}
+
+		case h2FrameGoAway:
+			// Streams up to the last one the server processes carry on
+			if len(payload) < 8 || binary.BigEndian.Uint32(payload)&0x7fffffff < h2StreamID {
+				cc.fail(fmt.Errorf("axon: HTTP/2 connection refused by server"))
+				return
+			}
+
+		case h2FramePushPromise:
+			// Push was disabled in the client's settings
+			cc.fail(errHTTP2Protocol)
+			return
+		}
+	}
+}
+
+// isInformational reports whether the header fields are a 1xx response
+func isInformational(fields []hpackField) bool {
+	for _, f := range fields {
+		if f.name == ":status" {
+			return len(f.value) == 3 && f.value[0] == '1'
+		}
+	}
+	return false
+}
+
+// applySettings applies a SETTINGS frame from the server
+func (cc *h2ClientConn) applySettings(payload []byte) error {
+	if len(payload)%6 != 0 {
+		return errHTTP2Protocol
+	}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	for i := 0; i < len(payload); i += 6 {
+		value := binary.BigEndian.Uint32(payload[i+2:])
+		switch binary.BigEndian.Uint16(payload[i:]) {
+		case h2SettingInitialWindowSize:
+			if value > 1<<31-1 {
+				return errHTTP2Protocol
+			}
+			cc.streamWindow += int64(value) - cc.initialWindow
+			cc.initialWindow = int64(value)
+		case h2SettingMaxFrameSize:
+			if value < h2MaxFrameSize || value > 1<<24-1 {
+				return errHTTP2Protocol
+			}
+			cc.maxFrameSize = int(value)
+		case h2SettingEnableConnectProtocol:
+			// Once enabled, the setting cannot be withdrawn
+			cc.connectOK = cc.connectOK || value == 1
+		}
+	}
+	cc.cond.Broadcast()
+	if !cc.seenSettings {
+		cc.seenSettings = true
+		close(cc.settings)
+	}
+	return nil
+}
+
+// h2Unpad strips the padding of a DATA or HEADERS frame
+func h2Unpad(flags byte, payload []byte) ([]byte, error) {
+	if flags&h2FlagPadded == 0 {
+		return payload, nil
+	}
+	if len(payload) == 0 || int(payload[0]) > len(payload)-1 {
+		return nil, errHTTP2Protocol
+	}
+	return payload[1 : len(payload)-int(payload[0])], nil
+}
+
+// readFrame reads a frame from the server
+func (cc *h2ClientConn) readFrame() (typ, flags byte, stream uint32, payload []byte, err error) {
+	var header [9]byte
+	if _, err := io.ReadFull(cc.br, header[:]); err != nil {
+		return 0, 0, 0, nil, err
+	}
+	length := int(header[0])<<16 | int(header[1])<<8 | int(header[2])
+	if length > h2MaxFrameSize {
+		return 0, 0, 0, nil, errHTTP2Protocol
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(cc.br, payload); err != nil {
+		return 0, 0, 0, nil, err
+	}
+	return header[3], header[4], binary.BigEndian.Uint32(header[5:]) & 0x7fffffff, payload, nil
+}
+
+// writeFrame writes a frame to the server
+func (cc *h2ClientConn) writeFrame(typ, flags byte, stream uint32, payload []byte) error {
+	cc.wmu.Lock()
+	defer cc.wmu.Unlock()
+	return cc.writeFrameLocked(typ, flags, stream, payload)
+}
+
+// writeFrameLocked writes a frame while wmu is held
+func (cc *h2ClientConn) writeFrameLocked(typ, flags byte, stream uint32, payload []byte) error {
+	header := [9]byte{byte(len(payload) >> 16), byte(len(payload) >> 8), byte(len(payload)), typ, flags}
+	binary.BigEndian.PutUint32(header[5:], stream)
+	bufs := net.Buffers{header[:], payload}
+	_, err := bufs.WriteTo(cc.conn)
+	return err
+}
+
+// writeHeaders writes a header block on the stream, continuing it in as
+// many frames as the server's frame size requires
+func (cc *h2ClientConn) writeHeaders(block []byte) error {
+	cc.mu.Lock()
+	maxFrameSize := cc.maxFrameSize
+	cc.mu.Unlock()
+
+	cc.wmu.Lock()
+	defer cc.wmu.Unlock()
+	typ := byte(h2FrameHeaders)
+	for {
+		n := min(len(block), maxFrameSize)
+		var flags byte
+		if n == len(block) {
+			flags = h2FlagEndHeaders
+		}
+		if err := cc.writeFrameLocked(typ, flags, h2StreamID, block[:n]); err != nil {
+			return err
+		}
+		if block = block[n:]; len(block) == 0 {
+			return nil
+		}
+		typ = h2FrameContinuation
+	}
+}
+
+// Write sends p on the stream in DATA frames, waiting for the server's
+// flow control windows to allow them
+func (cc *h2ClientConn) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		cc.mu.Lock()
+		for cc.err == nil && (cc.connWindow <= 0 || cc.streamWindow <= 0) {
+			cc.cond.Wait()
+		}
+		if cc.err != nil {
+			err := cc.err
+			cc.mu.Unlock()
+			return written, err
+		}
+		n := int(min(int64(len(p)), int64(cc.maxFrameSize), cc.connWindow, cc.streamWindow))
+		cc.connWindow -= int64(n)
+		cc.streamWindow -= int64(n)
+		cc.mu.Unlock()
+
+		if err := cc.writeFrame(h2FrameData, 0, h2StreamID, p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// read reads data received on the stream, acknowledging it to the server
+// a quarter window at a time
+func (cc *h2ClientConn) read(p []byte) (int, error) {
+	cc.mu.Lock()
+	for cc.recv.Len() == 0 && !cc.recvEnded && cc.err == nil {
+		cc.cond.Wait()
+	}
+	if cc.recv.Len() == 0 {
+		err := io.EOF
+		if !cc.recvEnded {
+			err = cc.err
+		}
+		cc.mu.Unlock()
+		return 0, err
+	}
+
+	n, _ := cc.recv.Read(p)
+	cc.pendingAck += n
+	ack := 0
+	if cc.pendingAck >= h2InitialWindow/4 {
+		ack = cc.pendingAck
+		cc.pendingAck = 0
+		cc.inflight -= ack
+	}
+	ended := cc.recvEnded
+	cc.mu.Unlock()
+
+	if ack > 0 {
+		var increment [4]byte
+		binary.BigEndian.PutUint32(increment[:], uint32(ack))
+		cc.writeFrame(h2FrameWindowUpdate, 0, 0, increment[:])
+		if !ended {
+			cc.writeFrame(h2FrameWindowUpdate, 0, h2StreamID, increment[:])
+		}
+	}
+	return n, nil
+}
+
+// endStream records that the server ended its half of the stream
+func (cc *h2ClientConn) endStream() {
+	cc.mu.Lock()
+	cc.recvEnded = true
+	cc.cond.Broadcast()
+	cc.mu.Unlock()
+}
+
+// failure returns the error the connection failed with
+func (cc *h2ClientConn) failure() error {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.err
+}
+
+// fail tears the connection down with err, unless it already failed
+func (cc *h2ClientConn) fail(err error) {
+	cc.mu.Lock()
+	if cc.err == nil {
+		cc.err = err
+		close(cc.done)
+	}
+	cc.cond.Broadcast()
+	cc.mu.Unlock()
+	cc.conn.Close()
+}
+
+// close ends the client's half of the stream, so that the server reads
+// everything sent before it, and closes the connection
+func (cc *h2ClientConn) close() {
+	if cc.failure() == nil {
+		cc.conn.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+		cc.writeFrame(h2FrameData, h2FlagEndStream, h2StreamID, nil)
+	}
+	cc.fail(net.ErrClosed)
+}
+
+// h2Body reads the data of the stream
+type h2Body struct {
+	cc *h2ClientConn
+}
+
+// Read reads data received on the stream
+func (b h2Body) Read(p []byte) (int, error) { return b.cc.read(p) }
+
+// Close does nothing; the stream ends with its connection
+func (b h2Body) Close() error { return nil }
@@ -0,0 +1,519 @@
+package axon
+
+import (
+	"context"
+	"net/netip"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HubOptions configures a Hub
+type HubOptions struct {
+	// SendBufferSize sets how many outgoing messages may be buffered per
+	// connection. A connection whose buffer is full is a slow consumer and
+	// is evicted from the hub.
+	// Default is 256.
+	SendBufferSize int
+
+	// WriteTimeout bounds each write to a connection.
+	// Default is 10 seconds.
+	WriteTimeout time.Duration
+
+	// OnError is called when a connection is evicted because a write failed
+	// or it could not keep up. The connection has already been closed.
+	OnError func(id string, err error)
+
+	// Bans records the bans made with Hub.Ban. Set it to share bans
+	// between servers.
+	// Default is a new BanList.
+	Bans BanStore
+}
+
+// hubClient is a connection registered with a Hub
+type hubClient[T any] struct {
+	id    string
+	conn  *Conn[T]
+	send  chan *PreparedMessage[T]
+	done  chan struct{}
+	once  sync.Once
+	rooms map[string]struct{} // guarded by Hub.mu
+
+	// Set before done is closed; a non-zero code makes the write loop
+	// close the connection, since it may be mid-write when stopped
+	closeCode   CloseCode
+	closeReason string
+	closeErr    error
+	drain       bool // Deliver buffered messages before closing
+}
+
+// stop signals the client's write loop to exit, closing the connection
+// with code unless it is zero
+func (hc *hubClient[T]) stop(code CloseCode, err error) {
+	hc.once.Do(func() {
+		hc.closeCode = code
+		hc.closeErr = err
+		close(hc.done)
+	})
+}
+
+// stopDraining signals the client's write loop to deliver the messages
+// already buffered, then close the connection with code and reason
+func (hc *hubClient[T]) stopDraining(code CloseCode, reason string) {
+	hc.once.Do(func() {
+		hc.closeCode = code
+		hc.closeReason = reason
+		hc.drain = true
+		close(hc.done)
+	})
+}
+
+// Hub tracks a set of connections and fans messages out to them.
+// Each connection gets its own write loop, so one slow peer never blocks
+// delivery to the others. Connections may join named rooms to receive
+// messages broadcast to that room only.
+type Hub[T any] struct {
+	mu      sync.RWMutex
+	clients map[string]*hubClient[T]
+	rooms   map[string]map[string]*hubClient[T]
+	closed  atomic.Bool
+	wg      sync.WaitGroup
+
+	sendBufferSize int
+	writeTimeout   time.Duration
+	onError        func(id string, err error)
+	bans           BanStore
+}
+
+// NewHub creates a new Hub
+func NewHub[T any](opts *HubOptions) *Hub[T] {
+	h := &Hub[T]{
+		clients:        make(map[string]*hubClient[T]),
+		rooms:          make(map[string]map[string]*hubClient[T]),
+		sendBufferSize: 256,
+		writeTimeout:   10 * time.Second,
+	}
+
+	if opts != nil {
+		if opts.SendBufferSize > 0 {
+			h.sendBufferSize = opts.SendBufferSize
+		}
+		if opts.WriteTimeout > 0 {
+			h.writeTimeout = opts.WriteTimeout
+		}
+		h.onError = opts.OnError
+		h.bans = opts.Bans
+	}
+	if h.bans == nil {
+		h.bans = NewBanList()
+	}
+
+	return h
+}
+
+// Register adds a connection to the hub and returns its ID, which is the
+// same as conn.ID(). Registering a connection twice has no effect.
+// The caller keeps reading from the connection and should call Unregister
+// once its read loop ends.
+func (h *Hub[T]) Register(conn *Conn[T]) (string, error) {
+	hc := &hubClient[T]{
+		id:    conn.ID(),
+		conn:  conn,
+		send:  make(chan *PreparedMessage[T], h.sendBufferSize),
+		done:  make(chan struct{}),
+		rooms: make(map[string]struct{}),
+	}
+
+	h.mu.Lock()
+	if h.closed.Load() {
+		h.mu.Unlock()
+		return "", ErrHubClosed
+	}
+	if _, ok := h.clients[hc.id]; ok {
+		h.mu.Unlock()
+		return hc.id, nil
+	}
+	h.clients[hc.id] = hc
+	h.wg.Add(1)
+	h.mu.Unlock()
+
+	go h.writeLoop(hc)
+
+	return hc.id, nil
+}
+
+// Unregister removes a connection from the hub and all of its rooms
+// without closing it. Messages still buffered for it are discarded.
+func (h *Hub[T]) Unregister(id string) {
+	h.mu.Lock()
+	hc, ok := h.clients[id]
+	if ok {
+		h.remove(hc)
+	}
+	h.mu.Unlock()
+
+	if ok {
+		hc.stop(0, nil)
+	}
+}
+
+// Drain removes a connection from the hub and closes it with code and
+// reason once the messages already queued for it are delivered
+func (h *Hub[T]) Drain(id string, code CloseCode, reason string) error {
+	h.mu.Lock()
+	hc, ok := h.clients[id]
+	if ok {
+		h.remove(hc)
+	}
+	h.mu.Unlock()
+	if !ok {
+		return ErrConnectionNotFound
+	}
+
+	hc.stopDraining(code, reason)
+	return nil
+}
+
+// Ban bans a client IP or principal for d, or for good if d is zero, and
+// drains its registered connections with ClosePolicyViolation. Upgraders
+// enforce the ban when their UpgradeOptions.Bans is the hub's Bans.
+func (h *Hub[T]) Ban(key string, d time.Duration) error {
+	var until time.Time
+	if d > 0 {
+		until = time.Now().Add(d)
+	}
+	if err := h.bans.Ban(key, until); err != nil {
+		return err
+	}
+
+	for _, hc := range h.snapshot() {
+		ip, _ := hc.conn.Get(MetaClientIP)
+		addr, _ := ip.(netip.Addr)
+		principal, _ := hc.conn.Get(MetaPrincipal)
+		if slices.Contains(banKeys(addr, principal), key) {
+			h.Drain(hc.id, ClosePolicyViolation, "banned")
+		}
+	}
+	return nil
+}
+
+// Unban lifts the ban on a client IP or principal
+func (h *Hub[T]) Unban(key string) error {
+	return h.bans.Unban(key)
+}
+
+// Bans returns the hub's ban store, to pass to UpgradeOptions.Bans
+func (h *Hub[T]) Bans() BanStore {
+	return h.bans
+}
+
+// Join adds a registered connection to a room, creating the room if needed
+func (h *Hub[T]) Join(id, room string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hc, ok := h.clients[id]
+	if !ok {
+		return ErrConnectionNotFound
+	}
+
+	members, ok := h.rooms[room]
+	if !ok {
+		members = make(map[string]*hubClient[T])
+		h.rooms[room] = members
+	}
+	members[id] = hc
+	hc.rooms[room] = struct{}{}
+
+	return nil
+}
+
+// Leave removes a connection from a room. Empty rooms are discarded.
+func (h *Hub[T]) Leave(id, room string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hc, ok := h.clients[id]
+	if !ok {
+		return ErrConnectionNotFound
+	}
+	h.leave(hc, room)
+
+	return nil
+}
+
+// Rooms returns the rooms a connection has joined
+func (h *Hub[T]) Rooms(id string) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	hc, ok := h.clients[id]
+	if !ok {
+		return nil
+	}
+
+	rooms := make([]string, 0, len(hc.rooms))
+	for room := range hc.rooms {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+// Members returns the IDs of the connections in a room
+func (h *Hub[T]) Members(room string) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	members := h.rooms[room]
+	ids := make([]string, 0, len(members))
+	for id := range members {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// RoomLen returns the number of connections in a room
+func (h *Hub[T]) RoomLen(room string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.rooms[room])
+}
+
+// Send queues a message for a single connection.
+// If the connection's buffer is full it is evicted and ErrSlowConsumer is
+// returned.
+func (h *Hub[T]) Send(ctx context.Context, id string, msg T) error {
+	if h.closed.Load() {
+		return ErrHubClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	h.mu.RLock()
+	hc, ok := h.clients[id]
+	h.mu.RUnlock()
+	if !ok {
+		return ErrConnectionNotFound
+	}
+
+	pm, err := NewPreparedMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	return h.enqueue(hc, pm)
+}
+
+// Broadcast queues a message for every registered connection.
+// The message is prepared once and shared by all recipients. Slow
+// consumers are evicted and reported through OnError rather than failing
+// the broadcast; only a closed hub, canceled context or serialization
+// error is returned.
+func (h *Hub[T]) Broadcast(ctx context.Context, msg T) error {
+	if h.closed.Load() {
+		return ErrHubClosed
+	}
+	return h.fanOut(ctx, h.snapshot(), msg)
+}
+
+// BroadcastToRoom queues a message for every connection in a room,
+// with the same semantics as Broadcast
+func (h *Hub[T]) BroadcastToRoom(ctx context.Context, room string, msg T) error {
+	if h.closed.Load() {
+		return ErrHubClosed
+	}
+
+	h.mu.RLock()
+	members := h.rooms[room]
+	clients := make([]*hubClient[T], 0, len(members))
+	for _, hc := range members {
+		clients = append(clients, hc)
+	}
+	h.mu.RUnlock()
+
+	return h.fanOut(ctx, clients, msg)
+}
+
+// fanOut prepares msg once and queues it for each client
+func (h *Hub[T]) fanOut(ctx context.Context, clients []*hubClient[T], msg T) error {
+	if len(clients) == 0 {
+		return nil
+	}
+
+	pm, err := NewPreparedMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	for _, hc := range clients {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		h.enqueue(hc, pm)
+	}
+
+	return nil
+}
+
+// Range calls fn for each registered connection until fn returns false.
+// fn runs on a snapshot, so it may call other Hub methods.
+func (h *Hub[T]) Range(fn func(id string, conn *Conn[T]) bool) {
+	for _, hc := range h.snapshot() {
+		if !fn(hc.id, hc.conn) {
+			return
+		}
+	}
+}
+
+// Len returns the number of registered connections
+func (h *Hub[T]) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients)
+}
+
+// Close closes every registered connection with CloseGoingAway and waits
+// for their write loops to exit
+func (h *Hub[T]) Close() error {
+	h.mu.Lock()
+	if h.closed.Swap(true) {
+		h.mu.Unlock()
+		return nil
+	}
+	clients := h.clients
+	h.clients = make(map[string]*hubClient[T])
+	h.rooms = make(map[string]map[string]*hubClient[T])
+	h.mu.Unlock()
+
+	for _, hc := range clients {
+		hc.stop(CloseGoingAway, nil)
+	}
+
+	h.wg.Wait()
+	return nil
+}
+
+// snapshot returns the currently registered clients
+func (h *Hub[T]) snapshot() []*hubClient[T] {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	clients := make([]*hubClient[T], 0, len(h.clients))
+	for _, hc := range h.clients {
+		clients = append(clients, hc)
+	}
+	return clients
+}
+
+// remove deletes a client from the hub and its rooms; h.mu must be held
+func (h *Hub[T]) remove(hc *hubClient[T]) {
+	if h.clients[hc.id] != hc {
+		return
+	}
+	delete(h.clients, hc.id)
+	for room := range hc.rooms {
+		h.leave(hc, room)
+	}
+}
+
+// leave deletes a client from a room; h.mu must be held
+func (h *Hub[T]) leave(hc *hubClient[T], room string) {
+	delete(hc.rooms, room)
+	if members, ok := h.rooms[room]; ok {
+		delete(members, hc.id)
+		if len(members) == 0 {
+			delete(h.rooms, room)
+		}
+	}
+}
+
+// enqueue hands msg to the client's write loop without blocking
+func (h *Hub[T]) enqueue(hc *hubClient[T], pm *PreparedMessage[T]) error {
+	select {
+	case <-hc.done:
+		return ErrConnectionNotFound
+	default:
+	}
+
+	select {
+	case hc.send <- pm:
+		return nil
+	default:
+		h.evict(hc, ClosePolicyViolation, ErrSlowConsumer)
+		return ErrSlowConsumer
+	}
+}
+
+// evict removes a client and has its write loop close the connection
+func (h *Hub[T]) evict(hc *hubClient[T], code CloseCode, err error) {
+	h.mu.Lock()
+	h.remove(hc)
+	h.mu.Unlock()
+
+	hc.stop(code, err)
+}
+
+// writeLoop delivers queued messages to a single connection
+func (h *Hub[T]) writeLoop(hc *hubClient[T]) {
+	defer h.wg.Done()
+
+	for {
+		select {
+		case <-hc.done:
+			if hc.drain {
+				h.flush(hc)
+			}
+			h.finish(hc)
+			return
+		case pm := <-hc.send:
+			ctx, cancel := context.WithTimeout(context.Background(), h.writeTimeout)
+			err := hc.conn.WritePrepared(ctx, pm)
+			cancel()
+			if err != nil {
+				h.evict(hc, CloseInternalError, err)
+				h.finish(hc)
+				return
+			}
+		}
+	}
+}
+
+// flush delivers the messages buffered for a draining client, stopping at
+// the first failed write
+func (h *Hub[T]) flush(hc *hubClient[T]) {
+	for {
+		select {
+		case pm := <-hc.send:
+			ctx, cancel := context.WithTimeout(context.Background(), h.writeTimeout)
+			err := hc.conn.WritePrepared(ctx, pm)
+			cancel()
+			if err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// finish closes a stopped client's connection if requested and reports
+// why it was evicted
+func (h *Hub[T]) finish(hc *hubClient[T]) {
+	if hc.closeCode == 0 {
+		return
+	}
+
+	reason := hc.closeReason
+	if hc.closeErr == ErrSlowConsumer {
+		reason = "slow consumer"
+	} else if reason == "" && hc.closeCode == CloseGoingAway {
+		reason = "server shutting down"
+	}
+	hc.conn.Close(int(hc.closeCode), reason)
+
+	if hc.closeErr != nil && h.onError != nil {
+		h.onError(hc.id, hc.closeErr)
+	}
+}
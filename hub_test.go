@@ -0,0 +1,349 @@
+package axon_test
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+// newHubConn creates a server-side test connection and registers it
+func newHubConn(t *testing.T, hub *axon.Hub[string]) (string, *axon.Conn[string], net.Conn) {
+	t.Helper()
+
+	conn, clientConn, err := axon.NewTestConn[string](nil)
+	if err != nil {
+		t.Fatalf("failed to create test connection: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.Close(1000, "")
+		clientConn.Close()
+	})
+
+	id, err := hub.Register(conn)
+	if err != nil {
+		t.Fatalf("register failed: %v", err)
+	}
+	return id, conn, clientConn
+}
+
+// readHubFrame reads one frame from the client side of a hub connection
+func readHubFrame(t *testing.T, clientConn net.Conn) (byte, []byte) {
+	t.Helper()
+
+	clientConn.SetReadDeadline(time.Now().Add(time.Second))
+	opcode, payload, err := readServerFrame(clientConn)
+	if err != nil {
+		t.Fatalf("failed to read frame: %v", err)
+	}
+	return opcode, payload
+}
+
+func TestHub_Broadcast(t *testing.T) {
+	hub := axon.NewHub[string](nil)
+	defer hub.Close()
+
+	var clients []net.Conn
+	for i := 0; i < 3; i++ {
+		_, _, clientConn := newHubConn(t, hub)
+		clients = append(clients, clientConn)
+	}
+
+	if hub.Len() != 3 {
+		t.Fatalf("expected 3 connections, got %d", hub.Len())
+	}
+
+	if err := hub.Broadcast(context.Background(), "hello all"); err != nil {
+		t.Fatalf("broadcast failed: %v", err)
+	}
+
+	for i, clientConn := range clients {
+		opcode, payload := readHubFrame(t, clientConn)
+		if opcode != 0x1 || string(payload) != `"hello all"` {
+			t.Errorf("client %d: expected text %q, got opcode %d payload %q", i, `"hello all"`, opcode, payload)
+		}
+	}
+}
+
+func TestHub_Send(t *testing.T) {
+	hub := axon.NewHub[string](nil)
+	defer hub.Close()
+
+	id, _, clientConn := newHubConn(t, hub)
+
+	if err := hub.Send(context.Background(), id, "just you"); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	if _, payload := readHubFrame(t, clientConn); string(payload) != `"just you"` {
+		t.Errorf("expected %q, got %q", `"just you"`, payload)
+	}
+
+	if err := hub.Send(context.Background(), "missing", "nobody"); err != axon.ErrConnectionNotFound {
+		t.Errorf("expected ErrConnectionNotFound, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := hub.Send(ctx, id, "canceled"); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestHub_Unregister(t *testing.T) {
+	hub := axon.NewHub[string](nil)
+	defer hub.Close()
+
+	id, conn, _ := newHubConn(t, hub)
+	hub.Unregister(id)
+
+	if hub.Len() != 0 {
+		t.Errorf("expected 0 connections, got %d", hub.Len())
+	}
+	if conn.IsClosed() {
+		t.Error("unregister should not close the connection")
+	}
+	if err := hub.Send(context.Background(), id, "gone"); err != axon.ErrConnectionNotFound {
+		t.Errorf("expected ErrConnectionNotFound, got %v", err)
+	}
+}
+
+func TestHub_SlowConsumerEviction(t *testing.T) {
+	evicted := make(chan error, 1)
+	hub := axon.NewHub[string](&axon.HubOptions{
+		SendBufferSize: 1,
+		WriteTimeout:   50 * time.Millisecond,
+		OnError: func(id string, err error) {
+			evicted <- err
+		},
+	})
+	defer hub.Close()
+
+	// The client never reads, so the write loop stalls and the buffer fills
+	id, conn, _ := newHubConn(t, hub)
+
+	var err error
+	for i := 0; i < 10 && err == nil; i++ {
+		err = hub.Send(context.Background(), id, "flood")
+	}
+	if err != axon.ErrSlowConsumer {
+		t.Fatalf("expected ErrSlowConsumer, got %v", err)
+	}
+
+	select {
+	case err := <-evicted:
+		if err != axon.ErrSlowConsumer {
+			t.Errorf("expected OnError with ErrSlowConsumer, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for eviction")
+	}
+
+	if hub.Len() != 0 {
+		t.Errorf("expected slow consumer to be removed, got %d connections", hub.Len())
+	}
+	if !conn.IsClosed() {
+		t.Error("expected slow consumer connection to be closed")
+	}
+}
+
+func TestHub_Range(t *testing.T) {
+	hub := axon.NewHub[string](nil)
+	defer hub.Close()
+
+	ids := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		id, _, _ := newHubConn(t, hub)
+		ids[id] = true
+	}
+
+	seen := 0
+	hub.Range(func(id string, conn *axon.Conn[string]) bool {
+		if !ids[id] {
+			t.Errorf("unexpected id %q", id)
+		}
+		seen++
+		return true
+	})
+	if seen != 3 {
+		t.Errorf("expected to visit 3 connections, visited %d", seen)
+	}
+
+	seen = 0
+	hub.Range(func(id string, conn *axon.Conn[string]) bool {
+		seen++
+		return false
+	})
+	if seen != 1 {
+		t.Errorf("expected Range to stop after 1 connection, visited %d", seen)
+	}
+}
+
+func TestHub_Close(t *testing.T) {
+	hub := axon.NewHub[string](nil)
+
+	_, _, clientConn := newHubConn(t, hub)
+
+	closeCode := make(chan uint16, 1)
+	go func() {
+		opcode, payload, err := readServerFrame(clientConn)
+		if err == nil && opcode == 0x8 && len(payload) >= 2 {
+			closeCode <- binary.BigEndian.Uint16(payload)
+		}
+	}()
+
+	if err := hub.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+
+	select {
+	case code := <-closeCode:
+		if code != uint16(axon.CloseGoingAway) {
+			t.Errorf("expected close code 1001, got %d", code)
+		}
+	case <-time.After(time.Second):
+		t.Error("timeout waiting for close frame")
+	}
+
+	conn, _, err := axon.NewTestConn[string](nil)
+	if err != nil {
+		t.Fatalf("failed to create test connection: %v", err)
+	}
+	defer conn.Close(1000, "")
+
+	if _, err := hub.Register(conn); err != axon.ErrHubClosed {
+		t.Errorf("expected ErrHubClosed from Register, got %v", err)
+	}
+	if err := hub.Broadcast(context.Background(), "late"); err != axon.ErrHubClosed {
+		t.Errorf("expected ErrHubClosed from Broadcast, got %v", err)
+	}
+}
+
+func TestHub_Rooms(t *testing.T) {
+	hub := axon.NewHub[string](nil)
+	defer hub.Close()
+
+	alice, _, aliceConn := newHubConn(t, hub)
+	bob, _, bobConn := newHubConn(t, hub)
+
+	if err := hub.Join(alice, "general"); err != nil {
+		t.Fatalf("join failed: %v", err)
+	}
+	if err := hub.Join(alice, "random"); err != nil {
+		t.Fatalf("join failed: %v", err)
+	}
+	if err := hub.Join(bob, "general"); err != nil {
+		t.Fatalf("join failed: %v", err)
+	}
+	if err := hub.Join("missing", "general"); err != axon.ErrConnectionNotFound {
+		t.Errorf("expected ErrConnectionNotFound, got %v", err)
+	}
+
+	if n := hub.RoomLen("general"); n != 2 {
+		t.Errorf("expected 2 members in general, got %d", n)
+	}
+	rooms := hub.Rooms(alice)
+	sort.Strings(rooms)
+	if strings.Join(rooms, ",") != "general,random" {
+		t.Errorf("expected alice in general,random, got %v", rooms)
+	}
+
+	if err := hub.BroadcastToRoom(context.Background(), "random", "random only"); err != nil {
+		t.Fatalf("broadcast to room failed: %v", err)
+	}
+	if err := hub.BroadcastToRoom(context.Background(), "general", "everyone"); err != nil {
+		t.Fatalf("broadcast to room failed: %v", err)
+	}
+
+	// Alice gets both in order; Bob only sees the general message
+	if _, payload := readHubFrame(t, aliceConn); string(payload) != `"random only"` {
+		t.Errorf("alice: expected %q, got %q", `"random only"`, payload)
+	}
+	if _, payload := readHubFrame(t, aliceConn); string(payload) != `"everyone"` {
+		t.Errorf("alice: expected %q, got %q", `"everyone"`, payload)
+	}
+	if _, payload := readHubFrame(t, bobConn); string(payload) != `"everyone"` {
+		t.Errorf("bob: expected %q, got %q", `"everyone"`, payload)
+	}
+
+	if err := hub.Leave(alice, "random"); err != nil {
+		t.Fatalf("leave failed: %v", err)
+	}
+	if n := hub.RoomLen("random"); n != 0 {
+		t.Errorf("expected random to be empty, got %d members", n)
+	}
+
+	hub.Unregister(bob)
+	if members := hub.Members("general"); len(members) != 1 || members[0] != alice {
+		t.Errorf("expected only alice in general after unregister, got %v", members)
+	}
+}
+
+func TestHub_Drain(t *testing.T) {
+	hub := axon.NewHub[string](nil)
+	defer hub.Close()
+
+	id, conn, clientConn := newHubConn(t, hub)
+
+	hub.Send(context.Background(), id, "first")
+	hub.Send(context.Background(), id, "second")
+	if err := hub.Drain(id, axon.CloseGoingAway, "moving"); err != nil {
+		t.Fatalf("drain failed: %v", err)
+	}
+	if hub.Len() != 0 {
+		t.Errorf("expected drained connection to be removed, got %d connections", hub.Len())
+	}
+
+	// Queued messages are delivered before the close frame
+	for _, want := range []string{`"first"`, `"second"`} {
+		if opcode, payload := readHubFrame(t, clientConn); opcode != 0x1 || string(payload) != want {
+			t.Errorf("expected text frame %s, got opcode %d payload %q", want, opcode, payload)
+		}
+	}
+	opcode, payload := readHubFrame(t, clientConn)
+	if opcode != 0x8 || binary.BigEndian.Uint16(payload) != uint16(axon.CloseGoingAway) || string(payload[2:]) != "moving" {
+		t.Errorf("expected close frame 1001 moving, got opcode %d payload %q", opcode, payload)
+	}
+	if !conn.IsClosed() {
+		t.Error("expected drained connection to be closed")
+	}
+
+	if err := hub.Drain(id, axon.CloseGoingAway, ""); err != axon.ErrConnectionNotFound {
+		t.Errorf("expected ErrConnectionNotFound, got %v", err)
+	}
+}
+
+func TestHub_Ban(t *testing.T) {
+	hub := axon.NewHub[string](nil)
+	defer hub.Close()
+
+	_, banned, clientConn := newHubConn(t, hub)
+	banned.Set(axon.MetaClientIP, netip.MustParseAddr("10.0.0.1"))
+	_, other, _ := newHubConn(t, hub)
+	other.Set(axon.MetaClientIP, netip.MustParseAddr("10.0.0.2"))
+
+	if err := hub.Ban("10.0.0.1", time.Minute); err != nil {
+		t.Fatalf("ban failed: %v", err)
+	}
+
+	opcode, payload := readHubFrame(t, clientConn)
+	if opcode != 0x8 || binary.BigEndian.Uint16(payload) != uint16(axon.ClosePolicyViolation) {
+		t.Errorf("expected close frame 1008, got opcode %d payload %q", opcode, payload)
+	}
+	if hub.Len() != 1 {
+		t.Errorf("expected 1 connection left, got %d", hub.Len())
+	}
+	if isBanned, _ := hub.Bans().Banned("10.0.0.1"); !isBanned {
+		t.Error("expected ban to be recorded")
+	}
+
+	hub.Unban("10.0.0.1")
+	if isBanned, _ := hub.Bans().Banned("10.0.0.1"); isBanned {
+		t.Error("expected ban to be lifted")
+	}
+}
@@ -0,0 +1,72 @@
+package axon
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// SlowClientPolicy evicts server connections whose peer stops reading.
+// Without it a write to such a peer blocks until the write deadline.
+type SlowClientPolicy struct {
+	// WriteStall is how long a single write may block before the
+	// connection is closed with ClosePolicyViolation. Writes with a
+	// shorter deadline of their own fail without closing it.
+	WriteStall time.Duration
+}
+
+// startIdleTimer closes the connection with CloseGoingAway once no data
+// message was read or written for idleTimeout
+func (c *Conn[T]) startIdleTimer() {
+	if c.idleTimeout <= 0 {
+		return
+	}
+	c.touch()
+	c.idleTimer = c.clock().AfterFunc(c.idleTimeout, c.checkIdle)
+}
+
+// touch records data traffic on the connection
+func (c *Conn[T]) touch() {
+	if c.idleTimeout > 0 {
+		c.lastData.Store(c.clock().Now().UnixNano())
+	}
+}
+
+// checkIdle closes an idle connection, or waits for the rest of the idle
+// timeout since the last data message
+func (c *Conn[T]) checkIdle() {
+	if atomic.LoadInt32(&c.closed) != 0 {
+		return
+	}
+	idle := c.clock().Now().Sub(time.Unix(0, c.lastData.Load()))
+	if idle < c.idleTimeout {
+		c.idleTimer.Reset(c.idleTimeout - idle)
+		return
+	}
+	c.logger().Info("closing idle connection", "idle", idle)
+	c.Close(int(CloseGoingAway), "idle timeout")
+}
+
+// stallTimeout caps a write's deadline at the slow client threshold and
+// reports whether the threshold is what bounds it
+func (c *Conn[T]) stallTimeout(deadline time.Duration) (time.Duration, bool) {
+	if c.writeStall > 0 && c.writeStall < deadline {
+		return c.writeStall, true
+	}
+	return deadline, false
+}
+
+// evictStalled closes the connection with ClosePolicyViolation when a
+// write bounded by the slow client threshold timed out, and returns
+// ErrSlowConsumer in place of the timeout. It must be called without
+// writeMu held.
+func (c *Conn[T]) evictStalled(err error, stalled bool) error {
+	var netErr net.Error
+	if !stalled || !errors.As(err, &netErr) || !netErr.Timeout() {
+		return err
+	}
+	c.logger().Warn("evicting slow client", "stall", c.writeStall)
+	c.Close(int(ClosePolicyViolation), "slow consumer")
+	return ErrSlowConsumer
+}
@@ -0,0 +1,90 @@
+package axon_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+func TestConn_IdleTimeout(t *testing.T) {
+	conn, clientConn, err := axon.NewTestConn[string](&axon.UpgradeOptions{
+		IdleTimeout: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to create test connection: %v", err)
+	}
+	defer clientConn.Close()
+	go func() {
+		for {
+			if _, err := conn.Read(context.Background()); errors.Is(err, axon.ErrConnectionClosed) || conn.IsClosed() {
+				return
+			}
+		}
+	}()
+
+	// Data messages keep the connection open, pings do not
+	start := time.Now()
+	for range 3 {
+		time.Sleep(50 * time.Millisecond)
+		if err := writeClientFrame(clientConn, 0x1, []byte(`"hi"`)); err != nil {
+			t.Fatalf("write error = %v", err)
+		}
+	}
+	writeClientFrame(clientConn, 0x9, nil)
+	if opcode, _ := readHubFrame(t, clientConn); opcode != 0xA {
+		t.Fatalf("expected a pong, got opcode %d", opcode)
+	}
+
+	opcode, payload := readHubFrame(t, clientConn)
+	if opcode != 0x8 || binary.BigEndian.Uint16(payload) != uint16(axon.CloseGoingAway) {
+		t.Fatalf("expected a going away close frame, got opcode %d payload %q", opcode, payload)
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("closed after %v despite traffic", elapsed)
+	}
+	if conn.CloseReason() != "idle timeout" {
+		t.Errorf("CloseReason() = %q", conn.CloseReason())
+	}
+}
+
+func TestConn_SlowClientPolicy(t *testing.T) {
+	conn, clientConn, err := axon.NewTestConn[string](&axon.UpgradeOptions{
+		SlowClientPolicy: &axon.SlowClientPolicy{WriteStall: 50 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("failed to create test connection: %v", err)
+	}
+	defer clientConn.Close()
+
+	// The client never reads, so the write stalls on the pipe
+	err = conn.Write(context.Background(), "hello")
+	if !errors.Is(err, axon.ErrSlowConsumer) {
+		t.Fatalf("Write() error = %v, want ErrSlowConsumer", err)
+	}
+	if conn.CloseCode() != int(axon.ClosePolicyViolation) {
+		t.Errorf("CloseCode() = %d, want %d", conn.CloseCode(), axon.ClosePolicyViolation)
+	}
+
+	// A deadline shorter than the stall threshold only fails the write
+	conn2, clientConn2, err := axon.NewTestConn[string](&axon.UpgradeOptions{
+		SlowClientPolicy: &axon.SlowClientPolicy{WriteStall: time.Second},
+	})
+	if err != nil {
+		t.Fatalf("failed to create test connection: %v", err)
+	}
+	defer clientConn2.Close()
+	defer conn2.Close(1000, "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := conn2.Write(ctx, "hello"); err == nil || errors.Is(err, axon.ErrSlowConsumer) {
+		t.Fatalf("Write() error = %v, want a timeout", err)
+	}
+	if conn2.IsClosed() {
+		t.Error("connection closed by a write that hit its own deadline")
+	}
+}
@@ -0,0 +1,300 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/kolosys/axon"
+)
+
+// Client calls methods of a JSON-RPC server. It is safe for concurrent
+// use; calls on the same connection are matched to their responses by ID.
+type Client struct {
+	write func(ctx context.Context, msg json.RawMessage) error
+	stop  func() error
+
+	mu             sync.Mutex
+	nextID         uint64
+	pending        map[string]*pendingCall
+	onNotification func(method string, params json.RawMessage)
+}
+
+// pendingCall is a call awaiting its response
+type pendingCall struct {
+	resp *Response
+	err  error
+	done chan struct{}
+}
+
+// BatchCall is one call of a batch. After Batch returns, Error holds the
+// call's error and Result the decoded result.
+type BatchCall struct {
+	Method string
+	Params any
+
+	// Result receives the decoded result; nil discards it
+	Result any
+
+	// Notification sends the call without an ID and expects no response
+	Notification bool
+
+	// Error is the error object returned by the server, or the error
+	// decoding the result
+	Error error
+}
+
+// NewClient returns a client calling over conn. The client owns reading
+// from the connection; do not call Read on it while the client is in use.
+func NewClient(conn *axon.Conn[json.RawMessage]) *Client {
+	c := &Client{
+		write: func(ctx context.Context, msg json.RawMessage) error {
+			return conn.Write(ctx, msg)
+		},
+		stop: func() error {
+			return conn.Close(int(axon.CloseNormalClosure), "")
+		},
+	}
+	go c.readLoop(conn)
+	return c
+}
+
+// NewReconnectingClient returns a client calling over client, which must
+// be connected with ConnectWithReadLoop. It takes over the client's
+// OnMessage callback. Pending calls fail when the connection is lost, and
+// new calls are queued like other writes while the client reconnects.
+func NewReconnectingClient(client *axon.Client[json.RawMessage]) *Client {
+	c := &Client{
+		write: client.Write,
+		stop:  client.Close,
+	}
+	client.OnMessage(c.dispatch)
+	client.OnStateChange(func(change axon.StateChange) {
+		if change.From == axon.StateConnected {
+			err := change.Err
+			if err == nil {
+				err = axon.ErrConnectionClosed
+			}
+			c.failPending(err)
+		}
+	})
+	return c
+}
+
+// OnNotification sets the callback for notifications sent by the server
+func (c *Client) OnNotification(fn func(method string, params json.RawMessage)) {
+	c.mu.Lock()
+	c.onNotification = fn
+	c.mu.Unlock()
+}
+
+// Call calls method with params and decodes its result into result, unless
+// result is nil. An error object returned by the server is returned as an
+// *Error.
+func (c *Client) Call(ctx context.Context, method string, params, result any) error {
+	call := &BatchCall{Method: method, Params: params, Result: result}
+	if err := c.send(ctx, []*BatchCall{call}, false); err != nil {
+		return err
+	}
+	return call.Error
+}
+
+// Notify sends a notification, which the server does not answer
+func (c *Client) Notify(ctx context.Context, method string, params any) error {
+	return c.send(ctx, []*BatchCall{{Method: method, Params: params, Notification: true}}, false)
+}
+
+// Batch sends calls as one batch and waits for all their responses. The
+// returned error is for the batch as a whole; the outcome of each call is
+// in its Error field.
+func (c *Client) Batch(ctx context.Context, calls ...*BatchCall) error {
+	if len(calls) == 0 {
+		return nil
+	}
+	return c.send(ctx, calls, true)
+}
+
+// Close closes the connection, failing pending calls
+func (c *Client) Close() error {
+	c.failPending(axon.ErrConnectionClosed)
+	return c.stop()
+}
+
+// Pending returns the number of calls awaiting a response
+func (c *Client) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending)
+}
+
+// send writes calls, as a batch if batch is set, and waits for the
+// responses to those that are not notifications
+func (c *Client) send(ctx context.Context, calls []*BatchCall, batch bool) error {
+	reqs := make([]Request, len(calls))
+	waiting := make(map[string]*pendingCall)
+	keys := make([]string, len(calls))
+	for i, call := range calls {
+		req := Request{JSONRPC: Version, Method: call.Method}
+		if call.Params != nil {
+			params, err := json.Marshal(call.Params)
+			if err != nil {
+				return err
+			}
+			req.Params = params
+		}
+		reqs[i] = req
+	}
+
+	c.mu.Lock()
+	if c.pending == nil {
+		c.pending = make(map[string]*pendingCall)
+	}
+	for i, call := range calls {
+		if call.Notification {
+			continue
+		}
+		c.nextID++
+		key := strconv.FormatUint(c.nextID, 10)
+		reqs[i].ID = json.RawMessage(key)
+		keys[i] = key
+		pc := &pendingCall{done: make(chan struct{})}
+		c.pending[key] = pc
+		waiting[key] = pc
+	}
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		for key, pc := range waiting {
+			if c.pending[key] == pc {
+				delete(c.pending, key)
+			}
+		}
+		c.mu.Unlock()
+	}()
+
+	var msg []byte
+	var err error
+	if batch {
+		msg, err = json.Marshal(reqs)
+	} else {
+		msg, err = json.Marshal(reqs[0])
+	}
+	if err != nil {
+		return err
+	}
+	if err := c.write(ctx, msg); err != nil {
+		return err
+	}
+
+	for i, call := range calls {
+		pc := waiting[keys[i]]
+		if pc == nil {
+			continue
+		}
+		select {
+		case <-pc.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if pc.err != nil {
+			return pc.err
+		}
+		call.Error = decodeResponse(pc.resp, call.Result)
+	}
+	return nil
+}
+
+// decodeResponse returns the error of resp, or decodes its result
+func decodeResponse(resp *Response, result any) error {
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if result == nil || len(resp.Result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(resp.Result, result)
+}
+
+// readLoop dispatches messages read from conn until it fails
+func (c *Client) readLoop(conn *axon.Conn[json.RawMessage]) {
+	for {
+		msg, err := conn.Read(context.Background())
+		if err != nil {
+			// Idle connections hit the default read deadline
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			c.failPending(err)
+			return
+		}
+		c.dispatch(msg)
+	}
+}
+
+// dispatch delivers the responses and notifications of a message
+func (c *Client) dispatch(msg json.RawMessage) {
+	if !isBatch(msg) {
+		c.dispatchOne(msg)
+		return
+	}
+	var batch []json.RawMessage
+	if err := json.Unmarshal(msg, &batch); err != nil {
+		return
+	}
+	for _, raw := range batch {
+		c.dispatchOne(raw)
+	}
+}
+
+// dispatchOne delivers a single response or notification. Malformed
+// messages, responses matching no call and requests from the server are
+// dropped.
+func (c *Client) dispatchOne(raw json.RawMessage) {
+	var msg struct {
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params"`
+		Response
+	}
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		return
+	}
+
+	if msg.Method != "" {
+		c.mu.Lock()
+		fn := c.onNotification
+		c.mu.Unlock()
+		if msg.ID == nil && fn != nil {
+			fn(msg.Method, msg.Params)
+		}
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := string(msg.ID)
+	pc, ok := c.pending[key]
+	if !ok {
+		return
+	}
+	delete(c.pending, key)
+	resp := msg.Response
+	pc.resp = &resp
+	close(pc.done)
+}
+
+// failPending completes all pending calls with err
+func (c *Client) failPending(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, pc := range c.pending {
+		delete(c.pending, key)
+		pc.err = err
+		close(pc.done)
+	}
+}
@@ -0,0 +1,175 @@
+package jsonrpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+	"github.com/kolosys/axon/jsonrpc"
+)
+
+// serve starts a server for s that notifies each client of "welcome"
+// before serving it
+func serve(t *testing.T, s *jsonrpc.Server) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[json.RawMessage](w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close(1000, "")
+		conn.Write(r.Context(), json.RawMessage(`{"jsonrpc":"2.0","method":"welcome","params":["hi"]}`))
+		s.ServeConn(r.Context(), conn)
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func TestClient_Call(t *testing.T) {
+	logged := make(chan string, 1)
+	url := serve(t, newServer(logged))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := axon.Dial[json.RawMessage](ctx, url, nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	notifications := make(chan string, 1)
+	client := jsonrpc.NewClient(conn)
+	client.OnNotification(func(method string, params json.RawMessage) {
+		notifications <- method + string(params)
+	})
+	defer client.Close()
+
+	var sum int
+	if err := client.Call(ctx, "sum", sumParams{A: 2, B: 3}, &sum); err != nil || sum != 5 {
+		t.Fatalf("Call(sum) = %d, %v; want 5", sum, err)
+	}
+
+	err = client.Call(ctx, "fail", []int{}, nil)
+	var rpcErr *jsonrpc.Error
+	if !errors.As(err, &rpcErr) || rpcErr.Code != -32000 || string(rpcErr.Data) != `{"retry":5}` {
+		t.Fatalf("Call(fail) error = %v, want the server's error object", err)
+	}
+
+	if err := client.Notify(ctx, "log", "note"); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+	if got := receive(t, logged); got != "note" {
+		t.Errorf("logged %q, want %q", got, "note")
+	}
+	if got := receive(t, notifications); got != `welcome["hi"]` {
+		t.Errorf("notification = %q", got)
+	}
+	if n := client.Pending(); n != 0 {
+		t.Errorf("Pending() = %d, want 0", n)
+	}
+}
+
+func TestClient_Batch(t *testing.T) {
+	logged := make(chan string, 1)
+	url := serve(t, newServer(logged))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := axon.Dial[json.RawMessage](ctx, url, nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	client := jsonrpc.NewClient(conn)
+	defer client.Close()
+
+	var a, b int
+	calls := []*jsonrpc.BatchCall{
+		{Method: "sum", Params: sumParams{A: 1, B: 1}, Result: &a},
+		{Method: "log", Params: "batched", Notification: true},
+		{Method: "nope"},
+		{Method: "sum", Params: sumParams{A: 2, B: 2}, Result: &b},
+	}
+	if err := client.Batch(ctx, calls...); err != nil {
+		t.Fatalf("Batch() error = %v", err)
+	}
+	if a != 2 || b != 4 || calls[0].Error != nil || calls[3].Error != nil {
+		t.Errorf("sums = %d, %d with errors %v, %v", a, b, calls[0].Error, calls[3].Error)
+	}
+	var rpcErr *jsonrpc.Error
+	if !errors.As(calls[2].Error, &rpcErr) || rpcErr.Code != jsonrpc.CodeMethodNotFound {
+		t.Errorf("unknown method error = %v, want method not found", calls[2].Error)
+	}
+	if got := receive(t, logged); got != "batched" {
+		t.Errorf("logged %q, want %q", got, "batched")
+	}
+}
+
+func TestClient_ConnectionLost(t *testing.T) {
+	s := jsonrpc.NewServer()
+	s.Register("hang", func(ctx context.Context, params json.RawMessage) (any, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	url := serve(t, s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := axon.Dial[json.RawMessage](ctx, url, nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	client := jsonrpc.NewClient(conn)
+
+	done := make(chan error, 1)
+	go func() { done <- client.Call(ctx, "hang", nil, nil) }()
+	for client.Pending() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	client.Close()
+
+	if err := receive(t, done); !errors.Is(err, axon.ErrConnectionClosed) {
+		t.Errorf("Call() error = %v, want ErrConnectionClosed", err)
+	}
+}
+
+func TestReconnectingClient(t *testing.T) {
+	url := serve(t, newServer(make(chan string, 1)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ac := axon.NewClient[json.RawMessage](url, nil)
+	client := jsonrpc.NewReconnectingClient(ac)
+	notifications := make(chan string, 1)
+	client.OnNotification(func(method string, params json.RawMessage) {
+		notifications <- method
+	})
+	if err := ac.ConnectWithReadLoop(ctx); err != nil {
+		t.Fatalf("ConnectWithReadLoop() error = %v", err)
+	}
+	defer client.Close()
+
+	var sum int
+	if err := client.Call(ctx, "sum", sumParams{A: 4, B: 5}, &sum); err != nil || sum != 9 {
+		t.Fatalf("Call(sum) = %d, %v; want 9", sum, err)
+	}
+	if got := receive(t, notifications); got != "welcome" {
+		t.Errorf("notification = %q, want %q", got, "welcome")
+	}
+}
+
+// receive returns the next value from ch, failing the test after a second
+func receive[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for value")
+		var zero T
+		return zero
+	}
+}
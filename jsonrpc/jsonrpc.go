@@ -0,0 +1,93 @@
+// Package jsonrpc implements JSON-RPC 2.0 over axon connections. A Server
+// dispatches requests, notifications and batches to registered methods; a
+// Client calls them over an axon Conn or a reconnecting axon Client.
+// Messages travel as text frames, one request, response or batch each.
+package jsonrpc
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Version is the protocol version carried in every message
+const Version = "2.0"
+
+// Error codes defined by the specification. Codes from -32000 to -32099
+// are reserved for implementation-defined server errors.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+)
+
+// Request is a request or, without an ID, a notification
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+// IsNotification reports whether the request expects no response
+func (r *Request) IsNotification() bool {
+	return r.ID == nil
+}
+
+// Response is the reply to a request, holding either a result or an error
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// Error is a JSON-RPC error object. Handlers return one to choose the code
+// sent to the caller; Client calls return the one the server sent.
+type Error struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// NewError returns an error object, with data encoded as JSON if not nil
+func NewError(code int, message string, data any) *Error {
+	e := &Error{Code: code, Message: message}
+	if data != nil {
+		if raw, err := json.Marshal(data); err == nil {
+			e.Data = raw
+		}
+	}
+	return e
+}
+
+// Error returns the error message
+func (e *Error) Error() string {
+	return fmt.Sprintf("jsonrpc: %s (%d)", e.Message, e.Code)
+}
+
+// nullID identifies responses to requests whose ID could not be read
+var nullID = json.RawMessage("null")
+
+// errorResponse returns a response carrying an error object
+func errorResponse(id json.RawMessage, code int, message string) *Response {
+	if id == nil {
+		id = nullID
+	}
+	return &Response{JSONRPC: Version, Error: &Error{Code: code, Message: message}, ID: id}
+}
+
+// isBatch reports whether a message is a JSON array
+func isBatch(data []byte) bool {
+	for _, b := range data {
+		switch b {
+		case ' ', '\t', '\n', '\r':
+			continue
+		case '[':
+			return true
+		}
+		return false
+	}
+	return false
+}
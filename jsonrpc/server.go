@@ -0,0 +1,174 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/kolosys/axon"
+)
+
+// Handler handles calls of a method, returning the result to encode as
+// JSON. Returning an *Error sends it as is; other errors are sent as
+// internal errors with their message.
+type Handler func(ctx context.Context, params json.RawMessage) (any, error)
+
+// Method adapts a function taking decoded params to a Handler. Params
+// that do not decode into P are answered with an invalid params error.
+func Method[P, R any](fn func(ctx context.Context, params P) (R, error)) Handler {
+	return func(ctx context.Context, raw json.RawMessage) (any, error) {
+		var params P
+		if len(raw) > 0 {
+			if err := json.Unmarshal(raw, &params); err != nil {
+				return nil, &Error{Code: CodeInvalidParams, Message: err.Error()}
+			}
+		}
+		return fn(ctx, params)
+	}
+}
+
+// Server dispatches JSON-RPC messages to registered methods. It is safe
+// for concurrent use and may serve any number of connections.
+type Server struct {
+	mu      sync.RWMutex
+	methods map[string]Handler
+}
+
+// NewServer returns a server without methods
+func NewServer() *Server {
+	return &Server{methods: make(map[string]Handler)}
+}
+
+// Register sets the handler of a method, replacing any previous one. A
+// nil handler removes the method.
+func (s *Server) Register(method string, h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h == nil {
+		delete(s.methods, method)
+		return
+	}
+	s.methods[method] = h
+}
+
+// Handle processes a request, notification or batch and returns the
+// message to reply with, or nil when there is none. The calls of a batch
+// run concurrently.
+func (s *Server) Handle(ctx context.Context, data []byte) []byte {
+	if !isBatch(data) {
+		resp := s.handleOne(ctx, data)
+		if resp == nil {
+			return nil
+		}
+		out, _ := json.Marshal(resp)
+		return out
+	}
+
+	var batch []json.RawMessage
+	if err := json.Unmarshal(data, &batch); err != nil {
+		out, _ := json.Marshal(errorResponse(nil, CodeParseError, "parse error"))
+		return out
+	}
+	if len(batch) == 0 {
+		out, _ := json.Marshal(errorResponse(nil, CodeInvalidRequest, "empty batch"))
+		return out
+	}
+
+	responses := make([]*Response, len(batch))
+	var wg sync.WaitGroup
+	for i, raw := range batch {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			responses[i] = s.handleOne(ctx, raw)
+		}()
+	}
+	wg.Wait()
+
+	replies := make([]*Response, 0, len(responses))
+	for _, resp := range responses {
+		if resp != nil {
+			replies = append(replies, resp)
+		}
+	}
+	if len(replies) == 0 {
+		return nil
+	}
+	out, _ := json.Marshal(replies)
+	return out
+}
+
+// handleOne processes a single request, returning nil for notifications
+func (s *Server) handleOne(ctx context.Context, raw json.RawMessage) *Response {
+	var req Request
+	if err := json.Unmarshal(raw, &req); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return errorResponse(nil, CodeParseError, "parse error")
+		}
+		return errorResponse(nil, CodeInvalidRequest, "invalid request")
+	}
+	if req.JSONRPC != Version || req.Method == "" {
+		return errorResponse(req.ID, CodeInvalidRequest, "invalid request")
+	}
+
+	s.mu.RLock()
+	h := s.methods[req.Method]
+	s.mu.RUnlock()
+
+	if h == nil {
+		if req.IsNotification() {
+			return nil
+		}
+		return errorResponse(req.ID, CodeMethodNotFound, "method not found")
+	}
+
+	result, err := h(ctx, req.Params)
+	if req.IsNotification() {
+		return nil
+	}
+	if err != nil {
+		var rpcErr *Error
+		if errors.As(err, &rpcErr) {
+			return &Response{JSONRPC: Version, Error: rpcErr, ID: req.ID}
+		}
+		return errorResponse(req.ID, CodeInternalError, err.Error())
+	}
+
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		return errorResponse(req.ID, CodeInternalError, err.Error())
+	}
+	return &Response{JSONRPC: Version, Result: encoded, ID: req.ID}
+}
+
+// ServeConn reads messages from conn and replies to them until the
+// connection fails or ctx is done, returning the error that stopped it.
+// Each message is handled in its own goroutine, so replies may be sent out
+// of order; ServeConn returns once all of them are sent.
+func (s *Server) ServeConn(ctx context.Context, conn *axon.Conn[json.RawMessage]) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		msg, err := conn.Read(ctx)
+		if err != nil {
+			// Idle connections hit the default read deadline
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() && ctx.Err() == nil {
+				continue
+			}
+			return err
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if reply := s.Handle(ctx, msg); reply != nil {
+				conn.Write(ctx, reply)
+			}
+		}()
+	}
+}
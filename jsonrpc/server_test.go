@@ -0,0 +1,95 @@
+package jsonrpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/kolosys/axon/jsonrpc"
+)
+
+type sumParams struct {
+	A, B int
+}
+
+// newServer returns a server with sum, fail and log methods; log records
+// its params in logged
+func newServer(logged chan<- string) *jsonrpc.Server {
+	s := jsonrpc.NewServer()
+	s.Register("sum", jsonrpc.Method(func(ctx context.Context, p sumParams) (int, error) {
+		return p.A + p.B, nil
+	}))
+	s.Register("fail", func(ctx context.Context, params json.RawMessage) (any, error) {
+		if len(params) > 0 {
+			return nil, jsonrpc.NewError(-32000, "custom failure", map[string]int{"retry": 5})
+		}
+		return nil, errors.New("plain failure")
+	})
+	s.Register("log", jsonrpc.Method(func(ctx context.Context, msg string) (any, error) {
+		logged <- msg
+		return nil, nil
+	}))
+	return s
+}
+
+func TestServer_Handle(t *testing.T) {
+	s := newServer(make(chan string, 4))
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"call", `{"jsonrpc":"2.0","method":"sum","params":{"A":1,"B":2},"id":1}`,
+			`{"jsonrpc":"2.0","result":3,"id":1}`},
+		{"string id", `{"jsonrpc":"2.0","method":"sum","params":{"A":1,"B":2},"id":"a"}`,
+			`{"jsonrpc":"2.0","result":3,"id":"a"}`},
+		{"null result", `{"jsonrpc":"2.0","method":"log","params":"x","id":2}`,
+			`{"jsonrpc":"2.0","result":null,"id":2}`},
+		{"notification", `{"jsonrpc":"2.0","method":"log","params":"x"}`, ``},
+		{"unknown notification", `{"jsonrpc":"2.0","method":"nope"}`, ``},
+		{"parse error", `{"jsonrpc":"2.0","method"`,
+			`{"jsonrpc":"2.0","error":{"code":-32700,"message":"parse error"},"id":null}`},
+		{"invalid request", `{"jsonrpc":"1.0","method":"sum","id":3}`,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid request"},"id":3}`},
+		{"method not found", `{"jsonrpc":"2.0","method":"nope","id":4}`,
+			`{"jsonrpc":"2.0","error":{"code":-32601,"message":"method not found"},"id":4}`},
+		{"invalid params", `{"jsonrpc":"2.0","method":"log","params":5,"id":5}`,
+			`{"jsonrpc":"2.0","error":{"code":-32602,"message":"json: cannot unmarshal number into Go value of type string"},"id":5}`},
+		{"internal error", `{"jsonrpc":"2.0","method":"fail","id":6}`,
+			`{"jsonrpc":"2.0","error":{"code":-32603,"message":"plain failure"},"id":6}`},
+		{"error object", `{"jsonrpc":"2.0","method":"fail","params":[],"id":7}`,
+			`{"jsonrpc":"2.0","error":{"code":-32000,"message":"custom failure","data":{"retry":5}},"id":7}`},
+		{"empty batch", `[]`,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"empty batch"},"id":null}`},
+		{"invalid batch element", `[1]`,
+			`[{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid request"},"id":null}]`},
+		{"batch", `[{"jsonrpc":"2.0","method":"sum","params":{"A":1},"id":1},{"jsonrpc":"2.0","method":"log","params":"x"},{"jsonrpc":"2.0","method":"nope","id":2}]`,
+			`[{"jsonrpc":"2.0","result":1,"id":1},{"jsonrpc":"2.0","error":{"code":-32601,"message":"method not found"},"id":2}]`},
+		{"batch of notifications", `[{"jsonrpc":"2.0","method":"log","params":"x"}]`, ``},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.Handle(ctx, []byte(tt.in)); string(got) != tt.want {
+				t.Errorf("Handle() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_Register(t *testing.T) {
+	s := newServer(make(chan string, 1))
+	s.Register("sum", nil)
+
+	got := s.Handle(context.Background(), []byte(`{"jsonrpc":"2.0","method":"sum","id":1}`))
+	var resp jsonrpc.Response
+	if err := json.Unmarshal(got, &resp); err != nil {
+		t.Fatalf("Handle() = %s: %v", got, err)
+	}
+	if resp.Error == nil || resp.Error.Code != jsonrpc.CodeMethodNotFound {
+		t.Errorf("removed method response = %s, want method not found", got)
+	}
+}
@@ -0,0 +1,42 @@
+package axon
+
+import "time"
+
+// DeadlineExtension selects which received frames push back the read
+// deadline of a connection that sends pings
+type DeadlineExtension int
+
+const (
+	// ExtendNever sets the read deadline once per Read
+	ExtendNever DeadlineExtension = iota
+
+	// ExtendOnPong extends the read deadline whenever a pong arrives, so a
+	// peer answering pings is never timed out while the application waits
+	// for messages
+	ExtendOnPong
+
+	// ExtendOnFrame extends the read deadline whenever any frame arrives
+	ExtendOnFrame
+)
+
+// heartbeatWindow returns how long the peer may stay silent before a read
+// times out when the heartbeat extends the deadline, or 0 when it does not
+func (c *Conn[T]) heartbeatWindow() time.Duration {
+	if c.extendDeadline == ExtendNever || c.pingInterval <= 0 {
+		return 0
+	}
+	return c.pingInterval + c.pongTimeout
+}
+
+// extendReadDeadline pushes back the read deadline by window after a frame
+// with the given opcode arrives, never past limit unless it is zero
+func (c *Conn[T]) extendReadDeadline(opcode byte, window time.Duration, limit time.Time) error {
+	if c.extendDeadline == ExtendOnPong && opcode != opPong {
+		return nil
+	}
+	deadline := time.Now().Add(window)
+	if !limit.IsZero() && limit.Before(deadline) {
+		deadline = limit
+	}
+	return c.conn.SetReadDeadline(deadline)
+}
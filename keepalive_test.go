@@ -0,0 +1,81 @@
+package axon_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+func TestConn_ExtendReadDeadlineOnPong(t *testing.T) {
+	conn, clientConn, err := axon.NewTestConn[string](&axon.UpgradeOptions{
+		PingInterval:       20 * time.Millisecond,
+		PongTimeout:        20 * time.Millisecond,
+		ExtendReadDeadline: axon.ExtendOnPong,
+	})
+	if err != nil {
+		t.Fatalf("failed to create test connection: %v", err)
+	}
+	defer conn.Close(1000, "")
+	defer clientConn.Close()
+
+	// The client answers pings until told to stop
+	var writeMu sync.Mutex
+	var answer atomic.Bool
+	answer.Store(true)
+	go func() {
+		for {
+			opcode, payload, err := readServerFrame(clientConn)
+			if err != nil {
+				return
+			}
+			if opcode == 0x9 && answer.Load() {
+				writeMu.Lock()
+				writeClientFrame(clientConn, 0xA, payload)
+				writeMu.Unlock()
+			}
+		}
+	}()
+
+	type result struct {
+		msg string
+		err error
+	}
+	results := make(chan result, 1)
+	read := func() {
+		msg, err := conn.Read(context.Background())
+		results <- result{msg, err}
+	}
+
+	// The read outlives the 40ms window because pongs keep extending it
+	go read()
+	time.Sleep(200 * time.Millisecond)
+	writeMu.Lock()
+	writeClientFrame(clientConn, 0x1, []byte(`"hi"`))
+	writeMu.Unlock()
+	if r := <-results; r.err != nil || r.msg != "hi" {
+		t.Fatalf("Read() = %q, %v, want hi", r.msg, r.err)
+	}
+
+	// Once pongs stop the read times out within the window
+	answer.Store(false)
+	start := time.Now()
+	go read()
+	select {
+	case r := <-results:
+		var netErr net.Error
+		if !errors.As(r.err, &netErr) || !netErr.Timeout() {
+			t.Errorf("Read() error = %v, want a timeout", r.err)
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("read timed out after %v", elapsed)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("read did not time out after pongs stopped")
+	}
+}
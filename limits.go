@@ -0,0 +1,81 @@
+package axon
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// connLimits tracks the open connections of an Upgrader against its limits
+type connLimits struct {
+	maxConnections int
+	maxPerIP       int
+	retryAfter     time.Duration
+
+	mu    sync.Mutex
+	total int
+	perIP map[string]int
+}
+
+// acquire reserves a connection slot for a client at ip, returning a
+// function that frees it. When a limit is reached it sets Retry-After on
+// header and returns ErrTooManyConnections.
+func (l *connLimits) acquire(header http.Header, ip string) (func(), error) {
+	if l.maxConnections <= 0 && l.maxPerIP <= 0 {
+		return func() {}, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if (l.maxConnections > 0 && l.total >= l.maxConnections) ||
+		(l.maxPerIP > 0 && l.perIP[ip] >= l.maxPerIP) {
+		setRetryAfter(header, l.retryAfter)
+		return nil, ErrTooManyConnections
+	}
+
+	l.total++
+	if l.maxPerIP > 0 {
+		if l.perIP == nil {
+			l.perIP = make(map[string]int)
+		}
+		l.perIP[ip]++
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { l.release(ip) })
+	}, nil
+}
+
+// release frees a slot acquired for ip
+func (l *connLimits) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.total--
+	if l.maxPerIP > 0 {
+		if l.perIP[ip]--; l.perIP[ip] <= 0 {
+			delete(l.perIP, ip)
+		}
+	}
+}
+
+// remoteIP returns the IP part of the request's remote address
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// setRetryAfter sets the Retry-After header to d rounded up to whole seconds
+func setRetryAfter(header http.Header, d time.Duration) {
+	if d < time.Second {
+		d = time.Second
+	}
+	header.Set("Retry-After", strconv.Itoa(int((d+time.Second-1)/time.Second)))
+}
@@ -0,0 +1,100 @@
+package axon_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+// upgradeRequest returns a valid upgrade request from remoteAddr
+func upgradeRequest(remoteAddr string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = remoteAddr
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	return req
+}
+
+func TestUpgrader_MaxConnectionsPerIP(t *testing.T) {
+	u := axon.NewUpgrader(&axon.UpgradeOptions{
+		MaxConnectionsPerIP: 1,
+		RetryAfter:          5 * time.Second,
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.UpgradeWith[string](u, w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close(1000, "")
+		conn.Read(context.Background())
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	first, err := axon.Dial[string](ctx, url, nil)
+	if err != nil {
+		t.Fatalf("first Dial() error = %v", err)
+	}
+
+	if _, err := axon.Dial[string](ctx, url, nil); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Fatalf("expected second dial from the same IP to fail with 503, got %v", err)
+	}
+
+	// Closing the first connection frees its slot
+	first.Close(1000, "")
+	deadline := time.Now().Add(time.Second)
+	for {
+		conn, err := axon.Dial[string](ctx, url, nil)
+		if err == nil {
+			conn.Close(1000, "")
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected dial to succeed after the first connection closed, got %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestUpgrader_MaxConnectionsRetryAfter(t *testing.T) {
+	h := axon.Handler[string](&axon.UpgradeOptions{
+		MaxConnections: 1,
+		RetryAfter:     1500 * time.Millisecond,
+	}, func(ctx context.Context, conn *axon.Conn[string]) {
+		conn.Read(ctx)
+	})
+
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	conn, err := axon.Dial[string](ctx, "ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close(1000, "")
+
+	// A different client IP is still subject to the global limit
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, upgradeRequest("203.0.113.7:4000"))
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("expected Retry-After 2, got %q", got)
+	}
+}
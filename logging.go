@@ -0,0 +1,28 @@
+package axon
+
+import "log/slog"
+
+// discardLogger is used wherever no Logger is configured
+var discardLogger = slog.New(slog.DiscardHandler)
+
+// loggerOr returns l, or a logger discarding every record if l is nil
+func loggerOr(l *slog.Logger) *slog.Logger {
+	if l == nil {
+		return discardLogger
+	}
+	return l
+}
+
+// connLogger returns the logger of connection id, whose records carry the
+// connection ID
+func connLogger(l *slog.Logger, id string) *slog.Logger {
+	if l == nil {
+		return discardLogger
+	}
+	return l.With("conn_id", id)
+}
+
+// logger returns the connection's logger
+func (c *Conn[T]) logger() *slog.Logger {
+	return loggerOr(c.log)
+}
@@ -0,0 +1,140 @@
+package axon_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+// logBuffer collects JSON log records written concurrently
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// logger returns a logger recording every level into b
+func (b *logBuffer) logger() *slog.Logger {
+	return slog.New(slog.NewJSONHandler(b, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+// records returns the records logged with msg
+func (b *logBuffer) records(t *testing.T, msg string) []map[string]any {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid log record %q: %v", line, err)
+		}
+		if record["msg"] == msg {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+func TestUpgrader_LogsRejectedUpgrade(t *testing.T) {
+	var logs logBuffer
+	u := axon.NewUpgrader(&axon.UpgradeOptions{
+		Logger:      logs.logger(),
+		CheckOrigin: func(r *http.Request) bool { return false },
+	})
+
+	req := upgradeRequest("192.0.2.1:1234")
+	req.Header.Set("Origin", "http://evil.example")
+	if _, err := axon.UpgradeWith[string](u, httptest.NewRecorder(), req); err != axon.ErrInvalidOrigin {
+		t.Fatalf("UpgradeWith() error = %v, want ErrInvalidOrigin", err)
+	}
+
+	records := logs.records(t, "upgrade rejected")
+	if len(records) != 1 {
+		t.Fatalf("got %d upgrade rejected records, want 1", len(records))
+	}
+	if records[0]["level"] != "INFO" || records[0]["remote_addr"] != "192.0.2.1:1234" || records[0]["error"] != axon.ErrInvalidOrigin.Error() {
+		t.Errorf("record = %v", records[0])
+	}
+}
+
+func TestClient_LogsReconnection(t *testing.T) {
+	var conns atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[string](w, r, nil)
+		if err != nil {
+			return
+		}
+		if conns.Add(1) == 1 {
+			conn.Close(int(axon.CloseGoingAway), "restarting")
+			return
+		}
+		defer conn.Close(1000, "done")
+		conn.Read(r.Context())
+	}))
+	defer server.Close()
+
+	var logs logBuffer
+	reconnected := make(chan struct{}, 1)
+
+	opts := axon.DefaultClientOptions()
+	opts.Logger = logs.logger()
+	opts.Reconnect.InitialDelay = 10 * time.Millisecond
+	opts.Reconnect.Jitter = false
+	opts.Reconnect.OnReconnected = func(int) { reconnected <- struct{}{} }
+	client := axon.NewClient[string]("ws"+strings.TrimPrefix(server.URL, "http"), opts)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.ConnectWithReadLoop(ctx); err != nil {
+		t.Fatalf("ConnectWithReadLoop() error = %v", err)
+	}
+	select {
+	case <-reconnected:
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for reconnection")
+	}
+
+	lost := logs.records(t, "connection lost")
+	if len(lost) != 1 || lost[0]["level"] != "WARN" || lost[0]["reconnect"] != true {
+		t.Errorf("connection lost records = %v", lost)
+	}
+	reconnecting := logs.records(t, "reconnecting")
+	if len(reconnecting) != 1 || reconnecting[0]["attempt"] != float64(1) {
+		t.Errorf("reconnecting records = %v", reconnecting)
+	}
+	if n := len(logs.records(t, "reconnected")); n != 1 {
+		t.Errorf("got %d reconnected records, want 1", n)
+	}
+
+	dialed := logs.records(t, "connection dialed")
+	if len(dialed) != 2 {
+		t.Fatalf("got %d connection dialed records, want 2", len(dialed))
+	}
+	if dialed[0]["conn_id"] == nil || dialed[0]["conn_id"] == dialed[1]["conn_id"] {
+		t.Errorf("dialed connections have conn_id %v and %v, want distinct IDs", dialed[0]["conn_id"], dialed[1]["conn_id"])
+	}
+	if id := client.Conn().ID(); dialed[1]["conn_id"] != id {
+		t.Errorf("conn_id = %v, want %q", dialed[1]["conn_id"], id)
+	}
+}
@@ -0,0 +1,80 @@
+package axon
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+)
+
+// maskKeys hands out client mask keys from a buffer filled from
+// crypto/rand, so that masking a frame neither allocates nor reads
+// randomness every time. It is not safe for concurrent use.
+type maskKeys struct {
+	buf  [128]byte
+	left int // Unused bytes at the end of buf
+}
+
+// next copies a fresh mask key into key
+func (k *maskKeys) next(key []byte) error {
+	if k.left == 0 {
+		if _, err := rand.Read(k.buf[:]); err != nil {
+			return fmt.Errorf("axon: failed to generate mask key: %w", err)
+		}
+		k.left = len(k.buf)
+	}
+	copy(key, k.buf[len(k.buf)-k.left:])
+	k.left -= 4
+	return nil
+}
+
+// maskBytes applies XOR masking to payload (RFC 6455 Section 5.3)
+func maskBytes(payload []byte, mask []byte) {
+	maskBytesPos(payload, mask, 0)
+}
+
+// maskBytesPos masks payload as if it started at byte offset pos of the frame
+// payload, so a payload can be masked in chunks. It returns the offset
+// following the last masked byte.
+func maskBytesPos(payload []byte, mask []byte, pos int) int {
+	var key [4]byte
+	for i := range key {
+		key[i] = mask[(pos+i)&3]
+	}
+
+	n := len(payload)
+	if n >= 8 {
+		// Mask whole 64-bit words, then fall through for the tail. The word
+		// count is a multiple of 4 so the key phase is unchanged for the tail.
+		k32 := binary.LittleEndian.Uint32(key[:])
+		words := n &^ 7
+		maskWords(payload[:words], uint64(k32)|uint64(k32)<<32)
+		payload = payload[words:]
+	}
+
+	for i := range payload {
+		payload[i] ^= key[i&3]
+	}
+
+	return pos + n
+}
+
+// maskWordsGeneric XORs b with key one 64-bit word at a time.
+// len(b) must be a multiple of 8.
+func maskWordsGeneric(b []byte, key uint64) {
+	for len(b) >= 32 {
+		v := binary.LittleEndian.Uint64(b)
+		binary.LittleEndian.PutUint64(b, v^key)
+		v = binary.LittleEndian.Uint64(b[8:])
+		binary.LittleEndian.PutUint64(b[8:], v^key)
+		v = binary.LittleEndian.Uint64(b[16:])
+		binary.LittleEndian.PutUint64(b[16:], v^key)
+		v = binary.LittleEndian.Uint64(b[24:])
+		binary.LittleEndian.PutUint64(b[24:], v^key)
+		b = b[32:]
+	}
+	for len(b) >= 8 {
+		v := binary.LittleEndian.Uint64(b)
+		binary.LittleEndian.PutUint64(b, v^key)
+		b = b[8:]
+	}
+}
@@ -0,0 +1,14 @@
+//go:build amd64 && !purego
+
+package axon
+
+// maskWords XORs b with key using SSE2. len(b) must be a multiple of 8.
+func maskWords(b []byte, key uint64) {
+	if len(b) == 0 {
+		return
+	}
+	maskSSE2(&b[0], len(b), key)
+}
+
+//go:noescape
+func maskSSE2(b *byte, n int, key uint64)
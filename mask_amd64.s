@@ -0,0 +1,48 @@
+//go:build amd64 && !purego
+
+#include "textflag.h"
+
+// func maskSSE2(b *byte, n int, key uint64)
+TEXT ·maskSSE2(SB), NOSPLIT, $0-24
+	MOVQ b+0(FP), SI
+	MOVQ n+8(FP), CX
+	MOVQ key+16(FP), AX
+	MOVQ AX, X0
+	PUNPCKLQDQ X0, X0
+
+loop64:
+	CMPQ CX, $64
+	JB   loop16
+	MOVOU 0(SI), X1
+	MOVOU 16(SI), X2
+	MOVOU 32(SI), X3
+	MOVOU 48(SI), X4
+	PXOR  X0, X1
+	PXOR  X0, X2
+	PXOR  X0, X3
+	PXOR  X0, X4
+	MOVOU X1, 0(SI)
+	MOVOU X2, 16(SI)
+	MOVOU X3, 32(SI)
+	MOVOU X4, 48(SI)
+	ADDQ  $64, SI
+	SUBQ  $64, CX
+	JMP   loop64
+
+loop16:
+	CMPQ CX, $16
+	JB   word
+	MOVOU (SI), X1
+	PXOR  X0, X1
+	MOVOU X1, (SI)
+	ADDQ  $16, SI
+	SUBQ  $16, CX
+	JMP   loop16
+
+word:
+	CMPQ CX, $8
+	JB   done
+	XORQ AX, (SI)
+
+done:
+	RET
@@ -0,0 +1,8 @@
+//go:build !amd64 || purego
+
+package axon
+
+// maskWords XORs b with key. len(b) must be a multiple of 8.
+func maskWords(b []byte, key uint64) {
+	maskWordsGeneric(b, key)
+}
@@ -0,0 +1,87 @@
+package axon_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/kolosys/axon"
+)
+
+// maskReference is the byte-at-a-time masking algorithm from RFC 6455
+func maskReference(payload []byte, mask []byte, pos int) {
+	for i := range payload {
+		payload[i] ^= mask[(pos+i)%4]
+	}
+}
+
+func TestMaskBytesMatchesReference(t *testing.T) {
+	mask := []byte{0x37, 0xfa, 0x21, 0x3d}
+
+	for n := 0; n <= 300; n++ {
+		for pos := 0; pos < 8; pos++ {
+			data := make([]byte, n)
+			for i := range data {
+				data[i] = byte(i * 7)
+			}
+			want := append([]byte(nil), data...)
+			maskReference(want, mask, pos)
+
+			if next := axon.MaskBytesPos(data, mask, pos); next != pos+n {
+				t.Fatalf("n=%d pos=%d: returned %d, want %d", n, pos, next, pos+n)
+			}
+			if !bytes.Equal(data, want) {
+				t.Fatalf("n=%d pos=%d: masked output mismatch", n, pos)
+			}
+		}
+	}
+}
+
+func TestMaskBytesUnaligned(t *testing.T) {
+	mask := []byte{0x01, 0x02, 0x03, 0x04}
+	buf := make([]byte, 1024+3)
+
+	for off := 0; off < 3; off++ {
+		data := buf[off : off+1024]
+		for i := range data {
+			data[i] = byte(i)
+		}
+		want := append([]byte(nil), data...)
+		maskReference(want, mask, 0)
+
+		axon.MaskBytes(data, mask)
+		if !bytes.Equal(data, want) {
+			t.Fatalf("offset %d: masked output mismatch", off)
+		}
+	}
+}
+
+func TestMaskBytesChunked(t *testing.T) {
+	mask := []byte{0xde, 0xad, 0xbe, 0xef}
+	data := bytes.Repeat([]byte("chunked masking "), 64)
+	want := append([]byte(nil), data...)
+	maskReference(want, mask, 0)
+
+	pos := 0
+	for start := 0; start < len(data); start += 37 {
+		end := min(start+37, len(data))
+		pos = axon.MaskBytesPos(data[start:end], mask, pos)
+	}
+
+	if !bytes.Equal(data, want) {
+		t.Error("chunked masking differs from single-pass masking")
+	}
+}
+
+func BenchmarkMaskBytes(b *testing.B) {
+	mask := []byte{0x37, 0xfa, 0x21, 0x3d}
+	for _, size := range []int{16, 128, 4096, 65536} {
+		data := make([]byte, size)
+		b.Run(fmt.Sprintf("%d", size), func(b *testing.B) {
+			b.SetBytes(int64(size))
+			for i := 0; i < b.N; i++ {
+				axon.MaskBytes(data, mask)
+			}
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package axon
 
 import (
+	"expvar"
+	"fmt"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -24,6 +27,10 @@ type Metrics struct {
 	FrameErrors     atomic.Int64
 	HandshakeErrors atomic.Int64
 
+	// RejectedHandshakes counts upgrades turned away by the rate limiter,
+	// ban list or connection limits
+	RejectedHandshakes atomic.Int64
+
 	// Performance metrics
 	ReadLatency  atomic.Int64 // nanoseconds
 	WriteLatency atomic.Int64 // nanoseconds
@@ -42,23 +49,28 @@ type Metrics struct {
 	CompressedMessages   atomic.Int64
 	DecompressedMessages atomic.Int64
 	CompressionSaved     atomic.Int64 // bytes saved by compression
+
+	resets atomic.Int64 // Number of Reset calls
 }
 
 // MetricsSnapshot represents a snapshot of metrics at a point in time
 type MetricsSnapshot struct {
-	ActiveConnections int64
-	TotalConnections  int64
-	ClosedConnections int64
-	MessagesRead      int64
-	MessagesWritten   int64
-	BytesRead         int64
-	BytesWritten      int64
-	ReadErrors        int64
-	WriteErrors       int64
-	FrameErrors       int64
-	HandshakeErrors   int64
-	AvgReadLatency    time.Duration
-	AvgWriteLatency   time.Duration
+	At time.Time // When the snapshot was taken
+
+	ActiveConnections  int64
+	TotalConnections   int64
+	ClosedConnections  int64
+	MessagesRead       int64
+	MessagesWritten    int64
+	BytesRead          int64
+	BytesWritten       int64
+	ReadErrors         int64
+	WriteErrors        int64
+	FrameErrors        int64
+	HandshakeErrors    int64
+	RejectedHandshakes int64
+	AvgReadLatency     time.Duration
+	AvgWriteLatency    time.Duration
 
 	// Reconnection metrics
 	ReconnectAttempts  int64
@@ -74,37 +86,29 @@ type MetricsSnapshot struct {
 	CompressedMessages   int64
 	DecompressedMessages int64
 	CompressionSaved     int64
+
+	// Latency totals, in nanoseconds, for computing deltas
+	readLatency  int64
+	writeLatency int64
+	resets       int64
 }
 
 // GetSnapshot returns a snapshot of current metrics
 func (m *Metrics) GetSnapshot() MetricsSnapshot {
-	readCount := m.MessagesRead.Load()
-	writeCount := m.MessagesWritten.Load()
-
-	avgReadLatency := time.Duration(0)
-	if readCount > 0 {
-		avgReadLatency = time.Duration(m.ReadLatency.Load() / readCount)
-	}
-
-	avgWriteLatency := time.Duration(0)
-	if writeCount > 0 {
-		avgWriteLatency = time.Duration(m.WriteLatency.Load() / writeCount)
-	}
-
-	return MetricsSnapshot{
+	s := MetricsSnapshot{
+		At:                   time.Now(),
 		ActiveConnections:    m.ActiveConnections.Load(),
 		TotalConnections:     m.TotalConnections.Load(),
 		ClosedConnections:    m.ClosedConnections.Load(),
-		MessagesRead:         readCount,
-		MessagesWritten:      writeCount,
+		MessagesRead:         m.MessagesRead.Load(),
+		MessagesWritten:      m.MessagesWritten.Load(),
 		BytesRead:            m.BytesRead.Load(),
 		BytesWritten:         m.BytesWritten.Load(),
 		ReadErrors:           m.ReadErrors.Load(),
 		WriteErrors:          m.WriteErrors.Load(),
 		FrameErrors:          m.FrameErrors.Load(),
 		HandshakeErrors:      m.HandshakeErrors.Load(),
-		AvgReadLatency:       avgReadLatency,
-		AvgWriteLatency:      avgWriteLatency,
+		RejectedHandshakes:   m.RejectedHandshakes.Load(),
 		ReconnectAttempts:    m.ReconnectAttempts.Load(),
 		ReconnectSuccesses:   m.ReconnectSuccesses.Load(),
 		ReconnectFailures:    m.ReconnectFailures.Load(),
@@ -114,6 +118,82 @@ func (m *Metrics) GetSnapshot() MetricsSnapshot {
 		CompressedMessages:   m.CompressedMessages.Load(),
 		DecompressedMessages: m.DecompressedMessages.Load(),
 		CompressionSaved:     m.CompressionSaved.Load(),
+		readLatency:          m.ReadLatency.Load(),
+		writeLatency:         m.WriteLatency.Load(),
+		resets:               m.resets.Load(),
+	}
+	s.averageLatency()
+	return s
+}
+
+// GetDeltaSnapshot returns the change in metrics since an earlier snapshot,
+// for computing rates over the interval since.At to the returned At.
+// ActiveConnections is the current count and the latencies are averaged
+// over the interval. If Reset was called in between, the counters are
+// those recorded since the reset.
+func (m *Metrics) GetDeltaSnapshot(since MetricsSnapshot) MetricsSnapshot {
+	now := m.GetSnapshot()
+	if now.resets != since.resets {
+		return now
+	}
+
+	d := MetricsSnapshot{
+		At:                   now.At,
+		ActiveConnections:    now.ActiveConnections,
+		TotalConnections:     now.TotalConnections - since.TotalConnections,
+		ClosedConnections:    now.ClosedConnections - since.ClosedConnections,
+		MessagesRead:         now.MessagesRead - since.MessagesRead,
+		MessagesWritten:      now.MessagesWritten - since.MessagesWritten,
+		BytesRead:            now.BytesRead - since.BytesRead,
+		BytesWritten:         now.BytesWritten - since.BytesWritten,
+		ReadErrors:           now.ReadErrors - since.ReadErrors,
+		WriteErrors:          now.WriteErrors - since.WriteErrors,
+		FrameErrors:          now.FrameErrors - since.FrameErrors,
+		HandshakeErrors:      now.HandshakeErrors - since.HandshakeErrors,
+		RejectedHandshakes:   now.RejectedHandshakes - since.RejectedHandshakes,
+		ReconnectAttempts:    now.ReconnectAttempts - since.ReconnectAttempts,
+		ReconnectSuccesses:   now.ReconnectSuccesses - since.ReconnectSuccesses,
+		ReconnectFailures:    now.ReconnectFailures - since.ReconnectFailures,
+		QueueEnqueued:        now.QueueEnqueued - since.QueueEnqueued,
+		QueueSent:            now.QueueSent - since.QueueSent,
+		QueueDropped:         now.QueueDropped - since.QueueDropped,
+		CompressedMessages:   now.CompressedMessages - since.CompressedMessages,
+		DecompressedMessages: now.DecompressedMessages - since.DecompressedMessages,
+		CompressionSaved:     now.CompressionSaved - since.CompressionSaved,
+		readLatency:          now.readLatency - since.readLatency,
+		writeLatency:         now.writeLatency - since.writeLatency,
+	}
+	d.averageLatency()
+	return d
+}
+
+// averageLatency sets the average latencies from the latency totals
+func (s *MetricsSnapshot) averageLatency() {
+	s.AvgReadLatency, s.AvgWriteLatency = 0, 0
+	if s.MessagesRead > 0 {
+		s.AvgReadLatency = time.Duration(s.readLatency / s.MessagesRead)
+	}
+	if s.MessagesWritten > 0 {
+		s.AvgWriteLatency = time.Duration(s.writeLatency / s.MessagesWritten)
+	}
+}
+
+// Reset zeroes every counter, leaving ActiveConnections, which counts the
+// connections still open. Counters are cleared one at a time, so a
+// snapshot taken concurrently may see some of them reset.
+func (m *Metrics) Reset() {
+	m.resets.Add(1)
+	for _, counter := range []*atomic.Int64{
+		&m.TotalConnections, &m.ClosedConnections,
+		&m.MessagesRead, &m.MessagesWritten, &m.BytesRead, &m.BytesWritten,
+		&m.ReadErrors, &m.WriteErrors, &m.FrameErrors, &m.HandshakeErrors,
+		&m.RejectedHandshakes,
+		&m.ReadLatency, &m.WriteLatency,
+		&m.ReconnectAttempts, &m.ReconnectSuccesses, &m.ReconnectFailures,
+		&m.QueueEnqueued, &m.QueueSent, &m.QueueDropped,
+		&m.CompressedMessages, &m.DecompressedMessages, &m.CompressionSaved,
+	} {
+		counter.Store(0)
 	}
 }
 
@@ -163,6 +243,11 @@ func (m *Metrics) RecordHandshakeError() {
 	m.HandshakeErrors.Add(1)
 }
 
+// RecordRejectedHandshake records a handshake rejected by a limit
+func (m *Metrics) RecordRejectedHandshake() {
+	m.RejectedHandshakes.Add(1)
+}
+
 // RecordReconnectAttempt records a reconnection attempt
 func (m *Metrics) RecordReconnectAttempt() {
 	m.ReconnectAttempts.Add(1)
@@ -206,5 +291,47 @@ func (m *Metrics) RecordDecompression() {
 	m.DecompressedMessages.Add(1)
 }
 
+// expvarMu serializes PublishExpvar, as expvar panics on duplicate names
+var expvarMu sync.Mutex
+
+// PublishExpvar publishes the metrics under expvar as prefix, a map of
+// every counter updated whenever the variables are read, such as through
+// /debug/vars. Names can only be published once per process.
+func (m *Metrics) PublishExpvar(prefix string) error {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	if expvar.Get(prefix) != nil {
+		return fmt.Errorf("axon: expvar %q already published", prefix)
+	}
+	expvar.Publish(prefix, expvar.Func(func() any {
+		return m.GetSnapshot()
+	}))
+	return nil
+}
+
 // DefaultMetrics is the default metrics instance
 var DefaultMetrics = &Metrics{}
+
+// recordRead records the outcome of a read in the connection's metrics
+func (c *Conn[T]) recordRead(size int, latency time.Duration, err error) {
+	m := c.metrics
+	switch {
+	case m == nil:
+	case err == nil:
+		m.RecordRead(size, latency)
+	case isConnError(err):
+		m.RecordReadError()
+	}
+}
+
+// recordWrite records the outcome of a write in the connection's metrics
+func (c *Conn[T]) recordWrite(size int, latency time.Duration, err error) {
+	m := c.metrics
+	switch {
+	case m == nil:
+	case err == nil:
+		m.RecordWrite(size, latency)
+	case isConnError(err):
+		m.RecordWriteError()
+	}
+}
@@ -1,6 +1,14 @@
 package axon_test
 
 import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -85,3 +93,242 @@ func TestMetricsSnapshot(t *testing.T) {
 		t.Errorf("expected 2048 bytes written, got %d", snapshot.BytesWritten)
 	}
 }
+
+func TestMetrics_Reset(t *testing.T) {
+	metrics := &axon.Metrics{}
+	metrics.RecordConnection()
+	metrics.RecordConnection()
+	metrics.RecordDisconnection()
+	metrics.RecordRead(100, time.Millisecond)
+	metrics.RecordQueueDropped()
+
+	metrics.Reset()
+
+	s := metrics.GetSnapshot()
+	if s.ActiveConnections != 1 {
+		t.Errorf("ActiveConnections = %d, want the open connection kept", s.ActiveConnections)
+	}
+	if s.TotalConnections != 0 || s.ClosedConnections != 0 || s.MessagesRead != 0 || s.BytesRead != 0 || s.AvgReadLatency != 0 || s.QueueDropped != 0 {
+		t.Errorf("snapshot after Reset = %+v, want zero counters", s)
+	}
+}
+
+func TestMetrics_GetDeltaSnapshot(t *testing.T) {
+	metrics := &axon.Metrics{}
+	metrics.RecordConnection()
+	metrics.RecordRead(100, 10*time.Millisecond)
+	metrics.RecordWrite(50, time.Millisecond)
+
+	since := metrics.GetSnapshot()
+	metrics.RecordConnection()
+	metrics.RecordRead(200, 30*time.Millisecond)
+	metrics.RecordRead(300, 50*time.Millisecond)
+	metrics.RecordReconnectAttempt()
+
+	d := metrics.GetDeltaSnapshot(since)
+	if d.ActiveConnections != 2 || d.TotalConnections != 1 {
+		t.Errorf("connections = %d active, %d total; want 2, 1", d.ActiveConnections, d.TotalConnections)
+	}
+	if d.MessagesRead != 2 || d.BytesRead != 500 || d.AvgReadLatency != 40*time.Millisecond {
+		t.Errorf("reads = %d messages, %d bytes, %v average; want 2, 500, 40ms", d.MessagesRead, d.BytesRead, d.AvgReadLatency)
+	}
+	if d.MessagesWritten != 0 || d.AvgWriteLatency != 0 {
+		t.Errorf("writes = %d messages, %v average; want none", d.MessagesWritten, d.AvgWriteLatency)
+	}
+	if d.ReconnectAttempts != 1 {
+		t.Errorf("ReconnectAttempts = %d, want 1", d.ReconnectAttempts)
+	}
+	if d.At.Before(since.At) {
+		t.Errorf("At = %v, before the earlier snapshot at %v", d.At, since.At)
+	}
+
+	// Counters reset after since are reported as recorded since the reset
+	metrics.Reset()
+	metrics.RecordRead(10, time.Millisecond)
+	metrics.RecordRead(10, time.Millisecond)
+	metrics.RecordRead(10, time.Millisecond)
+	if d := metrics.GetDeltaSnapshot(since); d.MessagesRead != 3 || d.BytesRead != 30 {
+		t.Errorf("reads after Reset = %d messages, %d bytes; want 3, 30", d.MessagesRead, d.BytesRead)
+	}
+}
+
+func TestMetrics_ConnTraffic(t *testing.T) {
+	serverMetrics := &axon.Metrics{}
+	closed := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[string](w, r, &axon.UpgradeOptions{Metrics: serverMetrics, Compression: true})
+		if err != nil {
+			return
+		}
+		defer close(closed)
+		defer conn.Close(1000, "done")
+		msg, err := conn.Read(r.Context())
+		if err != nil {
+			return
+		}
+		conn.Write(r.Context(), msg)
+	}))
+	defer server.Close()
+
+	clientMetrics := &axon.Metrics{}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := axon.Dial[string](ctx, "ws"+strings.TrimPrefix(server.URL, "http"), &axon.DialOptions{Metrics: clientMetrics, Compression: true})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+
+	msg := strings.Repeat("compressible ", 100)
+	if err := conn.Write(ctx, msg); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if _, err := conn.Read(ctx); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	conn.Close(1000, "")
+	<-closed
+
+	for name, m := range map[string]*axon.Metrics{"client": clientMetrics, "server": serverMetrics} {
+		s := m.GetSnapshot()
+		if s.TotalConnections != 1 || s.ActiveConnections != 0 || s.ClosedConnections != 1 {
+			t.Errorf("%s connections = %d total, %d active, %d closed; want 1, 0, 1", name, s.TotalConnections, s.ActiveConnections, s.ClosedConnections)
+		}
+		if s.MessagesRead != 1 || s.MessagesWritten != 1 {
+			t.Errorf("%s messages = %d read, %d written; want 1 each", name, s.MessagesRead, s.MessagesWritten)
+		}
+		// Strings are sent JSON encoded, in quotes
+		if want := int64(len(msg) + 2); s.BytesRead != want || s.BytesWritten != want {
+			t.Errorf("%s bytes = %d read, %d written; want %d each", name, s.BytesRead, s.BytesWritten, want)
+		}
+		if s.CompressedMessages != 1 || s.DecompressedMessages != 1 || s.CompressionSaved <= 0 {
+			t.Errorf("%s compression = %d compressed, %d decompressed, %d saved", name, s.CompressedMessages, s.DecompressedMessages, s.CompressionSaved)
+		}
+	}
+}
+
+func TestMetrics_HandshakeErrors(t *testing.T) {
+	metrics := &axon.Metrics{}
+	u := axon.NewUpgrader(&axon.UpgradeOptions{
+		Metrics:     metrics,
+		CheckOrigin: func(r *http.Request) bool { return false },
+	})
+	req := upgradeRequest("192.0.2.1:1234")
+	req.Header.Set("Origin", "http://evil.example")
+	if _, err := axon.UpgradeWith[string](u, httptest.NewRecorder(), req); err == nil {
+		t.Fatal("expected the upgrade to be rejected")
+	}
+	if s := metrics.GetSnapshot(); s.HandshakeErrors != 1 || s.RejectedHandshakes != 0 || s.TotalConnections != 0 {
+		t.Errorf("upgrader snapshot = %+v, want 1 handshake error", s)
+	}
+
+	dialMetrics := &axon.Metrics{}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := axon.Dial[string](ctx, "ws://127.0.0.1:1/", &axon.DialOptions{Metrics: dialMetrics}); err == nil {
+		t.Fatal("expected the dial to fail")
+	}
+	if got := dialMetrics.GetSnapshot().HandshakeErrors; got != 1 {
+		t.Errorf("HandshakeErrors after failed dial = %d, want 1", got)
+	}
+}
+
+func TestClient_Metrics(t *testing.T) {
+	var conns atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[string](w, r, nil)
+		if err != nil {
+			return
+		}
+		if conns.Add(1) == 1 {
+			conn.Close(int(axon.CloseGoingAway), "restarting")
+			return
+		}
+		defer conn.Close(1000, "done")
+		for {
+			if _, err := conn.Read(r.Context()); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	metrics := &axon.Metrics{}
+	sent := make(chan error, 1)
+	reconnected := make(chan struct{})
+
+	opts := axon.DefaultClientOptions()
+	opts.Metrics = metrics
+	opts.Reconnect.InitialDelay = 10 * time.Millisecond
+	opts.Reconnect.Jitter = false
+	// The success is counted once the queue is flushed, just before this
+	opts.Reconnect.OnReconnected = func(int) { close(reconnected) }
+	client := axon.NewClient[string]("ws"+strings.TrimPrefix(server.URL, "http"), opts)
+	defer client.Close()
+
+	// Queue a message while the client reconnects
+	client.OnDisconnect(func(c *axon.Client[string], err error) {
+		if err := c.WriteNoWait(context.Background(), "queued", func(err error) { sent <- err }); err != nil {
+			sent <- err
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.ConnectWithReadLoop(ctx); err != nil {
+		t.Fatalf("ConnectWithReadLoop() error = %v", err)
+	}
+	select {
+	case err := <-sent:
+		if err != nil {
+			t.Fatalf("queued write error = %v", err)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for the queued message")
+	}
+	select {
+	case <-reconnected:
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for the reconnect")
+	}
+
+	s := metrics.GetSnapshot()
+	if s.ReconnectAttempts != 1 || s.ReconnectSuccesses != 1 || s.ReconnectFailures != 0 {
+		t.Errorf("reconnects = %d attempts, %d successes, %d failures; want 1, 1, 0", s.ReconnectAttempts, s.ReconnectSuccesses, s.ReconnectFailures)
+	}
+	if s.QueueEnqueued != 1 || s.QueueSent != 1 || s.QueueDropped != 0 {
+		t.Errorf("queue = %d enqueued, %d sent, %d dropped; want 1, 1, 0", s.QueueEnqueued, s.QueueSent, s.QueueDropped)
+	}
+	if s.TotalConnections != 2 || s.ClosedConnections != 1 {
+		t.Errorf("connections = %d total, %d closed; want 2, 1", s.TotalConnections, s.ClosedConnections)
+	}
+}
+
+// expvarRuns keeps published names unique, as expvar names are global to
+// the process and tests may run more than once
+var expvarRuns atomic.Int64
+
+func TestMetrics_PublishExpvar(t *testing.T) {
+	name := fmt.Sprintf("%s_%d", t.Name(), expvarRuns.Add(1))
+	metrics := &axon.Metrics{}
+	if err := metrics.PublishExpvar(name); err != nil {
+		t.Fatalf("PublishExpvar() error = %v", err)
+	}
+	metrics.RecordRead(100, time.Millisecond)
+	metrics.RecordConnection()
+
+	v := expvar.Get(name)
+	if v == nil {
+		t.Fatal("metrics not published")
+	}
+	var published map[string]any
+	if err := json.Unmarshal([]byte(v.String()), &published); err != nil {
+		t.Fatalf("published value %q is not JSON: %v", v.String(), err)
+	}
+	if published["MessagesRead"] != float64(1) || published["BytesRead"] != float64(100) || published["ActiveConnections"] != float64(1) {
+		t.Errorf("published = %v", published)
+	}
+
+	if err := (&axon.Metrics{}).PublishExpvar(name); err == nil {
+		t.Error("expected publishing a name twice to fail")
+	}
+}
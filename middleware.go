@@ -0,0 +1,96 @@
+package axon
+
+import "context"
+
+// ReadFunc reads a complete message, returning its type and raw payload
+type ReadFunc func(ctx context.Context) (MessageType, []byte, error)
+
+// WriteFunc writes a raw payload as a single message of the given type
+type WriteFunc func(ctx context.Context, messageType MessageType, data []byte) error
+
+// ReadMiddleware wraps the reading of messages, such as to decrypt,
+// validate or audit them. It returns a ReadFunc that calls next and may
+// inspect or replace what it returns.
+type ReadMiddleware func(next ReadFunc) ReadFunc
+
+// WriteMiddleware wraps the writing of messages, such as to encrypt,
+// validate or audit them. It returns a WriteFunc that may transform the
+// message before passing it to next, or refuse it with an error.
+type WriteMiddleware func(next WriteFunc) WriteFunc
+
+// WrapRead layers middleware around every read: Read, ReadMessage and
+// readers built on them. Each call wraps the chain built so far, and the
+// first middleware of a call is outermost, so with WrapRead(a, b) the
+// payload passes through b before a. Payloads given to middleware are owned
+// by it. Wrap a connection before it is read from.
+func (c *Conn[T]) WrapRead(mw ...ReadMiddleware) {
+	c.wrapMu.Lock()
+	defer c.wrapMu.Unlock()
+
+	next := c.readChain
+	if next == nil {
+		next = c.readRaw
+	}
+	for i := len(mw) - 1; i >= 0; i-- {
+		next = mw[i](next)
+	}
+	c.readChain = next
+}
+
+// WrapWrite layers middleware around every write: Write, WriteMessage,
+// WritePrepared and SendAsync. Each call wraps the chain built so far, and
+// the first middleware of a call is outermost, so with WrapWrite(a, b) a
+// sees a message before b. Wrap a connection before it is written to.
+func (c *Conn[T]) WrapWrite(mw ...WriteMiddleware) {
+	c.wrapMu.Lock()
+	defer c.wrapMu.Unlock()
+
+	next := c.writeChain
+	if next == nil {
+		next = c.writeRaw
+	}
+	for i := len(mw) - 1; i >= 0; i-- {
+		next = mw[i](next)
+	}
+	c.writeChain = next
+}
+
+// readWrapped returns the read middleware chain, or nil without middleware
+func (c *Conn[T]) readWrapped() ReadFunc {
+	c.wrapMu.RLock()
+	defer c.wrapMu.RUnlock()
+	return c.readChain
+}
+
+// write writes an encoded message through the write middleware, if any
+func (c *Conn[T]) write(ctx context.Context, opcode byte, payload []byte) error {
+	c.wrapMu.RLock()
+	write := c.writeChain
+	c.wrapMu.RUnlock()
+
+	if write != nil {
+		return write(ctx, MessageType(opcode), payload)
+	}
+	return c.writeMessage(ctx, opcode, payload)
+}
+
+// readRaw is the innermost ReadFunc, returning payloads owned by the caller
+func (c *Conn[T]) readRaw(ctx context.Context) (MessageType, []byte, error) {
+	if !c.acquireIO() {
+		return 0, nil, ErrConnectionClosed
+	}
+	defer c.releaseIO()
+	opcode, payload, borrowed, err := c.readMessage(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	if borrowed {
+		payload = append([]byte(nil), payload...)
+	}
+	return MessageType(opcode), payload, nil
+}
+
+// writeRaw is the innermost WriteFunc
+func (c *Conn[T]) writeRaw(ctx context.Context, messageType MessageType, data []byte) error {
+	return c.writeMessage(ctx, byte(messageType), data)
+}
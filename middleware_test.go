@@ -0,0 +1,222 @@
+package axon_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+// xorPayload "encrypts" a payload by flipping every bit
+func xorPayload(data []byte) []byte {
+	out := make([]byte, len(data))
+	for i, b := range data {
+		out[i] = ^b
+	}
+	return out
+}
+
+func xorRead(next axon.ReadFunc) axon.ReadFunc {
+	return func(ctx context.Context) (axon.MessageType, []byte, error) {
+		mt, data, err := next(ctx)
+		if err != nil {
+			return 0, nil, err
+		}
+		return mt, xorPayload(data), nil
+	}
+}
+
+func xorWrite(next axon.WriteFunc) axon.WriteFunc {
+	return func(ctx context.Context, mt axon.MessageType, data []byte) error {
+		return next(ctx, axon.BinaryMessage, xorPayload(data))
+	}
+}
+
+// middlewareServer echoes messages prefixed with "echo ", reading and
+// writing through the xor middleware when wrap is set
+func middlewareServer(t *testing.T, wrap bool) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[string](w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close(1000, "done")
+		if wrap {
+			conn.WrapRead(xorRead)
+			conn.WrapWrite(xorWrite)
+		}
+		for {
+			msg, err := conn.Read(r.Context())
+			if err != nil {
+				return
+			}
+			if err := conn.Write(r.Context(), "echo "+msg); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func TestConn_Middleware(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := axon.Dial[string](ctx, middlewareServer(t, true), nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close(1000, "")
+	conn.WrapRead(xorRead)
+	conn.WrapWrite(xorWrite)
+
+	if err := conn.Write(ctx, "hello"); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if msg, err := conn.Read(ctx); err != nil || msg != "echo hello" {
+		t.Fatalf("Read() = %q, %v; want %q", msg, err, "echo hello")
+	}
+
+	pm, err := axon.NewPreparedMessage[string]("prepared")
+	if err != nil {
+		t.Fatalf("NewPreparedMessage() error = %v", err)
+	}
+	if err := conn.WritePrepared(ctx, pm); err != nil {
+		t.Fatalf("WritePrepared() error = %v", err)
+	}
+	if mt, data, err := conn.ReadMessage(ctx); err != nil || mt != axon.BinaryMessage || string(data) != `"echo prepared"` {
+		t.Fatalf("ReadMessage() = %v, %q, %v", mt, data, err)
+	}
+}
+
+func TestConn_MiddlewareOnWire(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[[]byte](w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close(1000, "done")
+		mt, data, err := conn.ReadMessage(r.Context())
+		if err != nil {
+			return
+		}
+		conn.WriteMessage(r.Context(), mt, data)
+		conn.Read(r.Context())
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := axon.Dial[[]byte](ctx, "ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close(1000, "")
+	conn.WrapWrite(xorWrite)
+
+	// The server echoes what it received, as transformed on the wire
+	if err := conn.WriteMessage(ctx, axon.TextMessage, []byte("secret")); err != nil {
+		t.Fatalf("WriteMessage() error = %v", err)
+	}
+	mt, data, err := conn.ReadMessage(ctx)
+	if err != nil {
+		t.Fatalf("ReadMessage() error = %v", err)
+	}
+	if want := xorPayload([]byte("secret")); mt != axon.BinaryMessage || string(data) != string(want) {
+		t.Errorf("wire message = %v %q, want %v %q", mt, data, axon.BinaryMessage, want)
+	}
+}
+
+func TestConn_MiddlewareOrder(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := axon.Dial[string](ctx, middlewareServer(t, false), nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close(1000, "")
+
+	var mu sync.Mutex
+	var order []string
+	record := func(name string) {
+		mu.Lock()
+		order = append(order, name)
+		mu.Unlock()
+	}
+	writeStep := func(name string) axon.WriteMiddleware {
+		return func(next axon.WriteFunc) axon.WriteFunc {
+			return func(ctx context.Context, mt axon.MessageType, data []byte) error {
+				record("write " + name)
+				return next(ctx, mt, data)
+			}
+		}
+	}
+	readStep := func(name string) axon.ReadMiddleware {
+		return func(next axon.ReadFunc) axon.ReadFunc {
+			return func(ctx context.Context) (axon.MessageType, []byte, error) {
+				mt, data, err := next(ctx)
+				record("read " + name)
+				return mt, data, err
+			}
+		}
+	}
+	conn.WrapWrite(writeStep("b"), writeStep("c"))
+	conn.WrapWrite(writeStep("a"))
+	conn.WrapRead(readStep("b"), readStep("c"))
+	conn.WrapRead(readStep("a"))
+
+	if err := conn.Write(ctx, "x"); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if _, err := conn.Read(ctx); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+
+	want := []string{"write a", "write b", "write c", "read c", "read b", "read a"}
+	mu.Lock()
+	defer mu.Unlock()
+	if !slices.Equal(order, want) {
+		t.Errorf("order = %v, want %v", order, want)
+	}
+}
+
+func TestConn_MiddlewareRejectsWrite(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := axon.Dial[string](ctx, middlewareServer(t, false), nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close(1000, "")
+
+	errForbidden := errors.New("forbidden word")
+	conn.WrapWrite(func(next axon.WriteFunc) axon.WriteFunc {
+		return func(ctx context.Context, mt axon.MessageType, data []byte) error {
+			if strings.Contains(string(data), "forbidden") {
+				return errForbidden
+			}
+			return next(ctx, mt, data)
+		}
+	})
+
+	if err := conn.Write(ctx, "forbidden"); !errors.Is(err, errForbidden) {
+		t.Fatalf("Write() error = %v, want errForbidden", err)
+	}
+	if err := conn.Write(ctx, "allowed"); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if msg, err := conn.Read(ctx); err != nil || msg != "echo allowed" {
+		t.Errorf("Read() = %q, %v; want only the allowed message echoed", msg, err)
+	}
+}
@@ -0,0 +1,146 @@
+package axon
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// CertReloader serves a certificate and key pair from files, loading them
+// again whenever either file changes, so rotated certificates take effect
+// without a restart. Its methods plug into tls.Config for servers and into
+// DialOptions.GetClientCertificate for clients.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// NewCertReloader loads the PEM encoded certificate and key files
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload loads the files again. A pair that fails to load leaves the
+// current certificate in place.
+func (r *CertReloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.load(r.latestModTime())
+}
+
+// load reads the pair, recording modTime as the version it holds
+func (r *CertReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("axon: failed to load certificate: %w", err)
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+// latestModTime returns the later modification time of the two files
+func (r *CertReloader) latestModTime() time.Time {
+	var latest time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		if info, err := os.Stat(name); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+// Certificate returns the current certificate, reloading it first if the
+// files changed. While a rotation is half written and fails to load, the
+// previous certificate is returned.
+func (r *CertReloader) Certificate() *tls.Certificate {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if modTime := r.latestModTime(); !modTime.Equal(r.modTime) {
+		r.load(modTime)
+	}
+	return r.cert
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.Certificate(), nil
+}
+
+// GetClientCertificate implements tls.Config.GetClientCertificate
+func (r *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.Certificate(), nil
+}
+
+// clientTLSConfig returns the TLS configuration for dialing serverName,
+// with the certificate options applied
+func (opts *DialOptions) clientTLSConfig(serverName string) *tls.Config {
+	tlsConfig := opts.TLSConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	if tlsConfig.ServerName != "" && opts.GetClientCertificate == nil && opts.VerifyPeerCertificate == nil {
+		return tlsConfig
+	}
+
+	tlsConfig = tlsConfig.Clone()
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = serverName
+	}
+	if opts.GetClientCertificate != nil {
+		tlsConfig.GetClientCertificate = opts.GetClientCertificate
+	}
+	if opts.VerifyPeerCertificate != nil {
+		tlsConfig.VerifyPeerCertificate = opts.VerifyPeerCertificate
+	}
+	return tlsConfig
+}
+
+// verifyPeer applies VerifyPeerCertificate to the client certificate of
+// the request
+func (u *Upgrader) verifyPeer(r *http.Request) error {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return fmt.Errorf("%w: no client certificate", ErrUpgradeRejected)
+	}
+	rawCerts := make([][]byte, len(r.TLS.PeerCertificates))
+	for i, cert := range r.TLS.PeerCertificates {
+		rawCerts[i] = cert.Raw
+	}
+	if err := u.verifyPeerCertificate(rawCerts, r.TLS.VerifiedChains); err != nil {
+		return fmt.Errorf("%w: %w", ErrUpgradeRejected, err)
+	}
+	return nil
+}
+
+// errNoVerifiedChain is returned by RequireVerifiedChain for certificates
+// that were not verified against the server's ClientCAs
+var errNoVerifiedChain = errors.New("client certificate not verified")
+
+// RequireVerifiedChain is a VerifyPeerCertificate hook that accepts only
+// client certificates verified against a CA, and that checks them with
+// check, such as to match the subject against an allow list. A nil check
+// accepts any verified certificate.
+func RequireVerifiedChain(check func(leaf *x509.Certificate) error) func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	return func(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if len(verifiedChains) == 0 || len(verifiedChains[0]) == 0 {
+			return errNoVerifiedChain
+		}
+		if check == nil {
+			return nil
+		}
+		return check(verifiedChains[0][0])
+	}
+}
@@ -0,0 +1,210 @@
+package axon_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+// testCA issues certificates for the mutual TLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue returns PEM encoded certificate and key for name, valid for both
+// client and server authentication
+func (ca *testCA) issue(t *testing.T, name string) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// writePair writes a certificate pair to dir with the given modification
+// time and returns the file names
+func writePair(t *testing.T, dir string, certPEM, keyPEM []byte, modTime time.Time) (string, string) {
+	t.Helper()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	for name, data := range map[string][]byte{certFile: certPEM, keyFile: keyPEM} {
+		if err := os.WriteFile(name, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(name, modTime, modTime)
+	}
+	return certFile, keyFile
+}
+
+func TestCertReloader(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+
+	certPEM, keyPEM := ca.issue(t, "first")
+	certFile, keyFile := writePair(t, dir, certPEM, keyPEM, time.Now().Add(-time.Minute))
+	reloader, err := axon.NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewCertReloader() error = %v", err)
+	}
+	commonName := func() string {
+		cert, err := reloader.GetClientCertificate(nil)
+		if err != nil {
+			t.Fatalf("GetClientCertificate() error = %v", err)
+		}
+		leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+		return leaf.Subject.CommonName
+	}
+	if got := commonName(); got != "first" {
+		t.Fatalf("certificate = %s, want first", got)
+	}
+
+	// A half written rotation keeps the current certificate
+	os.WriteFile(certFile, []byte("garbage"), 0o600)
+	if got := commonName(); got != "first" {
+		t.Errorf("certificate during rotation = %s, want first", got)
+	}
+
+	certPEM, keyPEM = ca.issue(t, "second")
+	writePair(t, dir, certPEM, keyPEM, time.Now())
+	if got := commonName(); got != "second" {
+		t.Errorf("certificate after rotation = %s, want second", got)
+	}
+}
+
+func TestDial_MutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+
+	serverCert, serverKey := ca.issue(t, "server")
+	cert, err := tls.X509KeyPair(serverCert, serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &axon.UpgradeOptions{
+		VerifyPeerCertificate: axon.RequireVerifiedChain(func(leaf *x509.Certificate) error {
+			if leaf.Subject.CommonName != "allowed" {
+				return errors.New("unknown peer")
+			}
+			return nil
+		}),
+	}
+	server := httptest.NewUnstartedServer(axon.Handler(opts, func(ctx context.Context, conn *axon.Conn[string]) {
+		conn.Write(ctx, "welcome")
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    ca.pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	}
+	server.Config.ErrorLog = log.New(io.Discard, "", 0) // Rejected handshakes
+	server.StartTLS()
+	defer server.Close()
+	url := "wss" + strings.TrimPrefix(server.URL, "https")
+
+	dial := func(name string) error {
+		dialOpts := &axon.DialOptions{TLSConfig: &tls.Config{RootCAs: ca.pool}}
+		if name != "" {
+			certPEM, keyPEM := ca.issue(t, name)
+			certFile, keyFile := writePair(t, dir, certPEM, keyPEM, time.Now())
+			reloader, err := axon.NewCertReloader(certFile, keyFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dialOpts.GetClientCertificate = reloader.GetClientCertificate
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		conn, err := axon.Dial[string](ctx, url, dialOpts)
+		if err != nil {
+			return err
+		}
+		defer conn.Close(1000, "")
+		if msg, err := conn.Read(ctx); err != nil || msg != "welcome" {
+			t.Errorf("Read() = %q, %v", msg, err)
+		}
+		return nil
+	}
+
+	if err := dial("allowed"); err != nil {
+		t.Errorf("Dial() with an allowed certificate error = %v", err)
+	}
+	for _, name := range []string{"", "stranger"} {
+		var hsErr *axon.HandshakeError
+		if err := dial(name); !errors.As(err, &hsErr) || hsErr.StatusCode() != http.StatusForbidden {
+			t.Errorf("Dial() with certificate %q error = %v, want 403", name, err)
+		}
+	}
+
+	// The server's certificate can be pinned too
+	pinned := &axon.DialOptions{
+		TLSConfig: &tls.Config{RootCAs: ca.pool},
+		VerifyPeerCertificate: axon.RequireVerifiedChain(func(leaf *x509.Certificate) error {
+			return errors.New("not the expected server")
+		}),
+	}
+	if _, err := axon.Dial[string](context.Background(), url, pinned); err == nil || !strings.Contains(err.Error(), "not the expected server") {
+		t.Errorf("Dial() to an unpinned server error = %v", err)
+	}
+}
@@ -0,0 +1,396 @@
+package axon
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+)
+
+// Mux frame types. Every mux frame is a binary message starting with the
+// frame type and the channel ID as a uvarint.
+const (
+	muxData   byte = 0 // Followed by the message payload
+	muxWindow byte = 1 // Followed by the window increment as a uvarint
+	muxClose  byte = 2 // The sender will write no more to the channel
+)
+
+// defaultMuxWindow is the receive window used when none is set
+const defaultMuxWindow = 256 * 1024
+
+// MuxOptions configures a Mux
+type MuxOptions struct {
+	// Window is the number of bytes a channel's peer may send before the
+	// channel is read from. Both ends must use the same window.
+	// Default is 262144 bytes (256KB).
+	Window int
+}
+
+// Mux carries independent logical channels over one connection. Each
+// channel has its own flow control window, so a channel that is not read
+// from stalls only its own writer. The Mux owns reading from the
+// connection; do not call Read on the connection while it is in use.
+type Mux struct {
+	readMessage  func(ctx context.Context) (MessageType, []byte, error)
+	writeMessage func(ctx context.Context, messageType MessageType, data []byte) error
+	closeConn    func(code int, reason string) error
+	window       int
+
+	mu       sync.Mutex
+	channels map[uint32]*muxChannel
+	err      error
+	done     chan struct{}
+}
+
+// NewMux starts multiplexing channels over conn. A nil opts uses the
+// defaults.
+func NewMux[C any](conn *Conn[C], opts *MuxOptions) *Mux {
+	window := defaultMuxWindow
+	if opts != nil && opts.Window > 0 {
+		window = opts.Window
+	}
+
+	m := &Mux{
+		readMessage:  conn.ReadMessage,
+		writeMessage: conn.WriteMessage,
+		closeConn:    conn.Close,
+		window:       window,
+		channels:     make(map[uint32]*muxChannel),
+		done:         make(chan struct{}),
+	}
+	go m.readLoop()
+	return m
+}
+
+// Done returns a channel that is closed when the Mux stops
+func (m *Mux) Done() <-chan struct{} {
+	return m.done
+}
+
+// Err returns the error that stopped the Mux, or nil while it runs
+func (m *Mux) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
+
+// Close closes the underlying connection, failing all channels
+func (m *Mux) Close(code int, reason string) error {
+	err := m.closeConn(code, reason)
+	m.stop(ErrConnectionClosed)
+	return err
+}
+
+// readLoop dispatches incoming frames to their channels
+func (m *Mux) readLoop() {
+	for {
+		messageType, data, err := m.readMessage(context.Background())
+		if err != nil {
+			// Idle connections hit the default read deadline
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			m.stop(err)
+			return
+		}
+
+		if err := m.dispatch(messageType, data); err != nil {
+			m.closeConn(int(CloseProtocolError), "")
+			m.stop(err)
+			return
+		}
+	}
+}
+
+// dispatch handles a single mux frame
+func (m *Mux) dispatch(messageType MessageType, data []byte) error {
+	if messageType != BinaryMessage || len(data) < 2 {
+		return ErrMuxProtocol
+	}
+	id, n := binary.Uvarint(data[1:])
+	if n <= 0 || id > 1<<32-1 {
+		return ErrMuxProtocol
+	}
+	payload := data[1+n:]
+
+	ch := m.channel(uint32(id))
+	if ch == nil {
+		return ErrConnectionClosed
+	}
+
+	switch data[0] {
+	case muxData:
+		return ch.receive(payload, m.window)
+	case muxWindow:
+		increment, n := binary.Uvarint(payload)
+		if n <= 0 || n != len(payload) || increment > uint64(m.window) {
+			return ErrMuxProtocol
+		}
+		ch.grant(int(increment))
+	case muxClose:
+		if ch.remoteClose() {
+			m.remove(ch)
+		}
+	default:
+		return ErrMuxProtocol
+	}
+	return nil
+}
+
+// channel returns the state of channel id, creating it if needed. Frames
+// for channels not yet opened locally are buffered within the window.
+func (m *Mux) channel(id uint32) *muxChannel {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.err != nil {
+		return nil
+	}
+	ch, ok := m.channels[id]
+	if !ok {
+		ch = newMuxChannel(id, m.window)
+		m.channels[id] = ch
+	}
+	return ch
+}
+
+// remove forgets a channel closed by both ends so its ID can be reused
+func (m *Mux) remove(ch *muxChannel) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.channels[ch.id] == ch {
+		delete(m.channels, ch.id)
+	}
+}
+
+// stop records err and wakes every blocked channel operation
+func (m *Mux) stop(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return
+	}
+	m.err = err
+	close(m.done)
+}
+
+// send writes a frame of type typ for channel id
+func (m *Mux) send(ctx context.Context, typ byte, id uint32, payload []byte) error {
+	frame := make([]byte, 0, 1+binary.MaxVarintLen32+len(payload))
+	frame = append(frame, typ)
+	frame = binary.AppendUvarint(frame, uint64(id))
+	frame = append(frame, payload...)
+	return m.writeMessage(ctx, BinaryMessage, frame)
+}
+
+// muxChannel is the state shared by the handles of one channel
+type muxChannel struct {
+	id uint32
+
+	mu           sync.Mutex
+	recv         [][]byte
+	buffered     int
+	consumed     int // Bytes read but not yet returned to the peer's window
+	credit       int // Bytes this end may still send
+	localClosed  bool
+	remoteClosed bool
+
+	readable chan struct{}
+	writable chan struct{}
+}
+
+// newMuxChannel creates a channel with a full send window
+func newMuxChannel(id uint32, window int) *muxChannel {
+	return &muxChannel{
+		id:       id,
+		credit:   window,
+		readable: make(chan struct{}, 1),
+		writable: make(chan struct{}, 1),
+	}
+}
+
+// receive buffers a message from the peer. A peer may start a message
+// while it has any credit left, so the buffer never needs to hold more
+// than the window plus one message.
+func (ch *muxChannel) receive(payload []byte, window int) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	if ch.remoteClosed || ch.buffered+ch.consumed >= window {
+		return ErrMuxProtocol
+	}
+	ch.recv = append(ch.recv, payload)
+	ch.buffered += len(payload)
+	notify(ch.readable)
+	return nil
+}
+
+// grant adds send credit returned by the peer
+func (ch *muxChannel) grant(n int) {
+	ch.mu.Lock()
+	ch.credit += n
+	ch.mu.Unlock()
+	notify(ch.writable)
+}
+
+// remoteClose records that the peer closed the channel, reporting whether
+// both ends are now closed
+func (ch *muxChannel) remoteClose() bool {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.remoteClosed = true
+	notify(ch.readable)
+	notify(ch.writable)
+	return ch.localClosed
+}
+
+// notify wakes a waiter without blocking
+func notify(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+// Channel is a typed handle to a logical channel of a Mux. Messages are
+// serialized the same way as on a Conn.
+type Channel[T any] struct {
+	mux *Mux
+	ch  *muxChannel
+}
+
+// OpenChannel returns a handle to channel id of m. Both ends open a channel
+// by agreeing on its ID; messages sent before the peer opens it are
+// buffered within the window.
+func OpenChannel[T any](m *Mux, id uint32) (*Channel[T], error) {
+	ch := m.channel(id)
+	if ch == nil {
+		return nil, ErrConnectionClosed
+	}
+
+	ch.mu.Lock()
+	closed := ch.localClosed
+	ch.mu.Unlock()
+	if closed {
+		return nil, ErrChannelClosed
+	}
+	return &Channel[T]{mux: m, ch: ch}, nil
+}
+
+// ID returns the channel ID
+func (c *Channel[T]) ID() uint32 {
+	return c.ch.id
+}
+
+// Read reads the next message on the channel. It returns ErrChannelClosed
+// once the peer has closed the channel and its messages have been read.
+func (c *Channel[T]) Read(ctx context.Context) (T, error) {
+	var zero T
+	ch := c.ch
+
+	for {
+		ch.mu.Lock()
+		if len(ch.recv) > 0 {
+			payload := ch.recv[0]
+			ch.recv[0] = nil
+			ch.recv = ch.recv[1:]
+			ch.buffered -= len(payload)
+			ch.consumed += len(payload)
+
+			// Return credit in batches to limit window updates
+			var increment int
+			if ch.consumed >= c.mux.window/2 {
+				increment = ch.consumed
+				ch.consumed = 0
+			}
+			remoteClosed := ch.remoteClosed
+			ch.mu.Unlock()
+
+			if increment > 0 && !remoteClosed {
+				update := binary.AppendUvarint(nil, uint64(increment))
+				if err := c.mux.send(context.Background(), muxWindow, ch.id, update); err != nil {
+					// The peer would stall on the lost credit
+					c.mux.closeConn(int(CloseInternalError), "")
+					c.mux.stop(err)
+					return zero, err
+				}
+			}
+
+			if len(payload) == 0 {
+				return zero, nil
+			}
+			return decode[T](payload, false)
+		}
+		remoteClosed := ch.remoteClosed
+		ch.mu.Unlock()
+
+		if remoteClosed {
+			return zero, ErrChannelClosed
+		}
+
+		select {
+		case <-ch.readable:
+		case <-c.mux.done:
+			return zero, c.mux.Err()
+		case <-ctx.Done():
+			return zero, ctx.Err()
+		}
+	}
+}
+
+// Write sends msg on the channel, blocking while the peer's window is
+// exhausted
+func (c *Channel[T]) Write(ctx context.Context, msg T) error {
+	_, payload, err := encode(msg)
+	if err != nil {
+		return err
+	}
+
+	ch := c.ch
+	for {
+		ch.mu.Lock()
+		if ch.localClosed || ch.remoteClosed {
+			ch.mu.Unlock()
+			return ErrChannelClosed
+		}
+		if ch.credit > 0 {
+			ch.credit -= len(payload)
+			ch.mu.Unlock()
+			break
+		}
+		ch.mu.Unlock()
+
+		select {
+		case <-ch.writable:
+		case <-c.mux.done:
+			return c.mux.Err()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return c.mux.send(ctx, muxData, ch.id, payload)
+}
+
+// Close tells the peer no more messages will be written on the channel.
+// Messages the peer already sent can still be read.
+func (c *Channel[T]) Close() error {
+	ch := c.ch
+
+	ch.mu.Lock()
+	if ch.localClosed {
+		ch.mu.Unlock()
+		return nil
+	}
+	ch.localClosed = true
+	remoteClosed := ch.remoteClosed
+	ch.mu.Unlock()
+	notify(ch.writable)
+
+	if remoteClosed {
+		c.mux.remove(ch)
+	}
+	return c.mux.send(context.Background(), muxClose, ch.id, nil)
+}
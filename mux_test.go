@@ -0,0 +1,171 @@
+package axon_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+// muxPair returns muxes on both ends of a connection
+func muxPair(t *testing.T, opts *axon.MuxOptions) (server, client *axon.Mux) {
+	t.Helper()
+	conns := make(chan *axon.Conn[[]byte], 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[[]byte](w, r, nil)
+		if err != nil {
+			t.Errorf("Upgrade() error = %v", err)
+			return
+		}
+		conns <- conn
+	}))
+	t.Cleanup(srv.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := axon.Dial[[]byte](ctx, "ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+
+	server = axon.NewMux(<-conns, opts)
+	client = axon.NewMux(conn, opts)
+	t.Cleanup(func() {
+		client.Close(1000, "")
+		server.Close(1000, "")
+	})
+	return server, client
+}
+
+func TestMux_Channels(t *testing.T) {
+	server, client := muxPair(t, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Messages sent before the peer opens a channel are buffered
+	chat, err := axon.OpenChannel[string](client, 1)
+	if err != nil {
+		t.Fatalf("OpenChannel() error = %v", err)
+	}
+	if err := chat.Write(ctx, "hello"); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	type point struct{ X, Y int }
+	points, err := axon.OpenChannel[point](client, 2)
+	if err != nil {
+		t.Fatalf("OpenChannel() error = %v", err)
+	}
+	if err := points.Write(ctx, point{1, 2}); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	serverPoints, _ := axon.OpenChannel[point](server, 2)
+	if p, err := serverPoints.Read(ctx); err != nil || p != (point{1, 2}) {
+		t.Errorf("Read() = %v, %v, want {1 2}", p, err)
+	}
+	serverChat, _ := axon.OpenChannel[string](server, 1)
+	if msg, err := serverChat.Read(ctx); err != nil || msg != "hello" {
+		t.Errorf("Read() = %q, %v, want hello", msg, err)
+	}
+
+	// Closing a channel ends the peer's reads once drained
+	if err := chat.Write(ctx, "bye"); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	chat.Close()
+	if msg, err := serverChat.Read(ctx); err != nil || msg != "bye" {
+		t.Errorf("Read() = %q, %v, want bye", msg, err)
+	}
+	if _, err := serverChat.Read(ctx); !errors.Is(err, axon.ErrChannelClosed) {
+		t.Errorf("Read() after peer close error = %v, want ErrChannelClosed", err)
+	}
+	if err := chat.Write(ctx, "again"); !errors.Is(err, axon.ErrChannelClosed) {
+		t.Errorf("Write() after Close error = %v, want ErrChannelClosed", err)
+	}
+}
+
+func TestMux_FlowControl(t *testing.T) {
+	server, client := muxPair(t, &axon.MuxOptions{Window: 64})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stalled, _ := axon.OpenChannel[[]byte](client, 1)
+	live, _ := axon.OpenChannel[[]byte](client, 2)
+	chunk := make([]byte, 40)
+
+	// Two chunks exhaust the window of the channel nobody reads
+	for i := 0; i < 2; i++ {
+		if err := stalled.Write(ctx, chunk); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	writeCtx, writeCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer writeCancel()
+	if err := stalled.Write(writeCtx, chunk); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Write() past window error = %v, want DeadlineExceeded", err)
+	}
+
+	// Other channels are unaffected
+	serverLive, _ := axon.OpenChannel[[]byte](server, 2)
+	for i := 0; i < 10; i++ {
+		if err := live.Write(ctx, chunk); err != nil {
+			t.Fatalf("Write() on live channel error = %v", err)
+		}
+		if _, err := serverLive.Read(ctx); err != nil {
+			t.Fatalf("Read() on live channel error = %v", err)
+		}
+	}
+
+	// Reading the stalled channel returns its window
+	serverStalled, _ := axon.OpenChannel[[]byte](server, 1)
+	for i := 0; i < 2; i++ {
+		if _, err := serverStalled.Read(ctx); err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+	}
+	if err := stalled.Write(ctx, chunk); err != nil {
+		t.Fatalf("Write() after window update error = %v", err)
+	}
+}
+
+func TestMux_ProtocolViolation(t *testing.T) {
+	conns := make(chan *axon.Conn[string], 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[string](w, r, nil)
+		if err != nil {
+			return
+		}
+		conns <- conn
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := axon.Dial[string](ctx, "ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close(1000, "")
+
+	m := axon.NewMux(<-conns, nil)
+	if err := conn.Write(ctx, "not a mux frame"); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	select {
+	case <-m.Done():
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for mux to stop")
+	}
+	if !errors.Is(m.Err(), axon.ErrMuxProtocol) {
+		t.Errorf("Err() = %v, want ErrMuxProtocol", m.Err())
+	}
+	if _, err := axon.OpenChannel[string](m, 1); !errors.Is(err, axon.ErrConnectionClosed) {
+		t.Errorf("OpenChannel() after stop error = %v, want ErrConnectionClosed", err)
+	}
+}
@@ -0,0 +1,318 @@
+package axon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// errNoMessage is returned by reads on a connection with lazy buffers once
+// the frames that arrived are handled without a data message starting
+var errNoMessage = errors.New("axon: no message available")
+
+// errPollerClosed is returned by poller.wait once the poller is closed
+var errPollerClosed = errors.New("axon: poller closed")
+
+// poller reports read readiness of file descriptors. Each registration
+// fires once, and is armed again with rearm.
+type poller interface {
+	add(fd int) error
+	rearm(fd int) error
+	remove(fd int) error
+	wait(fds []int) ([]int, error)
+	close() error
+}
+
+// ServeEvents is like Serve, but rather than a goroutine per connection it
+// waits for connections to become readable with epoll or kqueue and runs cb
+// on demand, for servers holding many mostly idle connections. An idle
+// connection holds no goroutine and no read or write buffers: they are
+// taken from the pools when a message arrives or is written, and returned
+// once it is done.
+//
+// OnMessage runs in a goroutine of its own for each batch of messages that
+// arrives, and must not read from the connection. Messages of one
+// connection are handled in order. Connections that are not plain TCP or
+// Unix sockets, such as those of a TLS listener, and every connection on
+// platforms without epoll or kqueue, are served by a goroutine as with
+// Serve. Ping intervals, send queues and Hub registration still start a
+// goroutine per connection.
+//
+// Connections still open once ctx is canceled are closed with
+// CloseGoingAway.
+func ServeEvents[T any](ctx context.Context, ln net.Listener, opts *UpgradeOptions, cb Callbacks[T]) error {
+	u := NewUpgrader(opts)
+	p, err := newPoller()
+	if errors.Is(err, errors.ErrUnsupported) {
+		return acceptLoop(ctx, ln, opts, func(nc net.Conn) {
+			go serveCallbacks(ctx, u, nc, cb)
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("axon: failed to create poller: %w", err)
+	}
+
+	s := &eventServer[T]{
+		upgrader: u,
+		poller:   p,
+		cb:       cb,
+		conns:    make(map[int]*eventConn[T]),
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.run()
+	}()
+
+	err = acceptLoop(ctx, ln, opts, func(nc net.Conn) {
+		go s.accept(ctx, nc)
+	})
+	p.close()
+	<-done
+	s.closeAll()
+	return err
+}
+
+// serveCallbacks upgrades a raw connection and runs cb's read loop in the
+// calling goroutine
+func serveCallbacks[T any](ctx context.Context, u *Upgrader, nc net.Conn, cb Callbacks[T]) {
+	conn, err := acceptConn[T](ctx, u, nc)
+	if err != nil {
+		if cb.OnError != nil {
+			cb.OnError(nil, err)
+		}
+		return
+	}
+	runConn(conn.Context(), conn, cb.serve, cb.OnError)
+}
+
+// Event connection states
+const (
+	eventIdle    int32 = iota // Waiting for readiness
+	eventBusy                 // Reading and running callbacks
+	eventClosing              // Closed while busy
+	eventDone                 // Finished
+)
+
+// eventConn is a connection served by an eventServer
+type eventConn[T any] struct {
+	conn   *Conn[T]
+	fd     int
+	state  atomic.Int32
+	finish sync.Once
+}
+
+// eventServer runs connections from readiness events
+type eventServer[T any] struct {
+	upgrader *Upgrader
+	poller   poller
+	cb       Callbacks[T]
+
+	mu    sync.Mutex
+	conns map[int]*eventConn[T] // By file descriptor
+}
+
+// accept performs the upgrade handshake on a raw connection and registers
+// it for readiness events
+func (s *eventServer[T]) accept(ctx context.Context, nc net.Conn) {
+	fd, ok := connFD(nc)
+	if !ok {
+		serveCallbacks(ctx, s.upgrader, nc, s.cb)
+		return
+	}
+	conn, err := acceptConn[T](ctx, s.upgrader, nc)
+	if err != nil {
+		if s.cb.OnError != nil {
+			s.cb.OnError(nil, err)
+		}
+		return
+	}
+
+	ec := &eventConn[T]{conn: conn, fd: fd}
+	ec.state.Store(eventBusy)
+	conn.lazyBuffers = true
+	conn.onClosed = func() {
+		s.closed(ec)
+	}
+
+	if s.cb.OnConnect != nil {
+		if err := s.callback(ec, func() error { return s.cb.OnConnect(conn.Context(), conn) }); err != nil {
+			conn.Close(int(CloseInternalError), "")
+			s.done(ec, err)
+			return
+		}
+	}
+
+	s.mu.Lock()
+	s.conns[fd] = ec
+	s.mu.Unlock()
+	if err := s.poller.add(fd); err != nil {
+		conn.Close(int(CloseInternalError), "")
+		s.done(ec, err)
+		return
+	}
+
+	// The client may have sent messages right behind the handshake
+	s.handle(ec)
+}
+
+// run dispatches readiness events until the poller is closed
+func (s *eventServer[T]) run() {
+	fds := make([]int, 0, 128)
+	for {
+		ready, err := s.poller.wait(fds[:0])
+		if err != nil {
+			return
+		}
+		for _, fd := range ready {
+			s.mu.Lock()
+			ec := s.conns[fd]
+			s.mu.Unlock()
+			if ec != nil && ec.state.CompareAndSwap(eventIdle, eventBusy) {
+				go s.handle(ec)
+			}
+		}
+	}
+}
+
+// handle reads and dispatches the messages that arrived on a busy
+// connection, then waits for readiness again with its buffers detached
+func (s *eventServer[T]) handle(ec *eventConn[T]) {
+	conn := ec.conn
+	if !conn.acquireIO() {
+		s.done(ec, ErrConnectionClosed)
+		return
+	}
+	defer conn.releaseIO()
+
+	for conn.pending() {
+		msg, err := conn.Read(conn.Context())
+		if errors.Is(err, errNoMessage) {
+			continue
+		}
+		if err != nil {
+			s.done(ec, err)
+			return
+		}
+		if s.cb.OnMessage != nil {
+			if err := s.callback(ec, func() error { return s.cb.OnMessage(conn.Context(), conn, msg) }); err != nil {
+				conn.Close(int(CloseInternalError), "")
+				s.done(ec, err)
+				return
+			}
+		}
+	}
+	conn.detachReader()
+
+	if !ec.state.CompareAndSwap(eventBusy, eventIdle) {
+		// Closed while the messages were handled
+		s.done(ec, ErrConnectionClosed)
+		return
+	}
+	if err := s.poller.rearm(ec.fd); err != nil && ec.state.CompareAndSwap(eventIdle, eventDone) {
+		conn.Close(int(CloseInternalError), "")
+		s.done(ec, err)
+	}
+}
+
+// callback runs fn, turning a panic into an error reported to OnError
+func (s *eventServer[T]) callback(ec *eventConn[T], fn func() error) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("axon: handler panic: %v", p)
+			if s.cb.OnError != nil {
+				s.cb.OnError(ec.conn, err)
+			}
+		}
+	}()
+	return fn()
+}
+
+// closed is called once the connection is marked closed, before its socket
+// is, so that the descriptor is unregistered before it can be reused. A
+// connection closed while idle is finished here.
+func (s *eventServer[T]) closed(ec *eventConn[T]) {
+	s.unregister(ec)
+	for {
+		switch ec.state.Load() {
+		case eventIdle:
+			if ec.state.CompareAndSwap(eventIdle, eventDone) {
+				// Close is still running, and OnClose may use the
+				// connection
+				go s.done(ec, ErrConnectionClosed)
+				return
+			}
+		case eventBusy:
+			if ec.state.CompareAndSwap(eventBusy, eventClosing) {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// unregister stops the readiness events of a connection
+func (s *eventServer[T]) unregister(ec *eventConn[T]) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conns[ec.fd] == ec {
+		delete(s.conns, ec.fd)
+		s.poller.remove(ec.fd)
+	}
+}
+
+// done finishes a connection once, with the error that ended it
+func (s *eventServer[T]) done(ec *eventConn[T], err error) {
+	ec.finish.Do(func() {
+		ec.state.Store(eventDone)
+		s.unregister(ec)
+		if s.cb.OnClose != nil {
+			s.callback(ec, func() error {
+				s.cb.OnClose(ec.conn, err)
+				return nil
+			})
+		}
+		ec.conn.Close(int(CloseNormalClosure), "")
+	})
+}
+
+// closeAll closes the connections still registered when the server stops
+func (s *eventServer[T]) closeAll() {
+	s.mu.Lock()
+	conns := make([]*eventConn[T], 0, len(s.conns))
+	for _, ec := range s.conns {
+		conns = append(conns, ec)
+	}
+	s.mu.Unlock()
+	for _, ec := range conns {
+		ec.conn.Close(int(CloseGoingAway), "")
+	}
+}
+
+// pending reports whether data has arrived on the connection, without
+// waiting for any
+func (c *Conn[T]) pending() bool {
+	return (c.reader != nil && c.reader.Buffered() > 0) || readable(c.conn)
+}
+
+// connFD returns the file descriptor of a plain socket
+func connFD(nc net.Conn) (int, bool) {
+	sc, ok := nc.(syscall.Conn)
+	if !ok {
+		return 0, false
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return 0, false
+	}
+	fd := -1
+	if err := rc.Control(func(s uintptr) { fd = int(s) }); err != nil || fd < 0 {
+		return 0, false
+	}
+	return fd, true
+}
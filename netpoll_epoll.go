@@ -0,0 +1,105 @@
+//go:build linux
+
+package axon
+
+import (
+	"sync"
+	"syscall"
+)
+
+// epoll is a poller using Linux epoll
+type epoll struct {
+	fd     int
+	wake   [2]int // Pipe whose read end wakes wait on close
+	events []syscall.EpollEvent
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// newPoller creates an epoll instance
+func newPoller() (poller, error) {
+	fd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	p := &epoll{fd: fd, events: make([]syscall.EpollEvent, 128)}
+	if err := syscall.Pipe2(p.wake[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	ev := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(p.wake[0])}
+	if err := syscall.EpollCtl(fd, syscall.EPOLL_CTL_ADD, p.wake[0], &ev); err != nil {
+		p.release()
+		return nil, err
+	}
+	return p, nil
+}
+
+// ctl changes the registration of fd, unless the poller is closed
+func (p *epoll) ctl(op, fd int) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return errPollerClosed
+	}
+	ev := syscall.EpollEvent{Events: syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT, Fd: int32(fd)}
+	return syscall.EpollCtl(p.fd, op, fd, &ev)
+}
+
+func (p *epoll) add(fd int) error {
+	return p.ctl(syscall.EPOLL_CTL_ADD, fd)
+}
+
+func (p *epoll) rearm(fd int) error {
+	return p.ctl(syscall.EPOLL_CTL_MOD, fd)
+}
+
+func (p *epoll) remove(fd int) error {
+	return p.ctl(syscall.EPOLL_CTL_DEL, fd)
+}
+
+// wait blocks until descriptors are ready and appends them to fds. Once
+// the poller is closed it releases the epoll instance and returns
+// errPollerClosed.
+func (p *epoll) wait(fds []int) ([]int, error) {
+	for {
+		n, err := syscall.EpollWait(p.fd, p.events, -1)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return fds, err
+		}
+		for _, ev := range p.events[:n] {
+			if int(ev.Fd) == p.wake[0] {
+				p.release()
+				return fds, errPollerClosed
+			}
+			fds = append(fds, int(ev.Fd))
+		}
+		return fds, nil
+	}
+}
+
+// close wakes wait, which then releases the poller
+func (p *epoll) close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	_, err := syscall.Write(p.wake[1], []byte{0})
+	return err
+}
+
+// release closes the epoll instance and the wake pipe
+func (p *epoll) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	syscall.Close(p.fd)
+	syscall.Close(p.wake[0])
+	syscall.Close(p.wake[1])
+}
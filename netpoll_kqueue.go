@@ -0,0 +1,116 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package axon
+
+import (
+	"sync"
+	"syscall"
+)
+
+// kqueue is a poller using BSD kqueue
+type kqueue struct {
+	fd     int
+	wake   [2]int // Pipe whose read end wakes wait on close
+	events []syscall.Kevent_t
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// newPoller creates a kqueue
+func newPoller() (poller, error) {
+	fd, err := syscall.Kqueue()
+	if err != nil {
+		return nil, err
+	}
+	syscall.CloseOnExec(fd)
+	p := &kqueue{fd: fd, events: make([]syscall.Kevent_t, 128)}
+	if err := syscall.Pipe(p.wake[:]); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	for _, wfd := range p.wake {
+		syscall.CloseOnExec(wfd)
+		syscall.SetNonblock(wfd, true)
+	}
+	var ev syscall.Kevent_t
+	syscall.SetKevent(&ev, p.wake[0], syscall.EVFILT_READ, syscall.EV_ADD)
+	if _, err := syscall.Kevent(fd, []syscall.Kevent_t{ev}, nil, nil); err != nil {
+		p.release()
+		return nil, err
+	}
+	return p, nil
+}
+
+// ctl changes the registration of fd, unless the poller is closed
+func (p *kqueue) ctl(fd, flags int) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return errPollerClosed
+	}
+	var ev syscall.Kevent_t
+	syscall.SetKevent(&ev, fd, syscall.EVFILT_READ, flags)
+	_, err := syscall.Kevent(p.fd, []syscall.Kevent_t{ev}, nil, nil)
+	return err
+}
+
+func (p *kqueue) add(fd int) error {
+	return p.ctl(fd, syscall.EV_ADD|syscall.EV_ONESHOT)
+}
+
+func (p *kqueue) rearm(fd int) error {
+	return p.ctl(fd, syscall.EV_ADD|syscall.EV_ONESHOT)
+}
+
+func (p *kqueue) remove(fd int) error {
+	// A fired one-shot registration is already gone
+	if err := p.ctl(fd, syscall.EV_DELETE); err != nil && err != syscall.ENOENT {
+		return err
+	}
+	return nil
+}
+
+// wait blocks until descriptors are ready and appends them to fds. Once
+// the poller is closed it releases the kqueue and returns errPollerClosed.
+func (p *kqueue) wait(fds []int) ([]int, error) {
+	for {
+		n, err := syscall.Kevent(p.fd, nil, p.events, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return fds, err
+		}
+		for _, ev := range p.events[:n] {
+			if int(ev.Ident) == p.wake[0] {
+				p.release()
+				return fds, errPollerClosed
+			}
+			fds = append(fds, int(ev.Ident))
+		}
+		return fds, nil
+	}
+}
+
+// close wakes wait, which then releases the poller
+func (p *kqueue) close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	_, err := syscall.Write(p.wake[1], []byte{0})
+	return err
+}
+
+// release closes the kqueue and the wake pipe
+func (p *kqueue) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	syscall.Close(p.fd)
+	syscall.Close(p.wake[0])
+	syscall.Close(p.wake[1])
+}
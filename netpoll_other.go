@@ -0,0 +1,19 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package axon
+
+import (
+	"errors"
+	"net"
+)
+
+// newPoller reports that readiness polling is not supported, so
+// ServeEvents serves each connection with a goroutine
+func newPoller() (poller, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// readable is never called without a poller
+func readable(nc net.Conn) bool {
+	return false
+}
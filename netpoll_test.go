@@ -0,0 +1,256 @@
+package axon_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+// startServeEvents runs ServeEvents on a loopback listener until the
+// returned stop function is called or the test ends
+func startServeEvents(t *testing.T, cb axon.Callbacks[string]) (string, func()) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- axon.ServeEvents(ctx, ln, nil, cb)
+	}()
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			cancel()
+			select {
+			case err := <-done:
+				if err != context.Canceled {
+					t.Errorf("expected ServeEvents to return context.Canceled, got %v", err)
+				}
+			case <-time.After(time.Second):
+				t.Error("timeout waiting for ServeEvents to return")
+			}
+		})
+	}
+	t.Cleanup(stop)
+	return ln.Addr().String(), stop
+}
+
+// closeLog collects the errors passed to OnClose
+type closeLog struct {
+	ch chan error
+}
+
+func newCloseLog() *closeLog {
+	return &closeLog{ch: make(chan error, 16)}
+}
+
+func (l *closeLog) onClose(conn *axon.Conn[string], err error) {
+	l.ch <- err
+}
+
+// next waits for the next OnClose
+func (l *closeLog) next(t *testing.T) error {
+	t.Helper()
+	select {
+	case err := <-l.ch:
+		return err
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for OnClose")
+		return nil
+	}
+}
+
+func TestServeEvents_Echo(t *testing.T) {
+	closes := newCloseLog()
+	addr, _ := startServeEvents(t, axon.Callbacks[string]{
+		OnMessage: func(ctx context.Context, conn *axon.Conn[string], msg string) error {
+			return conn.Write(ctx, msg)
+		},
+		OnClose: closes.onClose,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	conn, err := axon.Dial[string](ctx, "ws://"+addr+"/", nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+
+	for _, msg := range []string{"one", "two", "three"} {
+		if err := conn.Write(ctx, msg); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		got, err := conn.Read(ctx)
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+		if got != msg {
+			t.Errorf("Read() = %q, want %q", got, msg)
+		}
+	}
+
+	// A ping alone is answered without ending the connection
+	if err := conn.Ping(ctx, []byte("ping")); err != nil {
+		t.Fatalf("Ping() error = %v", err)
+	}
+	if err := conn.Write(ctx, "after ping"); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if got, err := conn.Read(ctx); err != nil || got != "after ping" {
+		t.Errorf("Read() = %q, %v, want %q", got, err, "after ping")
+	}
+
+	conn.Close(int(axon.CloseNormalClosure), "bye")
+	var closeErr *axon.CloseError
+	if err := closes.next(t); !errors.As(err, &closeErr) || closeErr.Code != axon.CloseNormalClosure {
+		t.Errorf("OnClose error = %v, want a normal CloseError", err)
+	}
+}
+
+func TestServeEvents_ServerClose(t *testing.T) {
+	closes := newCloseLog()
+	conns := make(chan *axon.Conn[string], 1)
+	addr, _ := startServeEvents(t, axon.Callbacks[string]{
+		OnConnect: func(ctx context.Context, conn *axon.Conn[string]) error {
+			conns <- conn
+			return nil
+		},
+		OnClose: closes.onClose,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	conn, err := axon.Dial[string](ctx, "ws://"+addr+"/", nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close(int(axon.CloseNormalClosure), "")
+
+	// Closing an idle connection from outside its callbacks finishes it
+	server := <-conns
+	time.Sleep(10 * time.Millisecond)
+	server.Close(int(axon.ClosePolicyViolation), "bye")
+	if err := closes.next(t); !errors.Is(err, axon.ErrConnectionClosed) {
+		t.Errorf("OnClose error = %v, want ErrConnectionClosed", err)
+	}
+
+	var closeErr *axon.CloseError
+	if _, err := conn.Read(ctx); !errors.As(err, &closeErr) || closeErr.Code != axon.ClosePolicyViolation {
+		t.Errorf("Read() error = %v, want a CloseError with ClosePolicyViolation", err)
+	}
+}
+
+func TestServeEvents_Shutdown(t *testing.T) {
+	closes := newCloseLog()
+	addr, stop := startServeEvents(t, axon.Callbacks[string]{
+		OnClose: closes.onClose,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	conn, err := axon.Dial[string](ctx, "ws://"+addr+"/", nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close(int(axon.CloseNormalClosure), "")
+	time.Sleep(10 * time.Millisecond)
+
+	stop()
+	closes.next(t)
+	var closeErr *axon.CloseError
+	if _, err := conn.Read(ctx); !errors.As(err, &closeErr) || closeErr.Code != axon.CloseGoingAway {
+		t.Errorf("Read() error = %v, want a CloseError with CloseGoingAway", err)
+	}
+}
+
+func TestServeEvents_IdleConnsHoldNoGoroutines(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" && runtime.GOOS != "freebsd" {
+		t.Skip("readiness polling is not supported on " + runtime.GOOS)
+	}
+
+	var mu sync.Mutex
+	connected := 0
+	addr, _ := startServeEvents(t, axon.Callbacks[string]{
+		OnConnect: func(ctx context.Context, conn *axon.Conn[string]) error {
+			mu.Lock()
+			connected++
+			mu.Unlock()
+			return nil
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	before := runtime.NumGoroutine()
+
+	const n = 50
+	for i := 0; i < n; i++ {
+		conn, err := axon.Dial[string](ctx, "ws://"+addr+"/", nil)
+		if err != nil {
+			t.Fatalf("Dial() error = %v", err)
+		}
+		defer conn.Close(int(axon.CloseNormalClosure), "")
+	}
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		mu.Lock()
+		done := connected == n
+		mu.Unlock()
+		if done || time.Now().After(deadline) {
+			break
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if grown := runtime.NumGoroutine() - before; grown > n/5 {
+		t.Errorf("%d idle connections started %d goroutines", n, grown)
+	}
+}
+
+func TestServeEvents_MessageBehindHandshake(t *testing.T) {
+	received := make(chan string, 1)
+	addr, _ := startServeEvents(t, axon.Callbacks[string]{
+		OnMessage: func(ctx context.Context, conn *axon.Conn[string], msg string) error {
+			received <- msg
+			return nil
+		},
+	})
+
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer nc.Close()
+
+	// The frame arrives with the request, so the server has read it by the
+	// time the handshake is done
+	var req bytes.Buffer
+	req.WriteString("GET / HTTP/1.1\r\nHost: example.com\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	if err := writeClientFrame(&req, 0x1, []byte(`"early"`)); err != nil {
+		t.Fatalf("failed to build frame: %v", err)
+	}
+	if _, err := nc.Write(req.Bytes()); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	select {
+	case msg := <-received:
+		if msg != "early" {
+			t.Errorf("OnMessage got %q, want %q", msg, "early")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for the message sent behind the handshake")
+	}
+}
@@ -0,0 +1,29 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package axon
+
+import (
+	"net"
+	"syscall"
+)
+
+// readable reports whether data or EOF is waiting on a socket, without
+// consuming it
+func readable(nc net.Conn) bool {
+	sc, ok := nc.(syscall.Conn)
+	if !ok {
+		return false
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return false
+	}
+	ready := false
+	rc.Control(func(fd uintptr) {
+		var b [1]byte
+		_, _, err := syscall.Recvfrom(int(fd), b[:], syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+		// Errors other than an empty socket are left for the read to report
+		ready = err != syscall.EAGAIN && err != syscall.EWOULDBLOCK
+	})
+	return ready
+}
@@ -1,7 +1,11 @@
 package axon
 
 import (
+	"context"
+	"crypto/x509"
+	"log/slog"
 	"net/http"
+	"net/netip"
 	"time"
 )
 
@@ -15,6 +19,12 @@ type UpgradeOptions struct {
 	// Default is 4096 bytes.
 	WriteBufferSize int
 
+	// DisableBufferPool allocates the read and write buffers of each
+	// connection rather than taking them from shared pools, so no idle
+	// buffers are kept in memory. Pooled buffers are rounded up to a power
+	// of two, and buffers over 1MB are never pooled.
+	DisableBufferPool bool
+
 	// MaxFrameSize sets the maximum frame size in bytes.
 	// Frames exceeding this size will result in ErrFrameTooLarge.
 	// Default is 4096 bytes.
@@ -25,6 +35,16 @@ type UpgradeOptions struct {
 	// Default is 1048576 bytes (1MB).
 	MaxMessageSize int
 
+	// LenientProtocol tolerates protocol violations of sloppy peers rather
+	// than closing the connection: frames masked contrary to RFC 6455
+	// Section 5.1, text messages and close reasons that are not valid
+	// UTF-8, and control frames over 125 bytes, whose pings are answered
+	// with the first 125 bytes. Unsolicited pongs, which the RFC allows,
+	// are always accepted.
+	// Default is false (violations close the connection with
+	// CloseProtocolError or CloseInvalidPayloadData).
+	LenientProtocol bool
+
 	// ReadDeadline sets the read deadline for connections.
 	// Default is no deadline.
 	ReadDeadline time.Duration
@@ -33,6 +53,27 @@ type UpgradeOptions struct {
 	// Default is no deadline.
 	WriteDeadline time.Duration
 
+	// HandshakeTimeout bounds writing the upgrade response once the
+	// connection is hijacked, so a stalled client cannot hold the handler.
+	// Serve also applies it to reading the upgrade request.
+	// Default is 10s.
+	HandshakeTimeout time.Duration
+
+	// MaxHeaderBytes limits the total size of the upgrade request's
+	// headers. Larger requests are rejected with ErrHeaderTooLarge, answered
+	// with 431 Request Header Fields Too Large.
+	// Default is 1MB, as for http.Server.
+	MaxHeaderBytes int
+
+	// UpgradeError writes the response to a rejected upgrade request, given
+	// the status UpgradeErrorStatus maps the error to.
+	// Default is nil (http.Error with the status text).
+	UpgradeError func(w http.ResponseWriter, r *http.Request, status int, err error)
+
+	// NoErrorResponse leaves the response to a rejected upgrade request to
+	// the caller of Upgrade, which can use UpgradeErrorStatus to pick it
+	NoErrorResponse bool
+
 	// PingInterval sets the interval for sending ping frames.
 	// If zero, pings are disabled.
 	// Default is 0 (disabled).
@@ -43,17 +84,222 @@ type UpgradeOptions struct {
 	// Default is 0 (disabled).
 	PongTimeout time.Duration
 
+	// ExtendReadDeadline makes received pongs, or any frames, push the
+	// read deadline back by PingInterval plus PongTimeout, replacing
+	// ReadDeadline while pings are enabled. A Read then waits for as
+	// long as the peer answers pings, and fails soon after it stops.
+	// Default is ExtendNever.
+	ExtendReadDeadline DeadlineExtension
+
+	// Clock drives the ping, idle and credential expiry timers of
+	// upgraded connections.
+	// Default is SystemClock.
+	Clock Clock
+
 	// CheckOrigin sets a function to validate the origin header.
 	// If nil, all origins are allowed.
 	// Default is nil (all origins allowed).
 	CheckOrigin func(r *http.Request) bool
 
 	// Subprotocols sets the list of supported subprotocols.
-	// The client's requested subprotocol must match one of these.
+	// The client's requested subprotocol must match one of these, and "*"
+	// matches any. The client's first match is selected, unless
+	// PreferServerSubprotocols is set.
 	// Default is nil (no subprotocols).
 	Subprotocols []string
 
+	// PreferServerSubprotocols selects the first of Subprotocols that the
+	// client requested, rather than the first request that is supported
+	PreferServerSubprotocols bool
+
+	// SelectSubprotocol picks the subprotocol from those the client
+	// requested, in the client's order, replacing Subprotocols. Returning
+	// "" accepts the connection without a subprotocol, and returning one
+	// the client did not request rejects it with ErrInvalidSubprotocol.
+	// It is not called when the client requests none.
+	SelectSubprotocol func(requested []string) string
+
 	// Compression enables per-message compression (RFC 7692).
 	// Default is false (disabled).
 	Compression bool
+
+	// CompressionThreshold sets the minimum message size to compress.
+	// Messages smaller than this will not be compressed.
+	// Default is 256 bytes.
+	CompressionThreshold int
+
+	// CompressionLevel sets the flate compression level, from
+	// flate.HuffmanOnly (-2) to flate.BestCompression (9).
+	// Default is 0, which selects flate.BestSpeed.
+	CompressionLevel int
+
+	// CompressionStrategy selects per-connection or pooled compressors.
+	// Pooled compressors use far less memory per idle connection but
+	// cannot keep context between messages.
+	// Default is CompressionPerConnection.
+	CompressionStrategy CompressionStrategy
+
+	// ServerNoContextTakeover resets the server's compressor after every
+	// message and tells the client it need not retain a window.
+	// Default is false (context takeover).
+	ServerNoContextTakeover bool
+
+	// ClientNoContextTakeover asks the client to reset its compressor after
+	// every message, so the server need not retain a decompression window.
+	// Default is false (context takeover).
+	ClientNoContextTakeover bool
+
+	// ClientMaxWindowBits limits the client's LZ77 window to
+	// 2^ClientMaxWindowBits bytes when the client supports it.
+	// Valid values are 8-15. Default is 0 (no limit).
+	ClientMaxWindowBits int
+
+	// Extensions are extension plugins accepted when the client offers
+	// them, in order after permessage-deflate.
+	// Default is nil (none).
+	Extensions []ExtensionPlugin
+
+	// Interceptors run around every upgrade, in order.
+	// Default is nil (no interceptors).
+	Interceptors []UpgradeInterceptor
+
+	// BaseContext returns the context that the context of a connection
+	// upgraded from r derives from, in place of r's context, which net/http
+	// cancels once the handler returns. Values of r's context and of
+	// interceptors are still available from Conn.Context.
+	// Default is nil (r's context, without its cancellation for hijacked
+	// connections).
+	BaseContext func(r *http.Request) context.Context
+
+	// MaxConnections limits the number of open connections upgraded by the
+	// same Upgrader. Excess upgrades are rejected with 503.
+	// Default is 0 (unlimited).
+	MaxConnections int
+
+	// MaxConnectionsPerIP limits the number of open connections from a
+	// single remote IP. Excess upgrades are rejected with 503.
+	// Default is 0 (unlimited).
+	MaxConnectionsPerIP int
+
+	// RetryAfter sets the Retry-After header sent when an upgrade is
+	// rejected because a limit was reached.
+	// Default is 1 second.
+	RetryAfter time.Duration
+
+	// RateLimiter throttles upgrade requests before any other limit is
+	// checked. Throttled upgrades are rejected with 429.
+	// Default is nil (no rate limiting).
+	RateLimiter RateLimiter
+
+	// Metrics records failed and rejected handshakes and the upgraded
+	// connections' traffic, if set.
+	// Default is nil.
+	Metrics *Metrics
+
+	// Logger receives records about rejected upgrades and the upgraded
+	// connections, such as failed pings and protocol errors. Connection
+	// records carry a conn_id attribute.
+	// Default is nil (no logging).
+	Logger *slog.Logger
+
+	// Trace is called with every frame read or written on upgraded
+	// connections, for wire-level debugging. Wrap it with RedactPayloads
+	// to keep message contents out of traces.
+	// Default is nil.
+	Trace TraceFunc
+
+	// SendQueueSize sets the capacity of the queue used by SendAsync.
+	// Default is 256 messages.
+	SendQueueSize int
+
+	// OverflowPolicy decides what SendAsync does when the queue is full.
+	// Default is OverflowDropNewest.
+	OverflowPolicy OverflowPolicy
+
+	// EnableHTTP2 accepts WebSockets bootstrapped over HTTP/2 with an
+	// extended CONNECT request (RFC 8441). The http.Server must advertise
+	// SETTINGS_ENABLE_CONNECT_PROTOCOL, which net/http does when run with
+	// GODEBUG=http2xconnect=1. The connection lives only as long as the
+	// handler that upgraded it. DialOptions.EnableHTTP2 dials such servers.
+	// Default is false.
+	EnableHTTP2 bool
+
+	// HTTPFallback serves clients whose upgrade is blocked, such as by
+	// proxies that strip the Upgrade header, over a server-sent event
+	// stream for reads and HTTP POST requests for writes. Only Handler and
+	// CallbackHandler serve it; see ClientOptions.HTTPFallback.
+	// Default is false.
+	HTTPFallback bool
+
+	// TrustedProxies lists the networks of the load balancers and reverse
+	// proxies in front of the server. Their Forwarded and X-Forwarded-*
+	// headers decide the client IP recorded under MetaClientIP, counted
+	// by MaxConnectionsPerIP and keyed by KeyByIP; see ClientIP.
+	// Default is nil (forwarding headers are ignored).
+	TrustedProxies []netip.Prefix
+
+	// VerifyPeerCertificate checks the client certificate of every upgrade
+	// request, such as one verified against the http.Server's ClientCAs.
+	// Requests without a certificate, or whose certificate it rejects, are
+	// answered with 403 Forbidden. See RequireVerifiedChain.
+	// Default is nil (client certificates are not checked).
+	VerifyPeerCertificate func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error
+
+	// Authenticator validates the bearer token of every upgrade request,
+	// taken from the Authorization header or the access_token query
+	// parameter. Requests without a valid token are rejected with 401
+	// Unauthorized. The principal is stored under MetaPrincipal, and the
+	// connection is closed with CloseAuthExpired when the token expires.
+	// Default is nil (no authentication).
+	Authenticator Authenticator
+
+	// Bans rejects upgrade requests from banned clients with 403
+	// Forbidden. Requests are checked by client IP and, once
+	// authenticated, by principal if it is a string. Pass Hub.Bans to
+	// enforce bans made with Hub.Ban.
+	// Default is nil (no ban list).
+	Bans BanStore
+
+	// ProxyProtocol makes Serve expect a PROXY protocol header (version 1
+	// or 2) on each connection, as sent by L4 load balancers, so that
+	// Conn.RemoteAddr and MetaClientIP report the client. With
+	// TrustedProxies set only connections from those networks carry the
+	// header; others are taken to come straight from clients. Upgrade and
+	// Handler ignore it; see ProxyProtocolListener.
+	// Default is false.
+	ProxyProtocol bool
+
+	// PayloadCipher encrypts and decrypts every message payload, end to
+	// end and independently of TLS; see Conn.UseCipher.
+	// Default is nil.
+	PayloadCipher PayloadCipher
+
+	// Faults injects dropped messages, delays and forced closes into
+	// every connection, for resilience tests; see Conn.InjectFaults.
+	// Default is nil.
+	Faults *Faults
+
+	// IdleTimeout closes connections with CloseGoingAway once no data
+	// message was read or written for this long. Pings and pongs do not
+	// count, so peers that only keep the connection alive are closed too.
+	// Default is 0 (no limit).
+	IdleTimeout time.Duration
+
+	// SlowClientPolicy closes connections with ClosePolicyViolation when
+	// a write stalls for too long, as it does once a peer stops reading.
+	// Default is nil (writes block until WriteDeadline).
+	SlowClientPolicy *SlowClientPolicy
+}
+
+// UpgradeInterceptor hooks into the upgrade of a valid WebSocket request,
+// for example to authenticate it or resolve a tenant
+type UpgradeInterceptor struct {
+	// Before runs before the connection is hijacked. The returned context
+	// is passed to later interceptors and becomes the connection's context.
+	// Returning an error rejects the upgrade with 403 Forbidden.
+	Before func(r *http.Request) (context.Context, error)
+
+	// After runs once the connection is established. Returning an error
+	// closes the connection with ClosePolicyViolation.
+	After func(ctx context.Context, conn RegisteredConn) error
 }
@@ -0,0 +1,81 @@
+package axon
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// pipeURL is the URL dialed by in-memory connections
+const pipeURL = "ws://pipe/"
+
+// Pipe returns the two ends of an in-memory connection over net.Pipe, for
+// tests. The client end dials with clientOpts and the server end is
+// upgraded with serverOpts, through a real handshake, so subprotocols,
+// compression, authentication and interceptors are negotiated as over the
+// network and client frames are masked. Either options may be nil.
+func Pipe[T any](clientOpts *DialOptions, serverOpts *UpgradeOptions) (client, server *Conn[T], err error) {
+	u := NewUpgrader(serverOpts)
+	clientEnd, serverEnd := net.Pipe()
+
+	type accepted struct {
+		conn *Conn[T]
+		err  error
+	}
+	done := make(chan accepted, 1)
+	go func() {
+		conn, err := acceptConn[T](context.Background(), u, serverEnd)
+		done <- accepted{conn, err}
+	}()
+
+	d := NewDialer(pipeDialOptions(clientOpts, func() net.Conn { return clientEnd }))
+	client, err = DialWithDialer[T](context.Background(), d, pipeURL)
+	if err != nil {
+		clientEnd.Close()
+		<-done
+		return nil, nil, err
+	}
+
+	res := <-done
+	if res.err != nil {
+		client.Close(int(CloseNormalClosure), "")
+		return nil, nil, res.err
+	}
+	return client, res.conn, nil
+}
+
+// NewTestClient creates a Client whose connections are served in memory by
+// fn, with the server side upgraded with serverOpts. Every connection the
+// client makes, including reconnections, gets its own Pipe.
+func NewTestClient[T any](opts *ClientOptions, serverOpts *UpgradeOptions, fn HandlerFunc[T]) *Client[T] {
+	if opts == nil {
+		opts = DefaultClientOptions()
+	}
+	u := NewUpgrader(serverOpts)
+
+	clientOpts := *opts
+	clientOpts.DialOptions = *pipeDialOptions(&opts.DialOptions, func() net.Conn {
+		clientEnd, serverEnd := net.Pipe()
+		go serveConn(context.Background(), u, serverEnd, fn)
+		return clientEnd
+	})
+	return NewClient[T](pipeURL, &clientOpts)
+}
+
+// pipeDialOptions returns a copy of opts that dials connections returned
+// by newConn rather than the network
+func pipeDialOptions(opts *DialOptions, newConn func() net.Conn) *DialOptions {
+	var pipeOpts DialOptions
+	if opts != nil {
+		pipeOpts = *opts
+	}
+	pipeOpts.DialContext = func(context.Context, string, string) (net.Conn, error) {
+		return newConn(), nil
+	}
+	pipeOpts.Proxy = func(*http.Request) (*url.URL, error) {
+		return nil, nil
+	}
+	pipeOpts.HostOverrides = nil
+	return &pipeOpts
+}
@@ -0,0 +1,101 @@
+package axon_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+func TestPipe(t *testing.T) {
+	client, server, err := axon.Pipe[string](
+		&axon.DialOptions{Subprotocols: []string{"chat"}},
+		&axon.UpgradeOptions{Subprotocols: []string{"chat"}},
+	)
+	if err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	defer client.Close(1000, "")
+	defer server.Close(1000, "")
+
+	if client.Subprotocol() != "chat" || server.Subprotocol() != "chat" {
+		t.Errorf("subprotocols = %q, %q, want chat", client.Subprotocol(), server.Subprotocol())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	go client.Write(ctx, "ping")
+	if msg, err := server.Read(ctx); err != nil || msg != "ping" {
+		t.Fatalf("server Read() = %q, %v", msg, err)
+	}
+	go server.Write(ctx, "pong")
+	if msg, err := client.Read(ctx); err != nil || msg != "pong" {
+		t.Fatalf("client Read() = %q, %v", msg, err)
+	}
+}
+
+func TestPipe_Rejected(t *testing.T) {
+	_, _, err := axon.Pipe[string](nil, &axon.UpgradeOptions{
+		Authenticator: axon.AuthFuncs{},
+	})
+	var hsErr *axon.HandshakeError
+	if !errors.As(err, &hsErr) || hsErr.StatusCode() != http.StatusUnauthorized {
+		t.Errorf("Pipe() error = %v, want 401", err)
+	}
+}
+
+func TestNewTestClient(t *testing.T) {
+	// The first connection is dropped after one echo, so the client
+	// reconnects through a fresh pipe
+	var conns atomic.Int32
+	echo := func(ctx context.Context, conn *axon.Conn[string]) {
+		first := conns.Add(1) == 1
+		for {
+			msg, err := conn.Read(ctx)
+			if err != nil {
+				return
+			}
+			conn.Write(ctx, msg)
+			if first {
+				conn.Close(int(axon.CloseGoingAway), "restart")
+				return
+			}
+		}
+	}
+
+	opts := axon.DefaultClientOptions()
+	opts.Reconnect.InitialDelay = 10 * time.Millisecond
+	opts.Reconnect.Jitter = false
+	client := axon.NewTestClient[string](opts, nil, echo)
+	defer client.Close()
+
+	msgs, _ := client.Receive()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.ConnectWithReadLoop(ctx); err != nil {
+		t.Fatalf("ConnectWithReadLoop() error = %v", err)
+	}
+
+	for i, want := range []string{"one", "two"} {
+		for int(conns.Load()) <= i || !client.IsConnected() {
+			if ctx.Err() != nil {
+				t.Fatal("timed out waiting for a connection")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err := client.Write(ctx, want); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		if got := receive(t, msgs); got != want {
+			t.Errorf("received %q, want %q", got, want)
+		}
+	}
+	if n := conns.Load(); n != 2 {
+		t.Errorf("served %d connections, want 2", n)
+	}
+}
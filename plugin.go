@@ -0,0 +1,171 @@
+package axon
+
+import "fmt"
+
+// ExtensionPlugin implements a WebSocket extension beyond
+// permessage-deflate, such as custom encryption or delta encoding, without
+// changes to the framing code. Plugins are set in the Extensions of
+// DialOptions and UpgradeOptions, and negotiated in that order after
+// permessage-deflate.
+type ExtensionPlugin interface {
+	// Name returns the extension token used in Sec-WebSocket-Extensions
+	Name() string
+
+	// RSV returns the reserved bits the extension may set on the first
+	// frame of a message, as a mask of RSV1 (0x40), RSV2 (0x20) and RSV3
+	// (0x10). A plugin whose bits are taken by permessage-deflate or an
+	// earlier plugin is not negotiated.
+	RSV() byte
+
+	// Offer returns the offers of a client, in order of preference
+	Offer() []Extension
+
+	// Accept chooses among a client's offers of the extension on the
+	// server, returning the response to send and the transform of the
+	// connection, or ok false to decline the extension
+	Accept(offers []Extension) (response Extension, transform ExtensionTransform, ok bool)
+
+	// Confirm checks the server's response on the client and returns the
+	// transform of the connection. An error fails the handshake.
+	Confirm(response Extension) (ExtensionTransform, error)
+}
+
+// ExtensionTransform transforms the data messages of one connection for a
+// negotiated ExtensionPlugin. axon writes each message as a single frame,
+// so the transform sees whole messages; on writes it runs after
+// compression and on reads before decompression. Writes are transformed in
+// the order they go out and reads in the order they arrive, so transforms
+// may keep state.
+type ExtensionTransform interface {
+	// TransformWrite transforms an outgoing payload, which it must not
+	// modify, and returns the RSV bits to set on the frame
+	TransformWrite(messageType MessageType, payload []byte) ([]byte, byte, error)
+
+	// TransformRead reverses TransformWrite, given the RSV bits of the
+	// plugin that were set on the message's first frame. payload is only
+	// valid during the call.
+	TransformRead(messageType MessageType, rsv byte, payload []byte) ([]byte, error)
+}
+
+// activeExtension is an ExtensionPlugin negotiated on a connection
+type activeExtension struct {
+	name      string
+	rsv       byte
+	response  Extension
+	transform ExtensionTransform
+}
+
+// offerExtensions returns the offers of the plugins, in order
+func offerExtensions(plugins []ExtensionPlugin) []Extension {
+	var offers []Extension
+	for _, p := range plugins {
+		offers = append(offers, p.Offer()...)
+	}
+	return offers
+}
+
+// acceptExtensions negotiates the plugins against a client's extensions on
+// the server. reserved holds the RSV bits already in use.
+func acceptExtensions(plugins []ExtensionPlugin, extensions []Extension, reserved byte) []activeExtension {
+	var active []activeExtension
+	for _, p := range plugins {
+		rsv := p.RSV()
+		if rsv&reserved != 0 {
+			continue
+		}
+		var offers []Extension
+		for _, ext := range extensions {
+			if ext.Name == p.Name() {
+				offers = append(offers, ext)
+			}
+		}
+		if len(offers) == 0 {
+			continue
+		}
+		response, transform, ok := p.Accept(offers)
+		if !ok {
+			continue
+		}
+		response.Name = p.Name()
+		active = append(active, activeExtension{name: p.Name(), rsv: rsv, response: response, transform: transform})
+		reserved |= rsv
+	}
+	return active
+}
+
+// confirmExtensions checks the extensions of a server's response on the
+// client, returning the plugins they accept. The server may only accept
+// permessage-deflate if deflate is set, and each offered plugin once.
+// reserved holds the RSV bits already in use.
+func confirmExtensions(plugins []ExtensionPlugin, extensions []Extension, deflate bool, reserved byte) ([]activeExtension, error) {
+	var active []activeExtension
+	confirmed := make(map[string]bool)
+
+next:
+	for _, ext := range extensions {
+		if ext.Name == permessageDeflate && deflate {
+			continue
+		}
+		for _, p := range plugins {
+			if p.Name() != ext.Name {
+				continue
+			}
+			rsv := p.RSV()
+			if confirmed[ext.Name] || rsv&reserved != 0 {
+				return nil, fmt.Errorf("%w: conflicting extension %s", ErrInvalidHandshake, ext.Name)
+			}
+			transform, err := p.Confirm(ext)
+			if err != nil {
+				return nil, fmt.Errorf("%w: extension %s: %w", ErrInvalidHandshake, ext.Name, err)
+			}
+			confirmed[ext.Name] = true
+			active = append(active, activeExtension{name: ext.Name, rsv: rsv, response: ext, transform: transform})
+			reserved |= rsv
+			continue next
+		}
+		// Extensions must not be used unless the client offered them
+		return nil, fmt.Errorf("%w: unrequested extension %s", ErrInvalidHandshake, ext.Name)
+	}
+	return active, nil
+}
+
+// extensionRSV returns the RSV bits reserved by the connection's plugins
+func (c *Conn[T]) extensionRSV() byte {
+	var rsv byte
+	for _, ext := range c.extensions {
+		rsv |= ext.rsv
+	}
+	return rsv
+}
+
+// transformWrite runs an outgoing payload through the connection's
+// plugins, in order, returning the RSV bits they set
+func (c *Conn[T]) transformWrite(opcode byte, payload []byte) ([]byte, byte, error) {
+	var rsv byte
+	for _, ext := range c.extensions {
+		out, bits, err := ext.transform.TransformWrite(MessageType(opcode), payload)
+		if err != nil {
+			return nil, 0, fmt.Errorf("axon: extension %s: %w", ext.name, err)
+		}
+		if bits&^ext.rsv != 0 {
+			return nil, 0, fmt.Errorf("axon: extension %s set RSV bits %#x it did not reserve", ext.name, bits)
+		}
+		payload = out
+		rsv |= bits
+	}
+	return payload, rsv, nil
+}
+
+// transformRead runs an incoming payload, whose first frame carried the
+// RSV bits rsv, through the connection's plugins in reverse order
+func (c *Conn[T]) transformRead(opcode byte, rsv byte, payload []byte) ([]byte, error) {
+	for i := len(c.extensions) - 1; i >= 0; i-- {
+		ext := c.extensions[i]
+		out, err := ext.transform.TransformRead(MessageType(opcode), rsv&ext.rsv, payload)
+		if err != nil {
+			return nil, fmt.Errorf("axon: extension %s: %w", ext.name, err)
+		}
+		payload = out
+	}
+	return payload, nil
+}
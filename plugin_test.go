@@ -0,0 +1,225 @@
+package axon_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+// xorPlugin is an extension XORing payloads with a key, marking the
+// messages it transformed with RSV2
+type xorPlugin struct {
+	rsv     byte
+	key     byte
+	decline bool
+}
+
+func (p xorPlugin) Name() string { return "x-xor" }
+func (p xorPlugin) RSV() byte    { return p.rsv }
+
+func (p xorPlugin) Offer() []axon.Extension {
+	return []axon.Extension{{Name: "x-xor", Params: []axon.ExtensionParam{{Name: "key", Value: strconv.Itoa(int(p.key))}}}}
+}
+
+func (p xorPlugin) Accept(offers []axon.Extension) (axon.Extension, axon.ExtensionTransform, bool) {
+	if p.decline {
+		return axon.Extension{}, nil, false
+	}
+	value, _ := offers[0].Param("key")
+	key, err := strconv.Atoi(value)
+	if err != nil {
+		return axon.Extension{}, nil, false
+	}
+	return offers[0], xorTransform{rsv: p.rsv, key: byte(key)}, true
+}
+
+func (p xorPlugin) Confirm(response axon.Extension) (axon.ExtensionTransform, error) {
+	if value, _ := response.Param("key"); value != strconv.Itoa(int(p.key)) {
+		return nil, errors.New("key changed")
+	}
+	return xorTransform{rsv: p.rsv, key: p.key}, nil
+}
+
+type xorTransform struct {
+	rsv byte
+	key byte
+}
+
+func (t xorTransform) TransformWrite(mt axon.MessageType, payload []byte) ([]byte, byte, error) {
+	if len(payload) == 0 {
+		return payload, 0, nil
+	}
+	out := make([]byte, len(payload))
+	for i, b := range payload {
+		out[i] = b ^ t.key
+	}
+	return out, t.rsv, nil
+}
+
+func (t xorTransform) TransformRead(mt axon.MessageType, rsv byte, payload []byte) ([]byte, error) {
+	if rsv == 0 {
+		return payload, nil
+	}
+	out, _, err := t.TransformWrite(mt, payload)
+	return out, err
+}
+
+// readFrames collects the frames read by a connection
+type readFrames struct {
+	mu     sync.Mutex
+	frames []axon.Frame
+}
+
+func (l *readFrames) trace(dir axon.FrameDirection, frame *axon.Frame) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if dir == axon.FrameRead {
+		f := *frame
+		f.Payload = bytes.Clone(frame.Payload)
+		l.frames = append(l.frames, f)
+	}
+}
+
+func TestExtensionPlugin_RoundTrip(t *testing.T) {
+	for _, compression := range []bool{false, true} {
+		t.Run("compression="+strconv.FormatBool(compression), func(t *testing.T) {
+			var log readFrames
+			client, server, err := axon.Pipe[axon.RawText](
+				&axon.DialOptions{Compression: compression, Extensions: []axon.ExtensionPlugin{xorPlugin{rsv: 0x20, key: 0x5a}}},
+				&axon.UpgradeOptions{
+					Compression: compression,
+					Extensions:  []axon.ExtensionPlugin{xorPlugin{rsv: 0x20}},
+					Trace:       log.trace,
+				},
+			)
+			if err != nil {
+				t.Fatalf("Pipe() error = %v", err)
+			}
+			defer client.Close(1000, "")
+			defer server.Close(1000, "")
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			msg := axon.RawText(strings.Repeat("hello extensions ", 40))
+			for _, m := range []axon.RawText{msg, "", msg} {
+				go client.Write(ctx, m)
+				got, err := server.Read(ctx)
+				if err != nil || got != m {
+					t.Fatalf("Read() = %q, %v, want %q", got, err, m)
+				}
+			}
+			go server.Write(ctx, msg)
+			if got, err := client.Read(ctx); err != nil || got != msg {
+				t.Fatalf("client Read() = %q, %v, want %q", got, err, msg)
+			}
+
+			log.mu.Lock()
+			defer log.mu.Unlock()
+			first := log.frames[0]
+			if !first.Rsv2 || first.Rsv1 != compression {
+				t.Errorf("expected RSV2 and RSV1=%v on the wire, got %+v", compression, first)
+			}
+			if bytes.Contains(first.Payload, []byte("hello")) {
+				t.Error("expected the payload to be transformed on the wire")
+			}
+			if log.frames[1].Rsv2 {
+				t.Error("expected the empty message to be left untransformed")
+			}
+		})
+	}
+}
+
+func TestExtensionPlugin_NotNegotiated(t *testing.T) {
+	tests := []struct {
+		name   string
+		server []axon.ExtensionPlugin
+		opts   axon.UpgradeOptions
+	}{
+		{name: "ServerWithout"},
+		{name: "Declined", server: []axon.ExtensionPlugin{xorPlugin{rsv: 0x20, decline: true}}},
+		{
+			name:   "RSVTakenByDeflate",
+			server: []axon.ExtensionPlugin{xorPlugin{rsv: 0x40}},
+			opts:   axon.UpgradeOptions{Compression: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var log readFrames
+			opts := tt.opts
+			opts.Extensions = tt.server
+			opts.Trace = log.trace
+			client, server, err := axon.Pipe[axon.RawText](&axon.DialOptions{
+				Compression: opts.Compression,
+				Extensions:  []axon.ExtensionPlugin{xorPlugin{rsv: 0x40, key: 1}},
+			}, &opts)
+			if err != nil {
+				t.Fatalf("Pipe() error = %v", err)
+			}
+			defer client.Close(1000, "")
+			defer server.Close(1000, "")
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			go client.Write(ctx, "plain")
+			if got, err := server.Read(ctx); err != nil || got != "plain" {
+				t.Fatalf("Read() = %q, %v, want plain", got, err)
+			}
+			log.mu.Lock()
+			defer log.mu.Unlock()
+			if f := log.frames[0]; f.Rsv2 || (f.Rsv1 && !opts.Compression) {
+				t.Errorf("expected no plugin bits, got %+v", f)
+			}
+		})
+	}
+}
+
+func TestExtensionPlugin_UnexpectedRSV(t *testing.T) {
+	client, server, err := axon.Pipe[axon.RawText](nil, &axon.UpgradeOptions{
+		Extensions: []axon.ExtensionPlugin{xorPlugin{rsv: 0x20}},
+	})
+	if err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	defer client.Close(1000, "")
+	defer server.Close(1000, "")
+
+	// The client did not offer the extension, so RSV2 stays reserved
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	go axon.WriteRawFrame(client, &axon.Frame{Fin: true, Rsv2: true, Opcode: 0x1, Payload: []byte("x")})
+	if _, err := server.Read(ctx); err == nil {
+		t.Fatal("expected a frame with RSV2 to fail the read")
+	}
+}
+
+func TestDial_UnrequestedExtension(t *testing.T) {
+	server := extensionServer(t, "x-xor; key=1")
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	if _, err := axon.Dial[string](ctx, url, &axon.DialOptions{Compression: true}); !errors.Is(err, axon.ErrInvalidHandshake) {
+		t.Errorf("expected ErrInvalidHandshake for an unrequested extension, got %v", err)
+	}
+
+	// A response the plugin rejects fails the handshake too
+	_, err := axon.Dial[string](ctx, url, &axon.DialOptions{
+		Extensions: []axon.ExtensionPlugin{xorPlugin{rsv: 0x20, key: 2}},
+	})
+	if !errors.Is(err, axon.ErrInvalidHandshake) {
+		t.Errorf("expected ErrInvalidHandshake for a rejected response, got %v", err)
+	}
+}
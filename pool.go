@@ -2,68 +2,271 @@ package axon
 
 import (
 	"bufio"
+	"bytes"
+	"io"
+	"net"
 	"sync"
 )
 
-// bufferPool manages reusable buffers for zero-allocation operations
-// Buffer size must accommodate max frame header (14 bytes) plus reasonable payload
-var bufferPool = sync.Pool{
-	New: func() any {
-		buf := make([]byte, maxFrameHeaderSize+4096)
-		return &buf
-	},
+// Pooled buffers come in power-of-two size classes from minPoolSize to
+// maxPoolSize, so connections configured with larger buffers are pooled
+// too. Requested sizes are rounded up to their class, and larger sizes are
+// allocated for each connection.
+const (
+	minPoolSize = 1024
+	maxPoolSize = 1 << 20
+	poolClasses = 11
+)
+
+// poolClass returns the size class holding size bytes, or -1 if size is
+// too large to pool
+func poolClass(size int) int {
+	class := 0
+	for classSize := minPoolSize; classSize < size; classSize <<= 1 {
+		class++
+	}
+	if class >= poolClasses {
+		return -1
+	}
+	return class
 }
 
-// getBuffer retrieves a buffer from the pool
-func getBuffer() []byte {
-	return *bufferPool.Get().(*[]byte)
+// exactPoolClass returns the size class of exactly size bytes, or -1 if
+// there is none
+func exactPoolClass(size int) int {
+	class := poolClass(size)
+	if class < 0 || minPoolSize<<class != size {
+		return -1
+	}
+	return class
 }
 
-// putBuffer returns a buffer to the pool
-func putBuffer(buf []byte) {
-	if cap(buf) >= 4096 {
-		// Reset length to capacity to avoid retaining references
-		buf = buf[:cap(buf)]
-		bufferPool.Put(&buf)
+// bufferPools manage reusable frame buffers, by size class. Each buffer
+// also has room for a maximum frame header.
+var bufferPools [poolClasses]sync.Pool
+
+// getBuffer returns a frame buffer of at least size bytes plus a frame
+// header
+func getBuffer(size int) []byte {
+	class := poolClass(size)
+	if class < 0 {
+		return make([]byte, maxFrameHeaderSize+size)
+	}
+	if buf, ok := bufferPools[class].Get().(*[]byte); ok {
+		return *buf
 	}
+	return make([]byte, maxFrameHeaderSize+minPoolSize<<class)
 }
 
-// readerPool manages reusable bufio.Reader instances
-var readerPool = sync.Pool{
-	New: func() any {
-		return bufio.NewReaderSize(nil, 4096)
-	},
+// putBuffer returns a buffer from getBuffer to its pool
+func putBuffer(buf []byte) {
+	class := exactPoolClass(cap(buf) - maxFrameHeaderSize)
+	if class < 0 {
+		return
+	}
+	// Reset length to capacity to avoid retaining references
+	buf = buf[:cap(buf)]
+	bufferPools[class].Put(&buf)
 }
 
-// getReader retrieves a reader from the pool and resets it with the given reader
-func getReader(r interface{ Read([]byte) (int, error) }) *bufio.Reader {
-	br := readerPool.Get().(*bufio.Reader)
-	br.Reset(r)
-	return br
+// readerPools manage reusable bufio.Reader instances, by size class
+var readerPools [poolClasses]sync.Pool
+
+// getReader returns a reader of at least size bytes reading from r
+func getReader(r io.Reader, size int) *bufio.Reader {
+	class := poolClass(size)
+	if class < 0 {
+		return bufio.NewReaderSize(r, size)
+	}
+	if br, ok := readerPools[class].Get().(*bufio.Reader); ok {
+		br.Reset(r)
+		return br
+	}
+	return bufio.NewReaderSize(r, minPoolSize<<class)
 }
 
-// putReader returns a reader to the pool
+// putReader returns a reader from getReader to its pool
 func putReader(br *bufio.Reader) {
+	class := exactPoolClass(br.Size())
+	if class < 0 {
+		return
+	}
 	br.Reset(nil)
-	readerPool.Put(br)
+	readerPools[class].Put(br)
 }
 
-// writerPool manages reusable bufio.Writer instances
-var writerPool = sync.Pool{
-	New: func() any {
-		return bufio.NewWriterSize(nil, 4096)
-	},
-}
+// writerPools manage reusable bufio.Writer instances, by size class
+var writerPools [poolClasses]sync.Pool
 
-// getWriter retrieves a writer from the pool and resets it with the given writer
-func getWriter(w interface{ Write([]byte) (int, error) }) *bufio.Writer {
-	bw := writerPool.Get().(*bufio.Writer)
-	bw.Reset(w)
-	return bw
+// getWriter returns a writer of at least size bytes writing to w
+func getWriter(w io.Writer, size int) *bufio.Writer {
+	class := poolClass(size)
+	if class < 0 {
+		return bufio.NewWriterSize(w, size)
+	}
+	if bw, ok := writerPools[class].Get().(*bufio.Writer); ok {
+		bw.Reset(w)
+		return bw
+	}
+	return bufio.NewWriterSize(w, minPoolSize<<class)
 }
 
-// putWriter returns a writer to the pool
+// putWriter returns a writer from getWriter to its pool
 func putWriter(bw *bufio.Writer) {
+	class := exactPoolClass(bw.Size())
+	if class < 0 {
+		return
+	}
 	bw.Reset(nil)
-	writerPool.Put(bw)
+	writerPools[class].Put(bw)
+}
+
+// newReader returns a connection reader of the configured size for r
+func (u *Upgrader) newReader(r io.Reader) *bufio.Reader {
+	if u.noBufferPool {
+		return bufio.NewReaderSize(r, u.readBufferSize)
+	}
+	return getReader(r, u.readBufferSize)
+}
+
+// hijackedReader returns a connection reader for conn that first yields
+// the bytes br had already buffered from it, such as frames the peer sent
+// right behind the handshake. br may be nil.
+func (u *Upgrader) hijackedReader(conn net.Conn, br *bufio.Reader) *bufio.Reader {
+	if br == nil || br.Buffered() == 0 {
+		return u.newReader(conn)
+	}
+	buffered, _ := br.Peek(br.Buffered())
+	r := io.MultiReader(bytes.NewReader(bytes.Clone(buffered)), conn)
+	reader := u.newReader(r)
+	if reader.Size() < len(buffered) {
+		u.freeReader(reader)
+		reader = bufio.NewReaderSize(r, len(buffered))
+	}
+	// Pull the bytes in now, so that Buffered counts them
+	reader.Peek(len(buffered))
+	return reader
+}
+
+// freeReader returns a reader from newReader to its pool
+func (u *Upgrader) freeReader(br *bufio.Reader) {
+	if !u.noBufferPool {
+		putReader(br)
+	}
+}
+
+// newWriter returns a connection writer of the configured size for w
+func (u *Upgrader) newWriter(w io.Writer) *bufio.Writer {
+	if u.noBufferPool {
+		return bufio.NewWriterSize(w, u.writeBufferSize)
+	}
+	return getWriter(w, u.writeBufferSize)
+}
+
+// newBuffer returns a connection frame buffer of size bytes plus a frame
+// header
+func (u *Upgrader) newBuffer(size int) []byte {
+	if u.noBufferPool {
+		return make([]byte, maxFrameHeaderSize+size)
+	}
+	return getBuffer(size)
+}
+
+// retiredBit marks a Conn's ioUsers once Close has finished with its
+// pooled buffers; the low bits count the reads and writes still using them
+const retiredBit = 1 << 62
+
+// acquireIO marks the start of a read or write using the connection's
+// pooled buffers, reporting false once they may already be released
+func (c *Conn[T]) acquireIO() bool {
+	if c.ioUsers.Add(1)&retiredBit != 0 {
+		c.releaseIO()
+		return false
+	}
+	return true
+}
+
+// releaseIO marks the end of a read or write started with acquireIO,
+// returning the buffers to their pools if it was the last one after Close
+func (c *Conn[T]) releaseIO() {
+	if c.ioUsers.Add(-1) == retiredBit {
+		c.freeBuffers()
+	}
+}
+
+// retireIO is called by Close once it no longer writes. The buffers are
+// returned now if no read or write is using them, or else by the last one
+// to finish, so the pools never hand out a buffer still in use.
+func (c *Conn[T]) retireIO() {
+	if c.ioUsers.Or(retiredBit) == 0 {
+		c.freeBuffers()
+	}
+}
+
+// attachReader gives a connection with lazy buffers its reader and read
+// buffer. It is called by the connection's reader.
+func (c *Conn[T]) attachReader() {
+	if c.reader == nil {
+		c.reader = c.upgrader.newReader(c.conn)
+		c.readBuf = c.upgrader.newBuffer(c.upgrader.readBufferSize)
+	}
+}
+
+// detachReader returns the reader and read buffer of a connection with lazy
+// buffers to their pools. The reader must hold no unread data.
+func (c *Conn[T]) detachReader() {
+	if c.reader == nil {
+		return
+	}
+	if !c.upgrader.noBufferPool {
+		putReader(c.reader)
+		putBuffer(c.readBuf)
+	}
+	c.reader, c.readBuf = nil, nil
+}
+
+// attachWriter gives a connection with lazy buffers its writer and write
+// buffer. It is called with writeMu held.
+func (c *Conn[T]) attachWriter() {
+	if c.writer == nil {
+		c.writer = c.upgrader.newWriter(c.conn)
+		c.writeBuf = c.upgrader.newBuffer(c.upgrader.writeBufferSize)
+	}
+}
+
+// flush flushes the connection's writer, detaching it and the write buffer
+// once they are empty if the connection's buffers are lazy. It is called
+// with writeMu held.
+func (c *Conn[T]) flush() error {
+	if err := c.writer.Flush(); err != nil {
+		return err
+	}
+	if c.lazyBuffers {
+		if !c.upgrader.noBufferPool {
+			putWriter(c.writer)
+			putBuffer(c.writeBuf)
+		}
+		c.writer, c.writeBuf = nil, nil
+	}
+	return nil
+}
+
+// freeBuffers returns the connection's buffers to their pools
+func (c *Conn[T]) freeBuffers() {
+	c.freeOnce.Do(func() {
+		if c.compression != nil {
+			c.compression.Close()
+		}
+		if c.upgrader.noBufferPool {
+			return
+		}
+		if c.reader != nil {
+			putReader(c.reader)
+			putBuffer(c.readBuf)
+		}
+		if c.writer != nil {
+			putWriter(c.writer)
+			putBuffer(c.writeBuf)
+		}
+	})
 }
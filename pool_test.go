@@ -2,14 +2,20 @@ package axon_test
 
 import (
 	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/kolosys/axon"
 )
 
 func TestBufferPool(t *testing.T) {
 	// Get buffer from pool
-	buf1 := axon.GetBuffer()
+	buf1 := axon.GetBuffer(4096)
 	if len(buf1) == 0 {
 		t.Error("buffer should not be empty")
 	}
@@ -18,7 +24,7 @@ func TestBufferPool(t *testing.T) {
 	axon.PutBuffer(buf1)
 
 	// Get another buffer - should reuse if pool works
-	buf2 := axon.GetBuffer()
+	buf2 := axon.GetBuffer(4096)
 	if len(buf2) == 0 {
 		t.Error("buffer should not be empty")
 	}
@@ -36,7 +42,7 @@ func TestBufferPoolSmallBuffer(t *testing.T) {
 
 func TestReaderPool(t *testing.T) {
 	r := bytes.NewReader([]byte("test"))
-	br := axon.GetReader(r)
+	br := axon.GetReader(r, 4096)
 
 	if br == nil {
 		t.Fatal("reader should not be nil")
@@ -58,7 +64,7 @@ func TestReaderPool(t *testing.T) {
 
 func TestWriterPool(t *testing.T) {
 	var buf bytes.Buffer
-	bw := axon.GetWriter(&buf)
+	bw := axon.GetWriter(&buf, 4096)
 
 	if bw == nil {
 		t.Fatal("writer should not be nil")
@@ -85,3 +91,139 @@ func TestWriterPool(t *testing.T) {
 	// Put back
 	axon.PutWriter(bw)
 }
+
+func TestBufferPoolSizeClasses(t *testing.T) {
+	// Sizes are rounded up to a power of two, with room for a frame header
+	buf := axon.GetBuffer(5000)
+	if len(buf) != 14+8192 {
+		t.Errorf("GetBuffer(5000) length = %d, want %d", len(buf), 14+8192)
+	}
+	axon.PutBuffer(buf)
+
+	br := axon.GetReader(strings.NewReader(""), 64*1024)
+	if br.Size() != 64*1024 {
+		t.Errorf("GetReader() size = %d, want %d", br.Size(), 64*1024)
+	}
+	axon.PutReader(br)
+
+	bw := axon.GetWriter(io.Discard, 100)
+	if bw.Size() != 1024 {
+		t.Errorf("GetWriter() size = %d, want %d", bw.Size(), 1024)
+	}
+	axon.PutWriter(bw)
+
+	// Sizes over the largest class are allocated as requested
+	big := axon.GetBuffer(3 << 20)
+	if len(big) != 14+3<<20 {
+		t.Errorf("GetBuffer(3MB) length = %d, want %d", len(big), 14+3<<20)
+	}
+	axon.PutBuffer(big)
+}
+
+func TestConnBufferSizes(t *testing.T) {
+	tests := []struct {
+		name    string
+		disable bool
+	}{
+		{"Pooled", false},
+		{"Unpooled", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server, err := axon.Pipe[string](
+				&axon.DialOptions{ReadBufferSize: 32 * 1024, WriteBufferSize: 16 * 1024, DisableBufferPool: tt.disable},
+				&axon.UpgradeOptions{ReadBufferSize: 64 * 1024, WriteBufferSize: 8 * 1024, DisableBufferPool: tt.disable},
+			)
+			if err != nil {
+				t.Fatalf("Pipe() error = %v", err)
+			}
+			defer client.Close(1000, "")
+			defer server.Close(1000, "")
+
+			if r, w := client.BufferSizes(); r != 32*1024 || w != 16*1024 {
+				t.Errorf("client buffer sizes = %d, %d, want %d, %d", r, w, 32*1024, 16*1024)
+			}
+			if r, w := server.BufferSizes(); r != 64*1024 || w != 8*1024 {
+				t.Errorf("server buffer sizes = %d, %d, want %d, %d", r, w, 64*1024, 8*1024)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			go client.Write(ctx, "hello")
+			if msg, err := server.Read(ctx); err != nil || msg != "hello" {
+				t.Errorf("Read() = %q, %v, want %q", msg, err, "hello")
+			}
+		})
+	}
+}
+
+func TestConnCloseDuringIO(t *testing.T) {
+	// Connections closed while reading and writing must not return buffers
+	// to the pools that other connections then see modified
+	const pairs, rounds = 8, 10
+	var wg sync.WaitGroup
+	for p := 0; p < pairs; p++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := 0; r < rounds; r++ {
+				closeDuringIO(t, fmt.Sprintf("pair-%d-", p))
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// closeDuringIO echoes messages through a Pipe and closes both ends while
+// they are in flight, failing if a message read back was corrupted
+func closeDuringIO(t *testing.T, prefix string) {
+	client, server, err := axon.Pipe[string](nil, nil)
+	if err != nil {
+		t.Errorf("Pipe() error = %v", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for {
+			msg, err := server.Read(ctx)
+			if err != nil || server.Write(ctx, msg) != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			if client.Write(ctx, fmt.Sprintf("%s%d%s", prefix, i, strings.Repeat("x", i%8*1024))) != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for {
+			msg, err := client.Read(ctx)
+			if err != nil {
+				return
+			}
+			if !strings.HasPrefix(msg, prefix) {
+				t.Errorf("read %q, want a message starting %q", msg, prefix)
+			}
+		}
+	}()
+
+	time.Sleep(time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		server.Close(1000, "")
+		close(done)
+	}()
+	client.Close(1000, "")
+	<-done
+	wg.Wait()
+}
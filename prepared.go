@@ -0,0 +1,142 @@
+package axon
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+)
+
+// PreparedMessage is a message that is serialized once and framed once per
+// connection configuration, so it can be written to many connections without
+// repeating that work. It is safe for concurrent use.
+type PreparedMessage[T any] struct {
+	opcode  byte
+	payload []byte
+
+	mu     sync.Mutex
+	frames map[preparedKey][]byte
+}
+
+// preparedKey identifies a framing of a prepared message
+type preparedKey struct {
+	compress bool
+	level    int
+}
+
+// NewPreparedMessage serializes msg for writing with Conn.WritePrepared
+func NewPreparedMessage[T any](msg T) (*PreparedMessage[T], error) {
+	opcode, payload, err := encode(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PreparedMessage[T]{
+		opcode:  opcode,
+		payload: payload,
+		frames:  make(map[preparedKey][]byte),
+	}, nil
+}
+
+// frame returns the encoded server frame for key, building it on first use
+func (pm *PreparedMessage[T]) frame(key preparedKey) ([]byte, error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	if data, ok := pm.frames[key]; ok {
+		return data, nil
+	}
+
+	payload := pm.payload
+	compressed := false
+	if key.compress {
+		buf := getDeflateBuffer()
+		defer putDeflateBuffer(buf)
+
+		fw, err := getFlateWriter(buf, key.level)
+		if err != nil {
+			return nil, err
+		}
+		defer putFlateWriter(fw, key.level)
+
+		// Without context takeover an uncompressed frame is always valid,
+		// so only use the compressed form if it is smaller
+		if c, err := compressMessage(fw, buf, payload); err == nil && len(c) < len(payload) {
+			payload = c
+			compressed = true
+		}
+	}
+
+	var b bytes.Buffer
+	b.Grow(maxFrameHeaderSize + len(payload))
+	frame := &Frame{
+		Fin:     true,
+		Rsv1:    compressed,
+		Opcode:  pm.opcode,
+		Payload: payload,
+	}
+	if err := writeFrame(&b, make([]byte, maxFrameHeaderSize), frame); err != nil {
+		return nil, err
+	}
+
+	pm.frames[key] = b.Bytes()
+	return b.Bytes(), nil
+}
+
+// WritePrepared writes a prepared message to the connection.
+// Server connections write the cached frame as-is. Client connections must
+// mask every frame with a fresh key, and compression with context takeover
+// depends on per-connection state, so those only reuse the serialized payload,
+// as do traced connections and connections with write middleware or
+// extension plugins.
+func (c *Conn[T]) WritePrepared(ctx context.Context, pm *PreparedMessage[T]) error {
+	c.wrapMu.RLock()
+	wrapped := c.writeChain != nil
+	c.wrapMu.RUnlock()
+
+	cm := c.compression
+	compress := cm != nil && cm.ShouldCompress(len(pm.payload))
+	if c.isClient || c.trace != nil || wrapped || c.extensions != nil || (compress && cm.compressTakeover) {
+		return c.write(ctx, pm.opcode, pm.payload)
+	}
+
+	deadline, err := c.writeTimeout(ctx, pm.opcode, len(pm.payload))
+	if err != nil {
+		return err
+	}
+
+	key := preparedKey{compress: compress}
+	if compress {
+		key.level = cm.level
+	}
+	data, err := pm.frame(key)
+	if err != nil {
+		return err
+	}
+
+	deadline, stalled := c.stallTimeout(deadline)
+	err = func() error {
+		c.writeMu.Lock()
+		defer c.writeMu.Unlock()
+
+		if c.IsClosed() || !c.acquireIO() {
+			return ErrConnectionClosed
+		}
+		defer c.releaseIO()
+		if err := c.conn.SetWriteDeadline(time.Now().Add(deadline)); err != nil {
+			return err
+		}
+
+		c.attachWriter()
+		if _, err := c.writer.Write(data); err != nil {
+			return err
+		}
+
+		return c.flush()
+	}()
+	if err != nil {
+		return c.evictStalled(deadlineErr(ErrWriteDeadlineExceeded, c.closedErr(err)), stalled)
+	}
+	c.touch()
+	return nil
+}
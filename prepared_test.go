@@ -0,0 +1,108 @@
+package axon_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+func TestPreparedMessage_WriteToMany(t *testing.T) {
+	pm, err := axon.NewPreparedMessage(map[string]string{"event": "tick"})
+	if err != nil {
+		t.Fatalf("prepare failed: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		conn, clientConn, err := axon.NewTestConn[map[string]string](nil)
+		if err != nil {
+			t.Fatalf("failed to create test connection: %v", err)
+		}
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if err := conn.WritePrepared(ctx, pm); err != nil {
+				t.Errorf("write prepared failed: %v", err)
+			}
+		}()
+
+		clientConn.SetReadDeadline(time.Now().Add(time.Second))
+		opcode, payload, err := readServerFrame(clientConn)
+		if err != nil {
+			t.Fatalf("conn %d: failed to read frame: %v", i, err)
+		}
+		if opcode != 0x1 || string(payload) != `{"event":"tick"}` {
+			t.Errorf("conn %d: got opcode %d payload %q", i, opcode, payload)
+		}
+
+		<-done
+		conn.Close(1000, "")
+		clientConn.Close()
+	}
+}
+
+func TestPreparedMessage_Compressed(t *testing.T) {
+	msg := bytes.Repeat([]byte("prepared and compressed "), 40)
+	pm, err := axon.NewPreparedMessage(msg)
+	if err != nil {
+		t.Fatalf("prepare failed: %v", err)
+	}
+
+	conn, clientConn, err := axon.NewTestConn[[]byte](&axon.UpgradeOptions{
+		Compression:             true,
+		ServerNoContextTakeover: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create test connection: %v", err)
+	}
+	defer conn.Close(1000, "")
+	defer clientConn.Close()
+
+	done := make(chan struct{})
+	defer func() { <-done }()
+	go func() {
+		defer close(done)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		for i := 0; i < 2; i++ {
+			if err := conn.WritePrepared(ctx, pm); err != nil {
+				t.Errorf("write prepared failed: %v", err)
+			}
+		}
+	}()
+
+	// []byte messages are serialized as JSON like any other T
+	want, _ := json.Marshal(msg)
+
+	client, _ := axon.NewCompressionPair(false, true)
+	buf := make([]byte, 4096)
+	for i := 0; i < 2; i++ {
+		clientConn.SetReadDeadline(time.Now().Add(time.Second))
+		frame, err := axon.ReadFrameRSV(clientConn, buf, len(buf), 0x40)
+		if err != nil {
+			t.Fatalf("message %d: failed to read frame: %v", i, err)
+		}
+		if !frame.Rsv1 || frame.Opcode != 0x1 {
+			t.Fatalf("message %d: expected compressed text frame, got rsv1=%v opcode=%d", i, frame.Rsv1, frame.Opcode)
+		}
+		got, err := client.Decompress(frame.Payload)
+		if err != nil {
+			t.Fatalf("message %d: decompress failed: %v", i, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("message %d: payload mismatch", i)
+		}
+	}
+}
+
+func TestPreparedMessage_SerializationError(t *testing.T) {
+	if _, err := axon.NewPreparedMessage(make(chan int)); err != axon.ErrSerializationFailed {
+		t.Errorf("expected ErrSerializationFailed, got %v", err)
+	}
+}
@@ -0,0 +1,242 @@
+package axon
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// dialProxy connects to addr through the proxy at proxyURL. Supported
+// schemes are http and https, which tunnel with CONNECT, and socks5 and
+// socks5h.
+func dialProxy(ctx context.Context, dialContext func(ctx context.Context, network, addr string) (net.Conn, error), proxyURL *url.URL, addr string) (net.Conn, error) {
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		switch proxyURL.Scheme {
+		case "https":
+			proxyAddr = net.JoinHostPort(proxyURL.Hostname(), "443")
+		case "socks5", "socks5h":
+			proxyAddr = net.JoinHostPort(proxyURL.Hostname(), "1080")
+		default:
+			proxyAddr = net.JoinHostPort(proxyURL.Hostname(), "80")
+		}
+	}
+
+	var connect func(net.Conn, *url.URL, string) error
+	switch proxyURL.Scheme {
+	case "http", "https":
+		connect = proxyConnect
+	case "socks5", "socks5h":
+		connect = socks5Connect
+	default:
+		return nil, fmt.Errorf("axon: unsupported proxy scheme: %s", proxyURL.Scheme)
+	}
+
+	conn, err := dialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	if proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	// Bound the proxy handshake by the dial context
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if err := connect(conn, proxyURL, addr); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// proxyConnect opens a tunnel to addr with an HTTP CONNECT request
+func proxyConnect(conn net.Conn, proxyURL *url.URL, addr string) error {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user := proxyURL.User; user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	if err := req.Write(conn); err != nil {
+		return fmt.Errorf("axon: failed to write proxy request: %w", err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return fmt.Errorf("axon: failed to read proxy response: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("axon: proxy CONNECT failed: %s", resp.Status)
+	}
+
+	// The peer only speaks after the handshake request, so anything
+	// buffered here would be lost
+	if br.Buffered() > 0 {
+		return errors.New("axon: proxy sent data before the tunnel was used")
+	}
+
+	return nil
+}
+
+// SOCKS5 protocol constants (RFC 1928, RFC 1929)
+const (
+	socks5Version      = 0x05
+	socks5AuthNone     = 0x00
+	socks5AuthPassword = 0x02
+	socks5CmdConnect   = 0x01
+	socks5AtypIPv4     = 0x01
+	socks5AtypDomain   = 0x03
+	socks5AtypIPv6     = 0x04
+)
+
+// socks5Connect opens a tunnel to addr through a SOCKS5 proxy
+func socks5Connect(conn net.Conn, proxyURL *url.URL, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("axon: invalid port: %s", portStr)
+	}
+
+	// Method negotiation
+	methods := []byte{socks5AuthNone}
+	if proxyURL.User != nil {
+		methods = append(methods, socks5AuthPassword)
+	}
+	greeting := append([]byte{socks5Version, byte(len(methods))}, methods...)
+	if _, err := conn.Write(greeting); err != nil {
+		return fmt.Errorf("axon: failed to write SOCKS5 greeting: %w", err)
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("axon: failed to read SOCKS5 greeting: %w", err)
+	}
+	if reply[0] != socks5Version {
+		return fmt.Errorf("axon: unexpected SOCKS version: %d", reply[0])
+	}
+
+	switch reply[1] {
+	case socks5AuthNone:
+	case socks5AuthPassword:
+		if proxyURL.User == nil {
+			return errors.New("axon: SOCKS5 proxy requires authentication")
+		}
+		if err := socks5Authenticate(conn, proxyURL.User); err != nil {
+			return err
+		}
+	default:
+		return errors.New("axon: SOCKS5 proxy rejected all authentication methods")
+	}
+
+	// Connect request
+	req := []byte{socks5Version, socks5CmdConnect, 0x00}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			req = append(req, socks5AtypIPv4)
+			req = append(req, ip4...)
+		} else {
+			req = append(req, socks5AtypIPv6)
+			req = append(req, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("axon: host name too long: %s", host)
+		}
+		req = append(req, socks5AtypDomain, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+
+	if _, err := conn.Write(req); err != nil {
+		return fmt.Errorf("axon: failed to write SOCKS5 request: %w", err)
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("axon: failed to read SOCKS5 reply: %w", err)
+	}
+	if header[1] != 0x00 {
+		return fmt.Errorf("axon: SOCKS5 connect failed with code %d", header[1])
+	}
+
+	// Discard the bound address
+	var skip int
+	switch header[3] {
+	case socks5AtypIPv4:
+		skip = net.IPv4len
+	case socks5AtypIPv6:
+		skip = net.IPv6len
+	case socks5AtypDomain:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return fmt.Errorf("axon: failed to read SOCKS5 reply: %w", err)
+		}
+		skip = int(n[0])
+	default:
+		return fmt.Errorf("axon: unexpected SOCKS5 address type: %d", header[3])
+	}
+	if _, err := io.ReadFull(conn, make([]byte, skip+2)); err != nil {
+		return fmt.Errorf("axon: failed to read SOCKS5 reply: %w", err)
+	}
+
+	return nil
+}
+
+// socks5Authenticate performs username/password authentication
+func socks5Authenticate(conn net.Conn, user *url.Userinfo) error {
+	username := user.Username()
+	password, _ := user.Password()
+	if len(username) > 255 || len(password) > 255 {
+		return errors.New("axon: SOCKS5 credentials too long")
+	}
+
+	req := []byte{0x01, byte(len(username))}
+	req = append(req, username...)
+	req = append(req, byte(len(password)))
+	req = append(req, password...)
+	if _, err := conn.Write(req); err != nil {
+		return fmt.Errorf("axon: failed to write SOCKS5 credentials: %w", err)
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("axon: failed to read SOCKS5 auth reply: %w", err)
+	}
+	if reply[1] != 0x00 {
+		return errors.New("axon: SOCKS5 authentication failed")
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package axon_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+// startProxy runs a proxy on a loopback listener that tunnels each
+// connection to the address returned by handshake
+func startProxy(t *testing.T, handshake func(conn net.Conn) (string, error)) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				addr, err := handshake(conn)
+				if err != nil {
+					return
+				}
+				target, err := net.Dial("tcp", addr)
+				if err != nil {
+					return
+				}
+				defer target.Close()
+				go io.Copy(target, conn)
+				io.Copy(conn, target)
+			}()
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+// echoServer starts an HTTP server that echoes WebSocket messages
+func echoServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(axon.Handler[string](nil, func(ctx context.Context, conn *axon.Conn[string]) {
+		for {
+			msg, err := conn.Read(ctx)
+			if err != nil {
+				return
+			}
+			if err := conn.Write(ctx, msg); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// dialThroughProxy dials server via proxyURL and checks a message round trips
+func dialThroughProxy(t *testing.T, server *httptest.Server, proxyURL string) {
+	t.Helper()
+
+	pu, err := url.Parse(proxyURL)
+	if err != nil {
+		t.Fatalf("invalid proxy URL: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	conn, err := axon.Dial[string](ctx, "ws"+strings.TrimPrefix(server.URL, "http"), &axon.DialOptions{
+		Proxy: http.ProxyURL(pu),
+	})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close(1000, "")
+
+	if err := conn.Write(ctx, "hello"); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if got, err := conn.Read(ctx); err != nil || got != "hello" {
+		t.Fatalf("expected echo, got %q (err=%v)", got, err)
+	}
+}
+
+func TestDial_HTTPConnectProxy(t *testing.T) {
+	server := echoServer(t)
+
+	auth := make(chan string, 1)
+	proxyAddr := startProxy(t, func(conn net.Conn) (string, error) {
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return "", err
+		}
+		auth <- req.Header.Get("Proxy-Authorization")
+		if req.Method != http.MethodConnect {
+			io.WriteString(conn, "HTTP/1.1 405 Method Not Allowed\r\n\r\n")
+			return "", io.EOF
+		}
+		io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n")
+		return req.Host, nil
+	})
+
+	dialThroughProxy(t, server, "http://user:secret@"+proxyAddr)
+
+	if got := <-auth; got != "Basic dXNlcjpzZWNyZXQ=" {
+		t.Errorf("expected basic proxy credentials, got %q", got)
+	}
+}
+
+func TestDial_HTTPConnectProxyRefused(t *testing.T) {
+	proxyAddr := startProxy(t, func(conn net.Conn) (string, error) {
+		http.ReadRequest(bufio.NewReader(conn))
+		io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+		return "", io.EOF
+	})
+
+	pu, _ := url.Parse("http://" + proxyAddr)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	_, err := axon.Dial[string](ctx, "ws://example.com/", &axon.DialOptions{Proxy: http.ProxyURL(pu)})
+	if err == nil || !strings.Contains(err.Error(), "407") {
+		t.Errorf("expected 407 error, got %v", err)
+	}
+}
+
+func TestDial_SOCKS5Proxy(t *testing.T) {
+	server := echoServer(t)
+
+	proxyAddr := startProxy(t, func(conn net.Conn) (string, error) {
+		// Greeting: only username/password is accepted
+		buf := make([]byte, 2)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return "", err
+		}
+		methods := make([]byte, buf[1])
+		io.ReadFull(conn, methods)
+		conn.Write([]byte{0x05, 0x02})
+
+		// Credentials
+		io.ReadFull(conn, buf)
+		user := make([]byte, buf[1])
+		io.ReadFull(conn, user)
+		io.ReadFull(conn, buf[:1])
+		pass := make([]byte, buf[0])
+		io.ReadFull(conn, pass)
+		if string(user) != "user" || string(pass) != "secret" {
+			conn.Write([]byte{0x01, 0x01})
+			return "", io.EOF
+		}
+		conn.Write([]byte{0x01, 0x00})
+
+		// Connect request for an IPv4 address
+		req := make([]byte, 10)
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return "", err
+		}
+		if req[3] != 0x01 {
+			return "", io.EOF
+		}
+		addr := net.JoinHostPort(net.IP(req[4:8]).String(), strconv.Itoa(int(binary.BigEndian.Uint16(req[8:]))))
+		conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		return addr, nil
+	})
+
+	dialThroughProxy(t, server, "socks5://user:secret@"+proxyAddr)
+}
@@ -0,0 +1,209 @@
+package axon
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyHeaderTimeout bounds reading the PROXY header of an accepted
+// connection that has no deadline of its own
+const proxyHeaderTimeout = 10 * time.Second
+
+// proxyV2Signature starts every PROXY protocol version 2 header
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ProxyProtocolListener wraps a listener whose connections come through a
+// load balancer speaking the PROXY protocol (version 1 or 2), such as
+// HAProxy or an AWS Network Load Balancer. RemoteAddr of each accepted
+// connection reports the client's address from its PROXY header, so
+// Serve sees the client rather than the balancer.
+//
+// Every connection must start with a PROXY header; reads from one that
+// does not fail with ErrInvalidProxyHeader. The listener must therefore
+// be reachable only through the balancer.
+func ProxyProtocolListener(ln net.Listener) net.Listener {
+	return &proxyListener{Listener: ln}
+}
+
+// proxyListener accepts connections that carry a PROXY header
+type proxyListener struct {
+	net.Listener
+	trusted []netip.Prefix // Peers that must send a header; nil means all
+}
+
+// Accept returns the next connection. Its header is read on first use,
+// so a slow client does not hold up the accept loop.
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if l.trusted != nil && !isTrusted(parseNode(conn.RemoteAddr().String()), l.trusted) {
+		return conn, nil
+	}
+	return &proxyConn{Conn: conn}, nil
+}
+
+// proxyConn is a connection whose PROXY header has not been read yet
+type proxyConn struct {
+	net.Conn
+
+	once     sync.Once
+	reader   *bufio.Reader
+	remote   net.Addr
+	local    net.Addr
+	deadline time.Time // Read deadline set before the header was read
+	err      error
+}
+
+// Read reads from the connection past its PROXY header
+func (c *proxyConn) Read(p []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(p)
+}
+
+// RemoteAddr returns the client's address from the PROXY header
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the address the client connected to, as told by the
+// PROXY header
+func (c *proxyConn) LocalAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.local != nil {
+		return c.local
+	}
+	return c.Conn.LocalAddr()
+}
+
+// SetDeadline sets the read and write deadlines
+func (c *proxyConn) SetDeadline(t time.Time) error {
+	c.deadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline
+func (c *proxyConn) SetReadDeadline(t time.Time) error {
+	c.deadline = t
+	return c.Conn.SetReadDeadline(t)
+}
+
+// readHeader reads and parses the PROXY header
+func (c *proxyConn) readHeader() {
+	// The header must arrive promptly even if no deadline is set yet
+	if c.deadline.IsZero() {
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		defer c.Conn.SetReadDeadline(time.Time{})
+	}
+
+	c.reader = bufio.NewReader(c.Conn)
+	sig, err := c.reader.Peek(len(proxyV2Signature))
+	switch {
+	case err == nil && bytes.Equal(sig, proxyV2Signature):
+		c.remote, c.local, c.err = readProxyV2(c.reader)
+	case err == nil && bytes.HasPrefix(sig, []byte("PROXY ")):
+		c.remote, c.local, c.err = readProxyV1(c.reader)
+	case err != nil && err != io.EOF:
+		c.err = err
+	default:
+		c.err = ErrInvalidProxyHeader
+	}
+}
+
+// readProxyV1 reads a human readable version 1 header, such as
+// "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n"
+func readProxyV1(r *bufio.Reader) (remote, local net.Addr, err error) {
+	var line []byte
+	for len(line) < 107 { // The longest valid header
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+		if bytes.HasSuffix(line, []byte("\r\n")) {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, ErrInvalidProxyHeader
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, ErrInvalidProxyHeader
+	}
+	src, err1 := netip.ParseAddr(fields[2])
+	dst, err2 := netip.ParseAddr(fields[3])
+	srcPort, err3 := strconv.ParseUint(fields[4], 10, 16)
+	dstPort, err4 := strconv.ParseUint(fields[5], 10, 16)
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+		return nil, nil, ErrInvalidProxyHeader
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(src, uint16(srcPort))),
+		net.TCPAddrFromAddrPort(netip.AddrPortFrom(dst, uint16(dstPort))), nil
+}
+
+// readProxyV2 reads a binary version 2 header
+func readProxyV2(r *bufio.Reader) (remote, local net.Addr, err error) {
+	var head [16]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, nil, err
+	}
+	if head[12]>>4 != 2 {
+		return nil, nil, ErrInvalidProxyHeader
+	}
+	body := make([]byte, binary.BigEndian.Uint16(head[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, nil, err
+	}
+
+	// LOCAL connections, such as health checks, carry no addresses
+	if head[12]&0x0F == 0 {
+		return nil, nil, nil
+	}
+
+	var size int
+	switch head[13] >> 4 {
+	case 1: // AF_INET
+		size = 4
+	case 2: // AF_INET6
+		size = 16
+	default: // AF_UNSPEC and AF_UNIX
+		return nil, nil, nil
+	}
+	if len(body) < 2*size+4 {
+		return nil, nil, ErrInvalidProxyHeader
+	}
+	src, _ := netip.AddrFromSlice(body[:size])
+	dst, _ := netip.AddrFromSlice(body[size : 2*size])
+	srcPort := binary.BigEndian.Uint16(body[2*size:])
+	dstPort := binary.BigEndian.Uint16(body[2*size+2:])
+
+	newAddr := func(ip netip.Addr, port uint16) net.Addr {
+		ap := netip.AddrPortFrom(ip, port)
+		if head[13]&0x0F == 2 { // DGRAM
+			return net.UDPAddrFromAddrPort(ap)
+		}
+		return net.TCPAddrFromAddrPort(ap)
+	}
+	return newAddr(src, srcPort), newAddr(dst, dstPort), nil
+}
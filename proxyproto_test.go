@@ -0,0 +1,142 @@
+package axon_test
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+// proxyV2Header builds a version 2 PROXY header for a TCP over IPv4
+// connection from 192.0.2.10:5555 to 192.0.2.20:443
+func proxyV2Header() []byte {
+	header := []byte("\r\n\r\n\x00\r\nQUIT\n")
+	header = append(header, 0x21, 0x11, 0, 12)
+	header = append(header, 192, 0, 2, 10, 192, 0, 2, 20)
+	header = binary.BigEndian.AppendUint16(header, 5555)
+	return binary.BigEndian.AppendUint16(header, 443)
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	addrs := make(chan string, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go axon.Serve(ctx, axon.ProxyProtocolListener(ln), nil, func(ctx context.Context, conn *axon.Conn[string]) {
+		ip, _ := conn.Get(axon.MetaClientIP)
+		addrs <- fmt.Sprintf("%v %v", conn.RemoteAddr(), ip)
+	})
+
+	tests := []struct {
+		name   string
+		header []byte
+		want   string
+	}{
+		{"v1", []byte("PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n"), "192.0.2.1:56324 192.0.2.1"},
+		{"v1 ipv6", []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"), "[2001:db8::1]:56324 2001:db8::1"},
+		{"v2", proxyV2Header(), "192.0.2.10:5555 192.0.2.10"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+
+			conn, err := axon.Dial[string](dialCtx, "ws://"+ln.Addr().String()+"/", &axon.DialOptions{
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					nc, err := new(net.Dialer).DialContext(ctx, network, addr)
+					if err != nil {
+						return nil, err
+					}
+					_, err = nc.Write(tt.header)
+					return nc, err
+				},
+				Proxy: func(*http.Request) (*url.URL, error) { return nil, nil },
+			})
+			if err != nil {
+				t.Fatalf("Dial() error = %v", err)
+			}
+			defer conn.Close(1000, "")
+
+			if got := <-addrs; got != tt.want {
+				t.Errorf("remote address = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// Connections without the header are refused
+	dialCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if conn, err := axon.Dial[string](dialCtx, "ws://"+ln.Addr().String()+"/", nil); err == nil {
+		conn.Close(1000, "")
+		t.Error("Dial() succeeded without a PROXY header")
+	}
+}
+
+// serveProxyProtocol runs Serve with ProxyProtocol set and returns its
+// address and the remote addresses of the connections it serves
+func serveProxyProtocol(t *testing.T, trusted string) (string, chan string) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	addrs := make(chan string, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go axon.Serve(ctx, ln, &axon.UpgradeOptions{
+		ProxyProtocol:  true,
+		TrustedProxies: []netip.Prefix{netip.MustParsePrefix(trusted)},
+	}, func(ctx context.Context, conn *axon.Conn[string]) {
+		addrs <- conn.RemoteAddr().String()
+	})
+	return ln.Addr().String(), addrs
+}
+
+func TestServe_ProxyProtocol(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// Connections from the balancer carry a header
+	addr, addrs := serveProxyProtocol(t, "127.0.0.0/8")
+	conn, err := axon.Dial[string](ctx, "ws://"+addr+"/", &axon.DialOptions{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			nc, err := new(net.Dialer).DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			_, err = nc.Write([]byte("PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n"))
+			return nc, err
+		},
+		Proxy: func(*http.Request) (*url.URL, error) { return nil, nil },
+	})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close(1000, "")
+	if got := <-addrs; got != "192.0.2.1:56324" {
+		t.Errorf("RemoteAddr() = %s, want the address from the header", got)
+	}
+
+	// Others are served as they are
+	addr, addrs = serveProxyProtocol(t, "192.0.2.0/24")
+	direct, err := axon.Dial[string](ctx, "ws://"+addr+"/", nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer direct.Close(1000, "")
+	if got := <-addrs; got != direct.LocalAddr().String() {
+		t.Errorf("RemoteAddr() = %s, want the direct peer %s", got, direct.LocalAddr())
+	}
+}
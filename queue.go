@@ -2,6 +2,9 @@ package axon
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -11,8 +14,21 @@ import (
 type queuedMessage[T any] struct {
 	msg     T
 	ctx     context.Context
-	errCh   chan error
+	errCh   chan error  // Nil for messages restored from a QueueStore
+	done    func(error) // Set for messages queued with EnqueueFunc
 	timeout time.Time
+	id      uint64 // QueueStore ID, when persisted
+}
+
+// resolve reports the outcome of sending the message to its writer
+func (qm queuedMessage[T]) resolve(err error) {
+	if qm.errCh != nil {
+		qm.errCh <- err
+		close(qm.errCh)
+	}
+	if qm.done != nil {
+		qm.done(err)
+	}
 }
 
 // MessageQueue manages queuing of messages during disconnection
@@ -25,6 +41,12 @@ type MessageQueue[T any] struct {
 	enqueued atomic.Int64
 	sent     atomic.Int64
 	closed   atomic.Bool
+	store    QueueStore
+	metrics  *Metrics
+	clock    Clock
+
+	concurrency int
+	key         func(T) string
 }
 
 // newMessageQueue creates a new message queue
@@ -45,8 +67,23 @@ func newMessageQueue[T any](maxSize int, timeout time.Duration) *MessageQueue[T]
 // Enqueue adds a message to the queue
 // Returns an error channel that will receive the send result
 func (mq *MessageQueue[T]) Enqueue(ctx context.Context, msg T) (chan error, error) {
+	errCh := make(chan error, 1)
+	if err := mq.enqueue(queuedMessage[T]{msg: msg, ctx: ctx, errCh: errCh}); err != nil {
+		return nil, err
+	}
+	return errCh, nil
+}
+
+// EnqueueFunc adds a message to the queue without waiting for it
+// done, if not nil, is called with the send result from the flushing goroutine
+func (mq *MessageQueue[T]) EnqueueFunc(ctx context.Context, msg T, done func(error)) error {
+	return mq.enqueue(queuedMessage[T]{msg: msg, ctx: ctx, done: done})
+}
+
+// enqueue adds qm to the queue, setting its expiry
+func (mq *MessageQueue[T]) enqueue(qm queuedMessage[T]) error {
 	if mq.closed.Load() {
-		return nil, ErrQueueClosed
+		return ErrQueueClosed
 	}
 
 	mq.mu.Lock()
@@ -54,81 +91,248 @@ func (mq *MessageQueue[T]) Enqueue(ctx context.Context, msg T) (chan error, erro
 
 	// Check if queue is full
 	if len(mq.queue) >= mq.maxSize {
-		mq.dropped.Add(1)
-		return nil, ErrQueueFull
+		mq.recordDropped(1)
+		return ErrQueueFull
 	}
 
-	errCh := make(chan error, 1)
-	qm := queuedMessage[T]{
-		msg:     msg,
-		ctx:     ctx,
-		errCh:   errCh,
-		timeout: time.Now().Add(mq.timeout),
+	qm.timeout = clockOr(mq.clock).Now().Add(mq.timeout)
+
+	if mq.store != nil {
+		_, data, err := encode(qm.msg)
+		if err != nil {
+			return err
+		}
+		qm.id, err = mq.store.Append(data, qm.timeout)
+		if err != nil {
+			return fmt.Errorf("axon: failed to persist queued message: %w", err)
+		}
 	}
 
 	mq.queue = append(mq.queue, qm)
-	mq.enqueued.Add(1)
+	mq.recordEnqueued()
 
-	return errCh, nil
+	return nil
 }
 
-// Flush sends all queued messages using the provided send function
+// Flush sends all queued messages using the provided send function.
+// Messages sharing a key are sent in order, and up to the configured
+// concurrency of keys are flushed at once. When a send fails for a reason
+// other than the message itself, such as the connection dropping, the
+// unsent messages stay queued in order for the next flush.
 func (mq *MessageQueue[T]) Flush(sendFn func(context.Context, T) error) {
 	mq.mu.Lock()
 	queue := mq.queue
 	mq.queue = make([]queuedMessage[T], 0, mq.maxSize)
+	key := mq.key
+	concurrency := mq.concurrency
 	mq.mu.Unlock()
 
-	now := time.Now()
-	for _, qm := range queue {
+	if concurrency < 1 || key == nil {
+		concurrency = 1
+	}
+
+	// Group by key, keeping each message's position for requeueing
+	type indexed struct {
+		i  int
+		qm queuedMessage[T]
+	}
+	groups := make(map[string][]indexed)
+	var order []string
+
+	now := clockOr(mq.clock).Now()
+	for i, qm := range queue {
 		// Check if message has expired
-		if now.After(qm.timeout) {
-			qm.errCh <- ErrQueueTimeout
-			close(qm.errCh)
-			mq.dropped.Add(1)
+		if !qm.timeout.IsZero() && now.After(qm.timeout) {
+			qm.resolve(ErrQueueTimeout)
+			mq.recordDropped(1)
+			mq.unpersist(qm)
 			continue
 		}
 
 		// Check if context was cancelled
 		if qm.ctx != nil && qm.ctx.Err() != nil {
-			qm.errCh <- qm.ctx.Err()
-			close(qm.errCh)
-			mq.dropped.Add(1)
+			qm.resolve(qm.ctx.Err())
+			mq.recordDropped(1)
+			mq.unpersist(qm)
 			continue
 		}
 
-		// Send the message
-		err := sendFn(qm.ctx, qm.msg)
-		qm.errCh <- err
-		close(qm.errCh)
+		k := ""
+		if key != nil {
+			k = key(qm.msg)
+		}
+		if _, ok := groups[k]; !ok {
+			order = append(order, k)
+		}
+		groups[k] = append(groups[k], indexed{i, qm})
+	}
+
+	var (
+		failed   atomic.Bool
+		mu       sync.Mutex
+		requeued []indexed
+		wg       sync.WaitGroup
+	)
+	sem := make(chan struct{}, concurrency)
+
+	for _, k := range order {
+		group := groups[k]
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			for n, im := range group {
+				if failed.Load() {
+					mu.Lock()
+					requeued = append(requeued, group[n:]...)
+					mu.Unlock()
+					return
+				}
+
+				// Send the message
+				err := sendFn(im.qm.ctx, im.qm.msg)
+				if err != nil && !isMessageError(im.qm, err) {
+					failed.Store(true)
+					mu.Lock()
+					requeued = append(requeued, group[n:]...)
+					mu.Unlock()
+					return
+				}
+
+				im.qm.resolve(err)
+				if err == nil {
+					mq.recordSent()
+				} else {
+					mq.recordDropped(1)
+				}
+				mq.unpersist(im.qm)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(requeued) == 0 {
+		return
+	}
+	slices.SortFunc(requeued, func(a, b indexed) int { return a.i - b.i })
+	msgs := make([]queuedMessage[T], len(requeued))
+	for i, im := range requeued {
+		msgs[i] = im.qm
+	}
+
+	mq.mu.Lock()
+	if !mq.closed.Load() {
+		mq.queue = append(msgs, mq.queue...)
+		mq.mu.Unlock()
+		return
+	}
+	mq.mu.Unlock()
 
-		if err == nil {
-			mq.sent.Add(1)
-		} else {
-			mq.dropped.Add(1)
+	// The queue closed during the flush
+	for _, qm := range msgs {
+		qm.resolve(ErrQueueClosed)
+	}
+	if mq.store == nil {
+		mq.recordDropped(len(msgs))
+	}
+}
+
+// isMessageError reports whether err was caused by the message rather than
+// the connection, so that sending it again cannot succeed
+func isMessageError[T any](qm queuedMessage[T], err error) bool {
+	if qm.ctx != nil && qm.ctx.Err() != nil {
+		return true
+	}
+	return errors.Is(err, ErrSerializationFailed) || errors.Is(err, ErrMessageTooLarge)
+}
+
+// setFlush configures how Flush orders and parallelizes sends
+func (mq *MessageQueue[T]) setFlush(concurrency int, key func(T) string) {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+	mq.concurrency = concurrency
+	mq.key = key
+}
+
+// unpersist removes a message that has left the queue from the store. A
+// failed removal means the message is sent again after a restart.
+func (mq *MessageQueue[T]) unpersist(qm queuedMessage[T]) {
+	if mq.store != nil {
+		mq.store.Remove(qm.id)
+	}
+}
+
+// restore loads the messages persisted in store into the queue and
+// persists later messages there
+func (mq *MessageQueue[T]) restore(store QueueStore) error {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+
+	mq.store = store
+	stored, err := store.Load()
+	if err != nil {
+		return fmt.Errorf("axon: failed to load queued messages: %w", err)
+	}
+
+	for _, sm := range stored {
+		var msg T
+		if len(sm.Data) > 0 {
+			msg, err = decode[T](sm.Data, false)
+			if err != nil {
+				// Unreadable messages would otherwise be loaded forever
+				store.Remove(sm.ID)
+				mq.recordDropped(1)
+				continue
+			}
 		}
+		mq.queue = append(mq.queue, queuedMessage[T]{
+			msg:     msg,
+			ctx:     context.Background(),
+			timeout: sm.Expires,
+			id:      sm.ID,
+		})
 	}
+	return nil
 }
 
 // Clear discards all queued messages
 func (mq *MessageQueue[T]) Clear() {
 	mq.mu.Lock()
-	defer mq.mu.Unlock()
+	queue := mq.queue
+	mq.queue = make([]queuedMessage[T], 0, mq.maxSize)
+	mq.mu.Unlock()
 
-	for _, qm := range mq.queue {
-		qm.errCh <- ErrQueueCleared
-		close(qm.errCh)
+	// Resolve outside the lock, since done callbacks may write again
+	for _, qm := range queue {
+		qm.resolve(ErrQueueCleared)
+		mq.unpersist(qm)
 	}
-	mq.dropped.Add(int64(len(mq.queue)))
-	mq.queue = mq.queue[:0]
+	mq.recordDropped(len(queue))
 }
 
-// Close closes the queue and discards all pending messages
+// Close closes the queue and discards all pending messages. Messages
+// persisted in a QueueStore are kept for the next queue using the store.
 func (mq *MessageQueue[T]) Close() {
 	if mq.closed.Swap(true) {
 		return // Already closed
 	}
-	mq.Clear()
+	if mq.store == nil {
+		mq.Clear()
+		return
+	}
+
+	mq.mu.Lock()
+	queue := mq.queue
+	mq.queue = nil
+	mq.mu.Unlock()
+
+	for _, qm := range queue {
+		qm.resolve(ErrQueueClosed)
+	}
 }
 
 // Size returns the current number of queued messages
@@ -157,3 +361,27 @@ type MessageQueueStats struct {
 	Enqueued    int64
 	Sent        int64
 }
+
+// recordEnqueued counts a queued message
+func (mq *MessageQueue[T]) recordEnqueued() {
+	mq.enqueued.Add(1)
+	if mq.metrics != nil {
+		mq.metrics.RecordQueueEnqueue()
+	}
+}
+
+// recordSent counts a queued message that was sent
+func (mq *MessageQueue[T]) recordSent() {
+	mq.sent.Add(1)
+	if mq.metrics != nil {
+		mq.metrics.RecordQueueSent()
+	}
+}
+
+// recordDropped counts n queued messages that were dropped
+func (mq *MessageQueue[T]) recordDropped(n int) {
+	mq.dropped.Add(int64(n))
+	if mq.metrics != nil {
+		mq.metrics.QueueDropped.Add(int64(n))
+	}
+}
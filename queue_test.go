@@ -2,7 +2,10 @@ package axon_test
 
 import (
 	"context"
+	"errors"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -167,3 +170,118 @@ func TestClient_WriteWhenDisconnected(t *testing.T) {
 		t.Error("expected error when writing while disconnected")
 	}
 }
+
+func TestMessageQueue_FlushKeepsMessagesAfterConnectionError(t *testing.T) {
+	mq := axon.NewMessageQueue[string](10, time.Minute, 1, nil)
+	ctx := context.Background()
+
+	var results []chan error
+	for _, msg := range []string{"a", "b", "c"} {
+		errCh, err := mq.Enqueue(ctx, msg)
+		if err != nil {
+			t.Fatalf("Enqueue() error = %v", err)
+		}
+		results = append(results, errCh)
+	}
+
+	var sent []string
+	mq.Flush(func(ctx context.Context, msg string) error {
+		if msg == "b" {
+			return axon.ErrConnectionClosed
+		}
+		sent = append(sent, msg)
+		return nil
+	})
+	if strings.Join(sent, ",") != "a" {
+		t.Errorf("sent %v, want [a]", sent)
+	}
+	if err := <-results[0]; err != nil {
+		t.Errorf("result of a = %v, want nil", err)
+	}
+	if size := mq.Size(); size != 2 {
+		t.Fatalf("Size() after failed flush = %d, want 2", size)
+	}
+
+	sent = nil
+	mq.Flush(func(ctx context.Context, msg string) error {
+		sent = append(sent, msg)
+		return nil
+	})
+	if strings.Join(sent, ",") != "b,c" {
+		t.Errorf("sent %v, want [b c]", sent)
+	}
+	for _, errCh := range results[1:] {
+		if err := <-errCh; err != nil {
+			t.Errorf("result = %v, want nil", err)
+		}
+	}
+	if stats := mq.Stats(); stats.Dropped != 0 || stats.Sent != 3 {
+		t.Errorf("Stats() = %+v, want 3 sent and none dropped", stats)
+	}
+}
+
+func TestMessageQueue_FlushIsolatesMessageErrors(t *testing.T) {
+	mq := axon.NewMessageQueue[string](10, time.Minute, 1, nil)
+	ctx := context.Background()
+
+	bad, _ := mq.Enqueue(ctx, "bad")
+	good, _ := mq.Enqueue(ctx, "good")
+
+	mq.Flush(func(ctx context.Context, msg string) error {
+		if msg == "bad" {
+			return axon.ErrMessageTooLarge
+		}
+		return nil
+	})
+	if err := <-bad; !errors.Is(err, axon.ErrMessageTooLarge) {
+		t.Errorf("result of bad = %v, want ErrMessageTooLarge", err)
+	}
+	if err := <-good; err != nil {
+		t.Errorf("result of good = %v, want nil", err)
+	}
+	if size := mq.Size(); size != 0 {
+		t.Errorf("Size() = %d, want 0", size)
+	}
+}
+
+func TestMessageQueue_FlushConcurrentKeys(t *testing.T) {
+	key := func(msg string) string { return msg[:1] }
+	mq := axon.NewMessageQueue[string](10, time.Minute, 2, key)
+	ctx := context.Background()
+
+	for _, msg := range []string{"x1", "y1", "x2", "y2", "x3"} {
+		if _, err := mq.Enqueue(ctx, msg); err != nil {
+			t.Fatalf("Enqueue() error = %v", err)
+		}
+	}
+
+	var mu sync.Mutex
+	sent := make(map[string][]string)
+	var inFlight, maxInFlight atomic.Int32
+	mq.Flush(func(ctx context.Context, msg string) error {
+		n := inFlight.Add(1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		inFlight.Add(-1)
+
+		mu.Lock()
+		sent[key(msg)] = append(sent[key(msg)], msg)
+		mu.Unlock()
+		return nil
+	})
+
+	if got := strings.Join(sent["x"], ","); got != "x1,x2,x3" {
+		t.Errorf("x sent in order %s, want x1,x2,x3", got)
+	}
+	if got := strings.Join(sent["y"], ","); got != "y1,y2" {
+		t.Errorf("y sent in order %s, want y1,y2", got)
+	}
+	if m := maxInFlight.Load(); m != 2 {
+		t.Errorf("max concurrent sends = %d, want 2", m)
+	}
+}
@@ -0,0 +1,170 @@
+package axon
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// QueueStore persists a Client's queued messages so that they survive
+// process restarts. Messages are stored serialized.
+type QueueStore interface {
+	// Append persists a message expiring at expires and returns its ID
+	Append(data []byte, expires time.Time) (uint64, error)
+
+	// Remove deletes a message that was sent or dropped
+	Remove(id uint64) error
+
+	// Load returns the persisted messages, oldest first
+	Load() ([]StoredMessage, error)
+}
+
+// StoredMessage is a message persisted in a QueueStore
+type StoredMessage struct {
+	ID      uint64
+	Data    []byte
+	Expires time.Time
+}
+
+// queueFileExt is the extension of FileQueueStore message files
+const queueFileExt = ".msg"
+
+// FileQueueStore is a QueueStore keeping each message in its own file in a
+// directory. Files are written to a temporary name and renamed into place,
+// so a crash never leaves a partial message behind.
+type FileQueueStore struct {
+	dir string
+
+	mu     sync.Mutex
+	nextID uint64
+}
+
+// NewFileQueueStore creates a store in dir, creating the directory if
+// needed. Messages already in dir are kept.
+func NewFileQueueStore(dir string) (*FileQueueStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("axon: failed to create queue directory: %w", err)
+	}
+
+	s := &FileQueueStore{dir: dir, nextID: 1}
+	ids, err := s.ids()
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) > 0 {
+		s.nextID = ids[len(ids)-1] + 1
+	}
+	return s, nil
+}
+
+// Append writes the message to a new file
+func (s *FileQueueStore) Append(data []byte, expires time.Time) (uint64, error) {
+	s.mu.Lock()
+	id := s.nextID
+	s.nextID++
+	s.mu.Unlock()
+
+	var expiresAt int64
+	if !expires.IsZero() {
+		expiresAt = expires.UnixNano()
+	}
+	record := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint64(record, uint64(expiresAt))
+	record = append(record, data...)
+
+	tmp, err := os.CreateTemp(s.dir, "tmp-*")
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tmp.Write(record); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return 0, err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), s.path(id)); err != nil {
+		os.Remove(tmp.Name())
+		return 0, err
+	}
+	return id, nil
+}
+
+// Remove deletes the message's file
+func (s *FileQueueStore) Remove(id uint64) error {
+	err := os.Remove(s.path(id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Load reads every message file in ID order
+func (s *FileQueueStore) Load() ([]StoredMessage, error) {
+	ids, err := s.ids()
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := make([]StoredMessage, 0, len(ids))
+	for _, id := range ids {
+		record, err := os.ReadFile(s.path(id))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		if len(record) < 8 {
+			// Not written by this store; drop it so it is not loaded again
+			s.Remove(id)
+			continue
+		}
+
+		msg := StoredMessage{ID: id, Data: record[8:]}
+		if expiresAt := int64(binary.BigEndian.Uint64(record)); expiresAt != 0 {
+			msg.Expires = time.Unix(0, expiresAt)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+// path returns the file name of message id
+func (s *FileQueueStore) path(id uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", id, queueFileExt))
+}
+
+// ids lists the IDs of the stored messages in ascending order
+func (s *FileQueueStore) ids() ([]uint64, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("axon: failed to read queue directory: %w", err)
+	}
+
+	var ids []uint64
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), queueFileExt)
+		if !ok || e.IsDir() {
+			continue
+		}
+		if id, err := strconv.ParseUint(name, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
@@ -0,0 +1,127 @@
+package axon_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+func TestFileQueueStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := axon.NewFileQueueStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileQueueStore() error = %v", err)
+	}
+
+	expires := time.Unix(1700000000, 0)
+	first, err := store.Append([]byte(`"one"`), expires)
+	if err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	second, err := store.Append([]byte(`"two"`), time.Time{})
+	if err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	if second <= first {
+		t.Errorf("IDs %d, %d are not increasing", first, second)
+	}
+
+	// A new store on the same directory sees the messages and keeps
+	// allocating newer IDs
+	reopened, err := axon.NewFileQueueStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileQueueStore() error = %v", err)
+	}
+	msgs, err := reopened.Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(msgs) != 2 {
+		t.Fatalf("Load() returned %d messages, want 2", len(msgs))
+	}
+	if string(msgs[0].Data) != `"one"` || !msgs[0].Expires.Equal(expires) {
+		t.Errorf("first message = %+v", msgs[0])
+	}
+	if string(msgs[1].Data) != `"two"` || !msgs[1].Expires.IsZero() {
+		t.Errorf("second message = %+v", msgs[1])
+	}
+	third, err := reopened.Append([]byte(`"three"`), time.Time{})
+	if err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	if third <= second {
+		t.Errorf("ID after reopen = %d, want above %d", third, second)
+	}
+
+	for _, id := range []uint64{first, second, third} {
+		if err := reopened.Remove(id); err != nil {
+			t.Errorf("Remove(%d) error = %v", id, err)
+		}
+	}
+	if err := reopened.Remove(first); err != nil {
+		t.Errorf("Remove() of a removed message error = %v", err)
+	}
+	if msgs, _ := reopened.Load(); len(msgs) != 0 {
+		t.Errorf("Load() after Remove = %d messages, want 0", len(msgs))
+	}
+}
+
+func TestClient_QueueStoreSurvivesRestart(t *testing.T) {
+	store, err := axon.NewFileQueueStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileQueueStore() error = %v", err)
+	}
+	// Left over from a previous process
+	if _, err := store.Append([]byte(`"left over"`), time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+
+	received := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[string](w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close(1000, "done")
+		msg, err := conn.Read(context.Background())
+		if err == nil {
+			received <- msg
+		}
+		conn.Read(context.Background())
+	}))
+	defer server.Close()
+
+	opts := axon.DefaultClientOptions()
+	opts.Reconnect.Enabled = false
+	opts.QueueStore = store
+
+	// Closing without connecting keeps the message persisted
+	axon.NewClient[string]("ws://localhost:1", opts).Close()
+	if msgs, _ := store.Load(); len(msgs) != 1 {
+		t.Fatalf("store holds %d messages after Close, want 1", len(msgs))
+	}
+
+	client := axon.NewClient[string]("ws"+strings.TrimPrefix(server.URL, "http"), opts)
+	defer client.Close()
+	if size := client.QueueStats().CurrentSize; size != 1 {
+		t.Errorf("QueueStats().CurrentSize = %d, want 1", size)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	if msg := receive(t, received); msg != "left over" {
+		t.Errorf("server got %q, want %q", msg, "left over")
+	}
+	if msgs, _ := store.Load(); len(msgs) != 0 {
+		t.Errorf("store holds %d messages after flush, want 0", len(msgs))
+	}
+}
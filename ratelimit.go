@@ -0,0 +1,135 @@
+package axon
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RateLimiter throttles upgrade requests before the handshake is accepted
+type RateLimiter interface {
+	// Allow reports whether r may be upgraded now. When it may not, it
+	// returns how long the client should wait before retrying.
+	Allow(r *http.Request) (bool, time.Duration)
+}
+
+// KeyByIP keys rate limiting by the client IP. During an upgrade it is the
+// IP the Upgrader resolves through its TrustedProxies (see ClientIP);
+// otherwise it is the request's remote IP.
+func KeyByIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return remoteIP(r)
+}
+
+// clientIPKey is the context key under which the Upgrader passes the
+// client IP to its RateLimiter
+type clientIPKey struct{}
+
+// KeyByHeader keys rate limiting by the value of the named request header,
+// such as an API key. Requests without the header are keyed as by KeyByIP.
+func KeyByHeader(name string) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		if v := r.Header.Get(name); v != "" {
+			return "header:" + v
+		}
+		return "ip:" + KeyByIP(r)
+	}
+}
+
+// TokenBucketLimiter is a RateLimiter that gives each key a bucket of burst
+// tokens refilled at rate tokens per second. Each upgrade takes one token.
+type TokenBucketLimiter struct {
+	rate  float64
+	burst float64
+	key   func(r *http.Request) string
+	now   func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// tokenBucket is the state of a single key
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucketLimiter creates a token bucket limiter allowing bursts of
+// burst upgrades per key, refilled at rate per second. A nil key limits by
+// KeyByIP.
+func NewTokenBucketLimiter(rate float64, burst int, key func(r *http.Request) string) *TokenBucketLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	if key == nil {
+		key = KeyByIP
+	}
+	return &TokenBucketLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		key:     key,
+		now:     time.Now,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// Allow takes a token from the request's bucket
+func (l *TokenBucketLimiter) Allow(r *http.Request) (bool, time.Duration) {
+	key := l.key(r)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.refill(now, l.rate, l.burst)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	if l.rate <= 0 {
+		return false, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// sweep drops buckets that have refilled completely, since they behave the
+// same as a new bucket. It runs at most once per refill period.
+func (l *TokenBucketLimiter) sweep(now time.Time) {
+	if l.rate <= 0 {
+		return
+	}
+	period := time.Duration(l.burst / l.rate * float64(time.Second))
+	if now.Sub(l.lastSweep) < period {
+		return
+	}
+	l.lastSweep = now
+
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= period {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// refill adds the tokens accrued since the last refill
+func (b *tokenBucket) refill(now time.Time, rate, burst float64) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * rate
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+	}
+	b.last = now
+}
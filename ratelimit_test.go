@@ -0,0 +1,127 @@
+package axon_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+func TestTokenBucketLimiter_Burst(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := axon.NewTokenBucketLimiter(2, 2, nil)
+	l.SetClock(func() time.Time { return now })
+
+	req := upgradeRequest("10.0.0.1:1234")
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow(req); !ok {
+			t.Fatalf("request %d within burst was throttled", i)
+		}
+	}
+
+	ok, wait := l.Allow(req)
+	if ok {
+		t.Fatal("expected request beyond burst to be throttled")
+	}
+	if wait != 500*time.Millisecond {
+		t.Errorf("expected wait of 500ms, got %v", wait)
+	}
+
+	// Other IPs have their own bucket
+	if ok, _ := l.Allow(upgradeRequest("10.0.0.2:1234")); !ok {
+		t.Error("expected request from another IP to be allowed")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if ok, _ := l.Allow(req); !ok {
+		t.Error("expected request to be allowed after refill")
+	}
+}
+
+func TestTokenBucketLimiter_KeyByHeader(t *testing.T) {
+	l := axon.NewTokenBucketLimiter(1, 1, axon.KeyByHeader("X-Api-Key"))
+	l.SetClock(func() time.Time { return time.Unix(0, 0) })
+
+	a := upgradeRequest("10.0.0.1:1234")
+	a.Header.Set("X-Api-Key", "a")
+	b := upgradeRequest("10.0.0.1:1234")
+	b.Header.Set("X-Api-Key", "b")
+
+	if ok, _ := l.Allow(a); !ok {
+		t.Error("expected first request for key a to be allowed")
+	}
+	if ok, _ := l.Allow(a); ok {
+		t.Error("expected second request for key a to be throttled")
+	}
+	if ok, _ := l.Allow(b); !ok {
+		t.Error("expected request for key b to be allowed")
+	}
+}
+
+func TestUpgrader_RateLimited(t *testing.T) {
+	metrics := &axon.Metrics{}
+	limiter := axon.NewTokenBucketLimiter(0.5, 1, nil)
+	limiter.SetClock(func() time.Time { return time.Unix(0, 0) })
+
+	u := axon.NewUpgrader(&axon.UpgradeOptions{
+		RateLimiter: limiter,
+		Metrics:     metrics,
+	})
+
+	// The first request passes the limiter and fails later on the recorder,
+	// which cannot be hijacked
+	axon.UpgradeWith[string](u, httptest.NewRecorder(), upgradeRequest("10.0.0.1:1234"))
+
+	w := httptest.NewRecorder()
+	if _, err := axon.UpgradeWith[string](u, w, upgradeRequest("10.0.0.1:1234")); err != axon.ErrRateLimited {
+		t.Fatalf("expected ErrRateLimited, got %v", err)
+	}
+	if got := w.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("expected Retry-After 2, got %q", got)
+	}
+	if got := metrics.GetSnapshot().RejectedHandshakes; got != 1 {
+		t.Errorf("expected 1 rejected handshake, got %d", got)
+	}
+}
+
+func TestUpgrader_RateLimitedByClientIP(t *testing.T) {
+	limiter := axon.NewTokenBucketLimiter(1, 1, nil)
+	limiter.SetClock(func() time.Time { return time.Unix(0, 0) })
+
+	u := axon.NewUpgrader(&axon.UpgradeOptions{
+		RateLimiter:    limiter,
+		TrustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+	})
+	forwarded := func(client string) *http.Request {
+		req := upgradeRequest("10.0.0.1:1234")
+		req.Header.Set("X-Forwarded-For", client)
+		return req
+	}
+
+	// Clients behind the same proxy have their own buckets
+	for _, client := range []string{"203.0.113.1", "203.0.113.2"} {
+		if _, err := axon.UpgradeWith[string](u, httptest.NewRecorder(), forwarded(client)); err == axon.ErrRateLimited {
+			t.Errorf("expected first request from %s to pass the limiter", client)
+		}
+	}
+	if _, err := axon.UpgradeWith[string](u, httptest.NewRecorder(), forwarded("203.0.113.1")); err != axon.ErrRateLimited {
+		t.Errorf("expected ErrRateLimited for a second request from the same client, got %v", err)
+	}
+}
+
+func TestHandler_RateLimited(t *testing.T) {
+	limiter := axon.NewTokenBucketLimiter(1, 1, nil)
+	limiter.SetClock(func() time.Time { return time.Unix(0, 0) })
+	h := axon.Handler[string](&axon.UpgradeOptions{RateLimiter: limiter}, nil)
+
+	h.ServeHTTP(httptest.NewRecorder(), upgradeRequest("10.0.0.1:1234"))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, upgradeRequest("10.0.0.1:1234"))
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected status %d, got %d", http.StatusTooManyRequests, w.Code)
+	}
+}
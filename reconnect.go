@@ -2,8 +2,14 @@ package axon
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
 	"math"
 	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -47,6 +53,88 @@ type ReconnectConfig struct {
 	// ShouldReconnect is called to determine if reconnection should be attempted
 	// If nil, always attempts to reconnect (within MaxAttempts)
 	ShouldReconnect func(err error, attempt int) bool
+
+	// BeforeAttempt is called before each reconnection attempt dials. The
+	// returned headers replace those of the same name in DialOptions.Headers
+	// and a non-empty url replaces the client's URL, for that attempt only.
+	// An error fails the attempt.
+	BeforeAttempt func(ctx context.Context, attempt int) (headers http.Header, url string, err error)
+
+	// DelayFromError returns the delay a server requested in the error that
+	// caused the disconnection or failed the last attempt, replacing the
+	// backoff delay for the next attempt. The delay is jittered like the
+	// backoff delay.
+	// If nil, RetryDelayFromClose is used.
+	DelayFromError func(err error) (time.Duration, bool)
+
+	// BreakerThreshold opens a circuit breaker after this many consecutive
+	// failed attempts. While open, no attempts are made for BreakerCooldown;
+	// then a single probe attempt is made without backoff, closing the
+	// breaker if it succeeds and opening it again if it fails.
+	// 0 disables the breaker.
+	BreakerThreshold int
+
+	// BreakerCooldown is how long the circuit breaker stays open
+	// Default: 60 seconds
+	BreakerCooldown time.Duration
+
+	// OnBreakerChange is called when the circuit breaker changes state,
+	// with the number of consecutive failed attempts
+	OnBreakerChange func(state BreakerState, failures int)
+}
+
+// BreakerState is the state of the reconnection circuit breaker
+type BreakerState int32
+
+const (
+	// BreakerClosed allows reconnection attempts with normal backoff
+	BreakerClosed BreakerState = iota
+	// BreakerOpen pauses reconnection attempts for the cooldown
+	BreakerOpen
+	// BreakerHalfOpen allows a single probe attempt
+	BreakerHalfOpen
+)
+
+// String returns the string representation of the breaker state
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// retryReason is the structured close reason read by RetryDelayFromClose
+type retryReason struct {
+	RetryAfterMs *int64 `json:"retry_after_ms"`
+}
+
+// RetryDelayFromClose returns the delay requested by a close reason of the
+// form {"retry_after_ms":5000}, as written by RetryAfterReason
+func RetryDelayFromClose(err error) (time.Duration, bool) {
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) || closeErr.Reason == "" || closeErr.Reason[0] != '{' {
+		return 0, false
+	}
+
+	var reason retryReason
+	if json.Unmarshal([]byte(closeErr.Reason), &reason) != nil || reason.RetryAfterMs == nil || *reason.RetryAfterMs < 0 {
+		return 0, false
+	}
+	return time.Duration(*reason.RetryAfterMs) * time.Millisecond, true
+}
+
+// RetryAfterReason returns a close reason asking clients to wait d before
+// reconnecting
+func RetryAfterReason(d time.Duration) string {
+	ms := d.Milliseconds()
+	b, _ := json.Marshal(retryReason{RetryAfterMs: &ms})
+	return string(b)
 }
 
 // DefaultReconnectConfig returns a default reconnection configuration
@@ -62,16 +150,35 @@ func DefaultReconnectConfig() *ReconnectConfig {
 	}
 }
 
-// reconnector manages automatic reconnection
+// ReconnectStats is a snapshot of a Client's reconnection state
+type ReconnectStats struct {
+	Attempts        int           // Attempts since the counter was last reset
+	LastConnectedAt time.Time     // When a reconnection last succeeded
+	NextDelay       time.Duration // Delay before the pending or next attempt
+	Breaker         BreakerState
+}
+
+// reconnector manages automatic reconnection. Its state is shared between
+// the reconnect goroutine and the client, so it is guarded by mu.
 type reconnector struct {
-	config      *ReconnectConfig
+	config  *ReconnectConfig
+	log     *slog.Logger
+	metrics *Metrics
+	clock   Clock
+	breaker atomic.Int32 // BreakerState
+
+	mu          sync.Mutex
 	attempts    int
+	failures    int // Consecutive failed attempts
 	lastConnect time.Time
+	pending     time.Duration // Delay of the attempt in progress
+	waiting     bool          // Whether an attempt is waiting out its delay
 	rand        *rand.Rand
 }
 
-// newReconnector creates a new reconnector with the given configuration
-func newReconnector(config *ReconnectConfig) *reconnector {
+// newReconnector creates a new reconnector with the given configuration,
+// logging its attempts to log and counting them in metrics if not nil
+func newReconnector(config *ReconnectConfig, log *slog.Logger, metrics *Metrics, clock Clock) *reconnector {
 	if config == nil {
 		config = DefaultReconnectConfig()
 	}
@@ -88,11 +195,41 @@ func newReconnector(config *ReconnectConfig) *reconnector {
 	if config.ResetAfter <= 0 {
 		config.ResetAfter = 60 * time.Second
 	}
+	if config.BreakerCooldown <= 0 {
+		config.BreakerCooldown = 60 * time.Second
+	}
 
 	return &reconnector{
-		config: config,
-		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		config:  config,
+		log:     loggerOr(log),
+		metrics: metrics,
+		clock:   clockOr(clock),
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// attemptCount returns the number of attempts since the last reset
+func (r *reconnector) attemptCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.attempts
+}
+
+// stats returns a snapshot of the reconnection state
+func (r *reconnector) stats() ReconnectStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := ReconnectStats{
+		Attempts:        r.attempts,
+		LastConnectedAt: r.lastConnect,
+		NextDelay:       r.pending,
+		Breaker:         r.breakerState(),
 	}
+	if !r.waiting {
+		stats.NextDelay = r.backoff(r.attempts + 1)
+	}
+	return stats
 }
 
 // shouldReconnect determines if a reconnection should be attempted
@@ -101,12 +238,13 @@ func (r *reconnector) shouldReconnect(err error) bool {
 		return false
 	}
 
-	if r.config.MaxAttempts > 0 && r.attempts >= r.config.MaxAttempts {
+	attempts := r.attemptCount()
+	if r.config.MaxAttempts > 0 && attempts >= r.config.MaxAttempts {
 		return false
 	}
 
 	if r.config.ShouldReconnect != nil {
-		return r.config.ShouldReconnect(err, r.attempts)
+		return r.config.ShouldReconnect(err, attempts)
 	}
 
 	if closeErr := AsCloseError(err); closeErr != nil {
@@ -116,16 +254,49 @@ func (r *reconnector) shouldReconnect(err error) bool {
 	return true
 }
 
-// nextDelay calculates the next reconnection delay using exponential backoff
-func (r *reconnector) nextDelay() time.Duration {
-	delay := float64(r.config.InitialDelay) * math.Pow(r.config.BackoffMultiplier, float64(r.attempts))
+// backoff returns the exponential backoff delay before attempt, without
+// jitter. The caller must hold mu.
+func (r *reconnector) backoff(attempt int) time.Duration {
+	delay := float64(r.config.InitialDelay) * math.Pow(r.config.BackoffMultiplier, float64(attempt))
 
 	// Cap at max delay
 	if delay > float64(r.config.MaxDelay) {
 		delay = float64(r.config.MaxDelay)
 	}
 
-	// Add jitter if enabled (±25%)
+	return time.Duration(delay)
+}
+
+// nextDelay calculates the next reconnection delay using exponential
+// backoff. The caller must hold mu.
+func (r *reconnector) nextDelay() time.Duration {
+	return r.jitter(r.backoff(r.attempts))
+}
+
+// delayAfter returns the delay before the next attempt following err,
+// preferring a delay requested by the server
+func (r *reconnector) delayAfter(err error) time.Duration {
+	delayFromError := r.config.DelayFromError
+	if delayFromError == nil {
+		delayFromError = RetryDelayFromClose
+	}
+	requested, ok := time.Duration(0), false
+	if err != nil {
+		requested, ok = delayFromError(err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ok {
+		return r.jitter(requested)
+	}
+	return r.nextDelay()
+}
+
+// jitter adds randomness to delay if enabled (±25%). The caller must hold
+// mu, as rand is not safe for concurrent use.
+func (r *reconnector) jitter(d time.Duration) time.Duration {
+	delay := float64(d)
 	if r.config.Jitter {
 		jitterRange := delay * 0.25
 		jitter := (r.rand.Float64() * 2 * jitterRange) - jitterRange
@@ -135,51 +306,97 @@ func (r *reconnector) nextDelay() time.Duration {
 	return time.Duration(delay)
 }
 
-// attempt performs a single reconnection attempt
-func (r *reconnector) attempt(ctx context.Context, dialFn func(context.Context) error) error {
+// attempt performs a single reconnection attempt, err being the error that
+// caused the previous disconnection or failed attempt
+func (r *reconnector) attempt(ctx context.Context, err error, dialFn func(context.Context) error) error {
+	r.mu.Lock()
 	r.attempts++
-	delay := r.nextDelay()
+	attempt := r.attempts
+	r.mu.Unlock()
+
+	delay := r.delayAfter(err)
+	if r.breakerState() == BreakerHalfOpen {
+		delay = 0 // Probe right after the cooldown
+	}
+
+	r.mu.Lock()
+	r.pending = delay
+	r.waiting = true
+	r.mu.Unlock()
 
+	r.log.Info("reconnecting", "attempt", attempt, "delay", delay)
 	if r.config.OnReconnecting != nil {
-		r.config.OnReconnecting(r.attempts, delay)
+		r.config.OnReconnecting(attempt, delay)
 	}
 
+	timer := r.clock.NewTimer(delay)
 	select {
 	case <-ctx.Done():
+		timer.Stop()
+		r.setWaiting(false)
 		return ctx.Err()
-	case <-time.After(delay):
+	case <-timer.C():
 	}
+	r.setWaiting(false)
 
-	err := dialFn(ctx)
+	if r.metrics != nil {
+		r.metrics.RecordReconnectAttempt()
+	}
+	err = dialFn(ctx)
 	if err != nil {
+		r.log.Warn("reconnect attempt failed", "attempt", attempt, "error", err)
+		if r.metrics != nil {
+			r.metrics.RecordReconnectFailure()
+		}
 		if r.config.OnReconnectFailed != nil {
-			r.config.OnReconnectFailed(r.attempts, err)
+			r.config.OnReconnectFailed(attempt, err)
 		}
 		return err
 	}
 
+	r.log.Info("reconnected", "attempt", attempt)
+	if r.metrics != nil {
+		r.metrics.RecordReconnectSuccess()
+	}
 	if r.config.OnReconnected != nil {
-		r.config.OnReconnected(r.attempts) // Success
+		r.config.OnReconnected(attempt) // Success
 	}
 
-	r.lastConnect = time.Now()
+	r.mu.Lock()
+	r.lastConnect = r.clock.Now()
+	r.mu.Unlock()
 	return nil
 }
 
+// setWaiting records whether an attempt is waiting out its delay
+func (r *reconnector) setWaiting(waiting bool) {
+	r.mu.Lock()
+	r.waiting = waiting
+	if !waiting {
+		r.pending = 0
+	}
+	r.mu.Unlock()
+}
+
 // reset resets the attempt counter
 func (r *reconnector) reset() {
+	r.mu.Lock()
 	r.attempts = 0
+	r.mu.Unlock()
 }
 
 // maybeReset resets the attempt counter if connected long enough
 func (r *reconnector) maybeReset() {
-	if !r.lastConnect.IsZero() && time.Since(r.lastConnect) >= r.config.ResetAfter {
-		r.reset()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.lastConnect.IsZero() && r.clock.Now().Sub(r.lastConnect) >= r.config.ResetAfter {
+		r.attempts = 0
 	}
 }
 
-// reconnectLoop continuously attempts to reconnect until successful or cancelled
-func (r *reconnector) reconnectLoop(ctx context.Context, dialFn func(context.Context) error) error {
+// reconnectLoop continuously attempts to reconnect until successful or
+// cancelled, err being the error that caused the disconnection
+func (r *reconnector) reconnectLoop(ctx context.Context, err error, dialFn func(context.Context) error) error {
 	for {
 		if ctx.Err() != nil {
 			return ctx.Err()
@@ -189,13 +406,74 @@ func (r *reconnector) reconnectLoop(ctx context.Context, dialFn func(context.Con
 			return ErrReconnectFailed
 		}
 
-		err := r.attempt(ctx, dialFn)
+		err = r.attempt(ctx, err, dialFn)
 		if err == nil {
+			r.mu.Lock()
+			r.failures = 0
+			r.mu.Unlock()
+			r.setBreaker(BreakerClosed)
 			return nil // Success
 		}
+		r.mu.Lock()
+		r.failures++
+		r.mu.Unlock()
 
 		if !r.shouldReconnect(err) {
 			return ErrReconnectFailed
 		}
+
+		if r.tripped() {
+			if err := r.cooldown(ctx); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// tripped reports whether the last failure opens the circuit breaker
+func (r *reconnector) tripped() bool {
+	threshold := r.config.BreakerThreshold
+	if threshold <= 0 {
+		return false
+	}
+	r.mu.Lock()
+	failures := r.failures
+	r.mu.Unlock()
+	return r.breakerState() == BreakerHalfOpen || failures >= threshold
+}
+
+// cooldown opens the circuit breaker, waits out the cooldown and half-opens
+// it for a probe attempt
+func (r *reconnector) cooldown(ctx context.Context) error {
+	r.setBreaker(BreakerOpen)
+
+	timer := r.clock.NewTimer(r.config.BreakerCooldown)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C():
+	}
+
+	r.setBreaker(BreakerHalfOpen)
+	return nil
+}
+
+// breakerState returns the circuit breaker state
+func (r *reconnector) breakerState() BreakerState {
+	return BreakerState(r.breaker.Load())
+}
+
+// setBreaker changes the circuit breaker state, notifying OnBreakerChange
+func (r *reconnector) setBreaker(state BreakerState) {
+	if BreakerState(r.breaker.Swap(int32(state))) == state {
+		return
+	}
+	r.mu.Lock()
+	failures := r.failures
+	r.mu.Unlock()
+	r.log.Warn("reconnect breaker changed", "state", state.String(), "failures", failures)
+	if r.config.OnBreakerChange != nil {
+		r.config.OnBreakerChange(state, failures)
 	}
 }
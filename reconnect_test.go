@@ -1,6 +1,13 @@
 package axon_test
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -180,3 +187,144 @@ func TestReconnectConfig_BackoffCalculation(t *testing.T) {
 		_ = i
 	}
 }
+
+func TestRetryDelayFromClose(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		delay time.Duration
+		ok    bool
+	}{
+		{"retry after", axon.NewCloseError(int(axon.CloseTryAgainLater), `{"retry_after_ms":5000}`), 5 * time.Second, true},
+		{"zero", axon.NewCloseError(int(axon.CloseGoingAway), `{"retry_after_ms":0}`), 0, true},
+		{"reason helper", axon.NewCloseError(int(axon.CloseGoingAway), axon.RetryAfterReason(1500*time.Millisecond)), 1500 * time.Millisecond, true},
+		{"plain reason", axon.NewCloseError(int(axon.CloseGoingAway), "restarting"), 0, false},
+		{"other fields", axon.NewCloseError(int(axon.CloseGoingAway), `{"region":"eu"}`), 0, false},
+		{"negative", axon.NewCloseError(int(axon.CloseGoingAway), `{"retry_after_ms":-1}`), 0, false},
+		{"not a close error", errors.New("dial failed"), 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay, ok := axon.RetryDelayFromClose(tt.err)
+			if delay != tt.delay || ok != tt.ok {
+				t.Errorf("RetryDelayFromClose() = %v, %v, want %v, %v", delay, ok, tt.delay, tt.ok)
+			}
+		})
+	}
+}
+
+func TestReconnectConfig_DelayFromError(t *testing.T) {
+	newConfig := func() *axon.ReconnectConfig {
+		return &axon.ReconnectConfig{
+			Enabled:           true,
+			InitialDelay:      100 * time.Millisecond,
+			MaxDelay:          time.Second,
+			BackoffMultiplier: 2.0,
+		}
+	}
+	steered := axon.NewCloseError(int(axon.CloseTryAgainLater), axon.RetryAfterReason(5*time.Second))
+
+	if delay := axon.ReconnectDelay(newConfig(), steered); delay != 5*time.Second {
+		t.Errorf("delay after retry_after_ms close = %v, want 5s", delay)
+	}
+	if delay := axon.ReconnectDelay(newConfig(), errors.New("dial failed")); delay != 200*time.Millisecond {
+		t.Errorf("delay after other error = %v, want backoff of 200ms", delay)
+	}
+
+	errBusy := errors.New("busy")
+	cfg := newConfig()
+	cfg.DelayFromError = func(err error) (time.Duration, bool) {
+		if errors.Is(err, errBusy) {
+			return 7 * time.Second, true
+		}
+		return 0, false
+	}
+	if delay := axon.ReconnectDelay(cfg, errBusy); delay != 7*time.Second {
+		t.Errorf("delay from DelayFromError = %v, want 7s", delay)
+	}
+	if delay := axon.ReconnectDelay(cfg, steered); delay != 200*time.Millisecond {
+		t.Errorf("custom DelayFromError should replace close reason parsing, got %v", delay)
+	}
+}
+
+func TestClient_ReconnectStats(t *testing.T) {
+	var conns atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[string](w, r, nil)
+		if err != nil {
+			return
+		}
+		if conns.Add(1) == 1 {
+			conn.Close(int(axon.CloseGoingAway), "restarting")
+			return
+		}
+		defer conn.Close(1000, "done")
+		for {
+			if _, err := conn.Read(r.Context()); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	reconnecting := make(chan time.Duration, 1)
+	opts := axon.DefaultClientOptions()
+	opts.Reconnect.InitialDelay = 50 * time.Millisecond
+	opts.Reconnect.MaxDelay = time.Second
+	opts.Reconnect.Jitter = false
+	opts.Reconnect.OnReconnecting = func(attempt int, delay time.Duration) {
+		reconnecting <- delay
+	}
+	client := axon.NewClient[string]("ws"+strings.TrimPrefix(server.URL, "http"), opts)
+	defer client.Close()
+
+	if stats := client.ReconnectStats(); stats.Attempts != 0 || !stats.LastConnectedAt.IsZero() || stats.NextDelay != 100*time.Millisecond {
+		t.Errorf("ReconnectStats() before connecting = %+v", stats)
+	}
+
+	// Snapshots are safe while the reconnect goroutine runs
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				client.ReconnectStats()
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.ConnectWithReadLoop(ctx); err != nil {
+		t.Fatalf("ConnectWithReadLoop() error = %v", err)
+	}
+
+	delay := receive(t, reconnecting)
+	if stats := client.ReconnectStats(); stats.Attempts != 1 || stats.NextDelay != delay {
+		t.Errorf("ReconnectStats() while waiting = %+v, want 1 attempt with delay %v", stats, delay)
+	}
+
+	if err := client.WaitForState(ctx, axon.StateConnected); err != nil {
+		t.Fatalf("WaitForState() error = %v", err)
+	}
+	stats := client.ReconnectStats()
+	if stats.LastConnectedAt.IsZero() {
+		t.Error("LastConnectedAt should be set after reconnecting")
+	}
+	if stats.NextDelay != 200*time.Millisecond {
+		t.Errorf("NextDelay after reconnecting = %v, want 200ms", stats.NextDelay)
+	}
+	if stats.Breaker != axon.BreakerClosed {
+		t.Errorf("Breaker = %v, want closed", stats.Breaker)
+	}
+}
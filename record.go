@@ -0,0 +1,195 @@
+package axon
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// RecordedFrame is a frame captured by a Recorder
+type RecordedFrame struct {
+	Time    time.Time      `json:"time"`
+	Dir     FrameDirection `json:"dir"`
+	Fin     bool           `json:"fin"`
+	Rsv1    bool           `json:"rsv1,omitempty"`
+	Rsv2    bool           `json:"rsv2,omitempty"`
+	Rsv3    bool           `json:"rsv3,omitempty"`
+	Opcode  byte           `json:"opcode"`
+	Masked  bool           `json:"masked,omitempty"`
+	Payload []byte         `json:"payload,omitempty"` // Unmasked
+}
+
+// Recorder writes every frame of a connection, with the time it was read
+// or written, to an io.Writer as JSON lines. Pass its Trace method as the
+// Trace option to capture a connection for ReadRecording and Replay.
+type Recorder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+// NewRecorder creates a Recorder writing to w
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{enc: json.NewEncoder(w)}
+}
+
+// Trace records frame. It is a TraceFunc.
+func (r *Recorder) Trace(dir FrameDirection, frame *Frame) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	r.err = r.enc.Encode(RecordedFrame{
+		Time:    time.Now(),
+		Dir:     dir,
+		Fin:     frame.Fin,
+		Rsv1:    frame.Rsv1,
+		Rsv2:    frame.Rsv2,
+		Rsv3:    frame.Rsv3,
+		Opcode:  frame.Opcode,
+		Masked:  frame.Masked,
+		Payload: frame.Payload,
+	})
+}
+
+// Err returns the first error writing the recording. Frames after it are
+// not recorded.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// ReadRecording reads the frames written by a Recorder
+func ReadRecording(r io.Reader) ([]RecordedFrame, error) {
+	var frames []RecordedFrame
+	dec := json.NewDecoder(r)
+	for {
+		var frame RecordedFrame
+		if err := dec.Decode(&frame); err != nil {
+			if errors.Is(err, io.EOF) {
+				return frames, nil
+			}
+			return frames, err
+		}
+		frames = append(frames, frame)
+	}
+}
+
+// ReplayOptions configures Replay
+type ReplayOptions struct {
+	// Speed scales the recorded time between frames, so 1 replays in real
+	// time and 2 twice as fast.
+	// Default is 0 (frames are replayed without pauses).
+	Speed float64
+
+	// Dial configures the replayed connection of a client recording, and
+	// Upgrade that of a server recording. Replaying compressed frames
+	// needs compression enabled on both.
+	Dial    *DialOptions
+	Upgrade *UpgradeOptions
+
+	// Clock paces the replay
+	// Default is SystemClock.
+	Clock Clock
+}
+
+// Replay returns a connection that reads the frames recorded as read, in
+// order and with their original payloads, for debugging and regression
+// tests of captured sessions. The connection is a server if the recorded
+// reads were masked and a client otherwise. What it writes is discarded,
+// and once the frames run out it reads as if the network had failed,
+// unless a close frame was replayed.
+func Replay[T any](frames []RecordedFrame, opts *ReplayOptions) (*Conn[T], error) {
+	if opts == nil {
+		opts = &ReplayOptions{}
+	}
+
+	isServer := false
+	for _, frame := range frames {
+		if frame.Dir == FrameRead {
+			isServer = frame.Masked
+			break
+		}
+	}
+
+	client, server, err := Pipe[T](opts.Dial, opts.Upgrade)
+	if err != nil {
+		return nil, err
+	}
+	conn, peer := client, server
+	if isServer {
+		conn, peer = server, client
+	}
+
+	// The peer only writes recorded frames, so it never answers the
+	// replayed connection
+	if peer.acquireIO() {
+		go func() {
+			defer peer.releaseIO()
+			io.Copy(io.Discard, peer.reader)
+		}()
+	}
+	go func() {
+		defer func() {
+			peer.conn.Close()
+			peer.Close(int(CloseAbnormalClosure), "replay finished")
+		}()
+		if err := replayFrames(peer, frames, opts); err != nil {
+			peer.logger().Debug("replay stopped", "error", err)
+		}
+	}()
+	return conn, nil
+}
+
+// replayFrames writes the frames recorded as read from peer, pacing them
+// as recorded when opts.Speed is set
+func replayFrames[T any](peer *Conn[T], frames []RecordedFrame, opts *ReplayOptions) error {
+	clk := clockOr(opts.Clock)
+	var last time.Time
+	for _, recorded := range frames {
+		if recorded.Dir != FrameRead {
+			continue
+		}
+		if opts.Speed > 0 && !last.IsZero() {
+			if gap := recorded.Time.Sub(last); gap > 0 {
+				<-clk.NewTimer(time.Duration(float64(gap) / opts.Speed)).C()
+			}
+		}
+		last = recorded.Time
+
+		frame := &Frame{
+			Fin:     recorded.Fin,
+			Rsv1:    recorded.Rsv1,
+			Rsv2:    recorded.Rsv2,
+			Rsv3:    recorded.Rsv3,
+			Opcode:  recorded.Opcode,
+			Payload: recorded.Payload,
+		}
+		if peer.isClient {
+			maskFrame(frame)
+		}
+		if err := peer.writeRecorded(frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeRecorded writes frame as is, bypassing compression and the write
+// chain
+func (c *Conn[T]) writeRecorded(frame *Frame) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if !c.acquireIO() {
+		return ErrConnectionClosed
+	}
+	defer c.releaseIO()
+	if err := c.writeFrame(frame); err != nil {
+		return err
+	}
+	return c.flush()
+}
@@ -0,0 +1,166 @@
+package axon_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+// recordSession records the client side of a session in which the client
+// sends "hello" and the server answers "a" and "b" and closes
+func recordSession(t *testing.T, clientTrace, serverTrace axon.TraceFunc) {
+	t.Helper()
+	client, server, err := axon.Pipe[string](
+		&axon.DialOptions{Trace: clientTrace},
+		&axon.UpgradeOptions{Trace: serverTrace},
+	)
+	if err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server.Read(ctx)
+		server.Write(ctx, "a")
+		server.Write(ctx, "b")
+		server.Close(int(axon.CloseGoingAway), "restart")
+	}()
+	client.Write(ctx, "hello")
+	for {
+		if _, err := client.Read(ctx); err != nil {
+			break
+		}
+	}
+	<-done
+}
+
+// readAll reads messages until the connection fails
+func readAll(conn *axon.Conn[string]) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var msgs []string
+	for {
+		msg, err := conn.Read(ctx)
+		if err != nil {
+			return msgs, err
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+func TestReplay_Client(t *testing.T) {
+	var buf bytes.Buffer
+	rec := axon.NewRecorder(&buf)
+	recordSession(t, rec.Trace, nil)
+	if err := rec.Err(); err != nil {
+		t.Fatalf("Recorder.Err() = %v", err)
+	}
+
+	frames, err := axon.ReadRecording(&buf)
+	if err != nil {
+		t.Fatalf("ReadRecording() error = %v", err)
+	}
+	if len(frames) == 0 || frames[0].Dir != axon.FrameWritten || string(frames[0].Payload) != `"hello"` {
+		t.Fatalf("first frame = %+v, want the written hello", frames)
+	}
+
+	conn, err := axon.Replay[string](frames, nil)
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	defer conn.Close(1000, "")
+
+	// Writes go nowhere and do not disturb the replay
+	conn.Write(context.Background(), "ignored")
+
+	msgs, err := readAll(conn)
+	if strings.Join(msgs, ",") != "a,b" {
+		t.Errorf("messages = %q, want a, b", msgs)
+	}
+	if closeErr := axon.AsCloseError(err); closeErr == nil || closeErr.Code != axon.CloseGoingAway || closeErr.Reason != "restart" {
+		t.Errorf("Read() error = %v, want the recorded close", err)
+	}
+}
+
+func TestReplay_Server(t *testing.T) {
+	var buf bytes.Buffer
+	rec := axon.NewRecorder(&buf)
+	recordSession(t, nil, rec.Trace)
+
+	frames, err := axon.ReadRecording(&buf)
+	if err != nil {
+		t.Fatalf("ReadRecording() error = %v", err)
+	}
+
+	// The recorded reads were masked, so the replay is a server
+	conn, err := axon.Replay[string](frames, nil)
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	defer conn.Close(1000, "")
+
+	msgs, err := readAll(conn)
+	if strings.Join(msgs, ",") != "hello" {
+		t.Errorf("messages = %q, want hello", msgs)
+	}
+	if err == nil {
+		t.Error("Read() succeeded after the recording ran out")
+	}
+}
+
+func TestReplay_Speed(t *testing.T) {
+	start := time.Now()
+	frames := []axon.RecordedFrame{
+		{Time: start, Dir: axon.FrameRead, Fin: true, Opcode: 1, Payload: []byte(`"a"`)},
+		{Time: start.Add(time.Hour), Dir: axon.FrameRead, Fin: true, Opcode: 1, Payload: []byte(`"b"`)},
+	}
+	clock := axon.NewFakeClock(start)
+	conn, err := axon.Replay[string](frames, &axon.ReplayOptions{Speed: 2, Clock: clock})
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	defer conn.Close(1000, "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if msg, err := conn.Read(ctx); err != nil || msg != "a" {
+		t.Fatalf("Read() = %q, %v, want a", msg, err)
+	}
+
+	// The hour between the frames takes half an hour at double speed
+	clock.BlockUntil(1)
+	clock.Advance(29 * time.Minute)
+	readCtx, readCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	if _, err := conn.Read(readCtx); err == nil {
+		t.Fatal("Read() returned before the gap elapsed")
+	}
+	readCancel()
+	clock.Advance(time.Minute)
+	if msg, err := conn.Read(ctx); err != nil || msg != "b" {
+		t.Fatalf("Read() = %q, %v, want b", msg, err)
+	}
+}
+
+func TestFrameDirection_Text(t *testing.T) {
+	for _, dir := range []axon.FrameDirection{axon.FrameRead, axon.FrameWritten} {
+		text, err := dir.MarshalText()
+		if err != nil {
+			t.Fatalf("MarshalText() error = %v", err)
+		}
+		var got axon.FrameDirection
+		if err := got.UnmarshalText(text); err != nil || got != dir {
+			t.Errorf("UnmarshalText(%q) = %v, %v, want %v", text, got, err, dir)
+		}
+	}
+	var dir axon.FrameDirection
+	if err := dir.UnmarshalText([]byte("sideways")); err == nil {
+		t.Error("UnmarshalText(sideways) succeeded")
+	}
+}
@@ -0,0 +1,108 @@
+package axon
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// RegisteredConn is the message-type independent view of a connection held
+// by the package registry
+type RegisteredConn interface {
+	// ID returns the connection's unique ID
+	ID() string
+
+	// RemoteAddr returns the peer's network address
+	RemoteAddr() net.Addr
+
+	// Context returns the context the connection was upgraded with
+	Context() context.Context
+
+	// Set stores a metadata value on the connection
+	Set(key string, value any)
+
+	// Get returns a metadata value set on the connection
+	Get(key string) (any, bool)
+
+	// IsClosed returns true if the connection has been closed
+	IsClosed() bool
+
+	// Close closes the connection with the given code and reason
+	Close(code int, reason string) error
+}
+
+// connRegistry tracks every open connection by ID
+type connRegistry struct {
+	mu    sync.RWMutex
+	conns map[string]RegisteredConn
+}
+
+// registry is the package-level connection registry
+var registry = &connRegistry{
+	conns: make(map[string]RegisteredConn),
+}
+
+// connIDCounter generates connection IDs
+var connIDCounter atomic.Uint64
+
+// newConnID returns a process-unique connection ID
+func newConnID() string {
+	return strconv.FormatUint(connIDCounter.Add(1), 10)
+}
+
+// add registers a connection
+func (r *connRegistry) add(c RegisteredConn) {
+	r.mu.Lock()
+	r.conns[c.ID()] = c
+	r.mu.Unlock()
+}
+
+// remove unregisters a connection
+func (r *connRegistry) remove(id string) {
+	r.mu.Lock()
+	delete(r.conns, id)
+	r.mu.Unlock()
+}
+
+// LookupConn returns the open connection with the given ID
+func LookupConn(id string) (RegisteredConn, bool) {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	c, ok := registry.conns[id]
+	return c, ok
+}
+
+// RangeConns calls fn for each open connection until fn returns false.
+// fn runs on a snapshot, so it may close connections.
+func RangeConns(fn func(c RegisteredConn) bool) {
+	registry.mu.RLock()
+	conns := make([]RegisteredConn, 0, len(registry.conns))
+	for _, c := range registry.conns {
+		conns = append(conns, c)
+	}
+	registry.mu.RUnlock()
+
+	for _, c := range conns {
+		if !fn(c) {
+			return
+		}
+	}
+}
+
+// ConnCount returns the number of open connections
+func ConnCount() int {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	return len(registry.conns)
+}
+
+// CloseConn closes the open connection with the given ID
+func CloseConn(id string, code int, reason string) error {
+	c, ok := LookupConn(id)
+	if !ok {
+		return ErrConnectionNotFound
+	}
+	return c.Close(code, reason)
+}
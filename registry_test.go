@@ -0,0 +1,84 @@
+package axon_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+func TestRegistry_LookupAndClose(t *testing.T) {
+	conn, clientConn, err := axon.NewTestConn[string](nil)
+	if err != nil {
+		t.Fatalf("failed to create test connection: %v", err)
+	}
+	defer clientConn.Close()
+
+	other, otherClient, err := axon.NewTestConn[string](nil)
+	if err != nil {
+		t.Fatalf("failed to create test connection: %v", err)
+	}
+	defer other.Close(1000, "")
+	defer otherClient.Close()
+
+	if conn.ID() == "" || conn.ID() == other.ID() {
+		t.Fatalf("expected unique IDs, got %q and %q", conn.ID(), other.ID())
+	}
+
+	found, ok := axon.LookupConn(conn.ID())
+	if !ok || found.ID() != conn.ID() {
+		t.Fatalf("expected to find connection %q", conn.ID())
+	}
+
+	seen := 0
+	axon.RangeConns(func(c axon.RegisteredConn) bool {
+		if c.ID() == conn.ID() || c.ID() == other.ID() {
+			seen++
+		}
+		return true
+	})
+	if seen != 2 {
+		t.Errorf("expected RangeConns to visit both connections, visited %d", seen)
+	}
+	if axon.ConnCount() < 2 {
+		t.Errorf("expected at least 2 registered connections, got %d", axon.ConnCount())
+	}
+
+	go readServerFrame(clientConn)
+	if err := axon.CloseConn(conn.ID(), int(axon.ClosePolicyViolation), "kicked"); err != nil {
+		t.Fatalf("close by ID failed: %v", err)
+	}
+	if !conn.IsClosed() || conn.CloseCode() != int(axon.ClosePolicyViolation) {
+		t.Errorf("expected connection closed with 1008, got closed=%v code=%d", conn.IsClosed(), conn.CloseCode())
+	}
+	if _, ok := axon.LookupConn(conn.ID()); ok {
+		t.Error("closed connection should be removed from the registry")
+	}
+	if err := axon.CloseConn(conn.ID(), 1000, ""); err != axon.ErrConnectionNotFound {
+		t.Errorf("expected ErrConnectionNotFound, got %v", err)
+	}
+}
+
+func TestRegistry_PeerClose(t *testing.T) {
+	conn, clientConn, err := axon.NewTestConn[string](nil)
+	if err != nil {
+		t.Fatalf("failed to create test connection: %v", err)
+	}
+	defer conn.Close(1000, "")
+	defer clientConn.Close()
+
+	go writeClientFrame(clientConn, 0x8, []byte{0x03, 0xE8})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var closeErr *axon.CloseError
+	if _, err := conn.Read(ctx); !errors.As(err, &closeErr) {
+		t.Fatalf("expected close error, got %v", err)
+	}
+	if _, ok := axon.LookupConn(conn.ID()); ok {
+		t.Error("connection closed by the peer should be removed from the registry")
+	}
+}
@@ -0,0 +1,113 @@
+package axon
+
+import "context"
+
+// SetCallID sets the function that extracts the correlation ID from a
+// message. Call uses it to tag requests and to match responses; messages
+// without an ID, or whose ID matches no pending call, go to OnMessage.
+func (c *Client[T]) SetCallID(fn func(T) string) {
+	c.callsMu.Lock()
+	c.callID = fn
+	c.callsMu.Unlock()
+}
+
+// Call writes req and waits for the message carrying the same correlation
+// ID, as extracted by the SetCallID function. Responses are delivered by
+// the read loop, so the client must be connected with ConnectWithReadLoop.
+// Without a deadline on ctx, ClientOptions.CallTimeout applies. Pending
+// calls fail when the connection is lost.
+func (c *Client[T]) Call(ctx context.Context, req T) (T, error) {
+	var zero T
+
+	c.callsMu.Lock()
+	if c.callID == nil {
+		c.callsMu.Unlock()
+		return zero, ErrNoCallID
+	}
+	id := c.callID(req)
+	if id == "" {
+		c.callsMu.Unlock()
+		return zero, ErrNoCallID
+	}
+	if _, ok := c.calls[id]; ok {
+		c.callsMu.Unlock()
+		return zero, ErrDuplicateCallID
+	}
+	if c.calls == nil {
+		c.calls = make(map[string]*pendingCall[T])
+	}
+	call := &pendingCall[T]{done: make(chan struct{})}
+	c.calls[id] = call
+	c.callsMu.Unlock()
+
+	defer func() {
+		c.callsMu.Lock()
+		if c.calls[id] == call {
+			delete(c.calls, id)
+		}
+		c.callsMu.Unlock()
+	}()
+
+	if _, ok := ctx.Deadline(); !ok && c.opts.CallTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.opts.CallTimeout)
+		defer cancel()
+	}
+
+	if err := c.Write(ctx, req); err != nil {
+		return zero, err
+	}
+
+	select {
+	case <-call.done:
+		return call.resp, call.err
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+}
+
+// PendingCalls returns the number of calls awaiting a response
+func (c *Client[T]) PendingCalls() int {
+	c.callsMu.Lock()
+	defer c.callsMu.Unlock()
+	return len(c.calls)
+}
+
+// pendingCall is a Call awaiting its response
+type pendingCall[T any] struct {
+	resp T
+	err  error
+	done chan struct{}
+}
+
+// resolveCall completes the pending call matching msg, reporting whether
+// there was one
+func (c *Client[T]) resolveCall(msg T) bool {
+	c.callsMu.Lock()
+	defer c.callsMu.Unlock()
+
+	if c.callID == nil || len(c.calls) == 0 {
+		return false
+	}
+	id := c.callID(msg)
+	call, ok := c.calls[id]
+	if id == "" || !ok {
+		return false
+	}
+	delete(c.calls, id)
+	call.resp = msg
+	close(call.done)
+	return true
+}
+
+// failCalls completes all pending calls with err
+func (c *Client[T]) failCalls(err error) {
+	c.callsMu.Lock()
+	defer c.callsMu.Unlock()
+
+	for id, call := range c.calls {
+		delete(c.calls, id)
+		call.err = err
+		close(call.done)
+	}
+}
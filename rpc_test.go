@@ -0,0 +1,141 @@
+package axon_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+type rpcMessage struct {
+	ID     string `json:"id,omitempty"`
+	Method string `json:"method,omitempty"`
+	Result string `json:"result,omitempty"`
+}
+
+// startRPCServer answers "echo" requests with their method name uppercased,
+// sends a notification before each answer and ignores "ignore" requests
+func startRPCServer(t *testing.T) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[rpcMessage](w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close(1000, "done")
+
+		for {
+			req, err := conn.Read(context.Background())
+			if err != nil {
+				return
+			}
+			switch req.Method {
+			case "ignore":
+				continue
+			case "close":
+				return
+			}
+			conn.Write(context.Background(), rpcMessage{Method: "notify"})
+			conn.Write(context.Background(), rpcMessage{ID: req.ID, Result: strings.ToUpper(req.Method)})
+		}
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func newRPCClient(t *testing.T, url string) *axon.Client[rpcMessage] {
+	t.Helper()
+	opts := axon.DefaultClientOptions()
+	opts.Reconnect.Enabled = false
+	client := axon.NewClient[rpcMessage](url, opts)
+	client.SetCallID(func(m rpcMessage) string { return m.ID })
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestClient_Call(t *testing.T) {
+	client := newRPCClient(t, startRPCServer(t))
+
+	notifications := make(chan rpcMessage, 4)
+	client.OnMessage(func(m rpcMessage) {
+		notifications <- m
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.ConnectWithReadLoop(ctx); err != nil {
+		t.Fatalf("ConnectWithReadLoop() error = %v", err)
+	}
+
+	resp, err := client.Call(ctx, rpcMessage{ID: "1", Method: "echo"})
+	if err != nil {
+		t.Fatalf("Call() error = %v", err)
+	}
+	if resp.ID != "1" || resp.Result != "ECHO" {
+		t.Errorf("Call() = %+v, want ID 1 and result ECHO", resp)
+	}
+
+	// Unmatched messages still reach OnMessage
+	select {
+	case m := <-notifications:
+		if m.Method != "notify" {
+			t.Errorf("OnMessage got %+v, want notification", m)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for notification")
+	}
+	if n := client.PendingCalls(); n != 0 {
+		t.Errorf("PendingCalls() = %d, want 0", n)
+	}
+}
+
+func TestClient_CallErrors(t *testing.T) {
+	client := newRPCClient(t, startRPCServer(t))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.ConnectWithReadLoop(ctx); err != nil {
+		t.Fatalf("ConnectWithReadLoop() error = %v", err)
+	}
+
+	if _, err := client.Call(ctx, rpcMessage{Method: "echo"}); !errors.Is(err, axon.ErrNoCallID) {
+		t.Errorf("Call() without ID error = %v, want ErrNoCallID", err)
+	}
+
+	callCtx, callCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer callCancel()
+	if _, err := client.Call(callCtx, rpcMessage{ID: "2", Method: "ignore"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Call() without response error = %v, want DeadlineExceeded", err)
+	}
+	if n := client.PendingCalls(); n != 0 {
+		t.Errorf("PendingCalls() after timeout = %d, want 0", n)
+	}
+}
+
+func TestClient_CallFailsOnDisconnect(t *testing.T) {
+	client := newRPCClient(t, startRPCServer(t))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.ConnectWithReadLoop(ctx); err != nil {
+		t.Fatalf("ConnectWithReadLoop() error = %v", err)
+	}
+
+	if _, err := client.Call(ctx, rpcMessage{ID: "3", Method: "close"}); !errors.Is(err, axon.ErrConnectionClosed) {
+		t.Errorf("Call() error = %v, want ErrConnectionClosed", err)
+	}
+}
+
+func TestClient_CallWithoutCallID(t *testing.T) {
+	client := axon.NewClient[rpcMessage]("ws://localhost:8080", nil)
+	defer client.Close()
+
+	if _, err := client.Call(context.Background(), rpcMessage{ID: "1"}); !errors.Is(err, axon.ErrNoCallID) {
+		t.Errorf("Call() error = %v, want ErrNoCallID", err)
+	}
+}
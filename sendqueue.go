@@ -0,0 +1,206 @@
+package axon
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// OverflowPolicy decides what SendAsync does when a connection's send queue
+// is full
+type OverflowPolicy int
+
+const (
+	// OverflowDropNewest rejects the new message with ErrQueueFull
+	OverflowDropNewest OverflowPolicy = iota
+
+	// OverflowDropOldest discards the oldest queued message to make room
+	OverflowDropOldest
+
+	// OverflowCloseSlowConsumer discards the queue and closes the connection
+	// with ClosePolicyViolation
+	OverflowCloseSlowConsumer
+)
+
+// defaultSendQueueSize is the send queue capacity used when none is set
+const defaultSendQueueSize = 256
+
+// sendQueue buffers messages for a connection's flush goroutine
+type sendQueue[T any] struct {
+	mu      sync.Mutex
+	items   []T
+	maxSize int
+	policy  OverflowPolicy
+	notify  chan struct{}
+	closed  bool
+	evicted bool
+
+	dropped  atomic.Int64
+	enqueued atomic.Int64
+	sent     atomic.Int64
+}
+
+// newSendQueue creates a send queue
+func newSendQueue[T any](maxSize int, policy OverflowPolicy) *sendQueue[T] {
+	if maxSize <= 0 {
+		maxSize = defaultSendQueueSize
+	}
+	return &sendQueue[T]{
+		maxSize: maxSize,
+		policy:  policy,
+		notify:  make(chan struct{}, 1),
+	}
+}
+
+// push adds msg to the queue, applying the overflow policy when it is full
+func (q *sendQueue[T]) push(msg T) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return ErrConnectionClosed
+	}
+
+	if len(q.items) >= q.maxSize {
+		switch q.policy {
+		case OverflowDropOldest:
+			var zero T
+			q.items[0] = zero
+			q.items = q.items[1:]
+			q.dropped.Add(1)
+		case OverflowCloseSlowConsumer:
+			q.dropped.Add(int64(len(q.items)) + 1)
+			q.items = nil
+			q.evicted = true
+			q.closeLocked()
+			return ErrSlowConsumer
+		default:
+			q.dropped.Add(1)
+			return ErrQueueFull
+		}
+	}
+
+	q.items = append(q.items, msg)
+	q.enqueued.Add(1)
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// next blocks until messages are queued and takes all of them. ok is false
+// once the queue is closed; evicted reports whether that was due to
+// overflow.
+func (q *sendQueue[T]) next() (batch []T, evicted, ok bool) {
+	for {
+		q.mu.Lock()
+		if q.closed {
+			evicted = q.evicted
+			q.mu.Unlock()
+			return nil, evicted, false
+		}
+		if len(q.items) > 0 {
+			batch = q.items
+			q.items = nil
+			q.mu.Unlock()
+			return batch, false, true
+		}
+		q.mu.Unlock()
+
+		<-q.notify
+	}
+}
+
+// close discards queued messages and stops the flush goroutine
+func (q *sendQueue[T]) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.dropped.Add(int64(len(q.items)))
+		q.items = nil
+	}
+	q.closeLocked()
+}
+
+// closeLocked marks the queue closed; q.mu must be held
+func (q *sendQueue[T]) closeLocked() {
+	if q.closed {
+		return
+	}
+	q.closed = true
+	close(q.notify)
+}
+
+// SendAsync queues msg to be written by the connection's flush goroutine,
+// so a slow peer never blocks the caller. The queue and goroutine are
+// created on first use. When the queue is full the configured
+// OverflowPolicy applies; messages that fail to write close the
+// connection with CloseInternalError.
+func (c *Conn[T]) SendAsync(msg T) error {
+	if atomic.LoadInt32(&c.closed) != 0 {
+		return ErrConnectionClosed
+	}
+	return c.sendQueue().push(msg)
+}
+
+// SendQueueStats returns statistics for the connection's send queue
+func (c *Conn[T]) SendQueueStats() MessageQueueStats {
+	q := c.sendQ.Load()
+	if q == nil {
+		return MessageQueueStats{}
+	}
+
+	q.mu.Lock()
+	size := len(q.items)
+	q.mu.Unlock()
+
+	return MessageQueueStats{
+		CurrentSize: size,
+		MaxSize:     q.maxSize,
+		Dropped:     q.dropped.Load(),
+		Enqueued:    q.enqueued.Load(),
+		Sent:        q.sent.Load(),
+	}
+}
+
+// sendQueue returns the connection's send queue, starting its flush
+// goroutine on first use
+func (c *Conn[T]) sendQueue() *sendQueue[T] {
+	c.sendOnce.Do(func() {
+		q := newSendQueue[T](c.upgrader.sendQueueSize, c.upgrader.overflowPolicy)
+		c.sendQ.Store(q)
+		// Close may have run before the queue was published
+		if c.IsClosed() {
+			q.close()
+		}
+		go c.flushLoop(q)
+	})
+	return c.sendQ.Load()
+}
+
+// flushLoop writes queued messages until the queue is closed. Overflow
+// evictions close the connection from here so that the close frame is not
+// written in the middle of a message.
+func (c *Conn[T]) flushLoop(q *sendQueue[T]) {
+	for {
+		batch, evicted, ok := q.next()
+		if !ok {
+			if evicted {
+				c.Close(int(ClosePolicyViolation), "slow consumer")
+			}
+			return
+		}
+
+		for i, msg := range batch {
+			if err := c.Write(context.Background(), msg); err != nil {
+				q.dropped.Add(int64(len(batch) - i))
+				q.close()
+				c.Close(int(CloseInternalError), "")
+				return
+			}
+			q.sent.Add(1)
+		}
+	}
+}
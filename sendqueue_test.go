@@ -0,0 +1,122 @@
+package axon_test
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+// newQueueConn creates a test connection whose send queue holds size
+// messages and has an unread message in flight, so later sends queue up
+func newQueueConn(t *testing.T, size int, policy axon.OverflowPolicy) (*axon.Conn[string], net.Conn) {
+	t.Helper()
+
+	conn, clientConn, err := axon.NewTestConn[string](&axon.UpgradeOptions{
+		SendQueueSize:  size,
+		OverflowPolicy: policy,
+	})
+	if err != nil {
+		t.Fatalf("failed to create test connection: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.Close(1000, "")
+		clientConn.Close()
+	})
+
+	if err := conn.SendAsync("first"); err != nil {
+		t.Fatalf("SendAsync() error = %v", err)
+	}
+
+	// Wait for the flush goroutine to take the message and block on the pipe
+	deadline := time.Now().Add(time.Second)
+	for conn.SendQueueStats().CurrentSize != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for flush goroutine")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	return conn, clientConn
+}
+
+// expectQueuedMessages reads text frames and compares their payloads
+func expectQueuedMessages(t *testing.T, clientConn net.Conn, want ...string) {
+	t.Helper()
+
+	for _, w := range want {
+		opcode, payload := readHubFrame(t, clientConn)
+		if opcode != 0x1 || string(payload) != `"`+w+`"` {
+			t.Fatalf("expected %q, got opcode %d payload %q", w, opcode, payload)
+		}
+	}
+}
+
+func TestSendAsync_Order(t *testing.T) {
+	conn, clientConn := newQueueConn(t, 0, axon.OverflowDropNewest)
+
+	for _, msg := range []string{"a", "b", "c"} {
+		if err := conn.SendAsync(msg); err != nil {
+			t.Fatalf("SendAsync() error = %v", err)
+		}
+	}
+
+	expectQueuedMessages(t, clientConn, "first", "a", "b", "c")
+
+	deadline := time.Now().Add(time.Second)
+	for conn.SendQueueStats().Sent != 4 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 4 sent, got %+v", conn.SendQueueStats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSendAsync_DropNewest(t *testing.T) {
+	conn, clientConn := newQueueConn(t, 1, axon.OverflowDropNewest)
+
+	if err := conn.SendAsync("a"); err != nil {
+		t.Fatalf("SendAsync() error = %v", err)
+	}
+	if err := conn.SendAsync("b"); err != axon.ErrQueueFull {
+		t.Fatalf("expected ErrQueueFull, got %v", err)
+	}
+	if got := conn.SendQueueStats().Dropped; got != 1 {
+		t.Errorf("expected 1 dropped, got %d", got)
+	}
+
+	expectQueuedMessages(t, clientConn, "first", "a")
+}
+
+func TestSendAsync_DropOldest(t *testing.T) {
+	conn, clientConn := newQueueConn(t, 1, axon.OverflowDropOldest)
+
+	for _, msg := range []string{"a", "b"} {
+		if err := conn.SendAsync(msg); err != nil {
+			t.Fatalf("SendAsync() error = %v", err)
+		}
+	}
+
+	expectQueuedMessages(t, clientConn, "first", "b")
+}
+
+func TestSendAsync_CloseSlowConsumer(t *testing.T) {
+	conn, clientConn := newQueueConn(t, 1, axon.OverflowCloseSlowConsumer)
+
+	conn.SendAsync("a")
+	if err := conn.SendAsync("b"); err != axon.ErrSlowConsumer {
+		t.Fatalf("expected ErrSlowConsumer, got %v", err)
+	}
+
+	expectQueuedMessages(t, clientConn, "first")
+
+	opcode, payload := readHubFrame(t, clientConn)
+	if opcode != 0x8 || binary.BigEndian.Uint16(payload) != uint16(axon.ClosePolicyViolation) {
+		t.Fatalf("expected policy violation close frame, got opcode %d payload %q", opcode, payload)
+	}
+	if err := conn.SendAsync("c"); err != axon.ErrConnectionClosed {
+		t.Errorf("expected ErrConnectionClosed after eviction, got %v", err)
+	}
+}
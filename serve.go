@@ -0,0 +1,164 @@
+package axon
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultHandshakeTimeout bounds the upgrade handshake of a server
+// connection when UpgradeOptions.HandshakeTimeout is not set
+const defaultHandshakeTimeout = 10 * time.Second
+
+// Serve accepts connections on ln, upgrades them and runs fn for each in its
+// own goroutine. It parses only the upgrade request itself rather than going
+// through net/http's server, so a dedicated WebSocket port carries no HTTP
+// server state per connection.
+//
+// Serve closes ln and returns ctx.Err() once ctx is canceled; handlers that
+// are still running see their context canceled. Any other Accept error that
+// is not a timeout is returned as is.
+func Serve[T any](ctx context.Context, ln net.Listener, opts *UpgradeOptions, fn HandlerFunc[T]) error {
+	u := NewUpgrader(opts)
+	return acceptLoop(ctx, ln, opts, func(nc net.Conn) {
+		go serveConn(ctx, u, nc, fn)
+	})
+}
+
+// acceptLoop accepts connections on ln and passes them to accept until ctx
+// is canceled, behaving as described for Serve
+func acceptLoop(ctx context.Context, ln net.Listener, opts *UpgradeOptions, accept func(nc net.Conn)) error {
+	if opts != nil && opts.ProxyProtocol {
+		ln = &proxyListener{Listener: ln, trusted: opts.TrustedProxies}
+	}
+
+	// Closing the listener is the only way to interrupt Accept
+	stop := context.AfterFunc(ctx, func() {
+		ln.Close()
+	})
+	defer stop()
+
+	var delay time.Duration
+	for {
+		nc, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				// Back off on transient errors such as running out of file
+				// descriptors, as net/http does
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay *= 2; delay > time.Second {
+					delay = time.Second
+				}
+				time.Sleep(delay)
+				continue
+			}
+			return err
+		}
+		delay = 0
+
+		accept(nc)
+	}
+}
+
+// serveConn performs the upgrade handshake on a raw connection and runs fn
+func serveConn[T any](ctx context.Context, u *Upgrader, nc net.Conn, fn HandlerFunc[T]) {
+	conn, err := acceptConn[T](ctx, u, nc)
+	if err != nil {
+		return
+	}
+	runConn(conn.Context(), conn, fn, nil)
+}
+
+// acceptConn performs the upgrade handshake on a raw connection, closing
+// it if the handshake fails
+func acceptConn[T any](ctx context.Context, u *Upgrader, nc net.Conn) (*Conn[T], error) {
+	nc.SetDeadline(time.Now().Add(u.handshakeTimeout))
+
+	// Reading is capped, with the slack net/http allows for the request
+	// line, so that a client cannot send unbounded headers
+	limited := &io.LimitedReader{R: nc, N: int64(u.maxHeaderBytes) + 4096}
+	br := getReader(limited, 4096)
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		if limited.N <= 0 {
+			err = ErrHeaderTooLarge
+		}
+		writeHandshakeError(nc, err, make(http.Header))
+		putReader(br)
+		nc.Close()
+		return nil, err
+	}
+	// The reader is kept for the connection since the client may send
+	// frames right behind the request
+	reader := u.hijackedReader(nc, br)
+	putReader(br)
+	req = req.WithContext(ctx)
+	req.RemoteAddr = nc.RemoteAddr().String()
+	if tc, ok := nc.(*tls.Conn); ok {
+		state := tc.ConnectionState()
+		req.TLS = &state
+	}
+
+	header := make(http.Header)
+	release := func() {}
+	hs, err := u.negotiate(header, req)
+	if err == nil {
+		release, err = u.admit(header, req)
+	}
+	if err == nil {
+		if ctx, err = u.before(header, req); err != nil {
+			release()
+		}
+	}
+	if err != nil {
+		writeHandshakeError(nc, err, header)
+		u.freeReader(reader)
+		nc.Close()
+		return nil, err
+	}
+
+	if _, err := io.WriteString(nc, hs.response(nil)); err != nil {
+		release()
+		u.freeReader(reader)
+		nc.Close()
+		return nil, err
+	}
+
+	nc.SetDeadline(time.Time{})
+
+	hs.release = release
+	conn := newServerConn[T](u.connContext(req, ctx), u, nc, reader, hs)
+	if err := u.after(conn); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// writeHandshakeError writes a minimal HTTP error response for a rejected
+// upgrade request
+func writeHandshakeError(w io.Writer, err error, header http.Header) {
+	status := UpgradeErrorStatus(err)
+	if status == 0 {
+		status = http.StatusBadRequest
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "HTTP/1.1 %d %s\r\n", status, http.StatusText(status))
+	header.Set("Connection", "close")
+	header.Set("Content-Length", "0")
+	header.Write(&b)
+	b.WriteString("\r\n")
+
+	io.WriteString(w, b.String())
+}
@@ -0,0 +1,132 @@
+package axon_test
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+// startServe runs Serve on a loopback listener until the test ends
+func startServe(t *testing.T, fn axon.HandlerFunc[string]) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- axon.Serve(ctx, ln, nil, fn)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		select {
+		case err := <-done:
+			if err != context.Canceled {
+				t.Errorf("expected Serve to return context.Canceled, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Error("timeout waiting for Serve to return")
+		}
+	})
+
+	return ln.Addr().String()
+}
+
+func TestServe_Echo(t *testing.T) {
+	addr := startServe(t, func(ctx context.Context, conn *axon.Conn[string]) {
+		for {
+			msg, err := conn.Read(ctx)
+			if err != nil {
+				return
+			}
+			if err := conn.Write(ctx, msg); err != nil {
+				return
+			}
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	conn, err := axon.Dial[string](ctx, "ws://"+addr+"/", nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close(1000, "")
+
+	for _, msg := range []string{"one", "two"} {
+		if err := conn.Write(ctx, msg); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+		got, err := conn.Read(ctx)
+		if err != nil {
+			t.Fatalf("read failed: %v", err)
+		}
+		if got != msg {
+			t.Errorf("expected %q, got %q", msg, got)
+		}
+	}
+}
+
+func TestServe_RejectsPlainHTTP(t *testing.T) {
+	addr := startServe(t, func(ctx context.Context, conn *axon.Conn[string]) {
+		t.Error("handler should not run for a plain HTTP request")
+	})
+
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer nc.Close()
+
+	nc.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	nc.SetReadDeadline(time.Now().Add(time.Second))
+
+	resp, err := http.ReadResponse(bufio.NewReader(nc), nil)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUpgradeRequired {
+		t.Errorf("expected status %d, got %d", http.StatusUpgradeRequired, resp.StatusCode)
+	}
+}
+
+func TestServe_RejectsOversizedHeaders(t *testing.T) {
+	addr := startServe(t, func(ctx context.Context, conn *axon.Conn[string]) {
+		t.Error("handler should not run for oversized headers")
+	})
+
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer nc.Close()
+
+	// The server stops reading once the limit is hit, so write in the
+	// background
+	go nc.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n" +
+		"Cookie: " + strings.Repeat("a", 2<<20) + "\r\n\r\n"))
+	nc.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	resp, err := http.ReadResponse(bufio.NewReader(nc), nil)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusRequestHeaderFieldsTooLarge {
+		t.Errorf("expected status %d, got %d", http.StatusRequestHeaderFieldsTooLarge, resp.StatusCode)
+	}
+}
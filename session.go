@@ -0,0 +1,289 @@
+package axon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Handshake headers a Client sends to resume its session
+const (
+	// SessionHeader carries the session ID set with Client.SetSessionID
+	SessionHeader = "Axon-Session-Id"
+
+	// LastSeqHeader carries the sequence number of the last message the
+	// client received in the session
+	LastSeqHeader = "Axon-Last-Seq"
+)
+
+// SessionMessage is a message stored for replay
+type SessionMessage struct {
+	Seq  uint64
+	Type MessageType
+	Data []byte
+}
+
+// SessionStore keeps the messages sent in each session so that a resuming
+// client can be sent the ones it missed
+type SessionStore interface {
+	// Append stores a message sent in session
+	Append(session string, msg SessionMessage) error
+
+	// Since returns the stored messages of session with a sequence number
+	// above seq, oldest first. It returns ErrSessionNotFound when the
+	// session is unknown or messages after seq are no longer stored.
+	Since(session string, seq uint64) ([]SessionMessage, error)
+
+	// Delete forgets session
+	Delete(session string) error
+}
+
+// SessionOptions configures ResumeSession
+type SessionOptions[T any] struct {
+	// Store keeps sent messages for replay
+	Store SessionStore
+
+	// Stamp returns msg carrying sequence number seq, for the client to
+	// report back when it resumes
+	Stamp func(msg T, seq uint64) T
+}
+
+// Session is a server connection whose messages are numbered and stored so
+// that a client reconnecting with the same session ID receives the
+// messages it missed
+type Session[T any] struct {
+	conn    *Conn[T]
+	id      string
+	store   SessionStore
+	stamp   func(T, uint64) T
+	resumed bool
+
+	mu  sync.Mutex
+	seq uint64
+}
+
+// ResumeSession starts or resumes the session named in r's handshake
+// headers, first writing to conn every stored message the client has not
+// received. It returns ErrSessionNotFound when r carries no session ID.
+// When the store cannot replay everything the client missed, the session
+// starts over and Resumed reports false.
+func ResumeSession[T any](ctx context.Context, conn *Conn[T], r *http.Request, opts SessionOptions[T]) (*Session[T], error) {
+	if opts.Store == nil || opts.Stamp == nil {
+		return nil, errors.New("axon: SessionOptions requires Store and Stamp")
+	}
+
+	id := r.Header.Get(SessionHeader)
+	if id == "" {
+		return nil, ErrSessionNotFound
+	}
+	var lastSeq uint64
+	if v := r.Header.Get(LastSeqHeader); v != "" {
+		seq, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("axon: invalid %s: %w", LastSeqHeader, err)
+		}
+		lastSeq = seq
+	}
+
+	s := &Session[T]{
+		conn:  conn,
+		id:    id,
+		store: opts.Store,
+		stamp: opts.Stamp,
+		seq:   lastSeq,
+	}
+
+	missed, err := opts.Store.Since(id, lastSeq)
+	switch {
+	case errors.Is(err, ErrSessionNotFound):
+		// Numbering continues so the client sees new messages as newer
+		if err := opts.Store.Delete(id); err != nil {
+			return nil, err
+		}
+		return s, nil
+	case err != nil:
+		return nil, err
+	}
+
+	for _, msg := range missed {
+		if err := conn.WriteMessage(ctx, msg.Type, msg.Data); err != nil {
+			return nil, err
+		}
+		s.seq = max(s.seq, msg.Seq)
+	}
+	s.resumed = true
+	return s, nil
+}
+
+// ID returns the session ID
+func (s *Session[T]) ID() string {
+	return s.id
+}
+
+// Resumed reports whether the client's missed messages were replayed
+func (s *Session[T]) Resumed() bool {
+	return s.resumed
+}
+
+// Conn returns the session's connection
+func (s *Session[T]) Conn() *Conn[T] {
+	return s.conn
+}
+
+// Write numbers msg, stores it and writes it to the connection. The
+// message is stored even if the write fails, so it is replayed when the
+// client resumes.
+func (s *Session[T]) Write(ctx context.Context, msg T) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seq := s.seq + 1
+	opcode, payload, err := encode(s.stamp(msg, seq))
+	if err != nil {
+		return err
+	}
+	if err := s.store.Append(s.id, SessionMessage{Seq: seq, Type: MessageType(opcode), Data: payload}); err != nil {
+		return err
+	}
+	s.seq = seq
+
+	return s.conn.WriteMessage(ctx, MessageType(opcode), payload)
+}
+
+// SetSessionSeq sets the function that extracts the sequence number from
+// received messages. The client tracks the last one received and, while it
+// has a session ID, presents both when it connects so the server can
+// replay the messages it missed.
+func (c *Client[T]) SetSessionSeq(fn func(T) (uint64, bool)) {
+	c.sessionMu.Lock()
+	c.sessionSeq = fn
+	c.sessionMu.Unlock()
+}
+
+// LastSeq returns the sequence number of the last message received in the
+// current session
+func (c *Client[T]) LastSeq() uint64 {
+	c.sessionMu.Lock()
+	defer c.sessionMu.Unlock()
+	return c.lastSeq
+}
+
+// trackSeq records the sequence number of a received message
+func (c *Client[T]) trackSeq(msg T) {
+	c.sessionMu.Lock()
+	defer c.sessionMu.Unlock()
+	if c.sessionSeq == nil {
+		return
+	}
+	if seq, ok := c.sessionSeq(msg); ok && seq > c.lastSeq {
+		c.lastSeq = seq
+	}
+}
+
+// MemorySessionStore is a SessionStore that keeps the latest messages of
+// each session in memory
+type MemorySessionStore struct {
+	maxMessages int
+	ttl         time.Duration
+	now         func() time.Time
+
+	mu        sync.Mutex
+	sessions  map[string]*storedSession
+	lastSweep time.Time
+}
+
+// storedSession is the history of a single session
+type storedSession struct {
+	messages []SessionMessage
+	evicted  uint64 // Highest sequence number no longer stored
+	last     time.Time
+}
+
+// NewMemorySessionStore creates a store keeping up to maxMessages messages
+// per session. Sessions without messages for ttl are forgotten; a zero ttl
+// keeps them until deleted.
+func NewMemorySessionStore(maxMessages int, ttl time.Duration) *MemorySessionStore {
+	if maxMessages <= 0 {
+		maxMessages = 1000
+	}
+	return &MemorySessionStore{
+		maxMessages: maxMessages,
+		ttl:         ttl,
+		now:         time.Now,
+		sessions:    make(map[string]*storedSession),
+	}
+}
+
+// Append stores a message sent in session
+func (m *MemorySessionStore) Append(session string, msg SessionMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.sweep(now)
+
+	s, ok := m.sessions[session]
+	if !ok {
+		// Earlier messages of the session were never stored
+		s = &storedSession{evicted: msg.Seq - 1}
+		m.sessions[session] = s
+	}
+	if len(s.messages) >= m.maxMessages {
+		s.evicted = s.messages[0].Seq
+		s.messages[0] = SessionMessage{}
+		s.messages = s.messages[1:]
+	}
+	s.messages = append(s.messages, msg)
+	s.last = now
+	return nil
+}
+
+// Since returns the stored messages of session after seq
+func (m *MemorySessionStore) Since(session string, seq uint64) ([]SessionMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[session]
+	if !ok || m.expired(s, m.now()) || seq < s.evicted {
+		return nil, ErrSessionNotFound
+	}
+
+	var missed []SessionMessage
+	for _, msg := range s.messages {
+		if msg.Seq > seq {
+			missed = append(missed, msg)
+		}
+	}
+	return missed, nil
+}
+
+// Delete forgets session
+func (m *MemorySessionStore) Delete(session string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, session)
+	return nil
+}
+
+// expired reports whether s has outlived the ttl
+func (m *MemorySessionStore) expired(s *storedSession, now time.Time) bool {
+	return m.ttl > 0 && now.Sub(s.last) >= m.ttl
+}
+
+// sweep drops expired sessions at most once per ttl
+func (m *MemorySessionStore) sweep(now time.Time) {
+	if m.ttl <= 0 || now.Sub(m.lastSweep) < m.ttl {
+		return
+	}
+	m.lastSweep = now
+
+	for id, s := range m.sessions {
+		if m.expired(s, now) {
+			delete(m.sessions, id)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package axon_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+type seqMessage struct {
+	Seq  uint64 `json:"seq"`
+	Data string `json:"data"`
+}
+
+func TestMemorySessionStore(t *testing.T) {
+	store := axon.NewMemorySessionStore(2, time.Minute)
+	now := time.Unix(0, 0)
+	store.SetClock(func() time.Time { return now })
+
+	if _, err := store.Since("s", 0); !errors.Is(err, axon.ErrSessionNotFound) {
+		t.Errorf("Since() unknown session error = %v, want ErrSessionNotFound", err)
+	}
+
+	for seq := uint64(1); seq <= 3; seq++ {
+		store.Append("s", axon.SessionMessage{Seq: seq, Type: axon.TextMessage, Data: []byte{byte('0' + seq)}})
+	}
+
+	missed, err := store.Since("s", 1)
+	if err != nil {
+		t.Fatalf("Since() error = %v", err)
+	}
+	if len(missed) != 2 || missed[0].Seq != 2 || missed[1].Seq != 3 {
+		t.Errorf("Since(1) = %+v, want seqs 2 and 3", missed)
+	}
+
+	// Message 1 was evicted, so resuming from 0 cannot be replayed
+	if _, err := store.Since("s", 0); !errors.Is(err, axon.ErrSessionNotFound) {
+		t.Errorf("Since(0) after eviction error = %v, want ErrSessionNotFound", err)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := store.Since("s", 2); !errors.Is(err, axon.ErrSessionNotFound) {
+		t.Errorf("Since() after ttl error = %v, want ErrSessionNotFound", err)
+	}
+
+	store.Append("t", axon.SessionMessage{Seq: 1})
+	store.Delete("t")
+	if _, err := store.Since("t", 0); !errors.Is(err, axon.ErrSessionNotFound) {
+		t.Errorf("Since() after Delete error = %v, want ErrSessionNotFound", err)
+	}
+}
+
+func TestClient_SessionResume(t *testing.T) {
+	store := axon.NewMemorySessionStore(100, 0)
+	stamp := func(m seqMessage, seq uint64) seqMessage {
+		m.Seq = seq
+		return m
+	}
+
+	var conns atomic.Int32
+	resumed := make(chan bool, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[seqMessage](w, r, nil)
+		if err != nil {
+			return
+		}
+		ctx := context.Background()
+		sess, err := axon.ResumeSession(ctx, conn, r, axon.SessionOptions[seqMessage]{Store: store, Stamp: stamp})
+		if err != nil {
+			t.Errorf("ResumeSession() error = %v", err)
+			conn.Close(int(axon.CloseInternalError), "")
+			return
+		}
+		resumed <- sess.Resumed()
+
+		if conns.Add(1) == 1 {
+			sess.Write(ctx, seqMessage{Data: "one"})
+			conn.Close(int(axon.CloseGoingAway), "restarting")
+			// Sent while the client is away
+			sess.Write(ctx, seqMessage{Data: "two"})
+			return
+		}
+		sess.Write(ctx, seqMessage{Data: "three"})
+		conn.Read(ctx)
+	}))
+	defer server.Close()
+
+	opts := axon.DefaultClientOptions()
+	opts.Reconnect.InitialDelay = 10 * time.Millisecond
+	opts.Reconnect.Jitter = false
+	client := axon.NewClient[seqMessage]("ws"+strings.TrimPrefix(server.URL, "http"), opts)
+	defer client.Close()
+
+	client.SetSessionID("session-1")
+	client.SetSessionSeq(func(m seqMessage) (uint64, bool) {
+		return m.Seq, m.Seq != 0
+	})
+	messages := make(chan seqMessage, 4)
+	client.OnMessage(func(m seqMessage) {
+		messages <- m
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.ConnectWithReadLoop(ctx); err != nil {
+		t.Fatalf("ConnectWithReadLoop() error = %v", err)
+	}
+
+	for i, want := range []string{"one", "two", "three"} {
+		m := receive(t, messages)
+		if m.Data != want || m.Seq != uint64(i+1) {
+			t.Errorf("message %d = %+v, want %q with seq %d", i, m, want, i+1)
+		}
+	}
+	if first, second := receive(t, resumed), receive(t, resumed); first || !second {
+		t.Errorf("Resumed() = %v then %v, want false then true", first, second)
+	}
+	if seq := client.LastSeq(); seq != 3 {
+		t.Errorf("LastSeq() = %d, want 3", seq)
+	}
+
+	client.SetSessionID("session-2")
+	if seq := client.LastSeq(); seq != 0 {
+		t.Errorf("LastSeq() after new session = %d, want 0", seq)
+	}
+}
+
+func TestResumeSession_NoSessionID(t *testing.T) {
+	conn, _, err := axon.NewTestConn[seqMessage](nil)
+	if err != nil {
+		t.Fatalf("NewTestConn() error = %v", err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	opts := axon.SessionOptions[seqMessage]{
+		Store: axon.NewMemorySessionStore(0, 0),
+		Stamp: func(m seqMessage, seq uint64) seqMessage { return m },
+	}
+	if _, err := axon.ResumeSession(context.Background(), conn, r, opts); !errors.Is(err, axon.ErrSessionNotFound) {
+		t.Errorf("ResumeSession() error = %v, want ErrSessionNotFound", err)
+	}
+}
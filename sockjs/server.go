@@ -0,0 +1,527 @@
+package sockjs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+// ErrServerClosed is returned for sessions opened after Close
+var ErrServerClosed = errors.New("sockjs: server closed")
+
+// Server serves SockJS clients under a URL prefix. It implements the
+// greeting, info, websocket, xhr-streaming, xhr-polling and xhr_send
+// endpoints, and raw WebSockets at "<prefix>/websocket".
+type Server[T any] struct {
+	prefix    string
+	opts      Options[T]
+	handler   axon.HandlerFunc[T]
+	upgrader  *axon.Upgrader // Raw WebSockets
+	transport *axon.Upgrader // The websocket transport
+	backend   *pipeListener
+	cancel    context.CancelFunc
+	maxBody   int64            // Limit of xhr_send bodies
+	limiter   axon.RateLimiter // Applied to sessions opened by xhr requests
+
+	mu       sync.Mutex
+	sessions map[string]*session
+	closed   bool
+}
+
+// NewServer returns a Server for the SockJS endpoint at prefix, such as
+// "/echo", that runs handler for every session until it returns
+func NewServer[T any](prefix string, opts *Options[T], handler axon.HandlerFunc[T]) *Server[T] {
+	s := &Server[T]{
+		prefix:   strings.TrimSuffix(prefix, "/"),
+		handler:  handler,
+		backend:  newPipeListener(),
+		sessions: make(map[string]*session),
+	}
+	if opts != nil {
+		s.opts = *opts
+	}
+	if s.opts.HeartbeatDelay <= 0 {
+		s.opts.HeartbeatDelay = DefaultHeartbeatDelay
+	}
+	if s.opts.DisconnectDelay <= 0 {
+		s.opts.DisconnectDelay = DefaultDisconnectDelay
+	}
+	if s.opts.ResponseLimit <= 0 {
+		s.opts.ResponseLimit = DefaultResponseLimit
+	}
+	s.upgrader = axon.NewUpgrader(s.opts.UpgradeOptions)
+
+	// Sessions are WebSocket connections over in-memory pipes, so the
+	// handler sees the same kind of connection for every transport.
+	// Interceptors run on those rather than on the websocket transport.
+	// The pipes carry the client's address, so connection limits and
+	// bans apply to every session; the rate limiter is applied to the
+	// requests opening them, as the websocket transport is rate limited
+	// when it is upgraded.
+	var backend axon.UpgradeOptions
+	if s.opts.UpgradeOptions != nil {
+		backend = *s.opts.UpgradeOptions
+	}
+	s.maxBody = 1 << 20
+	if backend.MaxMessageSize > 0 {
+		s.maxBody = int64(backend.MaxMessageSize)
+	}
+	transport := backend
+	transport.Interceptors = nil
+	s.transport = axon.NewUpgrader(&transport)
+
+	s.limiter = backend.RateLimiter
+	backend.CheckOrigin = nil
+	backend.RateLimiter = nil
+	backend.Subprotocols = nil
+	backend.Compression = false
+	backend.HTTPFallback = false
+	backend.MaxFrameSize = max(backend.MaxFrameSize, backend.MaxMessageSize, 1<<20)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	go axon.Serve(ctx, s.backend, &backend, s.run)
+	return s
+}
+
+// run runs the handler for a session, registered with the hub if any
+func (s *Server[T]) run(ctx context.Context, conn *axon.Conn[T]) {
+	if s.opts.Hub != nil {
+		if id, err := s.opts.Hub.Register(conn); err == nil {
+			defer s.opts.Hub.Unregister(id)
+		}
+	}
+	s.handler(ctx, conn)
+}
+
+// Close closes every session and stops accepting new ones
+func (s *Server[T]) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	sessions := s.sessions
+	s.sessions = make(map[string]*session)
+	s.mu.Unlock()
+
+	for _, sess := range sessions {
+		sess.close()
+	}
+	s.cancel()
+	return nil
+}
+
+// ServeHTTP routes a request under the prefix to its endpoint
+func (s *Server[T]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path, ok := strings.CutPrefix(r.URL.Path, s.prefix)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch path {
+	case "", "/":
+		s.greeting(w, r)
+		return
+	case "/info":
+		s.info(w, r)
+		return
+	case "/websocket":
+		s.rawWebSocket(w, r)
+		return
+	}
+
+	// Session endpoints are /<server>/<session>/<transport>
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if len(parts) != 3 || !validSegment(parts[0]) || !validSegment(parts[1]) {
+		http.NotFound(w, r)
+		return
+	}
+	if opts := s.opts.UpgradeOptions; opts != nil && opts.CheckOrigin != nil && !opts.CheckOrigin(r) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	id := parts[1]
+
+	switch parts[2] {
+	case "websocket":
+		s.webSocket(w, r)
+	case "xhr":
+		if s.preflight(w, r) {
+			return
+		}
+		s.xhr(w, r, id)
+	case "xhr_streaming":
+		if s.preflight(w, r) {
+			return
+		}
+		s.xhrStreaming(w, r, id)
+	case "xhr_send":
+		if s.preflight(w, r) {
+			return
+		}
+		s.xhrSend(w, r, id)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// validSegment reports whether a server or session ID is acceptable
+func validSegment(s string) bool {
+	return s != "" && !strings.Contains(s, ".")
+}
+
+// greeting answers the base URL
+func (s *Server[T]) greeting(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	io.WriteString(w, "Welcome to SockJS!\n")
+}
+
+// info describes the server to clients choosing a transport
+func (s *Server[T]) info(w http.ResponseWriter, r *http.Request) {
+	setCORS(w, r)
+	switch r.Method {
+	case http.MethodOptions:
+		setPreflight(w, "OPTIONS, GET")
+		return
+	case http.MethodGet:
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	header := w.Header()
+	header.Set("Content-Type", "application/json; charset=UTF-8")
+	header.Set("Cache-Control", "no-store, no-cache, no-transform, must-revalidate, max-age=0")
+	json.NewEncoder(w).Encode(map[string]any{
+		"websocket":     true,
+		"cookie_needed": false,
+		"origins":       []string{"*:*"},
+		"entropy":       rand.Uint32(),
+	})
+}
+
+// preflight answers CORS preflight requests for the xhr endpoints and
+// reports whether it did
+func (s *Server[T]) preflight(w http.ResponseWriter, r *http.Request) bool {
+	setCORS(w, r)
+	switch r.Method {
+	case http.MethodOptions:
+		setPreflight(w, "OPTIONS, POST")
+		return true
+	case http.MethodPost:
+		return false
+	}
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	return true
+}
+
+// setCORS allows the request's origin to read the response
+func setCORS(w http.ResponseWriter, r *http.Request) {
+	header := w.Header()
+	origin := r.Header.Get("Origin")
+	if origin == "" || origin == "null" {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+		header.Set("Access-Control-Allow-Credentials", "true")
+		header.Add("Vary", "Origin")
+	}
+	if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+		header.Set("Access-Control-Allow-Headers", headers)
+	}
+}
+
+// setPreflight answers a preflight request, which may be cached for a year
+func setPreflight(w http.ResponseWriter, methods string) {
+	header := w.Header()
+	header.Set("Access-Control-Allow-Methods", methods)
+	header.Set("Access-Control-Max-Age", "31536000")
+	header.Set("Cache-Control", "public, max-age=31536000")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// rawWebSocket serves a plain WebSocket without SockJS framing
+func (s *Server[T]) rawWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := axon.UpgradeWith[T](s.upgrader, w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close(int(axon.CloseNormalClosure), "")
+	s.run(conn.Context(), conn)
+}
+
+// webSocket serves a session over the websocket transport. Its session
+// ends with the WebSocket, so it is not tracked by ID.
+func (s *Server[T]) webSocket(w http.ResponseWriter, r *http.Request) {
+	ws, err := axon.UpgradeWith[[]byte](s.transport, w, r)
+	if err != nil {
+		return
+	}
+	ctx := ws.Context()
+
+	sess, err := s.open(ctx, r, func() {})
+	if err != nil {
+		ws.WriteMessage(ctx, axon.TextMessage, []byte(closeFrameOf(CloseGoAway, "Go away!")))
+		ws.Close(CloseGoAway, "Go away!")
+		return
+	}
+	defer sess.close()
+	sess.attach()
+
+	// Client messages are read concurrently with frames being written
+	go func() {
+		defer sess.close()
+		for {
+			_, data, err := ws.ReadMessage(ctx)
+			if err != nil {
+				if isTimeout(err) && ctx.Err() == nil {
+					continue
+				}
+				return
+			}
+			if len(data) == 0 {
+				continue
+			}
+			msgs, err := parseMessages(data)
+			if err != nil || sess.send(ctx, msgs) != nil {
+				return
+			}
+		}
+	}()
+
+	frame := openFrame
+	for {
+		if err := ws.WriteMessage(ctx, axon.TextMessage, []byte(frame)); err != nil {
+			ws.Close(int(axon.CloseNormalClosure), "")
+			return
+		}
+		if frame[0] == 'c' {
+			ws.Close(CloseGoAway, "Go away!")
+			return
+		}
+		var ok bool
+		if frame, ok = sess.next(ctx, s.opts.HeartbeatDelay); !ok {
+			ws.Close(int(axon.CloseNormalClosure), "")
+			return
+		}
+	}
+}
+
+// xhr serves a polling request, which receives a single frame
+func (s *Server[T]) xhr(w http.ResponseWriter, r *http.Request, id string) {
+	sess, created, err := s.session(w, r, id)
+	if err != nil {
+		rejectSession(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/javascript; charset=UTF-8")
+	if created {
+		io.WriteString(w, openFrame+"\n")
+		return
+	}
+	if !sess.attach() {
+		io.WriteString(w, closeFrameOf(CloseAnotherConnection, "Another connection still open")+"\n")
+		return
+	}
+	defer sess.detach(s.opts.DisconnectDelay)
+
+	if frame, ok := sess.next(r.Context(), s.opts.HeartbeatDelay); ok {
+		io.WriteString(w, frame+"\n")
+	}
+}
+
+// xhrStreaming serves a streaming request, which receives frames until
+// the response limit is reached
+func (s *Server[T]) xhrStreaming(w http.ResponseWriter, r *http.Request, id string) {
+	sess, created, err := s.session(w, r, id)
+	if err != nil {
+		rejectSession(w, r, err)
+		return
+	}
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "application/javascript; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+
+	// Some browsers only expose a streaming response once 2KB arrived
+	io.WriteString(w, strings.Repeat("h", 2048)+"\n")
+	if created {
+		io.WriteString(w, openFrame+"\n")
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	if !sess.attach() {
+		io.WriteString(w, closeFrameOf(CloseAnotherConnection, "Another connection still open")+"\n")
+		return
+	}
+	defer sess.detach(s.opts.DisconnectDelay)
+
+	written := 0
+	for written < s.opts.ResponseLimit {
+		frame, ok := sess.next(r.Context(), s.opts.HeartbeatDelay)
+		if !ok {
+			return
+		}
+		n, err := io.WriteString(w, frame+"\n")
+		if err != nil || rc.Flush() != nil || frame[0] == 'c' {
+			return
+		}
+		written += n
+	}
+}
+
+// xhrSend passes the messages posted by the client to its session
+func (s *Server[T]) xhrSend(w http.ResponseWriter, r *http.Request, id string) {
+	s.mu.Lock()
+	sess := s.sessions[id]
+	s.mu.Unlock()
+	if sess == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.maxBody))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Payload too large.", http.StatusRequestEntityTooLarge)
+		}
+		return
+	}
+	if len(body) == 0 {
+		http.Error(w, "Payload expected.", http.StatusInternalServerError)
+		return
+	}
+	msgs, err := parseMessages(body)
+	if err != nil {
+		http.Error(w, "Broken JSON encoding.", http.StatusInternalServerError)
+		return
+	}
+	if err := sess.send(r.Context(), msgs); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// session returns the session with id, opening it if it does not exist
+// and the rate limiter allows it
+func (s *Server[T]) session(w http.ResponseWriter, r *http.Request, id string) (sess *session, created bool, err error) {
+	s.mu.Lock()
+	sess = s.sessions[id]
+	s.mu.Unlock()
+	if sess != nil {
+		return sess, false, nil
+	}
+
+	if s.limiter != nil {
+		if ok, wait := s.limiter.Allow(r); !ok {
+			wait = max(wait, time.Second)
+			w.Header().Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+			return nil, false, axon.ErrRateLimited
+		}
+	}
+
+	sess, err = s.open(r.Context(), r, func() { s.remove(id) })
+	if err != nil {
+		return nil, false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		sess.close()
+		return nil, false, ErrServerClosed
+	}
+	if existing := s.sessions[id]; existing != nil {
+		// Another request opened it first
+		sess.close()
+		return existing, false, nil
+	}
+	s.sessions[id] = sess
+	return sess, true, nil
+}
+
+// rejectSession answers a request whose session could not be opened,
+// passing on the status of a session refused by the connection limits or
+// ban list
+func rejectSession(w http.ResponseWriter, r *http.Request, err error) {
+	var hs *axon.HandshakeError
+	switch {
+	case errors.Is(err, axon.ErrRateLimited):
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+	case errors.As(err, &hs):
+		if retry := hs.Response.Header.Get("Retry-After"); retry != "" {
+			w.Header().Set("Retry-After", retry)
+		}
+		http.Error(w, http.StatusText(hs.StatusCode()), hs.StatusCode())
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// remove forgets an expired session
+func (s *Server[T]) remove(id string) {
+	s.mu.Lock()
+	delete(s.sessions, id)
+	s.mu.Unlock()
+}
+
+// open starts the handler for a new session. The handshake carries the
+// request's headers, URL and remote address, so upgrade interceptors,
+// connection limits and bans see the client.
+func (s *Server[T]) open(ctx context.Context, r *http.Request, expire func()) (*session, error) {
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	if closed {
+		return nil, ErrServerClosed
+	}
+
+	header := r.Header.Clone()
+	for _, key := range bridgeDroppedHeaders {
+		header.Del(key)
+	}
+	u := url.URL{Scheme: "ws", Host: r.Host, Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+	if u.Host == "" {
+		u.Host = "sockjs"
+	}
+
+	conn, err := axon.Dial[[]byte](ctx, u.String(), &axon.DialOptions{
+		Headers:        header,
+		MaxFrameSize:   1 << 30,
+		MaxMessageSize: 1 << 30,
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return s.backend.dial(ctx, clientAddr(r))
+		},
+		Proxy: func(*http.Request) (*url.URL, error) { return nil, nil },
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newSession(conn, s.opts.DisconnectDelay, expire), nil
+}
+
+// bridgeDroppedHeaders are the request headers not passed on to the
+// handshake of a session, since they describe the request itself
+var bridgeDroppedHeaders = []string{
+	"Connection", "Upgrade", "Keep-Alive", "Te", "Trailer", "Transfer-Encoding",
+	"Content-Length", "Content-Type",
+	"Sec-Websocket-Key", "Sec-Websocket-Version", "Sec-Websocket-Extensions", "Sec-Websocket-Protocol",
+}
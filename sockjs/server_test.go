@@ -0,0 +1,333 @@
+package sockjs_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+	"github.com/kolosys/axon/sockjs"
+)
+
+// echo answers each message with an "echo " prefix and closes the session
+// with 3001 on "bye"
+func echo(ctx context.Context, conn *axon.Conn[string]) {
+	for {
+		_, msg, err := conn.ReadMessage(ctx)
+		if err != nil {
+			return
+		}
+		if string(msg) == "bye" {
+			conn.Close(3001, "bye")
+			return
+		}
+		if err := conn.WriteMessage(ctx, axon.TextMessage, append([]byte("echo "), msg...)); err != nil {
+			return
+		}
+	}
+}
+
+func newServer(t *testing.T, opts *sockjs.Options[string]) string {
+	t.Helper()
+	s := sockjs.NewServer("/echo", opts, echo)
+	server := httptest.NewServer(s)
+	t.Cleanup(func() {
+		s.Close()
+		server.Close()
+	})
+	return server.URL + "/echo"
+}
+
+// post sends a request to a session endpoint and returns the status and
+// body of the response
+func post(t *testing.T, url, body string) (int, string) {
+	t.Helper()
+	resp, err := http.Post(url, "text/plain", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+func TestServer_Info(t *testing.T) {
+	url := newServer(t, nil)
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(data) != "Welcome to SockJS!\n" {
+		t.Errorf("greeting = %q", data)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, url+"/info", nil)
+	req.Header.Set("Origin", "https://example.com")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var info struct {
+		WebSocket    bool `json:"websocket"`
+		CookieNeeded bool `json:"cookie_needed"`
+	}
+	json.NewDecoder(resp.Body).Decode(&info)
+	resp.Body.Close()
+	if !info.WebSocket || info.CookieNeeded {
+		t.Errorf("info = %+v", info)
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "https://example.com" {
+		t.Errorf("Access-Control-Allow-Origin = %q", got)
+	}
+
+	req, _ = http.NewRequest(http.MethodOptions, url+"/000/abc/xhr", nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Access-Control-Allow-Methods") != "OPTIONS, POST" {
+		t.Errorf("preflight = %d %v", resp.StatusCode, resp.Header)
+	}
+}
+
+func TestServer_XHRPolling(t *testing.T) {
+	url := newServer(t, nil) + "/000/abc"
+
+	if _, body := post(t, url+"/xhr", ""); body != "o\n" {
+		t.Fatalf("first poll = %q, want the open frame", body)
+	}
+	if status, _ := post(t, url+"/xhr_send", `["hi","there"]`); status != http.StatusNoContent {
+		t.Fatalf("xhr_send status = %d", status)
+	}
+	var got []string
+	for len(got) < 2 {
+		_, body := post(t, url+"/xhr", "")
+		var msgs []string
+		if !strings.HasPrefix(body, "a") || json.Unmarshal([]byte(body[1:]), &msgs) != nil {
+			t.Fatalf("poll = %q, want a message frame", body)
+		}
+		got = append(got, msgs...)
+	}
+	if strings.Join(got, "|") != "echo hi|echo there" {
+		t.Errorf("messages = %q", got)
+	}
+
+	if status, body := post(t, url+"/xhr_send", `not json`); status != http.StatusInternalServerError || body != "Broken JSON encoding.\n" {
+		t.Errorf("xhr_send of invalid JSON = %d %q", status, body)
+	}
+	if status, _ := post(t, url+"/xhr_send", ""); status != http.StatusInternalServerError {
+		t.Errorf("xhr_send without a payload status = %d", status)
+	}
+	if status, _ := post(t, newServer(t, nil)+"/000/missing/xhr_send", `["hi"]`); status != http.StatusNotFound {
+		t.Errorf("xhr_send to an unknown session status = %d", status)
+	}
+
+	// The handler's close code reaches the client, for every later poll
+	post(t, url+"/xhr_send", `"bye"`)
+	for range 2 {
+		if _, body := post(t, url+"/xhr", ""); body != `c[3001,"bye"]`+"\n" {
+			t.Errorf("poll after close = %q", body)
+		}
+	}
+}
+
+func TestServer_XHRSendLimit(t *testing.T) {
+	url := newServer(t, &sockjs.Options[string]{
+		UpgradeOptions: &axon.UpgradeOptions{MaxMessageSize: 1024},
+	}) + "/000/abc"
+
+	if _, body := post(t, url+"/xhr", ""); body != "o\n" {
+		t.Fatalf("first poll = %q, want the open frame", body)
+	}
+	large := `["` + strings.Repeat("a", 2048) + `"]`
+	if status, _ := post(t, url+"/xhr_send", large); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("xhr_send of an oversized body status = %d, want 413", status)
+	}
+	if status, _ := post(t, url+"/xhr_send", `["hi"]`); status != http.StatusNoContent {
+		t.Errorf("xhr_send status = %d", status)
+	}
+}
+
+func TestServer_XHRStreaming(t *testing.T) {
+	url := newServer(t, nil) + "/000/abc"
+
+	resp, err := http.Post(url+"/xhr_streaming", "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	lines := bufio.NewReader(resp.Body)
+	readLine := func() string {
+		line, err := lines.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading the stream: %v", err)
+		}
+		return line
+	}
+
+	if prelude := readLine(); prelude != strings.Repeat("h", 2048)+"\n" {
+		t.Errorf("prelude = %.10q...", prelude)
+	}
+	if open := readLine(); open != "o\n" {
+		t.Fatalf("open frame = %q", open)
+	}
+
+	// Only one request may receive at a time
+	if _, body := post(t, url+"/xhr", ""); body != `c[2010,"Another connection still open"]`+"\n" {
+		t.Errorf("concurrent poll = %q", body)
+	}
+
+	post(t, url+"/xhr_send", `["hi"]`)
+	if got := readLine(); got != `a["echo hi"]`+"\n" {
+		t.Errorf("streamed frame = %q", got)
+	}
+}
+
+func TestServer_WebSocket(t *testing.T) {
+	url := "ws" + strings.TrimPrefix(newServer(t, nil), "http")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := axon.Dial[axon.RawText](ctx, url+"/000/abc/websocket", nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close(1000, "")
+	if frame, err := conn.Read(ctx); err != nil || frame != "o" {
+		t.Fatalf("Read() = %q, %v; want the open frame", frame, err)
+	}
+	conn.WriteMessage(ctx, axon.TextMessage, []byte(`["hi"]`))
+	if frame, err := conn.Read(ctx); err != nil || frame != `a["echo hi"]` {
+		t.Errorf("Read() = %q, %v", frame, err)
+	}
+
+	// Raw WebSockets skip the framing
+	raw, err := axon.Dial[axon.RawText](ctx, url+"/websocket", nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer raw.Close(1000, "")
+	raw.WriteMessage(ctx, axon.TextMessage, []byte("hi"))
+	if msg, err := raw.Read(ctx); err != nil || msg != "echo hi" {
+		t.Errorf("raw Read() = %q, %v", msg, err)
+	}
+}
+
+func TestServer_Hub(t *testing.T) {
+	hub := axon.NewHub[string](nil)
+	defer hub.Close()
+	url := newServer(t, &sockjs.Options[string]{
+		Hub:             hub,
+		HeartbeatDelay:  50 * time.Millisecond,
+		DisconnectDelay: 100 * time.Millisecond,
+	})
+	session := url + "/000/abc"
+
+	post(t, session+"/xhr", "")
+	if hub.Len() != 1 {
+		t.Fatalf("hub.Len() = %d, want the session registered", hub.Len())
+	}
+	hub.Broadcast(context.Background(), "news")
+	// Write encodes messages as JSON
+	if _, body := post(t, session+"/xhr", ""); body != `a["\"news\""]`+"\n" {
+		t.Errorf("poll after Broadcast = %q", body)
+	}
+
+	// Idle polls are answered with heartbeats
+	if _, body := post(t, session+"/xhr", ""); body != "h\n" {
+		t.Errorf("idle poll = %q, want a heartbeat", body)
+	}
+
+	// Sessions without a receiving request expire
+	deadline := time.Now().Add(5 * time.Second)
+	for hub.Len() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("session did not expire")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status, _ := post(t, session+"/xhr_send", `["hi"]`); status != http.StatusNotFound {
+		t.Errorf("xhr_send to an expired session status = %d", status)
+	}
+}
+
+// limiterFunc is a RateLimiter calling itself
+type limiterFunc func(r *http.Request) (bool, time.Duration)
+
+func (f limiterFunc) Allow(r *http.Request) (bool, time.Duration) { return f(r) }
+
+func TestServer_XHRAdmission(t *testing.T) {
+	hub := axon.NewHub[string](nil)
+	defer hub.Close()
+	url := newServer(t, &sockjs.Options[string]{
+		Hub: hub,
+		UpgradeOptions: &axon.UpgradeOptions{
+			Bans:                hub.Bans(),
+			MaxConnectionsPerIP: 1,
+		},
+	})
+
+	if _, body := post(t, url+"/000/abc/xhr", ""); body != "o\n" {
+		t.Fatalf("first poll = %q, want the open frame", body)
+	}
+	if status, _ := post(t, url+"/000/def/xhr", ""); status != http.StatusServiceUnavailable {
+		t.Errorf("second session from the same IP status = %d, want 503", status)
+	}
+
+	// Sessions carry the client's IP, so banning it ends them
+	if err := hub.Ban("127.0.0.1", 0); err != nil {
+		t.Fatalf("Ban() error = %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for hub.Len() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("banned session was not closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status, _ := post(t, url+"/000/ghi/xhr_streaming", ""); status != http.StatusForbidden {
+		t.Errorf("session from a banned IP status = %d, want 403", status)
+	}
+}
+
+func TestServer_XHRRateLimit(t *testing.T) {
+	allowed := 1
+	url := newServer(t, &sockjs.Options[string]{
+		UpgradeOptions: &axon.UpgradeOptions{
+			RateLimiter: limiterFunc(func(r *http.Request) (bool, time.Duration) {
+				if allowed == 0 {
+					return false, 2 * time.Second
+				}
+				allowed--
+				return true, 0
+			}),
+		},
+	})
+
+	if _, body := post(t, url+"/000/abc/xhr", ""); body != "o\n" {
+		t.Fatalf("first poll = %q, want the open frame", body)
+	}
+	// Requests of an open session are not rate limited
+	if _, body := post(t, url+"/000/abc/xhr_send", `["hi"]`); body != "" {
+		t.Errorf("xhr_send = %q", body)
+	}
+
+	resp, err := http.Post(url+"/000/def/xhr", "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "2" {
+		t.Errorf("rate limited session = %d, Retry-After %q; want 429, 2", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+}
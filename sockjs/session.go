@@ -0,0 +1,151 @@
+package sockjs
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+// session bridges a SockJS session to the connection its handler runs on.
+// Messages the handler writes are queued until a receiving request
+// collects them.
+type session struct {
+	conn  *axon.Conn[[]byte] // The bridge's end of the connection
+	wake  chan struct{}
+	timer *time.Timer // Expires the session while no request receives
+
+	mu        sync.Mutex
+	pending   []string
+	closing   string // The close frame, once the session ended
+	receiving bool
+}
+
+// newSession starts bridging conn. expire is called once the session went
+// without a receiving request for delay.
+func newSession(conn *axon.Conn[[]byte], delay time.Duration, expire func()) *session {
+	s := &session{
+		conn: conn,
+		wake: make(chan struct{}, 1),
+	}
+	s.timer = time.AfterFunc(delay, func() {
+		s.mu.Lock()
+		receiving := s.receiving
+		s.mu.Unlock()
+		if !receiving {
+			s.close()
+			expire()
+		}
+	})
+	go s.readLoop()
+	return s
+}
+
+// readLoop queues the messages the handler writes until it ends
+func (s *session) readLoop() {
+	for {
+		_, data, err := s.conn.ReadMessage(context.Background())
+		if err != nil {
+			// Idle connections hit the default read deadline
+			if isTimeout(err) {
+				continue
+			}
+			s.end(closeFrame(err))
+			return
+		}
+		s.mu.Lock()
+		s.pending = append(s.pending, string(data))
+		s.mu.Unlock()
+		s.signal()
+	}
+}
+
+// end records the close frame of the session. Only the first call has an
+// effect.
+func (s *session) end(frame string) {
+	s.mu.Lock()
+	if s.closing == "" {
+		s.closing = frame
+	}
+	s.mu.Unlock()
+	s.signal()
+}
+
+// signal wakes the receiving request
+func (s *session) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// close ends the session, closing the handler's connection
+func (s *session) close() {
+	s.timer.Stop()
+	s.end(closeFrame(nil))
+	s.conn.Close(int(axon.CloseGoingAway), "")
+}
+
+// attach claims the session for a receiving request, returning false if
+// another request holds it
+func (s *session) attach() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.receiving {
+		return false
+	}
+	s.receiving = true
+	s.timer.Stop()
+	return true
+}
+
+// detach releases the session once a receiving request ends
+func (s *session) detach(delay time.Duration) {
+	s.mu.Lock()
+	s.receiving = false
+	s.mu.Unlock()
+	s.timer.Reset(delay)
+}
+
+// next returns the next frame for the receiving request: the queued
+// messages, the close frame once the session ended, or a heartbeat once it
+// was idle for heartbeat. It returns false once ctx is done.
+func (s *session) next(ctx context.Context, heartbeat time.Duration) (string, bool) {
+	timer := time.NewTimer(heartbeat)
+	defer timer.Stop()
+
+	for {
+		s.mu.Lock()
+		if len(s.pending) > 0 {
+			frame := messageFrame(s.pending)
+			s.pending = nil
+			s.mu.Unlock()
+			return frame, true
+		}
+		if s.closing != "" {
+			frame := s.closing
+			s.mu.Unlock()
+			return frame, true
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-s.wake:
+		case <-timer.C:
+			return heartbeatFrame, true
+		case <-ctx.Done():
+			return "", false
+		}
+	}
+}
+
+// send passes messages from the client to the handler
+func (s *session) send(ctx context.Context, msgs []string) error {
+	for _, msg := range msgs {
+		if err := s.conn.WriteMessage(ctx, axon.TextMessage, []byte(msg)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
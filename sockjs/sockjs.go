@@ -0,0 +1,197 @@
+// Package sockjs serves the SockJS protocol, so browsers whose network
+// blocks WebSockets can still connect through the xhr-streaming and
+// xhr-polling transports. Every session, whatever its transport, reaches
+// the handler as an ordinary *axon.Conn, so one server implementation and
+// one Hub serve them all.
+//
+// SockJS carries text only: each message the client sends arrives as a
+// text message, and each message written to a session reaches the client
+// as a string holding its payload. Messages written with Write are thus
+// JSON encoded, as they would be for a WebSocket client.
+package sockjs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+// Defaults of the Options durations and limits
+const (
+	DefaultHeartbeatDelay  = 25 * time.Second
+	DefaultDisconnectDelay = 5 * time.Second
+	DefaultResponseLimit   = 128 * 1024
+)
+
+// Close codes sent to clients in close frames
+const (
+	// CloseGoAway ends sessions closed by the server without a code of
+	// their own
+	CloseGoAway = 3000
+
+	// CloseAnotherConnection answers a receiving request for a session
+	// that already has one open
+	CloseAnotherConnection = 2010
+)
+
+// Options configures a Server
+type Options[T any] struct {
+	// Hub, if set, has every session registered with it for as long as
+	// the session is open, whichever transport it uses
+	Hub *axon.Hub[T]
+
+	// HeartbeatDelay is how long a receiving request may stay idle before
+	// a heartbeat frame is sent.
+	// Default is 25s.
+	HeartbeatDelay time.Duration
+
+	// DisconnectDelay is how long a session without a receiving request
+	// is kept before it is closed.
+	// Default is 5s.
+	DisconnectDelay time.Duration
+
+	// ResponseLimit is the number of bytes an xhr-streaming response
+	// carries before it ends, so that the client opens a new one and
+	// intermediaries do not buffer it forever.
+	// Default is 128KB.
+	ResponseLimit int
+
+	// UpgradeOptions configures the WebSocket transports and the limits
+	// of every session. Its CheckOrigin also applies to the other
+	// transports.
+	UpgradeOptions *axon.UpgradeOptions
+}
+
+// openFrame, heartbeatFrame and the close frames are the control frames
+// of the protocol
+const (
+	openFrame      = "o"
+	heartbeatFrame = "h"
+)
+
+// closeFrame returns the close frame reporting err, the error that ended
+// a session
+func closeFrame(err error) string {
+	code, reason := CloseGoAway, "Go away!"
+	var closeErr *axon.CloseError
+	if errors.As(err, &closeErr) && closeErr.Code >= 3000 && closeErr.Code <= 4999 {
+		code, reason = int(closeErr.Code), closeErr.Reason
+	}
+	return closeFrameOf(code, reason)
+}
+
+// closeFrameOf returns the close frame with code and reason
+func closeFrameOf(code int, reason string) string {
+	data, _ := json.Marshal([]any{code, reason})
+	return "c" + string(data)
+}
+
+// messageFrame returns the frame carrying msgs
+func messageFrame(msgs []string) string {
+	data, _ := json.Marshal(msgs)
+	return "a" + string(data)
+}
+
+// parseMessages decodes the messages a client sent, which is a JSON array
+// of strings or a single string
+func parseMessages(data []byte) ([]string, error) {
+	var msgs []string
+	if err := json.Unmarshal(data, &msgs); err == nil {
+		return msgs, nil
+	}
+	var msg string
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+	return []string{msg}, nil
+}
+
+// pipeListener hands the server's end of in-memory connections to
+// axon.Serve, which runs the handler for each session
+type pipeListener struct {
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+// Accept returns the next connection dialed
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops the listener
+func (l *pipeListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+// Addr returns the listener's address
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+// dial returns the client's end of a new connection, whose server end
+// reports remote as its peer
+func (l *pipeListener) dial(ctx context.Context, remote net.Addr) (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case l.conns <- remoteConn{Conn: server, remote: remote}:
+		return client, nil
+	case <-l.done:
+	case <-ctx.Done():
+	}
+	client.Close()
+	server.Close()
+	return nil, net.ErrClosed
+}
+
+// remoteConn is the server's end of a pipe, reporting the address of the
+// client the session belongs to
+type remoteConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c remoteConn) RemoteAddr() net.Addr { return c.remote }
+
+// clientAddr returns the remote address of r, or the pipe's address if it
+// is not an IP address and port
+func clientAddr(r *http.Request) net.Addr {
+	ap, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return pipeAddr{}
+	}
+	return net.TCPAddrFromAddrPort(ap)
+}
+
+// pipeAddr is the address of in-memory connections
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+// isTimeout reports whether err is a read timeout, which idle connections
+// hit without being closed
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -1,6 +1,8 @@
 package axon
 
 import (
+	"slices"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -70,14 +72,43 @@ type StateHandler func(StateChange)
 type stateManager struct {
 	state     atomic.Int32
 	sessionID atomic.Value // string
-	handlers  []StateHandler
+
+	handlersMu  sync.RWMutex
+	handlers    []registeredHandler
+	nextHandler uint64
+
+	// Asynchronous dispatch
+	async       bool
+	pendingMu   sync.Mutex
+	pending     []StateChange
+	dispatching bool
+
+	// Recent transitions, for debugging
+	historyMu   sync.Mutex
+	history     [stateHistorySize]StateChange
+	historyNext int
+
+	mu      sync.Mutex
+	changed chan struct{} // Closed on the next transition
+	readyCh chan struct{} // Closed while connected
 }
 
-// newStateManager creates a new state manager
-func newStateManager() *stateManager {
-	sm := &stateManager{}
+// stateHistorySize is the number of transitions kept by a stateManager
+const stateHistorySize = 32
+
+// registeredHandler is a StateHandler with the ID used to remove it
+type registeredHandler struct {
+	id      uint64
+	handler StateHandler
+}
+
+// newStateManager creates a new state manager, dispatching state changes
+// on a separate goroutine if async
+func newStateManager(async bool) *stateManager {
+	sm := &stateManager{async: async}
 	sm.state.Store(int32(StateDisconnected))
 	sm.sessionID.Store("")
+	sm.readyCh = make(chan struct{})
 	return sm
 }
 
@@ -103,9 +134,43 @@ func (sm *stateManager) SessionID() string {
 // transition attempts to transition from one state to another
 // Returns true if the transition was successful
 func (sm *stateManager) transition(from, to ConnectionState, err error, attempt int) bool {
-	if !sm.state.CompareAndSwap(int32(from), int32(to)) {
+	if !isValidTransition(from, to) || !sm.state.CompareAndSwap(int32(from), int32(to)) {
 		return false
 	}
+	sm.record(from, to, err, attempt)
+	return true
+}
+
+// advance transitions from the current state to to, returning
+// ErrInvalidState if the table does not allow it
+func (sm *stateManager) advance(to ConnectionState, err error, attempt int) error {
+	for {
+		from := sm.State()
+		if from == to {
+			return nil
+		}
+		if !isValidTransition(from, to) {
+			return ErrInvalidState
+		}
+		if sm.transition(from, to, err, attempt) {
+			return nil
+		}
+	}
+}
+
+// forceTransition transitions to a new state regardless of current state.
+// It bypasses the transition table and is reserved for shutdown.
+func (sm *stateManager) forceTransition(to ConnectionState, err error, attempt int) ConnectionState {
+	from := ConnectionState(sm.state.Swap(int32(to)))
+	if from != to {
+		sm.record(from, to, err, attempt)
+	}
+	return from
+}
+
+// record adds a transition to the history and notifies waiters and handlers
+func (sm *stateManager) record(from, to ConnectionState, err error, attempt int) {
+	sm.notify()
 
 	change := StateChange{
 		From:      from,
@@ -116,45 +181,138 @@ func (sm *stateManager) transition(from, to ConnectionState, err error, attempt
 		SessionID: sm.SessionID(),
 	}
 
-	// Notify handlers
-	for _, h := range sm.handlers {
-		if h != nil {
-			h(change)
+	sm.historyMu.Lock()
+	sm.history[sm.historyNext%stateHistorySize] = change
+	sm.historyNext++
+	sm.historyMu.Unlock()
+
+	sm.dispatch(change)
+}
+
+// History returns the most recent transitions, oldest first
+func (sm *stateManager) History() []StateChange {
+	sm.historyMu.Lock()
+	defer sm.historyMu.Unlock()
+
+	n := min(sm.historyNext, stateHistorySize)
+	history := make([]StateChange, 0, n)
+	for i := sm.historyNext - n; i < sm.historyNext; i++ {
+		history = append(history, sm.history[i%stateHistorySize])
+	}
+	return history
+}
+
+// notify wakes state waiters and updates the ready channel
+func (sm *stateManager) notify() {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.changed != nil {
+		close(sm.changed)
+		sm.changed = nil
+	}
+
+	select {
+	case <-sm.readyCh:
+		if sm.State() != StateConnected {
+			sm.readyCh = make(chan struct{})
+		}
+	default:
+		if sm.State() == StateConnected {
+			close(sm.readyCh)
 		}
 	}
+}
 
-	return true
+// watch returns the current state and a channel closed on the next
+// transition
+func (sm *stateManager) watch() (ConnectionState, <-chan struct{}) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if sm.changed == nil {
+		sm.changed = make(chan struct{})
+	}
+	return sm.State(), sm.changed
 }
 
-// forceTransition transitions to a new state regardless of current state
-func (sm *stateManager) forceTransition(to ConnectionState, err error, attempt int) ConnectionState {
-	from := ConnectionState(sm.state.Swap(int32(to)))
+// ready returns a channel that is closed while connected
+func (sm *stateManager) ready() <-chan struct{} {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return sm.readyCh
+}
 
-	if from != to {
-		change := StateChange{
-			From:      from,
-			To:        to,
-			Time:      time.Now(),
-			Err:       err,
-			Attempt:   attempt,
-			SessionID: sm.SessionID(),
-		}
+// OnStateChange registers a callback for state change events and returns
+// a function that removes it
+func (sm *stateManager) OnStateChange(handler StateHandler) func() {
+	if handler == nil {
+		return func() {}
+	}
 
-		// Notify handlers
-		for _, h := range sm.handlers {
-			if h != nil {
-				h(change)
-			}
-		}
+	sm.handlersMu.Lock()
+	sm.nextHandler++
+	id := sm.nextHandler
+	sm.handlers = append(sm.handlers, registeredHandler{id: id, handler: handler})
+	sm.handlersMu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			sm.handlersMu.Lock()
+			defer sm.handlersMu.Unlock()
+			sm.handlers = slices.DeleteFunc(sm.handlers, func(h registeredHandler) bool {
+				return h.id == id
+			})
+		})
 	}
+}
 
-	return from
+// dispatch notifies handlers of change, synchronously or in order on a
+// separate goroutine when async
+func (sm *stateManager) dispatch(change StateChange) {
+	if !sm.async {
+		sm.notifyHandlers(change)
+		return
+	}
+
+	sm.pendingMu.Lock()
+	sm.pending = append(sm.pending, change)
+	if sm.dispatching {
+		sm.pendingMu.Unlock()
+		return
+	}
+	sm.dispatching = true
+	sm.pendingMu.Unlock()
+
+	go func() {
+		for {
+			sm.pendingMu.Lock()
+			if len(sm.pending) == 0 {
+				sm.dispatching = false
+				sm.pendingMu.Unlock()
+				return
+			}
+			change := sm.pending[0]
+			sm.pending[0] = StateChange{}
+			sm.pending = sm.pending[1:]
+			sm.pendingMu.Unlock()
+
+			sm.notifyHandlers(change)
+		}
+	}()
 }
 
-// OnStateChange registers a callback for state change events
-func (sm *stateManager) OnStateChange(handler StateHandler) {
-	if handler != nil {
-		sm.handlers = append(sm.handlers, handler)
+// notifyHandlers calls the handlers registered when change is delivered
+func (sm *stateManager) notifyHandlers(change StateChange) {
+	sm.handlersMu.RLock()
+	handlers := make([]StateHandler, len(sm.handlers))
+	for i, h := range sm.handlers {
+		handlers[i] = h.handler
+	}
+	sm.handlersMu.RUnlock()
+
+	for _, h := range handlers {
+		h(change)
 	}
 }
 
@@ -1,7 +1,10 @@
 package axon_test
 
 import (
+	"context"
+	"errors"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -128,3 +131,144 @@ func TestStateChange_ConcurrentAccess(t *testing.T) {
 		t.Errorf("received %d changes, want %d", received, count)
 	}
 }
+
+// failingClient returns a client whose Connect fails immediately, moving
+// through connecting and back to disconnected
+func failingClient(t *testing.T, opts *axon.ClientOptions) *axon.Client[string] {
+	t.Helper()
+	if opts == nil {
+		opts = axon.DefaultClientOptions()
+	}
+	opts.Reconnect.Enabled = false
+	client := axon.NewClient[string]("ws://127.0.0.1:1/", opts)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestClient_OnStateChangeRemove(t *testing.T) {
+	client := failingClient(t, nil)
+
+	var kept, removed atomic.Int32
+	client.OnStateChange(func(axon.StateChange) { kept.Add(1) })
+	remove := client.OnStateChange(func(axon.StateChange) { removed.Add(1) })
+
+	client.Connect(context.Background())
+	if kept.Load() != 2 || removed.Load() != 2 {
+		t.Fatalf("handlers saw %d and %d changes, want 2 each", kept.Load(), removed.Load())
+	}
+
+	remove()
+	remove() // Removing twice is harmless
+	client.Connect(context.Background())
+	if kept.Load() != 4 {
+		t.Errorf("kept handler saw %d changes, want 4", kept.Load())
+	}
+	if removed.Load() != 2 {
+		t.Errorf("removed handler saw %d changes, want 2", removed.Load())
+	}
+}
+
+func TestClient_OnStateChangeConcurrentRegistration(t *testing.T) {
+	client := failingClient(t, nil)
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				remove := client.OnStateChange(func(axon.StateChange) {})
+				remove()
+			}
+		}()
+	}
+	for range 20 {
+		client.Connect(context.Background())
+	}
+	wg.Wait()
+}
+
+func TestClient_AsyncStateHandlers(t *testing.T) {
+	opts := axon.DefaultClientOptions()
+	opts.AsyncStateHandlers = true
+	client := failingClient(t, opts)
+
+	release := make(chan struct{})
+	changes := make(chan axon.StateChange, 4)
+	client.OnStateChange(func(change axon.StateChange) {
+		<-release
+		changes <- change
+	})
+
+	// Blocked handlers do not hold up the transitions
+	done := make(chan error, 1)
+	go func() { done <- client.Connect(context.Background()) }()
+	if err := receive(t, done); err == nil {
+		t.Fatal("Connect() should fail")
+	}
+	if state := client.State(); state != axon.StateDisconnected {
+		t.Errorf("State() = %v, want disconnected", state)
+	}
+
+	close(release)
+	if c := receive(t, changes); c.From != axon.StateDisconnected || c.To != axon.StateConnecting {
+		t.Errorf("first change = %v -> %v, want disconnected -> connecting", c.From, c.To)
+	}
+	if c := receive(t, changes); c.From != axon.StateConnecting || c.To != axon.StateDisconnected {
+		t.Errorf("second change = %v -> %v, want connecting -> disconnected", c.From, c.To)
+	}
+}
+
+func TestClient_StateHistory(t *testing.T) {
+	client := failingClient(t, nil)
+
+	if history := client.StateHistory(); len(history) != 0 {
+		t.Errorf("StateHistory() before any transition = %v, want empty", history)
+	}
+
+	client.Connect(context.Background())
+	history := client.StateHistory()
+	want := []axon.ConnectionState{axon.StateConnecting, axon.StateDisconnected}
+	if len(history) != len(want) {
+		t.Fatalf("StateHistory() has %d entries, want %d", len(history), len(want))
+	}
+	for i, change := range history {
+		if change.To != want[i] {
+			t.Errorf("history[%d].To = %v, want %v", i, change.To, want[i])
+		}
+	}
+	if history[1].Err == nil {
+		t.Error("failed connection should record its error")
+	}
+
+	// The history keeps only the most recent transitions
+	for range 20 {
+		client.Connect(context.Background())
+	}
+	client.Close()
+	history = client.StateHistory()
+	if len(history) != 32 {
+		t.Fatalf("StateHistory() has %d entries, want 32", len(history))
+	}
+	last := history[len(history)-1]
+	if last.From != axon.StateClosing || last.To != axon.StateClosed {
+		t.Errorf("last transition = %v -> %v, want closing -> closed", last.From, last.To)
+	}
+	for i := 1; i < len(history); i++ {
+		if history[i].From != history[i-1].To {
+			t.Errorf("history[%d] starts from %v, previous ended in %v", i, history[i].From, history[i-1].To)
+		}
+	}
+}
+
+func TestClient_ConnectAfterClose(t *testing.T) {
+	client := failingClient(t, nil)
+	client.Close()
+
+	if err := client.Connect(context.Background()); !errors.Is(err, axon.ErrInvalidState) {
+		t.Errorf("Connect() after Close error = %v, want ErrInvalidState", err)
+	}
+	if state := client.State(); state != axon.StateClosed {
+		t.Errorf("State() = %v, want closed", state)
+	}
+}
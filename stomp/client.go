@@ -0,0 +1,529 @@
+package stomp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+var (
+	// ErrHeartbeatTimeout is returned when the broker stays silent for
+	// longer than the negotiated heart-beat allows
+	ErrHeartbeatTimeout = errors.New("stomp: heart-beat timeout")
+
+	// ErrNotConnected is returned by NewClient when the broker answers
+	// CONNECT with anything but CONNECTED or ERROR
+	ErrNotConnected = errors.New("stomp: connection not accepted")
+)
+
+// AckMode is how the messages of a subscription are acknowledged
+type AckMode string
+
+// Acknowledgement modes
+const (
+	AckAuto             AckMode = "auto"
+	AckClient           AckMode = "client"
+	AckClientIndividual AckMode = "client-individual"
+)
+
+// ServerError is an ERROR frame sent by the broker, after which it closes
+// the connection
+type ServerError struct {
+	Message string
+	Frame   *Frame
+}
+
+// Error returns the error message
+func (e *ServerError) Error() string {
+	if len(e.Frame.Body) == 0 {
+		return "stomp: " + e.Message
+	}
+	return fmt.Sprintf("stomp: %s: %s", e.Message, e.Frame.Body)
+}
+
+// Options configures the CONNECT frame
+type Options struct {
+	// Host is the virtual host to connect to. Default is the host of the
+	// connection's URL for Dial, and "/" otherwise.
+	Host string
+
+	// Login and Passcode authenticate with the broker
+	Login    string
+	Passcode string
+
+	// HeartBeat is the interval at which the client offers to send and
+	// asks to receive heart-beats. The client sends end-of-line frames at
+	// the negotiated interval and pings the broker at its receiving
+	// interval; when neither a frame, heart-beat nor pong arrives within
+	// twice that interval, the connection fails with ErrHeartbeatTimeout.
+	// Default is 0 (no heart-beating).
+	HeartBeat time.Duration
+
+	// Headers are added to the CONNECT frame
+	Headers []Header
+}
+
+// Client is a STOMP client. It is safe for concurrent use.
+type Client struct {
+	conn      *axon.Conn[[]byte]
+	connected *Frame
+
+	mu       sync.Mutex
+	nextID   uint64
+	subs     map[string]*Subscription
+	receipts map[string]chan struct{}
+	err      error
+	done     chan struct{}
+
+	lastRead atomic.Int64 // Unix nanoseconds of the last activity
+}
+
+// Dial connects to a STOMP broker at urlStr. dialOpts and opts may be nil;
+// the dial options' subprotocols are replaced by Subprotocol.
+func Dial(ctx context.Context, urlStr string, dialOpts *axon.DialOptions, opts *Options) (*Client, error) {
+	var o axon.DialOptions
+	if dialOpts != nil {
+		o = *dialOpts
+	}
+	o.Subprotocols = []string{Subprotocol}
+
+	var connect Options
+	if opts != nil {
+		connect = *opts
+	}
+	if connect.Host == "" {
+		if u, err := url.Parse(urlStr); err == nil {
+			connect.Host = u.Hostname()
+		}
+	}
+
+	conn, err := axon.Dial[[]byte](ctx, urlStr, &o)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewClient(ctx, conn, &connect)
+	if err != nil {
+		conn.Close(int(axon.CloseNormalClosure), "")
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewClient sends CONNECT over conn and waits for CONNECTED. The client
+// then owns reading from the connection. opts may be nil.
+func NewClient(ctx context.Context, conn *axon.Conn[[]byte], opts *Options) (*Client, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	host := opts.Host
+	if host == "" {
+		host = "/"
+	}
+
+	connect := &Frame{Command: CommandConnect}
+	connect.AddHeader("accept-version", "1.2")
+	connect.AddHeader("host", host)
+	if opts.Login != "" {
+		connect.AddHeader("login", opts.Login)
+		connect.AddHeader("passcode", opts.Passcode)
+	}
+	beat := strconv.FormatInt(opts.HeartBeat.Milliseconds(), 10)
+	connect.AddHeader("heart-beat", beat+","+beat)
+	connect.Headers = append(connect.Headers, opts.Headers...)
+
+	c := &Client{
+		conn:     conn,
+		subs:     make(map[string]*Subscription),
+		receipts: make(map[string]chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if err := c.write(ctx, connect); err != nil {
+		return nil, err
+	}
+
+	var frame *Frame
+	for frame == nil {
+		_, data, err := conn.ReadMessage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if isHeartbeat(data) {
+			continue
+		}
+		if frame, err = ParseFrame(data); err != nil {
+			return nil, err
+		}
+	}
+	switch frame.Command {
+	case CommandConnected:
+		c.connected = frame
+	case CommandError:
+		return nil, &ServerError{Message: frame.Get("message"), Frame: frame}
+	default:
+		return nil, ErrNotConnected
+	}
+
+	c.lastRead.Store(time.Now().UnixNano())
+	conn.SetPongHandler(func([]byte) error {
+		c.lastRead.Store(time.Now().UnixNano())
+		return nil
+	})
+	send, receive := negotiateHeartBeat(opts.HeartBeat, c.connected.Get("heart-beat"))
+	go c.readLoop()
+	if send > 0 || receive > 0 {
+		go c.heartbeat(send, receive)
+	}
+	return c, nil
+}
+
+// negotiateHeartBeat returns the intervals at which the client sends and
+// expects heart-beats, given the broker's heart-beat header
+func negotiateHeartBeat(beat time.Duration, header string) (send, receive time.Duration) {
+	sx, sy, ok := strings.Cut(header, ",")
+	if beat <= 0 || !ok {
+		return 0, 0
+	}
+	canSend, err1 := strconv.ParseInt(strings.TrimSpace(sx), 10, 64)
+	wantReceive, err2 := strconv.ParseInt(strings.TrimSpace(sy), 10, 64)
+	if err1 != nil || err2 != nil {
+		return 0, 0
+	}
+	if wantReceive > 0 {
+		send = max(beat, time.Duration(wantReceive)*time.Millisecond)
+	}
+	if canSend > 0 {
+		receive = max(beat, time.Duration(canSend)*time.Millisecond)
+	}
+	return send, receive
+}
+
+// Connected returns the broker's CONNECTED frame
+func (c *Client) Connected() *Frame {
+	return c.connected
+}
+
+// Done returns a channel that is closed when the connection ends
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the error that ended the connection, or nil while it is open
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Send sends body to destination. contentType may be empty.
+func (c *Client) Send(ctx context.Context, destination, contentType string, body []byte, headers ...Header) error {
+	frame := &Frame{Command: CommandSend, Body: body}
+	frame.AddHeader("destination", destination)
+	if contentType != "" {
+		frame.AddHeader("content-type", contentType)
+	}
+	frame.Headers = append(frame.Headers, headers...)
+	return c.write(ctx, frame)
+}
+
+// Subscription receives the messages sent to a destination
+type Subscription struct {
+	id       string
+	client   *Client
+	messages chan *Frame
+
+	once sync.Once
+	mu   sync.Mutex // Held while delivering, so messages closes after
+	err  error
+	done chan struct{}
+}
+
+// Subscribe subscribes to destination. Messages must be received from the
+// subscription promptly, as the client reads no further frames while a
+// message waits to be delivered.
+func (c *Client) Subscribe(ctx context.Context, destination string, ack AckMode, headers ...Header) (*Subscription, error) {
+	if ack == "" {
+		ack = AckAuto
+	}
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	c.nextID++
+	sub := &Subscription{
+		id:       strconv.FormatUint(c.nextID, 10),
+		client:   c,
+		messages: make(chan *Frame, 64),
+		done:     make(chan struct{}),
+	}
+	c.subs[sub.id] = sub
+	c.mu.Unlock()
+
+	frame := &Frame{Command: CommandSubscribe}
+	frame.AddHeader("id", sub.id)
+	frame.AddHeader("destination", destination)
+	frame.AddHeader("ack", string(ack))
+	frame.Headers = append(frame.Headers, headers...)
+	if err := c.write(ctx, frame); err != nil {
+		c.remove(sub.id)
+		return nil, err
+	}
+	return sub, nil
+}
+
+// ID returns the subscription ID
+func (s *Subscription) ID() string {
+	return s.id
+}
+
+// Messages returns the channel of MESSAGE frames, closed when the
+// subscription ends
+func (s *Subscription) Messages() <-chan *Frame {
+	return s.messages
+}
+
+// Err returns why the subscription ended once Messages is closed: nil
+// after Unsubscribe, or the connection's error
+func (s *Subscription) Err() error {
+	<-s.done
+	return s.err
+}
+
+// Unsubscribe ends the subscription
+func (s *Subscription) Unsubscribe(ctx context.Context) error {
+	if !s.client.remove(s.id) {
+		return nil
+	}
+	s.end(nil)
+	frame := &Frame{Command: CommandUnsubscribe}
+	frame.AddHeader("id", s.id)
+	return s.client.write(ctx, frame)
+}
+
+// deliver waits for msg to be received, unless the subscription ends
+func (s *Subscription) deliver(msg *Frame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	select {
+	case s.messages <- msg:
+	case <-s.done:
+	}
+}
+
+// end ends the subscription with err
+func (s *Subscription) end(err error) {
+	s.once.Do(func() {
+		s.err = err
+		close(s.done)
+		s.mu.Lock()
+		close(s.messages)
+		s.mu.Unlock()
+	})
+}
+
+// Ack acknowledges a message of a subscription in a client ack mode
+func (c *Client) Ack(ctx context.Context, msg *Frame) error {
+	return c.acknowledge(ctx, CommandAck, msg)
+}
+
+// Nack rejects a message of a subscription in a client ack mode
+func (c *Client) Nack(ctx context.Context, msg *Frame) error {
+	return c.acknowledge(ctx, CommandNack, msg)
+}
+
+// acknowledge sends an ACK or NACK for msg
+func (c *Client) acknowledge(ctx context.Context, command string, msg *Frame) error {
+	id, ok := msg.Header("ack")
+	if !ok {
+		return fmt.Errorf("%w: message has no ack header", ErrInvalidFrame)
+	}
+	frame := &Frame{Command: command}
+	frame.AddHeader("id", id)
+	return c.write(ctx, frame)
+}
+
+// Disconnect sends DISCONNECT, waits for the broker to confirm it has
+// processed every earlier frame, and closes the connection
+func (c *Client) Disconnect(ctx context.Context) error {
+	receipt := make(chan struct{})
+	c.mu.Lock()
+	c.nextID++
+	id := "disconnect-" + strconv.FormatUint(c.nextID, 10)
+	c.receipts[id] = receipt
+	c.mu.Unlock()
+
+	frame := &Frame{Command: CommandDisconnect}
+	frame.AddHeader("receipt", id)
+	err := c.write(ctx, frame)
+	if err == nil {
+		select {
+		case <-receipt:
+		case <-c.done:
+			err = c.Err()
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	c.Close()
+	return err
+}
+
+// Close closes the connection without DISCONNECT, ending all subscriptions
+func (c *Client) Close() error {
+	return c.conn.Close(int(axon.CloseNormalClosure), "")
+}
+
+// write sends a frame
+func (c *Client) write(ctx context.Context, frame *Frame) error {
+	data, err := frame.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return c.conn.WriteMessage(ctx, axon.TextMessage, data)
+}
+
+// remove forgets a subscription, reporting whether it was active
+func (c *Client) remove(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.subs[id]; !ok {
+		return false
+	}
+	delete(c.subs, id)
+	return true
+}
+
+// readLoop dispatches frames until the connection ends
+func (c *Client) readLoop() {
+	for {
+		_, data, err := c.conn.ReadMessage(context.Background())
+		if err != nil {
+			// Idle connections hit the default read deadline
+			if isTimeout(err) {
+				continue
+			}
+			c.stop(err)
+			return
+		}
+		c.lastRead.Store(time.Now().UnixNano())
+		if isHeartbeat(data) {
+			continue
+		}
+		frame, err := ParseFrame(data)
+		if err != nil {
+			c.stop(err)
+			c.conn.Close(int(axon.CloseProtocolError), "")
+			return
+		}
+		if err := c.dispatch(frame); err != nil {
+			c.stop(err)
+			c.conn.Close(int(axon.CloseNormalClosure), "")
+			return
+		}
+	}
+}
+
+// dispatch handles a frame from the broker
+func (c *Client) dispatch(frame *Frame) error {
+	switch frame.Command {
+	case CommandMessage:
+		c.mu.Lock()
+		sub := c.subs[frame.Get("subscription")]
+		c.mu.Unlock()
+		if sub != nil {
+			sub.deliver(frame)
+		}
+	case CommandReceipt:
+		id := frame.Get("receipt-id")
+		c.mu.Lock()
+		receipt := c.receipts[id]
+		delete(c.receipts, id)
+		c.mu.Unlock()
+		if receipt != nil {
+			close(receipt)
+		}
+	case CommandError:
+		return &ServerError{Message: frame.Get("message"), Frame: frame}
+	}
+	return nil
+}
+
+// heartbeat sends heart-beats every send interval and checks the broker
+// is alive every receive interval
+func (c *Client) heartbeat(send, receive time.Duration) {
+	var sendC, receiveC <-chan time.Time
+	if send > 0 {
+		t := time.NewTicker(send)
+		defer t.Stop()
+		sendC = t.C
+	}
+	if receive > 0 {
+		t := time.NewTicker(receive)
+		defer t.Stop()
+		receiveC = t.C
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-c.done
+		cancel()
+	}()
+
+	for {
+		select {
+		case <-sendC:
+			c.conn.WriteMessage(ctx, axon.TextMessage, []byte{'\n'})
+		case <-receiveC:
+			if time.Since(time.Unix(0, c.lastRead.Load())) > 2*receive {
+				c.stop(ErrHeartbeatTimeout)
+				c.conn.Close(int(axon.ClosePolicyViolation), "heart-beat timeout")
+				return
+			}
+			c.conn.Ping(ctx, nil)
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// stop ends the client and all subscriptions with err. Only the first
+// call has an effect.
+func (c *Client) stop(err error) {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return
+	}
+	c.err = err
+	subs := c.subs
+	c.subs = make(map[string]*Subscription)
+	c.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.end(err)
+	}
+	close(c.done)
+}
+
+// isTimeout reports whether err is a read that hit its deadline
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
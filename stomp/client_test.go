@@ -0,0 +1,219 @@
+package stomp_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+	"github.com/kolosys/axon/stomp"
+)
+
+// broker is a minimal STOMP broker. SEND frames are delivered to
+// subscribers of the destination; every frame received is recorded.
+type broker struct {
+	t         *testing.T
+	heartBeat string
+	reject    bool
+	silent    bool // Ignores pings
+
+	mu       sync.Mutex
+	received []*stomp.Frame
+	beats    int
+}
+
+func (b *broker) serve() string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[[]byte](w, r, &axon.UpgradeOptions{Subprotocols: []string{stomp.Subprotocol}})
+		if err != nil {
+			return
+		}
+		defer conn.Close(1000, "")
+		ctx := r.Context()
+		if b.silent {
+			conn.SetPingHandler(func([]byte) error { return nil })
+		}
+
+		subs := make(map[string]string) // destination to subscription ID
+		acks := 0
+		for {
+			_, data, err := conn.ReadMessage(ctx)
+			if err != nil {
+				return
+			}
+			if strings.Trim(string(data), "\r\n") == "" {
+				b.mu.Lock()
+				b.beats++
+				b.mu.Unlock()
+				continue
+			}
+			frame, err := stomp.ParseFrame(data)
+			if err != nil {
+				b.t.Errorf("broker received an invalid frame %q", data)
+				return
+			}
+			b.mu.Lock()
+			b.received = append(b.received, frame)
+			b.mu.Unlock()
+
+			reply := &stomp.Frame{}
+			switch frame.Command {
+			case stomp.CommandConnect:
+				if b.reject {
+					reply.Command = stomp.CommandError
+					reply.AddHeader("message", "bad credentials")
+					break
+				}
+				reply.Command = stomp.CommandConnected
+				reply.AddHeader("version", "1.2")
+				reply.AddHeader("heart-beat", b.heartBeat)
+			case stomp.CommandSubscribe:
+				subs[frame.Get("destination")] = frame.Get("id")
+				continue
+			case stomp.CommandSend:
+				id, ok := subs[frame.Get("destination")]
+				if !ok {
+					continue
+				}
+				acks++
+				reply.Command = stomp.CommandMessage
+				reply.AddHeader("subscription", id)
+				reply.AddHeader("message-id", "m"+string(rune('0'+acks)))
+				reply.AddHeader("ack", "a"+string(rune('0'+acks)))
+				reply.Body = frame.Body
+			case stomp.CommandDisconnect:
+				reply.Command = stomp.CommandReceipt
+				reply.AddHeader("receipt-id", frame.Get("receipt"))
+			default:
+				continue
+			}
+			out, _ := reply.MarshalBinary()
+			conn.WriteMessage(ctx, axon.TextMessage, out)
+		}
+	}))
+	b.t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// frames returns the commands of the frames received, with the given
+// header of each
+func (b *broker) frames(header string) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var out []string
+	for _, f := range b.received {
+		out = append(out, f.Command+" "+f.Get(header))
+	}
+	return out
+}
+
+func TestClient_SendSubscribe(t *testing.T) {
+	b := &broker{t: t, heartBeat: "0,0"}
+	url := b.serve()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := stomp.Dial(ctx, url, nil, &stomp.Options{Login: "guest", Passcode: "guest"})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+
+	if got := client.Connected().Get("version"); got != "1.2" {
+		t.Errorf("CONNECTED version = %q", got)
+	}
+
+	sub, err := client.Subscribe(ctx, "/queue/orders", stomp.AckClientIndividual)
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	if err := client.Send(ctx, "/queue/orders", "text/plain", []byte("order 1")); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	var msg *stomp.Frame
+	select {
+	case msg = <-sub.Messages():
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for the message")
+	}
+	if string(msg.Body) != "order 1" || msg.Get("subscription") != sub.ID() {
+		t.Errorf("message = %+v", msg)
+	}
+	if err := client.Ack(ctx, msg); err != nil {
+		t.Fatalf("Ack() error = %v", err)
+	}
+	if err := client.Nack(ctx, &stomp.Frame{Command: stomp.CommandMessage}); !errors.Is(err, stomp.ErrInvalidFrame) {
+		t.Errorf("Nack() of a message without ack header error = %v", err)
+	}
+	if err := sub.Unsubscribe(ctx); err != nil {
+		t.Fatalf("Unsubscribe() error = %v", err)
+	}
+	if _, ok := <-sub.Messages(); ok || sub.Err() != nil {
+		t.Errorf("subscription after Unsubscribe: open = %v, Err() = %v", ok, sub.Err())
+	}
+	if err := client.Disconnect(ctx); err != nil {
+		t.Fatalf("Disconnect() error = %v", err)
+	}
+
+	want := []string{"CONNECT guest", "SUBSCRIBE ", "SEND ", "ACK ", "UNSUBSCRIBE ", "DISCONNECT "}
+	got := b.frames("login")
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("broker received %q, want %q", got, want)
+	}
+	if got := b.frames("host")[0]; got != "CONNECT 127.0.0.1" {
+		t.Errorf("CONNECT host = %q", got)
+	}
+}
+
+func TestClient_ConnectError(t *testing.T) {
+	b := &broker{t: t, reject: true}
+	url := b.serve()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := stomp.Dial(ctx, url, nil, nil)
+	var serverErr *stomp.ServerError
+	if !errors.As(err, &serverErr) || serverErr.Message != "bad credentials" {
+		t.Fatalf("Dial() error = %v, want the broker's ERROR frame", err)
+	}
+}
+
+func TestClient_HeartBeat(t *testing.T) {
+	// The broker asks for heart-beats but never sends any, and does not
+	// answer pings, so the client times out
+	b := &broker{t: t, heartBeat: "20,20", silent: true}
+	url := b.serve()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := axon.Dial[[]byte](ctx, url, nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	client, err := stomp.NewClient(ctx, conn, &stomp.Options{HeartBeat: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	if got := client.Connected().Get("heart-beat"); got != "20,20" {
+		t.Fatalf("CONNECTED heart-beat = %q", got)
+	}
+	select {
+	case <-client.Done():
+	case <-ctx.Done():
+		t.Fatal("client did not time out")
+	}
+	if !errors.Is(client.Err(), stomp.ErrHeartbeatTimeout) {
+		t.Errorf("Err() = %v, want ErrHeartbeatTimeout", client.Err())
+	}
+	b.mu.Lock()
+	beats := b.beats
+	b.mu.Unlock()
+	if beats == 0 {
+		t.Error("broker received no heart-beats")
+	}
+}
@@ -0,0 +1,216 @@
+// Package stomp implements STOMP 1.2 over axon connections: a frame codec
+// and a client for brokers that accept STOMP over WebSocket, such as
+// ActiveMQ and RabbitMQ Web-STOMP. Each frame travels in its own text
+// message.
+package stomp
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Subprotocol is the WebSocket subprotocol for STOMP 1.2
+const Subprotocol = "v12.stomp"
+
+// Frame commands
+const (
+	CommandConnect     = "CONNECT"
+	CommandStomp       = "STOMP"
+	CommandConnected   = "CONNECTED"
+	CommandSend        = "SEND"
+	CommandSubscribe   = "SUBSCRIBE"
+	CommandUnsubscribe = "UNSUBSCRIBE"
+	CommandAck         = "ACK"
+	CommandNack        = "NACK"
+	CommandBegin       = "BEGIN"
+	CommandCommit      = "COMMIT"
+	CommandAbort       = "ABORT"
+	CommandDisconnect  = "DISCONNECT"
+	CommandMessage     = "MESSAGE"
+	CommandReceipt     = "RECEIPT"
+	CommandError       = "ERROR"
+)
+
+// ErrInvalidFrame is returned when data is not a well-formed STOMP frame
+var ErrInvalidFrame = errors.New("stomp: invalid frame")
+
+// Header is a frame header
+type Header struct {
+	Key   string
+	Value string
+}
+
+// Frame is a STOMP frame. Headers keep their order; when a key repeats,
+// the first value applies.
+type Frame struct {
+	Command string
+	Headers []Header
+	Body    []byte
+}
+
+// Header returns the value of the first header with key
+func (f *Frame) Header(key string) (string, bool) {
+	for _, h := range f.Headers {
+		if h.Key == key {
+			return h.Value, true
+		}
+	}
+	return "", false
+}
+
+// Get returns the value of the first header with key, or "" if none
+func (f *Frame) Get(key string) string {
+	v, _ := f.Header(key)
+	return v
+}
+
+// AddHeader appends a header
+func (f *Frame) AddHeader(key, value string) {
+	f.Headers = append(f.Headers, Header{Key: key, Value: value})
+}
+
+// escapesHeaders reports whether headers of a command are escaped; CONNECT
+// and CONNECTED frames predate escaping and never are
+func escapesHeaders(command string) bool {
+	return command != CommandConnect && command != CommandConnected
+}
+
+var (
+	headerEscaper   = strings.NewReplacer("\\", `\\`, "\r", `\r`, "\n", `\n`, ":", `\c`)
+	headerUnescaper = strings.NewReplacer(`\\`, "\\", `\r`, "\r", `\n`, "\n", `\c`, ":")
+)
+
+// MarshalBinary encodes the frame. A content-length header is added to
+// frames with a body that lack one.
+func (f *Frame) MarshalBinary() ([]byte, error) {
+	if f.Command == "" || strings.ContainsAny(f.Command, "\r\n") {
+		return nil, ErrInvalidFrame
+	}
+	escape := escapesHeaders(f.Command)
+
+	var buf bytes.Buffer
+	buf.WriteString(f.Command)
+	buf.WriteByte('\n')
+	for _, h := range f.Headers {
+		key, value := h.Key, h.Value
+		if escape {
+			key, value = headerEscaper.Replace(key), headerEscaper.Replace(value)
+		} else if strings.ContainsAny(key+value, "\r\n") || strings.Contains(key, ":") {
+			return nil, ErrInvalidFrame
+		}
+		buf.WriteString(key)
+		buf.WriteByte(':')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+	}
+	if _, ok := f.Header("content-length"); !ok && len(f.Body) > 0 {
+		buf.WriteString("content-length:")
+		buf.WriteString(strconv.Itoa(len(f.Body)))
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	buf.Write(f.Body)
+	buf.WriteByte(0)
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a frame. End-of-line heart-beats before and
+// after it are skipped.
+func (f *Frame) UnmarshalBinary(data []byte) error {
+	data = trimEOL(data)
+
+	command, data, ok := cutLine(data)
+	if !ok || command == "" {
+		return ErrInvalidFrame
+	}
+	escape := escapesHeaders(command)
+
+	var headers []Header
+	for {
+		var line string
+		line, data, ok = cutLine(data)
+		if !ok {
+			return ErrInvalidFrame
+		}
+		if line == "" {
+			break
+		}
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			return ErrInvalidFrame
+		}
+		if escape {
+			if !validEscapes(key) || !validEscapes(value) {
+				return ErrInvalidFrame
+			}
+			key, value = headerUnescaper.Replace(key), headerUnescaper.Replace(value)
+		}
+		headers = append(headers, Header{Key: key, Value: value})
+	}
+
+	frame := Frame{Command: command, Headers: headers}
+	end := bytes.IndexByte(data, 0)
+	if length, ok := frame.Header("content-length"); ok {
+		n, err := strconv.Atoi(length)
+		if err != nil || n < 0 || n >= len(data) || data[n] != 0 {
+			return ErrInvalidFrame
+		}
+		end = n
+	}
+	if end < 0 || len(trimEOL(data[end+1:])) != 0 {
+		return ErrInvalidFrame
+	}
+	if end > 0 {
+		frame.Body = append([]byte(nil), data[:end]...)
+	}
+	*f = frame
+	return nil
+}
+
+// ParseFrame decodes a frame
+func ParseFrame(data []byte) (*Frame, error) {
+	f := new(Frame)
+	if err := f.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// isHeartbeat reports whether data holds only end-of-line heart-beats
+func isHeartbeat(data []byte) bool {
+	return len(trimEOL(data)) == 0
+}
+
+// trimEOL removes leading end-of-lines
+func trimEOL(data []byte) []byte {
+	for len(data) > 0 && (data[0] == '\n' || data[0] == '\r') {
+		data = data[1:]
+	}
+	return data
+}
+
+// cutLine splits off the first line, which ends with LF or CRLF
+func cutLine(data []byte) (line string, rest []byte, ok bool) {
+	i := bytes.IndexByte(data, '\n')
+	if i < 0 {
+		return "", data, false
+	}
+	return string(bytes.TrimSuffix(data[:i], []byte{'\r'})), data[i+1:], true
+}
+
+// validEscapes reports whether every backslash in s starts a defined
+// escape sequence
+func validEscapes(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			continue
+		}
+		if i+1 == len(s) || !strings.ContainsRune(`\rnc`, rune(s[i+1])) {
+			return false
+		}
+		i++
+	}
+	return true
+}
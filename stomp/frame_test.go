@@ -0,0 +1,86 @@
+package stomp_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kolosys/axon/stomp"
+)
+
+func TestFrame_Marshal(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame stomp.Frame
+		want  string
+	}{
+		{"no body", stomp.Frame{Command: "SUBSCRIBE", Headers: []stomp.Header{{"id", "0"}, {"destination", "/queue/a"}}},
+			"SUBSCRIBE\nid:0\ndestination:/queue/a\n\n\x00"},
+		{"body", stomp.Frame{Command: "SEND", Headers: []stomp.Header{{"destination", "/queue/a"}}, Body: []byte("hi")},
+			"SEND\ndestination:/queue/a\ncontent-length:2\n\nhi\x00"},
+		{"escaped", stomp.Frame{Command: "SEND", Headers: []stomp.Header{{"a:b", "line\nbreak\\"}}},
+			"SEND\na\\cb:line\\nbreak\\\\\n\n\x00"},
+		{"connect unescaped", stomp.Frame{Command: "CONNECT", Headers: []stomp.Header{{"passcode", `a\b`}}},
+			"CONNECT\npasscode:a\\b\n\n\x00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.frame.MarshalBinary()
+			if err != nil {
+				t.Fatalf("MarshalBinary() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("MarshalBinary() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := (&stomp.Frame{Command: "CONNECT", Headers: []stomp.Header{{"host", "a\nb"}}}).MarshalBinary(); !errors.Is(err, stomp.ErrInvalidFrame) {
+		t.Errorf("MarshalBinary() of a CONNECT header with a newline error = %v, want ErrInvalidFrame", err)
+	}
+}
+
+func TestParseFrame(t *testing.T) {
+	frame, err := stomp.ParseFrame([]byte("\n\r\nMESSAGE\r\nsubscription:1\nmessage-id:a\\cb\nsubscription:2\ncontent-length:3\n\na\x00b\x00\n\n"))
+	if err != nil {
+		t.Fatalf("ParseFrame() error = %v", err)
+	}
+	if frame.Command != "MESSAGE" || string(frame.Body) != "a\x00b" {
+		t.Errorf("frame = %s %q", frame.Command, frame.Body)
+	}
+	if got := frame.Get("message-id"); got != "a:b" {
+		t.Errorf("message-id = %q, want the unescaped %q", got, "a:b")
+	}
+	if got := frame.Get("subscription"); got != "1" {
+		t.Errorf("subscription = %q, want the first value", got)
+	}
+	if _, ok := frame.Header("missing"); ok {
+		t.Error("Header() found a missing header")
+	}
+
+	// Round trip
+	data, _ := frame.MarshalBinary()
+	again, err := stomp.ParseFrame(data)
+	if err != nil || string(again.Body) != string(frame.Body) || len(again.Headers) != len(frame.Headers) {
+		t.Errorf("round trip = %+v, %v", again, err)
+	}
+}
+
+func TestParseFrame_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"empty":              "",
+		"no headers end":     "SEND\ndestination:/a\n",
+		"header without key": "SEND\nnocolon\n\n\x00",
+		"bad escape":         "SEND\na:\\t\n\n\x00",
+		"no terminator":      "SEND\n\nbody",
+		"short body":         "SEND\ncontent-length:10\n\nbody\x00",
+		"long body":          "SEND\ncontent-length:2\n\nbody\x00",
+		"trailing data":      "SEND\n\n\x00junk",
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := stomp.ParseFrame([]byte(data)); !errors.Is(err, stomp.ErrInvalidFrame) {
+				t.Errorf("ParseFrame(%q) error = %v, want ErrInvalidFrame", data, err)
+			}
+		})
+	}
+}
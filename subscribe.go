@@ -0,0 +1,238 @@
+package axon
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// TopicConfig tells a Client how messages map to topics
+type TopicConfig[T any] struct {
+	// Topic extracts the topic of a received message; "" means none
+	Topic func(T) string
+
+	// Subscribe builds the message asking the server for a topic. It is
+	// sent when a topic gains its first subscriber and again after every
+	// reconnect. Nil sends nothing.
+	Subscribe func(topic string) T
+
+	// Unsubscribe builds the message sent when a topic loses its last
+	// subscriber. Nil sends nothing.
+	Unsubscribe func(topic string) T
+}
+
+// Subscription is a consumer of one topic on a Client
+type Subscription[T any] struct {
+	// C receives the topic's messages for subscriptions created with
+	// SubscribeChan. It is closed on Unsubscribe and when the client closes.
+	C <-chan T
+
+	client  *Client[T]
+	topic   string
+	handler func(T)
+
+	mu      sync.Mutex
+	ch      chan T
+	closed  bool
+	dropped atomic.Int64
+}
+
+// Topic returns the subscribed topic
+func (s *Subscription[T]) Topic() string {
+	return s.topic
+}
+
+// Dropped returns the number of messages discarded because C was full
+func (s *Subscription[T]) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Unsubscribe stops delivery to the subscription. When it was the topic's
+// last subscriber and the client is connected, the unsubscribe message is
+// sent.
+func (s *Subscription[T]) Unsubscribe(ctx context.Context) error {
+	c := s.client
+
+	c.topicsMu.Lock()
+	subs := c.subs[s.topic]
+	found := false
+	for i, sub := range subs {
+		if sub == s {
+			subs = append(subs[:i:i], subs[i+1:]...)
+			found = true
+			break
+		}
+	}
+	if len(subs) == 0 {
+		delete(c.subs, s.topic)
+	} else {
+		c.subs[s.topic] = subs
+	}
+	unsubscribe := c.topics.Unsubscribe
+	c.topicsMu.Unlock()
+
+	s.stop()
+
+	if !found || len(subs) > 0 || unsubscribe == nil || !c.IsConnected() {
+		return nil
+	}
+	return c.write(ctx, unsubscribe(s.topic))
+}
+
+// deliver passes msg to the subscription's handler or channel
+func (s *Subscription[T]) deliver(msg T) {
+	if s.handler != nil {
+		s.handler(msg)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.ch <- msg:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// stop ends delivery and closes C
+func (s *Subscription[T]) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	if s.ch != nil {
+		close(s.ch)
+	}
+}
+
+// SetTopics configures how messages map to topics for Subscribe
+func (c *Client[T]) SetTopics(cfg TopicConfig[T]) {
+	c.topicsMu.Lock()
+	c.topics = cfg
+	c.topicsMu.Unlock()
+}
+
+// Subscribe calls handler for every received message on topic. Messages
+// with a topic are delivered to its subscribers instead of OnMessage. The
+// handler runs on the read loop, so the client must be connected with
+// ConnectWithReadLoop.
+func (c *Client[T]) Subscribe(ctx context.Context, topic string, handler func(T)) (*Subscription[T], error) {
+	return c.subscribe(ctx, &Subscription[T]{client: c, topic: topic, handler: handler})
+}
+
+// SubscribeChan is like Subscribe but delivers messages on the
+// subscription's channel C, buffering up to size of them. Messages
+// arriving while C is full are dropped.
+func (c *Client[T]) SubscribeChan(ctx context.Context, topic string, size int) (*Subscription[T], error) {
+	ch := make(chan T, size)
+	return c.subscribe(ctx, &Subscription[T]{client: c, topic: topic, C: ch, ch: ch})
+}
+
+// subscribe registers sub, sending the subscribe message for a new topic
+func (c *Client[T]) subscribe(ctx context.Context, sub *Subscription[T]) (*Subscription[T], error) {
+	if sub.topic == "" {
+		return nil, ErrNoTopic
+	}
+
+	c.topicsMu.Lock()
+	if c.topics.Topic == nil {
+		c.topicsMu.Unlock()
+		return nil, ErrNoTopic
+	}
+	if c.ctx.Err() != nil {
+		c.topicsMu.Unlock()
+		return nil, ErrClientClosed
+	}
+	if c.subs == nil {
+		c.subs = make(map[string][]*Subscription[T])
+	}
+	first := len(c.subs[sub.topic]) == 0
+	c.subs[sub.topic] = append(c.subs[sub.topic], sub)
+	subscribe := c.topics.Subscribe
+	c.topicsMu.Unlock()
+
+	// Otherwise the subscription is sent by resubscribe once connected
+	if first && subscribe != nil && c.IsConnected() {
+		if err := c.write(ctx, subscribe(sub.topic)); err != nil {
+			sub.Unsubscribe(ctx)
+			return nil, err
+		}
+	}
+	return sub, nil
+}
+
+// Topics returns the topics that have subscribers
+func (c *Client[T]) Topics() []string {
+	c.topicsMu.Lock()
+	defer c.topicsMu.Unlock()
+
+	topics := make([]string, 0, len(c.subs))
+	for topic := range c.subs {
+		topics = append(topics, topic)
+	}
+	return topics
+}
+
+// dispatchTopic delivers msg to its topic's subscribers, reporting whether
+// there were any
+func (c *Client[T]) dispatchTopic(msg T) bool {
+	c.topicsMu.Lock()
+	if c.topics.Topic == nil || len(c.subs) == 0 {
+		c.topicsMu.Unlock()
+		return false
+	}
+	topic := c.topics.Topic(msg)
+	subs := c.subs[topic]
+	c.topicsMu.Unlock()
+
+	if topic == "" || len(subs) == 0 {
+		return false
+	}
+	for _, sub := range subs {
+		sub.deliver(msg)
+	}
+	return true
+}
+
+// resubscribe sends the subscribe message for every subscribed topic on a
+// new connection
+func (c *Client[T]) resubscribe(ctx context.Context) {
+	c.topicsMu.Lock()
+	subscribe := c.topics.Subscribe
+	var topics []string
+	if subscribe != nil {
+		for topic := range c.subs {
+			topics = append(topics, topic)
+		}
+	}
+	c.topicsMu.Unlock()
+
+	for _, topic := range topics {
+		if err := c.write(ctx, subscribe(topic)); err != nil {
+			if c.onError != nil {
+				c.onError(err)
+			}
+			return
+		}
+	}
+}
+
+// closeSubscriptions stops all subscriptions
+func (c *Client[T]) closeSubscriptions() {
+	c.topicsMu.Lock()
+	subs := c.subs
+	c.subs = nil
+	c.topicsMu.Unlock()
+
+	for _, topicSubs := range subs {
+		for _, sub := range topicSubs {
+			sub.stop()
+		}
+	}
+}
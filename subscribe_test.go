@@ -0,0 +1,203 @@
+package axon_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+type topicMessage struct {
+	Op    string `json:"op,omitempty"`
+	Topic string `json:"topic,omitempty"`
+	Data  string `json:"data,omitempty"`
+}
+
+var topicConfig = axon.TopicConfig[topicMessage]{
+	Topic: func(m topicMessage) string {
+		if m.Op != "" {
+			return ""
+		}
+		return m.Topic
+	},
+	Subscribe: func(topic string) topicMessage {
+		return topicMessage{Op: "sub", Topic: topic}
+	},
+	Unsubscribe: func(topic string) topicMessage {
+		return topicMessage{Op: "unsub", Topic: topic}
+	},
+}
+
+// startTopicServer answers every subscribe with a message on the topic and
+// reports the ops it receives. The first connection is dropped after its
+// first subscribe when dropFirst is set.
+func startTopicServer(t *testing.T, dropFirst bool) (string, <-chan topicMessage) {
+	t.Helper()
+	ops := make(chan topicMessage, 16)
+	var conns atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[topicMessage](w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close(1000, "done")
+		n := conns.Add(1)
+
+		for {
+			msg, err := conn.Read(context.Background())
+			if err != nil {
+				return
+			}
+			ops <- msg
+			if msg.Op != "sub" {
+				continue
+			}
+			if dropFirst && n == 1 {
+				conn.Close(int(axon.CloseGoingAway), "restarting")
+				return
+			}
+			conn.Write(context.Background(), topicMessage{Topic: msg.Topic, Data: "hello " + msg.Topic})
+			conn.Write(context.Background(), topicMessage{Topic: "other", Data: "unsubscribed"})
+		}
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http"), ops
+}
+
+func receive[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for message")
+		var zero T
+		return zero
+	}
+}
+
+func TestClient_Subscribe(t *testing.T) {
+	url, ops := startTopicServer(t, false)
+
+	opts := axon.DefaultClientOptions()
+	opts.Reconnect.Enabled = false
+	client := axon.NewClient[topicMessage](url, opts)
+	defer client.Close()
+	client.SetTopics(topicConfig)
+
+	unmatched := make(chan topicMessage, 4)
+	client.OnMessage(func(m topicMessage) {
+		unmatched <- m
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.ConnectWithReadLoop(ctx); err != nil {
+		t.Fatalf("ConnectWithReadLoop() error = %v", err)
+	}
+
+	handled := make(chan topicMessage, 4)
+	first, err := client.Subscribe(ctx, "news", func(m topicMessage) {
+		handled <- m
+	})
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	second, err := client.SubscribeChan(ctx, "news", 4)
+	if err != nil {
+		t.Fatalf("SubscribeChan() error = %v", err)
+	}
+
+	// Only the first subscriber sends a subscribe message
+	if op := receive(t, ops); op.Op != "sub" || op.Topic != "news" {
+		t.Errorf("server got %+v, want sub news", op)
+	}
+	if m := receive(t, handled); m.Data != "hello news" {
+		t.Errorf("handler got %+v", m)
+	}
+	if m := receive(t, second.C); m.Data != "hello news" {
+		t.Errorf("channel got %+v", m)
+	}
+	if m := receive(t, unmatched); m.Topic != "other" {
+		t.Errorf("OnMessage got %+v, want topic other", m)
+	}
+
+	if err := first.Unsubscribe(ctx); err != nil {
+		t.Fatalf("Unsubscribe() error = %v", err)
+	}
+	if err := second.Unsubscribe(ctx); err != nil {
+		t.Fatalf("Unsubscribe() error = %v", err)
+	}
+	if op := receive(t, ops); op.Op != "unsub" || op.Topic != "news" {
+		t.Errorf("server got %+v, want unsub news", op)
+	}
+	if _, ok := <-second.C; ok {
+		t.Error("expected C to be closed after Unsubscribe")
+	}
+	if topics := client.Topics(); len(topics) != 0 {
+		t.Errorf("Topics() = %v, want none", topics)
+	}
+}
+
+func TestClient_SubscribeResubscribesAfterReconnect(t *testing.T) {
+	url, ops := startTopicServer(t, true)
+
+	opts := axon.DefaultClientOptions()
+	opts.Reconnect.InitialDelay = 10 * time.Millisecond
+	opts.Reconnect.Jitter = false
+	client := axon.NewClient[topicMessage](url, opts)
+	defer client.Close()
+	client.SetTopics(topicConfig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Subscribing before connecting sends the subscription on connect
+	sub, err := client.SubscribeChan(ctx, "news", 4)
+	if err != nil {
+		t.Fatalf("SubscribeChan() error = %v", err)
+	}
+	if err := client.ConnectWithReadLoop(ctx); err != nil {
+		t.Fatalf("ConnectWithReadLoop() error = %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if op := receive(t, ops); op.Op != "sub" || op.Topic != "news" {
+			t.Errorf("server got %+v, want sub news", op)
+		}
+	}
+	if m := receive(t, sub.C); m.Data != "hello news" {
+		t.Errorf("channel got %+v", m)
+	}
+
+	client.Close()
+	if _, ok := <-sub.C; ok {
+		t.Error("expected C to be closed after Close")
+	}
+}
+
+func TestClient_SubscribeErrors(t *testing.T) {
+	client := axon.NewClient[topicMessage]("ws://localhost:8080", nil)
+	defer client.Close()
+	ctx := context.Background()
+
+	if _, err := client.SubscribeChan(ctx, "news", 1); !errors.Is(err, axon.ErrNoTopic) {
+		t.Errorf("SubscribeChan() without TopicConfig error = %v, want ErrNoTopic", err)
+	}
+
+	client.SetTopics(topicConfig)
+	if _, err := client.SubscribeChan(ctx, "", 1); !errors.Is(err, axon.ErrNoTopic) {
+		t.Errorf("SubscribeChan() with empty topic error = %v, want ErrNoTopic", err)
+	}
+
+	client.Close()
+	if _, err := client.SubscribeChan(ctx, "news", 1); !errors.Is(err, axon.ErrClientClosed) {
+		t.Errorf("SubscribeChan() after Close error = %v, want ErrClientClosed", err)
+	}
+}
@@ -0,0 +1,101 @@
+package axon
+
+import "fmt"
+
+// FrameDirection tells whether a traced frame was read or written
+type FrameDirection int
+
+const (
+	// FrameRead marks a frame read from the peer
+	FrameRead FrameDirection = iota
+	// FrameWritten marks a frame written to the peer
+	FrameWritten
+)
+
+// String returns the string representation of the direction
+func (d FrameDirection) String() string {
+	switch d {
+	case FrameRead:
+		return "read"
+	case FrameWritten:
+		return "written"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText encodes the direction as its string representation
+func (d FrameDirection) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText decodes a direction encoded by MarshalText
+func (d *FrameDirection) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "read":
+		*d = FrameRead
+	case "written":
+		*d = FrameWritten
+	default:
+		return fmt.Errorf("axon: unknown frame direction %q", text)
+	}
+	return nil
+}
+
+// TraceFunc is called with every frame read or written on a connection,
+// from the goroutine doing the I/O, before a written frame is sent.
+// Payloads are unmasked but stay compressed when Rsv1 is set. The frame
+// must not be modified or retained after the call.
+type TraceFunc func(dir FrameDirection, frame *Frame)
+
+// RedactPayloads wraps trace so that the payloads of data frames are
+// replaced by what redact returns for them, keeping message contents out of
+// traces. Control frame payloads are passed through. A nil redact drops
+// data payloads entirely.
+func RedactPayloads(trace TraceFunc, redact func(payload []byte) []byte) TraceFunc {
+	return func(dir FrameDirection, frame *Frame) {
+		if isControl(frame.Opcode) {
+			trace(dir, frame)
+			return
+		}
+		redacted := *frame
+		redacted.Payload = nil
+		if redact != nil {
+			redacted.Payload = redact(frame.Payload)
+		}
+		trace(dir, &redacted)
+	}
+}
+
+// traceFrame passes frame to the connection's TraceFunc, if any
+func (c *Conn[T]) traceFrame(dir FrameDirection, frame *Frame) {
+	if c.trace == nil {
+		return
+	}
+	// The TraceFunc gets a copy, so that frames being written stay on the
+	// stack. Their payloads are only masked as they are written.
+	traced := *frame
+	if traced.maskOnWrite {
+		traced.MaskKey = traced.maskKey[:]
+	}
+	c.trace(dir, &traced)
+}
+
+// writeFrame traces frame and writes it to the connection's writer,
+// choosing the mask key of a frame masked as it is written
+func (c *Conn[T]) writeFrame(frame *Frame) error {
+	c.attachWriter()
+	if frame.maskOnWrite {
+		if c.maskKeys == nil {
+			c.maskKeys = new(maskKeys)
+		}
+		if err := c.maskKeys.next(frame.maskKey[:]); err != nil {
+			return err
+		}
+	}
+	c.traceFrame(FrameWritten, frame)
+	if !frame.maskOnWrite && len(frame.Payload) > c.writer.Size() {
+		return c.writeFrameVectored(frame)
+	}
+	return writeFrame(c.writer, c.writeBuf, frame)
+}
@@ -0,0 +1,116 @@
+package axon_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+// frameLog records traced frames as "direction opcode payload"
+type frameLog struct {
+	mu     sync.Mutex
+	frames []string
+}
+
+func (l *frameLog) trace(dir axon.FrameDirection, frame *axon.Frame) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.frames = append(l.frames, fmt.Sprintf("%s %d %s", dir, frame.Opcode, frame.Payload))
+}
+
+func (l *frameLog) snapshot() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return slices.Clone(l.frames)
+}
+
+func TestTrace_Frames(t *testing.T) {
+	var serverLog frameLog
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		conn, err := axon.Upgrade[string](w, r, &axon.UpgradeOptions{Trace: serverLog.trace})
+		if err != nil {
+			return
+		}
+		msg, err := conn.Read(r.Context())
+		if err != nil {
+			return
+		}
+		conn.Write(r.Context(), msg)
+		conn.Read(r.Context())
+	}))
+	defer server.Close()
+
+	var clientLog frameLog
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := axon.Dial[string](ctx, "ws"+strings.TrimPrefix(server.URL, "http"), &axon.DialOptions{Trace: clientLog.trace})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	if err := conn.Write(ctx, "hello"); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if _, err := conn.Read(ctx); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	conn.Close(1000, "bye")
+	<-done
+
+	// Written client payloads are traced unmasked
+	wantClient := []string{`written 1 "hello"`, `read 1 "hello"`, "written 8 \x03\xe8bye"}
+	if got := clientLog.snapshot(); !slices.Equal(got, wantClient) {
+		t.Errorf("client frames = %q, want %q", got, wantClient)
+	}
+	// The server echoes the close frame with the client's code
+	wantServer := []string{`read 1 "hello"`, `written 1 "hello"`, "read 8 \x03\xe8bye", "written 8 \x03\xe8"}
+	if got := serverLog.snapshot(); !slices.Equal(got, wantServer) {
+		t.Errorf("server frames = %q, want %q", got, wantServer)
+	}
+}
+
+func TestRedactPayloads(t *testing.T) {
+	var log frameLog
+	trace := axon.RedactPayloads(log.trace, func(payload []byte) []byte {
+		return fmt.Appendf(nil, "<%d bytes>", len(payload))
+	})
+
+	data := &axon.Frame{Fin: true, Opcode: 0x1, Payload: []byte("secret")}
+	trace(axon.FrameWritten, data)
+	trace(axon.FrameRead, &axon.Frame{Fin: true, Opcode: 0x9, Payload: []byte("ping")})
+
+	want := []string{"written 1 <6 bytes>", "read 9 ping"}
+	if got := log.snapshot(); !slices.Equal(got, want) {
+		t.Errorf("frames = %q, want %q", got, want)
+	}
+	if string(data.Payload) != "secret" {
+		t.Errorf("redaction modified the traced frame: %q", data.Payload)
+	}
+
+	var dropped frameLog
+	axon.RedactPayloads(dropped.trace, nil)(axon.FrameRead, data)
+	if got := dropped.snapshot(); !slices.Equal(got, []string{"read 1 "}) {
+		t.Errorf("frames with nil redact = %q", got)
+	}
+}
+
+func TestFrameDirection_String(t *testing.T) {
+	for dir, want := range map[axon.FrameDirection]string{
+		axon.FrameRead:    "read",
+		axon.FrameWritten: "written",
+		42:                "unknown",
+	} {
+		if got := dir.String(); got != want {
+			t.Errorf("FrameDirection(%d).String() = %q, want %q", dir, got, want)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package axon
+
+import "unicode/utf8"
+
+// validUTF8Prefix validates p as UTF-8, tolerating an incomplete multi-byte
+// sequence at the end so text messages can be checked frame by frame.
+// It returns the number of bytes that form complete runes and false if an
+// invalid sequence was found.
+func validUTF8Prefix(p []byte) (int, bool) {
+	i := 0
+	for i < len(p) {
+		if p[i] < utf8.RuneSelf {
+			i++
+			continue
+		}
+		if !utf8.FullRune(p[i:]) {
+			// Possibly completed by the next fragment
+			return i, true
+		}
+		r, size := utf8.DecodeRune(p[i:])
+		if r == utf8.RuneError && size == 1 {
+			return i, false
+		}
+		i += size
+	}
+	return i, true
+}
+
+// truncateUTF8 shortens s to at most n bytes without splitting a rune
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
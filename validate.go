@@ -0,0 +1,71 @@
+package axon
+
+import "fmt"
+
+// ValidationFailure decides what Read does with a message that fails
+// validation
+type ValidationFailure int
+
+const (
+	// ValidationError makes Read return the validation error
+	ValidationError ValidationFailure = iota
+	// ValidationDrop makes Read discard the message and read the next one
+	ValidationDrop
+	// ValidationClose makes Read close the connection with 1007 (invalid
+	// payload data) and return the validation error
+	ValidationClose
+)
+
+// Validator checks the messages of a connection. Errors returned by
+// Read and Write for a failed validation wrap ErrValidationFailed and the
+// error of the check.
+type Validator[T any] struct {
+	// Read checks each message decoded by Read
+	Read func(T) error
+
+	// Write checks each message before Write encodes it. A message that
+	// fails is not sent and Write returns the error, whatever OnFailure.
+	// Nil skips the check.
+	Write func(T) error
+
+	// OnFailure decides what Read does with a message that fails its check
+	// Default is ValidationError.
+	OnFailure ValidationFailure
+}
+
+// SetValidator sets the checks applied by Read and Write. Raw messages
+// read and written with ReadMessage and WriteMessage are not checked.
+func (c *Conn[T]) SetValidator(v Validator[T]) {
+	c.validator.Store(&v)
+}
+
+// validateRead checks a message read from the connection, reporting
+// whether Read should drop it
+func (c *Conn[T]) validateRead(msg T) (drop bool, err error) {
+	v := c.validator.Load()
+	if v == nil || v.Read == nil {
+		return false, nil
+	}
+	if err := v.Read(msg); err != nil {
+		switch v.OnFailure {
+		case ValidationDrop:
+			return true, nil
+		case ValidationClose:
+			c.Close(int(CloseInvalidPayloadData), "invalid message")
+		}
+		return false, fmt.Errorf("%w: %w", ErrValidationFailed, err)
+	}
+	return false, nil
+}
+
+// validateWrite checks a message about to be written
+func (c *Conn[T]) validateWrite(msg T) error {
+	v := c.validator.Load()
+	if v == nil || v.Write == nil {
+		return nil
+	}
+	if err := v.Write(msg); err != nil {
+		return fmt.Errorf("%w: %w", ErrValidationFailed, err)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package axon_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+type counted struct {
+	N int `json:"n"`
+}
+
+var errNegative = errors.New("negative count")
+
+func checkCount(msg counted) error {
+	if msg.N < 0 {
+		return errNegative
+	}
+	return nil
+}
+
+// dialCounted connects to a server that writes counts 1, -1 and 2, then
+// waits for the client to close
+func dialCounted(t *testing.T, v axon.Validator[counted]) (*axon.Conn[counted], context.Context) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[counted](w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close(1000, "done")
+		for _, n := range []int{1, -1, 2} {
+			if err := conn.Write(r.Context(), counted{N: n}); err != nil {
+				return
+			}
+		}
+		conn.Read(r.Context())
+	}))
+	t.Cleanup(server.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	conn, err := axon.Dial[counted](ctx, "ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { conn.Close(1000, "") })
+	conn.SetValidator(v)
+	return conn, ctx
+}
+
+func TestValidator_Error(t *testing.T) {
+	conn, ctx := dialCounted(t, axon.Validator[counted]{Read: checkCount})
+
+	if msg, err := conn.Read(ctx); err != nil || msg.N != 1 {
+		t.Fatalf("first Read() = %+v, %v", msg, err)
+	}
+	_, err := conn.Read(ctx)
+	if !errors.Is(err, axon.ErrValidationFailed) || !errors.Is(err, errNegative) {
+		t.Fatalf("second Read() error = %v, want ErrValidationFailed wrapping errNegative", err)
+	}
+	if msg, err := conn.Read(ctx); err != nil || msg.N != 2 {
+		t.Fatalf("third Read() = %+v, %v", msg, err)
+	}
+}
+
+func TestValidator_Drop(t *testing.T) {
+	conn, ctx := dialCounted(t, axon.Validator[counted]{Read: checkCount, OnFailure: axon.ValidationDrop})
+
+	for _, want := range []int{1, 2} {
+		if msg, err := conn.Read(ctx); err != nil || msg.N != want {
+			t.Fatalf("Read() = %+v, %v; want count %d", msg, err, want)
+		}
+	}
+}
+
+func TestValidator_Close(t *testing.T) {
+	conn, ctx := dialCounted(t, axon.Validator[counted]{Read: checkCount, OnFailure: axon.ValidationClose})
+
+	if msg, err := conn.Read(ctx); err != nil || msg.N != 1 {
+		t.Fatalf("first Read() = %+v, %v", msg, err)
+	}
+	if _, err := conn.Read(ctx); !errors.Is(err, axon.ErrValidationFailed) {
+		t.Fatalf("second Read() error = %v, want ErrValidationFailed", err)
+	}
+	if !conn.IsClosed() || conn.CloseCode() != int(axon.CloseInvalidPayloadData) {
+		t.Errorf("connection closed = %v with code %d, want closed with 1007", conn.IsClosed(), conn.CloseCode())
+	}
+}
+
+func TestValidator_Write(t *testing.T) {
+	received := make(chan counted, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := axon.Upgrade[counted](w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close(1000, "done")
+		for {
+			msg, err := conn.Read(r.Context())
+			if err != nil {
+				return
+			}
+			received <- msg
+		}
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := axon.Dial[counted](ctx, "ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close(1000, "")
+	conn.SetValidator(axon.Validator[counted]{Write: checkCount})
+
+	if err := conn.Write(ctx, counted{N: -1}); !errors.Is(err, axon.ErrValidationFailed) || !errors.Is(err, errNegative) {
+		t.Fatalf("Write() of invalid message error = %v, want ErrValidationFailed wrapping errNegative", err)
+	}
+	if err := conn.Write(ctx, counted{N: 3}); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	select {
+	case msg := <-received:
+		if msg.N != 3 {
+			t.Errorf("server received %+v, want only the valid message", msg)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for the valid message")
+	}
+}
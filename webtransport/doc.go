@@ -0,0 +1,10 @@
+// Package webtransport carries axon connections over WebTransport
+// sessions on HTTP/3. Each session holds a single bidirectional stream
+// that speaks the WebSocket protocol, so handlers keep the typed Conn[T]
+// API and message handling code moves between transports unchanged.
+//
+// The package is experimental and is its own module, which keeps quic-go
+// out of axon's dependencies. To use it, add the module:
+//
+//	go get github.com/kolosys/axon/webtransport
+package webtransport
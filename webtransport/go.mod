@@ -0,0 +1,23 @@
+module github.com/kolosys/axon/webtransport
+
+// webtransport-go v0.13.0 and quic-go v0.62.0 require go 1.26
+go 1.26.0
+
+require (
+	github.com/kolosys/axon v0.0.0-20261015065801-a53d435dcc44
+	github.com/quic-go/quic-go v0.62.0
+	github.com/quic-go/webtransport-go v0.13.0
+)
+
+require (
+	github.com/dunglas/httpsfv v1.1.1 // indirect
+	github.com/quic-go/qpack v0.6.0 // indirect
+	golang.org/x/crypto v0.54.0 // indirect
+	golang.org/x/net v0.56.0 // indirect
+	golang.org/x/sys v0.47.0 // indirect
+	golang.org/x/text v0.40.0 // indirect
+)
+
+// Builds inside the repository use the axon beside it. Go ignores this
+// replacement where the module is a dependency, and uses the version above.
+replace github.com/kolosys/axon => ../
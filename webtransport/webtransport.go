@@ -0,0 +1,162 @@
+package webtransport
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/kolosys/axon"
+	wt "github.com/quic-go/webtransport-go"
+)
+
+// Server runs a handler for the connection of each WebTransport session
+type Server[T any] struct {
+	// WebTransport upgrades the sessions. Its HTTP/3 server is started by
+	// the caller.
+	WebTransport *wt.Server
+
+	// Handler runs each connection. The session ends when it returns.
+	Handler axon.HandlerFunc[T]
+
+	// UpgradeOptions configures the connections. Upgrade interceptors see
+	// the WebSocket handshake carried inside the session.
+	UpgradeOptions *axon.UpgradeOptions
+}
+
+// ServeHTTP upgrades the request to a WebTransport session and serves the
+// connection on its first bidirectional stream until the session ends
+func (s *Server[T]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	sess, err := s.WebTransport.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	ctx := sess.Context()
+
+	st, err := sess.AcceptStream(ctx)
+	if err != nil {
+		sess.CloseWithError(0, "")
+		return
+	}
+
+	ln := &sessionListener{
+		conn:   &streamConn{stream: st, sess: sess},
+		done:   ctx.Done(),
+		closed: make(chan struct{}),
+	}
+	axon.Serve(ctx, ln, s.UpgradeOptions, s.Handler)
+}
+
+// Dial opens a WebTransport session to rawURL, an https URL, and returns
+// the connection on its first bidirectional stream. Closing the connection
+// ends the session. opts configures the connection; its Headers are also
+// sent with the session request.
+func Dial[T any](ctx context.Context, d *wt.Transport, rawURL string, opts *axon.DialOptions) (*axon.Conn[T], error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("webtransport: invalid URL: %w", err)
+	}
+
+	var dialOpts axon.DialOptions
+	if opts != nil {
+		dialOpts = *opts
+	}
+
+	_, sess, err := d.Dial(ctx, rawURL, dialOpts.Headers)
+	if err != nil {
+		return nil, err
+	}
+	st, err := sess.OpenStreamSync(ctx)
+	if err != nil {
+		sess.CloseWithError(0, "")
+		return nil, err
+	}
+	nc := &streamConn{stream: st, sess: sess}
+
+	// The WebSocket handshake runs inside the stream, which is already
+	// encrypted and bound to the server
+	dialOpts.DialContext = func(context.Context, string, string) (net.Conn, error) {
+		return nc, nil
+	}
+	dialOpts.Proxy = func(*http.Request) (*url.URL, error) { return nil, nil }
+	dialOpts.HostOverrides = nil
+	dialOpts.MaxRedirects = 0
+
+	conn, err := axon.Dial[T](ctx, "ws://"+u.Host+u.RequestURI(), &dialOpts)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// stream is the part of a WebTransport stream a connection uses
+type stream interface {
+	io.ReadWriteCloser
+	SetDeadline(t time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// streamConn adapts a session's stream to net.Conn. As the session carries
+// no other streams, closing the connection ends it.
+type streamConn struct {
+	stream
+	sess *wt.Session
+}
+
+// Close closes the stream and its session
+func (c *streamConn) Close() error {
+	c.stream.Close()
+	return c.sess.CloseWithError(0, "")
+}
+
+func (c *streamConn) LocalAddr() net.Addr  { return c.sess.LocalAddr() }
+func (c *streamConn) RemoteAddr() net.Addr { return c.sess.RemoteAddr() }
+
+// sessionListener hands a session's connection to axon.Serve once, then
+// waits for the session to end
+type sessionListener struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	done   <-chan struct{}
+	closed chan struct{}
+	once   sync.Once
+}
+
+// Accept returns the session's connection on the first call
+func (l *sessionListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	conn := l.conn
+	l.conn = nil
+	l.mu.Unlock()
+	if conn != nil {
+		return conn, nil
+	}
+
+	select {
+	case <-l.done:
+	case <-l.closed:
+	}
+	return nil, net.ErrClosed
+}
+
+// Close stops the listener
+func (l *sessionListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+// Addr returns the session's local address
+func (l *sessionListener) Addr() net.Addr {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conn != nil {
+		return l.conn.LocalAddr()
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package webtransport_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+	"github.com/kolosys/axon/webtransport"
+	"github.com/quic-go/quic-go/http3"
+	wt "github.com/quic-go/webtransport-go"
+)
+
+func TestRoundTrip(t *testing.T) {
+	// Borrow httptest's certificate, which is valid for 127.0.0.1
+	certServer := httptest.NewTLSServer(nil)
+	cert := certServer.TLS.Certificates[0]
+	roots := x509.NewCertPool()
+	roots.AddCert(certServer.Certificate())
+	certServer.Close()
+
+	wts := &wt.Server{
+		H3: &http3.Server{
+			TLSConfig: http3.ConfigureTLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}}),
+		},
+	}
+	wt.ConfigureHTTP3Server(wts.H3)
+	mux := http.NewServeMux()
+	mux.Handle("/ws", &webtransport.Server[string]{
+		WebTransport: wts,
+		Handler: func(ctx context.Context, conn *axon.Conn[string]) {
+			for {
+				msg, err := conn.Read(ctx)
+				if err != nil {
+					return
+				}
+				if err := conn.Write(ctx, "echo "+msg); err != nil {
+					return
+				}
+			}
+		},
+	})
+	wts.H3.Handler = mux
+
+	udp, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP failed: %v", err)
+	}
+	defer udp.Close()
+	go wts.Serve(udp)
+	defer wts.Close()
+
+	d := &wt.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}
+	defer d.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	url := fmt.Sprintf("https://%s/ws", udp.LocalAddr())
+	conn, err := webtransport.Dial[string](ctx, d, url, nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close(1000, "")
+
+	for _, msg := range []string{"hello", "world"} {
+		if err := conn.Write(ctx, msg); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		got, err := conn.Read(ctx)
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		if want := "echo " + msg; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
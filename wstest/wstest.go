@@ -0,0 +1,380 @@
+// Package wstest provides a scriptable WebSocket server for integration
+// tests of code built on axon clients. Each connection runs a script of
+// steps, such as expecting a message, replying, waiting or dropping the
+// connection, so tests need no hand-rolled frame handling.
+package wstest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kolosys/axon"
+)
+
+// ErrUnexpectedMessage is reported when a connection receives a message
+// other than the one its script expects
+var ErrUnexpectedMessage = errors.New("wstest: unexpected message")
+
+// errDropped is returned by reads on a connection the server dropped
+var errDropped = errors.New("wstest: connection dropped")
+
+// Options configures a Server
+type Options struct {
+	// Upgrade configures the upgrade of each connection
+	Upgrade *axon.UpgradeOptions
+
+	// Script returns the steps run on the n-th connection, counting from
+	// 0. See Steps.
+	// Default is nil (every message is echoed).
+	Script func(n int) []Step
+
+	// Echo echoes messages once a connection's script ends, rather than
+	// discarding them until the client closes
+	Echo bool
+
+	// DropAfter drops each connection without a close frame once it has
+	// received that many messages
+	// Default is 0 (never).
+	DropAfter int
+
+	// WriteDelay delays every message the server writes
+	WriteDelay time.Duration
+}
+
+// Message is a message received by a Server
+type Message struct {
+	Conn int // Index of the connection, counting from 0
+	Type axon.MessageType
+	Data []byte
+}
+
+// Server is a WebSocket server running scripts on its connections
+type Server struct {
+	// URL is the ws:// URL of the server
+	URL string
+
+	http *httptest.Server
+	opts Options
+
+	mu       sync.Mutex
+	netConns map[string]net.Conn // By remote address
+	conns    int
+	received []Message
+	errs     []error
+}
+
+// NewServer starts a Server. Close it when the test ends.
+func NewServer(opts *Options) *Server {
+	s := &Server{netConns: make(map[string]net.Conn)}
+	if opts != nil {
+		s.opts = *opts
+	}
+
+	s.http = httptest.NewUnstartedServer(http.HandlerFunc(s.serveHTTP))
+	s.http.Config.ConnState = s.trackConn
+	s.http.Start()
+	s.URL = "ws" + strings.TrimPrefix(s.http.URL, "http")
+	return s
+}
+
+// Close closes every connection and shuts the server down
+func (s *Server) Close() {
+	s.http.CloseClientConnections()
+	s.mu.Lock()
+	for _, nc := range s.netConns {
+		nc.Close()
+	}
+	s.mu.Unlock()
+	s.http.Close()
+}
+
+// Connections returns the number of connections upgraded so far
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns
+}
+
+// Messages returns the messages received so far, in order
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.received...)
+}
+
+// Err returns the errors of the scripts that failed so far, joined
+func (s *Server) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return errors.Join(s.errs...)
+}
+
+// trackConn records the network connections of the server, so that they
+// can be dropped without a close frame
+func (s *Server) trackConn(nc net.Conn, state http.ConnState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch state {
+	case http.StateNew:
+		s.netConns[nc.RemoteAddr().String()] = nc
+	case http.StateClosed:
+		delete(s.netConns, nc.RemoteAddr().String())
+	}
+}
+
+// serveHTTP upgrades a request and runs the connection's script
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	ac, err := axon.Upgrade[[]byte](w, r, s.opts.Upgrade)
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	conn := &Conn{Conn: ac, server: s, index: s.conns}
+	s.conns++
+	s.mu.Unlock()
+
+	defer conn.Close(int(axon.CloseNormalClosure), "")
+	ctx := ac.Context()
+
+	var steps []Step
+	if s.opts.Script != nil {
+		steps = s.opts.Script(conn.index)
+	}
+	for i, step := range steps {
+		if err := step(ctx, conn); err != nil {
+			if !conn.IsClosed() && !errors.Is(err, errDropped) {
+				s.fail(fmt.Errorf("wstest: connection %d step %d: %w", conn.index, i, err))
+				conn.Close(int(axon.ClosePolicyViolation), "script failed")
+			}
+			return
+		}
+	}
+
+	echo := s.opts.Echo || s.opts.Script == nil
+	for {
+		mt, data, err := conn.ReadMessage(ctx)
+		if err != nil {
+			return
+		}
+		if echo {
+			if err := conn.WriteMessage(ctx, mt, data); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// fail records a script failure
+func (s *Server) fail(err error) {
+	s.mu.Lock()
+	s.errs = append(s.errs, err)
+	s.mu.Unlock()
+}
+
+// Conn is a server connection as seen by script steps
+type Conn struct {
+	*axon.Conn[[]byte]
+
+	server   *Server
+	index    int
+	received int
+}
+
+// Index returns the index of the connection, counting from 0
+func (c *Conn) Index() int {
+	return c.index
+}
+
+// ReadMessage reads a message, recording it on the server and dropping
+// the connection once DropAfter messages were received
+func (c *Conn) ReadMessage(ctx context.Context) (axon.MessageType, []byte, error) {
+	mt, data, err := c.Conn.ReadMessage(ctx)
+	if err != nil {
+		return mt, data, err
+	}
+	data = bytes.Clone(data)
+
+	c.server.mu.Lock()
+	c.server.received = append(c.server.received, Message{Conn: c.index, Type: mt, Data: data})
+	c.server.mu.Unlock()
+
+	c.received++
+	if c.server.opts.DropAfter > 0 && c.received >= c.server.opts.DropAfter {
+		c.Drop()
+		return mt, data, errDropped
+	}
+	return mt, data, nil
+}
+
+// WriteMessage writes a message after the server's WriteDelay
+func (c *Conn) WriteMessage(ctx context.Context, messageType axon.MessageType, data []byte) error {
+	if d := c.server.opts.WriteDelay; d > 0 {
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return c.Conn.WriteMessage(ctx, messageType, data)
+}
+
+// Drop closes the network connection without a close frame, as when a
+// network fails
+func (c *Conn) Drop() {
+	c.server.mu.Lock()
+	nc := c.server.netConns[c.RemoteAddr().String()]
+	c.server.mu.Unlock()
+	if nc != nil {
+		nc.Close()
+	}
+}
+
+// Step is one step of a connection's script. A step that fails ends the
+// script, is reported by Server.Err and closes the connection with
+// ClosePolicyViolation.
+type Step func(ctx context.Context, conn *Conn) error
+
+// Steps returns a Script running the same steps on every connection
+func Steps(steps ...Step) func(n int) []Step {
+	return func(int) []Step {
+		return steps
+	}
+}
+
+// Expect reads the next message and checks that it is the text msg
+func Expect(msg string) Step {
+	return ExpectFunc(func(mt axon.MessageType, data []byte) bool {
+		return mt == axon.TextMessage && string(data) == msg
+	})
+}
+
+// ExpectJSON reads the next message and checks that it decodes to the
+// same JSON value as v encodes to
+func ExpectJSON(v any) Step {
+	return func(ctx context.Context, conn *Conn) error {
+		want, err := jsonValue(v)
+		if err != nil {
+			return err
+		}
+		_, data, err := conn.ReadMessage(ctx)
+		if err != nil {
+			return err
+		}
+		var got any
+		if err := json.Unmarshal(data, &got); err != nil || !reflect.DeepEqual(got, want) {
+			return fmt.Errorf("%w: %q", ErrUnexpectedMessage, data)
+		}
+		return nil
+	}
+}
+
+// jsonValue returns v as decoded from its JSON encoding
+func jsonValue(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var value any
+	err = json.Unmarshal(data, &value)
+	return value, err
+}
+
+// ExpectFunc reads the next message and checks it with match
+func ExpectFunc(match func(messageType axon.MessageType, data []byte) bool) Step {
+	return func(ctx context.Context, conn *Conn) error {
+		mt, data, err := conn.ReadMessage(ctx)
+		if err != nil {
+			return err
+		}
+		if !match(mt, data) {
+			return fmt.Errorf("%w: %q", ErrUnexpectedMessage, data)
+		}
+		return nil
+	}
+}
+
+// Send writes the text msg
+func Send(msg string) Step {
+	return func(ctx context.Context, conn *Conn) error {
+		return conn.WriteMessage(ctx, axon.TextMessage, []byte(msg))
+	}
+}
+
+// SendJSON writes v encoded as JSON in a text message
+func SendJSON(v any) Step {
+	data, err := json.Marshal(v)
+	return func(ctx context.Context, conn *Conn) error {
+		if err != nil {
+			return err
+		}
+		return conn.WriteMessage(ctx, axon.TextMessage, data)
+	}
+}
+
+// SendBinary writes data in a binary message
+func SendBinary(data []byte) Step {
+	return func(ctx context.Context, conn *Conn) error {
+		return conn.WriteMessage(ctx, axon.BinaryMessage, data)
+	}
+}
+
+// Reply expects the text msg and answers it with reply
+func Reply(msg, reply string) Step {
+	expect, send := Expect(msg), Send(reply)
+	return func(ctx context.Context, conn *Conn) error {
+		if err := expect(ctx, conn); err != nil {
+			return err
+		}
+		return send(ctx, conn)
+	}
+}
+
+// Sleep pauses the script for d
+func Sleep(d time.Duration) Step {
+	return func(ctx context.Context, conn *Conn) error {
+		select {
+		case <-time.After(d):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Close closes the connection with code and reason, ending the script
+func Close(code axon.CloseCode, reason string) Step {
+	return func(ctx context.Context, conn *Conn) error {
+		conn.Close(int(code), reason)
+		return axon.ErrConnectionClosed
+	}
+}
+
+// Drop closes the network connection without a close frame, ending the
+// script
+func Drop() Step {
+	return func(ctx context.Context, conn *Conn) error {
+		conn.Drop()
+		return errDropped
+	}
+}
+
+// InjectFaults makes the rest of the connection lose, delay and close
+// messages as f configures; see axon.Conn.InjectFaults. To inject faults
+// from the start of every connection, set Faults in Options.Upgrade.
+func InjectFaults(f axon.Faults) Step {
+	return func(ctx context.Context, conn *Conn) error {
+		conn.Conn.InjectFaults(f)
+		return nil
+	}
+}
@@ -0,0 +1,206 @@
+package wstest_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kolosys/axon"
+	"github.com/kolosys/axon/wstest"
+)
+
+// dial connects to server and returns the connection with a context
+// bounding the test
+func dial(t *testing.T, server *wstest.Server) (*axon.Conn[[]byte], context.Context) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	t.Cleanup(cancel)
+	conn, err := axon.Dial[[]byte](ctx, server.URL, nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { conn.Close(1000, "") })
+	return conn, ctx
+}
+
+// readText reads a text message
+func readText(t *testing.T, ctx context.Context, conn *axon.Conn[[]byte]) string {
+	t.Helper()
+	_, data, err := conn.ReadMessage(ctx)
+	if err != nil {
+		t.Fatalf("ReadMessage() error = %v", err)
+	}
+	return string(data)
+}
+
+func TestServer_Echo(t *testing.T) {
+	server := wstest.NewServer(nil)
+	defer server.Close()
+
+	conn, ctx := dial(t, server)
+	conn.WriteMessage(ctx, axon.TextMessage, []byte("hello"))
+	if got := readText(t, ctx, conn); got != "hello" {
+		t.Errorf("echo = %q, want hello", got)
+	}
+
+	msgs := server.Messages()
+	if len(msgs) != 1 || msgs[0].Conn != 0 || string(msgs[0].Data) != "hello" {
+		t.Errorf("Messages() = %+v", msgs)
+	}
+}
+
+func TestServer_Script(t *testing.T) {
+	server := wstest.NewServer(&wstest.Options{
+		Script: wstest.Steps(
+			wstest.Send(`{"op":"hello"}`),
+			wstest.Reply("ping", "pong"),
+			wstest.ExpectJSON(map[string]any{"op": "identify", "shard": 1}),
+			wstest.Close(axon.CloseGoingAway, "bye"),
+		),
+	})
+	defer server.Close()
+
+	conn, ctx := dial(t, server)
+	if got := readText(t, ctx, conn); got != `{"op":"hello"}` {
+		t.Errorf("first message = %q", got)
+	}
+	conn.WriteMessage(ctx, axon.TextMessage, []byte("ping"))
+	if got := readText(t, ctx, conn); got != "pong" {
+		t.Errorf("reply = %q, want pong", got)
+	}
+	conn.WriteMessage(ctx, axon.TextMessage, []byte(`{"shard":1,"op":"identify"}`))
+
+	_, _, err := conn.ReadMessage(ctx)
+	if closeErr := axon.AsCloseError(err); closeErr == nil || closeErr.Code != axon.CloseGoingAway {
+		t.Fatalf("ReadMessage() error = %v, want going away", err)
+	}
+	if err := server.Err(); err != nil {
+		t.Errorf("Err() = %v", err)
+	}
+}
+
+func TestServer_ScriptFailure(t *testing.T) {
+	server := wstest.NewServer(&wstest.Options{
+		Script: wstest.Steps(wstest.Expect("ping")),
+	})
+	defer server.Close()
+
+	conn, ctx := dial(t, server)
+	conn.WriteMessage(ctx, axon.TextMessage, []byte("pong"))
+	_, _, err := conn.ReadMessage(ctx)
+	if closeErr := axon.AsCloseError(err); closeErr == nil || closeErr.Code != axon.ClosePolicyViolation {
+		t.Fatalf("ReadMessage() error = %v, want policy violation", err)
+	}
+	if err := server.Err(); !errors.Is(err, wstest.ErrUnexpectedMessage) {
+		t.Errorf("Err() = %v, want ErrUnexpectedMessage", err)
+	}
+}
+
+func TestServer_DropReconnect(t *testing.T) {
+	// Only the first connection is dropped, so the client recovers
+	var dropped atomic.Bool
+	server := wstest.NewServer(&wstest.Options{
+		Script: func(n int) []wstest.Step {
+			if n == 0 {
+				// Client[string] writes JSON strings
+				return []wstest.Step{wstest.Expect(`"one"`), wstest.Drop()}
+			}
+			return nil
+		},
+		Echo: true,
+	})
+	defer server.Close()
+
+	opts := axon.DefaultClientOptions()
+	opts.Reconnect.InitialDelay = 10 * time.Millisecond
+	opts.Reconnect.Jitter = false
+	client := axon.NewClient[string](server.URL, opts)
+	defer client.Close()
+	client.OnDisconnect(func(*axon.Client[string], error) { dropped.Store(true) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	msgs, _ := client.Receive()
+	if err := client.ConnectWithReadLoop(ctx); err != nil {
+		t.Fatalf("ConnectWithReadLoop() error = %v", err)
+	}
+	client.Write(ctx, "one")
+
+	for server.Connections() < 2 || !client.IsConnected() {
+		if ctx.Err() != nil {
+			t.Fatal("timed out waiting for the client to reconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !dropped.Load() {
+		t.Error("expected the client to see the drop")
+	}
+	client.Write(ctx, "two")
+	select {
+	case msg := <-msgs:
+		if msg != "two" {
+			t.Errorf("echo = %q, want two", msg)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for the echo")
+	}
+}
+
+func TestServer_WriteDelay(t *testing.T) {
+	server := wstest.NewServer(&wstest.Options{WriteDelay: 100 * time.Millisecond})
+	defer server.Close()
+
+	conn, ctx := dial(t, server)
+	start := time.Now()
+	conn.WriteMessage(ctx, axon.TextMessage, []byte("slow"))
+	readText(t, ctx, conn)
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("echo took %v, want at least 100ms", elapsed)
+	}
+}
+
+func TestServer_DropAfter(t *testing.T) {
+	server := wstest.NewServer(&wstest.Options{DropAfter: 2})
+	defer server.Close()
+
+	conn, ctx := dial(t, server)
+	conn.WriteMessage(ctx, axon.TextMessage, []byte("one"))
+	if got := readText(t, ctx, conn); got != "one" {
+		t.Errorf("echo = %q, want one", got)
+	}
+	conn.WriteMessage(ctx, axon.TextMessage, []byte("two"))
+	_, _, err := conn.ReadMessage(ctx)
+	if err == nil {
+		t.Fatal("ReadMessage() succeeded after the drop")
+	}
+	if closeErr := axon.AsCloseError(err); closeErr != nil && closeErr.Code != axon.CloseAbnormalClosure {
+		t.Errorf("ReadMessage() error = %v, want no close frame", err)
+	}
+}
+
+func TestInjectFaults(t *testing.T) {
+	// After the greeting the server loses every message it reads
+	server := wstest.NewServer(&wstest.Options{
+		Script: wstest.Steps(
+			wstest.Reply("hello", "welcome"),
+			wstest.InjectFaults(axon.Faults{DropRate: 1}),
+		),
+		Echo: true,
+	})
+	defer server.Close()
+
+	conn, ctx := dial(t, server)
+	conn.WriteMessage(ctx, axon.TextMessage, []byte("hello"))
+	if got := readText(t, ctx, conn); got != "welcome" {
+		t.Fatalf("reply = %q, want welcome", got)
+	}
+	conn.WriteMessage(ctx, axon.TextMessage, []byte("lost"))
+
+	readCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if _, data, err := conn.ReadMessage(readCtx); err == nil {
+		t.Errorf("ReadMessage() = %q, want the message lost", data)
+	}
+}